@@ -0,0 +1,279 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package admin provides an HTTP handler serving an operational interface to
+// a DB, for applications which embed Pebble and want to inspect and tune it
+// without building their own tooling. Handler serves the following endpoints:
+//
+//   - GET /metrics serves the metrics of the DB in the Prometheus text
+//     exposition format (see package promexport), or as formatted by
+//     Metrics.String if the format parameter is "text".
+//   - GET /lsm serves the sstables of each level of the LSM as JSON (see
+//     Level).
+//   - GET /compactions serves the running compactions as JSON (see
+//     Compaction).
+//   - POST /compact compacts the keys in the range [start, end] specified by
+//     the start and end parameters (see DB.Compact), parallelizing the
+//     compaction if the parallelize parameter is true. The request completes
+//     when the compaction does.
+//   - GET /options serves the options of the DB in the format of an OPTIONS
+//     file.
+//   - POST /options changes the options specified as parameters, named as in
+//     an OPTIONS file, and serves the options of the DB. Only
+//     disable_automatic_compactions may be changed.
+//
+// The handler does not authenticate requests, and should only be exposed to
+// trusted clients. Mount it under a prefix with http.StripPrefix.
+package admin // import "github.com/cockroachdb/pebble/admin"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/promexport"
+)
+
+// Table describes an sstable in the LSM.
+type Table struct {
+	FileNum        uint64 `json:"file_num"`
+	Size           uint64 `json:"size"`
+	Smallest       string `json:"smallest"`
+	Largest        string `json:"largest"`
+	SmallestSeqNum uint64 `json:"smallest_seq_num"`
+	LargestSeqNum  uint64 `json:"largest_seq_num"`
+}
+
+// Level describes a level of the LSM. The /lsm endpoint returns a Level for
+// each level, including the empty ones.
+type Level struct {
+	Level  int     `json:"level"`
+	Size   uint64  `json:"size"`
+	Tables []Table `json:"tables"`
+}
+
+// CompactionInput describes the input tables of a compaction from a level.
+type CompactionInput struct {
+	Level    int      `json:"level"`
+	FileNums []uint64 `json:"file_nums"`
+}
+
+// Compaction describes a running compaction (see DB.InProgressCompactions).
+type Compaction struct {
+	JobID       int               `json:"job_id"`
+	Inputs      []CompactionInput `json:"inputs"`
+	OutputLevel int               `json:"output_level"`
+	// DurationSeconds is the time elapsed since the compaction started.
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type handler struct {
+	d    *pebble.DB
+	opts *pebble.Options
+	mux  *http.ServeMux
+}
+
+// Handler returns an http.Handler serving the endpoints described in the
+// package documentation for the DB, which was opened with the specified
+// options. The options are used to format keys and to serve the /options
+// endpoint, and are not modified.
+func Handler(d *pebble.DB, opts *pebble.Options) http.Handler {
+	h := &handler{
+		d:    d,
+		opts: opts.Clone().EnsureDefaults(),
+		mux:  http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/metrics", h.metrics)
+	h.mux.HandleFunc("/lsm", h.lsm)
+	h.mux.HandleFunc("/compactions", h.compactions)
+	h.mux.HandleFunc("/compact", h.compact)
+	h.mux.HandleFunc("/options", h.options)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// allowMethods returns true if the method of the request is one of the
+// specified methods, and replies with an error otherwise.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	for _, m := range methods {
+		w.Header().Add("Allow", m)
+	}
+	http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(buf, '\n'))
+}
+
+func (h *handler) metrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "prometheus":
+		promexport.Handler(h.d).ServeHTTP(w, r)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, h.d.Metrics().String())
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+	}
+}
+
+func (h *handler) formatKey(k pebble.InternalKey) string {
+	return fmt.Sprint(k.Pretty(h.opts.Comparer.FormatKey))
+}
+
+func (h *handler) lsm(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	tables, err := h.d.SSTables()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	levels := make([]Level, len(tables))
+	for i := range tables {
+		levels[i] = Level{Level: i, Tables: make([]Table, len(tables[i]))}
+		for j, t := range tables[i] {
+			levels[i].Size += t.Size
+			levels[i].Tables[j] = Table{
+				FileNum:        uint64(t.FileNum),
+				Size:           t.Size,
+				Smallest:       h.formatKey(t.Smallest),
+				Largest:        h.formatKey(t.Largest),
+				SmallestSeqNum: t.SmallestSeqNum,
+				LargestSeqNum:  t.LargestSeqNum,
+			}
+		}
+	}
+	writeJSON(w, levels)
+}
+
+func (h *handler) compactions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	infos := h.d.InProgressCompactions()
+	compactions := make([]Compaction, len(infos))
+	for i, info := range infos {
+		c := &compactions[i]
+		c.JobID = info.JobID
+		c.OutputLevel = info.Output.Level
+		c.DurationSeconds = info.Duration.Seconds()
+		c.Inputs = make([]CompactionInput, len(info.Input))
+		for j, in := range info.Input {
+			c.Inputs[j] = CompactionInput{Level: in.Level, FileNums: make([]uint64, len(in.Tables))}
+			for k, t := range in.Tables {
+				c.Inputs[j].FileNums[k] = uint64(t.FileNum)
+			}
+		}
+	}
+	writeJSON(w, compactions)
+}
+
+func (h *handler) compact(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := r.Form["start"]; !ok {
+		http.Error(w, "missing start parameter", http.StatusBadRequest)
+		return
+	}
+	if _, ok := r.Form["end"]; !ok {
+		http.Error(w, "missing end parameter", http.StatusBadRequest)
+		return
+	}
+	start, end := []byte(r.Form.Get("start")), []byte(r.Form.Get("end"))
+	if h.opts.Comparer.Compare(start, end) > 0 {
+		http.Error(w, "start is after end", http.StatusBadRequest)
+		return
+	}
+	var parallelize bool
+	if s := r.Form.Get("parallelize"); s != "" {
+		var err error
+		if parallelize, err = strconv.ParseBool(s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid parallelize parameter: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := h.d.Compact(start, end, parallelize); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseOption parses the new value of the option with the specified name, as
+// named in an OPTIONS file, returning a function which changes the option.
+func (h *handler) parseOption(name, value string) (func(), error) {
+	switch name {
+	case "disable_automatic_compactions":
+		disable, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", name)
+		}
+		return func() { h.d.SetDisableAutomaticCompactions(disable) }, nil
+	default:
+		return nil, errors.Errorf("option %s cannot be changed", name)
+	}
+}
+
+func (h *handler) options(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// All of the options are parsed before any is changed, so that an
+		// invalid request does not change any option.
+		var changes []func()
+		for name, values := range r.PostForm {
+			if len(values) != 1 {
+				http.Error(w, fmt.Sprintf("option %s specified %d times", name, len(values)), http.StatusBadRequest)
+				return
+			}
+			change, err := h.parseOption(name, values[0])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			changes = append(changes, change)
+		}
+		for _, change := range changes {
+			change()
+		}
+	}
+
+	// The options which may be changed are reported with their current value.
+	opts := h.opts.Clone()
+	opts.DisableAutomaticCompactions = h.d.AutomaticCompactionsDisabled()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, opts.String())
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	opts := &pebble.Options{FS: vfs.NewMem()}
+	d, err := pebble.Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	h := Handler(d, opts)
+
+	do := func(method, target string, form url.Values) (int, string) {
+		r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		if form != nil {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		body, err := ioutil.ReadAll(w.Body)
+		require.NoError(t, err)
+		return w.Code, string(body)
+	}
+	get := func(target string, v interface{}) {
+		code, body := do("GET", target, nil)
+		require.Equal(t, 200, code, body)
+		require.NoError(t, json.Unmarshal([]byte(body), v))
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+
+	code, body := do("GET", "/metrics", nil)
+	require.Equal(t, 200, code)
+	require.Contains(t, body, "\npebble_flushes_total 1\n")
+	code, body = do("GET", "/metrics?format=text", nil)
+	require.Equal(t, 200, code)
+	require.Contains(t, body, "  flush         1\n")
+	code, _ = do("GET", "/metrics?format=json", nil)
+	require.Equal(t, 400, code)
+
+	var levels []Level
+	get("/lsm", &levels)
+	require.Len(t, levels, 7)
+	require.Len(t, levels[0].Tables, 1)
+	table := levels[0].Tables[0]
+	require.Equal(t, "a#1,SET", table.Smallest)
+	require.Equal(t, "b#2,SET", table.Largest)
+	require.Equal(t, table.Size, levels[0].Size)
+
+	var compactions []Compaction
+	get("/compactions", &compactions)
+	require.Empty(t, compactions)
+
+	// Manual compactions.
+	code, _ = do("GET", "/compact", nil)
+	require.Equal(t, 405, code)
+	code, body = do("POST", "/compact", url.Values{"start": {"a"}})
+	require.Equal(t, 400, code)
+	require.Equal(t, "missing end parameter\n", body)
+	code, body = do("POST", "/compact", url.Values{"start": {"b"}, "end": {"a"}})
+	require.Equal(t, 400, code)
+	require.Equal(t, "start is after end\n", body)
+	code, body = do("POST", "/compact", url.Values{"start": {"a"}, "end": {"b"}})
+	require.Equal(t, 200, code, body)
+	get("/lsm", &levels)
+	require.Empty(t, levels[0].Tables)
+	require.Len(t, levels[6].Tables, 1)
+
+	// Option tweaks.
+	code, body = do("GET", "/options", nil)
+	require.Equal(t, 200, code)
+	require.Contains(t, body, "  disable_automatic_compactions=false\n")
+	code, body = do("POST", "/options", url.Values{"disable_automatic_compactions": {"true"}})
+	require.Equal(t, 200, code, body)
+	require.Contains(t, body, "  disable_automatic_compactions=true\n")
+	require.True(t, d.AutomaticCompactionsDisabled())
+
+	// An invalid request does not change any option.
+	code, body = do("POST", "/options", url.Values{
+		"disable_automatic_compactions": {"false"},
+		"l0_compaction_threshold":       {"8"},
+	})
+	require.Equal(t, 400, code)
+	require.Equal(t, "option l0_compaction_threshold cannot be changed\n", body)
+	require.True(t, d.AutomaticCompactionsDisabled())
+	code, _ = do("POST", "/options", url.Values{"disable_automatic_compactions": {"maybe"}})
+	require.Equal(t, 400, code)
+	require.True(t, d.AutomaticCompactionsDisabled())
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	// archiveCheckpointDirname is the name of the directory in the DB
+	// directory in which the checkpoint from which an archive is written is
+	// constructed.
+	archiveCheckpointDirname = "archive.tmp"
+	// archiveChecksumRecord is the PAX record of the header of each file of
+	// an archive holding the checksum of the contents of the file.
+	archiveChecksumRecord = "PEBBLE.checksum"
+)
+
+// WriteArchive writes a snapshot of the DB to w as a single archive, from
+// which RestoreArchive restores the DB. The archive is intended for small
+// DBs, such as the in-memory DBs used as test fixtures and caches (see
+// vfs.NewMem), which need to be persisted occasionally.
+//
+// The archive is written from a checkpoint of the DB (see DB.Checkpoint),
+// constructed after flushing the memtables, so it reflects a consistent state
+// of the DB and writes may be performed concurrently with WriteArchive. The
+// archive is in the tar format, and holds the files of the checkpoint along
+// with their checksums, which are verified when the archive is restored.
+//
+// A DB which references sstables ingested by reference (see
+// DB.IngestExternal) cannot be archived, as the sstables reside on the
+// SharedFS of the DB rather than in the DB directory. An error is returned
+// until they have been compacted away.
+//
+// WriteArchive must not be called concurrently with another WriteArchive or
+// SaveArchive of the DB.
+func (d *DB) WriteArchive(w io.Writer) (err error) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+
+	fs := d.opts.FS
+	dir := fs.PathJoin(d.dirname, archiveCheckpointDirname)
+	if err := fs.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := d.Checkpoint(dir, WithFlush(), withoutExternalTables("archives")); err != nil {
+		return err
+	}
+	defer func() {
+		err = firstError(err, fs.RemoveAll(dir))
+	}()
+	ls, err := fs.List(dir)
+	if err != nil {
+		return err
+	}
+	sort.Strings(ls)
+
+	tw := tar.NewWriter(w)
+	for _, name := range ls {
+		if err := writeArchiveFile(tw, fs, fs.PathJoin(dir, name), name); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeArchiveFile writes the file at path of fs to the archive under the
+// specified name.
+func writeArchiveFile(tw *tar.Writer, fs vfs.FS, path, name string) error {
+	// The files of a checkpoint are not modified, so the checksum computed
+	// before the file is written to the archive is that of its contents.
+	size, checksum, err := backupChecksum(fs, path)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       name,
+		Mode:       0644,
+		Size:       size,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{archiveChecksumRecord: fmt.Sprintf("%08x", checksum)},
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// SaveArchive atomically writes a snapshot of the DB to the file at path of
+// fs, as a single archive (see DB.WriteArchive). The archive is written to a
+// temporary file which is synced and renamed, so that an existing archive at
+// path is replaced only once the new archive is complete.
+func (d *DB) SaveArchive(fs vfs.FS, path string) (err error) {
+	tmpPath := path + ".tmp"
+	f, err := fs.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = fs.Remove(tmpPath)
+		}
+	}()
+	if err := d.WriteArchive(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	dir, err := fs.OpenDir(fs.PathDir(path))
+	if err != nil {
+		return err
+	}
+	return firstError(dir.Sync(), dir.Close())
+}
+
+// RestoreArchive restores the DB archived in r (see DB.WriteArchive) into
+// destDir of destFS, which must not exist. The restored DB may then be opened
+// with Open. RestoreArchive fails if the archive is truncated or corrupted,
+// in which case destDir is removed.
+func RestoreArchive(r io.Reader, destFS vfs.FS, destDir string) (err error) {
+	if _, err := destFS.Stat(destDir); !os.IsNotExist(err) {
+		if err == nil {
+			return &os.PathError{
+				Op:   "restore",
+				Path: destDir,
+				Err:  os.ErrExist,
+			}
+		}
+		return err
+	}
+
+	if err := destFS.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			// Attempt to cleanup on error.
+			_ = destFS.RemoveAll(destDir)
+		}
+	}()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "pebble: invalid archive")
+		}
+		if err := restoreArchiveFile(tr, hdr, destFS, destDir); err != nil {
+			return err
+		}
+	}
+	dir, err := destFS.OpenDir(destDir)
+	if err != nil {
+		return err
+	}
+	return firstError(dir.Sync(), dir.Close())
+}
+
+// restoreArchiveFile restores the file of the archive described by hdr into
+// destDir of destFS, verifying its checksum.
+func restoreArchiveFile(tr *tar.Reader, hdr *tar.Header, destFS vfs.FS, destDir string) error {
+	// The files of an archive are the files of a DB directory, so their names
+	// are never paths.
+	if hdr.Typeflag != tar.TypeReg || hdr.Name == "" || hdr.Name == "." ||
+		hdr.Name == ".." || strings.ContainsAny(hdr.Name, `/\`) {
+		return errors.Errorf("pebble: invalid archive: unexpected file %q", hdr.Name)
+	}
+	expected, err := strconv.ParseUint(hdr.PAXRecords[archiveChecksumRecord], 16, 32)
+	if err != nil {
+		return errors.Errorf("pebble: invalid archive: missing checksum of %s", hdr.Name)
+	}
+
+	f, err := destFS.Create(destFS.PathJoin(destDir, hdr.Name))
+	if err != nil {
+		return err
+	}
+	var w checksumWriter
+	if _, err := io.Copy(io.MultiWriter(f, &w), tr); err != nil {
+		f.Close()
+		return errors.Wrap(err, "pebble: invalid archive")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if checksum := w.crc.Value(); checksum != uint32(expected) {
+		return errors.Errorf("pebble: invalid archive: checksum mismatch for %s: "+
+			"expected %08x, found %08x", hdr.Name, uint32(expected), checksum)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+
+	// The archive is saved to a file of another FS, replacing the archive
+	// saved earlier.
+	fs := vfs.NewMem()
+	require.NoError(t, fs.MkdirAll("archives", 0755))
+	require.NoError(t, d.SaveArchive(fs, "archives/db.tar"))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, d.SaveArchive(fs, "archives/db.tar"))
+	ls, err := fs.List("archives")
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.tar"}, ls)
+
+	// The scratch checkpoint is removed from the DB directory.
+	_, err = d.opts.FS.Stat(archiveCheckpointDirname)
+	require.True(t, os.IsNotExist(err), "%v", err)
+
+	contents := func(fs vfs.FS, dir string) string {
+		d, err := Open(dir, &Options{FS: fs})
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		require.NoError(t, d.Close())
+		return buf.String()
+	}
+
+	f, err := fs.Open("archives/db.tar")
+	require.NoError(t, err)
+	archive, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	restoreFS := vfs.NewMem()
+	require.NoError(t, RestoreArchive(bytes.NewReader(archive), restoreFS, "restored"))
+	require.Equal(t, "b:2 c:3 ", contents(restoreFS, "restored"))
+
+	// The destination directory must not exist.
+	err = RestoreArchive(bytes.NewReader(archive), restoreFS, "restored")
+	require.True(t, os.IsExist(err), "%v", err)
+
+	// Truncated and corrupted archives are not restored.
+	restore := func(archive []byte) error {
+		err := RestoreArchive(bytes.NewReader(archive), restoreFS, "corrupt")
+		if err != nil {
+			_, statErr := restoreFS.Stat("corrupt")
+			require.True(t, os.IsNotExist(statErr), "%v", statErr)
+		}
+		return err
+	}
+	require.Error(t, restore(archive[:len(archive)/2]))
+	var corrupt bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(archive))
+	tw := tar.NewWriter(&corrupt)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Name == "MANIFEST-000001" {
+			data[len(data)-1] ^= 0xff
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.Regexp(t, "checksum mismatch for MANIFEST-000001", restore(corrupt.Bytes()))
+
+	// The files of an archive are restored into the destination directory.
+	var escape bytes.Buffer
+	tw = tar.NewWriter(&escape)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       "../CURRENT",
+		Size:       1,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{archiveChecksumRecord: "00000000"},
+	}))
+	_, err = tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.Regexp(t, `unexpected file "../CURRENT"`, restore(escape.Bytes()))
+}
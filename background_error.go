@@ -0,0 +1,213 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+)
+
+// errWriteFailed marks the errors encountered by flushes and compactions
+// while writing their outputs, as opposed to reading their inputs. Only the
+// former put the DB into the background error state.
+var errWriteFailed = errors.New("pebble: write failed")
+
+func markWriteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return errors.Mark(err, errWriteFailed)
+}
+
+// walWriteFailed records an error writing batch b to the WAL. The batch is
+// still applied to the memtable and published, as the commit pipeline
+// requires, but the error is returned by the commit.
+func walWriteFailed(b *Batch, syncWG *sync.WaitGroup, err error) {
+	b.commitErr = err
+	if syncWG != nil {
+		syncWG.Done()
+	}
+}
+
+// Resume attempts to leave the background error state. The DB enters the
+// background error state when a flush, a compaction or a write to the WAL
+// fails writing to the filesystem, such as when the disk is full. While in
+// the state, reads are served as usual, but writes and ingestions fail with
+// an error marked as ErrBackgroundError (see errors.Is). Writes which fail
+// because of a WAL error have nonetheless been applied, and may be visible
+// to reads. The EventListener.BackgroundErrorStateBegin and
+// BackgroundErrorStateEnd events are invoked when the state is entered and
+// left.
+//
+// The DB leaves the state automatically once a subsequent flush or
+// compaction succeeds and the memtables which used a failed WAL, if any,
+// have been flushed, as failed flushes and compactions are retried. Resume
+// flushes the memtables, switching to a new WAL, and waits for the flush to
+// complete. It returns nil if the DB is not, or is no longer, in the
+// background error state, and the background error otherwise.
+//
+// A keyspace shares the WAL of its parent, and enters the background error
+// state along with the parent when a write to the WAL fails. Resume on a
+// keyspace first resumes the parent, and then flushes the memtables of the
+// keyspace.
+func (d *DB) Resume() error {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if atomic.LoadInt32(&d.bgErrState) == 0 {
+		return nil
+	}
+
+	// The memtables of a keyspace are switched while holding the parent's
+	// commitPipeline.mu. See keyspace.commitWrite.
+	commitMu := &d.commit.mu
+	if d.keyspace != nil {
+		if err := d.keyspace.parent.Resume(); err != nil {
+			return err
+		}
+		commitMu = &d.keyspace.parent.commit.mu
+	}
+
+	commitMu.Lock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	flushed := d.mu.mem.queue[len(d.mu.mem.queue)-1].flushed
+	flushErrs := d.mu.bgErr.flushErrs
+	err := d.makeRoomForWrite(nil)
+	commitMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Wait for the flush to complete or fail. A failed flush is retried, but
+	// Resume returns rather than waiting for the retries.
+	for {
+		select {
+		case <-flushed:
+			return d.backgroundErrorLocked()
+		default:
+		}
+		if d.mu.bgErr.flushErrs != flushErrs {
+			return d.backgroundErrorLocked()
+		}
+		d.mu.compact.cond.Wait()
+	}
+}
+
+// backgroundError returns the error with which writes fail while the DB is
+// in the background error state, or nil if it is not.
+func (d *DB) backgroundError() error {
+	if atomic.LoadInt32(&d.bgErrState) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.backgroundErrorLocked()
+}
+
+func (d *DB) backgroundErrorLocked() error {
+	if d.mu.bgErr.err == nil {
+		return nil
+	}
+	return errors.Mark(errors.Wrap(d.mu.bgErr.err, "pebble: background error"), ErrBackgroundError)
+}
+
+// setBackgroundErrorLocked enters the background error state because of err,
+// if the DB is not already in it. If walLogNum is non-zero, err was
+// encountered writing to that log, and the state is not left until the
+// memtables of the log have been flushed.
+//
+// d.mu must be held when calling this.
+func (d *DB) setBackgroundErrorLocked(err error, walLogNum FileNum) {
+	if walLogNum > d.mu.bgErr.walLogNum {
+		d.mu.bgErr.walLogNum = walLogNum
+	}
+	if d.mu.bgErr.err != nil {
+		return
+	}
+	d.mu.bgErr.err = err
+	atomic.StoreInt32(&d.bgErrState, 1)
+	d.opts.EventListener.BackgroundErrorStateBegin(BackgroundErrorStateInfo{Err: err})
+}
+
+// maybeClearBackgroundErrorLocked leaves the background error state, if the
+// DB is in it and the memtables of any log which encountered an error have
+// been flushed. It is called after a flush or compaction succeeds.
+//
+// d.mu must be held when calling this.
+func (d *DB) maybeClearBackgroundErrorLocked() {
+	if d.mu.bgErr.err == nil || d.mu.versions.minUnflushedLogNum <= d.mu.bgErr.walLogNum {
+		return
+	}
+	info := BackgroundErrorStateInfo{Err: d.mu.bgErr.err}
+	d.mu.bgErr.err = nil
+	d.mu.bgErr.walLogNum = 0
+	atomic.StoreInt32(&d.bgErrState, 0)
+	d.opts.EventListener.BackgroundErrorStateEnd(info)
+}
+
+// handleWALError enters the background error state after a batch could not
+// be written or synced to the WAL, and switches to a new WAL, queueing the
+// memtables of the failed log to be flushed.
+func (d *DB) handleWALError(err error) {
+	if d.keyspace != nil {
+		d.keyspace.handleWALError(err)
+		return
+	}
+
+	d.commit.mu.Lock()
+	defer d.commit.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if atomic.LoadInt32(&d.closed) != 0 {
+		return
+	}
+	logNum := FileNum(atomic.LoadUint64(&d.mu.log.num))
+	if d.mu.bgErr.walLogNum != 0 && logNum > d.mu.bgErr.walLogNum {
+		// The WAL has already been switched since an earlier error.
+		return
+	}
+	d.setBackgroundErrorLocked(err, logNum)
+	if err := d.makeRoomForWrite(nil); err != nil {
+		// The DB remains in the background error state, and Resume retries
+		// the switch to a new WAL.
+		d.opts.Logger.Infof("pebble: switching WAL after error: %v", err)
+	}
+}
+
+// handleWALError enters the background error state of both the keyspace and
+// its parent after a batch committed to the keyspace could not be written or
+// synced to the parent's WAL. The parent switches to a new WAL, and the
+// keyspace to a new memtable, queueing the memtables of the failed log to be
+// flushed. Each leaves the state once its own memtables of the failed log
+// have been flushed.
+func (k *keyspace) handleWALError(err error) {
+	p, d := k.parent, k.db
+	// The log the batch was written to may already have been switched, in
+	// which case logNum is a later log. Waiting for the memtables of the later
+	// log to be flushed as well is merely conservative.
+	p.commit.mu.Lock()
+	logNum := p.currentLogNum()
+	p.commit.mu.Unlock()
+	p.handleWALError(err)
+
+	p.commit.mu.Lock()
+	defer p.commit.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if atomic.LoadInt32(&d.closed) != 0 {
+		return
+	}
+	d.setBackgroundErrorLocked(err, logNum)
+	if d.mu.mem.queue[len(d.mu.mem.queue)-1].logNum > logNum {
+		// The keyspace has already switched to a memtable of a later log.
+		return
+	}
+	if err := d.makeRoomForWrite(nil); err != nil {
+		d.opts.Logger.Infof("pebble: keyspace %q: switching memtable after error: %v", k.name, err)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+var errTestWrite = errors.New("test write error")
+
+// writeErrorFS fails writes to the files of a type while failing is set.
+type writeErrorFS struct {
+	vfs.FS
+	fileType fileType
+	failing  int32 // updated atomically
+}
+
+func (fs *writeErrorFS) setFailing(failing bool) {
+	var v int32
+	if failing {
+		v = 1
+	}
+	atomic.StoreInt32(&fs.failing, v)
+}
+
+func (fs *writeErrorFS) wrap(name string, f vfs.File) vfs.File {
+	if ft, _, ok := base.ParseFilename(fs, fs.PathBase(name)); ok && ft == fs.fileType {
+		return writeErrorFile{f, fs}
+	}
+	return f
+}
+
+func (fs *writeErrorFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrap(name, f), nil
+}
+
+func (fs *writeErrorFS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrap(newname, f), nil
+}
+
+type writeErrorFile struct {
+	vfs.File
+	fs *writeErrorFS
+}
+
+func (f writeErrorFile) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&f.fs.failing) != 0 {
+		return 0, errTestWrite
+	}
+	return f.File.Write(p)
+}
+
+func (f writeErrorFile) Sync() error {
+	if atomic.LoadInt32(&f.fs.failing) != 0 {
+		return errTestWrite
+	}
+	return f.File.Sync()
+}
+
+func openBackgroundErrorTestDB(
+	t *testing.T, fs vfs.FS,
+) (d *DB, begin, end chan BackgroundErrorStateInfo) {
+	begin = make(chan BackgroundErrorStateInfo, 1)
+	end = make(chan BackgroundErrorStateInfo, 1)
+	d, err := Open("", &Options{
+		FS:                 fs,
+		FormatMajorVersion: FormatNewest,
+		EventListener: EventListener{
+			BackgroundErrorStateBegin: func(info BackgroundErrorStateInfo) { begin <- info },
+			BackgroundErrorStateEnd:   func(info BackgroundErrorStateInfo) { end <- info },
+		},
+	})
+	require.NoError(t, err)
+	return d, begin, end
+}
+
+func TestBackgroundErrorFlush(t *testing.T) {
+	fs := &writeErrorFS{FS: vfs.NewMem(), fileType: fileTypeTable}
+	d, begin, end := openBackgroundErrorTestDB(t, fs)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Resume())
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+
+	fs.setFailing(true)
+	_, err := d.AsyncFlush()
+	require.NoError(t, err)
+	info := <-begin
+	require.True(t, errors.Is(info.Err, errTestWrite), "%v", info.Err)
+
+	// Writes are rejected while reads are served.
+	err = d.Set([]byte("b"), []byte("2"), nil)
+	require.True(t, errors.Is(err, ErrBackgroundError), "%v", err)
+	require.True(t, errors.Is(err, errTestWrite), "%v", err)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+
+	// Resume fails while the flushes fail.
+	err = d.Resume()
+	require.True(t, errors.Is(err, ErrBackgroundError), "%v", err)
+
+	fs.setFailing(false)
+	require.NoError(t, d.Resume())
+	<-end
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+}
+
+func TestBackgroundErrorWAL(t *testing.T) {
+	mem := vfs.NewMem()
+	fs := &writeErrorFS{FS: mem, fileType: fileTypeLog}
+	d, begin, end := openBackgroundErrorTestDB(t, fs)
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), Sync))
+	fs.setFailing(true)
+	err := d.Set([]byte("b"), []byte("2"), Sync)
+	require.True(t, errors.Is(err, errTestWrite), "%v", err)
+	<-begin
+
+	// The DB switched to a new WAL and flushed the memtable of the failed WAL,
+	// leaving the background error state automatically. The failed write was
+	// applied, and is durable once flushed.
+	fs.setFailing(false)
+	<-end
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), Sync))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b", "c"} {
+		_, closer, err := d.Get([]byte(k))
+		require.NoError(t, err, k)
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
+func TestBackgroundErrorKeyspaceWAL(t *testing.T) {
+	mem := vfs.NewMem()
+	fs := &writeErrorFS{FS: mem, fileType: fileTypeLog}
+	d, begin, end := openBackgroundErrorTestDB(t, fs)
+	ksBegin := make(chan BackgroundErrorStateInfo, 1)
+	ksEnd := make(chan BackgroundErrorStateInfo, 1)
+	ks, err := d.CreateKeyspace("ks", &Options{
+		EventListener: EventListener{
+			BackgroundErrorStateBegin: func(info BackgroundErrorStateInfo) { ksBegin <- info },
+			BackgroundErrorStateEnd:   func(info BackgroundErrorStateInfo) { ksEnd <- info },
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, ks.Set([]byte("a"), []byte("1"), Sync))
+	fs.setFailing(true)
+	err = ks.Set([]byte("b"), []byte("2"), Sync)
+	require.True(t, errors.Is(err, errTestWrite), "%v", err)
+
+	// Both the keyspace and its parent enter the background error state, as
+	// they share the failed WAL.
+	<-ksBegin
+	<-begin
+	err = d.Set([]byte("x"), []byte("1"), nil)
+	require.True(t, errors.Is(err, ErrBackgroundError), "%v", err)
+
+	// Both leave the state once their memtables of the failed WAL have been
+	// flushed.
+	fs.setFailing(false)
+	require.NoError(t, ks.Resume())
+	<-ksEnd
+	<-end
+	require.NoError(t, ks.Set([]byte("c"), []byte("3"), Sync))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", &Options{FS: mem, Keyspaces: map[string]*Options{"ks": {}}})
+	require.NoError(t, err)
+	ks, err = d.Keyspace("ks")
+	require.NoError(t, err)
+	require.Equal(t, "a=1 b=2 c=3", keyspaceContents(t, ks))
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,471 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	// backupMetaFilename is the name of the file in the directory of a backup
+	// describing the files of the backup. A backup is complete once the file
+	// has been written.
+	backupMetaFilename = "BACKUP"
+	// backupSharedDirname is the name of the directory in a backup directory
+	// holding the sstables shared by the backups.
+	backupSharedDirname = "shared"
+	// backupCheckpointDirname is the name of the directory in the DB directory
+	// in which the checkpoint from which a backup is copied is constructed.
+	backupCheckpointDirname = "backup.tmp"
+)
+
+// BackupInfo describes a backup of a DB. See DB.Backup.
+type BackupInfo struct {
+	// ID identifies the backup within its backup directory. Backups are
+	// assigned increasing IDs.
+	ID uint64
+	// Files describes the files of the backup, which are restored into the DB
+	// directory by RestoreBackup.
+	Files []BackupFile
+}
+
+// BackupFile describes a file of a backup.
+type BackupFile struct {
+	// Name is the name of the file in the DB directory.
+	Name string
+	// Path is the path of the file relative to the backup directory.
+	Path string
+	// Size is the size of the file in bytes.
+	Size int64
+	// Checksum is the checksum of the contents of the file, which is verified
+	// when the file is restored.
+	Checksum uint32
+	// Copied is true if the file was copied to the backup directory by the
+	// backup, and false if it was shared with an earlier backup. It is only
+	// set in the BackupInfo returned by DB.Backup.
+	Copied bool
+}
+
+// Backup creates an incremental backup of the DB in the specified backup
+// directory of fs, which may hold earlier backups of the DB, returning a
+// description of the new backup.
+//
+// The backup is copied from a checkpoint of the DB (see DB.Checkpoint),
+// constructed after flushing the memtables in a scratch directory within the
+// DB directory, so that writes need not be stopped while the backup is
+// copied. The sstables of the DB are immutable, and are shared by the backups
+// which contain them: only the sstables which are not already present in the
+// backup directory are copied. The other files of the DB (e.g. the MANIFEST
+// and WAL) are copied into a directory of their own for each backup. Finally,
+// the names, sizes and checksums of the backup's files are recorded in the
+// backup's BACKUP file, which completes the backup.
+//
+// A DB which references sstables ingested by reference (see
+// DB.IngestExternal) cannot be backed up, as the sstables reside on the
+// SharedFS of the DB rather than in the DB directory. An error is returned
+// until they have been compacted away.
+//
+// Backup must not be called concurrently with another Backup of the DB, nor
+// with DeleteBackup on the same backup directory.
+func (d *DB) Backup(fs vfs.FS, backupDir string) (_ BackupInfo, err error) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+
+	// The checkpoint is constructed within the DB directory so that its
+	// sstables are hard linked rather than copied. A checkpoint left behind by
+	// an earlier Backup which failed is removed first.
+	srcFS := d.opts.FS
+	srcDir := srcFS.PathJoin(d.dirname, backupCheckpointDirname)
+	if err := srcFS.RemoveAll(srcDir); err != nil {
+		return BackupInfo{}, err
+	}
+	if err := d.Checkpoint(srcDir, WithFlush(), withoutExternalTables("backups")); err != nil {
+		return BackupInfo{}, err
+	}
+	defer func() {
+		err = firstError(err, srcFS.RemoveAll(srcDir))
+	}()
+	ls, err := srcFS.List(srcDir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	sort.Strings(ls)
+
+	ids, err := listBackupIDs(fs, backupDir)
+	if err != nil && !os.IsNotExist(err) {
+		return BackupInfo{}, err
+	}
+	info := BackupInfo{ID: 1}
+	if n := len(ids); n > 0 {
+		info.ID = ids[n-1] + 1
+	}
+	dirname := backupDirname(info.ID)
+	dir := fs.PathJoin(backupDir, dirname)
+	if err := fs.MkdirAll(fs.PathJoin(backupDir, backupSharedDirname), 0755); err != nil {
+		return BackupInfo{}, err
+	}
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return BackupInfo{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = fs.RemoveAll(dir)
+		}
+	}()
+
+	for _, name := range ls {
+		srcPath := srcFS.PathJoin(srcDir, name)
+		f := BackupFile{Name: name}
+		fileType, fileNum, ok := base.ParseFilename(srcFS, name)
+		if !ok || fileType != fileTypeTable {
+			f.Path = fs.PathJoin(dirname, name)
+			f.Size, f.Checksum, err = backupCopy(srcFS, srcPath, fs, fs.PathJoin(backupDir, f.Path))
+			if err != nil {
+				return BackupInfo{}, err
+			}
+			f.Copied = true
+			info.Files = append(info.Files, f)
+			continue
+		}
+
+		// The name of a shared sstable includes its checksum and size, so that
+		// distinct sstables with the same file number, such as those of a DB
+		// restored from a backup which has since diverged from the backed up
+		// DB, are not confused.
+		if f.Size, f.Checksum, err = backupChecksum(srcFS, srcPath); err != nil {
+			return BackupInfo{}, err
+		}
+		f.Path = fs.PathJoin(backupSharedDirname,
+			fmt.Sprintf("%s-%08x-%d.sst", fileNum, f.Checksum, f.Size))
+		sharedPath := fs.PathJoin(backupDir, f.Path)
+		if _, err := fs.Stat(sharedPath); err == nil {
+			info.Files = append(info.Files, f)
+			continue
+		} else if !os.IsNotExist(err) {
+			return BackupInfo{}, err
+		}
+		size, checksum, err := backupCopy(srcFS, srcPath, fs, sharedPath)
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		if size != f.Size || checksum != f.Checksum {
+			_ = fs.Remove(sharedPath)
+			return BackupInfo{}, errors.Errorf("pebble: %s changed while being backed up", name)
+		}
+		f.Copied = true
+		info.Files = append(info.Files, f)
+	}
+
+	if err := writeBackupMeta(fs, dir, info.Files); err != nil {
+		return BackupInfo{}, err
+	}
+	return info, nil
+}
+
+// ListBackups returns the complete backups in the specified backup directory
+// of fs, ordered by ID.
+func ListBackups(fs vfs.FS, backupDir string) ([]BackupInfo, error) {
+	ids, err := listBackupIDs(fs, backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []BackupInfo
+	for _, id := range ids {
+		info, err := readBackupMeta(fs, backupDir, id)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The backup is incomplete.
+				continue
+			}
+			return nil, err
+		}
+		backups = append(backups, info)
+	}
+	return backups, nil
+}
+
+// RestoreBackup restores the specified backup in backupDir of fs to destDir of
+// destFS, from which a DB can then be opened. The size and checksum of each
+// restored file are verified. The destination directory must not exist.
+func RestoreBackup(fs vfs.FS, backupDir string, id uint64, destFS vfs.FS, destDir string) (err error) {
+	info, err := readBackupMeta(fs, backupDir, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("pebble: backup %d not found", errors.Safe(id))
+		}
+		return err
+	}
+	if _, err := destFS.Stat(destDir); !os.IsNotExist(err) {
+		if err == nil {
+			return &os.PathError{
+				Op:   "restore",
+				Path: destDir,
+				Err:  os.ErrExist,
+			}
+		}
+		return err
+	}
+
+	if err := destFS.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			// Attempt to cleanup on error.
+			_ = destFS.RemoveAll(destDir)
+		}
+	}()
+	for _, f := range info.Files {
+		size, checksum, err := backupCopy(
+			fs, fs.PathJoin(backupDir, f.Path), destFS, destFS.PathJoin(destDir, f.Name))
+		if err != nil {
+			return err
+		}
+		if size != f.Size || checksum != f.Checksum {
+			return errors.Errorf("pebble: backup %d: checksum mismatch for %s: "+
+				"expected %08x (%d bytes), found %08x (%d bytes)",
+				errors.Safe(id), f.Path, f.Checksum, f.Size, checksum, size)
+		}
+	}
+	dir, err := destFS.OpenDir(destDir)
+	if err != nil {
+		return err
+	}
+	return firstError(dir.Sync(), dir.Close())
+}
+
+// DeleteBackup deletes the specified backup in backupDir of fs, along with the
+// shared sstables which are not contained in any other complete backup.
+//
+// DeleteBackup must not be called concurrently with DB.Backup on the same
+// backup directory.
+func DeleteBackup(fs vfs.FS, backupDir string, id uint64) error {
+	dir := fs.PathJoin(backupDir, backupDirname(id))
+	metaPath := fs.PathJoin(dir, backupMetaFilename)
+	if _, err := fs.Stat(metaPath); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("pebble: backup %d not found", errors.Safe(id))
+		}
+		return err
+	}
+	// The BACKUP file is removed first so that the backup is no longer
+	// considered complete if the deletion is interrupted.
+	if err := fs.Remove(metaPath); err != nil {
+		return err
+	}
+	if err := fs.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	backups, err := ListBackups(fs, backupDir)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]struct{})
+	for _, info := range backups {
+		for _, f := range info.Files {
+			live[f.Path] = struct{}{}
+		}
+	}
+	sharedDir := fs.PathJoin(backupDir, backupSharedDirname)
+	ls, err := fs.List(sharedDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range ls {
+		if _, ok := live[fs.PathJoin(backupSharedDirname, name)]; ok {
+			continue
+		}
+		if err := fs.Remove(fs.PathJoin(sharedDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func backupDirname(id uint64) string {
+	return fmt.Sprintf("%06d", id)
+}
+
+// listBackupIDs returns the IDs of the backups in backupDir, including those of
+// incomplete backups, in increasing order.
+func listBackupIDs(fs vfs.FS, backupDir string) ([]uint64, error) {
+	ls, err := fs.List(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, name := range ls {
+		if id, err := strconv.ParseUint(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+// writeBackupMeta durably writes the BACKUP file of the backup in dir,
+// recording the specified files.
+func writeBackupMeta(fs vfs.FS, dir string, files []BackupFile) error {
+	var buf bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&buf, "%s %s %d %08x\n", f.Name, f.Path, f.Size, f.Checksum)
+	}
+
+	// The file is written atomically by renaming a temporary file.
+	filename := fs.PathJoin(dir, backupMetaFilename)
+	tmpFilename := filename + ".tmp"
+	f, err := fs.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpFilename, filename); err != nil {
+		return err
+	}
+	d, err := fs.OpenDir(dir)
+	if err != nil {
+		return err
+	}
+	return firstError(d.Sync(), d.Close())
+}
+
+// readBackupMeta reads the BACKUP file of the specified backup.
+func readBackupMeta(fs vfs.FS, backupDir string, id uint64) (BackupInfo, error) {
+	f, err := fs.Open(fs.PathJoin(backupDir, backupDirname(id), backupMetaFilename))
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer f.Close()
+
+	info := BackupInfo{ID: id}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return BackupInfo{}, errors.Errorf("pebble: backup %d: corrupt %s: %q",
+				errors.Safe(id), backupMetaFilename, line)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return BackupInfo{}, errors.Errorf("pebble: backup %d: corrupt %s: %q",
+				errors.Safe(id), backupMetaFilename, line)
+		}
+		checksum, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return BackupInfo{}, errors.Errorf("pebble: backup %d: corrupt %s: %q",
+				errors.Safe(id), backupMetaFilename, line)
+		}
+		info.Files = append(info.Files, BackupFile{
+			Name:     fields[0],
+			Path:     fields[1],
+			Size:     size,
+			Checksum: uint32(checksum),
+		})
+	}
+	if err := s.Err(); err != nil {
+		return BackupInfo{}, err
+	}
+	return info, nil
+}
+
+// checksumWriter computes the size and checksum of the data written to it.
+type checksumWriter struct {
+	size int64
+	crc  crc.CRC
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	w.crc = w.crc.Update(p)
+	return len(p), nil
+}
+
+// backupChecksum returns the size and checksum of the specified file.
+func backupChecksum(fs vfs.FS, path string) (int64, uint32, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var w checksumWriter
+	if _, err := io.Copy(&w, f); err != nil {
+		return 0, 0, err
+	}
+	return w.size, w.crc.Value(), nil
+}
+
+// backupCopy copies srcPath of srcFS to dstPath of dstFS, returning the size
+// and checksum of the copied data. The data is copied to a temporary file
+// which is synced and renamed, so that a partial copy is never observed at
+// dstPath.
+func backupCopy(
+	srcFS vfs.FS, srcPath string, dstFS vfs.FS, dstPath string,
+) (size int64, checksum uint32, err error) {
+	src, err := srcFS.Open(srcPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	tmpPath := dstPath + ".tmp"
+	dst, err := dstFS.Create(tmpPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = dstFS.Remove(tmpPath)
+		}
+	}()
+
+	var w checksumWriter
+	if _, err := io.Copy(io.MultiWriter(dst, &w), src); err != nil {
+		dst.Close()
+		return 0, 0, err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return 0, 0, err
+	}
+	if err := dst.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := dstFS.Rename(tmpPath, dstPath); err != nil {
+		return 0, 0, err
+	}
+	return w.size, w.crc.Value(), nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	mem := vfs.NewMem()
+	backupFS := vfs.NewMem()
+	d, err := Open("db", &Options{FS: mem})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	contents := func(fs vfs.FS, dir string) string {
+		d, err := Open(dir, &Options{FS: fs, ReadOnly: true})
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, d.Close())
+		}()
+		iter := d.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(parts, " ")
+	}
+	copied := func(info BackupInfo) string {
+		var names []string
+		for _, f := range info.Files {
+			if f.Copied && strings.HasSuffix(f.Name, ".sst") {
+				names = append(names, f.Name)
+			}
+		}
+		sort.Strings(names)
+		return strings.Join(names, " ")
+	}
+
+	// The first backup copies all of the sstables, including the one created
+	// by flushing the memtable.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	info1, err := d.Backup(backupFS, "backup")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), info1.ID)
+	require.Equal(t, "000005.sst 000007.sst", copied(info1))
+
+	// The second backup copies only the sstables which are new.
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	info2, err := d.Backup(backupFS, "backup")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), info2.ID)
+	require.Equal(t, "000009.sst", copied(info2))
+
+	// After a compaction, the third backup shares none of the sstables of
+	// the first backup.
+	require.NoError(t, d.Set([]byte("a"), []byte("4"), nil))
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+	info3, err := d.Backup(backupFS, "backup")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), info3.ID)
+	require.Equal(t, "000012.sst", copied(info3))
+
+	// The scratch checkpoint is removed.
+	_, err = mem.Stat(mem.PathJoin("db", backupCheckpointDirname))
+	require.Error(t, err)
+
+	backups, err := ListBackups(backupFS, "backup")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(backups))
+	for i, info := range []BackupInfo{info1, info2, info3} {
+		require.Equal(t, info.ID, backups[i].ID)
+		require.Equal(t, len(info.Files), len(backups[i].Files))
+		for j := range info.Files {
+			f := info.Files[j]
+			f.Copied = false
+			require.Equal(t, f, backups[i].Files[j])
+		}
+	}
+
+	require.NoError(t, RestoreBackup(backupFS, "backup", 1, mem, "restore1"))
+	require.Equal(t, "a=1 b=2", contents(mem, "restore1"))
+	require.NoError(t, RestoreBackup(backupFS, "backup", 2, mem, "restore2"))
+	require.Equal(t, "a=1 b=2 c=3", contents(mem, "restore2"))
+	require.NoError(t, RestoreBackup(backupFS, "backup", 3, mem, "restore3"))
+	require.Equal(t, "a=4 c=3", contents(mem, "restore3"))
+	err = RestoreBackup(backupFS, "backup", 3, mem, "restore3")
+	require.EqualError(t, err, "restore restore3: file already exists")
+	err = RestoreBackup(backupFS, "backup", 4, mem, "restore4")
+	require.EqualError(t, err, "pebble: backup 4 not found")
+
+	// Deleting a backup deletes the sstables which are not contained in any
+	// other backup.
+	sharedDir := backupFS.PathJoin("backup", backupSharedDirname)
+	listShared := func() string {
+		ls, err := backupFS.List(sharedDir)
+		require.NoError(t, err)
+		sort.Strings(ls)
+		for i := range ls {
+			ls[i] = ls[i][:strings.Index(ls[i], "-")]
+		}
+		return strings.Join(ls, " ")
+	}
+	require.Equal(t, "000005 000007 000009 000012", listShared())
+	require.NoError(t, DeleteBackup(backupFS, "backup", 1))
+	require.Equal(t, "000005 000007 000009 000012", listShared())
+	require.NoError(t, DeleteBackup(backupFS, "backup", 2))
+	require.Equal(t, "000009 000012", listShared())
+	require.EqualError(t, DeleteBackup(backupFS, "backup", 2), "pebble: backup 2 not found")
+	backups, err = ListBackups(backupFS, "backup")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(backups))
+	require.Equal(t, uint64(3), backups[0].ID)
+	require.NoError(t, RestoreBackup(backupFS, "backup", 3, mem, "restore5"))
+	require.Equal(t, "a=4 c=3", contents(mem, "restore5"))
+
+	// A corrupted file is detected when it is restored.
+	shared, err := backupFS.List(sharedDir)
+	require.NoError(t, err)
+	sharedPath := backupFS.PathJoin(sharedDir, shared[0])
+	f, err := backupFS.Open(sharedPath)
+	require.NoError(t, err)
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	data := make([]byte, stat.Size())
+	_, err = f.ReadAt(data, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[0] ^= 0xff
+	f, err = backupFS.Create(sharedPath)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	err = RestoreBackup(backupFS, "backup", 3, mem, "restore6")
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "checksum mismatch"), err.Error())
+	_, err = mem.Stat("restore6")
+	require.Error(t, err)
+}
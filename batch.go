@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -20,6 +21,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/humanize"
 	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/internal/rangedel"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/internal/rawalloc"
 )
 
@@ -159,9 +161,16 @@ func (d DeferredBatchOp) Finish() {
 //   InternalKeyKindSet          varstring varstring
 //   InternalKeyKindMerge        varstring varstring
 //   InternalKeyKindRangeDelete  varstring varstring
+//   InternalKeyKindIngestSST    varstring
+//   InternalKeyKindRangeKeySet    varstring varstring
+//   InternalKeyKindRangeKeyUnset  varstring varstring
+//   InternalKeyKindRangeKeyDelete varstring varstring
+//   InternalKeyKindKeyspaceBatch  varstring varstring
 //
 // The intuitive understanding here are that the arguments to Delete(), Set(),
-// Merge(), and DeleteRange() are encoded into the batch.
+// Merge(), and DeleteRange() are encoded into the batch. The key of a range
+// key record is the start key of the range, and the value encodes the end
+// key, suffix and value of the range key (see rangekey.EncodeValue).
 //
 // The internal batch representation is the on disk format for a batch in the
 // WAL, and thus stable. New record kinds may be added, but the existing ones
@@ -200,6 +209,10 @@ type Batch struct {
 	// deletion is added.
 	countRangeDels uint64
 
+	// The count of range keys in the batch. Updated every time a range key is
+	// added.
+	countRangeKeys uint64
+
 	// A deferredOp struct, stored in the Batch so that a pointer can be returned
 	// from the *Deferred() methods rather than a value.
 	deferredOp DeferredBatchOp
@@ -207,12 +220,19 @@ type Batch struct {
 	// An optional skiplist keyed by offset into data of the entry.
 	index         *batchskl.Skiplist
 	rangeDelIndex *batchskl.Skiplist
+	rangeKeyIndex *batchskl.Skiplist
 
 	// Fragmented range deletion tombstones. Cached the first time a range
 	// deletion iterator is requested. The cache is invalidated whenever a new
 	// range deletion is added to the batch.
 	tombstones []rangedel.Tombstone
 
+	// Fragmented range keys, with the range keys shadowed by newer range keys
+	// within the batch removed. Cached the first time a range key iterator is
+	// requested. The cache is invalidated whenever a new range key is added to
+	// the batch.
+	rangeKeys []rangekey.Key
+
 	// The flushableBatch wrapper if the batch is too large to fit in the
 	// memtable.
 	flushable *flushableBatch
@@ -280,6 +300,7 @@ func (b *Batch) release() {
 
 	b.deferredOp = DeferredBatchOp{}
 	b.tombstones = nil
+	b.rangeKeys = nil
 	b.flushable = nil
 	b.commit = sync.WaitGroup{}
 	b.commitErr = nil
@@ -289,7 +310,7 @@ func (b *Batch) release() {
 		batchPool.Put(b)
 	} else {
 		b.index.Reset()
-		b.index, b.rangeDelIndex = nil, nil
+		b.index, b.rangeDelIndex, b.rangeKeyIndex = nil, nil, nil
 		indexedBatchPool.Put((*indexedBatch)(unsafe.Pointer(b)))
 	}
 }
@@ -301,14 +322,18 @@ func (b *Batch) refreshMemTableSize() {
 	}
 
 	b.countRangeDels = 0
+	b.countRangeKeys = 0
 	for r := b.Reader(); ; {
 		kind, key, value, ok := r.Next()
 		if !ok {
 			break
 		}
 		b.memTableSize += memTableEntrySize(len(key), len(value))
-		if kind == InternalKeyKindRangeDelete {
+		switch kind {
+		case InternalKeyKindRangeDelete:
 			b.countRangeDels++
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			b.countRangeKeys++
 		}
 	}
 }
@@ -342,18 +367,28 @@ func (b *Batch) Apply(batch *Batch, _ *WriteOptions) error {
 			if !ok {
 				break
 			}
-			if kind == InternalKeyKindRangeDelete {
+			switch kind {
+			case InternalKeyKindRangeDelete:
 				b.countRangeDels++
+			case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+				b.countRangeKeys++
 			}
 			if b.index != nil {
 				var err error
-				if kind == InternalKeyKindRangeDelete {
+				switch kind {
+				case InternalKeyKindRangeDelete:
 					b.tombstones = nil
 					if b.rangeDelIndex == nil {
 						b.rangeDelIndex = batchskl.NewSkiplist(&b.data, b.cmp, b.abbreviatedKey)
 					}
 					err = b.rangeDelIndex.Add(uint32(offset))
-				} else {
+				case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+					b.rangeKeys = nil
+					if b.rangeKeyIndex == nil {
+						b.rangeKeyIndex = batchskl.NewSkiplist(&b.data, b.cmp, b.abbreviatedKey)
+					}
+					err = b.rangeKeyIndex.Add(uint32(offset))
+				default:
 					err = b.index.Add(uint32(offset))
 				}
 				if err != nil {
@@ -378,7 +413,7 @@ func (b *Batch) Get(key []byte) ([]byte, io.Closer, error) {
 	if b.index == nil {
 		return nil, nil, ErrNotIndexed
 	}
-	return b.db.getInternal(key, b, nil /* snapshot */)
+	return b.db.getInternal(context.Background(), key, b, nil /* snapshot */)
 }
 
 func (b *Batch) prepareDeferredKeyValueRecord(keyLen, valueLen int, kind InternalKeyKind) {
@@ -617,6 +652,56 @@ func (b *Batch) DeleteRangeDeferred(startLen, endLen int) *DeferredBatchOp {
 	return &b.deferredOp
 }
 
+// RangeKeySet sets the range key with the specified suffix to value over the
+// range [start,end). Range keys are a key/value mapping over a span of user
+// keys which does not affect the point keys in the span. See
+// IterOptions.KeyTypes for how range keys are observed.
+//
+// It is safe to modify the contents of the arguments after RangeKeySet
+// returns.
+func (b *Batch) RangeKeySet(start, end, suffix, value []byte, _ *WriteOptions) error {
+	b.addRangeKey(InternalKeyKindRangeKeySet, start, rangekey.EncodeValue(end, suffix, value))
+	return nil
+}
+
+// RangeKeyUnset removes the range key with the specified suffix over the
+// range [start,end). Range keys with other suffixes are unaffected.
+//
+// It is safe to modify the contents of the arguments after RangeKeyUnset
+// returns.
+func (b *Batch) RangeKeyUnset(start, end, suffix []byte, _ *WriteOptions) error {
+	b.addRangeKey(InternalKeyKindRangeKeyUnset, start, rangekey.EncodeValue(end, suffix, nil))
+	return nil
+}
+
+// RangeKeyDelete removes all of the range keys over the range [start,end).
+// Point keys in the range are unaffected.
+//
+// It is safe to modify the contents of the arguments after RangeKeyDelete
+// returns.
+func (b *Batch) RangeKeyDelete(start, end []byte, _ *WriteOptions) error {
+	b.addRangeKey(InternalKeyKindRangeKeyDelete, start, rangekey.EncodeValue(end, nil, nil))
+	return nil
+}
+
+func (b *Batch) addRangeKey(kind InternalKeyKind, start, value []byte) {
+	b.prepareDeferredKeyValueRecord(len(start), len(value), kind)
+	copy(b.deferredOp.Key, start)
+	copy(b.deferredOp.Value, value)
+	b.countRangeKeys++
+	if b.index != nil {
+		b.rangeKeys = nil
+		// Range keys are rare, so we lazily allocate the index for them.
+		if b.rangeKeyIndex == nil {
+			b.rangeKeyIndex = batchskl.NewSkiplist(&b.data, b.cmp, b.abbreviatedKey)
+		}
+		if err := b.rangeKeyIndex.Add(b.deferredOp.offset); err != nil {
+			// We never add duplicate entries, so an error should never occur.
+			panic(err)
+		}
+	}
+}
+
 // LogData adds the specified to the batch. The data will be written to the
 // WAL, but not added to memtables or sstables. Log data is never indexed,
 // which makes it useful for testing WAL performance.
@@ -633,6 +718,64 @@ func (b *Batch) LogData(data []byte, _ *WriteOptions) error {
 	return nil
 }
 
+// ingestSST adds a record of an sstable ingested as a flushable to the batch.
+// The batch is written to the WAL, but never applied to a memtable, allowing
+// the ingested sstable to be recovered if the DB is reopened before the
+// sstable is flushed. Each record consumes a sequence number.
+func (b *Batch) ingestSST(fileNum FileNum) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(fileNum))
+	b.prepareDeferredKeyRecord(n, InternalKeyKindIngestSST)
+	copy(b.deferredOp.Key, buf[:n])
+}
+
+// ingestedSSTs returns the file numbers of the sstables recorded by ingestSST,
+// or false if the batch does not record an ingestion.
+func (b *Batch) ingestedSSTs() ([]FileNum, bool) {
+	var fileNums []FileNum
+	for r := b.Reader(); ; {
+		kind, key, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		if kind != InternalKeyKindIngestSST {
+			return nil, false
+		}
+		fileNum, n := binary.Uvarint(key)
+		if n <= 0 {
+			return nil, false
+		}
+		fileNums = append(fileNums, FileNum(fileNum))
+	}
+	return fileNums, len(fileNums) > 0
+}
+
+// keyspaceBatch adds a record of the batch repr committed to the keyspace
+// with the specified ID. The record is written to the WAL of the DB which owns
+// the keyspace, but never applied to a memtable. See keyspace.
+func (b *Batch) keyspaceBatch(id uint32, repr []byte) {
+	var buf [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(buf[:], uint64(id))
+	b.prepareDeferredKeyValueRecord(n, len(repr), InternalKeyKindKeyspaceBatch)
+	copy(b.deferredOp.Key, buf[:n])
+	copy(b.deferredOp.Value, repr)
+}
+
+// keyspaceBatchRepr returns the keyspace ID and the batch repr recorded by
+// keyspaceBatch, or false if the batch does not record a keyspace batch.
+func (b *Batch) keyspaceBatchRepr() (uint32, []byte, bool) {
+	r := b.Reader()
+	kind, key, value, ok := r.Next()
+	if !ok || kind != InternalKeyKindKeyspaceBatch || len(r) != 0 {
+		return 0, nil, false
+	}
+	id, n := binary.Uvarint(key)
+	if n <= 0 || len(value) < batchHeaderLen {
+		return 0, nil, false
+	}
+	return uint32(id), value, true
+}
+
 // Empty returns true if the batch is empty, and false otherwise.
 func (b *Batch) Empty() bool {
 	return len(b.data) <= batchHeaderLen
@@ -672,8 +815,12 @@ func (b *Batch) NewIter(o *IterOptions) *Iterator {
 	if b.index == nil {
 		return &Iterator{err: ErrNotIndexed}
 	}
+	var rangeKeyIter internalIterator
+	if o != nil && o.KeyTypes != IterKeyTypePointsOnly {
+		rangeKeyIter = b.newRangeKeyIter(o)
+	}
 	return b.db.newIterInternal(b.newInternalIter(o),
-		b.newRangeDelIter(o), nil /* snapshot */, o)
+		b.newRangeDelIter(o), rangeKeyIter, nil /* snapshot */, o)
 }
 
 // newInternalIter creates a new internalIterator that iterates over the
@@ -727,11 +874,63 @@ func (b *Batch) newRangeDelIter(o *IterOptions) internalIterator {
 	return rangedel.NewIter(b.cmp, b.tombstones)
 }
 
+// newRangeKeyIter returns an iterator over the range keys in the batch, or
+// nil if the batch does not contain any range keys. The range keys are
+// fragmented, and those shadowed by newer range keys in the batch are
+// omitted.
+func (b *Batch) newRangeKeyIter(o *IterOptions) internalIterator {
+	if b.index == nil {
+		return newErrorIter(ErrNotIndexed)
+	}
+	if b.rangeKeyIndex == nil {
+		return nil
+	}
+
+	// Fragment the range keys the first time a range key iterator is
+	// requested. The cached range keys are invalidated if another range key is
+	// added to the batch.
+	if b.rangeKeys == nil {
+		it := &batchIter{
+			cmp:   b.cmp,
+			batch: b,
+			iter:  b.rangeKeyIndex.NewIter(nil, nil),
+		}
+		// As with the fragmented range tombstones, the cached range keys are
+		// slices within Batch.data, which remain valid if Batch.data is
+		// reallocated.
+		var keys []rangekey.Key
+		for key, val := it.First(); key != nil; key, val = it.Next() {
+			k, err := rangekey.Decode(*key, val)
+			if err != nil {
+				return newErrorIter(err)
+			}
+			keys = append(keys, k)
+		}
+		// Every range key in the batch is visible to a reader of the batch, so
+		// a range key shadowed within the batch can never be observed.
+		b.rangeKeys = rangekey.Compact(b.cmp, keys, nil /* snapshots */, nil /* elide */)
+	}
+
+	return rangekey.NewIter(b.cmp, b.rangeKeys)
+}
+
 // Commit applies the batch to its parent writer.
 func (b *Batch) Commit(o *WriteOptions) error {
 	return b.db.Apply(b, o)
 }
 
+// CommitWithContext is like Commit, but records spans for the commit if a
+// tracer is attached to ctx. See DB.ApplyWithContext.
+func (b *Batch) CommitWithContext(ctx context.Context, o *WriteOptions) error {
+	return b.db.ApplyWithContext(ctx, b, o)
+}
+
+// CommitAsync applies the batch to its parent writer, invoking done once the
+// batch is visible and, if o requests a sync, durable. See DB.ApplyAsync.
+func (b *Batch) CommitAsync(o *WriteOptions, done func(error)) error {
+	return b.db.ApplyAsync(b, o, done)
+}
+
 // Close closes the batch without committing it.
 func (b *Batch) Close() error {
 	b.release()
@@ -762,6 +961,7 @@ func (b *Batch) init(cap int) {
 func (b *Batch) Reset() {
 	b.count = 0
 	b.countRangeDels = 0
+	b.countRangeKeys = 0
 	if b.data != nil {
 		if cap(b.data) > batchMaxRetainedSize {
 			// If the capacity of the buffer is larger than our maximum
@@ -881,7 +1081,8 @@ func (r *BatchReader) Next() (kind InternalKeyKind, ukey []byte, value []byte, o
 		return 0, nil, nil, false
 	}
 	kind = InternalKeyKind((*r)[0])
-	if kind > InternalKeyKindMax {
+	if kind > InternalKeyKindMax && kind != InternalKeyKindIngestSST &&
+		kind != InternalKeyKindKeyspaceBatch && !rangekey.IsRangeKey(kind) {
 		return 0, nil, nil, false
 	}
 	*r, ukey, ok = batchDecodeStr((*r)[1:])
@@ -889,7 +1090,9 @@ func (r *BatchReader) Next() (kind InternalKeyKind, ukey []byte, value []byte, o
 		return 0, nil, nil, false
 	}
 	switch kind {
-	case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindRangeDelete:
+	case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindRangeDelete,
+		InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete,
+		InternalKeyKindKeyspaceBatch:
 		*r, value, ok = batchDecodeStr(*r)
 		if !ok {
 			return 0, nil, nil, false
@@ -984,7 +1187,8 @@ func (i *batchIter) Value() []byte {
 	}
 
 	switch InternalKeyKind(data[offset]) {
-	case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindRangeDelete:
+	case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindRangeDelete,
+		InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
 		_, value, ok := batchDecodeStr(data[keyEnd:])
 		if !ok {
 			return nil
@@ -1043,11 +1247,15 @@ type flushableBatch struct {
 	// Sorted in increasing order of key and decreasing order of offset (since
 	// higher offsets correspond to higher sequence numbers).
 	//
-	// Does not include range deletion entries.
+	// Does not include range deletion or range key entries.
 	offsets []flushableBatchEntry
 
 	// Fragmented range deletion tombstones.
 	tombstones []rangedel.Tombstone
+
+	// The offsets of the range key entries, sorted in the same order as
+	// offsets. The range keys are not fragmented.
+	rangeKeyOffsets []flushableBatchEntry
 }
 
 var _ flushable = (*flushableBatch)(nil)
@@ -1093,19 +1301,25 @@ func newFlushableBatch(batch *Batch, comparer *Comparer) *flushableBatch {
 					uintptr(unsafe.Pointer(&b.data[0])))
 				entry.keyEnd = entry.keyStart + keySize
 			}
-			if kind == InternalKeyKindRangeDelete {
+			switch kind {
+			case InternalKeyKindRangeDelete:
 				rangeDelOffsets = append(rangeDelOffsets, entry)
-			} else {
+			case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+				b.rangeKeyOffsets = append(b.rangeKeyOffsets, entry)
+			default:
 				b.offsets = append(b.offsets, entry)
 			}
 		}
 	}
 
-	// Sort both offsets and rangeDelOffsets.
+	// Sort offsets, rangeDelOffsets and rangeKeyOffsets.
 	sort.Sort(b)
 	rangeDelOffsets, b.offsets = b.offsets, rangeDelOffsets
 	sort.Sort(b)
 	rangeDelOffsets, b.offsets = b.offsets, rangeDelOffsets
+	b.rangeKeyOffsets, b.offsets = b.offsets, b.rangeKeyOffsets
+	sort.Sort(b)
+	b.rangeKeyOffsets, b.offsets = b.offsets, b.rangeKeyOffsets
 
 	if len(rangeDelOffsets) > 0 {
 		frag := &rangedel.Fragmenter{
@@ -1195,6 +1409,19 @@ func (b *flushableBatch) newRangeDelIter(o *IterOptions) internalIterator {
 	return rangedel.NewIter(b.cmp, b.tombstones)
 }
 
+func (b *flushableBatch) newRangeKeyIter(o *IterOptions) internalIterator {
+	if len(b.rangeKeyOffsets) == 0 {
+		return nil
+	}
+	return &flushableBatchIter{
+		batch:   b,
+		data:    b.data,
+		offsets: b.rangeKeyOffsets,
+		cmp:     b.cmp,
+		index:   -1,
+	}
+}
+
 func (b *flushableBatch) inuseBytes() uint64 {
 	return uint64(len(b.data) - batchHeaderLen)
 }
@@ -1366,14 +1593,15 @@ func (i *flushableBatchIter) Value() []byte {
 		return nil
 	}
 	kind := InternalKeyKind(p[0])
-	if kind > InternalKeyKindMax {
+	if kind > InternalKeyKindMax && !rangekey.IsRangeKey(kind) {
 		i.err = errors.New("corrupted batch")
 		return nil
 	}
 	var value []byte
 	var ok bool
 	switch kind {
-	case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindRangeDelete:
+	case InternalKeyKindSet, InternalKeyKindMerge, InternalKeyKindRangeDelete,
+		InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
 		keyEnd := i.offsets[i.index].keyEnd
 		_, value, ok = batchDecodeStr(i.data[keyEnd:])
 		if !ok {
@@ -14,6 +14,7 @@ import (
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -396,9 +397,17 @@ func TestBatchIter(t *testing.T) {
 }
 
 func TestBatchDeleteRange(t *testing.T) {
+	runBatchRangeTest(t, "testdata/batch_delete_range")
+}
+
+func TestBatchRangeKeys(t *testing.T) {
+	runBatchRangeTest(t, "testdata/batch_range_keys")
+}
+
+func runBatchRangeTest(t *testing.T, path string) {
 	var b *Batch
 
-	datadriven.RunTest(t, "testdata/batch_delete_range", func(td *datadriven.TestData) string {
+	datadriven.RunTest(t, path, func(td *datadriven.TestData) string {
 		switch td.Cmd {
 		case "clear":
 			b = nil
@@ -428,23 +437,40 @@ func TestBatchDeleteRange(t *testing.T) {
 
 		case "scan":
 			var iter internalIterAdapter
+			var rangeKeys bool
 			if len(td.CmdArgs) > 1 {
 				return fmt.Sprintf("%s expects at most 1 argument", td.Cmd)
 			}
 			if len(td.CmdArgs) == 1 {
-				if td.CmdArgs[0].String() != "range-del" {
+				switch td.CmdArgs[0].String() {
+				case "range-del":
+					iter.internalIterator = b.newRangeDelIter(nil)
+				case "range-key":
+					iter.internalIterator = b.newRangeKeyIter(nil)
+					rangeKeys = true
+				default:
 					return fmt.Sprintf("%s unknown argument %s", td.Cmd, td.CmdArgs[0])
 				}
-				iter.internalIterator = b.newRangeDelIter(nil)
 			} else {
 				iter.internalIterator = b.newInternalIter(nil)
 			}
+			if iter.internalIterator == nil {
+				return ""
+			}
 			defer iter.Close()
 
 			var buf bytes.Buffer
 			for valid := iter.First(); valid; valid = iter.Next() {
 				key := iter.Key()
 				key.SetSeqNum(key.SeqNum() &^ InternalKeySeqNumBatch)
+				if rangeKeys {
+					k, err := rangekey.Decode(*key, iter.Value())
+					if err != nil {
+						return err.Error()
+					}
+					fmt.Fprintf(&buf, "%s\n", k)
+					continue
+				}
 				fmt.Fprintf(&buf, "%s:%s\n", key, iter.Value())
 			}
 			return buf.String()
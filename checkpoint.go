@@ -7,17 +7,71 @@ package pebble
 import (
 	"os"
 
+	"github.com/cockroachdb/errors"
+
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/vfs"
 )
 
+// checkpointOptions hold the optional parameters to construct checkpoint
+// snapshots.
+type checkpointOptions struct {
+	// flush set to true will flush the memtables prior to checkpointing.
+	flush bool
+	// rejectExternal, if non-empty, names the operation constructing the
+	// checkpoint, which fails if the DB references external sstables. See
+	// withoutExternalTables.
+	rejectExternal string
+}
+
+// CheckpointOption set optional parameters used by DB.Checkpoint.
+type CheckpointOption func(*checkpointOptions)
+
+// WithFlush enables flushing the memtables prior to constructing a
+// checkpoint. The writes committed before calling DB.Checkpoint are then
+// linked into the checkpoint as sstables, rather than copied as part of the
+// WAL, which bounds the amount of data copied into the checkpoint. Writes
+// committed concurrently with DB.Checkpoint may still be copied as part of the
+// WAL. Flushing has no effect on a read-only DB.
+func WithFlush() CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.flush = true
+	}
+}
+
+// withoutExternalTables fails the construction of the checkpoint if the DB
+// references sstables ingested by reference (see DB.IngestExternal), for the
+// operations which copy the checkpoint elsewhere, and which would thus depend
+// on the SharedFS of the DB. The operation is named by what.
+func withoutExternalTables(what string) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.rejectExternal = what
+	}
+}
+
 // Checkpoint constructs a snapshot of the DB instance in the specified
 // directory. The WAL, MANIFEST, OPTIONS, and sstables will be copied into the
 // snapshot. Hard links will be used when possible. Beware of the significant
 // space overhead for a checkpoint if hard links are disabled. Also beware that
 // even if hard links are used, the space overhead for the checkpoint will
 // increase over time as the DB performs compactions.
-func (d *DB) Checkpoint(destDir string) (err error) {
+//
+// Writes may be performed concurrently with Checkpoint: the checkpoint
+// reflects a consistent state of the DB, and can be opened as a DB.
+//
+// Sstables ingested by reference (see DB.IngestExternal) are not copied into
+// the snapshot, which must be opened with the same SharedFS.
+//
+// Checkpoints of a DB with keyspaces, or of a keyspace, are not supported.
+func (d *DB) Checkpoint(destDir string, opts ...CheckpointOption) (err error) {
+	if d.keyspace != nil || len(d.Keyspaces()) > 0 {
+		return errors.New("pebble: checkpoints of keyspaces are not supported")
+	}
+	opt := &checkpointOptions{}
+	for _, fn := range opts {
+		fn(opt)
+	}
+
 	if _, err := d.opts.FS.Stat(destDir); !os.IsNotExist(err) {
 		if err == nil {
 			return &os.PathError{
@@ -29,6 +83,12 @@ func (d *DB) Checkpoint(destDir string) (err error) {
 		return err
 	}
 
+	if opt.flush && !d.opts.ReadOnly {
+		if err := d.Flush(); err != nil {
+			return err
+		}
+	}
+
 	// Disable file deletions.
 	d.mu.Lock()
 	d.disableFileDeletions()
@@ -38,9 +98,8 @@ func (d *DB) Checkpoint(destDir string) (err error) {
 		d.enableFileDeletions()
 	}()
 
-	// TODO(peter): RocksDB provides the option to flush if the WAL size is too
-	// large, or roll the manifest if the MANIFEST size is too large. Should we
-	// do this too?
+	// TODO(peter): RocksDB provides the option to roll the manifest if the
+	// MANIFEST size is too large. Should we do this too?
 
 	// Lock the manifest before getting the current version. We need the
 	// length of the manifest that we read to match the current version that
@@ -60,6 +119,18 @@ func (d *DB) Checkpoint(destDir string) (err error) {
 	d.mu.versions.logUnlock()
 	d.mu.Unlock()
 
+	if opt.rejectExternal != "" {
+		for l := range current.Levels {
+			for _, f := range current.Levels[l] {
+				if f.External != "" {
+					return errors.Errorf(
+						"pebble: %s of DBs with external sstables are not supported: %s references %s",
+						opt.rejectExternal, f.FileNum, f.External)
+				}
+			}
+		}
+	}
+
 	// Wrap the normal filesystem with one which wraps newly created files with
 	// vfs.NewSyncingFile.
 	fs := syncingFS{
@@ -117,11 +188,22 @@ func (d *DB) Checkpoint(destDir string) (err error) {
 		}
 	}
 
-	// Link or copy the sstables.
+	// Link or copy the sstables. External sstables remain on SharedFS, where
+	// they are referenced by the checkpoint's MANIFEST. The backing table of
+	// virtual tables is linked once.
+	linked := make(map[FileNum]struct{})
 	for l := range current.Levels {
 		level := current.Levels[l]
 		for i := range level {
-			srcPath := base.MakeFilename(fs, d.dirname, fileTypeTable, level[i].FileNum)
+			if level[i].External != "" {
+				continue
+			}
+			fileNum := level[i].PhysicalFileNum()
+			if _, ok := linked[fileNum]; ok {
+				continue
+			}
+			linked[fileNum] = struct{}{}
+			srcPath := base.MakeFilename(fs, d.dirname, fileTypeTable, fileNum)
 			destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
 			if err := vfs.LinkOrCopy(fs, srcPath, destPath); err != nil {
 				return err
@@ -50,12 +50,19 @@ func TestCheckpoint(t *testing.T) {
 			return buf.String()
 
 		case "checkpoint":
-			if len(td.CmdArgs) != 2 {
-				return "checkpoint <db> <dir>"
+			if len(td.CmdArgs) != 2 && len(td.CmdArgs) != 3 {
+				return "checkpoint <db> <dir> [flush]"
+			}
+			var opts []CheckpointOption
+			if len(td.CmdArgs) == 3 {
+				if td.CmdArgs[2].String() != "flush" {
+					return "checkpoint <db> <dir> [flush]"
+				}
+				opts = append(opts, WithFlush())
 			}
 			buf.Reset()
 			d := dbs[td.CmdArgs[0].String()]
-			if err := d.Checkpoint(td.CmdArgs[1].String()); err != nil {
+			if err := d.Checkpoint(td.CmdArgs[1].String(), opts...); err != nil {
 				return err.Error()
 			}
 			return buf.String()
@@ -66,7 +73,7 @@ func TestCheckpoint(t *testing.T) {
 			}
 			buf.Reset()
 			d := dbs[td.CmdArgs[0].String()]
-			if err := d.Compact(nil, []byte("\xff")); err != nil {
+			if err := d.Compact(nil, []byte("\xff"), false); err != nil {
 				return err.Error()
 			}
 			return buf.String()
@@ -161,7 +168,7 @@ func TestCheckpointCompaction(t *testing.T) {
 		defer cancel()
 		defer wg.Done()
 		for ctx.Err() == nil {
-			if err := d.Compact([]byte("key"), []byte("key999999")); err != nil {
+			if err := d.Compact([]byte("key"), []byte("key999999"), false); err != nil {
 				t.Error(err)
 				return
 			}
@@ -197,7 +204,12 @@ func TestCheckpointCompaction(t *testing.T) {
 			}
 			// Check the checkpoint has all the sstables that the manifest
 			// claims it has.
-			for _, tables := range d2.SSTables() {
+			levels, err := d2.SSTables()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, tables := range levels {
 				for _, tbl := range tables {
 					if _, err := fs.Stat(base.MakeFilename(fs, dir, base.FileTypeTable, tbl.FileNum)); err != nil {
 						t.Error(err)
@@ -370,7 +370,7 @@ func runReplay(cmd *cobra.Command, args []string) error {
 	if err := iter.Close(); err != nil {
 		return err
 	}
-	if err := d.Compact(first, last); err != nil {
+	if err := d.Compact(first, last, false); err != nil {
 		return err
 	}
 	afterSize := totalSize(d.Metrics())
@@ -459,6 +459,10 @@ func teeEventListener(a, b pebble.EventListener) pebble.EventListener {
 			a.ManifestDeleted(info)
 			b.ManifestDeleted(info)
 		},
+		ManifestRecovered: func(info pebble.ManifestRecoveryInfo) {
+			a.ManifestRecovered(info)
+			b.ManifestRecovered(info)
+		},
 		TableCreated: func(info pebble.TableCreateInfo) {
 			a.TableCreated(info)
 			b.TableCreated(info)
@@ -6,6 +6,7 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/bloom"
@@ -69,6 +70,11 @@ func newPebbleDB(dir string) DB {
 		MinCompactionRate: 4 << 20, // 4 MB/s
 		MinFlushRate:      4 << 20, // 4 MB/s
 	}
+	if walMinSyncInterval > 0 {
+		opts.WALMinSyncInterval = func() time.Duration {
+			return walMinSyncInterval
+		}
+	}
 	opts.Experimental.L0SublevelCompactions = true
 	// This value for FlushSplitBytes was arrived through some experimentation
 	// with TPCC import performance. More experimentation might be needed to
@@ -14,15 +14,16 @@ import (
 )
 
 var (
-	cacheSize       int64
-	concurrency     int
-	disableWAL      bool
-	duration        time.Duration
-	engineType      string
-	maxOpsPerSec    = newRateFlag("")
-	verbose         bool
-	waitCompactions bool
-	wipe            bool
+	cacheSize          int64
+	concurrency        int
+	disableWAL         bool
+	duration           time.Duration
+	engineType         string
+	maxOpsPerSec       = newRateFlag("")
+	verbose            bool
+	waitCompactions    bool
+	walMinSyncInterval time.Duration
+	wipe               bool
 )
 
 func main() {
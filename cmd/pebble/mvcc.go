@@ -6,8 +6,11 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 
 	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/bytealloc"
 )
 
@@ -88,9 +91,36 @@ var mvccComparer = &pebble.Comparer{
 		return len(key) + 1
 	},
 
+	FormatKey: func(k []byte) fmt.Formatter {
+		return mvccKeyFormatter(k)
+	},
+
 	Name: "cockroach_comparator",
 }
 
+// mvccKeyFormatter formats an MVCC key as <key>@<wall_time>[,<logical>], or
+// as <key> if the key has no timestamp.
+type mvccKeyFormatter []byte
+
+// Format implements the fmt.Formatter interface.
+func (k mvccKeyFormatter) Format(s fmt.State, c rune) {
+	key, ts, ok := mvccSplitKey(k)
+	if !ok {
+		base.FormatBytes(k).Format(s, c)
+		return
+	}
+	base.FormatBytes(key).Format(s, c)
+	switch len(ts) {
+	case 0:
+	case 8:
+		fmt.Fprintf(s, "@%d", binary.BigEndian.Uint64(ts))
+	case 12:
+		fmt.Fprintf(s, "@%d,%d", binary.BigEndian.Uint64(ts), binary.BigEndian.Uint32(ts[8:]))
+	default:
+		fmt.Fprintf(s, "@%x", ts)
+	}
+}
+
 func mvccSplitKey(mvccKey []byte) (key []byte, ts []byte, ok bool) {
 	if len(mvccKey) == 0 {
 		return nil, nil, false
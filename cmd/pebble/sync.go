@@ -38,6 +38,9 @@ func init() {
 		"batch size distribution [{zipf,uniform}:]min[-max]")
 	syncCmd.Flags().BoolVar(
 		&syncConfig.walOnly, "wal-only", false, "write data only to the WAL")
+	syncCmd.Flags().DurationVar(
+		&walMinSyncInterval, "wal-min-sync-interval", 0,
+		"minimum duration between WAL syncs (0 disables)")
 	syncConfig.values = randvar.NewBytesFlag("uniform:60-80/1.0")
 	syncCmd.Flags().Var(
 		syncConfig.values, "values",
@@ -47,6 +50,8 @@ func init() {
 func runSync(cmd *cobra.Command, args []string) {
 	reg := newHistogramRegistry()
 	var bytes, lastBytes uint64
+	var db DB
+	var lastSyncs uint64
 
 	opts := pebble.Sync
 	if disableWAL {
@@ -57,6 +62,7 @@ func runSync(cmd *cobra.Command, args []string) {
 
 	runTest(args[0], test{
 		init: func(d DB, wg *sync.WaitGroup) {
+			db = d
 			limiter := maxOpsPerSec.newRateLimiter()
 
 			wg.Add(concurrency)
@@ -105,15 +111,17 @@ func runSync(cmd *cobra.Command, args []string) {
 
 		tick: func(elapsed time.Duration, i int) {
 			if i%20 == 0 {
-				fmt.Println("_elapsed____ops/sec___mb/sec__p50(ms)__p95(ms)__p99(ms)_pMax(ms)")
+				fmt.Println("_elapsed____ops/sec___mb/sec_syncs/sec__p50(ms)__p95(ms)__p99(ms)_pMax(ms)")
 			}
+			syncs := db.Metrics().WAL.Syncs
 			reg.Tick(func(tick histogramTick) {
 				h := tick.Hist
 				n := atomic.LoadUint64(&bytes)
-				fmt.Printf("%8s %10.1f %8.1f %8.1f %8.1f %8.1f %8.1f\n",
+				fmt.Printf("%8s %10.1f %8.1f %9.1f %8.1f %8.1f %8.1f %8.1f\n",
 					time.Duration(elapsed.Seconds()+0.5)*time.Second,
 					float64(h.TotalCount())/tick.Elapsed.Seconds(),
 					float64(n-lastBytes)/(1024.0*1024.0)/tick.Elapsed.Seconds(),
+					float64(syncs-lastSyncs)/tick.Elapsed.Seconds(),
 					time.Duration(h.ValueAtQuantile(50)).Seconds()*1000,
 					time.Duration(h.ValueAtQuantile(95)).Seconds()*1000,
 					time.Duration(h.ValueAtQuantile(99)).Seconds()*1000,
@@ -121,6 +129,7 @@ func runSync(cmd *cobra.Command, args []string) {
 				)
 				lastBytes = n
 			})
+			lastSyncs = syncs
 		},
 
 		done: func(elapsed time.Duration) {
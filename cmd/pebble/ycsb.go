@@ -28,15 +28,18 @@ const (
 	ycsbScan
 	ycsbReverseScan
 	ycsbUpdate
+	ycsbReadModifyWrite
 	ycsbNumOps
 )
 
 var ycsbConfig struct {
 	batch            *randvar.Flag
 	keys             string
+	keySizes         *randvar.Flag
 	initialKeys      int
 	prepopulatedKeys int
 	numOps           uint64
+	ramp             time.Duration
 	scans            *randvar.Flag
 	values           *randvar.BytesFlag
 	workload         string
@@ -50,9 +53,11 @@ Run a customizable YCSB workload. The workload is specified by the --workload
 flag which can take either one of the standard workload mixes (A-F), or
 customizable workload fixes specified as a command separated list of op=weight
 pairs. For example, --workload=read=50,update=50 performs a workload composed
-of 50% reads and 50% updates. This is identical to the standard workload A.
+of 50% reads and 50% updates. This is identical to the standard workload A. The
+supported ops are insert, read, scan, rscan (reverse scan), update and rmw
+(read-modify-write).
 
-The --batch, --scans, and --values flags take the specification for a random
+The --batch, --key-sizes, --scans, and --values flags take the specification for a random
 variable: [<type>:]<min>[-<max>]. The <type> parameter must be one of "uniform"
 or "zipf". If <type> is omitted, a uniform distribution is used. If <max> is
 omitted it is set to the same value as <min>. The specification "1000" results
@@ -64,6 +69,17 @@ The --batch flag controls the size of batches used for insert and update
 operations. The --scans flag controls the number of iterations performed by a
 scan operation. Read operations always read a single key.
 
+The --key-sizes flag controls the size of keys, excluding the MVCC timestamp
+suffix. Keys are padded to the size chosen for them, which is deterministic for
+a given key. The size of a key is never less than the size of its unpadded
+encoding, which is the default. The --keys flag controls the distribution of
+the keys read and updated: latest, uniform or zipf. Workload D defaults to the
+latest distribution, and the other workloads to zipf.
+
+The --ramp flag staggers the start of the --concurrency workers evenly over the
+specified duration, which allows the throughput and latency to be observed as
+the concurrency increases.
+
 The --values flag provides for an optional "/<target-compression-ratio>"
 suffix. The default target compression ratio is 1.0 (i.e. incompressible random
 data). A value of 2 will cause random data to be generated that should compress
@@ -76,7 +92,7 @@ Standard workloads:
   C: 100% reads
   D:  95% reads   /   5% inserts
   E:  95% scans   /   5% inserts
-  F: 100% inserts
+  F:  50% reads   /  50% read-modify-writes
 `,
 	Args: cobra.ExactArgs(1),
 	RunE: runYcsb,
@@ -88,7 +104,11 @@ func init() {
 		ycsbConfig.batch, "batch",
 		"batch size distribution [{zipf,uniform}:]min[-max]")
 	ycsbCmd.Flags().StringVar(
-		&ycsbConfig.keys, "keys", "zipf", "latest, uniform, or zipf")
+		&ycsbConfig.keys, "keys", "zipf", "latest, uniform, or zipf (latest for workload D)")
+	ycsbConfig.keySizes = randvar.NewFlag("0")
+	ycsbCmd.Flags().Var(
+		ycsbConfig.keySizes, "key-sizes",
+		"key size distribution [{zipf,uniform}:]min[-max]")
 	ycsbCmd.Flags().IntVar(
 		&ycsbConfig.initialKeys, "initial-keys", 10000,
 		"initial number of keys to insert before beginning workload")
@@ -98,6 +118,9 @@ func init() {
 	ycsbCmd.Flags().Uint64VarP(
 		&ycsbConfig.numOps, "num-ops", "n", 0,
 		"maximum number of operations (0 means unlimited)")
+	ycsbCmd.Flags().DurationVar(
+		&ycsbConfig.ramp, "ramp", 0,
+		"duration over which to start the concurrent workers")
 	ycsbConfig.scans = randvar.NewFlag("zipf:1-1000")
 	ycsbCmd.Flags().Var(
 		ycsbConfig.scans, "scans",
@@ -135,15 +158,14 @@ var ycsbWorkloads = map[string]ycsbWeights{
 	"D": ycsbWeights{
 		ycsbInsert: 0.05,
 		ycsbRead:   0.95,
-		// TODO(peter): default to skewed-latest distribution.
 	},
 	"E": ycsbWeights{
 		ycsbInsert: 0.05,
 		ycsbScan:   0.95,
 	},
 	"F": ycsbWeights{
-		ycsbInsert: 1.0,
-		// TODO(peter): the real workload is read-modify-write.
+		ycsbRead:            0.5,
+		ycsbReadModifyWrite: 0.5,
 	},
 }
 
@@ -172,6 +194,10 @@ func ycsbParseWorkload(w string) (ycsbWeights, error) {
 			iWeights[ycsbReverseScan] = weight
 		case "update":
 			iWeights[ycsbUpdate] = weight
+		case "rmw":
+			iWeights[ycsbReadModifyWrite] = weight
+		default:
+			return nil, errors.Errorf("unknown op: %s", errors.Safe(parts[0]))
 		}
 	}
 
@@ -214,7 +240,11 @@ func runYcsb(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	keyDist, err := ycsbParseKeyDist(ycsbConfig.keys)
+	keys := ycsbConfig.keys
+	if ycsbConfig.workload == "D" && !cmd.Flags().Changed("keys") {
+		keys = "latest"
+	}
+	keyDist, err := ycsbParseKeyDist(keys)
 	if err != nil {
 		return err
 	}
@@ -237,17 +267,26 @@ func runYcsb(cmd *cobra.Command, args []string) error {
 
 type ycsbBuf struct {
 	rng      *rand.Rand
+	keyRng   *rand.Rand
 	keyBuf   []byte
 	valueBuf []byte
 	keyNums  []uint64
 }
 
+func newYcsbBuf() *ycsbBuf {
+	return &ycsbBuf{
+		rng:    randvar.NewRand(),
+		keyRng: rand.New(rand.NewSource(0)),
+	}
+}
+
 type ycsb struct {
 	writeOpts *pebble.WriteOptions
 	weights   ycsbWeights
 	reg       *histogramRegistry
 	ops       *randvar.Weighted
 	keyDist   randvar.Dynamic
+	keySizes  randvar.Static
 	batchDist randvar.Static
 	scanDist  randvar.Static
 	valueDist *randvar.BytesFlag
@@ -268,6 +307,7 @@ func newYcsb(
 		weights:   weights,
 		ops:       randvar.NewWeighted(nil, weights...),
 		keyDist:   keyDist,
+		keySizes:  ycsbConfig.keySizes,
 		batchDist: batchDist,
 		scanDist:  scanDist,
 		valueDist: valueDist,
@@ -281,6 +321,7 @@ func newYcsb(
 	ops := map[string]int{
 		"insert": ycsbInsert,
 		"read":   ycsbRead,
+		"rmw":    ycsbReadModifyWrite,
 		"rscan":  ycsbReverseScan,
 		"scan":   ycsbScan,
 		"update": ycsbUpdate,
@@ -303,7 +344,7 @@ func newYcsb(
 
 func (y *ycsb) init(db DB, wg *sync.WaitGroup) {
 	if ycsbConfig.initialKeys > 0 {
-		buf := &ycsbBuf{rng: randvar.NewRand()}
+		buf := newYcsbBuf()
 
 		b := db.NewBatch()
 		size := 0
@@ -344,19 +385,21 @@ func (y *ycsb) init(db DB, wg *sync.WaitGroup) {
 
 	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
-		go y.run(db, wg)
+		delay := ycsbConfig.ramp * time.Duration(i) / time.Duration(concurrency)
+		go y.run(db, wg, delay)
 	}
 }
 
-func (y *ycsb) run(db DB, wg *sync.WaitGroup) {
+func (y *ycsb) run(db DB, wg *sync.WaitGroup, delay time.Duration) {
 	defer wg.Done()
+	time.Sleep(delay)
 
 	var latency [ycsbNumOps]*namedHistogram
 	for name, op := range y.opsMap {
 		latency[op] = y.reg.Register(name)
 	}
 
-	buf := &ycsbBuf{rng: randvar.NewRand()}
+	buf := newYcsbBuf()
 
 	for {
 		wait(y.limiter)
@@ -375,6 +418,8 @@ func (y *ycsb) run(db DB, wg *sync.WaitGroup) {
 			y.scan(db, buf, true /* reverse */)
 		case ycsbUpdate:
 			y.update(db, buf)
+		case ycsbReadModifyWrite:
+			y.readModifyWrite(db, buf)
 		default:
 			panic("not reached")
 		}
@@ -402,13 +447,23 @@ func (y *ycsb) hashKey(key uint64) uint64 {
 }
 
 func (y *ycsb) makeKey(keyNum uint64, buf *ycsbBuf) []byte {
-	const size = 24 + 10
+	// The size of the key is drawn from a generator seeded with the key
+	// number, so that the same key number always produces the same key.
+	buf.keyRng.Seed(keyNum)
+	keySize := int(y.keySizes.Uint64(buf.keyRng))
+	size := 24 + 10
+	if keySize+10 > size {
+		size = keySize + 10
+	}
 	if cap(buf.keyBuf) < size {
 		buf.keyBuf = make([]byte, size)
 	}
 	key := buf.keyBuf[:4]
 	copy(key, "user")
 	key = strconv.AppendUint(key, y.hashKey(keyNum), 10)
+	for len(key) < keySize {
+		key = append(key, byte('a'+buf.keyRng.Intn(26)))
+	}
 	// Use the MVCC encoding for keys. This appends a timestamp with
 	// walltime=1. That knowledge is utilized by rocksDB.Scan.
 	key = append(key, '\x00', '\x00', '\x00', '\x00', '\x00',
@@ -490,6 +545,24 @@ func (y *ycsb) update(db DB, buf *ycsbBuf) {
 	_ = b.Close()
 }
 
+func (y *ycsb) readModifyWrite(db DB, buf *ycsbBuf) {
+	key := y.nextReadKey(buf)
+	iter := db.NewIter(nil)
+	if iter.SeekGE(key) {
+		_ = iter.Value()
+	}
+	if err := iter.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	b := db.NewBatch()
+	_ = b.Set(key, y.randBytes(buf), nil)
+	if err := b.Commit(y.writeOpts); err != nil {
+		log.Fatal(err)
+	}
+	_ = b.Close()
+}
+
 func (y *ycsb) tick(elapsed time.Duration, i int) {
 	if i%20 == 0 {
 		fmt.Println("____optype__elapsed__ops/sec(inst)___ops/sec(cum)__p50(ms)__p95(ms)__p99(ms)_pMax(ms)")
@@ -5,11 +5,15 @@
 package pebble
 
 import (
+	"context"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/histogram"
 	"github.com/cockroachdb/pebble/internal/record"
 )
 
@@ -136,6 +140,34 @@ type commitEnv struct {
 	write func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error)
 }
 
+// commitMetrics holds histograms of the latency, in nanoseconds, of the stages
+// of the commit pipeline.
+type commitMetrics struct {
+	// total is the end-to-end latency of a commit.
+	total histogram.Histogram
+	// queueWait is the latency of acquiring a commit concurrency slot.
+	queueWait histogram.Histogram
+	// walWrite is the latency of writing the batch to the WAL, including the
+	// wait to acquire commitPipeline.mu.
+	walWrite histogram.Histogram
+	// memTableApply is the latency of applying the batch to the memtable.
+	memTableApply histogram.Histogram
+	// syncWait is the latency between a synchronous batch being applied to the
+	// memtable and the commit completing. This is dominated by waiting for the
+	// WAL sync. Only recorded for synchronous commits.
+	syncWait histogram.Histogram
+}
+
+// reset clears the histograms. Commits which are concurrently in progress may
+// be partially recorded.
+func (m *commitMetrics) reset() {
+	m.total.Reset()
+	m.queueWait.Reset()
+	m.walWrite.Reset()
+	m.memTableApply.Reset()
+	m.syncWait.Reset()
+}
+
 // A commitPipeline manages the stages of committing a set of mutations
 // (contained in a single Batch) atomically to the DB. The steps are
 // conceptually:
@@ -209,11 +241,16 @@ type commitEnv struct {
 type commitPipeline struct {
 	env commitEnv
 	sem chan struct{}
+	// fair, if non-nil, is used in place of sem to admit commits using
+	// weighted fair queuing. See Options.Experimental.CommitClassWeights.
+	fair *fairCommitGate
 	// The mutex to use for synchronizing access to logSeqNum and serializing
 	// calls to commitEnv.write().
 	mu sync.Mutex
 	// Queue of pending batches to commit.
 	pending commitQueue
+	// Latency histograms for the stages of Commit.
+	metrics commitMetrics
 }
 
 func newCommitPipeline(env commitEnv) *commitPipeline {
@@ -227,15 +264,51 @@ func newCommitPipeline(env commitEnv) *commitPipeline {
 	return p
 }
 
+// enableFairQueuing configures the pipeline to admit commits using weighted
+// fair queuing with the specified per-class weights.
+func (p *commitPipeline) enableFairQueuing(weights []int) {
+	p.fair = newFairCommitGate(cap(p.sem), weights)
+}
+
+// acquire acquires one of the commit concurrency slots on behalf of a commit
+// from the specified class.
+func (p *commitPipeline) acquire(class int) {
+	if p.fair != nil {
+		p.fair.acquire(class)
+		return
+	}
+	p.sem <- struct{}{}
+}
+
+// release releases a commit concurrency slot acquired by acquire.
+func (p *commitPipeline) release() {
+	if p.fair != nil {
+		p.fair.release()
+		return
+	}
+	<-p.sem
+}
+
 // Commit the specified batch, writing it to the WAL, optionally syncing the
 // WAL, and applying the batch to the memtable. Upon successful return the
-// batch's mutations will be visible for reading.
-func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
+// batch's mutations will be visible for reading. The class is used to admit
+// the commit when fair queuing is enabled, and is otherwise ignored.
+func (p *commitPipeline) Commit(b *Batch, syncWAL bool, class int) error {
+	return p.CommitWithContext(context.Background(), b, syncWAL, class)
+}
+
+// CommitWithContext is like Commit, but records spans for the stages of the
+// commit if a tracer is attached to ctx. See ContextWithTracer.
+func (p *commitPipeline) CommitWithContext(
+	ctx context.Context, b *Batch, syncWAL bool, class int,
+) error {
 	if b.Empty() {
 		return nil
 	}
 
-	p.sem <- struct{}{}
+	start := time.Now()
+	p.acquire(class)
+	acquired := time.Now()
 
 	// Prepare the batch for committing: enqueuing the batch in the pending
 	// queue, determining the batch sequence number and writing the data to the
@@ -248,32 +321,132 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
 		b.db = nil // prevent batch reuse on error
 		return err
 	}
+	written := time.Now()
 
 	// Apply the batch to the memtable.
 	if err := p.env.apply(b, mem); err != nil {
 		b.db = nil // prevent batch reuse on error
 		return err
 	}
+	applied := time.Now()
 
 	// Publish the batch sequence number.
 	p.publish(b)
 
-	<-p.sem
+	p.release()
 
+	end := p.recordMetrics(syncWAL, start, acquired, written, applied)
+	if base.Traced(ctx) {
+		traceCommit(ctx, b, syncWAL, start, acquired, written, applied, end)
+	}
 	if b.commitErr != nil {
 		b.db = nil // prevent batch reuse on error
 	}
 	return b.commitErr
 }
 
+// CommitAsync is like Commit, but returns once the batch has been written to
+// the WAL and applied to the memtable rather than once it has been published
+// and, if syncWAL is true, synced. The done callback is invoked from another
+// goroutine once that has occurred. Errors writing the batch to the WAL are
+// returned, in which case done is never invoked. Errors applying the batch to
+// the memtable or syncing the WAL are passed to done. A batch which failed to
+// apply is never published, as it may have been partially applied: as with
+// Commit, the commit pipeline cannot proceed past it.
+//
+// The commit concurrency slot acquired for the batch is held until done is
+// invoked, so CommitAsync blocks while the maximum number of commits are in
+// flight. This also bounds the number of outstanding WAL syncs.
+func (p *commitPipeline) CommitAsync(b *Batch, syncWAL bool, class int, done func(error)) error {
+	if b.Empty() {
+		go done(nil)
+		return nil
+	}
+
+	start := time.Now()
+	p.acquire(class)
+	acquired := time.Now()
+
+	// See Commit.
+	mem, err := p.prepare(b, syncWAL)
+	if err != nil {
+		p.abandonAsync(b, err != ErrInvalidBatch)
+		return err
+	}
+	written := time.Now()
+
+	applyErr := p.env.apply(b, mem)
+	applied := time.Now()
+
+	if applyErr == nil {
+		// Publish the batch sequence number, and any others which were waiting
+		// on this batch, but don't wait for another goroutine to publish it.
+		p.publishApplied(b)
+	} else {
+		// The batch is left unpublished in the pending queue. Drop the
+		// reference which its publication would have released, so that done
+		// is invoked once the WAL sync, if any, completes.
+		b.commit.Done()
+	}
+
+	go func() {
+		b.commit.Wait()
+		if applyErr != nil {
+			b.commitErr = applyErr
+		}
+		p.release()
+
+		p.recordMetrics(syncWAL, start, acquired, written, applied)
+		err := b.commitErr
+		if err != nil {
+			b.db = nil // prevent batch reuse on error
+		}
+		done(err)
+	}()
+	return nil
+}
+
+// abandonAsync abandons an asynchronous commit of the batch which could not be
+// written to the WAL, releasing its commit concurrency slot, as done is never
+// invoked. If the batch was enqueued in the pending queue, it is published so
+// that it does not block the publication of the batches behind it. None of
+// its mutations were applied, so none become visible.
+func (p *commitPipeline) abandonAsync(b *Batch, enqueued bool) {
+	b.db = nil // prevent batch reuse on error
+	if enqueued {
+		p.publishApplied(b)
+	}
+	p.release()
+}
+
+// recordMetrics records the latencies of the stages of a commit, which
+// completed at the specified times and now, and returns the time at which the
+// commit completed.
+func (p *commitPipeline) recordMetrics(
+	syncWAL bool, start, acquired, written, applied time.Time,
+) time.Time {
+	end := time.Now()
+	p.metrics.queueWait.RecordDuration(acquired.Sub(start))
+	p.metrics.walWrite.RecordDuration(written.Sub(acquired))
+	p.metrics.memTableApply.RecordDuration(applied.Sub(written))
+	if syncWAL {
+		p.metrics.syncWait.RecordDuration(end.Sub(applied))
+	}
+	p.metrics.total.RecordDuration(end.Sub(start))
+	return end
+}
+
 // AllocateSeqNum allocates count sequence numbers, invokes the prepare
 // callback, then the apply callback, and then publishes the sequence
 // numbers. AllocateSeqNum does not write to the WAL or add entries to the
 // memtable. AllocateSeqNum can be used to sequence an operation such as
 // sstable ingestion within the commit pipeline. The prepare callback is
 // invoked with commitPipeline.mu held, but note that DB.mu is not held and
-// must be locked if necessary.
-func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(seqNum uint64)) {
+// must be locked if necessary. Both callbacks are passed the first allocated
+// sequence number.
+func (p *commitPipeline) AllocateSeqNum(
+	count int, prepare func(seqNum uint64), apply func(seqNum uint64),
+) {
 	// This method is similar to Commit and prepare. Be careful about trying to
 	// share additional code with those methods because Commit and prepare are
 	// performance critical code paths.
@@ -287,7 +460,7 @@ func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(se
 	b.setCount(uint32(count))
 	b.commit.Add(1)
 
-	p.sem <- struct{}{}
+	p.acquire(0)
 
 	p.mu.Lock()
 
@@ -324,7 +497,7 @@ func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(se
 	// Invoke the prepare callback. Note the lack of error reporting. Even if the
 	// callback internally fails, the sequence number needs to be published in
 	// order to allow the commit pipeline to proceed.
-	prepare()
+	prepare(b.SeqNum())
 
 	p.mu.Unlock()
 
@@ -334,7 +507,7 @@ func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(se
 	// Publish the sequence number.
 	p.publish(b)
 
-	<-p.sem
+	p.release()
 }
 
 func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
@@ -375,6 +548,17 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 }
 
 func (p *commitPipeline) publish(b *Batch) {
+	p.publishApplied(b)
+
+	// Wait for another goroutine to publish us. We might also be waiting for
+	// the WAL sync to finish.
+	b.commit.Wait()
+}
+
+// publishApplied marks the batch as applied and publishes the sequence
+// numbers of the applied batches at the head of the pending queue, without
+// waiting for the batch itself to be published.
+func (p *commitPipeline) publishApplied(b *Batch) {
 	// Mark the batch as applied.
 	atomic.StoreUint32(&b.applied, 1)
 
@@ -388,9 +572,6 @@ func (p *commitPipeline) publish(b *Batch) {
 	for {
 		t := p.pending.dequeue()
 		if t == nil {
-			// Wait for another goroutine to publish us. We might also be waiting for
-			// the WAL sync to finish.
-			b.commit.Wait()
 			break
 		}
 		if atomic.LoadUint32(&t.applied) != 1 {
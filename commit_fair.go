@@ -0,0 +1,157 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "sync"
+
+// fairCommitGate limits the number of concurrent commits in the commit
+// pipeline, similar to commitPipeline.sem, but admits waiting commits using
+// weighted fair queuing keyed by the commit class supplied in
+// WriteOptions.Class. When the pipeline is saturated, a class with weight w
+// is admitted w times as often as a class with weight 1, which prevents a
+// burst of commits from one class (e.g. a background backfill) from starving
+// another (e.g. latency-sensitive foreground writes).
+//
+// Fairness is implemented using per-class virtual time: each admission
+// advances the class's virtual time by a cost inversely proportional to the
+// class's weight, and a freed slot is handed to the waiting class with the
+// smallest virtual time (ties are broken in favor of the lower class). A class
+// which becomes active after being idle starts at the current virtual time so
+// that it cannot accumulate credit while idle. Virtual time is maintained in
+// integer units in order for the admission order to be exact.
+type fairCommitGate struct {
+	mu sync.Mutex
+	// The number of available slots.
+	slots int
+	// The virtual time of the most recent admission.
+	vtime   uint64
+	classes []fairCommitClass
+	// The total number of waiters across all classes.
+	waiting int
+}
+
+type fairCommitClass struct {
+	// The virtual time cost of admitting a commit from the class.
+	cost    uint64
+	vtime   uint64
+	waiters []chan struct{}
+}
+
+// maxFairCommitCost bounds the virtual time cost of an admission. Costs are
+// derived from the least common multiple of the class weights, which is capped
+// at this value, in which case fairness is approximate.
+const maxFairCommitCost = 1 << 30
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func newFairCommitGate(slots int, weights []int) *fairCommitGate {
+	g := &fairCommitGate{
+		slots:   slots,
+		classes: make([]fairCommitClass, len(weights)),
+	}
+	// Compute the least common multiple of the weights so that the cost of
+	// each class is an integer.
+	lcm := uint64(1)
+	for _, w := range weights {
+		if w <= 0 {
+			w = 1
+		}
+		lcm = lcm / gcd(lcm, uint64(w)) * uint64(w)
+		if lcm > maxFairCommitCost {
+			lcm = maxFairCommitCost
+			break
+		}
+	}
+	for i, w := range weights {
+		if w <= 0 {
+			w = 1
+		}
+		cost := lcm / uint64(w)
+		if cost == 0 {
+			cost = 1
+		}
+		g.classes[i].cost = cost
+	}
+	return g
+}
+
+// class returns the commit class for the specified class index. Classes
+// outside of the configured range are mapped to class 0.
+func (g *fairCommitGate) class(i int) *fairCommitClass {
+	if i < 0 || i >= len(g.classes) {
+		i = 0
+	}
+	return &g.classes[i]
+}
+
+// admit records the admission of a commit from class c.
+func (g *fairCommitGate) admit(c *fairCommitClass) {
+	if c.vtime < g.vtime {
+		c.vtime = g.vtime
+	}
+	g.vtime = c.vtime
+	c.vtime += c.cost
+}
+
+// tryAcquire acquires a slot for a commit from the specified class. If a slot
+// is available, tryAcquire returns nil. Otherwise the commit is queued and
+// tryAcquire returns a channel which will be closed when the commit has been
+// granted a slot.
+func (g *fairCommitGate) tryAcquire(class int) chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.class(class)
+	if g.slots > 0 && g.waiting == 0 {
+		g.slots--
+		g.admit(c)
+		return nil
+	}
+	if len(c.waiters) == 0 && c.vtime < g.vtime {
+		// The class is becoming active. Don't allow it to use credit accumulated
+		// while it was idle.
+		c.vtime = g.vtime
+	}
+	ch := make(chan struct{})
+	c.waiters = append(c.waiters, ch)
+	g.waiting++
+	return ch
+}
+
+// acquire acquires a slot for a commit from the specified class, blocking
+// until one is available.
+func (g *fairCommitGate) acquire(class int) {
+	if ch := g.tryAcquire(class); ch != nil {
+		<-ch
+	}
+}
+
+// release releases a slot, handing it to the waiting class with the smallest
+// virtual time if there are any waiters.
+func (g *fairCommitGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.waiting == 0 {
+		g.slots++
+		return
+	}
+	var next *fairCommitClass
+	for i := range g.classes {
+		c := &g.classes[i]
+		if len(c.waiters) > 0 && (next == nil || c.vtime < next.vtime) {
+			next = c
+		}
+	}
+	ch := next.waiters[0]
+	next.waiters[0] = nil
+	next.waiters = next.waiters[1:]
+	g.waiting--
+	g.admit(next)
+	close(ch)
+}
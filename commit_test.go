@@ -13,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/record"
@@ -99,7 +100,7 @@ func TestCommitPipeline(t *testing.T) {
 			defer wg.Done()
 			var b Batch
 			_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
-			_ = p.Commit(&b, false)
+			_ = p.Commit(&b, false, 0)
 		}(i)
 	}
 	wg.Wait()
@@ -119,6 +120,222 @@ func TestCommitPipeline(t *testing.T) {
 	}
 }
 
+func TestCommitPipelineAsync(t *testing.T) {
+	var e testCommitEnv
+	p := newCommitPipeline(e.env())
+
+	// A single goroutine commits more batches than the commit concurrency
+	// limit, relying on the completion of earlier commits to proceed.
+	n := 2 * record.SyncConcurrency
+	var wg sync.WaitGroup
+	wg.Add(n)
+	var errCount int32
+	for i := 0; i < n; i++ {
+		b := &Batch{}
+		_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
+		require.NoError(t, p.CommitAsync(b, false, 0, func(err error) {
+			defer wg.Done()
+			// The batch is visible once done is invoked.
+			if err != nil || atomic.LoadUint64(&e.visibleSeqNum) < b.SeqNum()+1 {
+				atomic.AddInt32(&errCount, 1)
+			}
+		}))
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(0), atomic.LoadInt32(&errCount))
+	require.Equal(t, uint64(n), atomic.LoadUint64(&e.writeCount))
+	require.Equal(t, n, len(e.applyBuf.buf))
+	require.Equal(t, uint64(n), atomic.LoadUint64(&e.visibleSeqNum))
+
+	// A commit which fails is not passed to done, and releases its commit
+	// concurrency slot. Otherwise the commits beyond the limit would block.
+	env := e.env()
+	env.write = func(*Batch, *sync.WaitGroup, *error) (*memTable, error) {
+		return nil, errors.New("write failed")
+	}
+	p = newCommitPipeline(env)
+	for i := 0; i < n; i++ {
+		b := &Batch{}
+		_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
+		require.Regexp(t, "write failed", p.CommitAsync(b, false, 0, func(error) {
+			t.Error("done invoked for failed commit")
+		}))
+	}
+
+	// A batch which fails to apply to the memtable is passed to done with the
+	// error, and is not published, as it may have been partially applied.
+	var e2 testCommitEnv
+	env = e2.env()
+	env.apply = func(*Batch, *memTable) error {
+		return errors.New("apply failed")
+	}
+	p = newCommitPipeline(env)
+	b := &Batch{}
+	_ = b.Set([]byte("a"), nil, nil)
+	_ = b.Set([]byte("b"), nil, nil)
+	errCh := make(chan error, 1)
+	require.NoError(t, p.CommitAsync(b, false, 0, func(err error) {
+		errCh <- err
+	}))
+	require.Regexp(t, "apply failed", <-errCh)
+	require.Equal(t, uint64(2), atomic.LoadUint64(&e2.logSeqNum))
+	require.Equal(t, uint64(0), atomic.LoadUint64(&e2.visibleSeqNum))
+}
+
+func TestCommitPipelineMetrics(t *testing.T) {
+	var e testCommitEnv
+	env := e.env()
+	env.write = func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+		// Synchronous commits wait for the WAL sync. Signal it immediately.
+		if wg != nil {
+			wg.Done()
+		}
+		return e.write(b, wg, err)
+	}
+	p := newCommitPipeline(env)
+
+	for i := 0; i < 10; i++ {
+		var b Batch
+		_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
+		require.NoError(t, p.Commit(&b, i%2 == 0, 0))
+	}
+
+	require.EqualValues(t, 10, p.metrics.total.Snapshot().Count)
+	require.EqualValues(t, 10, p.metrics.queueWait.Snapshot().Count)
+	require.EqualValues(t, 10, p.metrics.walWrite.Snapshot().Count)
+	require.EqualValues(t, 10, p.metrics.memTableApply.Snapshot().Count)
+	require.EqualValues(t, 5, p.metrics.syncWait.Snapshot().Count)
+
+	p.metrics.reset()
+	require.EqualValues(t, 0, p.metrics.total.Snapshot().Count)
+	require.EqualValues(t, 0, p.metrics.queueWait.Snapshot().Count)
+	require.EqualValues(t, 0, p.metrics.walWrite.Snapshot().Count)
+	require.EqualValues(t, 0, p.metrics.memTableApply.Snapshot().Count)
+	require.EqualValues(t, 0, p.metrics.syncWait.Snapshot().Count)
+}
+
+func TestCommitPipelineConcurrentApply(t *testing.T) {
+	// Verify that batches are applied to the memtable concurrently, and that
+	// the visible sequence number is only published once all earlier batches
+	// have been applied. Each apply blocks until all of the batches are
+	// concurrently being applied.
+	const n = 8
+	var e testCommitEnv
+	var applying int32
+	ready := make(chan struct{})
+	env := e.env()
+	env.apply = func(b *Batch, mem *memTable) error {
+		if visible := atomic.LoadUint64(&e.visibleSeqNum); visible > b.SeqNum() {
+			return errors.Errorf("batch %d visible before being applied (visible=%d)",
+				b.SeqNum(), visible)
+		}
+		if atomic.AddInt32(&applying, 1) == n {
+			close(ready)
+		}
+		select {
+		case <-ready:
+		case <-time.After(10 * time.Second):
+			return errors.New("timed out waiting for concurrent apply")
+		}
+		return e.apply(b, mem)
+	}
+	p := newCommitPipeline(env)
+
+	errCh := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			var b Batch
+			_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
+			err := p.Commit(&b, false, 0)
+			if err == nil {
+				if visible := atomic.LoadUint64(&e.visibleSeqNum); visible <= b.SeqNum() {
+					err = errors.Errorf("batch %d not visible after commit (visible=%d)",
+						b.SeqNum(), visible)
+				}
+			}
+			errCh <- err
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		require.NoError(t, <-errCh)
+	}
+	require.EqualValues(t, n, atomic.LoadUint64(&e.visibleSeqNum))
+}
+
+func TestFairCommitGate(t *testing.T) {
+	g := newFairCommitGate(1, []int{1, 3})
+
+	// Occupy the only slot.
+	require.Nil(t, g.tryAcquire(0))
+
+	// Queue a burst of class 1 commits, followed by a few class 0 commits.
+	type waiter struct {
+		class int
+		ch    chan struct{}
+	}
+	var waiters []waiter
+	for i := 0; i < 12; i++ {
+		waiters = append(waiters, waiter{1, g.tryAcquire(1)})
+	}
+	for i := 0; i < 4; i++ {
+		waiters = append(waiters, waiter{0, g.tryAcquire(0)})
+	}
+
+	// Release the slot repeatedly, recording the class of each admitted commit.
+	var admitted []int
+	for range waiters {
+		g.release()
+		for i := range waiters {
+			if waiters[i].ch == nil {
+				continue
+			}
+			select {
+			case <-waiters[i].ch:
+				admitted = append(admitted, waiters[i].class)
+				waiters[i].ch = nil
+			default:
+			}
+		}
+	}
+	require.Equal(t, len(waiters), len(admitted))
+
+	// Class 0 is not starved by the earlier burst of class 1 commits: it is
+	// admitted once for every 3 class 1 admissions. Note that the initial class
+	// 0 commit which occupied the slot counts against class 0.
+	require.Equal(t, []int{1, 1, 1, 0, 1, 1, 1, 0, 1, 1, 1, 0, 1, 1, 1, 0}, admitted)
+
+	// With no waiters, the slot is returned to the gate.
+	g.release()
+	require.Nil(t, g.tryAcquire(1))
+}
+
+func TestCommitPipelineFairQueuing(t *testing.T) {
+	var e testCommitEnv
+	p := newCommitPipeline(e.env())
+	p.enableFairQueuing([]int{4, 1})
+
+	const n = 1000
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			var b Batch
+			_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
+			_ = p.Commit(&b, false, i%3)
+		}(i)
+	}
+	wg.Wait()
+
+	if s := atomic.LoadUint64(&e.writeCount); n != s {
+		t.Fatalf("expected %d written batches, but found %d", n, s)
+	}
+	if s := atomic.LoadUint64(&e.visibleSeqNum); n != s {
+		t.Fatalf("expected %d, but found %d", n, s)
+	}
+}
+
 func TestCommitPipelineAllocateSeqNum(t *testing.T) {
 	var e testCommitEnv
 	p := newCommitPipeline(e.env())
@@ -131,7 +348,7 @@ func TestCommitPipelineAllocateSeqNum(t *testing.T) {
 	for i := 1; i <= n; i++ {
 		go func(i int) {
 			defer wg.Done()
-			p.AllocateSeqNum(i, func() {
+			p.AllocateSeqNum(i, func(seqNum uint64) {
 				atomic.AddUint64(&prepareCount, uint64(1))
 			}, func(seqNum uint64) {
 				atomic.AddUint64(&applyCount, uint64(1))
@@ -213,7 +430,7 @@ func TestCommitPipelineWALClose(t *testing.T) {
 				errCh <- err
 				return
 			}
-			errCh <- p.Commit(b, true /* sync */)
+			errCh <- p.Commit(b, true /* sync */, 0)
 		}(i)
 	}
 
@@ -279,7 +496,7 @@ func BenchmarkCommitPipeline(b *testing.B) {
 					batch := newBatch(nil)
 					binary.BigEndian.PutUint64(buf, rng.Uint64())
 					batch.Set(buf, buf, nil)
-					if err := p.Commit(batch, true /* sync */); err != nil {
+					if err := p.Commit(batch, true /* sync */, 0); err != nil {
 						b.Fatal(err)
 					}
 					batch.release()
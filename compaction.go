@@ -23,6 +23,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/internal/rangedel"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/internal/rate"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -109,6 +111,13 @@ type compaction struct {
 	// tests to allow range tombstones to be added to tables where they would
 	// otherwise be elided.
 	disableRangeTombstoneElision bool
+	// deleteOnly is true if the compaction only deletes its input tables,
+	// which are wholly covered by range tombstones in higher levels (see
+	// deleteCompactionHint), without reading them or writing any output.
+	deleteOnly bool
+	// moved is set by runCompaction if the compaction is performed by moving
+	// its input table to the output level without rewriting it.
+	moved bool
 
 	// flushing contains the flushables (aka memtables) that are being flushed.
 	flushing flushableList
@@ -120,11 +129,23 @@ type compaction struct {
 	// compaction routine to know how many bytes have been flushed before the flush
 	// is applied.
 	atomicBytesIterated *uint64
+	// atomicCancel points to a flag which is set to non-zero to request that the
+	// compaction stop early and return ErrCancelledCompaction (see
+	// DB.CancelCompactions). The flag is shared with the subcompactions of the
+	// compaction and must be read/written atomically. atomicCancel is nil for
+	// flushes and delete-only compactions, which cannot be cancelled.
+	atomicCancel *int32
 
 	// The boundaries of the input data.
 	smallest InternalKey
 	largest  InternalKey
 
+	// lower and upper bound the user keys processed by a subcompaction to
+	// [lower, upper). Keys outside of these bounds are processed by the other
+	// subcompactions of the same compaction. A nil bound is unbounded. See
+	// runSubcompactions.
+	lower, upper []byte
+
 	// The range deletion tombstone fragmenter. Adds range tombstones as they are
 	// returned from `compactionIter` and fragments them for output to files.
 	// Referenced by `compactionIter` which uses it to check whether keys are deleted.
@@ -156,6 +177,11 @@ type compaction struct {
 	lcf *manifest.L0CompactionFiles
 
 	metrics map[int]*LevelMetrics
+
+	// jobID and startTime are set when the compaction starts running (see
+	// compact1), and are zero while it is waiting to run.
+	jobID     int
+	startTime time.Time
 }
 
 func newCompaction(
@@ -189,12 +215,49 @@ func newCompaction(
 		maxOverlapBytes:     maxGrandparentOverlapBytes(opts, adjustedOutputLevel),
 		maxExpandedBytes:    expandedCompactionByteSizeLimit(opts, adjustedOutputLevel),
 		atomicBytesIterated: bytesCompacted,
+		atomicCancel:        new(int32),
 	}
 	c.startLevel = &c.inputs[0]
 	c.outputLevel = &c.inputs[1]
 	return c
 }
 
+// cancel requests that the compaction stop early, returning whether the
+// compaction can be cancelled.
+func (c *compaction) cancel() bool {
+	if c.atomicCancel == nil {
+		return false
+	}
+	atomic.StoreInt32(c.atomicCancel, 1)
+	return true
+}
+
+// cancelled returns true if cancellation of the compaction has been requested.
+func (c *compaction) cancelled() bool {
+	return c.atomicCancel != nil && atomic.LoadInt32(c.atomicCancel) != 0
+}
+
+// newDeleteOnlyCompaction returns a compaction which deletes the tables in
+// inputs. The inputs must be sorted by level.
+func newDeleteOnlyCompaction(opts *Options, cur *version, inputs []compactionLevel) *compaction {
+	c := &compaction{
+		cmp:        opts.Comparer.Compare,
+		formatKey:  opts.Comparer.FormatKey,
+		logger:     opts.Logger,
+		version:    cur,
+		inputs:     inputs,
+		deleteOnly: true,
+	}
+	c.startLevel = &c.inputs[0]
+	c.outputLevel = &c.inputs[len(c.inputs)-1]
+	var files []*fileMetadata
+	for _, cl := range c.inputs {
+		files = append(files, cl.files...)
+	}
+	c.smallest, c.largest = manifest.KeyRange(c.cmp, files, nil)
+	return c
+}
+
 func newFlush(
 	opts *Options, cur *version, baseLevel int, flushing flushableList, bytesFlushed *uint64,
 ) *compaction {
@@ -255,12 +318,40 @@ func newFlush(
 		}
 	}
 
+	updateRangeKeyBounds := func(iter internalIterator) {
+		// The end key of a range key is encoded in its value, and range keys
+		// are ordered by start key, so every range key must be examined to
+		// find the largest end key. A range key which cannot be decoded is
+		// ignored here and surfaces as an error when the flush writes it.
+		for key, value := iter.First(); key != nil; key, value = iter.Next() {
+			if !smallestSet ||
+				base.InternalCompare(c.cmp, c.smallest, *key) > 0 {
+				smallestSet = true
+				c.smallest = key.Clone()
+			}
+			k, err := rangekey.Decode(*key, value)
+			if err != nil {
+				continue
+			}
+			tmp := base.MakeRangeDeleteSentinelKey(k.End)
+			if !largestSet ||
+				base.InternalCompare(c.cmp, c.largest, tmp) < 0 {
+				largestSet = true
+				c.largest = tmp.Clone()
+			}
+		}
+		_ = iter.Close()
+	}
+
 	for i := range flushing {
 		f := flushing[i]
 		updatePointBounds(f.newIter(nil))
 		if rangeDelIter := f.newRangeDelIter(nil); rangeDelIter != nil {
 			updateRangeBounds(rangeDelIter)
 		}
+		if rangeKeyIter := f.newRangeKeyIter(nil); rangeKeyIter != nil {
+			updateRangeKeyBounds(rangeKeyIter)
+		}
 	}
 
 	if opts.Experimental.FlushSplitBytes > 0 {
@@ -269,6 +360,10 @@ func newFlush(
 		c.maxExpandedBytes = expandedCompactionByteSizeLimit(opts, 0)
 		c.grandparents = c.version.Overlaps(baseLevel, c.cmp, c.smallest.UserKey, c.largest.UserKey)
 	}
+	if opts.Experimental.FlushSplitHints != nil && smallestSet {
+		c.l0Limits = mergeFlushSplitKeys(c.cmp, c.l0Limits,
+			opts.Experimental.FlushSplitHints(c.smallest.UserKey, c.largest.UserKey))
+	}
 
 	c.setupInuseKeyRanges()
 	return c
@@ -371,40 +466,8 @@ func (c *compaction) expandInputs(level int, inputs []*fileMetadata) []*fileMeta
 	}
 	end := start + len(inputs)
 
-	for ; start > 0; start-- {
-		cur := files[start]
-		prev := files[start-1]
-		if c.cmp(prev.Largest.UserKey, cur.Smallest.UserKey) < 0 {
-			break
-		}
-		if prev.Largest.Trailer == InternalKeyRangeDeleteSentinel {
-			// The range deletion sentinel key is set for the largest key in a
-			// table when a range deletion tombstone straddles a table. It
-			// isn't necessary to include the prev table in the atomic
-			// compaction unit as prev.largest.UserKey does not actually exist
-			// in the prev table.
-			break
-		}
-		// prev.Largest.UserKey == cur.Smallest.UserKey, so we need to include prev
-		// in the compaction.
-	}
-
-	for ; end < len(files); end++ {
-		cur := files[end-1]
-		next := files[end]
-		if c.cmp(cur.Largest.UserKey, next.Smallest.UserKey) < 0 {
-			break
-		}
-		if cur.Largest.Trailer == InternalKeyRangeDeleteSentinel {
-			// The range deletion sentinel key is set for the largest key in a table
-			// when a range deletion tombstone straddles a table. It isn't necessary
-			// to include the next table in the compaction as cur.largest.UserKey
-			// does not actually exist in the table.
-			break
-		}
-		// cur.Largest.UserKey == next.Smallest.UserKey, so we need to include next
-		// in the compaction.
-	}
+	start, _ = manifest.AtomicUnit(c.cmp, files, start)
+	_, end = manifest.AtomicUnit(c.cmp, files, end-1)
 	return files[start:end]
 }
 
@@ -441,12 +504,20 @@ func (c *compaction) setupInuseKeyRanges() {
 		// overlaps.
 		level = 0
 	}
+	c.inuseKeyRanges = calculateInuseKeyRanges(
+		c.version, c.cmp, level, numLevels-1, c.smallest.UserKey, c.largest.UserKey)
+}
 
+// calculateInuseKeyRanges returns the sorted, disjoint user key ranges spanned
+// by the tables in levels [level, maxLevel] which overlap [smallest, largest].
+func calculateInuseKeyRanges(
+	v *version, cmp Compare, level, maxLevel int, smallest, largest []byte,
+) []userKeyRange {
 	// Gather up the raw list of key ranges from overlapping tables in lower
 	// levels.
 	var input []userKeyRange
-	for ; level < numLevels; level++ {
-		overlaps := c.version.Overlaps(level, c.cmp, c.smallest.UserKey, c.largest.UserKey)
+	for ; level <= maxLevel; level++ {
+		overlaps := v.Overlaps(level, cmp, smallest, largest)
 		for i := range overlaps {
 			m := overlaps[i]
 			input = append(input, userKeyRange{m.Smallest.UserKey, m.Largest.UserKey})
@@ -455,43 +526,71 @@ func (c *compaction) setupInuseKeyRanges() {
 
 	if len(input) == 0 {
 		// Nothing more to do.
-		return
+		return nil
 	}
 
 	// Sort the raw list of key ranges by start key.
 	sort.Slice(input, func(i, j int) bool {
-		return c.cmp(input[i].start, input[j].start) < 0
+		return cmp(input[i].start, input[j].start) < 0
 	})
 
 	// Take the first input as the first output. This key range is guaranteed to
 	// have the smallest start key (or share the smallest start key) with another
 	// range. Loop over the remaining input key ranges and either add a new
 	// output, or merge with the last output.
-	c.inuseKeyRanges = input[:1]
-	for _, v := range input[1:] {
-		last := &c.inuseKeyRanges[len(c.inuseKeyRanges)-1]
+	output := input[:1]
+	for _, r := range input[1:] {
+		last := &output[len(output)-1]
 		switch {
-		case c.cmp(last.end, v.start) < 0:
-			c.inuseKeyRanges = append(c.inuseKeyRanges, v)
-		case c.cmp(last.end, v.end) < 0:
-			last.end = v.end
+		case cmp(last.end, r.start) < 0:
+			output = append(output, r)
+		case cmp(last.end, r.end) < 0:
+			last.end = r.end
 		}
 	}
+	return output
 }
 
 func (c *compaction) trivialMove() bool {
 	if len(c.flushing) != 0 {
 		return false
 	}
-	// Check for a trivial move of one table from one level to the next. We avoid
+	// Check for a trivial move of tables from one level to the next. We avoid
 	// such a move if there is lots of overlapping grandparent data. Otherwise,
 	// the move could create a parent file that will require a very expensive
 	// merge later on.
-	if len(c.startLevel.files) == 1 && len(c.outputLevel.files) == 0 &&
-		totalSize(c.grandparents) <= c.maxOverlapBytes {
-		return true
+	files := c.startLevel.files
+	if len(files) == 0 || len(c.outputLevel.files) != 0 ||
+		c.startLevel.level == c.outputLevel.level {
+		return false
+	}
+	if len(files) == 1 {
+		return totalSize(c.grandparents) <= c.maxOverlapBytes
+	}
+	// Several tables can be moved together as long as their key ranges are
+	// disjoint, which is always the case outside of L0, and none of them
+	// overlaps too much grandparent data. Tables in L0 are sorted by sequence
+	// number, so they are sorted by key before checking for overlap.
+	sorted := make([]*fileMetadata, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		return c.cmp(sorted[i].Smallest.UserKey, sorted[j].Smallest.UserKey) < 0
+	})
+	for i := 1; i < len(sorted); i++ {
+		if c.cmp(sorted[i-1].Largest.UserKey, sorted[i].Smallest.UserKey) >= 0 {
+			return false
+		}
 	}
-	return false
+	if c.outputLevel.level+1 < numLevels {
+		for _, f := range sorted {
+			overlaps := c.version.Overlaps(
+				c.outputLevel.level+1, c.cmp, f.Smallest.UserKey, f.Largest.UserKey)
+			if totalSize(overlaps) > c.maxOverlapBytes {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // findGrandparentLimit takes the start user key for a table and returns the
@@ -546,6 +645,31 @@ func (c *compaction) findL0Limit(start []byte) []byte {
 	return grandparentLimit
 }
 
+// mergeFlushSplitKeys returns the sorted, de-duplicated union of the L0 flush
+// split keys and the user-provided flush split hints. The L0 flush split keys
+// are owned by the current version and are not modified.
+func mergeFlushSplitKeys(cmp Compare, limits, hints [][]byte) [][]byte {
+	if len(hints) == 0 {
+		return limits
+	}
+	merged := make([][]byte, 0, len(limits)+len(hints))
+	merged = append(merged, limits...)
+	for _, k := range hints {
+		merged = append(merged, append([]byte(nil), k...))
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return cmp(merged[i], merged[j]) < 0
+	})
+	n := 1
+	for i := 1; i < len(merged); i++ {
+		if cmp(merged[i], merged[n-1]) != 0 {
+			merged[n] = merged[i]
+			n++
+		}
+	}
+	return merged[:n]
+}
+
 // allowZeroSeqNum returns true if seqnum's can be zeroed if there are no
 // snapshots requiring them to be kept. It performs this determination by
 // looking for an sstable which overlaps the bounds of the compaction at a
@@ -618,54 +742,14 @@ func (c *compaction) atomicUnitBounds(f *fileMetadata) (lower, upper []byte) {
 		files := c.inputs[i].files
 		for j := range files {
 			if f == files[j] {
-				// Note that if this file is in a multi-file atomic compaction unit, this file
-				// may not be the first file in that unit. An example in Pebble would be a
-				// preceding file with Largest c#12,1 and this file with Smallest c#9,1 and
-				// containing a range tombstone [c, g)#11,15. The start of the range tombstone
-				// is already truncated to this file's Smallest.UserKey, due to the code in
-				// rangedel.Fragmenter.FlushTo(), so this walking back should not be necessary
-				// (also see range_deletions.md for more details).
-				//
-				// We do this walking back to be extra cautious, in case it helps with RocksDB
-				// compatibility.
-				lowerBound := f.Smallest.UserKey
-				for k := j; k > 0; k-- {
-					cur := files[k]
-					prev := files[k-1]
-					if c.cmp(prev.Largest.UserKey, cur.Smallest.UserKey) < 0 {
-						break
-					}
-					if prev.Largest.Trailer == InternalKeyRangeDeleteSentinel {
-						// The range deletion sentinel key is set for the largest key in a
-						// table when a range deletion tombstone straddles a table. It
-						// isn't necessary to include the prev table in the atomic
-						// compaction unit as prev.largest.UserKey does not actually exist
-						// in the prev table.
-						break
-					}
-					lowerBound = prev.Smallest.UserKey
-				}
-
-				upperBound := f.Largest.UserKey
-				for k := j + 1; k < len(files); k++ {
-					cur := files[k-1]
-					next := files[k]
-					if c.cmp(cur.Largest.UserKey, next.Smallest.UserKey) < 0 {
-						break
-					}
-					if cur.Largest.Trailer == InternalKeyRangeDeleteSentinel {
-						// The range deletion sentinel key is set for the largest key in a
-						// table when a range deletion tombstone straddles a table. It
-						// isn't necessary to include the next table in the atomic
-						// compaction unit as cur.largest.UserKey does not actually exist
-						// in the current table.
-						break
-					}
-					// cur.largest.UserKey == next.largest.UserKey, so next is part of
-					// the atomic compaction unit.
-					upperBound = next.Largest.UserKey
-				}
-				return lowerBound, upperBound
+				// The start of a range tombstone in a file which is not the first
+				// file in its atomic compaction unit is already truncated to the
+				// file's Smallest.UserKey by rangedel.Fragmenter.FlushTo(), so
+				// using the bounds of the whole unit should not be necessary (see
+				// range_deletions.md). We do so to be extra cautious, in case it
+				// helps with RocksDB compatibility.
+				start, end := manifest.AtomicUnit(c.cmp, files, j)
+				return files[start].Smallest.UserKey, files[end-1].Largest.UserKey
 			}
 		}
 	}
@@ -755,6 +839,12 @@ func (c *compaction) newInputIter(newIters tableNewIters) (_ internalIterator, r
 			// time, we follow RocksDB's lead and do not truncate tombstones to
 			// atomic unit boundaries at compaction time.
 			lowerBound, upperBound := c.atomicUnitBounds(f)
+			if c.lower != nil && (lowerBound == nil || c.cmp(c.lower, lowerBound) > 0) {
+				lowerBound = c.lower
+			}
+			if c.upper != nil && (upperBound == nil || c.cmp(c.upper, upperBound) < 0) {
+				upperBound = c.upper
+			}
 			if lowerBound != nil || upperBound != nil {
 				rangeDelIter = rangedel.Truncate(c.cmp, rangeDelIter, lowerBound, upperBound)
 			}
@@ -767,9 +857,9 @@ func (c *compaction) newInputIter(newIters tableNewIters) (_ internalIterator, r
 
 	iterOpts := IterOptions{logger: c.logger}
 	if c.startLevel.level != 0 {
-		iters = append(iters, newLevelIter(iterOpts, c.cmp, newIters, c.startLevel.files,
+		iters = append(iters, newLevelIter(iterOpts, c.cmp, nil, newIters, c.startLevel.files,
 			manifest.Level(c.startLevel.level), &c.bytesIterated))
-		iters = append(iters, newLevelIter(iterOpts, c.cmp, newRangeDelIter, c.startLevel.files,
+		iters = append(iters, newLevelIter(iterOpts, c.cmp, nil, newRangeDelIter, c.startLevel.files,
 			manifest.Level(c.startLevel.level), &c.bytesIterated))
 	} else {
 		for i := range c.startLevel.files {
@@ -780,16 +870,32 @@ func (c *compaction) newInputIter(newIters tableNewIters) (_ internalIterator, r
 			}
 			iters = append(iters, iter)
 			if rangeDelIter != nil {
+				if c.lower != nil || c.upper != nil {
+					// Truncate the range tombstones to the bounds of the
+					// subcompaction, keeping the underlying iterator open for the
+					// lifetime of the compaction as above.
+					c.closers = append(c.closers, rangeDelIter)
+					rangeDelIter = rangedel.Truncate(c.cmp, noCloseIter{rangeDelIter}, c.lower, c.upper)
+				}
 				iters = append(iters, rangeDelIter)
 			}
 		}
 	}
 
-	iters = append(iters, newLevelIter(iterOpts, c.cmp, newIters, c.outputLevel.files,
+	iters = append(iters, newLevelIter(iterOpts, c.cmp, nil, newIters, c.outputLevel.files,
 		manifest.Level(c.outputLevel.level), &c.bytesIterated))
-	iters = append(iters, newLevelIter(iterOpts, c.cmp, newRangeDelIter, c.outputLevel.files,
+	iters = append(iters, newLevelIter(iterOpts, c.cmp, nil, newRangeDelIter, c.outputLevel.files,
 		manifest.Level(c.outputLevel.level), &c.bytesIterated))
-	return newMergingIter(c.logger, c.cmp, iters...), nil
+	var iter internalIterator = newMergingIter(c.logger, c.cmp, iters...)
+	if c.lower != nil || c.upper != nil {
+		iter = &subcompactionInputIter{
+			internalIterator: iter,
+			cmp:              c.cmp,
+			lower:            c.lower,
+			upper:            c.upper,
+		}
+	}
+	return iter, nil
 }
 
 func (c *compaction) String() string {
@@ -821,6 +927,119 @@ type manualCompaction struct {
 	done        chan error
 	start       InternalKey
 	end         InternalKey
+	// split is true if the manual compaction is one of several compactions of
+	// disjoint key ranges into which a parallel manual compaction was split.
+	// Split manual compactions only conflict with in-progress compactions of
+	// overlapping key ranges.
+	split bool
+}
+
+// A deleteCompactionHint records a range tombstone which wholly covers
+// tables in lower levels. Such tables may be deleted without being read or
+// rewritten, as long as all of their keys are older than the tombstone and no
+// open snapshot can observe them but not the tombstone. Hints are produced by
+// the table stats collector and consumed by delete-only compactions.
+type deleteCompactionHint struct {
+	// The level and table containing the range tombstone. The hint is only
+	// valid while the table remains in the level.
+	tombstoneLevel int
+	tombstoneFile  *fileMetadata
+	// The smallest and largest sequence numbers of the (defragmented) range
+	// tombstones spanning [start, end).
+	tombstoneSmallestSeqNum uint64
+	tombstoneLargestSeqNum  uint64
+	start                   []byte
+	end                     []byte
+}
+
+func (h deleteCompactionHint) String() string {
+	return fmt.Sprintf("L%d.%s %s-%s seqnums(tombstone=%d-%d)",
+		h.tombstoneLevel, h.tombstoneFile.FileNum, h.start, h.end,
+		h.tombstoneSmallestSeqNum, h.tombstoneLargestSeqNum)
+}
+
+// canDelete returns true if the table m is covered by the hint's range
+// tombstone. If the table is covered except for the presence of an open
+// snapshot, canDelete returns false and blocked is true.
+func (h *deleteCompactionHint) canDelete(
+	cmp Compare, m *fileMetadata, snapshots []uint64,
+) (ok, blocked bool) {
+	// Range deletions do not delete range keys, so a table containing range
+	// keys must be compacted rather than deleted.
+	if m.HasRangeKeys {
+		return false, false
+	}
+	// The table's keys must be completely contained within the tombstone's
+	// range, and all of them must be older than the tombstone.
+	if cmp(h.start, m.Smallest.UserKey) > 0 || cmp(m.Largest.UserKey, h.end) >= 0 {
+		return false, false
+	}
+	if m.LargestSeqNum >= h.tombstoneSmallestSeqNum {
+		return false, false
+	}
+	// No snapshot may be able to observe the table's keys without also
+	// observing the tombstone.
+	for _, s := range snapshots {
+		if m.SmallestSeqNum < s && s <= h.tombstoneLargestSeqNum {
+			return false, true
+		}
+	}
+	return true, false
+}
+
+// checkDeleteCompactionHints returns the tables in v which can be deleted by a
+// delete-only compaction, grouped by level, along with the hints which remain
+// unresolved. A hint remains unresolved if it covers a table which cannot be
+// deleted yet because the table is being compacted or because of an open
+// snapshot.
+func checkDeleteCompactionHints(
+	cmp Compare, v *version, hints []deleteCompactionHint, snapshots []uint64,
+) ([]compactionLevel, []deleteCompactionHint) {
+	var files [numLevels]map[*fileMetadata]bool
+	var unresolved []deleteCompactionHint
+	for i := range hints {
+		h := &hints[i]
+		if !v.Contains(h.tombstoneLevel, cmp, h.tombstoneFile) {
+			// The table containing the tombstone has been compacted or
+			// deleted.
+			continue
+		}
+		var pending bool
+		for level := h.tombstoneLevel + 1; level < numLevels; level++ {
+			for _, m := range v.Overlaps(level, cmp, h.start, h.end) {
+				ok, blocked := h.canDelete(cmp, m, snapshots)
+				if blocked || (ok && m.Compacting) {
+					pending = true
+					continue
+				}
+				if !ok {
+					continue
+				}
+				if files[level] == nil {
+					files[level] = make(map[*fileMetadata]bool)
+				}
+				files[level][m] = true
+			}
+		}
+		if pending {
+			unresolved = append(unresolved, *h)
+		}
+	}
+
+	var inputs []compactionLevel
+	for level, set := range files {
+		if len(set) == 0 {
+			continue
+		}
+		cl := compactionLevel{level: level}
+		for _, m := range v.Levels[level] {
+			if set[m] {
+				cl.files = append(cl.files, m)
+			}
+		}
+		inputs = append(inputs, cl)
+	}
+	return inputs, unresolved
 }
 
 func (d *DB) addInProgressCompaction(c *compaction) {
@@ -905,6 +1124,7 @@ func (d *DB) getCompactionPacerInfo() compactionPacerInfo {
 	pacerInfo := compactionPacerInfo{
 		slowdownThreshold:   uint64(estimatedMaxWAmp * float64(d.opts.MemTableSize)),
 		totalCompactionDebt: d.mu.versions.picker.estimatedCompactionDebt(bytesFlushed),
+		compactions:         d.mu.compact.compactingCount,
 	}
 	for _, m := range d.mu.mem.queue {
 		pacerInfo.totalDirtyBytes += m.inuseBytes()
@@ -959,11 +1179,19 @@ func (d *DB) maybeScheduleFlush() {
 	// while we're undergoing the ramp period on the memtable size. See
 	// DB.newMemTable().
 	minFlushSize := uint64(d.opts.MemTableSize) / 2
+	if d.memTableSizer != nil {
+		// Adaptively sized memtables do not undergo the ramp period.
+		minFlushSize = uint64(d.memTableSizer.minSize) / 2
+	}
 	if size < minFlushSize {
 		return
 	}
 
 	d.mu.compact.flushing = true
+	if d.opts.Experimental.Deterministic {
+		d.flushLocked()
+		return
+	}
 	go d.flush()
 }
 
@@ -1018,32 +1246,57 @@ func (d *DB) flush() {
 	pprof.Do(context.Background(), flushLabels, func(context.Context) {
 		d.mu.Lock()
 		defer d.mu.Unlock()
-		if err := d.flush1(); err != nil {
-			// TODO(peter): count consecutive flush errors and backoff.
-			d.opts.EventListener.BackgroundError(err)
-		}
-		d.mu.compact.flushing = false
-		// More flush work may have arrived while we were flushing, so schedule
-		// another flush if needed.
-		d.maybeScheduleFlush()
-		// The flush may have produced too many files in a level, so schedule a
-		// compaction if needed.
-		d.maybeScheduleCompaction()
-		d.mu.compact.cond.Broadcast()
+		d.flushLocked()
 	})
 }
 
+// flushLocked runs a flush and maybe schedules another flush and compactions.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) flushLocked() {
+	err := d.flush1()
+	if err != nil {
+		// TODO(peter): count consecutive flush errors and backoff.
+		d.opts.EventListener.BackgroundError(err)
+		d.mu.bgErr.flushErrs++
+		d.setBackgroundErrorLocked(err, 0)
+	}
+	d.mu.compact.flushing = false
+	// More flush work may have arrived while we were flushing, so schedule
+	// another flush if needed. As for compactions, a failed synchronous flush
+	// is not retried immediately.
+	if err == nil || !d.opts.Experimental.Deterministic {
+		d.maybeScheduleFlush()
+	}
+	// The flush may have produced too many files in a level, so schedule a
+	// compaction if needed.
+	d.maybeScheduleCompaction()
+	d.mu.compact.cond.Broadcast()
+}
+
 // flush runs a compaction that copies the immutable memtables from memory to
 // disk.
 //
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
 func (d *DB) flush1() error {
+	if len(d.mu.mem.queue) > 1 {
+		if ingested, ok := d.mu.mem.queue[0].flushable.(*ingestedFlushable); ok {
+			return d.flushIngested(ingested)
+		}
+	}
+
 	var n int
 	for ; n < len(d.mu.mem.queue)-1; n++ {
 		if !d.mu.mem.queue[n].readyForFlush() {
 			break
 		}
+		if _, ok := d.mu.mem.queue[n].flushable.(*ingestedFlushable); ok {
+			// Ingested sstables are flushed separately, once all of the
+			// flushables before them have been flushed.
+			break
+		}
 	}
 	if n == 0 {
 		// None of the immutable memtables are ready for flushing.
@@ -1051,9 +1304,10 @@ func (d *DB) flush1() error {
 	}
 
 	// Require that every memtable being flushed has a log number less than the
-	// new minimum unflushed log number.
+	// new minimum unflushed log number. The memtables of a keyspace share the
+	// logs of its parent, and may have the same log number.
 	minUnflushedLogNum := d.mu.mem.queue[n].logNum
-	if !d.opts.DisableWAL {
+	if !d.opts.DisableWAL && d.keyspace == nil {
 		for i := 0; i < n; i++ {
 			logNum := d.mu.mem.queue[i].logNum
 			if logNum >= minUnflushedLogNum {
@@ -1132,7 +1386,9 @@ func (d *DB) flush1() error {
 		flushed = d.mu.mem.queue[:n]
 		d.mu.mem.queue = d.mu.mem.queue[n:]
 		d.updateReadStateLocked(d.opts.DebugCheck)
+		d.maybeTransitionSnapshotsLocked()
 		d.updateTableStatsLocked(ve.NewFiles)
+		d.maybeClearBackgroundErrorLocked()
 	}
 	d.deleteObsoleteFiles(jobID)
 
@@ -1153,19 +1409,98 @@ func (d *DB) flush1() error {
 	return err
 }
 
-// maybeScheduleCompaction schedules a compaction if necessary.
+// flushIngested flushes the ingested sstables at the head of the queue of
+// flushables by adding them to L0. The sstables are not rewritten, and all of
+// the flushables containing older data have already been flushed.
 //
-// d.mu must be held when calling this.
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) flushIngested(ingested *ingestedFlushable) error {
+	entry := d.mu.mem.queue[0]
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	d.opts.EventListener.FlushBegin(FlushInfo{
+		JobID: jobID,
+		Input: 1,
+	})
+	startTime := d.timeNow()
+
+	ve := &versionEdit{
+		MinUnflushedLogNum: d.mu.mem.queue[1].logNum,
+		NewFiles:           make([]newFileEntry, len(ingested.files)),
+	}
+	levelMetrics := &LevelMetrics{}
+	for i, m := range ingested.files {
+		ve.NewFiles[i] = newFileEntry{Level: 0, Meta: m}
+		levelMetrics.BytesIngested += m.Size
+		levelMetrics.TablesIngested++
+	}
+	d.mu.versions.logLock()
+	err := d.mu.versions.logAndApply(jobID, ve, map[int]*LevelMetrics{0: levelMetrics},
+		d.dataDir, func() []compactionInfo { return d.getInProgressCompactionInfoLocked(nil) })
+
+	info := FlushInfo{
+		JobID:    jobID,
+		Input:    1,
+		Duration: d.timeNow().Sub(startTime),
+		Done:     true,
+		Err:      err,
+	}
+	if err == nil {
+		for i := range ve.NewFiles {
+			info.Output = append(info.Output, ve.NewFiles[i].Meta.TableInfo())
+		}
+	}
+	d.mu.versions.incrementFlushes()
+	d.opts.EventListener.FlushEnd(info)
+
+	if err != nil {
+		return err
+	}
+	d.mu.mem.queue = d.mu.mem.queue[1:]
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	d.maybeTransitionSnapshotsLocked()
+	d.updateTableStatsLocked(ve.NewFiles)
+	d.maybeClearBackgroundErrorLocked()
+	d.deleteObsoleteFiles(jobID)
+	entry.readerUnref()
+	close(entry.flushed)
+	return nil
+}
+
+// maybeScheduleCompaction schedules a compaction if necessary. If
+// Options.Experimental.Deterministic is set, the compaction is run
+// before returning.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired while running a synchronous compaction.
 func (d *DB) maybeScheduleCompaction() {
+	if c, errChannel := d.scheduleCompactionsLocked(); c != nil {
+		d.compactLocked(c, errChannel)
+	}
+}
+
+// scheduleCompactionsLocked picks compactions and starts running them in the
+// background, up to Options.MaxConcurrentCompactions. If
+// Options.Experimental.Deterministic is set, at most one compaction
+// is picked and, rather than being started, it is returned along with the
+// channel its result should be sent on, if any.
+//
+// d.mu must be held when calling this.
+func (d *DB) scheduleCompactionsLocked() (synchronous *compaction, errChannel chan error) {
 	if atomic.LoadInt32(&d.closed) != 0 || d.opts.ReadOnly {
-		return
+		return nil, nil
 	}
-	if d.mu.compact.compactingCount >= d.opts.MaxConcurrentCompactions {
+	maxConcurrentCompactions := d.opts.MaxConcurrentCompactions
+	if d.opts.Experimental.Deterministic {
+		maxConcurrentCompactions = 1
+	}
+	if d.mu.compact.compactingCount >= maxConcurrentCompactions {
 		if len(d.mu.compact.manual) > 0 {
 			// Inability to run head blocks later manual compactions.
 			d.mu.compact.manual[0].retries++
 		}
-		return
+		return nil, nil
 	}
 
 	// Compaction picking needs a coherent view of a Version. In particular, we
@@ -1178,22 +1513,33 @@ func (d *DB) maybeScheduleCompaction() {
 	// Check for the closed flag again, in case the DB was closed while we were
 	// waiting for logLock().
 	if atomic.LoadInt32(&d.closed) != 0 {
-		return
+		return nil, nil
+	}
+
+	start := func(c *compaction, ch chan error) {
+		d.mu.compact.compactingCount++
+		d.addInProgressCompaction(c)
+		if d.opts.Experimental.Deterministic {
+			synchronous, errChannel = c, ch
+			return
+		}
+		go d.compact(c, ch)
 	}
 
 	env := compactionEnv{
 		bytesCompacted:          &d.bytesCompacted,
 		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
 	}
-	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
+	if d.opts.PeriodicCompactionPeriod > 0 {
+		env.now = d.timeNow()
+	}
+	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < maxConcurrentCompactions {
 		manual := d.mu.compact.manual[0]
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		c, retryLater := d.mu.versions.picker.pickManual(env, manual)
 		if c != nil {
 			d.mu.compact.manual = d.mu.compact.manual[1:]
-			d.mu.compact.compactingCount++
-			d.addInProgressCompaction(c)
-			go d.compact(c, manual.done)
+			start(c, manual.done)
 		} else if !retryLater {
 			// Noop
 			d.mu.compact.manual = d.mu.compact.manual[1:]
@@ -1205,16 +1551,26 @@ func (d *DB) maybeScheduleCompaction() {
 		}
 	}
 
-	for !d.opts.private.disableAutomaticCompactions && d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
+	if !d.mu.compact.disableAutomatic && len(d.mu.compact.deletionHints) > 0 &&
+		d.mu.compact.compactingCount < maxConcurrentCompactions {
+		v := d.mu.versions.currentVersion()
+		inputs, unresolved := checkDeleteCompactionHints(
+			d.cmp, v, d.mu.compact.deletionHints, d.mu.snapshots.toSlice())
+		d.mu.compact.deletionHints = unresolved
+		if len(inputs) > 0 {
+			start(newDeleteOnlyCompaction(d.opts, v, inputs), nil)
+		}
+	}
+
+	for !d.mu.compact.disableAutomatic && d.mu.compact.compactingCount < maxConcurrentCompactions {
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		c := d.mu.versions.picker.pickAuto(env)
 		if c == nil {
 			break
 		}
-		d.mu.compact.compactingCount++
-		d.addInProgressCompaction(c)
-		go d.compact(c, nil)
+		start(c, nil)
 	}
+	return synchronous, errChannel
 }
 
 // compact runs one compaction and maybe schedules another call to compact.
@@ -1222,18 +1578,36 @@ func (d *DB) compact(c *compaction, errChannel chan error) {
 	pprof.Do(context.Background(), compactLabels, func(context.Context) {
 		d.mu.Lock()
 		defer d.mu.Unlock()
-		if err := d.compact1(c, errChannel); err != nil {
-			// TODO(peter): count consecutive compaction errors and backoff.
-			d.opts.EventListener.BackgroundError(err)
-		}
-		d.mu.compact.compactingCount--
-		// The previous compaction may have produced too many files in a
-		// level, so reschedule another compaction if needed.
-		d.maybeScheduleCompaction()
-		d.mu.compact.cond.Broadcast()
+		d.compactLocked(c, errChannel)
 	})
 }
 
+// compactLocked runs one compaction and maybe schedules another call to
+// compact.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) compactLocked(c *compaction, errChannel chan error) {
+	err := d.compact1(c, errChannel)
+	if err != nil && !errors.Is(err, ErrCancelledCompaction) {
+		// TODO(peter): count consecutive compaction errors and backoff.
+		d.opts.EventListener.BackgroundError(err)
+		if errors.Is(err, errWriteFailed) {
+			d.setBackgroundErrorLocked(err, 0)
+		}
+	}
+	d.mu.compact.compactingCount--
+	// The previous compaction may have produced too many files in a
+	// level, so reschedule another compaction if needed. A failed synchronous
+	// compaction is not retried immediately, as doing so would recurse for as
+	// long as the failure persists. It is retried the next time compactions are
+	// scheduled.
+	if err == nil || !d.opts.Experimental.Deterministic {
+		d.maybeScheduleCompaction()
+	}
+	d.mu.compact.cond.Broadcast()
+}
+
 // compact1 runs one compaction.
 //
 // d.mu must be held when calling this, but the mutex may be dropped and
@@ -1248,14 +1622,12 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
 	info := CompactionInfo{
-		JobID: jobID,
-		Input: []LevelInfo{
-			{Level: c.startLevel.level},
-			{Level: c.outputLevel.level},
-		},
+		JobID:  jobID,
+		Input:  make([]LevelInfo, len(c.inputs)),
 		Output: LevelInfo{Level: c.outputLevel.level},
 	}
 	for i, cl := range c.inputs {
+		info.Input[i].Level = cl.level
 		for _, m := range cl.files {
 			info.Input[i].Tables = append(info.Input[i].Tables, m.TableInfo())
 		}
@@ -1263,21 +1635,35 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 
 	d.opts.EventListener.CompactionBegin(info)
 	startTime := d.timeNow()
+	c.jobID, c.startTime = jobID, startTime
 
 	compactionPacer := (pacer)(nilPacer)
-	if d.opts.private.enablePacing {
-		// TODO(peter): Compaction pacing is disabled until we figure out why it
-		// impacts throughput.
+	if d.opts.private.enablePacing || d.opts.Experimental.CompactionDebtPacing {
+		// TODO(peter): Compaction pacing is disabled by default until we figure
+		// out why it impacts throughput.
+		limiter := d.compactionLimiter
+		if d.opts.Experimental.CompactionDebtPacing {
+			// Debt pacing adjusts the limit of the limiter, which is thus not
+			// shared with other compactions.
+			limiter = rate.NewLimiter(rate.Limit(d.opts.MinCompactionRate), d.opts.MinCompactionRate)
+		}
 		compactionPacer = newCompactionPacer(compactionPacerEnv{
-			limiter:      d.compactionLimiter,
+			limiter:      limiter,
 			memTableSize: uint64(d.opts.MemTableSize),
+			debtPacing:   d.opts.Experimental.CompactionDebtPacing,
+			minRate:      uint64(d.opts.MinCompactionRate),
+			stats:        &d.compactionPacing,
 			getInfo:      d.getCompactionPacerInfo,
 		})
 	}
+	if d.compactionRateCap != nil {
+		compactionPacer = newCappedPacer(compactionPacer, d.compactionRateCap, &d.compactionPacing)
+	}
 	ve, pendingOutputs, err := d.runCompaction(jobID, c, compactionPacer)
 
 	info.Duration = d.timeNow().Sub(startTime)
 	if err == nil {
+		d.recordCompactionThroughputLocked(c, info.Duration)
 		d.mu.versions.logLock()
 		err = d.mu.versions.logAndApply(jobID, ve, c.metrics, d.dataDir, func() []compactionInfo {
 			return d.getInProgressCompactionInfoLocked(c)
@@ -1298,7 +1684,7 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	}
 
 	d.removeInProgressCompaction(c)
-	d.mu.versions.incrementCompactions()
+	d.mu.versions.incrementCompactions(c)
 	d.opts.EventListener.CompactionEnd(info)
 
 	// Update the read state before deleting obsolete files because the
@@ -1308,12 +1694,32 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	if err == nil {
 		d.updateReadStateLocked(d.opts.DebugCheck)
 		d.updateTableStatsLocked(ve.NewFiles)
+		d.maybeClearBackgroundErrorLocked()
 	}
 	d.deleteObsoleteFiles(jobID)
 
 	return err
 }
 
+// recordCompactionThroughputLocked updates the moving average of the
+// compaction throughput with the number of bytes read by the compaction c,
+// which took the specified duration. Compactions which did not read any data,
+// such as trivial moves, are ignored.
+//
+// d.mu must be held when calling this.
+func (d *DB) recordCompactionThroughputLocked(c *compaction, elapsed time.Duration) {
+	m := c.metrics[c.outputLevel.level]
+	if m == nil || m.BytesRead == 0 || elapsed <= 0 {
+		return
+	}
+	sample := float64(m.BytesRead) / elapsed.Seconds()
+	if d.mu.compact.throughput == 0 {
+		d.mu.compact.throughput = sample
+	} else {
+		d.mu.compact.throughput = (d.mu.compact.throughput + sample) / 2
+	}
+}
+
 // runCompactions runs a compaction that produces new on-disk tables from
 // memtables or old on-disk tables.
 //
@@ -1322,25 +1728,37 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 func (d *DB) runCompaction(
 	jobID int, c *compaction, pacer pacer,
 ) (ve *versionEdit, pendingOutputs []FileNum, retErr error) {
-	// Check for a trivial move of one table from one level to the next. We avoid
+	// Check for a trivial move of tables from one level to the next. We avoid
 	// such a move if there is lots of overlapping grandparent data. Otherwise,
 	// the move could create a parent file that will require a very expensive
-	// merge later on.
-	if c.trivialMove() {
-		meta := c.startLevel.files[0]
-		c.metrics = map[int]*LevelMetrics{
-			c.outputLevel.level: &LevelMetrics{
-				BytesMoved:  meta.Size,
-				TablesMoved: 1,
-			},
+	// merge later on. Tables are not moved if the placement policy would place
+	// them on a different filesystem, in which case they are rewritten instead.
+	if c.deleteOnly {
+		ve := &versionEdit{
+			DeletedFiles: map[deletedFileEntry]bool{},
+		}
+		for _, cl := range c.inputs {
+			for _, meta := range cl.files {
+				ve.DeletedFiles[deletedFileEntry{Level: cl.level, FileNum: meta.FileNum}] = true
+			}
 		}
+		return ve, nil, nil
+	}
+
+	if c.trivialMove() && !d.placementChanged(c) {
+		c.moved = true
+		metrics := &LevelMetrics{}
 		ve := &versionEdit{
-			DeletedFiles: map[deletedFileEntry]bool{
-				deletedFileEntry{Level: c.startLevel.level, FileNum: meta.FileNum}: true,
-			},
-			NewFiles: []newFileEntry{
-				{Level: c.outputLevel.level, Meta: meta},
-			},
+			DeletedFiles: map[deletedFileEntry]bool{},
+		}
+		for _, meta := range c.startLevel.files {
+			metrics.BytesMoved += meta.Size
+			metrics.TablesMoved++
+			ve.DeletedFiles[deletedFileEntry{Level: c.startLevel.level, FileNum: meta.FileNum}] = true
+			ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: c.outputLevel.level, Meta: meta})
+		}
+		c.metrics = map[int]*LevelMetrics{
+			c.outputLevel.level: metrics,
 		}
 		return ve, nil, nil
 	}
@@ -1358,6 +1776,51 @@ func (d *DB) runCompaction(
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	if bounds := c.subcompactionBoundaries(d.opts.Experimental.MaxSubcompactions); len(bounds) > 0 {
+		ve, pendingOutputs, retErr = d.runSubcompactions(jobID, c, bounds, pacer, snapshots)
+	} else {
+		ve, pendingOutputs, retErr = d.writeCompactionOutputs(jobID, c, pacer, snapshots)
+	}
+	if retErr != nil {
+		return nil, pendingOutputs, retErr
+	}
+
+	for _, cl := range c.inputs {
+		for _, f := range cl.files {
+			ve.DeletedFiles[deletedFileEntry{
+				Level:   cl.level,
+				FileNum: f.FileNum,
+			}] = true
+		}
+	}
+
+	if err := d.dataDir.Sync(); err != nil {
+		return nil, pendingOutputs, markWriteError(err)
+	}
+	return ve, pendingOutputs, nil
+}
+
+// writeCompactionOutputs iterates over the inputs of the compaction (or
+// subcompaction) c and writes its output sstables. It returns a version edit
+// containing the new files, and sets c.metrics. d.mu must not be held when
+// calling this.
+func (d *DB) writeCompactionOutputs(
+	jobID int, c *compaction, pacer pacer, snapshots []uint64,
+) (ve *versionEdit, pendingOutputs []FileNum, retErr error) {
+	defer func() {
+		if retErr != nil {
+			pendingOutputs = nil
+		}
+	}()
+
+	// Range keys are not processed by the compaction iterator. They are read
+	// in their entirety up front and added to the output tables as each is
+	// finished.
+	rangeKeys, err := d.compactionRangeKeys(c, snapshots)
+	if err != nil {
+		return nil, pendingOutputs, err
+	}
+
 	iiter, err := c.newInputIter(d.newIters)
 	if err != nil {
 		return nil, pendingOutputs, err
@@ -1365,6 +1828,9 @@ func (d *DB) runCompaction(
 	allowZeroSeqNum := c.allowZeroSeqNum(iiter)
 	iter := newCompactionIter(c.cmp, d.merge, iiter, snapshots, &c.rangeDelFrag,
 		allowZeroSeqNum, c.elideTombstone, c.elideRangeTombstone)
+	if d.opts.CompactionFilter != nil && len(c.flushing) == 0 {
+		iter.filter = d.opts.CompactionFilter(c.outputLevel.level)
+	}
 
 	var (
 		filenames []string
@@ -1409,6 +1875,9 @@ func (d *DB) runCompaction(
 		d.mu.Unlock()
 
 		filename := base.MakeFilename(d.opts.FS, d.dirname, fileTypeTable, fileNum)
+		if d.outputPlacement(c) == PlacementSecondary {
+			d.tieredFS.placeOnSecondary(filename)
+		}
 		file, err := d.opts.FS.Create(filename)
 		if err != nil {
 			return err
@@ -1426,6 +1895,13 @@ func (d *DB) runCompaction(
 		file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
 			BytesPerSync: d.opts.BytesPerSync,
 		})
+		if d.opts.RateLimiter != nil {
+			var stats *pacingStats
+			if c.flushing == nil {
+				stats = &d.compactionPacing
+			}
+			file = newRateLimitedFile(file, d.opts.RateLimiter, c.flushing != nil, stats)
+		}
 		filenames = append(filenames, filename)
 		cacheOpts := private.SSTableCacheOpts(d.cacheID, fileNum).(sstable.WriterOption)
 		internalTableOpt := private.SSTableInternalTableOpt.(sstable.WriterOption)
@@ -1435,13 +1911,14 @@ func (d *DB) runCompaction(
 			Level: c.outputLevel.level,
 			Meta: &fileMetadata{
 				FileNum:      fileNum,
-				CreationTime: time.Now().Unix(),
+				CreationTime: d.timeNow().Unix(),
 			},
 		})
 		return nil
 	}
 
-	splittingFlush := c.startLevel.level < 0 && c.outputLevel.level == 0 && d.opts.Experimental.FlushSplitBytes > 0
+	splittingFlush := c.startLevel.level < 0 && c.outputLevel.level == 0 &&
+		(d.opts.Experimental.FlushSplitBytes > 0 || d.opts.Experimental.FlushSplitHints != nil)
 
 	// finishOutput is called for an sstable with the first key of the next sstable, and for the
 	// last sstable with an empty key.
@@ -1460,6 +1937,33 @@ func (d *DB) runCompaction(
 			}
 		}
 
+		// Add the range keys which start before key. A range key which
+		// extends past key is truncated, and its remainder is added to the
+		// next sstable.
+		n := 0
+		for n < len(rangeKeys) && (key == nil || d.cmp(rangeKeys[n].Start.UserKey, key) < 0) {
+			n++
+		}
+		var remainder []rangekey.Key
+		for _, k := range rangeKeys[:n] {
+			if tw == nil {
+				if err := newOutput(); err != nil {
+					return err
+				}
+			}
+			end := k.End
+			if key != nil && d.cmp(end, key) > 0 {
+				end = key
+				rem := k
+				rem.Start = base.InternalKey{UserKey: key, Trailer: k.Start.Trailer}
+				remainder = append(remainder, rem)
+			}
+			if err := tw.AddRangeKey(k.Start, end, k.Suffix, k.Value); err != nil {
+				return err
+			}
+		}
+		rangeKeys = append(remainder, rangeKeys[n:]...)
+
 		if tw == nil {
 			return nil
 		}
@@ -1479,13 +1983,12 @@ func (d *DB) runCompaction(
 		meta.SmallestSeqNum = writerMeta.SmallestSeqNum
 		meta.LargestSeqNum = writerMeta.LargestSeqNum
 		meta.MarkedForCompaction = writerMeta.MarkedForCompaction
+		meta.HasRangeKeys = writerMeta.Properties.NumRangeKeys > 0
 		// If the file didn't contain any range deletions, we can fill its
 		// table stats now, avoiding unnecessarily loading the table later.
 		if writerMeta.Properties.NumRangeDeletions == 0 {
-			meta.Stats = manifest.TableStats{
-				Valid:                       true,
-				RangeDeletionsBytesEstimate: 0,
-			}
+			meta.Stats = propertiesTableStats(&writerMeta.Properties)
+			meta.Stats.Valid = true
 		}
 
 		if c.flushing == nil {
@@ -1544,6 +2047,14 @@ func (d *DB) runCompaction(
 					writerMeta.SmallestRange.SetSeqNum(prevMeta.Largest.SeqNum() - 1)
 				}
 			}
+			// The same applies to a range key which was truncated at the
+			// boundary with the previous table.
+			if writerMeta.SmallestRangeKey.UserKey != nil &&
+				d.cmp(writerMeta.SmallestRangeKey.UserKey, prevMeta.Largest.UserKey) == 0 &&
+				prevMeta.Largest.SeqNum() <= writerMeta.SmallestRangeKey.SeqNum() &&
+				prevMeta.Largest.SeqNum() > 0 {
+				writerMeta.SmallestRangeKey.SetSeqNum(prevMeta.Largest.SeqNum() - 1)
+			}
 		}
 
 		if key != nil && writerMeta.LargestRange.UserKey != nil {
@@ -1608,7 +2119,7 @@ func (d *DB) runCompaction(
 	// to a grandparent file largest key, or nil. Taken together, these
 	// progress guarantees ensure that eventually the input iterator will be
 	// exhausted and the range tombstone fragments will all be flushed.
-	for key, val := iter.First(); key != nil || !c.rangeDelFrag.Empty(); {
+	for key, val := iter.First(); key != nil || !c.rangeDelFrag.Empty() || len(rangeKeys) > 0; {
 		var limit []byte
 		if splittingFlush {
 			// For flushes being split across multiple sstables, call
@@ -1639,8 +2150,12 @@ func (d *DB) runCompaction(
 		} else if c.rangeDelFrag.Empty() {
 			// In this case, `limit` will be a larger user key than `key.UserKey`, or
 			// nil. In either case, the inner loop will execute at least once to
-			// process `key`, and the input iterator will be advanced.
-			limit = c.findGrandparentLimit(key.UserKey)
+			// process `key`, and the input iterator will be advanced. If only
+			// range keys remain, `limit` is nil and they are all written to the
+			// final output file.
+			if key != nil {
+				limit = c.findGrandparentLimit(key.UserKey)
+			}
 		} else {
 			// There is a range tombstone spanning from the last file into the
 			// current one. Therefore this file's smallest boundary will overlap the
@@ -1712,6 +2227,9 @@ func (d *DB) runCompaction(
 				limit = nil
 			}
 
+			if c.cancelled() {
+				return nil, pendingOutputs, ErrCancelledCompaction
+			}
 			atomic.StoreUint64(c.atomicBytesIterated, c.bytesIterated)
 			if err := pacer.maybeThrottle(c.bytesIterated); err != nil {
 				return nil, pendingOutputs, err
@@ -1751,23 +2269,24 @@ func (d *DB) runCompaction(
 			}
 			if tw == nil {
 				if err := newOutput(); err != nil {
-					return nil, pendingOutputs, err
+					return nil, pendingOutputs, markWriteError(err)
 				}
 			}
 			if err := tw.Add(*key, val); err != nil {
-				return nil, pendingOutputs, err
+				return nil, pendingOutputs, markWriteError(err)
 			}
 			prevPointSeqNum = key.SeqNum()
 		}
 
 		switch {
-		case key == nil && prevPointSeqNum == 0 && !c.rangeDelFrag.Empty():
+		case key == nil && prevPointSeqNum == 0 && (!c.rangeDelFrag.Empty() || len(rangeKeys) > 0):
 			// We ran out of keys and the last key added to the sstable has a zero
-			// seqnum and there are buffered range tombstones, so we're unable to use
-			// the grandparent/flush limit for the sstable boundary. See the example in the
-			// in the loop above with range tombstones straddling sstables.
+			// seqnum and there are buffered range tombstones or range keys, so
+			// we're unable to use the grandparent/flush limit for the sstable
+			// boundary. See the example in the in the loop above with range
+			// tombstones straddling sstables.
 			limit = nil
-		case key == nil && splittingFlush && !c.rangeDelFrag.Empty():
+		case key == nil && splittingFlush && (!c.rangeDelFrag.Empty() || len(rangeKeys) > 0):
 			// We ran out of keys with flush splits enabled, and have remaining
 			// buffered range tombstones. Set limit to nil so all range
 			// tombstones get flushed in the current sstable. Consider this
@@ -1801,22 +2320,9 @@ func (d *DB) runCompaction(
 		}
 
 		if err := finishOutput(limit); err != nil {
-			return nil, pendingOutputs, err
+			return nil, pendingOutputs, markWriteError(err)
 		}
 	}
-
-	for _, cl := range c.inputs {
-		for _, f := range cl.files {
-			ve.DeletedFiles[deletedFileEntry{
-				Level:   cl.level,
-				FileNum: f.FileNum,
-			}] = true
-		}
-	}
-
-	if err := d.dataDir.Sync(); err != nil {
-		return nil, pendingOutputs, err
-	}
 	return ve, pendingOutputs, nil
 }
 
@@ -1832,7 +2338,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 
 	liveFileNums := make(map[FileNum]struct{})
 	d.mu.versions.addLiveFileNums(liveFileNums)
-	minUnflushedLogNum := d.mu.versions.minUnflushedLogNum
+	minUnflushedLogNum := d.minUnflushedLogNumLocked()
 	manifestFileNum := d.mu.versions.manifestFileNum
 
 	var obsoleteLogs []FileNum
@@ -1847,7 +2353,8 @@ func (d *DB) scanObsoleteFiles(list []string) {
 		}
 		switch fileType {
 		case fileTypeLog:
-			if fileNum >= minUnflushedLogNum {
+			// The logs listed for a keyspace belong to its parent.
+			if fileNum >= minUnflushedLogNum || d.keyspace != nil {
 				continue
 			}
 			obsoleteLogs = append(obsoleteLogs, fileNum)
@@ -1961,12 +2468,13 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	}()
 
 	var obsoleteLogs []FileNum
+	minUnflushedLogNum := d.minUnflushedLogNumLocked()
 	for i := range d.mu.log.queue {
-		// NB: d.mu.versions.minUnflushedLogNum is the log number of the earliest
-		// log that has not had its contents flushed to an sstable. We can recycle
-		// the prefix of d.mu.log.queue with log numbers less than
-		// minUnflushedLogNum.
-		if d.mu.log.queue[i] >= d.mu.versions.minUnflushedLogNum {
+		// NB: minUnflushedLogNum is the log number of the earliest log that has
+		// not had its contents flushed to an sstable, by either the DB or its
+		// keyspaces. We can recycle the prefix of d.mu.log.queue with log
+		// numbers less than minUnflushedLogNum.
+		if d.mu.log.queue[i] >= minUnflushedLogNum {
 			obsoleteLogs = d.mu.log.queue[:i]
 			d.mu.log.queue = d.mu.log.queue[i:]
 			d.mu.versions.metrics.WAL.Files -= int64(len(obsoleteLogs))
@@ -1976,9 +2484,48 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 
 	obsoleteTables = d.mu.versions.obsoleteTables
 	d.mu.versions.obsoleteTables = nil
+	// External tables reside on SharedFS and are never deleted, though they
+	// must be evicted from the table cache. Neither are the tables of a
+	// follower, which are owned by its primary.
+	var obsoleteExternal []FileNum
+	if len(d.mu.versions.externalTables) > 0 || d.follower != nil {
+		n := 0
+		for _, fileNum := range obsoleteTables {
+			if _, ok := d.mu.versions.externalTables[fileNum]; ok || d.follower != nil {
+				delete(d.mu.versions.externalTables, fileNum)
+				delete(d.mu.versions.zombieTables, fileNum)
+				obsoleteExternal = append(obsoleteExternal, fileNum)
+				continue
+			}
+			obsoleteTables[n] = fileNum
+			n++
+		}
+		obsoleteTables = obsoleteTables[:n]
+	}
+	// The sizes of the obsolete tables are needed to pace their deletion. The
+	// sizes are recorded in zombieTables, which is updated when this method
+	// returns, except for the tables found to be obsolete when the DB was
+	// opened.
+	var tableSizes map[FileNum]uint64
+	if d.deletionPacer != nil {
+		tableSizes = make(map[FileNum]uint64, len(obsoleteTables))
+		for _, fileNum := range obsoleteTables {
+			if size, ok := d.mu.versions.zombieTables[fileNum]; ok {
+				tableSizes[fileNum] = size
+			}
+		}
+	}
 
-	obsoleteManifests := d.mu.versions.obsoleteManifests
-	d.mu.versions.obsoleteManifests = nil
+	// The most recent NumPrevManifest obsolete manifests are retained. The
+	// obsolete manifests are sorted by file number, so the oldest are deleted.
+	var obsoleteManifests []FileNum
+	if n := len(d.mu.versions.obsoleteManifests) - d.opts.NumPrevManifest; n > 0 {
+		obsoleteManifests = d.mu.versions.obsoleteManifests[:n:n]
+		d.mu.versions.obsoleteManifests = d.mu.versions.obsoleteManifests[n:]
+		if len(d.mu.versions.obsoleteManifests) == 0 {
+			d.mu.versions.obsoleteManifests = nil
+		}
+	}
 
 	obsoleteOptions := d.mu.versions.obsoleteOptions
 	d.mu.versions.obsoleteOptions = nil
@@ -1988,6 +2535,10 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	for _, fileNum := range obsoleteExternal {
+		d.tableCache.evict(fileNum)
+	}
+
 	files := [4]struct {
 		fileType fileType
 		obsolete []FileNum
@@ -1998,6 +2549,7 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 		{fileTypeOptions, obsoleteOptions},
 	}
 	_, noRecycle := d.opts.Cleaner.(base.NeedsFileContents)
+	var pacedTables []obsoleteTable
 	for _, f := range files {
 		// We sort to make the order of deletions deterministic, which is nice for
 		// tests.
@@ -2017,9 +2569,27 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 			}
 
 			path := base.MakeFilename(d.opts.FS, dir, f.fileType, fileNum)
+			if f.fileType == fileTypeTable && d.deletionPacer != nil {
+				size, ok := tableSizes[fileNum]
+				if !ok {
+					if info, err := d.opts.FS.Stat(path); err == nil {
+						size = uint64(info.Size())
+					}
+				}
+				pacedTables = append(pacedTables, obsoleteTable{
+					jobID:   jobID,
+					path:    path,
+					fileNum: fileNum,
+					size:    size,
+				})
+				continue
+			}
 			d.deleteObsoleteFile(f.fileType, jobID, path, fileNum)
 		}
 	}
+	if d.deletionPacer != nil {
+		d.deletionPacer.enqueue(pacedTables)
+	}
 }
 
 func (d *DB) maybeScheduleObsoleteTableDeletion() {
@@ -0,0 +1,43 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// CompactionFilterDecision is the decision returned by a CompactionFilter for
+// a key/value pair.
+type CompactionFilterDecision int
+
+const (
+	// CompactionFilterKeep retains the key/value pair unchanged.
+	CompactionFilterKeep CompactionFilterDecision = iota
+	// CompactionFilterRemove removes the key. The key is replaced by a deletion
+	// tombstone in the compaction output so that older versions of the key in
+	// lower levels remain shadowed. The tombstone is elided when it is known
+	// not to shadow anything.
+	CompactionFilterRemove
+	// CompactionFilterChangeValue replaces the value of the key with the value
+	// returned by the filter.
+	CompactionFilterChangeValue
+)
+
+// CompactionFilter allows a user to remove or modify key/value pairs as they
+// are rewritten by compactions. A typical use is removing entries whose TTL
+// has expired.
+//
+// The filter is only invoked on SET entries which are not visible to any open
+// snapshot: keys which are still visible to a snapshot are always retained so
+// that filtering does not change the contents of the snapshot. The filter is
+// not invoked on MERGE or DELETE entries, nor on the entries written by
+// flushes, so a filtered key may be seen by the filter again in a later
+// compaction if it is retained.
+//
+// A CompactionFilter is used by a single compaction and does not need to be
+// safe for concurrent use.
+type CompactionFilter interface {
+	// Filter is invoked with the user key and value of an entry written to the
+	// compaction output. If the returned decision is
+	// CompactionFilterChangeValue, newValue is used as the new value of the
+	// key. newValue must remain valid until the next call to Filter.
+	Filter(key, value []byte) (decision CompactionFilterDecision, newValue []byte)
+}
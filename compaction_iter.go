@@ -9,6 +9,7 @@ import (
 	"sort"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/bytealloc"
 	"github.com/cockroachdb/pebble/internal/rangedel"
 )
@@ -44,7 +45,9 @@ import (
 // internal iterator collapsing MERGE operations for the same key until it
 // encounters a SET or DELETE operation. For example, the keys a.MERGE.4,
 // a.MERGE.3, a.MERGE.2 will be collapsed to a.MERGE.4 and the values will be
-// merged using the specified Merger.
+// merged using the specified Merger. Such a merge is partial, as it does not
+// include the older values of the key, and is finished with
+// PartialValueMerger.PartialFinish if the Merger supports it.
 //
 // An interesting case here occurs when MERGE is combined with SET. Consider
 // the entries a.MERGE.3 and a.SET.2. The collapsed key will be a.SET.3. The
@@ -177,6 +180,8 @@ type compactionIter struct {
 	allowZeroSeqNum     bool
 	elideTombstone      func(key []byte) bool
 	elideRangeTombstone func(start, end []byte) bool
+	// The user provided compaction filter, if any. See CompactionFilter.
+	filter CompactionFilter
 }
 
 func newCompactionIter(
@@ -302,9 +307,15 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 		case InternalKeyKindSet:
 			i.saveKey()
 			i.value = i.iterValue
+			if i.applyCompactionFilter() {
+				i.skipInStripe()
+				continue
+			}
 			i.valid = true
 			i.skip = true
-			i.maybeZeroSeqnum(i.curSnapshotIdx)
+			if i.key.Kind() == InternalKeyKindSet {
+				i.maybeZeroSeqnum(i.curSnapshotIdx)
+			}
 			return &i.key, i.value
 
 		case InternalKeyKindMerge:
@@ -318,7 +329,10 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 				change = i.mergeNext(valueMerger)
 			}
 			if i.err == nil {
-				i.value, i.valueCloser, i.err = valueMerger.Finish()
+				// The merge is partial if it did not reach a SET or deletion of the
+				// key, in which case the result remains a merge operand.
+				includesBase := i.key.Kind() != InternalKeyKindMerge
+				i.value, i.valueCloser, i.err = base.FinishValueMerger(valueMerger, includesBase)
 			}
 			if i.err == nil {
 				// A non-skippable entry does not necessarily cover later merge
@@ -534,6 +548,30 @@ func (i *compactionIter) singleDeleteNext() bool {
 	}
 }
 
+// applyCompactionFilter invokes the compaction filter, if any, on the current
+// SET entry. It returns true if the entry was removed and no longer needs to
+// be output. If the entry was removed but the resulting tombstone cannot be
+// elided, the current key is converted to a deletion tombstone.
+func (i *compactionIter) applyCompactionFilter() (elided bool) {
+	// Only filter keys which are newer than every snapshot. Filtering a key
+	// which is visible to a snapshot would change the view of the snapshot.
+	if i.filter == nil || i.curSnapshotIdx != len(i.snapshots) {
+		return false
+	}
+	decision, newValue := i.filter.Filter(i.key.UserKey, i.value)
+	switch decision {
+	case CompactionFilterRemove:
+		if i.curSnapshotIdx == 0 && i.elideTombstone(i.key.UserKey) {
+			return true
+		}
+		i.key.SetKind(InternalKeyKindDelete)
+		i.value = nil
+	case CompactionFilterChangeValue:
+		i.value = newValue
+	}
+	return false
+}
+
 func (i *compactionIter) saveKey() {
 	i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
 	i.key.UserKey = i.keyBuf
@@ -47,15 +47,30 @@ func TestSnapshotIndex(t *testing.T) {
 	}
 }
 
+// testCompactionFilter removes keys with the value "remove" and changes the
+// value "change" to "changed".
+type testCompactionFilter struct{}
+
+func (testCompactionFilter) Filter(key, value []byte) (CompactionFilterDecision, []byte) {
+	switch string(value) {
+	case "remove":
+		return CompactionFilterRemove, nil
+	case "change":
+		return CompactionFilterChangeValue, []byte("changed")
+	}
+	return CompactionFilterKeep, nil
+}
+
 func TestCompactionIter(t *testing.T) {
 	var keys []InternalKey
 	var vals [][]byte
 	var snapshots []uint64
 	var elideTombstones bool
 	var allowZeroSeqnum bool
+	var filter CompactionFilter
 
 	newIter := func() *compactionIter {
-		return newCompactionIter(
+		iter := newCompactionIter(
 			DefaultComparer.Compare,
 			DefaultMerger.Merge,
 			&fakeIter{keys: keys, vals: vals},
@@ -69,6 +84,8 @@ func TestCompactionIter(t *testing.T) {
 				return elideTombstones
 			},
 		)
+		iter.filter = filter
+		return iter
 	}
 
 	datadriven.RunTest(t, "testdata/compaction_iter", func(d *datadriven.TestData) string {
@@ -87,6 +104,7 @@ func TestCompactionIter(t *testing.T) {
 			snapshots = snapshots[:0]
 			elideTombstones = false
 			allowZeroSeqnum = false
+			filter = nil
 			for _, arg := range d.CmdArgs {
 				switch arg.Key {
 				case "snapshots":
@@ -109,6 +127,14 @@ func TestCompactionIter(t *testing.T) {
 					if err != nil {
 						return err.Error()
 					}
+				case "filter":
+					enabled, err := strconv.ParseBool(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
+					if enabled {
+						filter = testCompactionFilter{}
+					}
 				default:
 					return fmt.Sprintf("%s: unknown arg: %s", d.Cmd, arg.Key)
 				}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/humanize"
@@ -23,6 +24,8 @@ type compactionEnv struct {
 	bytesCompacted          *uint64
 	earliestUnflushedSeqNum uint64
 	inProgressCompactions   []compactionInfo
+	// now is the current time, used to pick periodic compactions.
+	now time.Time
 }
 
 type compactionPicker interface {
@@ -666,12 +669,62 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (c *compaction) {
 		}
 	}
 
+	// Check for periodic compactions. These are the lowest priority
+	// compactions.
+	if p.opts.PeriodicCompactionPeriod > 0 {
+		if c := p.pickPeriodic(env); c != nil {
+			return c
+		}
+	}
+
 	// TODO(peter): When a snapshot is released, we may need to compact tables at
 	// the bottom level in order to free up entries that were pinned by the
 	// snapshot.
 	return nil
 }
 
+// pickPeriodic picks a compaction of the oldest table which was created more
+// than Options.PeriodicCompactionPeriod ago. A table above the bottommost
+// level is compacted into the next level, where it may be moved rather than
+// rewritten; the table is then picked again until it reaches the bottommost
+// level, where it is rewritten in place.
+func (p *compactionPickerByScore) pickPeriodic(env compactionEnv) (c *compaction) {
+	cutoff := env.now.Add(-p.opts.PeriodicCompactionPeriod).Unix()
+	level, file := -1, -1
+	var oldest int64
+	for l := range p.vers.Levels {
+		for i, f := range p.vers.Levels[l] {
+			if f.Compacting || f.CreationTime == 0 || f.CreationTime > cutoff {
+				continue
+			}
+			if level == -1 || f.CreationTime < oldest {
+				level, file, oldest = l, i, f.CreationTime
+			}
+		}
+	}
+	if level == -1 {
+		return nil
+	}
+
+	if level == numLevels-1 {
+		c = newCompaction(p.opts, p.vers, level, p.baseLevel, env.bytesCompacted)
+		c.startLevel.files = p.vers.Levels[level][file : file+1]
+		c.smallest, c.largest = manifest.KeyRange(c.cmp, c.startLevel.files, nil)
+		c.setupInuseKeyRanges()
+	} else {
+		info := pickedCompactionInfo{level: level, outputLevel: level + 1, file: file}
+		if level == 0 {
+			info.outputLevel = p.baseLevel
+		}
+		c = pickAutoHelper(env, p.opts, p.vers, info, p.baseLevel)
+	}
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if c == nil || inputAlreadyCompacting(c) {
+		return nil
+	}
+	return c
+}
+
 func pickAutoHelper(
 	env compactionEnv, opts *Options, vers *version, cInfo pickedCompactionInfo, baseLevel int,
 ) (c *compaction) {
@@ -883,7 +936,7 @@ func (p *compactionPickerByScore) pickManual(
 	}
 	// TODO(peter): The conflictsWithInProgress call should no longer be
 	// necessary, but TestManualCompaction currently expects it.
-	if conflictsWithInProgress(manual.level, outputLevel, env.inProgressCompactions) {
+	if conflictsWithInProgress(manual, outputLevel, env.inProgressCompactions, p.opts.Comparer.Compare) {
 		return nil, true
 	}
 	c = pickManualHelper(env, p.opts, manual, p.vers, p.baseLevel)
@@ -931,17 +984,33 @@ func inputAlreadyCompacting(c *compaction) bool {
 }
 
 func conflictsWithInProgress(
-	level int, outputLevel int, inProgressCompactions []compactionInfo,
+	manual *manualCompaction, outputLevel int, inProgressCompactions []compactionInfo, cmp Compare,
 ) bool {
 	for _, c := range inProgressCompactions {
+		conflicts := c.outputLevel == manual.level || c.outputLevel == outputLevel
 		for _, in := range c.inputs {
-			if in.level == level || in.level == outputLevel {
-				return true
+			if in.level == manual.level || in.level == outputLevel {
+				conflicts = true
 			}
 		}
-		if c.outputLevel == level || c.outputLevel == outputLevel {
+		if conflicts && (!manual.split || inProgressOverlaps(c, manual, cmp)) {
 			return true
 		}
 	}
 	return false
 }
+
+// inProgressOverlaps returns true if the key range of the in-progress
+// compaction overlaps the key range of the manual compaction.
+func inProgressOverlaps(c compactionInfo, manual *manualCompaction, cmp Compare) bool {
+	var files []*fileMetadata
+	for _, in := range c.inputs {
+		files = append(files, in.files...)
+	}
+	if len(files) == 0 {
+		return true
+	}
+	smallest, largest := manifest.KeyRange(cmp, files, nil)
+	return cmp(smallest.UserKey, manual.end.UserKey) <= 0 &&
+		cmp(largest.UserKey, manual.start.UserKey) >= 0
+}
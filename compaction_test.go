@@ -8,12 +8,14 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"math/rand"
 	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -919,6 +921,18 @@ func TestCompaction(t *testing.T) {
 	}
 }
 
+// newManualCompactionTestOptions returns the options used by the manual
+// compaction tests. Automatic compactions are disabled so that delete-only
+// compactions don't race with the manual compactions under test.
+func newManualCompactionTestOptions(fs vfs.FS) *Options {
+	opts := &Options{
+		FS:         fs,
+		DebugCheck: DebugCheckLevels,
+	}
+	opts.DisableAutomaticCompactions = true
+	return opts
+}
+
 func TestManualCompaction(t *testing.T) {
 	var mem vfs.FS
 	var d *DB
@@ -935,10 +949,7 @@ func TestManualCompaction(t *testing.T) {
 		require.NoError(t, mem.MkdirAll("ext", 0755))
 
 		var err error
-		d, err = Open("", &Options{
-			FS:         mem,
-			DebugCheck: DebugCheckLevels,
-		})
+		d, err = Open("", newManualCompactionTestOptions(mem))
 		require.NoError(t, err)
 	}
 	reset()
@@ -979,7 +990,7 @@ func TestManualCompaction(t *testing.T) {
 			}
 
 			var err error
-			if d, err = runDBDefineCmd(td, nil /* options */); err != nil {
+			if d, err = runDBDefineCmd(td, newManualCompactionTestOptions(nil)); err != nil {
 				return err.Error()
 			}
 			mem = d.opts.FS
@@ -1907,7 +1918,7 @@ func TestCompactFlushQueuedMemTable(t *testing.T) {
 		}
 	}
 
-	require.NoError(t, d.Compact([]byte("a"), []byte("a")))
+	require.NoError(t, d.Compact([]byte("a"), []byte("a"), false))
 	require.NoError(t, d.Close())
 }
 
@@ -1933,10 +1944,464 @@ func TestCompactFlushQueuedLargeBatch(t *testing.T) {
 	// batch and placed in the flushable queue.
 	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), d.largeBatchThreshold), nil))
 
-	require.NoError(t, d.Compact([]byte("a"), []byte("a")))
+	require.NoError(t, d.Compact([]byte("a"), []byte("a"), false))
 	require.NoError(t, d.Close())
 }
 
+func TestCompactParallel(t *testing.T) {
+	var mu sync.Mutex
+	var compactions int
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		EventListener: EventListener{
+			CompactionBegin: func(info CompactionInfo) {
+				mu.Lock()
+				compactions++
+				mu.Unlock()
+			},
+		},
+		L0CompactionThreshold:    100,
+		L0StopWritesThreshold:    100,
+		MaxConcurrentCompactions: 4,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write several disjoint key ranges, flushing each to its own sstable.
+	prefixes := []string{"a", "c", "e", "g"}
+	write := func(value string) {
+		for _, prefix := range prefixes {
+			for i := 0; i < 10; i++ {
+				key := []byte(fmt.Sprintf("%s%02d", prefix, i))
+				require.NoError(t, d.Set(key, []byte(value), nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+	}
+	write("1")
+	require.NoError(t, d.Compact([]byte("a"), []byte("h"), true))
+	write("2")
+
+	mu.Lock()
+	compactions = 0
+	mu.Unlock()
+	require.NoError(t, d.Compact([]byte("a"), []byte("h"), true))
+
+	// The compaction was split into a compaction per key range, and all of
+	// the data ends up in the bottommost level.
+	mu.Lock()
+	require.Equal(t, len(prefixes), compactions)
+	mu.Unlock()
+	d.mu.Lock()
+	v := d.mu.versions.currentVersion()
+	for level := 0; level < numLevels-1; level++ {
+		require.Empty(t, v.Levels[level], "L%d", level)
+	}
+	require.Len(t, v.Levels[numLevels-1], len(prefixes))
+	d.mu.Unlock()
+
+	for _, prefix := range prefixes {
+		for i := 0; i < 10; i++ {
+			value, closer, err := d.Get([]byte(fmt.Sprintf("%s%02d", prefix, i)))
+			require.NoError(t, err)
+			require.Equal(t, "2", string(value))
+			require.NoError(t, closer.Close())
+		}
+	}
+}
+
+func TestDeleteOnlyCompaction(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []TableInfo
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		EventListener: EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(info.Output.Tables) == 0 {
+					for _, in := range info.Input {
+						deleted = append(deleted, in.Tables...)
+					}
+				}
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	waitForCompactions := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.waitTableStats()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	tables := func(level int) []FileNum {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var fileNums []FileNum
+		for _, m := range d.mu.versions.currentVersion().Levels[level] {
+			fileNums = append(fileNums, m.FileNum)
+		}
+		return fileNums
+	}
+
+	// Write a table to the bottommost level.
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	bottom := tables(numLevels - 1)
+	require.Len(t, bottom, 1)
+
+	// Delete the table's keys with a range tombstone in L0. The snapshot can
+	// observe the keys, so the table is not deleted.
+	snap := d.NewSnapshot()
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("d"), nil))
+	require.NoError(t, d.Set([]byte("x"), []byte("x"), nil))
+	require.NoError(t, d.Flush())
+	waitForCompactions()
+	require.Equal(t, bottom, tables(numLevels-1))
+
+	// Releasing the snapshot allows the table to be deleted without reading
+	// or rewriting it.
+	require.NoError(t, snap.Close())
+	waitForCompactions()
+	require.Empty(t, tables(numLevels-1))
+	require.Len(t, tables(0), 1)
+	mu.Lock()
+	require.Len(t, deleted, 1)
+	require.Equal(t, bottom[0], deleted[0].FileNum)
+	mu.Unlock()
+
+	_, _, err = d.Get([]byte("b"))
+	require.Equal(t, ErrNotFound, err)
+	value, closer, err := d.Get([]byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(value))
+	require.NoError(t, closer.Close())
+}
+
+func TestPeriodicCompaction(t *testing.T) {
+	var compactions int32
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		EventListener: EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				atomic.AddInt32(&compactions, 1)
+			},
+		},
+		PeriodicCompactionPeriod: time.Hour,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	now := time.Unix(1000000, 0).UnixNano()
+	d.mu.Lock()
+	d.timeNow = func() time.Time {
+		return time.Unix(0, atomic.LoadInt64(&now))
+	}
+	d.mu.Unlock()
+
+	tables := func() [numLevels][]fileMetadata {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		var levels [numLevels][]fileMetadata
+		for level, files := range d.mu.versions.currentVersion().Levels {
+			for _, m := range files {
+				levels[level] = append(levels[level], *m)
+			}
+		}
+		return levels
+	}
+	advance := func(dur time.Duration) {
+		atomic.AddInt64(&now, int64(dur))
+		d.mu.Lock()
+		d.maybeScheduleCompaction()
+		d.mu.Unlock()
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	levels := tables()
+	require.Len(t, levels[0], 2)
+	created := levels[0][0].CreationTime
+
+	// Tables younger than the period are not compacted.
+	advance(30 * time.Minute)
+	require.Len(t, tables()[0], 2)
+	require.EqualValues(t, 0, atomic.LoadInt32(&compactions))
+
+	// Once the tables are older than the period they are compacted into the
+	// bottommost level. The move into the bottommost level doesn't rewrite
+	// the tables, so they are picked again and rewritten in place.
+	advance(time.Hour)
+	levels = tables()
+	require.Empty(t, levels[0])
+	bottom := levels[numLevels-1]
+	require.Len(t, bottom, 2)
+	for _, m := range bottom {
+		require.True(t, m.CreationTime > created)
+		require.Equal(t, time.Unix(0, atomic.LoadInt64(&now)).Unix(), m.CreationTime)
+	}
+
+	// The rewritten tables aren't compacted again until they are older than
+	// the period.
+	n := atomic.LoadInt32(&compactions)
+	advance(30 * time.Minute)
+	for i, m := range tables()[numLevels-1] {
+		require.Equal(t, bottom[i].FileNum, m.FileNum)
+	}
+	require.Equal(t, n, atomic.LoadInt32(&compactions))
+	advance(time.Hour)
+	levels = tables()
+	require.Len(t, levels[numLevels-1], 2)
+	for i, m := range levels[numLevels-1] {
+		require.NotEqual(t, bottom[i].FileNum, m.FileNum)
+	}
+	require.Equal(t, n+2, atomic.LoadInt32(&compactions))
+
+	for _, k := range []string{"a", "b"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, k, string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestDisableAutomaticCompactions(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		L0CompactionThreshold:       2,
+		DisableAutomaticCompactions: true,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	levelCounts := func() [numLevels]int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		var counts [numLevels]int
+		for level, files := range d.mu.versions.currentVersion().Levels {
+			counts[level] = len(files)
+		}
+		return counts
+	}
+
+	// Flushes are performed, but the L0 tables are not compacted.
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, 4, levelCounts()[0])
+
+	// Manual compactions are performed.
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	counts := levelCounts()
+	require.Equal(t, 2, counts[0])
+	require.Equal(t, 2, counts[numLevels-1])
+
+	// Re-enabling automatic compactions schedules the pending compactions.
+	d.SetDisableAutomaticCompactions(false)
+	n := levelCounts()[0]
+	require.True(t, n < opts.L0CompactionThreshold, "L0 tables: %d", n)
+
+	// Disabling them again stops the scheduling of new compactions.
+	d.SetDisableAutomaticCompactions(true)
+	for _, k := range []string{"e", "f", "g"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, n+3, levelCounts()[0])
+}
+
+// blockingCompactionFilter blocks the first call to Filter until release is
+// closed, allowing tests to hold a compaction in progress.
+type blockingCompactionFilter struct {
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingCompactionFilter) Filter(key, value []byte) (CompactionFilterDecision, []byte) {
+	f.once.Do(func() {
+		close(f.started)
+		<-f.release
+	})
+	return CompactionFilterKeep, nil
+}
+
+func TestCancelCompactions(t *testing.T) {
+	var filter *blockingCompactionFilter
+	opts := &Options{
+		FS: vfs.NewMem(),
+		CompactionFilter: func(outputLevel int) CompactionFilter {
+			return filter
+		},
+		DisableAutomaticCompactions: true,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	levelFiles := func() [numLevels][]FileNum {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var levels [numLevels][]FileNum
+		for level, files := range d.mu.versions.currentVersion().Levels {
+			for _, m := range files {
+				levels[level] = append(levels[level], m.FileNum)
+			}
+		}
+		return levels
+	}
+	// waitCancelled waits for the cancellation of an in-progress compaction to
+	// be requested.
+	waitCancelled := func() {
+		for {
+			d.mu.Lock()
+			var cancelled bool
+			for c := range d.mu.compact.inProgress {
+				cancelled = cancelled || c.cancelled()
+			}
+			d.mu.Unlock()
+			if cancelled {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// startCompaction starts a manual compaction of [a, d] which blocks in the
+	// compaction filter until the filter is released.
+	startCompaction := func() <-chan error {
+		filter = &blockingCompactionFilter{
+			started: make(chan struct{}),
+			release: make(chan struct{}),
+		}
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- d.Compact([]byte("a"), []byte("d"), false /* parallelize */)
+		}()
+		<-filter.started
+		return errCh
+	}
+
+	// Populate L6 and L0 so that the compactions below are not trivial moves.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+	filter = &blockingCompactionFilter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	close(filter.release)
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Set([]byte("d"), []byte("d"), nil))
+	require.NoError(t, d.Flush())
+	before := levelFiles()
+
+	// Cancelling compactions of a non-overlapping range has no effect.
+	errCh := startCompaction()
+	d.CancelCompactions([]byte("e"), []byte("f"))
+	close(filter.release)
+	require.NoError(t, <-errCh)
+	require.NotEqual(t, before, levelFiles())
+
+	// Cancelling an overlapping compaction discards its outputs and leaves the
+	// LSM unchanged.
+	require.NoError(t, d.Set([]byte("a"), []byte("a2"), nil))
+	require.NoError(t, d.Flush())
+	before = levelFiles()
+	errCh = startCompaction()
+	cancelDone := make(chan struct{})
+	go func() {
+		d.CancelCompactions([]byte("c"), nil)
+		close(cancelDone)
+	}()
+	waitCancelled()
+	close(filter.release)
+	<-cancelDone
+	require.Equal(t, ErrCancelledCompaction, <-errCh)
+	require.Equal(t, before, levelFiles())
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "a2", string(v))
+	require.NoError(t, closer.Close())
+
+	// Close cancels in-progress compactions rather than waiting for them.
+	errCh = startCompaction()
+	closeDone := make(chan error, 1)
+	go func() {
+		closeDone <- d.Close()
+	}()
+	waitCancelled()
+	close(filter.release)
+	require.NoError(t, <-closeDone)
+	require.Equal(t, ErrCancelledCompaction, <-errCh)
+}
+
+func TestDeterministic(t *testing.T) {
+	// Run the same workload against two deterministic DBs. The flushes and
+	// compactions complete before each write returns, so the DBs end up with
+	// identical LSMs.
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	run := func() string {
+		opts := &Options{
+			FS:                    vfs.NewMem(),
+			L0CompactionThreshold: 2,
+			LBaseMaxBytes:         64 << 10,
+			MemTableSize:          32 << 10,
+		}
+		opts.Experimental.Deterministic = true
+		opts.Levels = []LevelOptions{{TargetFileSize: 8 << 10}}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+
+		rng := rand.New(rand.NewSource(seed))
+		value := make([]byte, 100)
+		for i := 0; i < 5000; i++ {
+			key := []byte(fmt.Sprintf("%05d", rng.Intn(2000)))
+			rng.Read(value)
+			switch rng.Intn(10) {
+			case 0:
+				require.NoError(t, d.Delete(key, nil))
+			case 1:
+				require.NoError(t, d.DeleteRange(key, append(key, 'x'), nil))
+			default:
+				require.NoError(t, d.Set(key, value, nil))
+			}
+
+			// No background work is in progress when a write returns.
+			d.mu.Lock()
+			require.False(t, d.mu.compact.flushing)
+			require.Equal(t, 0, d.mu.compact.compactingCount)
+			require.False(t, d.mu.tableStats.loading)
+			d.mu.Unlock()
+		}
+		m := d.Metrics()
+		require.True(t, m.Flush.Count > 0)
+		require.True(t, m.Compact.Count > 0)
+
+		d.mu.Lock()
+		s := d.mu.versions.currentVersion().DebugString(base.DefaultFormatter)
+		d.mu.Unlock()
+		require.NoError(t, d.Close())
+		return s
+	}
+	require.Equal(t, run(), run())
+}
+
 // Regression test for #747. Test a problematic series of "cleaner" operations
 // that could previously lead to DB.disableFileDeletions blocking forever even
 // though no cleaning was in progress.
@@ -1984,3 +2449,230 @@ func TestCleanerCond(t *testing.T) {
 
 	require.NoError(t, d.Close())
 }
+
+func TestCompactionFilter(t *testing.T) {
+	var outputLevels []int
+	opts := &Options{
+		FS: vfs.NewMem(),
+		CompactionFilter: func(outputLevel int) CompactionFilter {
+			outputLevels = append(outputLevels, outputLevel)
+			return testCompactionFilter{}
+		},
+	}
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Populate L6 so that the compactions below are not trivial moves.
+	require.NoError(t, d.Set([]byte("a"), []byte("old"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("old"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	outputLevels = outputLevels[:0]
+
+	require.NoError(t, d.Set([]byte("a"), []byte("remove"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("change"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("keep"), nil))
+	require.NoError(t, d.Flush())
+
+	// The filter is not applied to flushes.
+	require.Empty(t, outputLevels)
+
+	// Keys visible to a snapshot are not filtered.
+	snap := d.NewSnapshot()
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.Equal(t, []int{numLevels - 1}, outputLevels)
+	for key, expected := range map[string]string{"a": "remove", "b": "change", "c": "keep"} {
+		v, closer, err := d.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(v))
+		require.NoError(t, closer.Close())
+	}
+
+	// Once the snapshot is released, the keys are filtered by the next
+	// compaction.
+	require.NoError(t, snap.Close())
+	outputLevels = outputLevels[:0]
+	require.NoError(t, d.Set([]byte("bb"), []byte("keep"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.Equal(t, []int{numLevels - 1}, outputLevels)
+	_, _, err = d.Get([]byte("a"))
+	require.Equal(t, ErrNotFound, err)
+	for key, expected := range map[string]string{"b": "changed", "c": "keep"} {
+		v, closer, err := d.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestCompactionSubcompactionBoundaries(t *testing.T) {
+	cmp := DefaultComparer.Compare
+	var c *compaction
+
+	datadriven.RunTest(t, "testdata/compaction_subcompaction_boundaries",
+		func(d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "define":
+				c = &compaction{
+					cmp:    cmp,
+					inputs: []compactionLevel{{level: -1}, {level: -1}},
+				}
+				c.startLevel, c.outputLevel = &c.inputs[0], &c.inputs[1]
+				var cl *compactionLevel
+				for _, data := range strings.Split(d.Input, "\n") {
+					data = strings.TrimSpace(data)
+					if strings.HasPrefix(data, "L") {
+						level, err := strconv.Atoi(data[1:])
+						if err != nil {
+							return err.Error()
+						}
+						cl = c.startLevel
+						if cl.level != -1 {
+							cl = c.outputLevel
+						}
+						cl.level = level
+						continue
+					}
+					fields := strings.Fields(data)
+					if cl == nil || len(fields) != 2 {
+						return fmt.Sprintf("malformed table spec: %s", data)
+					}
+					parts := strings.Split(fields[0], "-")
+					if len(parts) != 2 {
+						return fmt.Sprintf("malformed table spec: %s", data)
+					}
+					size, err := strconv.ParseUint(fields[1], 10, 64)
+					if err != nil {
+						return err.Error()
+					}
+					cl.files = append(cl.files, &fileMetadata{
+						FileNum:  FileNum(len(c.startLevel.files) + len(c.outputLevel.files)),
+						Smallest: base.ParseInternalKey(parts[0]),
+						Largest:  base.ParseInternalKey(parts[1]),
+						Size:     size,
+					})
+				}
+				c.smallest, c.largest = manifest.KeyRange(cmp, c.startLevel.files, c.outputLevel.files)
+				return ""
+
+			case "subcompaction-boundaries":
+				maxSubcompactions := 1
+				c.maxOutputFileSize = 1
+				for _, arg := range d.CmdArgs {
+					var err error
+					switch arg.Key {
+					case "max-subcompactions":
+						maxSubcompactions, err = strconv.Atoi(arg.Vals[0])
+					case "target-file-size":
+						c.maxOutputFileSize, err = strconv.ParseUint(arg.Vals[0], 10, 64)
+					default:
+						return fmt.Sprintf("%s: unknown arg: %s", d.Cmd, arg.Key)
+					}
+					if err != nil {
+						return err.Error()
+					}
+				}
+				bounds := c.subcompactionBoundaries(maxSubcompactions)
+				if len(bounds) == 0 {
+					return "none\n"
+				}
+				var buf bytes.Buffer
+				for i := 0; i <= len(bounds); i++ {
+					var lower, upper []byte
+					if i > 0 {
+						lower = bounds[i-1]
+					}
+					if i < len(bounds) {
+						upper = bounds[i]
+					}
+					fmt.Fprintf(&buf, "[%s,%s):", lower, upper)
+					sc := c.newSubcompaction(lower, upper)
+					for _, cl := range sc.inputs {
+						for _, f := range cl.files {
+							fmt.Fprintf(&buf, " %s", f.FileNum)
+						}
+					}
+					fmt.Fprintf(&buf, "\n")
+				}
+				return buf.String()
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		})
+}
+
+func TestSubcompactions(t *testing.T) {
+	type ops struct {
+		db   *DB
+		snap *Snapshot
+	}
+	open := func(maxSubcompactions int) ops {
+		opts := &Options{
+			FS:         vfs.NewMem(),
+			DebugCheck: DebugCheckLevels,
+			Levels:     []LevelOptions{{TargetFileSize: 1 << 10}},
+		}
+		opts.Experimental.MaxSubcompactions = maxSubcompactions
+		opts.DisableAutomaticCompactions = true
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		return ops{db: d}
+	}
+	dbs := []ops{open(0), open(4)}
+
+	// Apply the same operations to both DBs: overwrites, merges and range
+	// deletions spread across L6 and several L0 sstables, with a snapshot
+	// which keeps some of the older versions visible.
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 500; j++ {
+			key := []byte(fmt.Sprintf("%04d", rng.Intn(2000)))
+			end := []byte(fmt.Sprintf("%04d", rng.Intn(2000)))
+			kind := rng.Intn(20)
+			value := make([]byte, rng.Intn(100))
+			rng.Read(value)
+			for _, o := range dbs {
+				switch {
+				case kind == 0:
+					require.NoError(t, o.db.DeleteRange(key, end, nil))
+				case kind < 5:
+					require.NoError(t, o.db.Merge(key, []byte(fmt.Sprint(i)), nil))
+				default:
+					require.NoError(t, o.db.Set(key, value, nil))
+				}
+			}
+		}
+		for k := range dbs {
+			require.NoError(t, dbs[k].db.Flush())
+			switch i {
+			case 1:
+				require.NoError(t, dbs[k].db.Compact([]byte("0000"), []byte("9999"), false /* parallelize */))
+			case 2:
+				dbs[k].snap = dbs[k].db.NewSnapshot()
+			}
+		}
+	}
+
+	scan := func(r Reader) string {
+		var buf bytes.Buffer
+		iter := r.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s:%s\n", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+	expected := scan(dbs[0].db)
+	expectedSnap := scan(dbs[0].snap)
+	for _, o := range dbs {
+		require.NoError(t, o.db.Compact([]byte("0000"), []byte("9999"), false /* parallelize */))
+		require.Equal(t, expected, scan(o.db))
+		require.Equal(t, expectedSnap, scan(o.snap))
+		require.NoError(t, o.snap.Close())
+		require.NoError(t, o.db.Close())
+	}
+}
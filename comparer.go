@@ -29,3 +29,40 @@ type Comparer = base.Comparer
 
 // DefaultComparer exports the base.DefaultComparer variable.
 var DefaultComparer = base.DefaultComparer
+
+// TimestampLen exports the base.TimestampLen constant.
+const TimestampLen = base.TimestampLen
+
+// MaxTimestamp exports the base.MaxTimestamp constant.
+const MaxTimestamp = base.MaxTimestamp
+
+// TimestampComparer exports the base.TimestampComparer variable.
+var TimestampComparer = base.TimestampComparer
+
+// MakeTimestampKey exports the base.MakeTimestampKey function.
+func MakeTimestampKey(dst, prefix []byte, ts uint64) []byte {
+	return base.MakeTimestampKey(dst, prefix, ts)
+}
+
+// SplitTimestampKey exports the base.SplitTimestampKey function.
+func SplitTimestampKey(key []byte) (prefix []byte, ts uint64, ok bool) {
+	return base.SplitTimestampKey(key)
+}
+
+// ReverseComparer exports the base.ReverseComparer function.
+func ReverseComparer(c *Comparer) *Comparer {
+	return base.ReverseComparer(c)
+}
+
+// CheckComparer exports the base.CheckComparer function.
+func CheckComparer(c *Comparer, keys [][]byte) error {
+	return base.CheckComparer(c, keys)
+}
+
+// SortKey exports the base.SortKey type.
+type SortKey = base.SortKey
+
+// NewCollatingComparer exports the base.NewCollatingComparer function.
+func NewCollatingComparer(name string, sortKey SortKey) *Comparer {
+	return base.NewCollatingComparer(name, sortKey)
+}
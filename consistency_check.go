@@ -0,0 +1,173 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// CheckConsistencyOptions hold the optional parameters for
+// DB.CheckConsistency.
+type CheckConsistencyOptions struct {
+	// BytesPerSecond, if positive, limits the rate at which sstables are read,
+	// bounding the impact of the check on the IO of foreground operations.
+	BytesPerSecond int64
+}
+
+// TableCheckResult is the result of checking an sstable with
+// DB.CheckConsistency.
+type TableCheckResult struct {
+	// Level is the level of the sstable.
+	Level int
+	// FileNum is the file number of the sstable.
+	FileNum FileNum
+	// Size is the size of the sstable in bytes.
+	Size uint64
+	// Err is nil if the sstable passed the check, and otherwise describes the
+	// corruption found or the error which prevented the sstable from being
+	// checked.
+	Err error
+}
+
+// CheckConsistency checks every sstable of the current version. The blocks of
+// each sstable are read from disk, bypassing the block cache, and their
+// checksums validated, and the point keys and range deletions of the sstable
+// are verified to be in order and to lie within the bounds recorded in the
+// MANIFEST. Unlike CheckLevels, only individual sstables are checked, which
+// allows the check to be run periodically against a production DB.
+//
+// CheckConsistency returns a result for each sstable checked, in level order.
+// The returned error is non-nil if any sstable failed the check, or if ctx
+// was cancelled, in which case the results of the sstables checked so far are
+// returned. The sstables being checked are not deleted until the check
+// completes, so a check must complete or be cancelled before the DB is
+// closed.
+func (d *DB) CheckConsistency(
+	ctx context.Context, opts *CheckConsistencyOptions,
+) ([]TableCheckResult, error) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	var o CheckConsistencyOptions
+	if opts != nil {
+		o = *opts
+	}
+	var limiter *rate.Limiter
+	if o.BytesPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(o.BytesPerSecond), rateLimiterChunkSize)
+	}
+
+	// Grab and reference the current readState, preventing the sstables of the
+	// current version from being deleted.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	var results []TableCheckResult
+	var retErr error
+	for level, files := range readState.current.Levels {
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			if limiter != nil {
+				for n := int64(f.Size); n > 0; n -= rateLimiterChunkSize {
+					chunk := n
+					if chunk > rateLimiterChunkSize {
+						chunk = rateLimiterChunkSize
+					}
+					if err := limiter.WaitN(ctx, int(chunk)); err != nil {
+						return results, err
+					}
+				}
+			}
+
+			err := d.tableCache.withReader(f, func(r *sstable.Reader) error {
+				return checkTable(d.opts.Comparer, f, r)
+			})
+			if err != nil && retErr == nil {
+				retErr = errors.Wrapf(err, "pebble: L%d table %s failed consistency check",
+					errors.Safe(level), f.FileNum)
+			}
+			results = append(results, TableCheckResult{
+				Level:   level,
+				FileNum: f.FileNum,
+				Size:    f.Size,
+				Err:     err,
+			})
+		}
+	}
+	return results, retErr
+}
+
+// checkTable validates the block checksums of the sstable f, read by r, and
+// verifies that its point keys and range deletions are in order and lie
+// within its bounds.
+func checkTable(comparer *Comparer, f *fileMetadata, r *sstable.Reader) error {
+	if err := r.ValidateBlockChecksums(); err != nil {
+		return err
+	}
+	cmp := comparer.Compare
+	formatKey := comparer.FormatKey
+
+	// checkKeys verifies the ordering and bounds of the keys of iter. Only the
+	// start keys of range deletions are checked.
+	checkKeys := func(iter internalIterator, kind string) (err error) {
+		defer func() {
+			err = firstError(err, iter.Close())
+		}()
+		var prev InternalKey
+		var valid bool
+		for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+			if !valid {
+				if base.InternalCompare(cmp, *key, f.Smallest) < 0 {
+					return errors.Errorf("%s %s precedes smallest key %s", kind,
+						key.Pretty(formatKey), f.Smallest.Pretty(formatKey))
+				}
+			} else if base.InternalCompare(cmp, prev, *key) >= 0 {
+				return errors.Errorf("%s out of order: %s, %s", kind,
+					prev.Pretty(formatKey), key.Pretty(formatKey))
+			}
+			prev = key.Clone()
+			valid = true
+		}
+		if valid && base.InternalCompare(cmp, prev, f.Largest) > 0 {
+			return errors.Errorf("%s %s follows largest key %s", kind,
+				prev.Pretty(formatKey), f.Largest.Pretty(formatKey))
+		}
+		return nil
+	}
+
+	// Only the keys of the backing table of a virtual table which lie within
+	// the bounds of the virtual table are checked.
+	var lower, upper []byte
+	if f.Virtual() {
+		lower, upper = virtualTableBounds(f)
+	}
+	iter, err := r.NewIter(lower, upper)
+	if err != nil {
+		return err
+	}
+	var pointIter internalIterator = iter
+	if f.Virtual() {
+		pointIter = &virtualTableIter{Iterator: iter, cmp: cmp, lower: lower, upper: upper}
+	}
+	if err := checkKeys(pointIter, "point key"); err != nil {
+		return err
+	}
+	rangeDelIter, err := r.NewRangeDelIter()
+	if err == nil && rangeDelIter != nil && f.Virtual() {
+		rangeDelIter, err = truncateVirtualRangeDels(cmp, rangeDelIter, lower, upper)
+	}
+	if err != nil || rangeDelIter == nil {
+		return err
+	}
+	return checkKeys(rangeDelIter, "range deletion")
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), []byte("v"), nil))
+	}
+	require.NoError(t, d.DeleteRange([]byte("010"), []byte("020"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("000"), []byte("100"), false))
+	require.NoError(t, d.Set([]byte("050"), []byte("w"), nil))
+	require.NoError(t, d.Flush())
+
+	results, err := d.CheckConsistency(context.Background(), &CheckConsistencyOptions{
+		BytesPerSecond: 1 << 30,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	for _, r := range results {
+		require.NoError(t, r.Err)
+	}
+	require.Equal(t, 0, results[0].Level)
+	require.Equal(t, numLevels-1, results[1].Level)
+	corruptFileNum := results[1].FileNum
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = d.CheckConsistency(ctx, nil)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 0, len(results))
+	require.NoError(t, d.Close())
+
+	// Corrupt the first data block of the table in the last level.
+	path := base.MakeFilename(mem, "", fileTypeTable, corruptFileNum)
+	f, err := mem.Open(path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[0] ^= 0xff
+	f, err = mem.Create(path)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	results, err = d.CheckConsistency(context.Background(), nil)
+	require.Regexp(t, `table .* failed consistency check: .*checksum mismatch`, err)
+	require.Equal(t, 2, len(results))
+	require.NoError(t, results[0].Err)
+	require.Equal(t, corruptFileNum, results[1].FileNum)
+	require.Regexp(t, `checksum mismatch`, results[1].Err)
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/corruption"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCorruptSSTable verifies that corruption of each of the blocks of an
+// sstable is surfaced as an ErrCorruption by reads of the corrupted data,
+// rather than silently returning incorrect results.
+func TestCorruptSSTable(t *testing.T) {
+	const numKeys = 100
+	value := []byte(strings.Repeat("v", 100))
+
+	testCases := []struct {
+		typ   corruption.BlockType
+		index int
+		// offset is the offset within the block of the byte to corrupt. A
+		// negative offset is relative to the end of the block.
+		offset int64
+		// seek is true if a prefix seek to the first key reads the corrupted
+		// block.
+		seek bool
+		// scan is true if a full scan reads the corrupted block. Scans do not
+		// consult the filter block.
+		scan bool
+	}{
+		{corruption.DataBlock, 0, 0, true, true},
+		{corruption.DataBlock, 1, 10, false, true},
+		{corruption.IndexBlock, 0, 0, true, true},
+		{corruption.FilterBlock, 0, 0, true, false},
+		{corruption.PropertiesBlock, 0, 0, true, true},
+		{corruption.MetaIndexBlock, 0, 0, true, true},
+		// The last byte of the footer is part of the magic number.
+		{corruption.Footer, 0, -1, true, true},
+	}
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%s/%d", c.typ, c.index), func(t *testing.T) {
+			mem := vfs.NewMem()
+			// SeekPrefixGE requires a Split function.
+			comparer := *DefaultComparer
+			comparer.Split = func(a []byte) int { return len(a) }
+			opts := &Options{FS: mem, Comparer: &comparer}
+			opts.Levels = []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			for i := 0; i < numKeys; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), value, nil))
+			}
+			require.NoError(t, d.Flush())
+			tables, err := d.SSTables()
+			require.NoError(t, err)
+			require.Equal(t, 1, len(tables[0]))
+			path := base.MakeFilename(mem, "", base.FileTypeTable, tables[0][0].FileNum)
+			require.NoError(t, d.Close())
+
+			offset := c.offset
+			if offset < 0 {
+				bh, err := corruption.Block(mem, path, c.typ, c.index)
+				require.NoError(t, err)
+				offset += int64(bh.Length)
+			}
+			require.NoError(t, corruption.FlipBlockBit(mem, path, c.typ, c.index, offset, 0))
+
+			// Opening the DB does not read the sstable. The corruption must be
+			// surfaced by reads, and the same error must be returned by
+			// repeated reads.
+			d, err = Open("", opts)
+			require.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				if c.seek {
+					iter := d.NewIter(nil)
+					require.False(t, iter.SeekPrefixGE([]byte("000")))
+					err := iter.Close()
+					require.True(t, errors.Is(err, ErrCorruption), "expected corruption, found %v", err)
+				}
+				if c.scan {
+					iter := d.NewIter(nil)
+					var n int
+					for valid := iter.First(); valid; valid = iter.Next() {
+						n++
+					}
+					err := iter.Close()
+					require.True(t, errors.Is(err, ErrCorruption), "expected corruption, found %v", err)
+					require.True(t, n < numKeys)
+				}
+			}
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+// TestCorruptWAL verifies the errors surfaced by Open for WALs with torn tails
+// and corruption in the middle of the log, under each WAL recovery mode.
+func TestCorruptWAL(t *testing.T) {
+	const numKeys = 20
+	value := []byte(strings.Repeat("a", 10<<10))
+
+	// makeWAL creates a DB whose data is contained entirely in a single WAL,
+	// returning the filesystem and the path of the WAL.
+	makeWAL := func() (*vfs.MemFS, string) {
+		mem := vfs.NewMem()
+		d, err := Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		for i := 0; i < numKeys; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%02d", i)), value, nil))
+		}
+		require.NoError(t, d.Close())
+
+		files, err := mem.List("")
+		require.NoError(t, err)
+		var logName string
+		var logSize int64
+		for _, fname := range files {
+			if ft, _, ok := base.ParseFilename(mem, fname); !ok || ft != base.FileTypeLog {
+				continue
+			}
+			info, err := mem.Stat(fname)
+			require.NoError(t, err)
+			if info.Size() > logSize {
+				logName, logSize = fname, info.Size()
+			}
+		}
+		return mem, logName
+	}
+	corruptMiddle := func(fs vfs.FS, path string) error {
+		return corruption.FlipBit(fs, path, 25<<10, 3)
+	}
+	truncateTail := func(fs vfs.FS, path string) error {
+		return corruption.TruncateTail(fs, path, 5<<10)
+	}
+
+	testCases := []struct {
+		name   string
+		mode   WALRecoveryMode
+		fault  func(fs vfs.FS, path string) error
+		expErr bool
+		// expKeys is the number of keys present after Open if no error is
+		// expected.
+		expKeys int
+	}{
+		{"torn-tail/tolerate-torn-tail", WALRecoveryTolerateTornTail, truncateTail, false, numKeys - 1},
+		{"torn-tail/strict", WALRecoveryStrict, truncateTail, false, numKeys - 1},
+		{"torn-tail/salvage", WALRecoverySalvage, truncateTail, false, numKeys - 1},
+		{"corrupt-middle/tolerate-torn-tail", WALRecoveryTolerateTornTail, corruptMiddle, false, 2},
+		{"corrupt-middle/strict", WALRecoveryStrict, corruptMiddle, true, 0},
+		// Salvaging skips the remainder of the corrupted block, which holds parts
+		// of two records.
+		{"corrupt-middle/salvage", WALRecoverySalvage, corruptMiddle, false, numKeys - 2},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			mem, path := makeWAL()
+			require.NoError(t, c.fault(mem, path))
+
+			d, err := Open("", &Options{FS: mem, WALRecoveryMode: c.mode})
+			if c.expErr {
+				require.True(t, errors.Is(err, ErrCorruption), "expected corruption, found %v", err)
+				var replayErr *WALReplayError
+				require.True(t, errors.As(err, &replayErr), "%v", err)
+				return
+			}
+			require.NoError(t, err)
+			iter := d.NewIter(nil)
+			var n int
+			for valid := iter.First(); valid; valid = iter.Next() {
+				n++
+			}
+			require.NoError(t, iter.Close())
+			require.Equal(t, c.expKeys, n)
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+// TestCrashDropsUnsyncedWrites verifies that writes which were synced before a
+// crash survive it, and that the DB can be reopened after the unsynced writes
+// are dropped.
+func TestCrashDropsUnsyncedWrites(t *testing.T) {
+	mem := vfs.NewStrictMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	// The directory must be synced for the files created by Open to survive
+	// the crash.
+	dir, err := mem.OpenDir("")
+	require.NoError(t, err)
+	require.NoError(t, dir.Sync())
+	require.NoError(t, dir.Close())
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), Sync))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), NoSync))
+	require.NoError(t, corruption.Crash(mem, d.Close))
+
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = d.Get([]byte("b"))
+	require.Equal(t, ErrNotFound, err)
+	require.NoError(t, d.Close())
+}
@@ -239,6 +239,21 @@ func runBatchDefineCmd(d *datadriven.TestData, b *Batch) error {
 				return errors.Errorf("%s expects 2 arguments", parts[0])
 			}
 			err = b.Merge([]byte(parts[1]), []byte(parts[2]), nil)
+		case "range-key-set":
+			if len(parts) != 5 {
+				return errors.Errorf("%s expects 4 arguments", parts[0])
+			}
+			err = b.RangeKeySet([]byte(parts[1]), []byte(parts[2]), []byte(parts[3]), []byte(parts[4]), nil)
+		case "range-key-unset":
+			if len(parts) != 4 {
+				return errors.Errorf("%s expects 3 arguments", parts[0])
+			}
+			err = b.RangeKeyUnset([]byte(parts[1]), []byte(parts[2]), []byte(parts[3]), nil)
+		case "range-key-del":
+			if len(parts) != 3 {
+				return errors.Errorf("%s expects 2 arguments", parts[0])
+			}
+			err = b.RangeKeyDelete([]byte(parts[1]), []byte(parts[2]), nil)
 		default:
 			return errors.Errorf("unknown op: %s", parts[0])
 		}
@@ -313,7 +328,7 @@ func runCompactCmd(td *datadriven.TestData, d *DB) error {
 			end:   iEnd,
 		})
 	}
-	return d.Compact([]byte(parts[0]), []byte(parts[1]))
+	return d.Compact([]byte(parts[0]), []byte(parts[1]), false)
 }
 
 func runDBDefineCmd(td *datadriven.TestData, opts *Options) (*DB, error) {
@@ -518,6 +533,8 @@ func runTableStatsCmd(td *datadriven.TestData, d *DB) string {
 			}
 
 			var b bytes.Buffer
+			fmt.Fprintf(&b, "num-entries: %d\n", f.Stats.NumEntries)
+			fmt.Fprintf(&b, "num-deletions: %d\n", f.Stats.NumDeletions)
 			fmt.Fprintf(&b, "range-deletions-bytes-estimate: %d\n", f.Stats.RangeDeletionsBytesEstimate)
 			return b.String()
 		}
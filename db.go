@@ -6,9 +6,11 @@
 package pebble // import "github.com/cockroachdb/pebble"
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,12 +39,25 @@ var (
 	// ErrNotFound is returned when a get operation does not find the requested
 	// key.
 	ErrNotFound = base.ErrNotFound
+	// ErrCorruption is a marker for errors caused by data which is not in the
+	// expected format, such as a checksum mismatch in an sstable or WAL. Use
+	// errors.Is to check whether an error is a corruption error.
+	ErrCorruption = base.ErrCorruption
 	// ErrClosed is returned when an operation is performed on a closed snapshot
 	// or DB.
 	ErrClosed = errors.New("pebble: closed")
 	// ErrReadOnly is returned when a write operation is performed on a read-only
 	// database.
 	ErrReadOnly = errors.New("pebble: read-only")
+	// ErrCancelledCompaction is returned by a compaction which was cancelled
+	// before it completed, either by DB.CancelCompactions or by DB.Close. The
+	// outputs of a cancelled compaction are discarded and the LSM is left
+	// unchanged.
+	ErrCancelledCompaction = errors.New("pebble: compaction cancelled")
+	// ErrBackgroundError is returned, wrapping the error which caused it, when
+	// a write operation is performed while the DB is in the background error
+	// state. See DB.Resume.
+	ErrBackgroundError = errors.New("pebble: background error")
 )
 
 // Reader is a readable key/value store.
@@ -126,6 +141,27 @@ type Writer interface {
 	// It is safe to modify the contents of the arguments after Merge returns.
 	Merge(key, value []byte, o *WriteOptions) error
 
+	// RangeKeySet sets the range key with the specified suffix to value over
+	// the range [start,end). Range keys do not affect point keys, and are only
+	// observed by iterators configured with IterOptions.KeyTypes.
+	//
+	// It is safe to modify the contents of the arguments after RangeKeySet
+	// returns.
+	RangeKeySet(start, end, suffix, value []byte, o *WriteOptions) error
+
+	// RangeKeyUnset removes the range key with the specified suffix over the
+	// range [start,end).
+	//
+	// It is safe to modify the contents of the arguments after RangeKeyUnset
+	// returns.
+	RangeKeyUnset(start, end, suffix []byte, o *WriteOptions) error
+
+	// RangeKeyDelete removes all of the range keys over the range [start,end).
+	//
+	// It is safe to modify the contents of the arguments after RangeKeyDelete
+	// returns.
+	RangeKeyDelete(start, end []byte, o *WriteOptions) error
+
 	// Set sets the value for the given key. It overwrites any previous value
 	// for that key; a DB is not a multi-map.
 	//
@@ -181,9 +217,17 @@ type DB struct {
 
 	tableCache tableCache
 	newIters   tableNewIters
+	// iterAllocs caches the allocations of closed iterators.
+	iterAllocs iterAllocCache
+	// subscriptions receive the batches committed to the DB. See Subscribe.
+	subscriptions subscriptions
 
 	commit *commitPipeline
 
+	// memTableSizer, if non-nil, chooses the size of new memtables. See
+	// Options.Experimental.MemTableMinSize.
+	memTableSizer *memTableSizer
+
 	// readState provides access to the state needed for reading without needing
 	// to acquire DB.mu.
 	readState struct {
@@ -191,22 +235,70 @@ type DB struct {
 		val *readState
 	}
 
+	// txnCommitMu serializes the validation and application of transactions.
+	// See Transaction.Commit.
+	txnCommitMu sync.Mutex
+	// locks holds the locks of pessimistic transactions.
+	locks lockTable
+
+	// keyspace is non-nil if the DB is a keyspace of another DB, whose WAL and
+	// commit pipeline it shares. See keyspace.
+	keyspace *keyspace
+	// keyspaces holds the keyspaces of the DB, indexed by name. If both are
+	// held, DB.mu must be acquired before keyspaces.Mutex, which in turn must
+	// be acquired before the DB.mu of a keyspace.
+	keyspaces struct {
+		sync.Mutex
+		m      map[string]*keyspace
+		nextID uint32
+	}
+
 	// logRecycler holds a set of log file numbers that are available for
 	// reuse. Writing to a recycled log file is faster than to a new log file on
 	// some common filesystems (xfs, and ext3/4) due to avoiding metadata
 	// updates.
 	logRecycler logRecycler
 
+	// tieredFS is non-nil if Options.Experimental.SecondaryFS is set, in which
+	// case it is also Options.FS.
+	tieredFS *tieredFS
+
+	// follower is non-nil if the DB was opened with OpenFollower.
+	follower *follower
+
+	// walMetrics accumulates sync metrics across all of the WAL writers
+	// created by the DB.
+	walMetrics record.LogWriterMetrics
+
 	closed   int32 // updated atomically
 	closedCh chan struct{}
 
+	// bgErrState is non-zero while the DB is in the background error state
+	// described by DB.mu.bgErr. Updated atomically, allowing writes to check
+	// the state without acquiring DB.mu.
+	bgErrState int32
+
 	// The count and size of referenced memtables. This includes memtables
 	// present in DB.mu.mem.queue, as well as memtables that have been flushed
 	// but are still referenced by an inuse readState.
 	memTableCount    int64
 	memTableReserved int64 // number of bytes reserved in the cache for memtables
 
+	// The threshold for determining when a batch is too large for the mutable
+	// memtable when adaptive memtable sizing is enabled. The mutable memtable
+	// may be considerably smaller than Options.MemTableSize in that case, and
+	// batches which exceed this threshold are committed as flushable batches
+	// rather than being copied into a newly grown memtable. Updated atomically
+	// whenever a memtable is created.
+	memTableBatchThreshold int64
+
 	compactionLimiter limiter
+	// compactionRateCap, if non-nil, limits the combined rate of compactions.
+	// See Options.MaxCompactionRate.
+	compactionRateCap limiter
+	// compactionPacing records the pacing decisions of compactions for
+	// Metrics.Compact.
+	compactionPacing pacingStats
 
 	// bytesFlushed is the number of bytes flushed in the current flush. This
 	// must be read/written atomically since it is accessed by both the flush
@@ -219,6 +311,10 @@ type DB struct {
 
 	flushLimiter limiter
 
+	// deletionPacer, if non-nil, deletes obsolete sstables in the background at
+	// Options.Experimental.TargetByteDeletionRate.
+	deletionPacer *deletionPacer
+
 	// The main mutex protecting internal DB state. This mutex encompasses many
 	// fields because those fields need to be accessed and updated atomically. In
 	// particular, the current version, log.*, mem.*, and snapshot list need to
@@ -250,6 +346,10 @@ type DB struct {
 			queue []FileNum
 			// The size of the current log file (i.e. queue[len(queue)-1].
 			size uint64
+			// The file number of the current log file. Updated atomically while
+			// holding commitPipeline.mu, so that keyspaces can determine which
+			// log their batches are written to. See keyspace.
+			num uint64
 			// The number of input bytes to the log. This is the raw size of the
 			// batches written to the WAL, without the overhead of the record
 			// envelopes.
@@ -279,8 +379,12 @@ type DB struct {
 			// min(256KB,Options.MemTableSize) and doubles each time a new memtable
 			// is allocated up to Options.MemTableSize. This reduces the memory
 			// footprint of memtables when lots of DB instances are used concurrently
-			// in test environments.
+			// in test environments. If adaptive memtable sizing is enabled, nextSize
+			// is instead chosen by memTableSizer each time a memtable fills.
 			nextSize int
+			// createdAt is the time at which the most recently created memtable
+			// (i.e. the mutable memtable) was created.
+			createdAt time.Time
 		}
 
 		compact struct {
@@ -297,6 +401,45 @@ type DB struct {
 			manual []*manualCompaction
 			// inProgress is the set of in-progress flushes and compactions.
 			inProgress map[*compaction]struct{}
+			// deletionHints are the range tombstones which wholly cover tables
+			// in lower levels, which may be deleted by delete-only compactions.
+			deletionHints []deleteCompactionHint
+			// True when the scheduling of automatic compactions is disabled. See
+			// DB.SetDisableAutomaticCompactions.
+			disableAutomatic bool
+			// throughput is an exponentially weighted moving average of the
+			// throughput of individual compactions in bytes per second, sampled
+			// each time a compaction completes.
+			throughput float64
+		}
+
+		// bgErr holds the background error state. See DB.Resume.
+		bgErr struct {
+			// err is the error which put the DB into the background error state,
+			// or nil if the DB is not in the background error state.
+			err error
+			// walLogNum is the number of the most recent log which encountered an
+			// error while in the background error state, or zero. Writes to the
+			// log may have been lost, so the state is only left once the memtables
+			// of the log have been flushed.
+			walLogNum FileNum
+			// flushErrs counts the flushes which have failed. See DB.Resume.
+			flushErrs int
+		}
+
+		// writeStall holds the state of the current write stall, if any. See
+		// DB.makeRoomForWrite().
+		writeStall struct {
+			// True when writes are stopped or slowed down, as described by info.
+			active bool
+			info   WriteStallBeginInfo
+			// The time at which the current stall began.
+			start time.Time
+			// The estimated compaction debt of debtVersion. The estimate is
+			// cached as it is consulted by every write while compaction debt
+			// thresholds are configured.
+			debtVersion *version
+			debt        uint64
 		}
 
 		cleaner struct {
@@ -315,6 +458,9 @@ type DB struct {
 
 		// The list of active snapshots.
 		snapshots snapshotList
+		// excising is the number of excisions being applied, during which
+		// snapshots are not created. See DB.IngestAndExcise.
+		excising int
 
 		tableStats struct {
 			// Condition variable used to signal the completion of a
@@ -349,19 +495,50 @@ var _ Writer = (*DB)(nil)
 // safe to modify the contents of the argument after Get returns. The returned
 // slice will remain valid until the returned Closer is closed. On success, the
 // caller MUST call closer.Close() or a memory leak will occur.
+//
+// The returned slice is not a copy: it points directly into the memtable or
+// the block cache, which the Closer pins until it is closed. Callers that need
+// the value beyond that point must copy it. Like an unclosed Iterator, an
+// unclosed Closer causes DB.Close to return a "leaked iterators" error.
 func (d *DB) Get(key []byte) ([]byte, io.Closer, error) {
-	return d.getInternal(key, nil /* batch */, nil /* snapshot */)
+	return d.getInternal(context.Background(), key, nil /* batch */, nil /* snapshot */)
+}
+
+// GetWithContext is like Get, but once ctx is done, opening sstables and
+// reading sstable blocks which are not in the cache fail with the context's
+// error. This allows a slow read, such as one against remote storage, to be
+// cancelled or bounded by a deadline.
+func (d *DB) GetWithContext(ctx context.Context, key []byte) ([]byte, io.Closer, error) {
+	if !base.Traced(ctx) {
+		return d.getInternal(ctx, key, nil /* batch */, nil /* snapshot */)
+	}
+	ctx, span := base.StartSpan(ctx, "pebble.Get", time.Now())
+	value, closer, err := d.getInternal(ctx, key, nil /* batch */, nil /* snapshot */)
+	span.SetAttribute("pebble.value_bytes", int64(len(value)))
+	span.End(time.Now())
+	return value, closer, err
 }
 
-func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer, error) {
+func (d *DB) getInternal(
+	ctx context.Context, key []byte, b *Batch, s *Snapshot,
+) ([]byte, io.Closer, error) {
 	if atomic.LoadInt32(&d.closed) != 0 {
 		panic(ErrClosed)
 	}
+	if t := d.opts.TraceRecorder; t != nil && b == nil && s == nil {
+		t.get(key)
+	}
 
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
-	// compaction. The readState is unref'd by Iterator.Close().
-	readState := d.loadReadState()
+	// compaction. The readState is unref'd by Iterator.Close(). A file-only
+	// snapshot reads from the version it references.
+	var readState *readState
+	if s != nil {
+		readState = s.loadReadState()
+	} else {
+		readState = d.loadReadState()
+	}
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
@@ -372,18 +549,23 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		seqNum = atomic.LoadUint64(&d.mu.versions.visibleSeqNum)
 	}
 
-	var buf struct {
-		dbi Iterator
-		get getIter
-	}
+	// Bundle various structures under a single umbrella in order to allocate
+	// them together.
+	buf := getIterAllocPool.Get().(*getIterAlloc)
 
 	get := &buf.get
 	get.logger = d.opts.Logger
+	get.ctx = ctx
 	get.cmp = d.cmp
 	get.equal = d.equal
+	get.split = d.split
 	get.newIters = d.newIters
 	get.snapshot = seqNum
 	get.key = key
+	get.prefix = key
+	if d.split != nil {
+		get.prefix = key[:d.split(key)]
+	}
 	get.batch = b
 	get.mem = readState.memtables
 	get.l0 = readState.current.L0Sublevels.Levels
@@ -400,12 +582,14 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 	}
 
 	i := &buf.dbi
+	i.getIterAlloc = buf
 	i.cmp = d.cmp
 	i.equal = d.equal
 	i.merge = d.merge
 	i.split = d.split
 	i.iter = get
 	i.readState = readState
+	i.keyBuf = buf.keyBuf
 
 	if !i.First() {
 		err := i.Close()
@@ -494,6 +678,54 @@ func (d *DB) Merge(key, value []byte, opts *WriteOptions) error {
 	return nil
 }
 
+// RangeKeySet sets the range key with the specified suffix to value over the
+// range [start,end). Range keys require the format major version of the DB to
+// be at least FormatRangeKeys.
+//
+// It is safe to modify the contents of the arguments after RangeKeySet
+// returns.
+func (d *DB) RangeKeySet(start, end, suffix, value []byte, opts *WriteOptions) error {
+	b := newBatch(d)
+	_ = b.RangeKeySet(start, end, suffix, value, opts)
+	if err := d.Apply(b, opts); err != nil {
+		return err
+	}
+	// Only release the batch on success.
+	b.release()
+	return nil
+}
+
+// RangeKeyUnset removes the range key with the specified suffix over the
+// range [start,end).
+//
+// It is safe to modify the contents of the arguments after RangeKeyUnset
+// returns.
+func (d *DB) RangeKeyUnset(start, end, suffix []byte, opts *WriteOptions) error {
+	b := newBatch(d)
+	_ = b.RangeKeyUnset(start, end, suffix, opts)
+	if err := d.Apply(b, opts); err != nil {
+		return err
+	}
+	// Only release the batch on success.
+	b.release()
+	return nil
+}
+
+// RangeKeyDelete removes all of the range keys over the range [start,end).
+//
+// It is safe to modify the contents of the arguments after RangeKeyDelete
+// returns.
+func (d *DB) RangeKeyDelete(start, end []byte, opts *WriteOptions) error {
+	b := newBatch(d)
+	_ = b.RangeKeyDelete(start, end, opts)
+	if err := d.Apply(b, opts); err != nil {
+		return err
+	}
+	// Only release the batch on success.
+	b.release()
+	return nil
+}
+
 // LogData adds the specified to the batch. The data will be written to the
 // WAL, but not added to memtables or sstables. Log data is never indexed,
 // which makes it useful for testing WAL performance.
@@ -517,31 +749,98 @@ func (d *DB) LogData(data []byte, opts *WriteOptions) error {
 //
 // It is safe to modify the contents of the arguments after Apply returns.
 func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
+	return d.apply(context.Background(), batch, opts, nil)
+}
+
+// ApplyWithContext is like Apply, but records spans for the commit if a
+// tracer is attached to ctx. See ContextWithTracer.
+func (d *DB) ApplyWithContext(ctx context.Context, batch *Batch, opts *WriteOptions) error {
+	return d.apply(ctx, batch, opts, nil)
+}
+
+// ApplyAsync applies the operations contained in the batch to the DB like
+// Apply, but returns as soon as the batch has been written to the WAL and
+// applied to the memtable, without waiting for it to become visible to reads
+// or, if opts requests a sync, durable. The done callback is invoked from
+// another goroutine once both have occurred, allowing commits to be pipelined
+// without blocking a goroutine on each of them. Errors which prevent the
+// batch from being committed are returned by ApplyAsync, in which case done
+// is not invoked.
+//
+// The batch must not be modified, reused or closed until done has been
+// invoked. ApplyAsync blocks while the maximum number of commits are in
+// flight.
+func (d *DB) ApplyAsync(batch *Batch, opts *WriteOptions, done func(error)) error {
+	if done == nil {
+		return errors.New("pebble: nil ApplyAsync callback")
+	}
+	return d.apply(context.Background(), batch, opts, done)
+}
+
+// apply implements Apply and ApplyWithContext and, if done is non-nil,
+// ApplyAsync.
+func (d *DB) apply(ctx context.Context, batch *Batch, opts *WriteOptions, done func(error)) error {
 	if atomic.LoadInt32(&d.closed) != 0 {
 		panic(ErrClosed)
 	}
 	if d.opts.ReadOnly {
 		return ErrReadOnly
 	}
+	if err := d.backgroundError(); err != nil {
+		return err
+	}
 	if batch.db != nil && batch.db != d {
 		panic(fmt.Sprintf("pebble: batch db mismatch: %p != %p", batch.db, d))
 	}
+	if batch.countRangeKeys > 0 {
+		if err := d.requireFormatMajorVersion(FormatRangeKeys, "range keys"); err != nil {
+			return err
+		}
+	}
 
 	sync := opts.GetSync()
 	if sync && d.opts.DisableWAL {
 		return errors.New("pebble: WAL disabled")
 	}
+	if t := d.opts.TraceRecorder; t != nil && !batch.Empty() {
+		t.batch(batch, sync)
+	}
 
 	if batch.db == nil {
 		batch.refreshMemTableSize()
 	}
-	if int(batch.memTableSize) >= d.largeBatchThreshold {
+	if int(batch.memTableSize) >= d.largeBatchThreshold ||
+		(d.memTableSizer != nil && int64(batch.memTableSize) >= atomic.LoadInt64(&d.memTableBatchThreshold)) {
 		batch.flushable = newFlushableBatch(batch, d.opts.Comparer)
 	}
-	if err := d.commit.Commit(batch, sync); err != nil {
-		// There isn't much we can do on an error here. The commit pipeline will be
-		// horked at this point.
-		d.opts.Logger.Fatalf("%v", err)
+	if done != nil {
+		// Errors syncing the batch to the WAL or applying it to the memtable
+		// are passed to done, and errors which prevent the batch from being
+		// committed are returned, in which case done is never invoked.
+		return d.commit.CommitAsync(batch, sync, opts.GetClass(), func(err error) {
+			if err != nil {
+				if atomic.LoadUint32(&batch.applied) == 0 {
+					// As below, the commit pipeline is horked by a batch which
+					// failed to apply.
+					d.opts.Logger.Fatalf("%v", err)
+				}
+				d.handleWALError(err)
+			}
+			if batch.flushable != nil {
+				batch.data = nil
+			}
+			done(err)
+		})
+	}
+	err := d.commit.CommitWithContext(ctx, batch, sync, opts.GetClass())
+	if err != nil {
+		if batch.commitErr == nil {
+			// There isn't much we can do on an error here. The commit pipeline
+			// will be horked at this point.
+			d.opts.Logger.Fatalf("%v", err)
+		}
+		// The batch was applied, but writing or syncing it to the WAL failed.
+		d.handleWALError(err)
 	}
 	// If this is a large batch, we need to clear the batch contents as the
 	// flushable batch may still be present in the flushables queue.
@@ -553,7 +852,7 @@ func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
 	if batch.flushable != nil {
 		batch.data = nil
 	}
-	return nil
+	return err
 }
 
 func (d *DB) commitApply(b *Batch, mem *memTable) error {
@@ -584,6 +883,12 @@ func (d *DB) commitApply(b *Batch, mem *memTable) error {
 }
 
 func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
+	if d.keyspace != nil {
+		return d.keyspace.commitWrite(b, syncWG, syncErr)
+	}
+
+	d.maybeCollapseMerges(b)
+
 	var size int64
 	repr := b.Repr()
 
@@ -602,7 +907,8 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 			var err error
 			size, err = d.mu.log.SyncRecord(repr, syncWG, syncErr)
 			if err != nil {
-				panic(err)
+				walWriteFailed(b, syncWG, err)
+				size = int64(atomic.LoadUint64(&d.mu.log.size))
 			}
 		}
 	}
@@ -634,7 +940,8 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 	if b.flushable == nil {
 		size, err = d.mu.log.SyncRecord(repr, syncWG, syncErr)
 		if err != nil {
-			panic(err)
+			walWriteFailed(b, syncWG, err)
+			return mem, nil
 		}
 	}
 
@@ -644,31 +951,115 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 
 type iterAlloc struct {
 	dbi     Iterator
-	keyBuf  []byte
 	merging mergingIter
 	mlevels [3 + numLevels]mergingIterLevel
 	levels  [3 + numLevels]levelIter
+	// cache is the freelist to which the iterAlloc is returned when its
+	// Iterator is closed.
+	cache *iterAllocCache
+
+	rangeDelBudget rangeDelBudget
+}
+
+// release drops the references held by the internal iterators of an iterAlloc
+// whose Iterator has been closed, so that a cached iterAlloc does not retain
+// memtables, sstable iterators or version metadata. Only the levels which were
+// used are reset.
+func (a *iterAlloc) release() {
+	for i := range a.mlevels {
+		if a.mlevels[i].iter == nil {
+			break
+		}
+		a.mlevels[i] = mergingIterLevel{}
+	}
+	for i := range a.levels {
+		if a.levels[i].files == nil {
+			break
+		}
+		a.levels[i] = levelIter{}
+	}
+	items := a.merging.heap.items[:cap(a.merging.heap.items)]
+	for i := range items {
+		items[i] = mergingIterItem{}
+	}
+	a.merging = mergingIter{heap: mergingIterHeap{items: items[:0]}}
+}
+
+// maxCachedIterAllocs is the maximum number of closed iterators whose
+// allocations are retained by a DB for reuse. The constant is fairly
+// arbitrary.
+const maxCachedIterAllocs = 32
+
+// iterAllocCache is a freelist of the allocations of closed iterators. Unlike
+// a sync.Pool, which is emptied by every GC, the freelist retains its contents
+// so that a workload of short-lived iterators does not allocate.
+type iterAllocCache struct {
+	mu   sync.Mutex
+	free []*iterAlloc
 }
 
-var iterAllocPool = sync.Pool{
+func (c *iterAllocCache) get() *iterAlloc {
+	c.mu.Lock()
+	if n := len(c.free); n > 0 {
+		a := c.free[n-1]
+		c.free[n-1] = nil
+		c.free = c.free[:n-1]
+		c.mu.Unlock()
+		return a
+	}
+	c.mu.Unlock()
+	return &iterAlloc{cache: c}
+}
+
+func (c *iterAllocCache) put(a *iterAlloc) {
+	a.release()
+	c.mu.Lock()
+	if len(c.free) < maxCachedIterAllocs {
+		c.free = append(c.free, a)
+	}
+	c.mu.Unlock()
+}
+
+// getIterAlloc bundles the structures allocated by a Get, which are released
+// when the returned closer is closed.
+type getIterAlloc struct {
+	dbi    Iterator
+	keyBuf []byte
+	get    getIter
+}
+
+var getIterAllocPool = sync.Pool{
 	New: func() interface{} {
-		return &iterAlloc{}
+		return &getIterAlloc{}
 	},
 }
 
 // newIterInternal constructs a new iterator, merging in batchIter as an extra
 // level.
 func (d *DB) newIterInternal(
-	batchIter internalIterator, batchRangeDelIter internalIterator, s *Snapshot, o *IterOptions,
+	batchIter internalIterator,
+	batchRangeDelIter internalIterator,
+	batchRangeKeyIter internalIterator,
+	s *Snapshot,
+	o *IterOptions,
 ) *Iterator {
 	if atomic.LoadInt32(&d.closed) != 0 {
 		panic(ErrClosed)
 	}
+	if o != nil && o.Timestamp != 0 && d.split == nil {
+		panic("pebble: split must be provided for IterOptions.Timestamp")
+	}
 
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
-	// compaction. The readState is unref'd by Iterator.Close().
-	readState := d.loadReadState()
+	// compaction. The readState is unref'd by Iterator.Close(). A file-only
+	// snapshot reads from the version it references.
+	var readState *readState
+	if s != nil {
+		readState = s.loadReadState()
+	} else {
+		readState = d.loadReadState()
+	}
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
@@ -679,24 +1070,49 @@ func (d *DB) newIterInternal(
 		seqNum = atomic.LoadUint64(&d.mu.versions.visibleSeqNum)
 	}
 
+	var rangeKeys *iterRangeKeys
+	if o != nil && o.KeyTypes != IterKeyTypePointsOnly {
+		var err error
+		rangeKeys, err = d.newIterRangeKeys(o, batchRangeKeyIter, readState, seqNum)
+		if err != nil {
+			readState.unref()
+			return &Iterator{err: err, iter: newErrorIter(err)}
+		}
+	}
+
 	// Bundle various structures under a single umbrella in order to allocate
-	// them together.
-	buf := iterAllocPool.Get().(*iterAlloc)
+	// them together. A closed Iterator has already been reset by
+	// Iterator.Close, and retains its buffers.
+	buf := d.iterAllocs.get()
 	dbi := &buf.dbi
-	*dbi = Iterator{
-		alloc:     buf,
-		cmp:       d.cmp,
-		equal:     d.equal,
-		iter:      &buf.merging,
-		merge:     d.merge,
-		split:     d.split,
-		readState: readState,
-		keyBuf:    buf.keyBuf,
-	}
+	dbi.alloc = buf
+	dbi.cmp = d.cmp
+	dbi.equal = d.equal
+	dbi.iter = &buf.merging
+	dbi.merge = d.merge
+	dbi.split = d.split
+	dbi.readState = readState
+	dbi.rangeKeys = rangeKeys
 	if o != nil {
 		dbi.opts = *o
 	}
+	if base.Traced(dbi.opts.ctx) {
+		dbi.opts.ctx, dbi.span = base.StartSpan(dbi.opts.ctx, "pebble.Iterator", time.Now())
+	}
+	if t := d.opts.TraceRecorder; t != nil && batchIter == nil && s == nil {
+		dbi.trace = t
+		dbi.traceID = t.newIter(o)
+	}
+	if dbi.opts.KeyTypes == IterKeyTypeRangesOnly {
+		// The point keys are not surfaced by the iterator.
+		dbi.iter = emptyIter
+		return dbi
+	}
 	dbi.opts.logger = d.opts.Logger
+	if limit := d.opts.Experimental.IterRangeDelMemoryLimit; limit > 0 {
+		buf.rangeDelBudget = rangeDelBudget{limit: limit}
+		dbi.opts.rangeDelBudget = &buf.rangeDelBudget
+	}
 
 	mlevels := buf.mlevels[:0]
 	if batchIter != nil {
@@ -752,7 +1168,7 @@ func (d *DB) newIterInternal(
 			li = &levelIter{}
 		}
 
-		li.init(dbi.opts, d.cmp, d.newIters, files, level, nil)
+		li.init(dbi.opts, d.cmp, d.split, d.newIters, files, level, nil)
 		li.initRangeDel(&mlevels[0].rangeDelIter)
 		li.initSmallestLargestUserKey(&mlevels[0].smallestUserKey, &mlevels[0].largestUserKey,
 			&mlevels[0].isLargestUserKeyRangeDelSentinel)
@@ -802,7 +1218,15 @@ func (d *DB) NewIndexedBatch() *Batch {
 // point-in-time snapshots which avoids these problems.
 func (d *DB) NewIter(o *IterOptions) *Iterator {
 	return d.newIterInternal(nil, /* batchIter */
-		nil /* batchRangeDelIter */, nil /* snapshot */, o)
+		nil /* batchRangeDelIter */, nil /* batchRangeKeyIter */, nil /* snapshot */, o)
+}
+
+// NewIterWithContext is like NewIter, but once ctx is done, the iterator's
+// opening of sstables and reads of sstable blocks which are not in the cache
+// fail with the context's error. The error is surfaced by Iterator.Error.
+func (d *DB) NewIterWithContext(ctx context.Context, o *IterOptions) *Iterator {
+	return d.newIterInternal(nil, /* batchIter */
+		nil /* batchRangeDelIter */, nil /* batchRangeKeyIter */, nil /* snapshot */, withContext(ctx, o))
 }
 
 // NewSnapshot returns a point-in-time view of the current DB state. Iterators
@@ -819,6 +1243,7 @@ func (d *DB) NewSnapshot() *Snapshot {
 	}
 
 	d.mu.Lock()
+	d.waitForExcisionsLocked()
 	s := &Snapshot{
 		db:     d,
 		seqNum: atomic.LoadUint64(&d.mu.versions.visibleSeqNum),
@@ -828,12 +1253,92 @@ func (d *DB) NewSnapshot() *Snapshot {
 	return s
 }
 
-// Close closes the DB.
+// NewEventuallyFileOnlySnapshot returns a point-in-time view of the current
+// DB state which, unlike a snapshot returned by NewSnapshot, stops
+// constraining the DB once all of the keys visible to it have been flushed
+// from the memtables. Until then, it prevents compactions from discarding the
+// keys it shadows, as any snapshot does. Once the memtables containing the
+// keys have been flushed, the snapshot becomes file-only: it references the
+// sstables of the LSM at that point, and compactions are free to discard
+// shadowed keys, as the sstables referenced by the snapshot are retained on
+// disk until it is closed. A flush may be forced using DB.Flush.
+//
+// An eventually file-only snapshot is suited to long-lived snapshots, which
+// would otherwise accumulate the overwritten and deleted keys they shadow,
+// though it may instead hold on to obsolete sstables.
+func (d *DB) NewEventuallyFileOnlySnapshot() *Snapshot {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+
+	d.mu.Lock()
+	d.waitForExcisionsLocked()
+	s := &Snapshot{
+		db:     d,
+		seqNum: atomic.LoadUint64(&d.mu.versions.visibleSeqNum),
+	}
+	s.fileOnly.enabled = true
+	d.mu.snapshots.pushBack(s)
+	d.maybeTransitionSnapshotsLocked()
+	d.mu.Unlock()
+	return s
+}
+
+// waitForExcisionsLocked waits for the excisions being applied to complete,
+// as a snapshot created during an excision would not observe the excised
+// keys. See DB.IngestAndExcise.
+//
+// d.mu must be held when calling this.
+func (d *DB) waitForExcisionsLocked() {
+	for d.mu.excising > 0 {
+		d.mu.compact.cond.Wait()
+	}
+}
+
+// maybeTransitionSnapshotsLocked makes file-only any eventually file-only
+// snapshots whose visible keys have all been flushed, removing them from the
+// snapshot list. It is called whenever memtables are flushed.
+//
+// d.mu must be held when calling this.
+func (d *DB) maybeTransitionSnapshotsLocked() {
+	if d.mu.snapshots.empty() || len(d.mu.mem.queue) == 0 {
+		return
+	}
+	// The memtables are ordered from oldest to newest, and contain only keys
+	// with sequence numbers greater than or equal to the logSeqNum at which
+	// they were created. A snapshot reads keys with sequence numbers less than
+	// its own, so they have all been flushed if the sequence number of the
+	// snapshot is no greater than the logSeqNum of the oldest memtable.
+	minUnflushedSeqNum := d.mu.mem.queue[0].logSeqNum
+	root := &d.mu.snapshots.root
+	for s := root.next; s != root; {
+		next := s.next
+		if s.fileOnly.enabled && s.seqNum <= minUnflushedSeqNum {
+			current := d.mu.versions.currentVersion()
+			current.Ref()
+			s.fileOnly.Lock()
+			s.fileOnly.readState = &readState{db: d, refcnt: 1, current: current}
+			s.fileOnly.Unlock()
+			d.mu.snapshots.remove(s)
+		}
+		s = next
+	}
+}
+
+// Close closes the DB, along with its keyspaces.
 //
 // It is not safe to close a DB until all outstanding iterators are closed
 // or to call Close concurrently with any other DB method. It is not valid
 // to call any of a DB's methods after the DB has been closed.
 func (d *DB) Close() error {
+	if d.keyspace != nil {
+		return errors.New("pebble: a keyspace is closed along with its DB")
+	}
+	err := d.closeKeyspaces()
+	return firstError(err, d.close())
+}
+
+func (d *DB) close() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	if atomic.LoadInt32(&d.closed) != 0 {
@@ -841,9 +1346,26 @@ func (d *DB) Close() error {
 	}
 	atomic.StoreInt32(&d.closed, 1)
 	close(d.closedCh)
+	d.subscriptions.closeAll()
+
+	if d.follower != nil && d.follower.doneCh != nil {
+		// Wait for the follower to stop catching up with its primary, which
+		// requires d.mu.
+		d.mu.Unlock()
+		<-d.follower.doneCh
+		d.mu.Lock()
+	}
 
 	defer d.opts.Cache.Unref()
+	if d.deletionPacer != nil {
+		// Deferred so that the sstables made obsolete below are queued before
+		// the queue is drained.
+		defer d.deletionPacer.close()
+	}
 
+	// Cancel the in-progress compactions rather than waiting for them to
+	// complete. Flushes are allowed to complete.
+	d.cancelCompactionsLocked(nil, nil)
 	for d.mu.compact.compactingCount > 0 || d.mu.compact.flushing {
 		d.mu.compact.cond.Wait()
 	}
@@ -856,12 +1378,14 @@ func (d *DB) Close() error {
 		err = errors.Errorf("pebble: %d unexpected in-progress compactions", errors.Safe(n))
 	}
 	err = firstError(err, d.tableCache.Close())
-	if !d.opts.ReadOnly {
+	if !d.opts.ReadOnly && d.keyspace == nil {
 		err = firstError(err, d.mu.log.Close())
 	} else if d.mu.log.LogWriter != nil {
 		panic("pebble: log-writer should be nil in read-only mode")
 	}
-	err = firstError(err, d.fileLock.Close())
+	if d.fileLock != nil {
+		err = firstError(err, d.fileLock.Close())
+	}
 
 	// Note that versionSet.close() only closes the MANIFEST. The versions list
 	// is still valid for the checks below.
@@ -910,10 +1434,13 @@ func (d *DB) Close() error {
 	return err
 }
 
-// Compact the specified range of keys in the database.
-func (d *DB) Compact(
-	start, end []byte, /* CompactionOptions */
-) error {
+// Compact the specified range of keys in the database. All of the sstables
+// overlapping the range are compacted down to the bottommost level containing
+// data in the range, which is useful for reclaiming space deterministically
+// after deleting large ranges of keys. If parallelize is true, the compaction
+// of each level is split into compactions of disjoint key ranges which may run
+// concurrently, subject to Options.MaxConcurrentCompactions.
+func (d *DB) Compact(start, end []byte, parallelize bool) error {
 	if atomic.LoadInt32(&d.closed) != 0 {
 		panic(ErrClosed)
 	}
@@ -976,16 +1503,29 @@ func (d *DB) Compact(
 	}
 
 	for level := 0; level < maxLevelWithFiles; {
-		manual := &manualCompaction{
-			done:  make(chan error, 1),
-			level: level,
-			start: iStart,
-			end:   iEnd,
+		var manuals []*manualCompaction
+		if parallelize {
+			d.mu.Lock()
+			manuals = d.splitManualCompaction(start, end, level)
+			d.mu.Unlock()
+		}
+		if len(manuals) == 0 {
+			manuals = []*manualCompaction{{
+				done:  make(chan error, 1),
+				level: level,
+				start: iStart,
+				end:   iEnd,
+			}}
 		}
-		if err := d.manualCompact(manual); err != nil {
+		if err := d.manualCompact(manuals...); err != nil {
 			return err
 		}
-		level = manual.outputLevel
+		level = manuals[0].outputLevel
+		for _, manual := range manuals[1:] {
+			if level < manual.outputLevel {
+				level = manual.outputLevel
+			}
+		}
 		if level == numLevels-1 {
 			// A manual compaction of the bottommost level occured. There is no next
 			// level to try and compact.
@@ -995,12 +1535,48 @@ func (d *DB) Compact(
 	return nil
 }
 
-func (d *DB) manualCompact(manual *manualCompaction) error {
+// manualCompact schedules the manual compactions and waits for them to
+// complete, returning the first error encountered.
+func (d *DB) manualCompact(manuals ...*manualCompaction) error {
 	d.mu.Lock()
-	d.mu.compact.manual = append(d.mu.compact.manual, manual)
+	d.mu.compact.manual = append(d.mu.compact.manual, manuals...)
 	d.maybeScheduleCompaction()
 	d.mu.Unlock()
-	return <-manual.done
+	var err error
+	for _, manual := range manuals {
+		err = firstError(err, <-manual.done)
+	}
+	return err
+}
+
+// splitManualCompaction splits the manual compaction of [start, end] at the
+// specified level into manual compactions of the disjoint key ranges spanned
+// by the overlapping sstables in the level and the level it compacts into.
+// The resulting compactions do not overlap one another, and so may run
+// concurrently.
+//
+// d.mu must be held when calling this.
+func (d *DB) splitManualCompaction(start, end []byte, level int) []*manualCompaction {
+	outputLevel := level + 1
+	if level == 0 {
+		outputLevel = d.mu.versions.picker.getBaseLevel()
+	}
+	if outputLevel >= numLevels {
+		outputLevel = numLevels - 1
+	}
+	cur := d.mu.versions.currentVersion()
+	keyRanges := calculateInuseKeyRanges(cur, d.cmp, level, outputLevel, start, end)
+	manuals := make([]*manualCompaction, len(keyRanges))
+	for i, r := range keyRanges {
+		manuals[i] = &manualCompaction{
+			done:  make(chan error, 1),
+			level: level,
+			start: base.MakeInternalKey(r.start, InternalKeySeqNumMax, InternalKeyKindMax),
+			end:   base.MakeInternalKey(r.end, 0, 0),
+			split: true,
+		}
+	}
+	return manuals
 }
 
 // Flush the memtable to stable storage.
@@ -1037,6 +1613,137 @@ func (d *DB) AsyncFlush() (<-chan struct{}, error) {
 	return flushed, nil
 }
 
+// CancelCompactions cancels the in-progress compactions which overlap the
+// range of user keys [start, end] and waits for them to stop. A nil start or
+// end leaves the range unbounded on that side. The outputs of the cancelled
+// compactions are discarded, and cancelled manual compactions return
+// ErrCancelledCompaction. Flushes are not cancelled.
+//
+// Note that automatic compactions over the range may be scheduled again as
+// soon as CancelCompactions returns. Callers which require that the range not
+// be compacted for a period should also disable automatic compactions (see
+// SetDisableAutomaticCompactions).
+func (d *DB) CancelCompactions(start, end []byte) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cancelled := d.cancelCompactionsLocked(start, end)
+	for len(cancelled) > 0 {
+		d.mu.compact.cond.Wait()
+		for i := 0; i < len(cancelled); {
+			if _, ok := d.mu.compact.inProgress[cancelled[i]]; ok {
+				i++
+				continue
+			}
+			cancelled[i] = cancelled[len(cancelled)-1]
+			cancelled = cancelled[:len(cancelled)-1]
+		}
+	}
+}
+
+// InProgressCompactions returns the compactions which are running, ordered by
+// job ID. The output tables and the error of the returned compactions are
+// unset, and their duration is the time elapsed since they started running.
+// A compaction which is waiting to run has a zero job ID and duration.
+// Flushes are not returned.
+func (d *DB) InProgressCompactions() []CompactionInfo {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.timeNow()
+	var infos []CompactionInfo
+	for c := range d.mu.compact.inProgress {
+		if len(c.flushing) != 0 {
+			continue
+		}
+		info := CompactionInfo{
+			JobID:  c.jobID,
+			Input:  make([]LevelInfo, len(c.inputs)),
+			Output: LevelInfo{Level: c.outputLevel.level},
+		}
+		if !c.startTime.IsZero() {
+			info.Duration = now.Sub(c.startTime)
+		}
+		for i, cl := range c.inputs {
+			info.Input[i].Level = cl.level
+			for _, m := range cl.files {
+				info.Input[i].Tables = append(info.Input[i].Tables, m.TableInfo())
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].JobID < infos[j].JobID
+	})
+	return infos
+}
+
+// cancelCompactionsLocked requests the cancellation of the in-progress
+// compactions which overlap the user key range [start, end], returning the
+// compactions which were cancelled.
+//
+// d.mu must be held when calling this.
+func (d *DB) cancelCompactionsLocked(start, end []byte) []*compaction {
+	var cancelled []*compaction
+	for c := range d.mu.compact.inProgress {
+		if start != nil && d.cmp(c.largest.UserKey, start) < 0 {
+			continue
+		}
+		if end != nil && d.cmp(c.smallest.UserKey, end) > 0 {
+			continue
+		}
+		if c.cancel() {
+			cancelled = append(cancelled, c)
+		}
+	}
+	return cancelled
+}
+
+// SetDisableAutomaticCompactions disables or re-enables the scheduling of
+// automatic compactions, overriding Options.DisableAutomaticCompactions.
+// Flushes and manual compactions are unaffected. Compactions which are already
+// running are allowed to complete. Re-enabling automatic compactions
+// immediately schedules any compactions which are needed.
+func (d *DB) SetDisableAutomaticCompactions(disable bool) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.compact.disableAutomatic = disable
+	if !disable {
+		d.maybeScheduleCompaction()
+	}
+}
+
+// AutomaticCompactionsDisabled returns true if the scheduling of automatic
+// compactions is disabled (see SetDisableAutomaticCompactions).
+func (d *DB) AutomaticCompactionsDisabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mu.compact.disableAutomatic
+}
+
+// estimatedDrainTime returns the time needed to compact the specified debt if
+// concurrency compactions run at the specified per-compaction throughput in
+// bytes per second. It returns 0 if the throughput is unknown.
+func estimatedDrainTime(debt uint64, throughput float64, concurrency int) time.Duration {
+	if debt == 0 || throughput <= 0 {
+		return 0
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return time.Duration(float64(debt) / (throughput * float64(concurrency)) * float64(time.Second))
+}
+
 // Metrics returns metrics about the database.
 func (d *DB) Metrics() *Metrics {
 	metrics := &Metrics{}
@@ -1045,6 +1752,23 @@ func (d *DB) Metrics() *Metrics {
 	d.mu.Lock()
 	*metrics = d.mu.versions.metrics
 	metrics.Compact.EstimatedDebt = d.mu.versions.picker.estimatedCompactionDebt(0)
+	metrics.Compact.Throughput = int64(d.mu.compact.throughput)
+	metrics.Compact.EstimatedDrainTime = estimatedDrainTime(
+		metrics.Compact.EstimatedDebt, d.mu.compact.throughput, d.opts.MaxConcurrentCompactions)
+	metrics.Compact.PacedRate = atomic.LoadInt64(&d.compactionPacing.rate)
+	metrics.Compact.PacingDelay = time.Duration(atomic.LoadInt64(&d.compactionPacing.delay))
+	d.writeStallMetricsLocked(metrics)
+	for c := range d.mu.compact.inProgress {
+		if c.flushing != nil {
+			continue
+		}
+		metrics.Compact.NumInProgress++
+		for _, cl := range c.inputs {
+			for _, m := range cl.files {
+				metrics.Compact.InProgressBytes += int64(m.Size)
+			}
+		}
+	}
 	for _, m := range d.mu.mem.queue {
 		metrics.MemTable.Size += m.totalBytes()
 	}
@@ -1064,6 +1788,20 @@ func (d *DB) Metrics() *Metrics {
 			metrics.Levels[level].Score = score
 		}
 	}
+	if d.deletionPacer != nil {
+		metrics.Table.PendingDeletionCount, metrics.Table.PendingDeletionSize = d.deletionPacer.pending()
+	}
+	for _, files := range d.mu.versions.currentVersion().Levels {
+		for _, f := range files {
+			if !f.Stats.Valid {
+				metrics.Table.PendingStatsCount++
+				continue
+			}
+			metrics.Table.NumEntries += f.Stats.NumEntries
+			metrics.Table.NumDeletions += f.Stats.NumDeletions
+			metrics.Table.RangeDeletionsBytesEstimate += f.Stats.RangeDeletionsBytesEstimate
+		}
+	}
 	metrics.Table.ZombieCount = int64(len(d.mu.versions.zombieTables))
 	for _, size := range d.mu.versions.zombieTables {
 		metrics.Table.ZombieSize += size
@@ -1072,15 +1810,79 @@ func (d *DB) Metrics() *Metrics {
 
 	metrics.BlockCache = d.opts.Cache.Metrics()
 	metrics.TableCache, metrics.Filter = d.tableCache.metrics()
+	metrics.TableCacheEvictions = d.tableCache.evictions()
+	metrics.TableOpenFiles = d.tableCache.openTables()
+	metrics.TableReopens = d.tableCache.reopens()
 	metrics.TableIters = int64(d.tableCache.iterCount())
+	metrics.Commit.Latency = d.commit.metrics.total.Snapshot()
+	metrics.Commit.QueueWaitLatency = d.commit.metrics.queueWait.Snapshot()
+	metrics.Commit.WALWriteLatency = d.commit.metrics.walWrite.Snapshot()
+	metrics.Commit.MemTableApplyLatency = d.commit.metrics.memTableApply.Snapshot()
+	metrics.Commit.SyncWaitLatency = d.commit.metrics.syncWait.Snapshot()
+	metrics.WAL.SyncLatency = d.walMetrics.SyncLatency.Snapshot()
+	metrics.WAL.Syncs = metrics.WAL.SyncLatency.Count
+	metrics.WAL.SyncBytes = d.walMetrics.SyncBytes.Snapshot()
+	metrics.WAL.SyncQueueLen = d.walMetrics.SyncQueueLen.Snapshot()
 	return metrics
 }
 
+// ResetCommitMetrics clears the commit latency histograms reported by
+// Metrics.Commit, along with the WAL sync histograms reported by Metrics.WAL
+// (including the count of WAL syncs). This allows the latency of each stage
+// of the commit pipeline to be measured over a specific interval, such as the
+// duration of a benchmark. Commits which are concurrently in progress may be
+// partially recorded.
+func (d *DB) ResetCommitMetrics() {
+	d.commit.metrics.reset()
+	d.walMetrics.SyncLatency.Reset()
+	d.walMetrics.SyncBytes.Reset()
+	d.walMetrics.SyncQueueLen.Reset()
+}
+
+// SSTableInfo exports manifest.TableInfo with sstable.Properties.
+type SSTableInfo struct {
+	manifest.TableInfo
+
+	// AtomicUnit identifies the atomic compaction unit containing the table:
+	// a run of adjacent tables in a level whose boundaries split the versions
+	// of a user key, or the range tombstones covering it. Such tables are
+	// always compacted together. AtomicUnit is the file number of the first
+	// table in the unit, so the tables of a level with the same AtomicUnit
+	// form a unit. A table which is not linked to its neighbours, including
+	// every table in L0, forms a unit by itself.
+	AtomicUnit FileNum
+
+	// Properties is the sstable properties of this table. It is only
+	// populated if the WithProperties option is passed to DB.SSTables.
+	Properties *sstable.Properties
+}
+
+// SSTablesOption sets an optional parameter of DB.SSTables.
+type SSTablesOption func(*sstablesOptions)
+
+type sstablesOptions struct {
+	// withProperties, if true, loads the properties of each sstable.
+	withProperties bool
+}
+
+// WithProperties enables returning the sstable properties of each table from
+// DB.SSTables.
+func WithProperties() SSTablesOption {
+	return func(opt *sstablesOptions) {
+		opt.withProperties = true
+	}
+}
+
 // SSTables retrieves the current sstables. The returned slice is indexed by
 // level and each level is indexed by the position of the sstable within the
 // level. Note that this information may be out of date due to concurrent
 // flushes and compactions.
-func (d *DB) SSTables() [][]TableInfo {
+func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
+	opt := &sstablesOptions{}
+	for _, fn := range opts {
+		fn(opt)
+	}
+
 	// Grab and reference the current readState.
 	readState := d.loadReadState()
 	defer readState.unref()
@@ -1095,18 +1897,35 @@ func (d *DB) SSTables() [][]TableInfo {
 		totalTables += len(srcLevels[i])
 	}
 
-	destTables := make([]TableInfo, totalTables)
-	destLevels := make([][]TableInfo, len(srcLevels))
+	destTables := make([]SSTableInfo, totalTables)
+	destLevels := make([][]SSTableInfo, len(srcLevels))
 	for i := range destLevels {
 		srcLevel := srcLevels[i]
 		destLevel := destTables[:len(srcLevel):len(srcLevel)]
 		destTables = destTables[len(srcLevel):]
+		var unit FileNum
+		var unitEnd int
 		for j := range destLevel {
-			destLevel[j] = srcLevel[j].TableInfo()
+			m := srcLevel[j]
+			if i == 0 {
+				unit = m.FileNum
+			} else if j >= unitEnd {
+				var start int
+				start, unitEnd = manifest.AtomicUnit(d.cmp, srcLevel, j)
+				unit = srcLevel[start].FileNum
+			}
+			destLevel[j] = SSTableInfo{TableInfo: m.TableInfo(), AtomicUnit: unit}
+			if opt.withProperties {
+				p, err := d.tableCache.getTableProperties(m)
+				if err != nil {
+					return nil, err
+				}
+				destLevel[j].Properties = p
+			}
 		}
 		destLevels[i] = destLevel
 	}
-	return destLevels
+	return destLevels, nil
 }
 
 // EstimateDiskUsage returns the estimated filesystem space used in bytes for
@@ -1128,13 +1947,89 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 		return 0, errors.New("invalid key-range specified (start > end)")
 	}
 
+	var totalSize uint64
+	err := d.forEachRangeTable(start, end, func(file *fileMetadata, contained bool) error {
+		if contained {
+			// The range fully contains the file, so skip looking it up in
+			// table cache/looking at its indexes, and add the full file size.
+			totalSize += file.Size
+			return nil
+		}
+		return d.tableCache.withReader(file, func(r *sstable.Reader) error {
+			start, end := virtualEstimateBounds(d.cmp, file, start, end)
+			size, err := r.EstimateDiskUsage(start, end)
+			totalSize += size
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return totalSize, nil
+}
+
+// EstimateKeyCount returns the estimated number of keys stored in sstables
+// within the range `[start, end]`. The count includes every version of a key,
+// along with deletion tombstones, that has yet to be compacted away. The
+// estimation is computed as follows:
+//
+// - For sstables fully contained in the range the number of entries in the
+//   sstable's properties is included.
+// - For sstables partially contained in the range the number of entries is
+//   prorated by the fraction of the sstable's data blocks which overlap the
+//   range, as determined by EstimateDiskUsage.
+// - Keys in the memtables are not included.
+func (d *DB) EstimateKeyCount(start, end []byte) (uint64, error) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.opts.Comparer.Compare(start, end) > 0 {
+		return 0, errors.New("invalid key-range specified (start > end)")
+	}
+
+	var totalCount uint64
+	err := d.forEachRangeTable(start, end, func(file *fileMetadata, contained bool) error {
+		return d.tableCache.withReader(file, func(r *sstable.Reader) error {
+			// The properties of the backing table of a virtual table describe
+			// the keys outside of the virtual table as well.
+			if contained && !file.Virtual() {
+				totalCount += r.Properties.NumEntries
+				return nil
+			}
+			start, end := virtualEstimateBounds(d.cmp, file, start, end)
+			if r.Properties.DataSize == 0 {
+				return nil
+			}
+			size, err := r.EstimateDiskUsage(start, end)
+			if err != nil {
+				return err
+			}
+			count := uint64(float64(r.Properties.NumEntries) * float64(size) / float64(r.Properties.DataSize))
+			if count > r.Properties.NumEntries {
+				count = r.Properties.NumEntries
+			}
+			totalCount += count
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return totalCount, nil
+}
+
+// forEachRangeTable calls fn for each sstable in the current version which
+// overlaps the range `[start, end]`, indicating whether the sstable is fully
+// contained within the range.
+func (d *DB) forEachRangeTable(
+	start, end []byte, fn func(file *fileMetadata, contained bool) error,
+) error {
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a concurrent
 	// compaction.
 	readState := d.loadReadState()
 	defer readState.unref()
 
-	var totalSize uint64
 	for level, files := range readState.current.Levels {
 		if level > 0 {
 			// We can only use `Overlaps` to restrict `files` at L1+ since at L0 it
@@ -1143,31 +2038,25 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 			files = readState.current.Overlaps(level, d.opts.Comparer.Compare, start, end)
 		}
 		for fileIdx, file := range files {
+			var err error
 			if level > 0 && fileIdx > 0 && fileIdx < len(files)-1 {
 				// The files to the left and the right at least partially overlap
 				// with `file`, which means `file` is fully contained within the
 				// range specified by `[start, end]`.
-				totalSize += file.Size
+				err = fn(file, true)
 			} else if d.opts.Comparer.Compare(start, file.Smallest.UserKey) <= 0 &&
 				d.opts.Comparer.Compare(file.Largest.UserKey, end) <= 0 {
-				// The range fully contains the file, so skip looking it up in
-				// table cache/looking at its indexes, and add the full file size.
-				totalSize += file.Size
+				err = fn(file, true)
 			} else if d.opts.Comparer.Compare(file.Smallest.UserKey, end) <= 0 &&
 				d.opts.Comparer.Compare(start, file.Largest.UserKey) <= 0 {
-				var size uint64
-				err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
-					size, err = r.EstimateDiskUsage(start, end)
-					return err
-				})
-				if err != nil {
-					return 0, err
-				}
-				totalSize += size
+				err = fn(file, false)
+			}
+			if err != nil {
+				return err
 			}
 		}
 	}
-	return totalSize, nil
+	return nil
 }
 
 func (d *DB) walPreallocateSize() int {
@@ -1186,7 +2075,8 @@ func (d *DB) walPreallocateSize() int {
 
 func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushableEntry) {
 	size := d.mu.mem.nextSize
-	if d.mu.mem.nextSize < d.opts.MemTableSize {
+	d.mu.mem.createdAt = d.timeNow()
+	if d.memTableSizer == nil && d.mu.mem.nextSize < d.opts.MemTableSize {
 		d.mu.mem.nextSize *= 2
 		if d.mu.mem.nextSize > d.opts.MemTableSize {
 			d.mu.mem.nextSize = d.opts.MemTableSize
@@ -1202,6 +2092,9 @@ func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushabl
 		arenaBuf:  manual.New(int(size)),
 		logSeqNum: logSeqNum,
 	})
+	if d.memTableSizer != nil {
+		atomic.StoreInt64(&d.memTableBatchThreshold, int64(size-int(mem.emptySize))/2)
+	}
 	if invariants.Enabled {
 		runtime.SetFinalizer(mem, checkMemTable)
 	}
@@ -1238,7 +2131,9 @@ func (d *DB) newFlushableEntry(f flushable, logNum FileNum, logSeqNum uint64) *f
 // may be released and reacquired.
 func (d *DB) makeRoomForWrite(b *Batch) error {
 	force := b == nil || b.flushable != nil
-	stalled := false
+	if b != nil {
+		d.maybeDelayWriteLocked()
+	}
 	for {
 		if d.mu.mem.switching {
 			d.mu.mem.cond.Wait()
@@ -1247,17 +2142,11 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		if b != nil && b.flushable == nil {
 			err := d.mu.mem.mutable.prepare(b)
 			if err != arenaskl.ErrArenaFull {
-				if stalled {
-					stalled = false
-					d.opts.EventListener.WriteStallEnd()
-				}
+				d.endWriteStopLocked()
 				return err
 			}
 		} else if !force {
-			if stalled {
-				stalled = false
-				d.opts.EventListener.WriteStallEnd()
-			}
+			d.endWriteStopLocked()
 			return nil
 		}
 		// force || err == ErrArenaFull, so we need to rotate the current memtable.
@@ -1269,28 +2158,20 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			if size >= uint64(d.opts.MemTableStopWritesThreshold)*uint64(d.opts.MemTableSize) {
 				// We have filled up the current memtable, but already queued memtables
 				// are still flushing, so we wait.
-				if !stalled {
-					stalled = true
-					d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-						Reason: "memtable count limit reached",
-					})
-				}
+				d.beginWriteStallLocked(writeStallMemTableLimit, false /* slowdown */)
 				d.mu.compact.cond.Wait()
 				continue
 			}
 		}
-		l0ReadAmp := len(d.mu.versions.currentVersion().Levels[0])
-		if d.opts.Experimental.L0SublevelCompactions {
-			l0ReadAmp = d.mu.versions.currentVersion().L0Sublevels.ReadAmplification()
-		}
-		if l0ReadAmp >= d.opts.L0StopWritesThreshold {
+		if d.l0ReadAmpLocked() >= d.opts.L0StopWritesThreshold {
 			// There are too many level-0 files, so we wait.
-			if !stalled {
-				stalled = true
-				d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-					Reason: "L0 file count limit exceeded",
-				})
-			}
+			d.beginWriteStallLocked(writeStallL0Limit, false /* slowdown */)
+			d.mu.compact.cond.Wait()
+			continue
+		}
+		if t := d.opts.CompactionDebtStopThreshold; t > 0 && d.compactionDebtLocked() >= t {
+			// There is too much data awaiting compaction, so we wait.
+			d.beginWriteStallLocked(writeStallCompactionDebtLimit, false /* slowdown */)
 			d.mu.compact.cond.Wait()
 			continue
 		}
@@ -1298,9 +2179,16 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		var newLogNum FileNum
 		var newLogFile vfs.File
 		var prevLogSize uint64
-		var err error
-
-		if !d.opts.DisableWAL {
+		var err, walErr error
+
+		if d.keyspace != nil {
+			// A keyspace does not have its own log. The new memtable is
+			// associated with the current log of the keyspace's parent, which
+			// must be marked as used for the keyspace's MANIFEST to record it as
+			// the minimum unflushed log number.
+			newLogNum = d.keyspace.parent.currentLogNum()
+			d.mu.versions.markFileNumUsed(newLogNum)
+		} else if !d.opts.DisableWAL {
 			jobID := d.mu.nextJobID
 			d.mu.nextJobID++
 			newLogNum = d.mu.versions.getNextFileNum()
@@ -1331,15 +2219,15 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 
 			if err == nil {
 				prevLogSize = uint64(d.mu.log.Size())
-				err = d.mu.log.Close()
-				if err != nil {
-					newLogFile.Close()
-				} else {
-					newLogFile = vfs.NewSyncingFile(newLogFile, vfs.SyncingFileOptions{
-						BytesPerSync:    d.opts.BytesPerSync,
-						PreallocateSize: d.walPreallocateSize(),
-					})
-				}
+				// An error closing the previous log means that writes to it may
+				// have been lost. They are still present in the memtable being
+				// rotated, so rather than failing, the DB enters the background
+				// error state until that memtable has been flushed.
+				walErr = d.mu.log.Close()
+				newLogFile = vfs.NewSyncingFile(newLogFile, vfs.SyncingFileOptions{
+					BytesPerSync:    d.opts.BytesPerSync,
+					PreallocateSize: d.walPreallocateSize(),
+				})
 			}
 
 			if recycleLogNum > 0 {
@@ -1359,6 +2247,9 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			d.mu.mem.cond.Broadcast()
 
 			d.mu.versions.metrics.WAL.Files++
+			if walErr != nil {
+				d.setBackgroundErrorLocked(walErr, FileNum(atomic.LoadUint64(&d.mu.log.num)))
+			}
 		}
 
 		if err != nil {
@@ -1370,10 +2261,14 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			panic(err)
 		}
 
-		if !d.opts.DisableWAL {
+		if d.keyspace == nil && !d.opts.DisableWAL {
 			d.mu.log.queue = append(d.mu.log.queue, newLogNum)
 			d.mu.log.LogWriter = record.NewLogWriter(newLogFile, newLogNum)
 			d.mu.log.LogWriter.SetMinSyncInterval(d.opts.WALMinSyncInterval)
+			d.mu.log.LogWriter.SetGroupCommit(
+				int64(d.opts.WALGroupCommitMaxBytes), d.opts.WALGroupCommitMaxWait)
+			d.mu.log.LogWriter.SetMetrics(&d.walMetrics)
+			atomic.StoreUint64(&d.mu.log.num, uint64(newLogNum))
 		}
 
 		immMem := d.mu.mem.mutable
@@ -1389,6 +2284,17 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			d.mu.mem.nextSize = int(immMem.totalBytes())
 		}
 
+		// If the memtable filled up and adaptive memtable sizing is enabled, size
+		// the next memtable based on the write throughput and flush backlog,
+		// ensuring that the batch will fit.
+		if d.memTableSizer != nil && b != nil && b.flushable == nil {
+			d.mu.mem.nextSize = d.memTableSizer.next(int(immMem.totalBytes()),
+				immMem.inuseBytes(), d.timeNow().Sub(d.mu.mem.createdAt), len(d.mu.mem.queue)-1)
+			if need := int(b.memTableSize + immMem.emptySize); d.mu.mem.nextSize < need {
+				d.mu.mem.nextSize = need
+			}
+		}
+
 		if b != nil && b.flushable != nil {
 			// The batch is too large to fit in the memtable so add it directly to
 			// the immutable queue. The flushable batch is associated with the same
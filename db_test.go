@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -431,6 +432,42 @@ func TestLargeBatch(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestApplyAsync(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+
+	// Commit synced batches without waiting for each to complete, including a
+	// large batch which is added to the flushable queue.
+	const n = 100
+	errCh := make(chan error, n+1)
+	for i := 0; i < n; i++ {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("%03d", i)), nil, nil))
+		require.NoError(t, b.CommitAsync(Sync, func(err error) {
+			errCh <- firstError(err, b.Close())
+		}))
+	}
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("large"), make([]byte, d.largeBatchThreshold), nil))
+	require.NoError(t, d.ApplyAsync(b, Sync, func(err error) {
+		errCh <- err
+	}))
+	for i := 0; i <= n; i++ {
+		require.NoError(t, <-errCh)
+	}
+
+	for i := 0; i < n; i++ {
+		_, closer, err := d.Get([]byte(fmt.Sprintf("%03d", i)))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+	_, closer, err := d.Get([]byte("large"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	require.Error(t, d.ApplyAsync(d.NewBatch(), nil, nil))
+	require.NoError(t, d.Close())
+}
+
 func TestGetNoCache(t *testing.T) {
 	cache := NewCache(0)
 	defer cache.Unref()
@@ -448,6 +485,64 @@ func TestGetNoCache(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestReadWithContext(t *testing.T) {
+	cache := NewCache(0)
+	defer cache.Unref()
+
+	opts := &Options{
+		Cache: cache,
+		FS:    vfs.NewMem(),
+	}
+	opts.EnsureDefaults()
+	opts.Levels[0].BlockSize = 1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprint(i)), []byte("v"), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("mem"), []byte("v"), nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v, closer, err := d.GetWithContext(ctx, []byte("1"))
+	require.NoError(t, err)
+	require.Equal(t, "v", string(v))
+	require.NoError(t, closer.Close())
+
+	// Reads are cancelled partway through iteration.
+	iter := d.NewIterWithContext(ctx, nil)
+	require.True(t, iter.First())
+	cancel()
+	for iter.Next() {
+	}
+	require.True(t, errors.Is(iter.Error(), context.Canceled))
+	require.True(t, errors.Is(iter.Close(), context.Canceled))
+
+	_, _, err = d.GetWithContext(ctx, []byte("1"))
+	require.True(t, errors.Is(err, context.Canceled))
+
+	// Reads which do not reach the sstables are not affected.
+	v, closer, err = d.GetWithContext(ctx, []byte("mem"))
+	require.NoError(t, err)
+	require.Equal(t, "v", string(v))
+	require.NoError(t, closer.Close())
+
+	// Deadlines are enforced.
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	snap := d.NewSnapshot()
+	_, _, err = snap.GetWithContext(ctx, []byte("1"))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	iter = snap.NewIterWithContext(ctx, nil)
+	require.False(t, iter.SeekGE([]byte("1")))
+	require.True(t, errors.Is(iter.Close(), context.DeadlineExceeded))
+	require.NoError(t, snap.Close())
+}
+
 func TestGetMerge(t *testing.T) {
 	d, err := Open("", &Options{
 		FS: vfs.NewMem(),
@@ -676,6 +771,52 @@ func TestUnremovableSingleDelete(t *testing.T) {
 	verifyGetNotFound(t, d, key)
 }
 
+func TestGetNoCopy(t *testing.T) {
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("a"), 1<<10), nil))
+	require.NoError(t, d.Flush())
+
+	// Both values are backed by the same cached block rather than by copies.
+	v1, closer1, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	v2, closer2, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.True(t, &v1[0] == &v2[0])
+	require.NoError(t, closer1.Close())
+	require.NoError(t, closer2.Close())
+}
+
+func TestGetLeak(t *testing.T) {
+	for _, flush := range []bool{true, false} {
+		t.Run(fmt.Sprintf("flush=%t", flush), func(t *testing.T) {
+			d, err := Open("", &Options{
+				FS: vfs.NewMem(),
+			})
+			require.NoError(t, err)
+
+			require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+			if flush {
+				require.NoError(t, d.Flush())
+			}
+			_, closer, err := d.Get([]byte("a"))
+			require.NoError(t, err)
+			defer closer.Close()
+			if err := d.Close(); err == nil {
+				t.Fatalf("expected failure, but found success")
+			} else if !strings.HasPrefix(err.Error(), "leaked iterators:") {
+				t.Fatalf("expected leaked iterators, but found %+v", err)
+			}
+		})
+	}
+}
+
 func TestIterLeak(t *testing.T) {
 	for _, leak := range []bool{true, false} {
 		t.Run(fmt.Sprintf("leak=%t", leak), func(t *testing.T) {
@@ -813,7 +954,7 @@ func TestCacheEvict(t *testing.T) {
 		require.NoError(t, d.Delete(key, nil))
 	}
 
-	require.NoError(t, d.Compact([]byte("0"), []byte("1")))
+	require.NoError(t, d.Compact([]byte("0"), []byte("1"), false))
 
 	if size := cache.Size(); size != 0 {
 		t.Fatalf("expected empty cache, but found %d", size)
@@ -916,7 +1057,7 @@ func TestDBClosed(t *testing.T) {
 
 	require.EqualValues(t, ErrClosed, catch(func() { _ = d.Close() }))
 
-	require.EqualValues(t, ErrClosed, catch(func() { _ = d.Compact(nil, nil) }))
+	require.EqualValues(t, ErrClosed, catch(func() { _ = d.Compact(nil, nil, false) }))
 	require.EqualValues(t, ErrClosed, catch(func() { _ = d.Flush() }))
 	require.EqualValues(t, ErrClosed, catch(func() { _, _ = d.AsyncFlush() }))
 
@@ -954,7 +1095,7 @@ func TestDBConcurrentCommitCompactFlush(t *testing.T) {
 			var err error
 			switch i % 3 {
 			case 0:
-				err = d.Compact(nil, []byte("\xff"))
+				err = d.Compact(nil, []byte("\xff"), false)
 			case 1:
 				err = d.Flush()
 			case 2:
@@ -1053,7 +1194,7 @@ func TestCloseCleanerRace(t *testing.T) {
 		it := db.NewIter(nil)
 		require.NotNil(t, it)
 		require.NoError(t, db.DeleteRange([]byte("a"), []byte("b"), Sync))
-		require.NoError(t, db.Compact([]byte("a"), []byte("b")))
+		require.NoError(t, db.Compact([]byte("a"), []byte("b"), false))
 		// Only the iterator is keeping the sstables alive.
 		files, err := mem.List("/")
 		require.NoError(t, err)
@@ -1149,3 +1290,84 @@ func verifyGetNotFound(t *testing.T, r Reader, key []byte) {
 		t.Fatalf("expected nil, but got %s", val)
 	}
 }
+
+func TestEstimateKeyCount(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%04d", i)), value, nil))
+	}
+	// Keys in the memtable are not counted.
+	n, err := d.EstimateKeyCount([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), n)
+
+	require.NoError(t, d.Compact([]byte("key0000"), []byte("key1000"), false))
+	n, err = d.EstimateKeyCount([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), n)
+
+	// The count for a partially overlapping range is accurate to within a
+	// few data blocks.
+	n, err = d.EstimateKeyCount([]byte("key0250"), []byte("key0749"))
+	require.NoError(t, err)
+	require.True(t, n >= 450 && n <= 550, "%d", n)
+
+	n, err = d.EstimateKeyCount([]byte("z"), []byte("zz"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), n)
+
+	_, err = d.EstimateKeyCount([]byte("z"), []byte("a"))
+	require.Error(t, err)
+}
+
+func TestSSTables(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, d.Flush())
+
+	// Properties are only loaded when requested.
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(tables[0]))
+	for _, info := range tables[0] {
+		require.Nil(t, info.Properties)
+	}
+
+	tables, err = d.SSTables(WithProperties())
+	require.NoError(t, err)
+	var totalTables int
+	for _, level := range tables {
+		totalTables += len(level)
+	}
+	require.Equal(t, 2, totalTables)
+	for _, info := range tables[0] {
+		require.NotNil(t, info.Properties)
+		require.Equal(t, uint64(2), info.Properties.NumEntries)
+		switch string(info.Smallest.UserKey) {
+		case "a":
+			require.Equal(t, "b", string(info.Largest.UserKey))
+			require.Equal(t, uint64(0), info.Properties.NumDeletions)
+		case "b":
+			require.Equal(t, "c", string(info.Largest.UserKey))
+			require.Equal(t, uint64(1), info.Properties.NumDeletions)
+		default:
+			t.Fatalf("unexpected table %s", info.FileNum)
+		}
+		require.True(t, info.SmallestSeqNum <= info.LargestSeqNum)
+	}
+}
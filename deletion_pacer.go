@@ -0,0 +1,145 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/rate"
+)
+
+// obsoleteTable is an obsolete sstable queued for deletion by a
+// deletionPacer.
+type obsoleteTable struct {
+	jobID   int
+	path    string
+	fileNum FileNum
+	size    uint64
+}
+
+// deletionPacer deletes obsolete sstables in the background, limiting the
+// rate of deletion to a target number of bytes per second (see
+// Options.Experimental.TargetByteDeletionRate). Deleting a large number of
+// sstables at once, such as after a large compaction, can cause IO latency
+// spikes on some filesystems. Pacing the deletions spreads that IO out.
+type deletionPacer struct {
+	limiter limiter
+	// deleteFn deletes an sstable. It is called without DB.mu held.
+	deleteFn func(t obsoleteTable)
+	// closedCh is closed when the pacer is closed, interrupting any ongoing
+	// wait so that the remaining sstables are deleted without pacing.
+	closedCh chan struct{}
+	done     chan struct{}
+
+	mu struct {
+		sync.Mutex
+		cond   sync.Cond
+		queue  []obsoleteTable
+		closed bool
+		// The number and total size of the sstables which have been queued but
+		// not yet deleted, including the one currently being deleted.
+		pendingCount int64
+		pendingSize  uint64
+	}
+}
+
+func newDeletionPacer(bytesPerSec int, deleteFn func(t obsoleteTable)) *deletionPacer {
+	p := &deletionPacer{
+		limiter:  rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec),
+		deleteFn: deleteFn,
+		closedCh: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	p.mu.cond.L = &p.mu.Mutex
+	go pprof.Do(context.Background(), gcLabels, func(context.Context) {
+		p.run()
+	})
+	return p
+}
+
+// enqueue queues the specified sstables for deletion.
+func (p *deletionPacer) enqueue(tables []obsoleteTable) {
+	if len(tables) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.queue = append(p.mu.queue, tables...)
+	for _, t := range tables {
+		p.mu.pendingCount++
+		p.mu.pendingSize += t.size
+	}
+	p.mu.cond.Signal()
+}
+
+// pending returns the number and total size of the sstables awaiting deletion.
+func (p *deletionPacer) pending() (count int64, size uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mu.pendingCount, p.mu.pendingSize
+}
+
+// close deletes the remaining queued sstables without pacing, and waits for
+// the deletions to complete.
+func (p *deletionPacer) close() {
+	p.mu.Lock()
+	p.mu.closed = true
+	p.mu.cond.Signal()
+	p.mu.Unlock()
+	close(p.closedCh)
+	<-p.done
+}
+
+func (p *deletionPacer) run() {
+	defer close(p.done)
+	for {
+		p.mu.Lock()
+		for len(p.mu.queue) == 0 && !p.mu.closed {
+			p.mu.cond.Wait()
+		}
+		if len(p.mu.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		t := p.mu.queue[0]
+		p.mu.queue = p.mu.queue[1:]
+		p.mu.Unlock()
+
+		p.wait(t.size)
+		p.deleteFn(t)
+
+		p.mu.Lock()
+		p.mu.pendingCount--
+		p.mu.pendingSize -= t.size
+		p.mu.Unlock()
+	}
+}
+
+// wait waits until size bytes may be deleted at the target rate, or until the
+// pacer is closed.
+func (p *deletionPacer) wait(size uint64) {
+	// Reservations larger than the burst are not allowed, so the size is
+	// reserved in chunks. The delay of the last reservation includes the
+	// delays of the earlier ones.
+	now := time.Now()
+	burst := uint64(p.limiter.Burst())
+	for size > burst {
+		p.limiter.DelayN(now, int(burst))
+		size -= burst
+	}
+	delay := p.limiter.DelayN(now, int(size))
+	if delay <= 0 {
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-p.closedCh:
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletionPacer(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []FileNum
+	// At 100 bytes per second, the first 100 byte table is deleted immediately
+	// and each subsequent table a second later.
+	p := newDeletionPacer(100, func(t obsoleteTable) {
+		mu.Lock()
+		defer mu.Unlock()
+		deleted = append(deleted, t.fileNum)
+	})
+	p.enqueue([]obsoleteTable{
+		{fileNum: 1, size: 100},
+		{fileNum: 2, size: 100},
+		{fileNum: 3, size: 100},
+	})
+
+	waitDeleted := func(n int) {
+		for {
+			mu.Lock()
+			m := len(deleted)
+			mu.Unlock()
+			if m >= n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitDeleted(1)
+	count, size := p.pending()
+	require.EqualValues(t, 2, count)
+	require.EqualValues(t, 200, size)
+
+	// Closing the pacer deletes the remaining tables without waiting.
+	start := time.Now()
+	p.close()
+	require.True(t, time.Since(start) < time.Second)
+	require.Equal(t, []FileNum{1, 2, 3}, deleted)
+	count, size = p.pending()
+	require.EqualValues(t, 0, count)
+	require.EqualValues(t, 0, size)
+}
+
+func TestTargetByteDeletionRate(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	// A rate of 1 byte per second ensures that no more than one table is
+	// deleted for the duration of the test.
+	opts.Experimental.TargetByteDeletionRate = 1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	tables := func() int {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var n int
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeTable {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Each compaction rewrites the table in L6, making the previous L0 and L6
+	// tables obsolete.
+	for i := 0; i < 5; i++ {
+		require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	}
+	m := d.Metrics()
+	require.True(t, m.Table.PendingDeletionCount >= 7, "pending: %d", m.Table.PendingDeletionCount)
+	require.True(t, m.Table.PendingDeletionSize > 0)
+	require.EqualValues(t, 1+m.Table.PendingDeletionCount, tables())
+
+	// The queue is drained when the DB is closed.
+	require.NoError(t, d.Close())
+	require.Equal(t, 1, tables())
+}
@@ -163,11 +163,40 @@ func (i ManifestDeleteInfo) String() string {
 	return fmt.Sprintf("[JOB %d] MANIFEST deleted %s", i.JobID, i.FileNum)
 }
 
+// ManifestRecoveryInfo contains the info for a MANIFEST recovery event,
+// which occurs when Open cannot read the entirety of the current MANIFEST.
+type ManifestRecoveryInfo struct {
+	// Path is the path of the MANIFEST which could not be read in its
+	// entirety.
+	Path    string
+	FileNum FileNum
+	// Offset is the byte offset within the MANIFEST of the first version edit
+	// which could not be read, and Edits is the number of version edits which
+	// were read before it.
+	Offset int64
+	Edits  int
+	// FallbackPath is the path of the previous MANIFEST from which the DB was
+	// recovered, if the MANIFEST at Path was unusable. See
+	// ManifestRecoveryTruncate.
+	FallbackPath string
+	// Err is the error encountered reading the version edit at Offset.
+	Err error
+}
+
+func (i ManifestRecoveryInfo) String() string {
+	if i.FallbackPath != "" {
+		return fmt.Sprintf("MANIFEST %s unusable (%s); recovered from %s",
+			i.Path, i.Err, i.FallbackPath)
+	}
+	return fmt.Sprintf("MANIFEST %s truncated at offset %d after %d edits: %s",
+		i.Path, i.Offset, i.Edits, i.Err)
+}
+
 // TableCreateInfo contains the info for a table creation event.
 type TableCreateInfo struct {
 	JobID int
-	// Reason is the reason for the table creation: "compacting", "flushing", or
-	// "ingesting".
+	// Reason is the reason for the table creation: "compacting", "excising",
+	// "flushing", or "ingesting".
 	Reason  string
 	Path    string
 	FileNum FileNum
@@ -280,10 +309,48 @@ func (i WALDeleteInfo) String() string {
 // WriteStallBeginInfo contains the info for a write stall begin event.
 type WriteStallBeginInfo struct {
 	Reason string
+	// Slowdown is true if writes are being delayed (see
+	// Options.WriteSlowdownMaxDelay) rather than stopped.
+	Slowdown bool
+	// The L0 read-amplification, the number of queued memtables (including
+	// the mutable memtable) and the estimated compaction debt when the stall
+	// began. The compaction debt is only estimated if
+	// Options.CompactionDebtSlowdownThreshold or
+	// Options.CompactionDebtStopThreshold is set.
+	L0ReadAmp      int
+	MemTableCount  int
+	CompactionDebt uint64
 }
 
 func (i WriteStallBeginInfo) String() string {
-	return fmt.Sprintf("write stall beginning: %s", i.Reason)
+	kind := "stall"
+	if i.Slowdown {
+		kind = "slowdown"
+	}
+	return fmt.Sprintf("write %s beginning: %s (L0 read-amp %d, %d memtables, compaction debt %s)",
+		kind, i.Reason, i.L0ReadAmp, i.MemTableCount, humanize.IEC.Uint64(i.CompactionDebt))
+}
+
+// DiskSlowInfo contains the info for a disk slowness event when writing to a
+// file.
+type DiskSlowInfo struct {
+	// Path of file being written to.
+	Path string
+	// Duration that has elapsed since this disk operation started.
+	Duration time.Duration
+}
+
+func (i DiskSlowInfo) String() string {
+	return fmt.Sprintf("disk slowness detected: write to file %s has been ongoing for %0.1fs",
+		i.Path, i.Duration.Seconds())
+}
+
+// BackgroundErrorStateInfo contains the info for the events of the DB
+// entering and leaving the background error state. See DB.Resume.
+type BackgroundErrorStateInfo struct {
+	// Err is the error which caused the DB to enter the background error
+	// state.
+	Err error
 }
 
 // EventListener contains a set of functions that will be invoked when various
@@ -296,6 +363,15 @@ type EventListener struct {
 	// operation such as flush or compaction.
 	BackgroundError func(error)
 
+	// BackgroundErrorStateBegin is invoked when a background error causes the
+	// DB to enter the background error state, in which writes are rejected.
+	// See DB.Resume.
+	BackgroundErrorStateBegin func(BackgroundErrorStateInfo)
+
+	// BackgroundErrorStateEnd is invoked when the DB leaves the background
+	// error state, and writes are accepted again.
+	BackgroundErrorStateEnd func(BackgroundErrorStateInfo)
+
 	// CompactionBegin is invoked after the inputs to a compaction have been
 	// determined, but before the compaction has produced any output.
 	CompactionBegin func(CompactionInfo)
@@ -304,6 +380,12 @@ type EventListener struct {
 	// has been installed.
 	CompactionEnd func(CompactionInfo)
 
+	// DiskSlow is invoked after a disk write operation on a file created
+	// with a disk health checking vfs.FS (see vfs.WithDiskHealthChecks) is
+	// observed to exceed the specified disk slowness threshold duration. See
+	// Options.DiskSlowThreshold.
+	DiskSlow func(DiskSlowInfo)
+
 	// FlushBegin is invoked after the inputs to a flush have been determined,
 	// but before the flush has produced any output.
 	FlushBegin func(FlushInfo)
@@ -318,6 +400,11 @@ type EventListener struct {
 	// ManifestDeleted is invoked after a manifest has been deleted.
 	ManifestDeleted func(ManifestDeleteInfo)
 
+	// ManifestRecovered is invoked during Open if the current MANIFEST could
+	// not be read in its entirety, or the DB was recovered from a previous
+	// MANIFEST. See ManifestRecoveryMode.
+	ManifestRecovered func(ManifestRecoveryInfo)
+
 	// TableCreated is invoked when a table has been created.
 	TableCreated func(TableCreateInfo)
 
@@ -338,10 +425,13 @@ type EventListener struct {
 	// WALDeleted is invoked after a WAL has been deleted.
 	WALDeleted func(WALDeleteInfo)
 
-	// WriteStallBegin is invoked when writes are intentionally delayed.
+	// WriteStallBegin is invoked when writes are intentionally stopped or
+	// slowed down. A stall which changes reason or changes between stopping
+	// and slowing down writes is reported as the end of one stall and the
+	// beginning of another.
 	WriteStallBegin func(WriteStallBeginInfo)
 
-	// WriteStallEnd is invoked when delayed writes are released.
+	// WriteStallEnd is invoked when stopped or delayed writes are released.
 	WriteStallEnd func()
 }
 
@@ -355,12 +445,21 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 			logger.Infof("background error: %s", err)
 		}
 	}
+	if l.BackgroundErrorStateBegin == nil {
+		l.BackgroundErrorStateBegin = func(info BackgroundErrorStateInfo) {}
+	}
+	if l.BackgroundErrorStateEnd == nil {
+		l.BackgroundErrorStateEnd = func(info BackgroundErrorStateInfo) {}
+	}
 	if l.CompactionBegin == nil {
 		l.CompactionBegin = func(info CompactionInfo) {}
 	}
 	if l.CompactionEnd == nil {
 		l.CompactionEnd = func(info CompactionInfo) {}
 	}
+	if l.DiskSlow == nil {
+		l.DiskSlow = func(info DiskSlowInfo) {}
+	}
 	if l.FlushBegin == nil {
 		l.FlushBegin = func(info FlushInfo) {}
 	}
@@ -373,6 +472,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.ManifestDeleted == nil {
 		l.ManifestDeleted = func(info ManifestDeleteInfo) {}
 	}
+	if l.ManifestRecovered == nil {
+		l.ManifestRecovered = func(info ManifestRecoveryInfo) {}
+	}
 	if l.TableCreated == nil {
 		l.TableCreated = func(info TableCreateInfo) {}
 	}
@@ -410,12 +512,21 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		BackgroundError: func(err error) {
 			logger.Infof("background error: %s", err)
 		},
+		BackgroundErrorStateBegin: func(info BackgroundErrorStateInfo) {
+			logger.Infof("background error state: writes stopped: %s", info.Err)
+		},
+		BackgroundErrorStateEnd: func(info BackgroundErrorStateInfo) {
+			logger.Infof("background error state ending: writes resumed")
+		},
 		CompactionBegin: func(info CompactionInfo) {
 			logger.Infof("%s", info)
 		},
 		CompactionEnd: func(info CompactionInfo) {
 			logger.Infof("%s", info)
 		},
+		DiskSlow: func(info DiskSlowInfo) {
+			logger.Infof("%s", info)
+		},
 		FlushBegin: func(info FlushInfo) {
 			logger.Infof("%s", info)
 		},
@@ -428,6 +539,9 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		ManifestDeleted: func(info ManifestDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		ManifestRecovered: func(info ManifestRecoveryInfo) {
+			logger.Infof("%s", info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			logger.Infof("%s", info)
 		},
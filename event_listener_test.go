@@ -184,7 +184,7 @@ func TestEventListener(t *testing.T) {
 			if err := d.Set([]byte("a"), nil, nil); err != nil {
 				return err.Error()
 			}
-			if err := d.Compact([]byte("a"), []byte("b")); err != nil {
+			if err := d.Compact([]byte("a"), []byte("b"), false); err != nil {
 				return err.Error()
 			}
 			return buf.String()
@@ -246,7 +246,11 @@ func TestEventListener(t *testing.T) {
 
 		case "sstables":
 			var buf bytes.Buffer
-			for i, level := range d.SSTables() {
+			levels, err := d.SSTables()
+			if err != nil {
+				return err.Error()
+			}
+			for i, level := range levels {
 				if len(level) == 0 {
 					continue
 				}
@@ -337,6 +341,14 @@ func TestWriteStallEvents(t *testing.T) {
 			events := buf.String()
 			require.Contains(t, events, c.expected)
 			require.Contains(t, events, writeStallEnd)
+
+			m := d.Metrics()
+			require.True(t, m.WriteStall.Count >= 1)
+			if c.delayFlush {
+				require.True(t, m.WriteStall.MemTableCount >= 1)
+			} else {
+				require.True(t, m.WriteStall.L0Count >= 1)
+			}
 			if testing.Verbose() {
 				t.Logf("\n%s", events)
 			}
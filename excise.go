@@ -0,0 +1,309 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// exciseOverlaps returns true if the bounds of the table f overlap the user
+// key span [start, end).
+func exciseOverlaps(cmp Compare, f *fileMetadata, start, end []byte) bool {
+	if cmp(f.Smallest.UserKey, end) >= 0 {
+		return false
+	}
+	c := cmp(f.Largest.UserKey, start)
+	return c > 0 || (c == 0 && f.Largest.Trailer != InternalKeyRangeDeleteSentinel)
+}
+
+// exciseContains returns true if the bounds of the table f lie within the user
+// key span [start, end).
+func exciseContains(cmp Compare, f *fileMetadata, start, end []byte) bool {
+	if cmp(f.Smallest.UserKey, start) < 0 {
+		return false
+	}
+	c := cmp(f.Largest.UserKey, end)
+	return c < 0 || (c == 0 && f.Largest.Trailer == InternalKeyRangeDeleteSentinel)
+}
+
+type exciseInput struct {
+	level int
+	meta  *fileMetadata
+}
+
+// exciseInputsLocked returns the tables of the current version which overlap
+// [start, end) and contain keys with sequence numbers less than seqNum. The
+// second return value is true if any of the tables are being compacted.
+//
+// d.mu must be held when calling this.
+func (d *DB) exciseInputsLocked(start, end []byte, seqNum uint64) ([]exciseInput, bool) {
+	var inputs []exciseInput
+	var compacting bool
+	current := d.mu.versions.currentVersion()
+	for level := range current.Levels {
+		for _, f := range current.Levels[level] {
+			if f.SmallestSeqNum >= seqNum || !exciseOverlaps(d.cmp, f, start, end) {
+				continue
+			}
+			compacting = compacting || f.Compacting
+			inputs = append(inputs, exciseInput{level: level, meta: f})
+		}
+	}
+	return inputs, compacting
+}
+
+// exciseLocked removes the keys in the excised span [start, end) with sequence
+// numbers less than seqNum from the LSM, recording the deletion of the tables overlapping
+// the span in ve, along with the addition of virtual tables exposing the keys
+// of those tables which lie outside of the span (see exciseVirtualTables). No
+// data is rewritten. The compactions of the tables overlapping the span are
+// cancelled, and the tables are marked as compacting so that they are not
+// picked by a compaction before the version edit is applied. The excised
+// tables are returned so that the excision can be abandoned by
+// exciseCleanupLocked if the version edit is not applied.
+//
+// A table overlapping the span which contains keys with sequence numbers both
+// less than and greater than or equal to seqNum cannot be excised, as the
+// keys written after the ingestion began would be removed along with it, in
+// which case an error is returned.
+//
+// On success, exciseLocked returns with the manifest locked, ensuring that no
+// table overlapping the span is added before the version edit is applied.
+// d.mu must be held when calling this, though it may be dropped and
+// re-acquired while waiting for compactions and locking the manifest.
+func (d *DB) exciseLocked(
+	excise *exciseSpan, seqNum uint64, ve *versionEdit,
+) ([]*fileMetadata, error) {
+	start, end := excise.start, excise.end
+	var inputs []exciseInput
+	var manifestLocked bool
+	for {
+		var compacting bool
+		inputs, compacting = d.exciseInputsLocked(start, end, seqNum)
+		if compacting {
+			// Cancel the compactions of the tables overlapping the span, and wait
+			// for them to finish.
+			if manifestLocked {
+				d.mu.versions.logUnlock()
+				manifestLocked = false
+			}
+			d.cancelCompactionsLocked(start, end)
+			d.mu.compact.cond.Wait()
+			continue
+		}
+		if manifestLocked {
+			break
+		}
+		// Locking the manifest may drop d.mu, during which a compaction or
+		// flush may add or pick another table overlapping the span.
+		d.mu.versions.logLock()
+		manifestLocked = true
+	}
+
+	for _, in := range inputs {
+		if in.meta.LargestSeqNum >= seqNum {
+			d.mu.versions.logUnlock()
+			return nil, errors.Errorf("pebble: cannot excise table %s, which contains keys "+
+				"written after the ingestion began", in.meta)
+		}
+	}
+	if ve.DeletedFiles == nil {
+		ve.DeletedFiles = make(map[deletedFileEntry]bool)
+	}
+	excised := make([]*fileMetadata, 0, len(inputs))
+	for _, in := range inputs {
+		in.meta.Compacting = true
+		excised = append(excised, in.meta)
+		ve.DeletedFiles[deletedFileEntry{Level: in.level, FileNum: in.meta.FileNum}] = true
+		for _, m := range d.exciseVirtualTablesLocked(in.meta, excise) {
+			ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: in.level, Meta: m})
+		}
+	}
+	d.mu.versions.currentVersion().L0Sublevels.InitCompactingFileInfo()
+	return excised, nil
+}
+
+// exciseCleanupLocked abandons an excision, unmarking the excised tables as
+// compacting.
+//
+// d.mu must be held when calling this.
+func (d *DB) exciseCleanupLocked(excised []*fileMetadata) {
+	for _, f := range excised {
+		f.Compacting = false
+	}
+	d.mu.versions.currentVersion().L0Sublevels.InitCompactingFileInfo()
+	// Wake up any excision waiting for the tables.
+	d.mu.compact.cond.Broadcast()
+}
+
+// exciseRemainder describes the part of a table remaining once a span is
+// excised from it, which is exposed by a virtual table.
+type exciseRemainder struct {
+	smallest, largest InternalKey
+}
+
+// exciseRemainders returns the parts of the table f remaining once [start,
+// end) is excised from it: at most one before start, and one at or after end.
+// The index of each part within the returned array identifies it, and a nil
+// part does not exist.
+func exciseRemainders(cmp Compare, f *fileMetadata, start, end []byte) [2]*exciseRemainder {
+	var parts [2]*exciseRemainder
+	if cmp(f.Smallest.UserKey, start) < 0 {
+		parts[0] = &exciseRemainder{
+			smallest: f.Smallest,
+			largest:  base.MakeRangeDeleteSentinelKey(append([]byte(nil), start...)),
+		}
+	}
+	if c := cmp(f.Largest.UserKey, end); c > 0 || (c == 0 && f.Largest.Trailer != InternalKeyRangeDeleteSentinel) {
+		parts[1] = &exciseRemainder{
+			smallest: base.MakeSearchKey(append([]byte(nil), end...)),
+			largest:  f.Largest,
+		}
+	}
+	return parts
+}
+
+// exciseEstimateSizes estimates the sizes of the virtual tables which will
+// replace the tables of the current version overlapping the excised span,
+// recording them in excise.sizes. The estimates are computed from the index
+// of each table, which may need to be read, so d.mu must not be held when
+// calling this.
+func (d *DB) exciseEstimateSizes(excise *exciseSpan) {
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	excise.sizes = make(map[FileNum][2]uint64)
+	for _, files := range readState.current.Levels {
+		for _, f := range files {
+			if !exciseOverlaps(d.cmp, f, excise.start, excise.end) {
+				continue
+			}
+			var sizes [2]uint64
+			for i, part := range exciseRemainders(d.cmp, f, excise.start, excise.end) {
+				if part == nil {
+					continue
+				}
+				// The estimate is never larger than the table being excised.
+				sizes[i] = f.Size
+				m := &fileMetadata{
+					BackingFileNum: f.PhysicalFileNum(),
+					Smallest:       part.smallest,
+					Largest:        part.largest,
+				}
+				lower, upper := virtualEstimateBounds(d.cmp, m, part.smallest.UserKey, part.largest.UserKey)
+				_ = d.tableCache.withReader(f, func(r *sstable.Reader) error {
+					size, err := r.EstimateDiskUsage(lower, upper)
+					if err == nil && size < sizes[i] {
+						sizes[i] = size
+					}
+					return err
+				})
+			}
+			excise.sizes[f.FileNum] = sizes
+		}
+	}
+}
+
+// exciseVirtualTablesLocked returns the virtual tables replacing the table f
+// once the span is excised from it: at most one exposing the keys of f before
+// the span, and one exposing the keys of f at or after its end. Both are
+// backed by the table holding the data of f, and share its sequence numbers.
+// Their sizes are those estimated by exciseEstimateSizes, or the size of f if
+// f was added to the LSM since.
+//
+// d.mu must be held when calling this.
+func (d *DB) exciseVirtualTablesLocked(f *fileMetadata, excise *exciseSpan) []*fileMetadata {
+	sizes, estimated := excise.sizes[f.FileNum]
+	var metas []*fileMetadata
+	for i, part := range exciseRemainders(d.cmp, f, excise.start, excise.end) {
+		if part == nil {
+			continue
+		}
+		m := &fileMetadata{
+			FileNum:             d.mu.versions.getNextFileNum(),
+			BackingFileNum:      f.PhysicalFileNum(),
+			External:            f.External,
+			CreationTime:        f.CreationTime,
+			Size:                f.Size,
+			Smallest:            part.smallest,
+			Largest:             part.largest,
+			SmallestSeqNum:      f.SmallestSeqNum,
+			LargestSeqNum:       f.LargestSeqNum,
+			MarkedForCompaction: f.MarkedForCompaction,
+			HasRangeKeys:        f.HasRangeKeys,
+		}
+		if estimated {
+			m.Size = sizes[i]
+		}
+		metas = append(metas, m)
+	}
+	return metas
+}
+
+// exciseTargetLevel returns the lowest level at which the table meta, whose
+// bounds lie within an excised span, can be ingested by the version edit ve.
+// Once ve is applied, the only tables overlapping the span are those written
+// after the ingestion began, so rather than checking for data overlap as
+// ingestTargetLevel does, the bounds of the table are checked against the
+// bounds of the tables remaining in each level, including those added by ve.
+func exciseTargetLevel(
+	cmp Compare,
+	v *version,
+	ve *versionEdit,
+	baseLevel int,
+	compactions map[*compaction]struct{},
+	meta *fileMetadata,
+) int {
+	overlaps := func(level int) bool {
+		for _, f := range v.Levels[level] {
+			if ve.DeletedFiles[deletedFileEntry{Level: level, FileNum: f.FileNum}] {
+				continue
+			}
+			if sstableKeyCompare(cmp, meta.Smallest, f.Largest) <= 0 &&
+				sstableKeyCompare(cmp, f.Smallest, meta.Largest) <= 0 {
+				return true
+			}
+		}
+		for _, e := range ve.NewFiles {
+			if e.Level != level || e.Meta == nil || e.Meta == meta {
+				continue
+			}
+			if sstableKeyCompare(cmp, meta.Smallest, e.Meta.Largest) <= 0 &&
+				sstableKeyCompare(cmp, e.Meta.Smallest, meta.Largest) <= 0 {
+				return true
+			}
+		}
+		return false
+	}
+	if overlaps(0) {
+		return 0
+	}
+
+	targetLevel := 0
+	for level := baseLevel; level < numLevels; level++ {
+		if overlaps(level) {
+			break
+		}
+		// Skip the level if the table would overlap the output of a compaction
+		// into it.
+		overlapsCompaction := false
+		for c := range compactions {
+			if c.outputLevel == nil || level != c.outputLevel.level {
+				continue
+			}
+			if cmp(meta.Smallest.UserKey, c.largest.UserKey) <= 0 &&
+				cmp(meta.Largest.UserKey, c.smallest.UserKey) >= 0 {
+				overlapsCompaction = true
+				break
+			}
+		}
+		if !overlapsCompaction {
+			targetLevel = level
+		}
+	}
+	return targetLevel
+}
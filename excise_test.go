@@ -0,0 +1,152 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestAndExcise(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true, FormatMajorVersion: FormatNewest}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+
+	contents := func(r Reader) string {
+		iter := r.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(parts, " ")
+	}
+	writeSST := func(path string, keys ...string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte("new")))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	// Populate L6 with a table spanning the excised span, L0 with a table
+	// containing a range deletion extending beyond it, and the memtable with a
+	// key within it.
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		require.NoError(t, d.Set([]byte(k), []byte("old"), nil))
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("i"), false))
+	require.NoError(t, d.Set([]byte("c"), []byte("l0"), nil))
+	require.NoError(t, d.DeleteRange([]byte("f"), []byte("h"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("d"), []byte("mem"), nil))
+	snap := d.NewSnapshot()
+
+	// The ingested tables must lie within the span, and the span must not be
+	// empty.
+	writeSST("ext0", "b")
+	require.Error(t, d.IngestAndExcise([]string{"ext0"}, []byte("c"), []byte("g")))
+	writeSST("ext1", "g")
+	require.Error(t, d.IngestAndExcise([]string{"ext1"}, []byte("c"), []byte("g")))
+	require.Error(t, d.IngestAndExcise(nil, []byte("g"), []byte("c")))
+
+	// The excision would remove the excised keys from the open snapshot.
+	writeSST("ext", "d", "e")
+	require.Regexp(t, "snapshots are open", d.IngestAndExcise([]string{"ext"}, []byte("c"), []byte("g")))
+	require.Equal(t, "a=old b=old c=l0 d=mem e=old h=old", contents(d))
+	require.NoError(t, snap.Close())
+
+	writeSST("ext", "d", "e")
+	require.NoError(t, d.IngestAndExcise([]string{"ext"}, []byte("c"), []byte("g")))
+	require.Equal(t, "a=old b=old d=new e=new h=old", contents(d))
+
+	// The only table overlapping the span is the ingested table, which no
+	// longer overlaps any table and is ingested into L6.
+	d.mu.Lock()
+	current := d.mu.versions.currentVersion()
+	var overlapping []string
+	for level := range current.Levels {
+		for _, f := range current.Levels[level] {
+			if exciseOverlaps(d.cmp, f, []byte("c"), []byte("g")) {
+				overlapping = append(overlapping, fmt.Sprintf("L%d:%s-%s", level, f.Smallest.UserKey, f.Largest.UserKey))
+			}
+		}
+	}
+	d.mu.Unlock()
+	require.Equal(t, []string{"L6:d-e"}, overlapping)
+
+	// The tables extending beyond the span are replaced by virtual tables
+	// backed by the original tables.
+	virtualTables := func() []string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		current := d.mu.versions.currentVersion()
+		var virtual []string
+		for level := range current.Levels {
+			for _, f := range current.Levels[level] {
+				if f.Virtual() {
+					virtual = append(virtual, fmt.Sprintf("L%d:%s-%s", level, f.Smallest.UserKey, f.Largest.UserKey))
+				}
+			}
+		}
+		return virtual
+	}
+	require.Equal(t, []string{"L0:g-h", "L6:a-c", "L6:g-h"}, virtualTables())
+
+	// The sizes of the virtual tables are estimated before the excision is
+	// applied, and never exceed the size of their backing table.
+	d.mu.Lock()
+	current = d.mu.versions.currentVersion()
+	for level := range current.Levels {
+		for _, f := range current.Levels[level] {
+			if !f.Virtual() {
+				continue
+			}
+			info, err := mem.Stat(base.MakeFilename(mem, "db", fileTypeTable, f.BackingFileNum))
+			require.NoError(t, err)
+			require.True(t, f.Size <= uint64(info.Size()), "%s: %d", f, f.Size)
+		}
+	}
+	d.mu.Unlock()
+
+	// Keys written after the excision are unaffected by it, and an excision
+	// without any tables only removes keys.
+	require.NoError(t, d.Set([]byte("c"), []byte("after"), nil))
+	require.NoError(t, d.IngestAndExcise(nil, []byte("a"), []byte("b")))
+	require.Equal(t, "b=old c=after d=new e=new h=old", contents(d))
+
+	require.NoError(t, d.Close())
+	d, err = Open("db", opts)
+	require.NoError(t, err)
+	require.Equal(t, "b=old c=after d=new e=new h=old", contents(d))
+	require.NotEmpty(t, virtualTables())
+
+	// Once the virtual tables are compacted, their backing tables are deleted.
+	tables := func() []string {
+		ls, err := mem.List("db")
+		require.NoError(t, err)
+		var tables []string
+		for _, name := range ls {
+			if strings.HasSuffix(name, ".sst") {
+				tables = append(tables, name)
+			}
+		}
+		return tables
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	require.Empty(t, virtualTables())
+	require.Equal(t, "b=old c=after d=new e=new h=old", contents(d))
+	require.Len(t, tables(), 1)
+	require.NoError(t, d.Close())
+}
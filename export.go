@@ -0,0 +1,91 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ExportOptions hold the optional parameters of DB.Export and
+// Snapshot.Export.
+type ExportOptions struct {
+	// AllVersions exports every version of each key. By default, when the
+	// Comparer defines a Split function, only the first key with each prefix
+	// is exported, which for an MVCC scheme ordering the versions of a key
+	// from newest to oldest is its latest version. AllVersions has no effect
+	// if the Comparer does not define Split.
+	AllVersions bool
+}
+
+// Export writes the keys of the DB in the range [start, end) to a new sstable
+// at path, created using the DB's FS, returning the number of keys written.
+// A nil start or end leaves the range unbounded on that side. The keys are
+// read from a consistent point-in-time view of the DB: merge operands are
+// merged and deleted keys are omitted, so the sstable contains a single SET
+// for each exported key, and may be passed to DB.Ingest of a DB using the
+// same Comparer. Range keys are not exported.
+func (d *DB) Export(path string, start, end []byte, opts ExportOptions) (int, error) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	return exportInternal(d, d.opts, path, start, end, opts)
+}
+
+// Export writes the keys of the snapshot in the range [start, end) to a new
+// sstable at path, returning the number of keys written. See DB.Export.
+func (s *Snapshot) Export(path string, start, end []byte, opts ExportOptions) (int, error) {
+	if s.db == nil {
+		panic(ErrClosed)
+	}
+	return exportInternal(s, s.db.opts, path, start, end, opts)
+}
+
+func exportInternal(
+	r Reader, opts *Options, path string, start, end []byte, o ExportOptions,
+) (n int, err error) {
+	f, err := opts.FS.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w := sstable.NewWriter(f, opts.MakeWriterOptions(0))
+	defer func() {
+		if w != nil {
+			_ = w.Close()
+		}
+		if err != nil {
+			_ = opts.FS.Remove(path)
+		}
+	}()
+
+	iter := r.NewIter(&IterOptions{LowerBound: start, UpperBound: end})
+	split := opts.Comparer.Split
+	var prefix []byte
+	for valid := iter.First(); valid; valid = iter.Next() {
+		key := iter.Key()
+		if split != nil && !o.AllVersions {
+			p := key[:split(key)]
+			if n > 0 && opts.Comparer.Equal(p, prefix) {
+				continue
+			}
+			prefix = append(prefix[:0], p...)
+		}
+		if err := w.Set(key, iter.Value()); err != nil {
+			_ = iter.Close()
+			return 0, err
+		}
+		n++
+	}
+	if err := iter.Close(); err != nil {
+		return 0, err
+	}
+	err = w.Close()
+	w = nil
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	// Keys are of the form <key>@<version>, and the versions of a key are
+	// ordered from newest to oldest.
+	comparer := &Comparer{
+		Compare:        DefaultComparer.Compare,
+		Equal:          DefaultComparer.Equal,
+		AbbreviatedKey: DefaultComparer.AbbreviatedKey,
+		Separator:      DefaultComparer.Separator,
+		Successor:      DefaultComparer.Successor,
+		Split: func(a []byte) int {
+			if i := bytes.IndexByte(a, '@'); i >= 0 {
+				return i
+			}
+			return len(a)
+		},
+		Name: "pebble.test.export",
+	}
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, Comparer: comparer, Merger: DefaultMerger}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	contents := func(d *DB) string {
+		iter := d.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(parts, " ")
+	}
+
+	for _, kv := range []string{"a@1=a1", "a@2=a2", "b@1=b1", "c@1=c1", "c@2=c2", "d@1=d1"} {
+		i := strings.IndexByte(kv, '=')
+		require.NoError(t, d.Set([]byte(kv[:i]), []byte(kv[i+1:]), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Merge([]byte("b@1"), []byte("x"), nil))
+	require.NoError(t, d.Delete([]byte("c@1"), nil))
+	snap := d.NewSnapshot()
+	defer func() {
+		require.NoError(t, snap.Close())
+	}()
+	require.NoError(t, d.Set([]byte("b@0"), []byte("b0"), nil))
+
+	testCases := []struct {
+		export   func(path string) (int, error)
+		expected string
+	}{
+		{
+			export: func(path string) (int, error) {
+				return d.Export(path, []byte("a"), []byte("d"), ExportOptions{})
+			},
+			expected: "a@1=a1 b@0=b0 c@2=c2",
+		},
+		{
+			export: func(path string) (int, error) {
+				return snap.Export(path, []byte("a"), []byte("d"), ExportOptions{})
+			},
+			expected: "a@1=a1 b@1=b1x c@2=c2",
+		},
+		{
+			export: func(path string) (int, error) {
+				return snap.Export(path, nil, nil, ExportOptions{AllVersions: true})
+			},
+			expected: "a@1=a1 a@2=a2 b@1=b1x c@2=c2 d@1=d1",
+		},
+		{
+			export: func(path string) (int, error) {
+				return d.Export(path, []byte("e"), nil, ExportOptions{})
+			},
+			expected: "",
+		},
+	}
+	for i, c := range testCases {
+		t.Run("", func(t *testing.T) {
+			path := fmt.Sprintf("export%d.sst", i)
+			n, err := c.export(path)
+			require.NoError(t, err)
+			if c.expected == "" {
+				require.Equal(t, 0, n)
+			} else {
+				require.Equal(t, len(strings.Fields(c.expected)), n)
+			}
+
+			// The exported sstable can be ingested.
+			dir := fmt.Sprintf("ingest%d", i)
+			d2, err := Open(dir, opts)
+			require.NoError(t, err)
+			require.NoError(t, d2.Ingest([]string{path}))
+			require.Equal(t, c.expected, contents(d2))
+			require.NoError(t, d2.Close())
+		})
+	}
+}
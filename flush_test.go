@@ -88,3 +88,35 @@ func TestManualFlush(t *testing.T) {
 		}
 	})
 }
+
+func TestFlushSplitHints(t *testing.T) {
+	var hintBounds []string
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.FlushSplitHints = func(smallest, largest []byte) [][]byte {
+		hintBounds = append(hintBounds, fmt.Sprintf("%s-%s", smallest, largest))
+		return [][]byte{[]byte("f"), []byte("c"), []byte("f"), []byte("z")}
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// The range tombstone spans the "c" split point and is truncated there, so
+	// the first sstable's largest key is the tombstone's exclusive end at "d".
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("e"), nil))
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.Equal(t, []string{"a-h"}, hintBounds)
+
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[0]
+	var bounds []string
+	for _, f := range files {
+		bounds = append(bounds, fmt.Sprintf("%s-%s", f.Smallest.UserKey, f.Largest.UserKey))
+	}
+	d.mu.Unlock()
+	require.Equal(t, []string{"a-d", "d-f", "g-h"}, bounds)
+}
@@ -7,6 +7,8 @@ package pebble
 import (
 	"fmt"
 	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
 )
 
 // flushable defines the interface for immutable memtables.
@@ -14,6 +16,9 @@ type flushable interface {
 	newIter(o *IterOptions) internalIterator
 	newFlushIter(o *IterOptions, bytesFlushed *uint64) internalIterator
 	newRangeDelIter(o *IterOptions) internalIterator
+	// newRangeKeyIter returns an iterator over the range keys in the
+	// flushable, or nil if there are none. The range keys are not fragmented.
+	newRangeKeyIter(o *IterOptions) internalIterator
 	// inuseBytes returns the number of inuse bytes by the flushable.
 	inuseBytes() uint64
 	// totalBytes returns the total number of bytes allocated by the flushable.
@@ -76,3 +81,116 @@ func (e *flushableEntry) readerUnref() {
 }
 
 type flushableList []*flushableEntry
+
+// ingestedFlushable is a flushable containing sstables which were ingested
+// while overlapping with a memtable. Rather than waiting for the overlapping
+// memtable to flush, the ingested sstables are layered above it in the queue
+// of flushables. Flushing an ingestedFlushable adds its sstables to L0 without
+// rewriting them. See Options.Experimental.IngestAsFlushable.
+type ingestedFlushable struct {
+	// files are sorted by smallest key and do not overlap.
+	files    []*fileMetadata
+	cmp      Compare
+	logger   Logger
+	newIters tableNewIters
+	// newTableRangeKeyIter returns an iterator over the range keys in a file.
+	newTableRangeKeyIter tableNewRangeKeyIter
+}
+
+var _ flushable = (*ingestedFlushable)(nil)
+
+func newIngestedFlushable(
+	files []*fileMetadata,
+	cmp Compare,
+	logger Logger,
+	newIters tableNewIters,
+	newTableRangeKeyIter tableNewRangeKeyIter,
+) *ingestedFlushable {
+	return &ingestedFlushable{
+		files:                files,
+		cmp:                  cmp,
+		logger:               logger,
+		newIters:             newIters,
+		newTableRangeKeyIter: newTableRangeKeyIter,
+	}
+}
+
+func (s *ingestedFlushable) newIter(o *IterOptions) internalIterator {
+	var opts IterOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.logger == nil {
+		opts.logger = s.logger
+	}
+	return newLevelIter(opts, s.cmp, nil, s.newIters, s.files, manifest.Level(0), nil)
+}
+
+func (s *ingestedFlushable) newFlushIter(o *IterOptions, bytesFlushed *uint64) internalIterator {
+	// The sstables of an ingestedFlushable are added to L0 directly rather than
+	// being rewritten by a flush. See DB.flushIngested.
+	panic("pebble: not implemented")
+}
+
+func (s *ingestedFlushable) newRangeDelIter(o *IterOptions) internalIterator {
+	// The ingested sstables do not overlap, so merging their fragmented range
+	// tombstones yields fragmented range tombstones.
+	var iters []internalIterator
+	for _, f := range s.files {
+		iter, rangeDelIter, err := s.newIters(f, nil, nil)
+		if err != nil {
+			for i := range iters {
+				_ = iters[i].Close()
+			}
+			return newErrorIter(err)
+		}
+		_ = iter.Close()
+		if rangeDelIter != nil {
+			iters = append(iters, rangeDelIter)
+		}
+	}
+	if len(iters) == 0 {
+		return nil
+	}
+	return newMergingIter(s.logger, s.cmp, iters...)
+}
+
+func (s *ingestedFlushable) newRangeKeyIter(o *IterOptions) internalIterator {
+	var iters []internalIterator
+	for _, f := range s.files {
+		if !f.HasRangeKeys {
+			continue
+		}
+		iter, err := s.newTableRangeKeyIter(f)
+		if err != nil {
+			for i := range iters {
+				_ = iters[i].Close()
+			}
+			return newErrorIter(err)
+		}
+		if iter != nil {
+			iters = append(iters, iter)
+		}
+	}
+	if len(iters) == 0 {
+		return nil
+	}
+	return newMergingIter(s.logger, s.cmp, iters...)
+}
+
+// inuseBytes implements the flushable interface. The ingested sstables do not
+// consume memory.
+func (s *ingestedFlushable) inuseBytes() uint64 {
+	return 0
+}
+
+// totalBytes implements the flushable interface. The ingested sstables do not
+// consume memory.
+func (s *ingestedFlushable) totalBytes() uint64 {
+	return 0
+}
+
+func (s *ingestedFlushable) readyForFlush() bool {
+	// The ingested sstables are immutable and can be added to L0 at any time.
+	return true
+}
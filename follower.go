@@ -0,0 +1,269 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// followerCatchUpAttempts is the number of times catching up with the
+// primary is attempted when the primary removes a MANIFEST or WAL before the
+// follower has read it.
+const followerCatchUpAttempts = 3
+
+// follower holds the state of a DB opened with OpenFollower.
+type follower struct {
+	// mu serializes catching up with the primary.
+	mu sync.Mutex
+	// doneCh is closed when the goroutine which periodically catches up with
+	// the primary exits. Nil if Options.Experimental.FollowerCatchUpInterval
+	// is zero.
+	doneCh chan struct{}
+}
+
+// OpenFollower opens a read-only follower of the DB in dirname, which is
+// concurrently written by another DB, the primary, which may reside in
+// another process. The directory may also be a copy of the primary's
+// directory which is continuously replicated by some other means.
+//
+// The follower is opened without locking the directory, and initially
+// observes the DB as of its last MANIFEST and WAL records. It advances its
+// view by re-reading the MANIFEST and the tail of the WALs, either when
+// DB.CatchUp is called or periodically if
+// Options.Experimental.FollowerCatchUpInterval is set. Reads from a follower
+// are thus eventually consistent with the primary.
+//
+// The primary deletes sstables and WALs without regard to the follower. A
+// read which finds that an sstable has been deleted returns an error, and may
+// be retried after catching up. Iterators and snapshots which are held open
+// across a catch up continue to observe the older view, and are more likely
+// to encounter such errors.
+func OpenFollower(dirname string, opts *Options) (*DB, error) {
+	opts = opts.Clone()
+	opts.ReadOnly = true
+	opts.private.follower = true
+	d, err := Open(dirname, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.follower = &follower{}
+	if interval := d.opts.Experimental.FollowerCatchUpInterval; interval > 0 {
+		d.follower.doneCh = make(chan struct{})
+		go d.followerLoop(interval)
+	}
+	return d, nil
+}
+
+// followerLoop catches up with the primary at the specified interval until
+// the DB is closed.
+func (d *DB) followerLoop(interval time.Duration) {
+	defer close(d.follower.doneCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+			if err := d.catchUp(); err != nil {
+				d.opts.EventListener.BackgroundError(err)
+			}
+		}
+	}
+}
+
+// CatchUp advances the view of a follower (see OpenFollower) to the latest
+// state of its primary, re-reading the primary's MANIFEST and the tail of its
+// WALs. Iterators and snapshots created after CatchUp returns observe the new
+// state. It is an error to call CatchUp on a DB which is not a follower.
+func (d *DB) CatchUp() error {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.follower == nil {
+		return errors.New("pebble: not a follower")
+	}
+	return d.catchUp()
+}
+
+func (d *DB) catchUp() error {
+	d.follower.mu.Lock()
+	defer d.follower.mu.Unlock()
+
+	var err error
+	for i := 0; i < followerCatchUpAttempts; i++ {
+		err = d.catchUpOnce()
+		// The primary may have removed a MANIFEST or WAL named by the state
+		// read before it, in which case the newer state is read again.
+		if err == nil || !os.IsNotExist(errors.UnwrapAll(err)) {
+			break
+		}
+	}
+	return err
+}
+
+func (d *DB) catchUpOnce() error {
+	m, err := readManifest(d.opts.FS, d.dirname, d.opts.Comparer.Name)
+	if err != nil {
+		return err
+	}
+	target, _, err := m.bve.Apply(nil, d.cmp, d.opts.Comparer.FormatKey,
+		d.opts.Experimental.FlushSplitBytes)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if atomic.LoadInt32(&d.closed) != 0 {
+		return nil
+	}
+	vs := &d.mu.versions
+
+	minUnflushedLogNum := m.minUnflushedLogNum
+	if minUnflushedLogNum < vs.minUnflushedLogNum {
+		minUnflushedLogNum = vs.minUnflushedLogNum
+	}
+	ls, err := d.opts.FS.List(d.walDirname)
+	if err != nil {
+		return err
+	}
+	var logNums []FileNum
+	for _, filename := range ls {
+		ft, fn, ok := base.ParseFilename(d.opts.FS, filename)
+		if ok && ft == fileTypeLog && fn >= minUnflushedLogNum {
+			logNums = append(logNums, fn)
+		}
+	}
+	sort.Slice(logNums, func(i, j int) bool {
+		return logNums[i] < logNums[j]
+	})
+
+	// Replay the WALs into a new queue of memtables. The existing memtables
+	// are restored if a WAL cannot be replayed.
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	oldQueue, oldMutable := d.mu.mem.queue, d.mu.mem.mutable
+	d.mu.mem.queue, d.mu.mem.mutable = nil, nil
+	logSeqNum := m.logSeqNum
+	var ve versionEdit
+	for _, logNum := range logNums {
+		path := base.MakeFilename(d.opts.FS, d.walDirname, fileTypeLog, logNum)
+		maxSeqNum, err := d.replayWAL(jobID, &ve, d.opts.FS, path, logNum)
+		if err != nil {
+			for _, mem := range d.mu.mem.queue {
+				mem.readerUnref()
+			}
+			d.mu.mem.queue, d.mu.mem.mutable = oldQueue, oldMutable
+			return err
+		}
+		if logSeqNum < maxSeqNum {
+			logSeqNum = maxSeqNum
+		}
+	}
+
+	// Install a version edit which transforms the current version into the
+	// one read from the MANIFEST. Tables present in both are shared, so that
+	// the tables which are no longer present become obsolete.
+	d.installFollowerVersionLocked(target)
+	vs.minUnflushedLogNum = minUnflushedLogNum
+	vs.markFileNumUsed(m.nextFileNum)
+	if logSeqNum > atomic.LoadUint64(&vs.logSeqNum) {
+		atomic.StoreUint64(&vs.logSeqNum, logSeqNum)
+		atomic.StoreUint64(&vs.visibleSeqNum, logSeqNum)
+	}
+	vs.metrics.WAL.Files = int64(len(logNums))
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	for _, mem := range oldQueue {
+		mem.readerUnref()
+	}
+	d.deleteObsoleteFiles(jobID)
+	return nil
+}
+
+// installFollowerVersionLocked installs a new current version with the same
+// tables as target. d.mu must be held.
+func (d *DB) installFollowerVersionLocked(target *version) {
+	vs := &d.mu.versions
+	current := vs.currentVersion()
+
+	type levelFile struct {
+		level   int
+		fileNum FileNum
+	}
+	currentFiles := make(map[FileNum]*fileMetadata)
+	currentLevelFiles := make(map[levelFile]bool)
+	for level, files := range current.Levels {
+		for _, f := range files {
+			currentFiles[f.FileNum] = f
+			currentLevelFiles[levelFile{level, f.FileNum}] = true
+		}
+	}
+
+	ve := &versionEdit{DeletedFiles: make(map[deletedFileEntry]bool)}
+	targetLevelFiles := make(map[levelFile]bool)
+	for level, files := range target.Levels {
+		for _, f := range files {
+			targetLevelFiles[levelFile{level, f.FileNum}] = true
+			if currentLevelFiles[levelFile{level, f.FileNum}] {
+				continue
+			}
+			// A table moved between levels retains its metadata.
+			meta := f
+			if cf, ok := currentFiles[f.FileNum]; ok {
+				meta = cf
+			}
+			ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: level, Meta: meta})
+		}
+	}
+	for lf := range currentLevelFiles {
+		if !targetLevelFiles[lf] {
+			ve.DeletedFiles[deletedFileEntry{Level: lf.level, FileNum: lf.fileNum}] = true
+		}
+	}
+	if len(ve.NewFiles) == 0 && len(ve.DeletedFiles) == 0 {
+		return
+	}
+
+	var bve bulkVersionEdit
+	bve.Accumulate(ve)
+	newVersion, zombies, err := bve.Apply(current, d.cmp, d.opts.Comparer.FormatKey,
+		d.opts.Experimental.FlushSplitBytes)
+	if err != nil {
+		// The edit is derived from two valid versions.
+		d.opts.Logger.Fatalf("pebble: follower version edit failed: %v", err)
+	}
+	newVersion.L0Sublevels.InitCompactingFileInfo()
+
+	// Update the zombie tables before installing the new version, which may
+	// make the tables of the previous version obsolete. The obsolete tables of
+	// a follower are evicted from the table cache but never deleted.
+	for fileNum, size := range zombies {
+		vs.zombieTables[fileNum] = size
+	}
+	for _, nf := range ve.NewFiles {
+		if nf.Meta.External != "" {
+			vs.externalTables[nf.Meta.FileNum] = struct{}{}
+		}
+		if nf.Meta.Virtual() {
+			vs.addVirtualTable(nf.Meta, zombies)
+		}
+	}
+	vs.append(newVersion)
+	vs.picker = newCompactionPicker(newVersion, vs.opts, nil)
+	for i := range vs.metrics.Levels {
+		l := &vs.metrics.Levels[i]
+		l.NumFiles = int64(len(newVersion.Levels[i]))
+		l.Size = uint64(totalSize(newVersion.Levels[i]))
+	}
+}
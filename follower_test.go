@@ -0,0 +1,95 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pebble-follower")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The primary and its follower use the directory as if they were in
+	// separate processes, sharing only the files within it.
+	primary, err := Open(dir, &Options{FS: vfs.Default})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, primary.Close())
+	}()
+
+	contents := func(r Reader) string {
+		iter := r.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(parts, " ")
+	}
+
+	require.NoError(t, primary.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.Set([]byte("b"), []byte("2"), nil))
+
+	// The follower observes both the flushed and the unflushed keys.
+	follower, err := OpenFollower(dir, &Options{FS: vfs.Default})
+	require.NoError(t, err)
+	require.Equal(t, "a=1 b=2", contents(follower))
+	require.Equal(t, ErrReadOnly, follower.Set([]byte("c"), []byte("3"), nil))
+	require.Error(t, primary.CatchUp())
+
+	// The follower only observes new writes once it has caught up, while a
+	// snapshot continues to observe the older view.
+	require.NoError(t, primary.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, primary.Delete([]byte("a"), nil))
+	require.Equal(t, "a=1 b=2", contents(follower))
+	snap := follower.NewSnapshot()
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "b=2 c=3", contents(follower))
+	require.Equal(t, "a=1 b=2", contents(snap))
+	require.NoError(t, snap.Close())
+
+	// Catching up after the primary flushes and compacts replaces the tables
+	// of the follower, which are evicted rather than deleted.
+	require.NoError(t, primary.Set([]byte("d"), []byte("4"), nil))
+	require.NoError(t, primary.Compact([]byte("a"), []byte("e"), false))
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "b=2 c=3 d=4", contents(follower))
+	primaryTables, err := primary.SSTables()
+	require.NoError(t, err)
+	followerTables, err := follower.SSTables()
+	require.NoError(t, err)
+	require.Equal(t, primaryTables, followerTables)
+	follower.mu.Lock()
+	for follower.mu.cleaner.cleaning || len(follower.mu.versions.obsoleteTables) > 0 {
+		follower.mu.cleaner.cond.Wait()
+	}
+	require.Equal(t, 0, len(follower.mu.versions.zombieTables))
+	follower.mu.Unlock()
+	require.NoError(t, follower.Close())
+
+	// A follower may catch up in the background.
+	opts := &Options{FS: vfs.Default}
+	opts.Experimental.FollowerCatchUpInterval = time.Millisecond
+	follower, err = OpenFollower(dir, opts)
+	require.NoError(t, err)
+	require.NoError(t, primary.Set([]byte("e"), []byte("5"), nil))
+	deadline := time.Now().Add(10 * time.Second)
+	for contents(follower) != "b=2 c=3 d=4 e=5" {
+		require.True(t, time.Now().Before(deadline), "follower did not catch up")
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, follower.Close())
+}
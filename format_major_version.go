@@ -0,0 +1,139 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+)
+
+// FormatMajorVersion is a version of the on-disk format of a DB, which is
+// persisted in the MANIFEST. Unlike TableFormat, which describes the format of
+// individual sstables, the format major version describes the DB as a whole:
+// features which a previous version of Pebble could not read, such as new key
+// kinds, MANIFEST fields or WAL record formats, are gated on the format major
+// version of the DB. The format major version of a DB is only ever ratcheted
+// upwards, either when the DB is opened with a newer Options.FormatMajorVersion
+// or explicitly with DB.RatchetFormatMajorVersion, and a version of Pebble
+// refuses to open a DB with a format major version newer than FormatNewest.
+type FormatMajorVersion uint64
+
+const (
+	// FormatDefault leaves the format major version unspecified. A new DB is
+	// created with FormatMostCompatible, and the format major version of an
+	// existing DB is left unchanged.
+	FormatDefault FormatMajorVersion = iota
+	// FormatMostCompatible is the original format of a DB, which does not
+	// record a format major version and may be opened by any version of
+	// Pebble.
+	FormatMostCompatible
+	// FormatVersioned is the first format major version recorded in the
+	// MANIFEST. Versions of Pebble predating format major versions are unable
+	// to open a DB in this format.
+	FormatVersioned
+	// FormatFlushableIngest is the format major version which records the
+	// ingestion of sstables queued as a flushable in the WAL (see
+	// Options.Experimental.IngestAsFlushable). At older versions, an
+	// ingestion overlapping the memtables waits for them to be flushed.
+	FormatFlushableIngest
+	// FormatRangeKeys is the format major version which adds range keys, in
+	// batches, the WAL and sstables (see DB.RangeKeySet).
+	FormatRangeKeys
+	// FormatKeyspaces is the format major version which adds keyspaces, whose
+	// batches are recorded in the WAL of the DB (see DB.CreateKeyspace).
+	FormatKeyspaces
+	// FormatVirtualSSTables is the format major version which adds virtual
+	// sstables to the MANIFEST, as created by excising a span of the LSM (see
+	// DB.IngestAndExcise).
+	FormatVirtualSSTables
+	// FormatExternalSSTables is the format major version which adds sstables
+	// residing on Options.Experimental.SharedFS to the MANIFEST (see
+	// DB.IngestExternal).
+	FormatExternalSSTables
+
+	// FormatNewest is the newest format major version supported by this
+	// version of Pebble.
+	FormatNewest = FormatExternalSSTables
+)
+
+// String implements fmt.Stringer.
+func (v FormatMajorVersion) String() string {
+	switch v {
+	case FormatDefault:
+		return "default"
+	case FormatMostCompatible:
+		return "most-compatible"
+	case FormatVersioned:
+		return "versioned"
+	case FormatFlushableIngest:
+		return "flushable-ingest"
+	case FormatRangeKeys:
+		return "range-keys"
+	case FormatKeyspaces:
+		return "keyspaces"
+	case FormatVirtualSSTables:
+		return "virtual-sstables"
+	case FormatExternalSSTables:
+		return "external-sstables"
+	default:
+		return fmt.Sprintf("FormatMajorVersion(%d)", uint64(v))
+	}
+}
+
+// FormatMajorVersion returns the format major version of the DB.
+func (d *DB) FormatMajorVersion() FormatMajorVersion {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mu.versions.formatMajorVersion
+}
+
+// requireFormatMajorVersion returns an error if the format major version of
+// the DB is older than v, which is required by the named feature.
+func (d *DB) requireFormatMajorVersion(v FormatMajorVersion, feature string) error {
+	if cur := d.FormatMajorVersion(); cur < v {
+		return errors.Errorf("pebble: %s require format major version %s, but the DB is at %s",
+			errors.Safe(feature), v, cur)
+	}
+	return nil
+}
+
+// RatchetFormatMajorVersion ratchets the format major version of the DB to v,
+// enabling the features gated on v. The new format major version is persisted
+// before RatchetFormatMajorVersion returns. Once ratcheted, the DB can no
+// longer be opened by versions of Pebble which do not support v. Ratcheting to
+// a version older than the current format major version of the DB is a no-op.
+func (d *DB) RatchetFormatMajorVersion(v FormatMajorVersion) error {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if v > FormatNewest {
+		return errors.Errorf("pebble: format major version %d is newer than the newest supported version %d",
+			errors.Safe(uint64(v)), errors.Safe(uint64(FormatNewest)))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mu.versions.formatMajorVersion >= v {
+		return nil
+	}
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	d.mu.versions.logLock()
+	// NB: The MANIFEST lock may have been acquired by waiting on a concurrent
+	// ratchet to the same version.
+	if d.mu.versions.formatMajorVersion >= v {
+		d.mu.versions.logUnlock()
+		return nil
+	}
+	ve := &versionEdit{FormatMajorVersion: uint64(v)}
+	return d.mu.versions.logAndApply(jobID, ve, nil, d.dataDir, func() []compactionInfo {
+		return d.getInProgressCompactionInfoLocked(nil)
+	})
+}
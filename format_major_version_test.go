@@ -0,0 +1,147 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatchetFormatMajorVersion(t *testing.T) {
+	mem := vfs.NewMem()
+	open := func(v FormatMajorVersion) *DB {
+		d, err := Open("", &Options{
+			FS:                 mem,
+			FormatMajorVersion: v,
+			// Create a new MANIFEST on every version edit, verifying that the
+			// format major version is carried over to new MANIFESTs.
+			MaxManifestFileSize: 1,
+		})
+		require.NoError(t, err)
+		return d
+	}
+
+	// A new DB is created with the most compatible format, which is not
+	// recorded in the MANIFEST.
+	d := open(FormatDefault)
+	require.Equal(t, FormatMostCompatible, d.FormatMajorVersion())
+	m, err := readManifest(mem, "", DefaultComparer.Name)
+	require.NoError(t, err)
+	require.Equal(t, FormatDefault, m.formatMajorVersion)
+
+	require.Error(t, d.RatchetFormatMajorVersion(FormatNewest+1))
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatVersioned))
+	require.Equal(t, FormatVersioned, d.FormatMajorVersion())
+	// Ratcheting is a no-op if the DB is already at the requested version.
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatMostCompatible))
+	require.Equal(t, FormatVersioned, d.FormatMajorVersion())
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// The format major version persists, and is never downgraded.
+	d = open(FormatMostCompatible)
+	require.Equal(t, FormatVersioned, d.FormatMajorVersion())
+	require.NoError(t, d.Close())
+	m, err = readManifest(mem, "", DefaultComparer.Name)
+	require.NoError(t, err)
+	require.Equal(t, FormatVersioned, m.formatMajorVersion)
+
+	// Opening a DB with a newer Options.FormatMajorVersion ratchets it.
+	mem = vfs.NewMem()
+	d = open(FormatMostCompatible)
+	require.NoError(t, d.Close())
+	d = open(FormatVersioned)
+	require.Equal(t, FormatVersioned, d.FormatMajorVersion())
+	require.NoError(t, d.Close())
+	d = open(FormatDefault)
+	require.Equal(t, FormatVersioned, d.FormatMajorVersion())
+	require.NoError(t, d.Close())
+
+	_, err = Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest + 1})
+	require.Error(t, err)
+}
+
+func TestFormatMajorVersionTooNew(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+
+	// Simulate a DB ratcheted by a newer version of Pebble.
+	d.mu.Lock()
+	d.mu.versions.logLock()
+	ve := &versionEdit{FormatMajorVersion: uint64(FormatNewest + 1)}
+	require.NoError(t, d.mu.versions.logAndApply(0, ve, nil, d.dataDir, func() []compactionInfo {
+		return nil
+	}))
+	d.mu.Unlock()
+	require.NoError(t, d.Close())
+
+	_, err = Open("", &Options{FS: mem})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "newer than the newest supported version")
+	_, err = Open("", &Options{FS: mem, ReadOnly: true})
+	require.Error(t, err)
+}
+
+func TestFormatMajorVersionGates(t *testing.T) {
+	mem := vfs.NewMem()
+	shared := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatVersioned}
+	opts.Experimental.SharedFS = shared
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	writeTable := func(fs vfs.FS, path string, rangeKey bool) {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		if rangeKey {
+			require.NoError(t, w.RangeKeySet([]byte("a"), []byte("b"), nil, []byte("1")))
+		} else {
+			require.NoError(t, w.Set([]byte("a"), []byte("1")))
+		}
+		require.NoError(t, w.Close())
+	}
+	requireVersionErr := func(err error, v FormatMajorVersion) {
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "require format major version "+v.String())
+	}
+
+	// Each feature is rejected until the DB is ratcheted to the format major
+	// version introducing it.
+	requireVersionErr(d.RangeKeySet([]byte("a"), []byte("b"), nil, []byte("1"), nil), FormatRangeKeys)
+	b := d.NewBatch()
+	require.NoError(t, b.RangeKeyDelete([]byte("a"), []byte("b"), nil))
+	requireVersionErr(b.Commit(nil), FormatRangeKeys)
+	writeTable(mem, "rangekey.sst", true)
+	requireVersionErr(d.Ingest([]string{"rangekey.sst"}), FormatRangeKeys)
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatRangeKeys))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.RangeKeySet([]byte("a"), []byte("b"), nil, []byte("1"), nil))
+	require.NoError(t, d.Ingest([]string{"rangekey.sst"}))
+
+	_, err = d.CreateKeyspace("ks", nil)
+	requireVersionErr(err, FormatKeyspaces)
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatKeyspaces))
+	ks, err := d.CreateKeyspace("ks", nil)
+	require.NoError(t, err)
+	require.Equal(t, FormatKeyspaces, ks.FormatMajorVersion())
+
+	requireVersionErr(d.IngestAndExcise(nil, []byte("a"), []byte("b")), FormatVirtualSSTables)
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatVirtualSSTables))
+	require.NoError(t, d.IngestAndExcise(nil, []byte("a"), []byte("b")))
+
+	writeTable(shared, "ext.sst", false)
+	requireVersionErr(d.IngestExternal([]string{"ext.sst"}), FormatExternalSSTables)
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatExternalSSTables))
+	require.NoError(t, d.IngestExternal([]string{"ext.sst"}))
+}
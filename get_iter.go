@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/cockroachdb/pebble/internal/base"
@@ -18,8 +19,10 @@ import (
 // lazily.
 type getIter struct {
 	logger       Logger
+	ctx          context.Context
 	cmp          Compare
 	equal        Equal
+	split        Split
 	newIters     tableNewIters
 	snapshot     uint64
 	key          []byte
@@ -35,6 +38,10 @@ type getIter struct {
 	iterKey      *InternalKey
 	iterValue    []byte
 	err          error
+	// prefix is the prefix of key (see Comparer.Split), or key itself if
+	// there is no Split function. The sstables are searched for the prefix so
+	// that their filters are consulted before any data block is read.
+	prefix []byte
 }
 
 // getIter implements the base.InternalIterator interface.
@@ -96,7 +103,9 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 					return nil, nil
 				}
 				if g.equal(g.key, key.UserKey) {
-					if !key.Visible(g.snapshot) {
+					// The level iterators may return synthetic range deletion boundary
+					// keys, which are skipped along with the invisible keys.
+					if !key.Visible(g.snapshot) || key.Kind() == InternalKeyKindRangeDelete {
 						g.iterKey, g.iterValue = g.iter.Next()
 						continue
 					}
@@ -130,7 +139,18 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 		// Create iterators from memtables from newest to oldest.
 		if n := len(g.mem); n > 0 {
 			m := g.mem[n-1]
-			g.iter = m.newIter(nil)
+			if mem, ok := m.flushable.(*memTable); ok {
+				if mem.mayContain(g.key) {
+					// Only the memtable shard containing the key needs to be searched.
+					g.iter = mem.newPointIter(g.key)
+				} else {
+					// The memtable definitely does not contain the key, though its range
+					// tombstones may still delete the key in older memtables and levels.
+					g.iter = emptyIter
+				}
+			} else {
+				g.iter = m.newIter(nil)
+			}
 			g.rangeDelIter = m.newRangeDelIter(nil)
 			g.mem = g.mem[:n-1]
 			g.iterKey, g.iterValue = g.iter.SeekGE(g.key)
@@ -142,11 +162,11 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 			if n := len(g.l0); n > 0 {
 				files := g.l0[n-1]
 				g.l0 = g.l0[:n-1]
-				iterOpts := IterOptions{logger: g.logger}
-				g.levelIter.init(iterOpts, g.cmp, g.newIters, files, manifest.L0Sublevel(n), nil)
+				iterOpts := IterOptions{logger: g.logger, ctx: g.ctx}
+				g.levelIter.init(iterOpts, g.cmp, g.split, g.newIters, files, manifest.L0Sublevel(n), nil)
 				g.levelIter.initRangeDel(&g.rangeDelIter)
 				g.iter = &g.levelIter
-				g.iterKey, g.iterValue = g.iter.SeekGE(g.key)
+				g.iterKey, g.iterValue = g.levelIter.SeekPrefixGE(g.prefix, g.key)
 				continue
 			}
 			g.level++
@@ -160,13 +180,13 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 			continue
 		}
 
-		iterOpts := IterOptions{logger: g.logger}
-		g.levelIter.init(iterOpts, g.cmp, g.newIters,
+		iterOpts := IterOptions{logger: g.logger, ctx: g.ctx}
+		g.levelIter.init(iterOpts, g.cmp, g.split, g.newIters,
 			g.version.Levels[g.level], manifest.Level(g.level), nil)
 		g.levelIter.initRangeDel(&g.rangeDelIter)
 		g.level++
 		g.iter = &g.levelIter
-		g.iterKey, g.iterValue = g.iter.SeekGE(g.key)
+		g.iterKey, g.iterValue = g.levelIter.SeekPrefixGE(g.prefix, g.key)
 	}
 }
 
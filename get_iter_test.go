@@ -5,11 +5,16 @@
 package pebble
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetIter(t *testing.T) {
@@ -542,6 +547,7 @@ func TestGetIter(t *testing.T) {
 			get.equal = equal
 			get.newIters = newIter
 			get.key = ikey.UserKey
+			get.prefix = ikey.UserKey
 			get.l0 = v.L0Sublevels.Levels
 			get.version = v
 			get.snapshot = ikey.SeqNum() + 1
@@ -583,3 +589,52 @@ func TestGetIter(t *testing.T) {
 		}
 	}
 }
+
+func TestGetUsesFilters(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:     vfs.NewMem(),
+		Levels: []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	// Each flush writes an L0 table overlapping the others.
+	for l := 0; l < 3; l++ {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d-%d", i, l)), []byte("value"), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	get := func(key string) error {
+		v, closer, err := d.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		require.Equal(t, "value", string(v))
+		return closer.Close()
+	}
+	require.NoError(t, get("050-1"))
+	require.Equal(t, ErrNotFound, get("050-3"))
+
+	// The lookups consult the filters of the tables rather than reading their
+	// data blocks, except for the table containing the key.
+	m := d.Metrics()
+	require.Equal(t, int64(4), m.Filter.Hits)
+	require.Equal(t, int64(1), m.Filter.Misses)
+
+	if invariants.RaceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	key := []byte("050-1")
+	require.Equal(t, float64(0), testing.AllocsPerRun(100, func() {
+		_, closer, err := d.Get(key)
+		if err == nil {
+			err = closer.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+}
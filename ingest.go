@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -45,14 +47,14 @@ func ingestValidateKey(opts *Options, key *InternalKey) error {
 }
 
 func ingestLoad1(
-	opts *Options, path string, cacheID uint64, fileNum FileNum,
+	opts *Options, fs vfs.FS, path string, cacheID uint64, fileNum FileNum,
 ) (*fileMetadata, error) {
-	stat, err := opts.FS.Stat(path)
+	stat, err := fs.Stat(path)
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := opts.FS.Open(path)
+	f, err := fs.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +66,14 @@ func ingestLoad1(
 	}
 	defer r.Close()
 
+	// The sstable may have been opened using a comparer registered with
+	// sstable.RegisterComparer, but its keys can only be ingested if they are
+	// ordered by the DB's comparer.
+	if name := r.Properties.ComparerName; name != "" && name != opts.Comparer.Name {
+		return nil, errors.Errorf("pebble: ingested sstable %s uses comparer %q, but the DB uses comparer %q",
+			path, errors.Safe(name), errors.Safe(opts.Comparer.Name))
+	}
+
 	meta := &fileMetadata{}
 	meta.FileNum = fileNum
 	meta.Size = uint64(stat.Size())
@@ -81,8 +91,8 @@ func ingestLoad1(
 	// meta.Stats here, the file will be loaded into the table cache for
 	// calculating stats before we can remove the original link.
 	if r.Properties.NumRangeDeletions == 0 {
+		meta.Stats = propertiesTableStats(&r.Properties)
 		meta.Stats.Valid = true
-		meta.Stats.RangeDeletionsBytesEstimate = 0
 	}
 
 	smallestSet, largestSet := false, false
@@ -132,6 +142,7 @@ func ingestLoad1(
 			if !smallestSet ||
 				base.InternalCompare(opts.Comparer.Compare, meta.Smallest, *key) > 0 {
 				meta.Smallest = key.Clone()
+				smallestSet = true
 			}
 		}
 		if err := iter.Error(); err != nil {
@@ -146,7 +157,41 @@ func ingestLoad1(
 			if !largestSet ||
 				base.InternalCompare(opts.Comparer.Compare, meta.Largest, end) < 0 {
 				meta.Largest = end.Clone()
+				largestSet = true
+			}
+		}
+	}
+
+	rangeKeyIter, err := r.NewRangeKeyIter()
+	if err != nil {
+		return nil, err
+	}
+	if rangeKeyIter != nil {
+		defer rangeKeyIter.Close()
+		// The range keys are sorted by start key, but the largest end key may
+		// belong to any of them.
+		for key, val := rangeKeyIter.First(); key != nil; key, val = rangeKeyIter.Next() {
+			if err := ingestValidateKey(opts, key); err != nil {
+				return nil, err
+			}
+			k, err := rangekey.Decode(*key, val)
+			if err != nil {
+				return nil, err
+			}
+			if !smallestSet || base.InternalCompare(opts.Comparer.Compare, meta.Smallest, *key) > 0 {
+				meta.Smallest = key.Clone()
+				smallestSet = true
+			}
+			end := base.MakeRangeDeleteSentinelKey(k.End)
+			if !largestSet || base.InternalCompare(opts.Comparer.Compare, meta.Largest, end) < 0 {
+				meta.Largest = end.Clone()
+				largestSet = true
 			}
+			empty = false
+			meta.HasRangeKeys = true
+		}
+		if err := rangeKeyIter.Error(); err != nil {
+			return nil, err
 		}
 	}
 
@@ -157,12 +202,12 @@ func ingestLoad1(
 }
 
 func ingestLoad(
-	opts *Options, paths []string, cacheID uint64, pending []FileNum,
+	opts *Options, fs vfs.FS, paths []string, cacheID uint64, pending []FileNum,
 ) ([]*fileMetadata, []string, error) {
 	meta := make([]*fileMetadata, 0, len(paths))
 	newPaths := make([]string, 0, len(paths))
 	for i := range paths {
-		m, err := ingestLoad1(opts, paths[i], cacheID, pending[i])
+		m, err := ingestLoad1(opts, fs, paths[i], cacheID, pending[i])
 		if err != nil {
 			return nil, nil, err
 		}
@@ -295,6 +340,24 @@ func ingestMemtableOverlaps(cmp Compare, mem flushable, meta []*fileMetadata) bo
 			return true
 		}
 	}
+
+	// Range keys in the memtable must be flushed before an overlapping table is
+	// ingested, just like point keys and range deletions.
+	if rangeKeyIter := mem.newRangeKeyIter(nil); rangeKeyIter != nil {
+		defer rangeKeyIter.Close()
+		for key, val := rangeKeyIter.First(); key != nil; key, val = rangeKeyIter.Next() {
+			k, err := rangekey.Decode(*key, val)
+			if err != nil {
+				// Be conservative and treat a corrupt range key as overlapping.
+				return true
+			}
+			for _, m := range meta {
+				if cmp(k.Start.UserKey, m.Largest.UserKey) <= 0 && cmp(k.End, m.Smallest.UserKey) > 0 {
+					return true
+				}
+			}
+		}
+	}
 	return false
 }
 
@@ -405,6 +468,12 @@ func ingestTargetLevel(
 			continue
 		}
 
+		// Range keys are not checked for data overlap. Conservatively treat
+		// boundary overlap as data overlap if either table contains range keys.
+		if meta.HasRangeKeys || meta0.HasRangeKeys {
+			return targetLevel, nil
+		}
+
 		iter, rangeDelIter, err := newIters(meta0, nil, nil)
 		if err != nil {
 			return 0, err
@@ -421,7 +490,13 @@ func ingestTargetLevel(
 
 	level := baseLevel
 	for ; level < numLevels; level++ {
-		levelIter := newLevelIter(iterOps, cmp, newIters, v.Levels[level], manifest.Level(level), nil)
+		for _, f := range v.Overlaps(level, cmp, meta.Smallest.UserKey, meta.Largest.UserKey) {
+			if meta.HasRangeKeys || f.HasRangeKeys {
+				return targetLevel, nil
+			}
+		}
+
+		levelIter := newLevelIter(iterOps, cmp, nil, newIters, v.Levels[level], manifest.Level(level), nil)
 		var rangeDelIter internalIterator
 		// Pass in a non-nil pointer to rangeDelIter so that levelIter.findFileGE sets it up for the target file.
 		levelIter.initRangeDel(&rangeDelIter)
@@ -492,9 +567,11 @@ func ingestTargetLevel(
 // memtable is forced equivalent to DB.Flush. Additionally, subsequent
 // mutations that get sequence numbers larger than the ingestion sequence
 // number get queued up behind the ingestion waiting for it to complete. This
-// can produce a noticeable hiccup in performance. See
-// https://github.com/cockroachdb/pebble/issues/25 for an idea for how to fix
-// this hiccup.
+// can produce a noticeable hiccup in performance. If
+// Options.Experimental.IngestAsFlushable is enabled, the hiccup is avoided by
+// recording the ingestion in the WAL and queueing the sstables as a flushable
+// above the overlapping memtables (see DB.ingestAsFlushable). The sstables
+// are added to L0 once the memtables they overlap have been flushed.
 func (d *DB) Ingest(paths []string) error {
 	if atomic.LoadInt32(&d.closed) != 0 {
 		panic(ErrClosed)
@@ -502,6 +579,109 @@ func (d *DB) Ingest(paths []string) error {
 	if d.opts.ReadOnly {
 		return ErrReadOnly
 	}
+	return d.ingest(paths, nil, false)
+}
+
+// IngestExternal ingests a set of sstables residing on
+// Options.Experimental.SharedFS by reference. Rather than being linked or
+// copied into the DB directory, each sstable is recorded in the MANIFEST by
+// its path within SharedFS along with its key bounds, and is read directly
+// from SharedFS. Ingestion is otherwise the same as Ingest, except that the
+// input paths are not removed: the sstables must not be modified or removed
+// for as long as the DB, or any checkpoint of it, references them. A DB
+// referencing external sstables must be opened with the same SharedFS, and
+// external sstables require the format major version of the DB to be at least
+// FormatExternalSSTables.
+func (d *DB) IngestExternal(paths []string) error {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.opts.Experimental.SharedFS == nil {
+		return errors.New("pebble: cannot ingest external sstables without SharedFS")
+	}
+	return d.ingest(paths, nil, true)
+}
+
+// IngestAndExcise atomically replaces the keys in the span [start, end) with
+// the contents of a set of sstables, all of whose keys must lie within the
+// span. Semantically, it is equivalent to deleting the span with a range
+// deletion and range key deletion and then ingesting the sstables (see
+// Ingest), though the existing keys within the span are removed immediately
+// rather than being shadowed by a tombstone until compactions reclaim them:
+// the sstables overlapping the span are removed from the LSM, and those
+// extending beyond it are replaced by virtual sstables, which share the data
+// of the original sstable but only expose its keys outside of the span. No
+// data is rewritten, and the original sstable is deleted once none of its
+// virtual sstables remain. Compactions of the sstables overlapping the span
+// are cancelled. Paths may be empty, in which case the span is only excised.
+// Excising requires the format major version of the DB to be at least
+// FormatVirtualSSTables.
+//
+// Unlike a range deletion, the excision cannot be bounded by open snapshots,
+// so IngestAndExcise returns an error if any snapshot is open, and snapshots
+// are not created while the excision is applied. An eventually file-only
+// snapshot which has become file-only continues to observe the excised keys
+// (see NewEventuallyFileOnlySnapshot). IngestAndExcise also returns an error
+// if an sstable overlapping the span contains keys written both before and
+// after the ingestion began, which may be the case if a memtable is flushed
+// concurrently.
+func (d *DB) IngestAndExcise(paths []string, start, end []byte) error {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("pebble: excise start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	return d.ingest(paths, &exciseSpan{start: start, end: end}, false)
+}
+
+// ingestCheckFormatMajorVersion returns an error if the format major version
+// of the DB does not support the features required by the ingestion.
+func (d *DB) ingestCheckFormatMajorVersion(
+	meta []*fileMetadata, excise *exciseSpan, external bool,
+) error {
+	if external {
+		if err := d.requireFormatMajorVersion(FormatExternalSSTables, "external sstables"); err != nil {
+			return err
+		}
+	}
+	if excise != nil {
+		if err := d.requireFormatMajorVersion(FormatVirtualSSTables, "excises"); err != nil {
+			return err
+		}
+	}
+	for _, m := range meta {
+		if m.HasRangeKeys {
+			return d.requireFormatMajorVersion(FormatRangeKeys, "range keys")
+		}
+	}
+	return nil
+}
+
+// exciseSpan is the span of user keys [start, end) excised by
+// DB.IngestAndExcise.
+type exciseSpan struct {
+	start, end []byte
+	// sizes holds the estimated sizes of the virtual tables replacing the
+	// tables overlapping the span, keyed by the file number of the excised
+	// table. See exciseEstimateSizes.
+	sizes map[FileNum][2]uint64
+}
+
+// ingest ingests the sstables at the specified paths, excising the span
+// first if excise is non-nil. If external is true, the sstables reside on
+// Options.Experimental.SharedFS and are ingested by reference.
+func (d *DB) ingest(paths []string, excise *exciseSpan, external bool) error {
+	if err := d.backgroundError(); err != nil {
+		return err
+	}
 
 	// Allocate file numbers for all of the files being ingested and mark them as
 	// pending in order to prevent them from being deleted. Note that this causes
@@ -519,38 +699,74 @@ func (d *DB) Ingest(paths []string) error {
 
 	// Load the metadata for all of the files being ingested. This step detects
 	// and elides empty sstables.
-	meta, paths, err := ingestLoad(d.opts, paths, d.cacheID, pendingOutputs)
+	fs := d.opts.FS
+	if external {
+		fs = d.opts.Experimental.SharedFS
+	}
+	meta, paths, err := ingestLoad(d.opts, fs, paths, d.cacheID, pendingOutputs)
 	if err != nil {
 		return err
 	}
-	if len(meta) == 0 {
+	if external {
+		for i := range meta {
+			meta[i].External = paths[i]
+		}
+	}
+	if len(meta) == 0 && excise == nil {
 		// All of the sstables to be ingested were empty. Nothing to do.
 		return nil
 	}
+	if err := d.ingestCheckFormatMajorVersion(meta, excise, external); err != nil {
+		return err
+	}
 
 	// Verify the sstables do not overlap.
 	if err := ingestSortAndVerify(d.cmp, meta, paths); err != nil {
 		return err
 	}
 
+	// Verify the sstables lie within the excised span, and check for overlap
+	// with the memtables using the span rather than the sstables.
+	overlapMeta := meta
+	if excise != nil {
+		for _, m := range meta {
+			if !exciseContains(d.cmp, m, excise.start, excise.end) {
+				return errors.Errorf("pebble: ingested table %s is not within excise span [%s, %s)",
+					m, d.opts.Comparer.FormatKey(excise.start), d.opts.Comparer.FormatKey(excise.end))
+			}
+		}
+		overlapMeta = []*fileMetadata{{
+			Smallest: base.MakeInternalKey(excise.start, InternalKeySeqNumMax, InternalKeyKindMax),
+			Largest:  base.MakeRangeDeleteSentinelKey(excise.end),
+		}}
+		// Estimating the sizes of the virtual tables may read the indexes of
+		// the excised tables, which must be done before d.mu is acquired.
+		d.exciseEstimateSizes(excise)
+	}
+
 	// Hard link the sstables into the DB directory. Since the sstables aren't
 	// referenced by a version, they won't be used. If the hard linking fails
 	// (e.g. because the files reside on a different filesystem), ingestLink will
 	// fall back to copying, and if that fails we undo our work and return an
-	// error.
-	if err := ingestLink(jobID, d.opts, d.dirname, paths, meta); err != nil {
-		return err
-	}
-	// Fsync the directory we added the tables to. We need to do this at some
-	// point before we update the MANIFEST (via logAndApply), otherwise a crash
-	// can have the tables referenced in the MANIFEST, but not present in the
-	// directory.
-	if err := d.dataDir.Sync(); err != nil {
-		return err
+	// error. External sstables are left in place on SharedFS.
+	if !external {
+		if err := ingestLink(jobID, d.opts, d.dirname, paths, meta); err != nil {
+			return err
+		}
+		// Fsync the directory we added the tables to. We need to do this at
+		// some point before we update the MANIFEST (via logAndApply),
+		// otherwise a crash can have the tables referenced in the MANIFEST,
+		// but not present in the directory.
+		if err := d.dataDir.Sync(); err != nil {
+			return err
+		}
 	}
 
 	var mem *flushableEntry
-	prepare := func() {
+	var asFlushable bool
+	var syncWG sync.WaitGroup
+	var syncErr error
+	prepare := func(seqNum uint64) {
 		// Note that d.commit.mu is held by commitPipeline when calling prepare.
 
 		d.mu.Lock()
@@ -562,7 +778,19 @@ func (d *DB) Ingest(paths []string) error {
 		// overlaps.
 		for i := len(d.mu.mem.queue) - 1; i >= 0; i-- {
 			m := d.mu.mem.queue[i]
-			if ingestMemtableOverlaps(d.cmp, m, meta) {
+			if ingestMemtableOverlaps(d.cmp, m, overlapMeta) {
+				if d.opts.Experimental.IngestAsFlushable && !d.opts.DisableWAL && d.keyspace == nil &&
+					excise == nil && !external &&
+					d.mu.versions.formatMajorVersion >= FormatFlushableIngest {
+					// Rather than waiting for the overlapping memtable to flush, queue
+					// the sstables as a flushable above it.
+					if err = ingestUpdateSeqNum(d.opts, d.dirname, seqNum, meta); err != nil {
+						return
+					}
+					asFlushable = true
+					d.ingestAsFlushable(meta, seqNum, &syncWG, &syncErr)
+					return
+				}
 				mem = m
 				if mem.flushable == d.mu.mem.mutable {
 					err = d.makeRoomForWrite(nil)
@@ -580,6 +808,13 @@ func (d *DB) Ingest(paths []string) error {
 			// An error occurred during prepare.
 			return
 		}
+		if asFlushable {
+			// The sstables were queued as a flushable during prepare. Wait for the
+			// WAL record of the ingestion to be synced.
+			syncWG.Wait()
+			err = syncErr
+			return
+		}
 
 		// Update the sequence number for all of the sstables, both in the metadata
 		// and the global sequence number property on disk.
@@ -595,16 +830,28 @@ func (d *DB) Ingest(paths []string) error {
 
 		// Assign the sstables to the correct level in the LSM and apply the
 		// version edit.
-		ve, err = d.ingestApply(jobID, meta)
+		ve, err = d.ingestApply(jobID, meta, excise, seqNum)
 	}
 
-	d.commit.AllocateSeqNum(len(meta), prepare, apply)
+	// NB: an excision without any sstables still requires a sequence number,
+	// which distinguishes the keys written before and after it.
+	count := len(meta)
+	if count == 0 {
+		count = 1
+	}
+	d.commit.AllocateSeqNum(count, prepare, apply)
 
-	if err != nil {
+	switch {
+	case external:
+		// External sstables are neither linked into the DB directory nor
+		// removed from SharedFS.
+	case err != nil && !asFlushable:
+		// NB: the sstables of a flushable ingestion are referenced by the queue
+		// of flushables, and must not be removed even if syncing the WAL failed.
 		if err2 := ingestCleanup(d.opts.FS, d.dirname, meta); err2 != nil {
 			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
 		}
-	} else {
+	default:
 		for _, path := range paths {
 			if err2 := d.opts.FS.Remove(path); err2 != nil {
 				d.opts.Logger.Infof("ingest failed to remove original file: %s", err2)
@@ -613,16 +860,29 @@ func (d *DB) Ingest(paths []string) error {
 	}
 
 	info := TableIngestInfo{
-		JobID:        jobID,
-		GlobalSeqNum: meta[0].SmallestSeqNum,
-		Err:          err,
+		JobID: jobID,
+		Err:   err,
 	}
-	if ve != nil {
+	if len(meta) > 0 {
+		info.GlobalSeqNum = meta[0].SmallestSeqNum
+	}
+	if asFlushable && err == nil {
+		// The sstables will be added to L0 when the flushable containing them
+		// is flushed.
+		info.Tables = make([]struct {
+			TableInfo
+			Level int
+		}, len(meta))
+		for i := range meta {
+			info.Tables[i].TableInfo = meta[i].TableInfo()
+		}
+	} else if ve != nil {
+		// NB: the tables rewritten by an excision follow the ingested tables.
 		info.Tables = make([]struct {
 			TableInfo
 			Level int
-		}, len(ve.NewFiles))
-		for i := range ve.NewFiles {
+		}, len(meta))
+		for i := range meta {
 			e := &ve.NewFiles[i]
 			info.Tables[i].Level = e.Level
 			info.Tables[i].TableInfo = e.Meta.TableInfo()
@@ -633,7 +893,57 @@ func (d *DB) Ingest(paths []string) error {
 	return err
 }
 
-func (d *DB) ingestApply(jobID int, meta []*fileMetadata) (*versionEdit, error) {
+// ingestAsFlushable records the ingestion of the specified sstables in the
+// WAL, rotates the mutable memtable and queues the sstables as a flushable
+// between the rotated memtable and the new mutable memtable. The sequence
+// numbers of the sstables must already have been assigned. The WAL record is
+// synced asynchronously, and syncWG is signaled once it is durable.
+//
+// d.commit.mu and d.mu must be held when calling this, though the latter may
+// be dropped and re-acquired while rotating the memtable.
+func (d *DB) ingestAsFlushable(
+	meta []*fileMetadata, seqNum uint64, syncWG *sync.WaitGroup, syncErr *error,
+) {
+	var b Batch
+	for _, m := range meta {
+		b.ingestSST(m.FileNum)
+	}
+	b.setSeqNum(seqNum)
+
+	// Similar to a large batch, the WAL record is written to the log of the
+	// memtable being rotated, and the flushable takes ownership of that log.
+	syncWG.Add(1)
+	size, err := d.mu.log.SyncRecord(b.Repr(), syncWG, syncErr)
+	if err != nil {
+		panic(err)
+	}
+	d.mu.log.bytesIn += uint64(len(b.Repr()))
+	atomic.StoreUint64(&d.mu.log.size, uint64(size))
+
+	// Rotating the memtable with a nil batch forces a flush of the rotated
+	// memtable, after which the ingested sstables are flushed to L0.
+	if err := d.makeRoomForWrite(nil); err != nil {
+		panic(err)
+	}
+
+	n := len(d.mu.mem.queue)
+	imm := d.mu.mem.queue[n-2]
+	entry := d.newFlushableEntry(
+		newIngestedFlushable(meta, d.cmp, d.opts.Logger, d.newIters,
+			d.tableCache.newRangeKeyIter), imm.logNum, seqNum)
+	entry.flushForced = true
+	// The ingested sstables do not consume memory.
+	entry.releaseMemAccounting = func() {}
+	imm.logNum = 0
+	mutable := d.mu.mem.queue[n-1]
+	d.mu.mem.queue = append(d.mu.mem.queue[:n-1], entry, mutable)
+	d.updateReadStateLocked(nil)
+	d.maybeScheduleFlush()
+}
+
+func (d *DB) ingestApply(
+	jobID int, meta []*fileMetadata, excise *exciseSpan, seqNum uint64,
+) (*versionEdit, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -647,8 +957,29 @@ func (d *DB) ingestApply(jobID int, meta []*fileMetadata) (*versionEdit, error)
 	// from using the same version to determine the target level, and also
 	// provides serialization with concurrent compaction and flush jobs.
 	// logAndApply unconditionally releases the manifest lock, but any earlier
-	// returns must unlock the manifest.
-	d.mu.versions.logLock()
+	// returns must unlock the manifest. An excision locks the manifest once the
+	// tables overlapping the span have been excised.
+	var excised []*fileMetadata
+	if excise != nil {
+		// The excised keys are removed from the LSM rather than shadowed, so an
+		// open snapshot would no longer observe them. Snapshots are not created
+		// while the excision is applied.
+		if !d.mu.snapshots.empty() {
+			return nil, errors.New("pebble: cannot excise while snapshots are open")
+		}
+		d.mu.excising++
+		defer func() {
+			d.mu.excising--
+			d.mu.compact.cond.Broadcast()
+		}()
+		var err error
+		excised, err = d.exciseLocked(excise, seqNum, ve)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		d.mu.versions.logLock()
+	}
 	current := d.mu.versions.currentVersion()
 	baseLevel := d.mu.versions.picker.getBaseLevel()
 	iterOps := IterOptions{logger: d.opts.Logger}
@@ -658,7 +989,11 @@ func (d *DB) ingestApply(jobID int, meta []*fileMetadata) (*versionEdit, error)
 		m := meta[i]
 		f := &ve.NewFiles[i]
 		var err error
-		f.Level, err = ingestTargetLevel(d.newIters, iterOps, d.cmp, current, baseLevel, d.mu.compact.inProgress, m)
+		if excise != nil {
+			f.Level = exciseTargetLevel(d.cmp, current, ve, baseLevel, d.mu.compact.inProgress, m)
+		} else {
+			f.Level, err = ingestTargetLevel(d.newIters, iterOps, d.cmp, current, baseLevel, d.mu.compact.inProgress, m)
+		}
 		if err != nil {
 			d.mu.versions.logUnlock()
 			return nil, err
@@ -675,8 +1010,15 @@ func (d *DB) ingestApply(jobID int, meta []*fileMetadata) (*versionEdit, error)
 	if err := d.mu.versions.logAndApply(jobID, ve, metrics, d.dataDir, func() []compactionInfo {
 		return d.getInProgressCompactionInfoLocked(nil)
 	}); err != nil {
+		if excise != nil {
+			d.exciseCleanupLocked(excised)
+		}
 		return nil, err
 	}
+	if excise != nil {
+		// Wake up any excision waiting for the excised tables.
+		d.mu.compact.cond.Broadcast()
+	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
 	d.updateTableStatsLocked(ve.NewFiles)
 	d.deleteObsoleteFiles(jobID)
@@ -56,7 +56,7 @@ func TestIngestLoad(t *testing.T) {
 				Comparer: DefaultComparer,
 				FS:       mem,
 			}
-			meta, _, err := ingestLoad(opts, []string{"ext"}, 0, []FileNum{1})
+			meta, _, err := ingestLoad(opts, mem, []string{"ext"}, 0, []FileNum{1})
 			if err != nil {
 				return err.Error()
 			}
@@ -130,6 +130,7 @@ func TestIngestLoadRand(t *testing.T) {
 			require.NoError(t, err)
 
 			expected[i].Size = meta.Size
+			expected[i].Stats.NumEntries = meta.Properties.NumEntries
 		}()
 	}
 
@@ -137,7 +138,7 @@ func TestIngestLoadRand(t *testing.T) {
 		Comparer: DefaultComparer,
 		FS:       mem,
 	}
-	meta, _, err := ingestLoad(opts, paths, 0, pending)
+	meta, _, err := ingestLoad(opts, mem, paths, 0, pending)
 	require.NoError(t, err)
 
 	for _, m := range meta {
@@ -158,11 +159,37 @@ func TestIngestLoadInvalid(t *testing.T) {
 		Comparer: DefaultComparer,
 		FS:       mem,
 	}
-	if _, _, err := ingestLoad(opts, []string{"invalid"}, 0, []FileNum{1}); err == nil {
+	if _, _, err := ingestLoad(opts, mem, []string{"invalid"}, 0, []FileNum{1}); err == nil {
 		t.Fatalf("expected error, but found success")
 	}
 }
 
+func TestIngestComparerMismatch(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// An sstable written with a different comparer can be opened once its
+	// comparer is registered, but cannot be ingested.
+	cmp := NewCollatingComparer("pebble.test.ingest-comparer", func(dst, key []byte) []byte {
+		return append(dst, bytes.ToLower(key)...)
+	})
+	sstable.RegisterComparer(cmp)
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, sstable.WriterOptions{Comparer: cmp})
+	require.NoError(t, w.Set([]byte("a"), []byte("1")))
+	require.NoError(t, w.Close())
+
+	err = d.Ingest([]string{"ext"})
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(),
+		`uses comparer "pebble.test.ingest-comparer", but the DB uses comparer "leveldb.BytewiseComparator"`), err.Error())
+}
+
 func TestIngestSortAndVerify(t *testing.T) {
 	comparers := map[string]Compare{
 		"default": DefaultComparer.Compare,
@@ -500,13 +527,17 @@ func TestIngest(t *testing.T) {
 		mem = vfs.NewMem()
 		require.NoError(t, mem.MkdirAll("ext", 0755))
 
-		var err error
-		d, err = Open("", &Options{
+		opts := &Options{
 			FS:                    mem,
 			L0CompactionThreshold: 100,
 			L0StopWritesThreshold: 100,
 			DebugCheck:            DebugCheckLevels,
-		})
+		}
+		// Disable automatic compactions, including delete-only compactions of
+		// tables covered by the ingested range tombstones.
+		opts.DisableAutomaticCompactions = true
+		var err error
+		d, err = Open("", opts)
 		require.NoError(t, err)
 	}
 	reset()
@@ -745,7 +776,7 @@ func TestConcurrentIngestCompact(t *testing.T) {
 
 			compact := func(start, end string) {
 				t.Helper()
-				require.NoError(t, d.Compact([]byte(start), []byte(end)))
+				require.NoError(t, d.Compact([]byte(start), []byte(end), false))
 			}
 
 			lsm := func() string {
@@ -908,6 +939,115 @@ func TestIngestFlushQueuedLargeBatch(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestIngestAsFlushable(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest}
+	opts.Experimental.IngestAsFlushable = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("1"), nil))
+
+	// Prevent flushes so that the ingested sstable remains queued.
+	d.mu.Lock()
+	d.mu.compact.flushing = true
+	d.mu.Unlock()
+
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	require.NoError(t, w.Set([]byte("a"), []byte("2")))
+	require.NoError(t, w.Set([]byte("b"), []byte("2")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	// The ingested sstable is queued above the overlapping memtable rather
+	// than being added to the LSM.
+	var ingestedFileNum FileNum
+	d.mu.Lock()
+	for _, entry := range d.mu.mem.queue {
+		if f, ok := entry.flushable.(*ingestedFlushable); ok {
+			require.Equal(t, 1, len(f.files))
+			ingestedFileNum = f.files[0].FileNum
+		}
+	}
+	require.Equal(t, 0, len(d.mu.versions.currentVersion().Levels[0]))
+	d.mu.Unlock()
+	require.NotEqual(t, FileNum(0), ingestedFileNum)
+
+	// Writes sequenced after the ingestion shadow it.
+	require.NoError(t, d.Set([]byte("b"), []byte("3"), nil))
+
+	verify := func(d *DB) {
+		t.Helper()
+		for k, v := range map[string]string{"a": "2", "b": "3", "c": "1"} {
+			verifyGet(t, d, []byte(k), []byte(v))
+		}
+		iter := d.NewIter(nil)
+		var buf bytes.Buffer
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, "a:2 b:3 c:1 ", buf.String())
+	}
+	verify(d)
+
+	// Close without flushing. The ingested sstable is recovered from the WAL
+	// when the DB is reopened, first in read-only mode where it remains
+	// queued, and then in read-write mode where it is added to L0.
+	d.mu.Lock()
+	d.mu.compact.flushing = false
+	d.mu.Unlock()
+	require.NoError(t, d.Close())
+
+	d, err = Open("", &Options{FS: mem, ReadOnly: true})
+	require.NoError(t, err)
+	verify(d)
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	verify(d)
+	d.mu.Lock()
+	var found bool
+	for _, f := range d.mu.versions.currentVersion().Levels[0] {
+		found = found || f.FileNum == ingestedFileNum
+	}
+	d.mu.Unlock()
+	require.True(t, found)
+
+	// Flushing the queued ingestion adds the ingested sstable to L0 without
+	// rewriting it.
+	require.NoError(t, d.Set([]byte("d"), []byte("1"), nil))
+	d.mu.Lock()
+	d.mu.compact.flushing = true
+	d.mu.Unlock()
+	f, err = mem.Create("ext")
+	require.NoError(t, err)
+	w = sstable.NewWriter(f, sstable.WriterOptions{})
+	require.NoError(t, w.Set([]byte("d"), []byte("2")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	d.mu.Lock()
+	ingestedFileNum = d.mu.mem.queue[1].flushable.(*ingestedFlushable).files[0].FileNum
+	d.mu.compact.flushing = false
+	d.mu.Unlock()
+	require.NoError(t, d.Flush())
+	verifyGet(t, d, []byte("d"), []byte("2"))
+
+	d.mu.Lock()
+	found = false
+	for _, f := range d.mu.versions.currentVersion().Levels[0] {
+		found = found || f.FileNum == ingestedFileNum
+	}
+	d.mu.Unlock()
+	require.True(t, found)
+	require.NoError(t, d.CheckLevels(nil))
+	require.NoError(t, d.Close())
+}
+
 func TestIngestMemtablePendingOverlap(t *testing.T) {
 	mem := vfs.NewMem()
 	d, err := Open("", &Options{
@@ -988,3 +1128,91 @@ func TestIngestMemtablePendingOverlap(t *testing.T) {
 	require.NoError(t, d.CheckLevels(nil))
 	require.NoError(t, d.Close())
 }
+
+func TestIngestExternal(t *testing.T) {
+	mem := vfs.NewMem()
+	shared := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest}
+	opts.Experimental.SharedFS = shared
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+
+	contents := func(d *DB) string {
+		iter := d.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(parts, " ")
+	}
+	localTables := func(dir string) int {
+		ls, err := mem.List(dir)
+		require.NoError(t, err)
+		var n int
+		for _, name := range ls {
+			if strings.HasSuffix(name, ".sst") {
+				n++
+			}
+		}
+		return n
+	}
+
+	f, err := shared.Create("ext.sst")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	require.NoError(t, w.Set([]byte("a"), []byte("1")))
+	require.NoError(t, w.Set([]byte("b"), []byte("2")))
+	require.NoError(t, w.Close())
+
+	// The external sstable is referenced rather than linked or copied, and is
+	// not removed.
+	require.NoError(t, d.IngestExternal([]string{"ext.sst"}))
+	require.Equal(t, "a=1 b=2", contents(d))
+	require.Equal(t, 0, localTables("db"))
+	_, err = shared.Stat("ext.sst")
+	require.NoError(t, err)
+
+	// The reference survives reopening the DB, and is retained by a
+	// checkpoint.
+	require.NoError(t, d.Close())
+	d, err = Open("db", opts)
+	require.NoError(t, err)
+	require.Equal(t, "a=1 b=2", contents(d))
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	require.Equal(t, 0, localTables("checkpoint"))
+	d2, err := Open("checkpoint", opts)
+	require.NoError(t, err)
+	require.Equal(t, "a=1 b=2", contents(d2))
+	require.NoError(t, d2.Close())
+
+	// Backups and archives, which do not retain the reference, are rejected.
+	_, err = d.Backup(mem, "backup")
+	require.Error(t, err)
+	require.Regexp(t, "backups of DBs with external sstables are not supported", err.Error())
+	err = d.WriteArchive(ioutil.Discard)
+	require.Error(t, err)
+	require.Regexp(t, "archives of DBs with external sstables are not supported", err.Error())
+	for _, dir := range []string{backupCheckpointDirname, archiveCheckpointDirname} {
+		_, err = mem.Stat(mem.PathJoin("db", dir))
+		require.True(t, os.IsNotExist(err), "%v", err)
+	}
+
+	// Once compacted away, the external sstable is no longer referenced, but
+	// is not deleted.
+	require.NoError(t, d.Set([]byte("b"), []byte("3"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+	require.Equal(t, "a=1 b=3", contents(d))
+	d.mu.Lock()
+	require.Equal(t, 0, len(d.mu.versions.externalTables))
+	d.mu.Unlock()
+	_, err = shared.Stat("ext.sst")
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	// Ingesting by reference requires SharedFS.
+	d, err = Open("db2", &Options{FS: mem})
+	require.NoError(t, err)
+	require.Error(t, d.IngestExternal([]string{"ext.sst"}))
+	require.NoError(t, d.Close())
+}
@@ -76,11 +76,16 @@ var ErrRecordExists = errors.New("record with this key already exists")
 // is up to the user to process these shadow entries and tombstones
 // appropriately during retrieval.
 type Skiplist struct {
+	// The state of the generator of node heights for a seeded skiplist (see
+	// Seed). Accessed atomically, and placed first to ensure 64-bit alignment.
+	seed uint64
+
 	arena  *Arena
 	cmp    base.Compare
 	head   *node
 	tail   *node
 	height uint32 // Current height. 1 <= height <= maxHeight. CAS.
+	seeded bool
 
 	// If set to true by tests, then extra delays are added to make it easier to
 	// detect unusual race conditions.
@@ -153,6 +158,16 @@ func (s *Skiplist) Reset(arena *Arena, cmp base.Compare) {
 	}
 }
 
+// Seed causes the heights of the nodes subsequently added to the skiplist to
+// be generated deterministically from the specified seed, rather than
+// randomly. Given the same seed, the same sequence of additions to a skiplist
+// consumes the same amount of space in the arena. Seed must be called after
+// Reset and before any nodes are added.
+func (s *Skiplist) Seed(seed uint64) {
+	s.seed = seed
+	s.seeded = true
+}
+
 // Height returns the height of the highest tower within any of the nodes that
 // have ever been allocated as part of this skiplist.
 func (s *Skiplist) Height() uint32 { return atomic.LoadUint32(&s.height) }
@@ -339,7 +354,12 @@ func (s *Skiplist) newNode(
 }
 
 func (s *Skiplist) randomHeight() uint32 {
-	rnd := fastrand.Uint32()
+	var rnd uint32
+	if s.seeded {
+		rnd = uint32(splitmix64(atomic.AddUint64(&s.seed, 0x9e3779b97f4a7c15)) >> 32)
+	} else {
+		rnd = fastrand.Uint32()
+	}
 
 	h := uint32(1)
 	for h < maxHeight && rnd <= probabilities[h] {
@@ -349,6 +369,14 @@ func (s *Skiplist) randomHeight() uint32 {
 	return h
 }
 
+// splitmix64 is the output function of the SplitMix64 generator, which mixes
+// the bits of successive values of a Weyl sequence.
+func splitmix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
 func (s *Skiplist) findSplice(key base.InternalKey, ins *Inserter) (found bool) {
 	listHeight := s.Height()
 	var level int
@@ -181,6 +181,27 @@ func TestFull(t *testing.T) {
 	require.Equal(t, ErrArenaFull, err)
 }
 
+// TestSeed tests that seeded skiplists with the same additions have the same
+// heights and sizes.
+func TestSeed(t *testing.T) {
+	build := func(seed uint64) (height, size uint32) {
+		l := NewSkiplist(newArena(arenaSize), bytes.Compare)
+		l.Seed(seed)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, l.Add(makeIntKey(i), makeValue(i)))
+		}
+		return l.Height(), l.Size()
+	}
+	height, size := build(1)
+	for i := 0; i < 5; i++ {
+		h, s := build(1)
+		require.Equal(t, height, h)
+		require.Equal(t, size, s)
+	}
+	h, s := build(2)
+	require.True(t, h != height || s != size)
+}
+
 // TestBasic tests single-threaded seeks and adds.
 func TestBasic(t *testing.T) {
 	for _, inserter := range []bool{false, true} {
@@ -0,0 +1,83 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"bytes"
+	"sync"
+)
+
+// SortKey appends the sort key of a user key to dst, returning the result. Sort
+// keys compare bytewise in the desired order of their user keys, as produced
+// by the sort key functions of collation libraries such as ICU. Distinct user
+// keys may have identical sort keys.
+type SortKey func(dst, key []byte) []byte
+
+// NewCollatingComparer returns a Comparer with the specified name which orders
+// user keys by their sort keys. User keys with identical sort keys are ordered
+// bytewise, as Compare may only consider identical keys equal. The empty key
+// is ordered before all other keys.
+//
+// sortKey is called concurrently, so any state it uses (such as a collator)
+// must be safe for concurrent use or be protected by sortKey. Separator and
+// Successor attempt to shorten keys as the default comparer does, falling back
+// to the unshortened key when the shortened key is not correctly ordered by
+// the collation.
+func NewCollatingComparer(name string, sortKey SortKey) *Comparer {
+	bufPool := sync.Pool{
+		New: func() interface{} {
+			return new([]byte)
+		},
+	}
+	compare := func(a, b []byte) int {
+		if len(a) == 0 || len(b) == 0 {
+			return len(a) - len(b)
+		}
+		buf := bufPool.Get().(*[]byte)
+		ka := sortKey((*buf)[:0], a)
+		n := len(ka)
+		kb := sortKey(ka, b)
+		v := bytes.Compare(kb[:n], kb[n:])
+		*buf = kb
+		bufPool.Put(buf)
+		if v != 0 {
+			return v
+		}
+		return bytes.Compare(a, b)
+	}
+
+	return &Comparer{
+		Compare: compare,
+		Equal:   bytes.Equal,
+		AbbreviatedKey: func(key []byte) uint64 {
+			if len(key) == 0 {
+				return 0
+			}
+			buf := bufPool.Get().(*[]byte)
+			*buf = sortKey((*buf)[:0], key)
+			v := DefaultComparer.AbbreviatedKey(*buf)
+			bufPool.Put(buf)
+			return v
+		},
+		FormatKey: DefaultFormatter,
+		Separator: func(dst, a, b []byte) []byte {
+			n := len(dst)
+			dst = DefaultComparer.Separator(dst, a, b)
+			if k := dst[n:]; compare(a, k) <= 0 && (b == nil || compare(k, b) < 0) {
+				return dst
+			}
+			return append(dst[:n], a...)
+		},
+		Successor: func(dst, a []byte) []byte {
+			n := len(dst)
+			dst = DefaultComparer.Successor(dst, a)
+			if compare(a, dst[n:]) <= 0 {
+				return dst
+			}
+			return append(dst[:n], a...)
+		},
+		Name: name,
+	}
+}
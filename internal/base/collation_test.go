@@ -0,0 +1,39 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollatingComparer(t *testing.T) {
+	// A case-insensitive collation.
+	c := NewCollatingComparer("case-insensitive", func(dst, key []byte) []byte {
+		return append(dst, bytes.ToLower(key)...)
+	})
+
+	keys := []string{"z", "b", "Ab", "aB", "", "abc", "A", "Z", "B", "a", "ab", "a\x00", "A\xff"}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+	require.Equal(t, []string{
+		"", "A", "a", "a\x00", "Ab", "aB", "ab", "abc", "A\xff", "B", "b", "Z", "z",
+	}, keys)
+
+	var bkeys [][]byte
+	for _, k := range keys {
+		bkeys = append(bkeys, []byte(k))
+	}
+	require.NoError(t, CheckComparer(c, bkeys))
+
+	// The bytewise separator "B" of "Ab" and "b" is correctly ordered by the
+	// collation, while the bytewise separator "[" of "Z" and "{" is not.
+	require.Equal(t, "B", string(c.Separator(nil, []byte("Ab"), []byte("b"))))
+	require.Equal(t, "Z", string(c.Separator(nil, []byte("Z"), []byte("{"))))
+}
@@ -0,0 +1,227 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ReverseComparer returns a Comparer which orders user keys in the reverse of
+// the order of c. If c.Split is non-nil, it is the key prefixes which are
+// reversed: keys sharing a prefix retain their order under c, so that the
+// versions of a key are ordered in the same way under both comparers, and
+// c.Split remains a valid Split function. If c.Split is nil, the order of all
+// keys is reversed. In both cases keys with an empty prefix, and so the empty
+// key, remain the smallest keys, as required by Compare.
+//
+// The Separator and Successor of the returned Comparer do not shorten keys.
+func ReverseComparer(c *Comparer) *Comparer {
+	prefix := func(k []byte) []byte {
+		if c.Split == nil {
+			return k
+		}
+		return k[:c.Split(k)]
+	}
+	compare := func(a, b []byte) int {
+		ap, bp := prefix(a), prefix(b)
+		if len(ap) == 0 || len(bp) == 0 {
+			// Keys with an empty prefix, including the empty key, are ordered
+			// before all other keys.
+			switch {
+			case len(ap) == len(bp):
+				return c.Compare(a, b)
+			case len(ap) == 0:
+				return -1
+			}
+			return +1
+		}
+		if v := c.Compare(bp, ap); v != 0 {
+			return v
+		}
+		return c.Compare(a, b)
+	}
+
+	r := &Comparer{
+		Compare:     compare,
+		Equal:       c.Equal,
+		FormatKey:   c.FormatKey,
+		FormatValue: c.FormatValue,
+		Split:       c.Split,
+		// Given a < b, a itself satisfies a <= k < b. Shortening a would
+		// require knowledge of the key encoding.
+		Separator: func(dst, a, b []byte) []byte {
+			return append(dst, a...)
+		},
+		Successor: func(dst, a []byte) []byte {
+			return append(dst, a...)
+		},
+		Name: "reverse(" + c.Name + ")",
+	}
+	if r.Equal == nil {
+		r.Equal = func(a, b []byte) bool {
+			return c.Compare(a, b) == 0
+		}
+	}
+	if c.AbbreviatedKey != nil {
+		// Complementing the abbreviated key of the prefix reverses the order of
+		// prefixes, while keys sharing a prefix have equal abbreviated keys.
+		r.AbbreviatedKey = func(key []byte) uint64 {
+			p := prefix(key)
+			if len(p) == 0 {
+				return 0
+			}
+			return ^c.AbbreviatedKey(p)
+		}
+	}
+	return r
+}
+
+// CheckComparer verifies that c satisfies the requirements documented by the
+// Compare, Equal, AbbreviatedKey, Separator, Successor and Split types over
+// the specified user keys, returning an error describing the first violation
+// found. Optional functions which are nil are not checked. A Comparer which
+// violates these requirements can silently corrupt a DB, so a custom Comparer
+// should be checked with a variety of keys, including keys sharing a prefix
+// and keys which are prefixes of other keys.
+//
+// The keys are checked pairwise, so the cost of CheckComparer is quadratic in
+// the number of keys.
+func CheckComparer(c *Comparer, keys [][]byte) error {
+	if c.Compare == nil {
+		return errors.New("pebble: comparer has no Compare function")
+	}
+	if c.Name == "" {
+		return errors.New("pebble: comparer has no name")
+	}
+	formatKey := c.FormatKey
+	if formatKey == nil {
+		formatKey = DefaultFormatter
+	}
+	fail := func(format string, args ...interface{}) error {
+		for i := range args {
+			if k, ok := args[i].([]byte); ok {
+				args[i] = formatKey(k)
+			}
+		}
+		return errors.Errorf("pebble: comparer %s: "+format, append([]interface{}{c.Name}, args...)...)
+	}
+	sign := func(v int) int {
+		switch {
+		case v < 0:
+			return -1
+		case v > 0:
+			return +1
+		}
+		return 0
+	}
+
+	sorted := append([][]byte(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return c.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	// Verify that Compare and Equal define a total order before verifying the
+	// functions whose requirements are defined in terms of that order.
+	for i, a := range sorted {
+		if len(a) > 0 {
+			if v := c.Compare(nil, a); v >= 0 {
+				return fail("Compare(\"\", %s) = %d, but the empty key must be the smallest key", a, v)
+			}
+		}
+		for j, b := range sorted {
+			v := c.Compare(a, b)
+			if w := c.Compare(b, a); sign(v) != -sign(w) {
+				return fail("Compare(%s, %s) = %d is inconsistent with Compare(%s, %s) = %d", a, b, v, b, a, w)
+			}
+			if (v == 0) != bytes.Equal(a, b) {
+				return fail("Compare(%s, %s) = %d, but only identical keys may be equal", a, b, v)
+			}
+			if i < j && v > 0 {
+				// Sorting produced an order which is not consistent with Compare,
+				// which is only possible if Compare is not transitive.
+				return fail("Compare(%s, %s) = %d is not transitive", a, b, v)
+			}
+			if c.Equal != nil && c.Equal(a, b) != (v == 0) {
+				return fail("Equal(%s, %s) = %t is inconsistent with Compare(%s, %s) = %d",
+					a, b, c.Equal(a, b), a, b, v)
+			}
+		}
+	}
+
+	for _, a := range sorted {
+		if c.Separator != nil {
+			k, err := checkAppend(c.Separator(dstSentinel(), a, nil))
+			if err != nil {
+				return fail("Separator(%s, nil): %s", a, err)
+			}
+			if c.Compare(a, k) > 0 {
+				return fail("Separator(%s, nil) = %s is less than %s", a, k, a)
+			}
+		}
+		if c.Successor != nil {
+			k, err := checkAppend(c.Successor(dstSentinel(), a))
+			if err != nil {
+				return fail("Successor(%s): %s", a, err)
+			}
+			if c.Compare(a, k) > 0 {
+				return fail("Successor(%s) = %s is less than %s", a, k, a)
+			}
+		}
+		if c.Split != nil {
+			n := c.Split(a)
+			if n < 0 || n > len(a) {
+				return fail("Split(%s) = %d is out of range", a, n)
+			}
+			if v := c.Compare(a[:n], a); v > 0 {
+				return fail("Compare(%s, %s) = %d, but a prefix must not be greater than its key", a[:n], a, v)
+			}
+		}
+	}
+
+	for i, a := range sorted {
+		for _, b := range sorted[i+1:] {
+			if c.AbbreviatedKey != nil && c.AbbreviatedKey(a) > c.AbbreviatedKey(b) {
+				return fail("AbbreviatedKey(%s) = %d > AbbreviatedKey(%s) = %d, but %s < %s",
+					a, c.AbbreviatedKey(a), b, c.AbbreviatedKey(b), a, b)
+			}
+			if c.Separator != nil {
+				k, err := checkAppend(c.Separator(dstSentinel(), a, b))
+				if err != nil {
+					return fail("Separator(%s, %s): %s", a, b, err)
+				}
+				if c.Compare(a, k) > 0 || c.Compare(k, b) >= 0 {
+					return fail("Separator(%s, %s) = %s is not in [%s, %s)", a, b, k, a, b)
+				}
+			}
+			if c.Split != nil {
+				ap, bp := a[:c.Split(a)], b[:c.Split(b)]
+				if c.Compare(ap, bp) > 0 {
+					return fail("%s < %s, but their prefixes %s and %s are out of order", a, b, ap, bp)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+const dstSentinelString = "dst"
+
+// dstSentinel returns the dst argument passed to Separator and Successor by
+// CheckComparer, which verifies that the result is appended to it.
+func dstSentinel() []byte {
+	return []byte(dstSentinelString)
+}
+
+// checkAppend verifies that dst, as returned by Separator or Successor, begins
+// with the contents of dstSentinel, returning the appended key.
+func checkAppend(dst []byte) ([]byte, error) {
+	if !bytes.HasPrefix(dst, []byte(dstSentinelString)) {
+		return nil, errors.New("result was not appended to dst")
+	}
+	return dst[len(dstSentinelString):], nil
+}
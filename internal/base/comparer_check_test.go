@@ -0,0 +1,176 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func checkComparerKeys() [][]byte {
+	k := func(prefix string, ts uint64) []byte {
+		return MakeTimestampKey(nil, []byte(prefix), ts)
+	}
+	return [][]byte{
+		[]byte(""),
+		k("", 2),
+		[]byte("a"),
+		k("a", MaxTimestamp),
+		k("a", 3),
+		k("a", 1),
+		k("a", 0),
+		[]byte("ab"),
+		k("ab", 7),
+		k("b", 1),
+		[]byte("b\xff\xff"),
+	}
+}
+
+func TestReverseComparer(t *testing.T) {
+	k := func(prefix string, ts uint64) string {
+		return string(MakeTimestampKey(nil, []byte(prefix), ts))
+	}
+	sortKeys := func(c *Comparer, keys []string) {
+		sort.Slice(keys, func(i, j int) bool {
+			return c.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+		})
+	}
+
+	keys := []string{"b", "", "a", "ab", "c"}
+	sortKeys(ReverseComparer(DefaultComparer), keys)
+	require.Equal(t, []string{"", "c", "b", "ab", "a"}, keys)
+
+	// The prefixes are reversed, while the versions of each prefix remain
+	// ordered by descending timestamp.
+	keys = []string{k("a", 1), k("b", 1), k("a", 2), "", k("b", 3), "a"}
+	sortKeys(ReverseComparer(TimestampComparer), keys)
+	require.Equal(t, []string{"", k("b", 3), k("b", 1), "a", k("a", 2), k("a", 1)}, keys)
+
+	require.Equal(t, "reverse(leveldb.BytewiseComparator)", ReverseComparer(DefaultComparer).Name)
+}
+
+func TestCheckComparer(t *testing.T) {
+	keys := checkComparerKeys()
+	for _, c := range []*Comparer{
+		DefaultComparer,
+		TimestampComparer,
+		ReverseComparer(DefaultComparer),
+		ReverseComparer(TimestampComparer),
+	} {
+		t.Run(c.Name, func(t *testing.T) {
+			require.NoError(t, CheckComparer(c, keys))
+		})
+	}
+
+	// Each of the following comparers violates a single requirement.
+	broken := func(fn func(c *Comparer)) *Comparer {
+		c := *DefaultComparer
+		c.Name = "broken"
+		fn(&c)
+		return &c
+	}
+	testCases := []struct {
+		c   *Comparer
+		err string
+	}{
+		{
+			c: broken(func(c *Comparer) {
+				c.Compare = func(a, b []byte) int {
+					return bytes.Compare(a, b[:len(b)/2])
+				}
+			}),
+			err: "is inconsistent with Compare",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				// Keys of the same length compare equal.
+				c.Compare = func(a, b []byte) int {
+					return len(a) - len(b)
+				}
+				c.AbbreviatedKey = nil
+			}),
+			err: "only identical keys may be equal",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Compare = func(a, b []byte) int {
+					return -bytes.Compare(a, b)
+				}
+			}),
+			err: "the empty key must be the smallest key",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Equal = func(a, b []byte) bool {
+					return len(a) == len(b)
+				}
+			}),
+			err: "Equal(",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.AbbreviatedKey = func(key []byte) uint64 {
+					return ^DefaultComparer.AbbreviatedKey(key)
+				}
+			}),
+			err: "AbbreviatedKey(",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Separator = func(dst, a, b []byte) []byte {
+					if b == nil {
+						return append(dst, a...)
+					}
+					return append(dst, b...)
+				}
+			}),
+			err: "is not in",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Separator = func(dst, a, b []byte) []byte {
+					return a
+				}
+			}),
+			err: "result was not appended to dst",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Successor = func(dst, a []byte) []byte {
+					return dst
+				}
+			}),
+			err: "Successor(",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Split = func(a []byte) int {
+					if len(a) > 0 && a[0] == 'b' {
+						return 0
+					}
+					return len(a)
+				}
+			}),
+			err: "are out of order",
+		},
+		{
+			c: broken(func(c *Comparer) {
+				c.Split = func(a []byte) int {
+					return len(a) + 1
+				}
+			}),
+			err: "is out of range",
+		},
+	}
+	for i, tc := range testCases {
+		err := CheckComparer(tc.c, keys)
+		require.Error(t, err, "%d", i)
+		require.True(t, strings.Contains(err.Error(), tc.err), "%d: %v", i, err)
+	}
+}
@@ -47,6 +47,26 @@ const (
 	// seqNum.
 	InternalKeyKindMax InternalKeyKind = 17
 
+	// InternalKeyKindRangeKeyDelete, InternalKeyKindRangeKeyUnset and
+	// InternalKeyKindRangeKeySet are the kinds of range keys. Range keys are
+	// stored separately from point keys and range deletions, in their own
+	// batch index, memtable skiplist and sstable block, and therefore do not
+	// need to be bounded by InternalKeyKindMax. See the rangekey package.
+	InternalKeyKindRangeKeyDelete InternalKeyKind = 19
+	InternalKeyKindRangeKeyUnset  InternalKeyKind = 20
+	InternalKeyKindRangeKeySet    InternalKeyKind = 21
+
+	// InternalKeyKindIngestSST is used in WAL batches to record the file number
+	// of an sstable that was ingested as a flushable. It never appears in a
+	// memtable or sstable, and is therefore outside of the range of kinds
+	// bounded by InternalKeyKindMax.
+	InternalKeyKindIngestSST InternalKeyKind = 22
+
+	// InternalKeyKindKeyspaceBatch is used in WAL batches to record a batch
+	// committed to a keyspace, which shares the WAL of its DB. Like
+	// InternalKeyKindIngestSST, it never appears in a memtable or sstable.
+	InternalKeyKindKeyspaceBatch InternalKeyKind = 23
+
 	// A marker for an invalid key.
 	InternalKeyKindInvalid InternalKeyKind = 255
 
@@ -66,14 +86,19 @@ const (
 )
 
 var internalKeyKindNames = []string{
-	InternalKeyKindDelete:       "DEL",
-	InternalKeyKindSet:          "SET",
-	InternalKeyKindMerge:        "MERGE",
-	InternalKeyKindLogData:      "LOGDATA",
-	InternalKeyKindSingleDelete: "SINGLEDEL",
-	InternalKeyKindRangeDelete:  "RANGEDEL",
-	InternalKeyKindMax:          "MAX",
-	InternalKeyKindInvalid:      "INVALID",
+	InternalKeyKindDelete:         "DEL",
+	InternalKeyKindSet:            "SET",
+	InternalKeyKindMerge:          "MERGE",
+	InternalKeyKindLogData:        "LOGDATA",
+	InternalKeyKindSingleDelete:   "SINGLEDEL",
+	InternalKeyKindRangeDelete:    "RANGEDEL",
+	InternalKeyKindMax:            "MAX",
+	InternalKeyKindRangeKeyDelete: "RANGEKEYDEL",
+	InternalKeyKindRangeKeyUnset:  "RANGEKEYUNSET",
+	InternalKeyKindRangeKeySet:    "RANGEKEYSET",
+	InternalKeyKindIngestSST:      "INGESTSST",
+	InternalKeyKindKeyspaceBatch:  "KEYSPACEBATCH",
+	InternalKeyKindInvalid:        "INVALID",
 }
 
 func (k InternalKeyKind) String() string {
@@ -137,6 +162,10 @@ var kindsMap = map[string]InternalKeyKind{
 	"MERGE":     InternalKeyKindMerge,
 	"INVALID":   InternalKeyKindInvalid,
 	"MAX":       InternalKeyKindMax,
+
+	"RANGEKEYDEL":   InternalKeyKindRangeKeyDelete,
+	"RANGEKEYUNSET": InternalKeyKindRangeKeyUnset,
+	"RANGEKEYSET":   InternalKeyKindRangeKeySet,
 }
 
 // ParseInternalKey parses the string representation of an internal key. The
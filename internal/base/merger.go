@@ -53,6 +53,41 @@ type ValueMerger interface {
 	Finish() ([]byte, io.Closer, error)
 }
 
+// PartialValueMerger is an optional extension of ValueMerger for merge
+// operations which distinguish a partial merge from a full merge. A full
+// merge combines operands with the oldest value of a key (or with the
+// knowledge that the key has no older value), as happens when a key is read,
+// or when a compaction encounters a SET or a deletion of the key. A partial
+// merge combines adjacent operands which are newer than the oldest value, as
+// happens when a compaction or flush encounters several merge operands for a
+// key but none of its older values. The result of a partial merge is written
+// as a new merge operand, which is later merged with the older values.
+//
+// A ValueMerger which does not implement PartialValueMerger has Finish called
+// for both partial and full merges.
+type PartialValueMerger interface {
+	ValueMerger
+
+	// PartialFinish is called instead of Finish when the added operands do
+	// not include the oldest value of the key. The returned value must be a
+	// valid merge operand, such that merging it with older values is
+	// equivalent to merging each of the added operands with them.
+	//
+	// The same requirements as for Finish apply to the returned slice and
+	// Closer.
+	PartialFinish() ([]byte, io.Closer, error)
+}
+
+// FinishValueMerger finishes a merge, calling PartialFinish if m implements
+// PartialValueMerger and the merge is partial (includesBase is false), and
+// Finish otherwise.
+func FinishValueMerger(m ValueMerger, includesBase bool) ([]byte, io.Closer, error) {
+	if pm, ok := m.(PartialValueMerger); ok && !includesBase {
+		return pm.PartialFinish()
+	}
+	return m.Finish()
+}
+
 // Merger defines an associative merge operation. The merge operation merges
 // two or more values for a single key. A merge operation is requested by
 // writing a value using {Batch,DB}.Merge(). The value at that key is merged
@@ -0,0 +1,147 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// TimestampLen is the length of the timestamp suffix of the user keys ordered
+// by TimestampComparer.
+const TimestampLen = 8
+
+// MaxTimestamp is the largest timestamp. As versions are ordered by descending
+// timestamp, the key MakeTimestampKey(prefix, MaxTimestamp) sorts before all
+// other versions of the prefix, which makes it a useful seek key.
+const MaxTimestamp uint64 = math.MaxUint64
+
+// MakeTimestampKey appends the version of prefix at timestamp ts to dst,
+// returning the resulting user key.
+func MakeTimestampKey(dst, prefix []byte, ts uint64) []byte {
+	var buf [TimestampLen]byte
+	binary.BigEndian.PutUint64(buf[:], ts)
+	dst = append(dst, prefix...)
+	return append(dst, buf[:]...)
+}
+
+// SplitTimestampKey splits a user key ordered by TimestampComparer into its
+// prefix and timestamp. Keys shorter than TimestampLen have no timestamp, in
+// which case the whole key is returned as the prefix and ok is false.
+func SplitTimestampKey(key []byte) (prefix []byte, ts uint64, ok bool) {
+	n := len(key) - TimestampLen
+	if n < 0 {
+		return key, 0, false
+	}
+	return key[:n], binary.BigEndian.Uint64(key[n:]), true
+}
+
+// DecodeTimestamp decodes the timestamp suffix of a user key, as separated
+// from its prefix by TimestampComparer.Split. It returns false if the suffix
+// is not a timestamp.
+func DecodeTimestamp(suffix []byte) (uint64, bool) {
+	if len(suffix) != TimestampLen {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(suffix), true
+}
+
+func timestampCompare(a, b []byte) int {
+	aPrefix, aTS, aOK := SplitTimestampKey(a)
+	bPrefix, bTS, bOK := SplitTimestampKey(b)
+	if c := bytes.Compare(aPrefix, bPrefix); c != 0 {
+		return c
+	}
+	// A prefix without a timestamp sorts before its versions, which are
+	// ordered from newest to oldest.
+	switch {
+	case !aOK || !bOK:
+		if aOK == bOK {
+			return 0
+		}
+		if !aOK {
+			return -1
+		}
+		return +1
+	case aTS > bTS:
+		return -1
+	case aTS < bTS:
+		return +1
+	}
+	return 0
+}
+
+// timestampKeyFormatter formats a user key ordered by TimestampComparer as
+// <prefix>@<timestamp>.
+type timestampKeyFormatter []byte
+
+// Format implements the fmt.Formatter interface.
+func (k timestampKeyFormatter) Format(s fmt.State, c rune) {
+	prefix, ts, ok := SplitTimestampKey(k)
+	if !ok {
+		FormatBytes(prefix).Format(s, c)
+		return
+	}
+	fmt.Fprintf(s, "%s@%d", FormatBytes(prefix), ts)
+}
+
+// TimestampComparer orders user keys composed of a prefix followed by a fixed
+// width, TimestampLen byte, big-endian timestamp suffix (see
+// MakeTimestampKey). Keys are ordered by their prefix, and the versions of a
+// prefix are ordered by descending timestamp so that the newest version comes
+// first. Keys shorter than TimestampLen have no timestamp and sort before all
+// of the versions of their prefix.
+//
+// Split separates the prefix from the timestamp, so that bloom filters and
+// prefix iteration operate on the prefix, and IterOptions.Timestamp may be
+// used to read the versions visible as of a timestamp.
+var TimestampComparer = &Comparer{
+	Compare: timestampCompare,
+	Equal:   bytes.Equal,
+
+	AbbreviatedKey: func(key []byte) uint64 {
+		prefix, _, _ := SplitTimestampKey(key)
+		return DefaultComparer.AbbreviatedKey(prefix)
+	},
+
+	FormatKey: func(key []byte) fmt.Formatter {
+		return timestampKeyFormatter(key)
+	},
+
+	Separator: func(dst, a, b []byte) []byte {
+		aPrefix, _, _ := SplitTimestampKey(a)
+		bPrefix, _, _ := SplitTimestampKey(b)
+		if bytes.Equal(aPrefix, bPrefix) {
+			return append(dst, a...)
+		}
+		n := len(dst)
+		dst = DefaultComparer.Separator(dst, aPrefix, bPrefix)
+		if bytes.Equal(aPrefix, dst[n:]) {
+			return append(dst[:n], a...)
+		}
+		// The separator is a prefix greater than aPrefix and less than bPrefix.
+		// Its newest version is a valid key which sorts between a and b.
+		return MakeTimestampKey(dst, nil, MaxTimestamp)
+	},
+
+	Split: func(key []byte) int {
+		prefix, _, _ := SplitTimestampKey(key)
+		return len(prefix)
+	},
+
+	Successor: func(dst, a []byte) []byte {
+		aPrefix, _, _ := SplitTimestampKey(a)
+		n := len(dst)
+		dst = DefaultComparer.Successor(dst, aPrefix)
+		if bytes.Equal(aPrefix, dst[n:]) {
+			return append(dst[:n], a...)
+		}
+		return MakeTimestampKey(dst, nil, MaxTimestamp)
+	},
+
+	Name: "pebble.TimestampComparator",
+}
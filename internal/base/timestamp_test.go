@@ -0,0 +1,86 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampComparer(t *testing.T) {
+	c := TimestampComparer
+	k := func(prefix string, ts uint64) []byte {
+		return MakeTimestampKey(nil, []byte(prefix), ts)
+	}
+
+	// Keys in ascending order.
+	keys := [][]byte{
+		[]byte(""),
+		k("", 2),
+		[]byte("a"),
+		k("a", MaxTimestamp),
+		k("a", 3),
+		k("a", 1),
+		k("a", 0),
+		[]byte("ab"),
+		k("ab", 7),
+		k("b", 1),
+	}
+	for i := range keys {
+		for j := range keys {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = +1
+			}
+			require.Equal(t, want, c.Compare(keys[i], keys[j]), "%x vs %x", keys[i], keys[j])
+			if i < j {
+				require.True(t, c.AbbreviatedKey(keys[i]) <= c.AbbreviatedKey(keys[j]))
+			}
+		}
+	}
+
+	require.Equal(t, len("ab"), c.Split(k("ab", 7)))
+	require.Equal(t, len("ab"), c.Split([]byte("ab")))
+
+	prefix, ts, ok := SplitTimestampKey(k("ab", 7))
+	require.Equal(t, "ab", string(prefix))
+	require.Equal(t, uint64(7), ts)
+	require.True(t, ok)
+	_, _, ok = SplitTimestampKey([]byte("ab"))
+	require.False(t, ok)
+
+	require.Equal(t, "ab@7", fmt.Sprint(c.FormatKey(k("ab", 7))))
+	require.Equal(t, "ab", fmt.Sprint(c.FormatKey([]byte("ab"))))
+}
+
+func TestTimestampComparerSeparator(t *testing.T) {
+	c := TimestampComparer
+	k := func(prefix string, ts uint64) []byte {
+		return MakeTimestampKey(nil, []byte(prefix), ts)
+	}
+	testCases := []struct {
+		a, b, want []byte
+	}{
+		{k("black", 1), k("blue", 2), k("blb", MaxTimestamp)},
+		{k("black", 2), k("black", 1), k("black", 2)},
+		{k("a", 1), k("b", 1), k("a", 1)},
+		{k("green", 1), nil, k("green", 1)},
+	}
+	for _, tc := range testCases {
+		got := c.Separator(nil, tc.a, tc.b)
+		require.Equal(t, fmt.Sprint(c.FormatKey(tc.want)), fmt.Sprint(c.FormatKey(got)))
+		require.True(t, c.Compare(tc.a, got) <= 0)
+		if tc.b != nil {
+			require.True(t, c.Compare(got, tc.b) < 0)
+		}
+	}
+
+	require.Equal(t, k("b", MaxTimestamp), c.Successor(nil, k("abc", 3)))
+	require.Equal(t, k("\xff", 3), c.Successor(nil, k("\xff", 3)))
+}
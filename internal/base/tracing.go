@@ -0,0 +1,59 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package base
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer creates the spans of the operations performed with a context to
+// which it is attached with ContextWithTracer. It is typically an adapter to
+// a distributed tracing system, such as OpenTelemetry.
+type Tracer interface {
+	// StartSpan starts a span with the specified name and start time, as a
+	// child of the span of ctx, if any. It returns a context holding the new
+	// span, which is the parent of the spans started with it. The start time
+	// may be in the past, as spans are started once the duration of the
+	// operation which they describe is known.
+	StartSpan(ctx context.Context, name string, start time.Time) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span.
+	SetAttribute(key string, value int64)
+	// End ends the span at the specified time.
+	End(end time.Time)
+}
+
+type tracerKey struct{}
+
+// ContextWithTracer returns a copy of ctx to which the tracer is attached.
+func ContextWithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// StartSpan starts a span using the tracer attached to ctx. It returns ctx
+// and a nil span if ctx is nil or has no tracer attached.
+func StartSpan(ctx context.Context, name string, start time.Time) (context.Context, Span) {
+	if ctx == nil {
+		return ctx, nil
+	}
+	t, _ := ctx.Value(tracerKey{}).(Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	return t.StartSpan(ctx, name, start)
+}
+
+// Traced returns true if a tracer is attached to ctx.
+func Traced(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	_, ok := ctx.Value(tracerKey{}).(Tracer)
+	return ok
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package corruption provides facilities for injecting faults into the files
+// of a DB in order to test how corruption and data loss are handled: flipping
+// bits in files and in specific sstable blocks, truncating the tail of a file
+// (such as a WAL), and dropping the writes which were not synced before a
+// simulated crash.
+//
+// The functions which modify files rewrite them in their entirety, so they
+// must not be used on files which are open by a DB.
+package corruption
+
+import (
+	"io/ioutil"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// BlockType identifies a type of block within an sstable.
+type BlockType int
+
+// The block types which can be corrupted by FlipBlockBit.
+const (
+	DataBlock BlockType = iota
+	IndexBlock
+	TopIndexBlock
+	FilterBlock
+	RangeDelBlock
+	RangeKeyBlock
+	PropertiesBlock
+	MetaIndexBlock
+	Footer
+)
+
+// String implements fmt.Stringer.
+func (t BlockType) String() string {
+	switch t {
+	case DataBlock:
+		return "data"
+	case IndexBlock:
+		return "index"
+	case TopIndexBlock:
+		return "top-index"
+	case FilterBlock:
+		return "filter"
+	case RangeDelBlock:
+		return "range-del"
+	case RangeKeyBlock:
+		return "range-key"
+	case PropertiesBlock:
+		return "properties"
+	case MetaIndexBlock:
+		return "meta-index"
+	case Footer:
+		return "footer"
+	}
+	return "unknown"
+}
+
+func readFile(fs vfs.FS, path string) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// writeFile replaces the contents of the named file with data, syncing the
+// new contents so that they survive a subsequent simulated crash.
+func writeFile(fs vfs.FS, path string, data []byte) error {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// FlipBit inverts the specified bit (0-7) of the byte at offset within the
+// named file.
+func FlipBit(fs vfs.FS, path string, offset int64, bit uint) error {
+	if bit > 7 {
+		return errors.Errorf("invalid bit %d", errors.Safe(bit))
+	}
+	data, err := readFile(fs, path)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset >= int64(len(data)) {
+		return errors.Errorf("offset %d out of range for %s (%d bytes)",
+			errors.Safe(offset), path, errors.Safe(len(data)))
+	}
+	data[offset] ^= 1 << bit
+	return writeFile(fs, path, data)
+}
+
+// TruncateTail removes the last n bytes of the named file, simulating a torn
+// write at the tail of a log.
+func TruncateTail(fs vfs.FS, path string, n int64) error {
+	data, err := readFile(fs, path)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > int64(len(data)) {
+		return errors.Errorf("cannot truncate %d bytes from %s (%d bytes)",
+			errors.Safe(n), path, errors.Safe(len(data)))
+	}
+	return writeFile(fs, path, data[:int64(len(data))-n])
+}
+
+// Block returns the handle of the index'th block of the specified type within
+// the named sstable. The index must be zero for all block types other than
+// data and index blocks.
+func Block(fs vfs.FS, path string, typ BlockType, index int) (sstable.BlockHandle, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return sstable.BlockHandle{}, err
+	}
+	// The filter block is only located by the reader if the filter policy is
+	// known.
+	fp := bloom.FilterPolicy(10)
+	r, err := sstable.NewReader(f, sstable.ReaderOptions{
+		Filters: map[string]sstable.FilterPolicy{fp.Name(): fp},
+	})
+	if err != nil {
+		return sstable.BlockHandle{}, err
+	}
+	defer r.Close()
+	l, err := r.Layout()
+	if err != nil {
+		return sstable.BlockHandle{}, err
+	}
+
+	var handles []sstable.BlockHandle
+	switch typ {
+	case DataBlock:
+		handles = l.Data
+	case IndexBlock:
+		handles = l.Index
+	case TopIndexBlock:
+		handles = []sstable.BlockHandle{l.TopIndex}
+	case FilterBlock:
+		handles = []sstable.BlockHandle{l.Filter}
+	case RangeDelBlock:
+		handles = []sstable.BlockHandle{l.RangeDel}
+	case RangeKeyBlock:
+		handles = []sstable.BlockHandle{l.RangeKey}
+	case PropertiesBlock:
+		handles = []sstable.BlockHandle{l.Properties}
+	case MetaIndexBlock:
+		handles = []sstable.BlockHandle{l.MetaIndex}
+	case Footer:
+		handles = []sstable.BlockHandle{l.Footer}
+	default:
+		return sstable.BlockHandle{}, errors.Errorf("unknown block type %d", errors.Safe(int(typ)))
+	}
+	if index < 0 || index >= len(handles) || handles[index].Length == 0 {
+		return sstable.BlockHandle{}, errors.Errorf("%s has no %s block %d",
+			path, errors.Safe(typ), errors.Safe(index))
+	}
+	return handles[index], nil
+}
+
+// FlipBlockBit inverts the specified bit of the byte at offset within the
+// contents of the index'th block of the specified type in the named sstable.
+// The block trailer is not included in the addressable contents, so the
+// corruption is detected by the block checksum (except for the footer, which
+// has no checksum).
+func FlipBlockBit(
+	fs vfs.FS, path string, typ BlockType, index int, offset int64, bit uint,
+) error {
+	bh, err := Block(fs, path, typ, index)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset >= int64(bh.Length) {
+		return errors.Errorf("offset %d out of range for %s block %d (%d bytes)",
+			errors.Safe(offset), errors.Safe(typ), errors.Safe(index), errors.Safe(bh.Length))
+	}
+	return FlipBit(fs, path, int64(bh.Offset)+offset, bit)
+}
+
+// Crash simulates a crash of the process using fs, which must have been
+// created by vfs.NewStrictMem. The close function (typically DB.Close) is
+// invoked with syncs ignored in order to release the resources held by the
+// DB, after which all of the writes which were not synced before Crash was
+// called are discarded.
+func Crash(fs *vfs.MemFS, close func() error) error {
+	fs.SetIgnoreSyncs(true)
+	err := close()
+	fs.ResetToSyncedState()
+	fs.SetIgnoreSyncs(false)
+	return err
+}
@@ -12,6 +12,10 @@
 // To calculate the uint32 checksum of some data:
 //	var u uint32 = crc.New(data).Value()
 // In pebble, the uint32 value is then stored in little-endian format.
+//
+// The checksum is computed by hash/crc32, which detects at runtime and uses the
+// CRC32 instructions of SSE 4.2 on amd64 and of ARMv8 on arm64, falling back to
+// a portable table-driven implementation on other platforms.
 package crc // import "github.com/cockroachdb/pebble/internal/crc"
 
 import (
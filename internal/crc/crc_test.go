@@ -0,0 +1,92 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package crc
+
+import (
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"golang.org/x/exp/rand"
+)
+
+// referenceUpdate is a bitwise implementation of CRC-32C, against which the
+// hardware accelerated implementation used by Update is checked.
+func referenceUpdate(crc uint32, b []byte) uint32 {
+	crc = ^crc
+	for _, c := range b {
+		crc ^= uint32(c)
+		for i := 0; i < 8; i++ {
+			crc = crc>>1 ^ (crc32.Castagnoli & -(crc & 1))
+		}
+	}
+	return ^crc
+}
+
+// tableUpdate is a byte-at-a-time table-driven implementation of CRC-32C,
+// representative of the portable implementation which hash/crc32 falls back to
+// in the absence of hardware support.
+func tableUpdate(crc uint32, b []byte) uint32 {
+	crc = ^crc
+	for _, c := range b {
+		crc = table[byte(crc)^c] ^ crc>>8
+	}
+	return ^crc
+}
+
+func TestCRC(t *testing.T) {
+	// The standard check value of CRC-32C.
+	if v := uint32(New([]byte("123456789"))); v != 0xe3069283 {
+		t.Fatalf("expected 0xe3069283, but found %#x", v)
+	}
+
+	rng := rand.New(rand.NewSource(uint64(1)))
+	buf := make([]byte, 4096+16)
+	rng.Read(buf)
+	// The hardware implementations process unaligned heads and short tails
+	// separately from the aligned bulk of the data, so check a variety of
+	// offsets and lengths.
+	for _, n := range []int{0, 1, 3, 7, 8, 15, 16, 63, 64, 255, 256, 1000, 4096} {
+		for off := 0; off < 16; off++ {
+			b := buf[off : off+n]
+			expected := referenceUpdate(0, b)
+			if v := uint32(New(b)); v != expected {
+				t.Fatalf("len=%d off=%d: expected %#x, but found %#x", n, off, expected, v)
+			}
+			if v := tableUpdate(0, b); v != expected {
+				t.Fatalf("len=%d off=%d: expected %#x, but found %#x from fallback", n, off, expected, v)
+			}
+			// Incremental updates produce the same checksum.
+			if n > 0 {
+				split := rng.Intn(n)
+				if v := uint32(New(b[:split]).Update(b[split:])); v != expected {
+					t.Fatalf("len=%d off=%d split=%d: expected %#x, but found %#x",
+						n, off, split, expected, v)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkCRC(b *testing.B) {
+	for _, size := range []int{64, 4 << 10, 32 << 10} {
+		buf := make([]byte, size)
+		rand.New(rand.NewSource(uint64(1))).Read(buf)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			b.Run("hardware", func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					_ = New(buf)
+				}
+			})
+			b.Run("fallback", func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					_ = tableUpdate(0, buf)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package histogram provides a concurrent, fixed-memory histogram suitable for
+// recording latencies and sizes on performance critical code paths.
+package histogram
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// subBucketBits is the number of bits of precision below the most significant
+// bit that are used to select a bucket. Each power of 2 is split into
+// 1<<subBucketBits buckets, bounding the relative error of a recorded value to
+// 1/(1<<subBucketBits) (25%).
+const subBucketBits = 2
+
+const subBuckets = 1 << subBucketBits
+
+// NumBuckets is the number of buckets in a Histogram. The buckets cover the
+// entire range of non-negative int64 values.
+const NumBuckets = (64 - subBucketBits) * subBuckets
+
+// bucketIndex returns the index of the bucket containing v.
+func bucketIndex(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - 1 - subBucketBits
+	sub := int(v>>uint(shift)) & (subBuckets - 1)
+	return (shift+1)*subBuckets + sub
+}
+
+// BucketLowerBound returns the smallest value (inclusive) contained in the
+// specified bucket.
+func BucketLowerBound(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := uint(i/subBuckets - 1)
+	return uint64(subBuckets+i%subBuckets) << shift
+}
+
+// BucketUpperBound returns the largest value (exclusive) contained in the
+// specified bucket.
+func BucketUpperBound(i int) uint64 {
+	if i+1 >= NumBuckets {
+		return 1 << 63
+	}
+	return BucketLowerBound(i + 1)
+}
+
+// Histogram records the distribution of a series of non-negative values using
+// exponentially sized buckets. Recording a value is lock-free and safe for use
+// by concurrent goroutines. The zero value is ready for use.
+type Histogram struct {
+	count   uint64
+	sum     uint64
+	max     uint64
+	buckets [NumBuckets]uint64
+}
+
+// Record adds the specified value to the histogram. Negative values are
+// recorded as zero.
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	u := uint64(v)
+	atomic.AddUint64(&h.buckets[bucketIndex(u)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, u)
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if u <= max || atomic.CompareAndSwapUint64(&h.max, max, u) {
+			break
+		}
+	}
+}
+
+// RecordDuration adds the specified duration, in nanoseconds, to the
+// histogram.
+func (h *Histogram) RecordDuration(d time.Duration) {
+	h.Record(int64(d))
+}
+
+// Snapshot returns a copy of the current state of the histogram. The snapshot
+// is not an atomic view across buckets: values recorded concurrently with the
+// call to Snapshot may be partially reflected.
+func (h *Histogram) Snapshot() Snapshot {
+	var s Snapshot
+	s.Count = atomic.LoadUint64(&h.count)
+	s.Sum = atomic.LoadUint64(&h.sum)
+	s.Max = atomic.LoadUint64(&h.max)
+	for i := range h.buckets {
+		s.Buckets[i] = atomic.LoadUint64(&h.buckets[i])
+	}
+	return s
+}
+
+// Reset clears the histogram. Like Snapshot, Reset is not atomic with respect
+// to concurrent calls to Record.
+func (h *Histogram) Reset() {
+	for i := range h.buckets {
+		atomic.StoreUint64(&h.buckets[i], 0)
+	}
+	atomic.StoreUint64(&h.count, 0)
+	atomic.StoreUint64(&h.sum, 0)
+	atomic.StoreUint64(&h.max, 0)
+}
+
+// Snapshot is a point-in-time copy of a Histogram.
+type Snapshot struct {
+	// Count is the number of recorded values.
+	Count uint64
+	// Sum is the sum of the recorded values.
+	Sum uint64
+	// Max is the largest recorded value.
+	Max uint64
+	// Buckets holds the count of recorded values in each bucket. See
+	// BucketLowerBound and BucketUpperBound for the bucket boundaries.
+	Buckets [NumBuckets]uint64
+}
+
+// Mean returns the mean of the recorded values.
+func (s *Snapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// ValueAtQuantile returns an estimate of the value at the specified quantile,
+// expressed as a percentage in the range [0,100]. The estimate is the upper
+// bound of the bucket containing the quantile, capped at the maximum recorded
+// value.
+func (s *Snapshot) ValueAtQuantile(q float64) uint64 {
+	if s.Count == 0 {
+		return 0
+	}
+	if q > 100 {
+		q = 100
+	}
+	target := uint64(q / 100 * float64(s.Count))
+	if target == 0 {
+		target = 1
+	}
+	var cumulative uint64
+	for i := range s.Buckets {
+		cumulative += s.Buckets[i]
+		if cumulative >= target {
+			v := BucketUpperBound(i) - 1
+			if v > s.Max {
+				v = s.Max
+			}
+			return v
+		}
+	}
+	return s.Max
+}
+
+// Merge adds the values recorded in o to the receiver.
+func (s *Snapshot) Merge(o *Snapshot) {
+	s.Count += o.Count
+	s.Sum += o.Sum
+	if s.Max < o.Max {
+		s.Max = o.Max
+	}
+	for i := range s.Buckets {
+		s.Buckets[i] += o.Buckets[i]
+	}
+}
+
+// Sub returns the values recorded in the receiver that were not recorded in
+// the earlier snapshot o. Max is retained from the receiver as it cannot be
+// subtracted.
+func (s Snapshot) Sub(o *Snapshot) Snapshot {
+	s.Count -= o.Count
+	s.Sum -= o.Sum
+	for i := range s.Buckets {
+		s.Buckets[i] -= o.Buckets[i]
+	}
+	return s
+}
+
+// String returns a summary of the distribution suitable for logging, treating
+// the recorded values as plain integers.
+func (s *Snapshot) String() string {
+	return fmt.Sprintf("count=%d mean=%.1f p50=%d p99=%d max=%d",
+		s.Count, s.Mean(), s.ValueAtQuantile(50), s.ValueAtQuantile(99), s.Max)
+}
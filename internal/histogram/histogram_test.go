@@ -0,0 +1,95 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package histogram
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketBounds(t *testing.T) {
+	for _, v := range []uint64{0, 1, 3, 4, 5, 7, 8, 9, 1000, 1 << 40, math.MaxInt64} {
+		i := bucketIndex(v)
+		require.True(t, i < NumBuckets, "v=%d i=%d", v, i)
+		require.True(t, BucketLowerBound(i) <= v, "v=%d lower=%d", v, BucketLowerBound(i))
+		if i+1 < NumBuckets {
+			require.True(t, v < BucketUpperBound(i), "v=%d upper=%d", v, BucketUpperBound(i))
+		}
+	}
+	for i := 1; i < NumBuckets; i++ {
+		require.Equal(t, i, bucketIndex(BucketLowerBound(i)))
+		require.Equal(t, i-1, bucketIndex(BucketLowerBound(i)-1))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for i := int64(1); i <= 1000; i++ {
+		h.Record(i)
+	}
+	h.Record(-1)
+
+	s := h.Snapshot()
+	require.EqualValues(t, 1001, s.Count)
+	require.EqualValues(t, 500500, s.Sum)
+	require.EqualValues(t, 1000, s.Max)
+	require.EqualValues(t, 1000, s.ValueAtQuantile(100))
+
+	// Quantile estimates are bounded by the bucket precision (25%).
+	p50 := float64(s.ValueAtQuantile(50))
+	require.InDelta(t, 500, p50, 500*0.25)
+	p99 := float64(s.ValueAtQuantile(99))
+	require.InDelta(t, 990, p99, 990*0.25)
+
+	prev := s
+	h.Record(2000)
+	s = h.Snapshot()
+	d := s.Sub(&prev)
+	require.EqualValues(t, 1, d.Count)
+	require.EqualValues(t, 2000, d.Sum)
+
+	var merged Snapshot
+	merged.Merge(&prev)
+	merged.Merge(&d)
+	require.Equal(t, s, merged)
+
+	h.Reset()
+	s = h.Snapshot()
+	require.Equal(t, Snapshot{}, s)
+	require.EqualValues(t, 0, s.ValueAtQuantile(50))
+}
+
+func TestHistogramConcurrent(t *testing.T) {
+	var h Histogram
+	var wg sync.WaitGroup
+	const goroutines, n = 8, 10000
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				h.Record(int64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	s := h.Snapshot()
+	require.EqualValues(t, goroutines*n, s.Count)
+	require.EqualValues(t, n-1, s.Max)
+}
+
+func BenchmarkRecord(b *testing.B) {
+	var h Histogram
+	b.RunParallel(func(pb *testing.PB) {
+		var i int64
+		for pb.Next() {
+			h.Record(i)
+			i++
+		}
+	})
+}
@@ -36,6 +36,11 @@ type TableInfo struct {
 	SmallestSeqNum uint64
 	// LargestSeqNum is the largest sequence number in the table.
 	LargestSeqNum uint64
+	// BackingFileNum is the file number of the table holding the data of a
+	// virtual table, or zero if the table is not virtual. The size of a
+	// virtual table is an estimate of the size of its portion of the backing
+	// table.
+	BackingFileNum base.FileNum `json:",omitempty"`
 }
 
 // TableStats contains statistics on a table used for compaction heuristics.
@@ -43,6 +48,11 @@ type TableStats struct {
 	// Valid true if stats have been loaded for the table. The rest of the
 	// structure is populated only if true.
 	Valid bool
+	// The total number of entries in the table, including point keys, point
+	// tombstones and range deletions.
+	NumEntries uint64
+	// The number of point and range deletion tombstones in the table.
+	NumDeletions uint64
 	// Estimate of the total disk space that may be reclaimed by compacting
 	// this table's range deletions to the bottom of the LSM. This estimate is
 	// at data-block granularity and is not updated if compactions beneath the
@@ -75,6 +85,23 @@ type FileMetadata struct {
 	LargestSeqNum  uint64
 	// True if user asked us to compact this file.
 	MarkedForCompaction bool
+	// True if the file contains range keys. Range keys are stored in a
+	// separate block of the table, which is only read if this is set.
+	HasRangeKeys bool
+	// External is the path of the table within the shared filesystem if the
+	// table was ingested by reference, in which case it does not reside in the
+	// DB directory and is never deleted by the DB.
+	External string
+	// BackingFileNum is the file number of the table holding the data of a
+	// virtual table, or zero for a physical table. A virtual table exposes the
+	// keys of its backing table which lie within its bounds, and is created
+	// by recording narrower bounds for an existing table rather than by
+	// rewriting it (see DB.IngestAndExcise). The backing table is shared by
+	// the virtual tables created from it, and is deleted once none of them
+	// remain. The bounds of a virtual table are either the bounds of its
+	// backing table, or an inclusive smallest user key and an exclusive
+	// largest user key (a range deletion sentinel).
+	BackingFileNum base.FileNum
 	// True if the file is actively being compacted. Protected by DB.mu.
 	Compacting bool
 	// Stats describe table statistics. Protected by DB.mu.
@@ -97,6 +124,20 @@ func (m FileMetadata) String() string {
 	return fmt.Sprintf("%s:%s-%s", m.FileNum, m.Smallest, m.Largest)
 }
 
+// Virtual returns true if the table is a virtual table (see BackingFileNum).
+func (m *FileMetadata) Virtual() bool {
+	return m.BackingFileNum != 0
+}
+
+// PhysicalFileNum returns the file number of the table holding the data of
+// the table: the backing table of a virtual table, or the table itself.
+func (m *FileMetadata) PhysicalFileNum() base.FileNum {
+	if m.BackingFileNum != 0 {
+		return m.BackingFileNum
+	}
+	return m.FileNum
+}
+
 // TableInfo returns a subset of the FileMetadata state formatted as a
 // TableInfo.
 func (m *FileMetadata) TableInfo() TableInfo {
@@ -107,6 +148,7 @@ func (m *FileMetadata) TableInfo() TableInfo {
 		Largest:        m.Largest,
 		SmallestSeqNum: m.SmallestSeqNum,
 		LargestSeqNum:  m.LargestSeqNum,
+		BackingFileNum: m.BackingFileNum,
 	}
 }
 
@@ -463,6 +505,49 @@ func (v *Version) Overlaps(level int, cmp Compare, start, end []byte) (ret []*Fi
 	return files[lower:upper]
 }
 
+// AtomicUnit returns the range [start, end) of indexes within files of the
+// atomic compaction unit containing files[index]. The files must be those of
+// a level other than L0, sorted by key.
+//
+// An atomic compaction unit is a maximal run of adjacent files in which the
+// largest user key of each file is equal to the smallest user key of the
+// next, so that the versions of a user key, or the range tombstones covering
+// it, are split across the files. The files of a unit must always be
+// compacted together: compacting a subset of the unit would allow an older
+// version of a key to be placed in a higher level than a newer version, and
+// would cause the range tombstones of the remaining files to be truncated to
+// the wrong bounds when they are next compacted.
+//
+// Files whose boundary is the range deletion sentinel of the left file are
+// not linked, as the sentinel's user key does not exist in the left file.
+func AtomicUnit(cmp Compare, files []*FileMetadata, index int) (start, end int) {
+	start, end = index, index+1
+	for ; start > 0; start-- {
+		if !linkedFiles(cmp, files[start-1], files[start]) {
+			break
+		}
+	}
+	for ; end < len(files); end++ {
+		if !linkedFiles(cmp, files[end-1], files[end]) {
+			break
+		}
+	}
+	return start, end
+}
+
+// linkedFiles returns true if the adjacent files a and b belong to the same
+// atomic compaction unit.
+func linkedFiles(cmp Compare, a, b *FileMetadata) bool {
+	if cmp(a.Largest.UserKey, b.Smallest.UserKey) < 0 {
+		return false
+	}
+	// The range deletion sentinel key is set for the largest key in a table
+	// when a range deletion tombstone straddles a table. It isn't necessary to
+	// include the next table in the atomic compaction unit as
+	// a.Largest.UserKey does not actually exist in a.
+	return a.Largest.Trailer != base.InternalKeyRangeDeleteSentinel
+}
+
 // CheckOrdering checks that the files are consistent with respect to
 // increasing file numbers (for level 0 files) and increasing and non-
 // overlapping internal key ranges (for level non-0 files).
@@ -482,21 +567,34 @@ func (v *Version) CheckOrdering(cmp Compare, format base.FormatKey) error {
 }
 
 // CheckConsistency checks that all of the files listed in the version exist
-// and their on-disk sizes match the sizes listed in the version.
-func (v *Version) CheckConsistency(dirname string, fs vfs.FS) error {
+// and their on-disk sizes match the sizes listed in the version. External
+// files are checked within sharedFS, which may be nil if there are none. The
+// backing tables of virtual tables are only checked for existence, as the size
+// of a virtual table is an estimate of the size of its portion of the backing
+// table.
+func (v *Version) CheckConsistency(dirname string, fs, sharedFS vfs.FS) error {
 	var buf bytes.Buffer
 	var args []interface{}
 
 	for level, files := range v.Levels {
 		for _, f := range files {
-			path := base.MakeFilename(fs, dirname, base.FileTypeTable, f.FileNum)
-			info, err := fs.Stat(path)
+			path := base.MakeFilename(fs, dirname, base.FileTypeTable, f.PhysicalFileNum())
+			statFS := fs
+			if f.External != "" {
+				if sharedFS == nil {
+					buf.WriteString("L%d: %s: external file %s requires a shared filesystem\n")
+					args = append(args, errors.Safe(level), errors.Safe(f.FileNum), f.External)
+					continue
+				}
+				path, statFS = f.External, sharedFS
+			}
+			info, err := statFS.Stat(path)
 			if err != nil {
 				buf.WriteString("L%d: %s: %v\n")
 				args = append(args, errors.Safe(level), errors.Safe(f.FileNum), err)
 				continue
 			}
-			if info.Size() != int64(f.Size) {
+			if !f.Virtual() && info.Size() != int64(f.Size) {
 				buf.WriteString("L%d: %s: file size mismatch (%s): %d (disk) != %d (MANIFEST)\n")
 				args = append(args, errors.Safe(level), errors.Safe(f.FileNum), path,
 					errors.Safe(info.Size()), errors.Safe(f.Size))
@@ -41,19 +41,33 @@ const (
 	tagPrevLogNumber  = 9
 
 	// RocksDB tags.
-	tagNewFile2         = 100
-	tagNewFile3         = 102
-	tagNewFile4         = 103
-	tagColumnFamily     = 200
-	tagColumnFamilyAdd  = 201
-	tagColumnFamilyDrop = 202
-	tagMaxColumnFamily  = 203
+	tagMinLogNumberToKeep = 10
+	tagNewFile2           = 100
+	tagNewFile3           = 102
+	tagNewFile4           = 103
+	tagColumnFamily       = 200
+	tagColumnFamilyAdd    = 201
+	tagColumnFamilyDrop   = 202
+	tagMaxColumnFamily    = 203
+	tagInAtomicGroup      = 300
+
+	// Pebble tags. Note that these tags do not have tagSafeIgnoreMask set, so
+	// that a MANIFEST containing them cannot be read by versions of Pebble (or
+	// RocksDB) which do not understand them.
+	tagFormatMajorVersion = 1000
+
+	// RocksDB tags with this bit set are followed by a length-prefixed
+	// payload, and may be skipped by readers which don't understand them.
+	tagSafeIgnoreMask = 1 << 13
 
 	// The custom tags sub-format used by tagNewFile4.
 	customTagTerminate         = 1
 	customTagNeedsCompaction   = 2
 	customTagCreationTime      = 6
 	customTagPathID            = 65
+	customTagHasRangeKeys      = 66
+	customTagExternal          = 67
+	customTagBacking           = 68
 	customTagNonSafeIgnoreMask = 1 << 6
 )
 
@@ -101,6 +115,15 @@ type VersionEdit struct {
 	// recovery) may contain sequence numbers greater than this value.
 	LastSeqNum uint64
 
+	// FormatMajorVersion is the format major version of the DB, which is set
+	// when the format major version is ratcheted, and in the first VersionEdit
+	// in a manifest. Only format major versions newer than the original format
+	// are recorded, so that older versions of Pebble can read the MANIFEST of a
+	// DB which has not been upgraded.
+	//
+	// This is an optional field, and 0 represents it is not set.
+	FormatMajorVersion uint64
+
 	// A file num may be present in both deleted files and new files when it
 	// is moved from a lower level to a higher level (when the compaction
 	// found that there was no overlapping file at the higher level).
@@ -215,8 +238,10 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					return err
 				}
 			}
-			var markedForCompaction bool
+			var markedForCompaction, hasRangeKeys bool
 			var creationTime uint64
+			var external string
+			var backingFileNum uint64
 			if tag == tagNewFile4 {
 				for {
 					customTag, err := d.readUvarint()
@@ -247,6 +272,25 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					case customTagPathID:
 						return errors.New("new-file4: path-id field not supported")
 
+					case customTagHasRangeKeys:
+						if len(field) != 1 {
+							return errors.New("new-file4: has-range-keys field wrong size")
+						}
+						hasRangeKeys = (field[0] == 1)
+
+					case customTagExternal:
+						if len(field) == 0 {
+							return errors.New("new-file4: empty external path")
+						}
+						external = string(field)
+
+					case customTagBacking:
+						var n int
+						backingFileNum, n = binary.Uvarint(field)
+						if n != len(field) || backingFileNum == 0 {
+							return errors.New("new-file4: invalid backing file number")
+						}
+
 					default:
 						if (customTag & customTagNonSafeIgnoreMask) != 0 {
 							return errors.Errorf("new-file4: custom field not supported: %d", customTag)
@@ -265,6 +309,9 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					SmallestSeqNum:      smallestSeqNum,
 					LargestSeqNum:       largestSeqNum,
 					MarkedForCompaction: markedForCompaction,
+					HasRangeKeys:        hasRangeKeys,
+					External:            external,
+					BackingFileNum:      base.FileNum(backingFileNum),
 				},
 			})
 
@@ -275,11 +322,29 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			v.ObsoletePrevLogNum = n
 
+		case tagFormatMajorVersion:
+			n, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			v.FormatMajorVersion = n
+
 		case tagColumnFamily, tagColumnFamilyAdd, tagColumnFamilyDrop, tagMaxColumnFamily:
 			return errors.New("column families are not supported")
 
+		case tagMinLogNumberToKeep:
+			return errors.New("two-phase commit is not supported")
+
+		case tagInAtomicGroup:
+			return errors.New("atomic groups are not supported")
+
 		default:
-			return errCorruptManifest
+			if tag&tagSafeIgnoreMask == 0 {
+				return errCorruptManifest
+			}
+			if _, err := d.readBytes(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -312,6 +377,10 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(tagLastSequence)
 		e.writeUvarint(v.LastSeqNum)
 	}
+	if v.FormatMajorVersion != 0 {
+		e.writeUvarint(tagFormatMajorVersion)
+		e.writeUvarint(v.FormatMajorVersion)
+	}
 	for x := range v.DeletedFiles {
 		e.writeUvarint(tagDeletedFile)
 		e.writeUvarint(uint64(x.Level))
@@ -319,7 +388,8 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 	}
 	for _, x := range v.NewFiles {
 		var customFields bool
-		if x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 {
+		if x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.HasRangeKeys ||
+			x.Meta.External != "" || x.Meta.BackingFileNum != 0 {
 			customFields = true
 			e.writeUvarint(tagNewFile4)
 		} else {
@@ -343,6 +413,20 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 				e.writeUvarint(customTagNeedsCompaction)
 				e.writeBytes([]byte{1})
 			}
+			if x.Meta.HasRangeKeys {
+				e.writeUvarint(customTagHasRangeKeys)
+				e.writeBytes([]byte{1})
+			}
+			if x.Meta.External != "" {
+				e.writeUvarint(customTagExternal)
+				e.writeBytes([]byte(x.Meta.External))
+			}
+			if x.Meta.BackingFileNum != 0 {
+				e.writeUvarint(customTagBacking)
+				var buf [binary.MaxVarintLen64]byte
+				n := binary.PutUvarint(buf[:], uint64(x.Meta.BackingFileNum))
+				e.writeBytes(buf[:n])
+			}
 			e.writeUvarint(customTagTerminate)
 		}
 	}
@@ -50,6 +50,7 @@ func TestVersionEditRoundTrip(t *testing.T) {
 			ObsoletePrevLogNum: 33,
 			NextFileNum:        44,
 			LastSeqNum:         55,
+			FormatMajorVersion: 66,
 			DeletedFiles: map[DeletedFileEntry]bool{
 				DeletedFileEntry{
 					Level:   3,
@@ -84,6 +85,42 @@ func TestVersionEditRoundTrip(t *testing.T) {
 						MarkedForCompaction: true,
 					},
 				},
+				{
+					Level: 6,
+					Meta: &FileMetadata{
+						FileNum:        807,
+						Size:           8070,
+						Smallest:       base.DecodeInternalKey([]byte("a\x00\x01\x02\x03\x04\x05\x06\x07")),
+						Largest:        base.DecodeInternalKey([]byte("z\x01\xff\xfe\xfd\xfc\xfb\xfa\xf9")),
+						SmallestSeqNum: 6,
+						LargestSeqNum:  7,
+						HasRangeKeys:   true,
+					},
+				},
+				{
+					Level: 6,
+					Meta: &FileMetadata{
+						FileNum:        808,
+						Size:           8080,
+						Smallest:       base.DecodeInternalKey([]byte("a\x00\x01\x02\x03\x04\x05\x06\x07")),
+						Largest:        base.DecodeInternalKey([]byte("z\x01\xff\xfe\xfd\xfc\xfb\xfa\xf9")),
+						SmallestSeqNum: 8,
+						LargestSeqNum:  8,
+						External:       "shared/000001.sst",
+					},
+				},
+				{
+					Level: 6,
+					Meta: &FileMetadata{
+						FileNum:        809,
+						Size:           4040,
+						Smallest:       base.DecodeInternalKey([]byte("a\x00\x01\x02\x03\x04\x05\x06\x07")),
+						Largest:        base.MakeRangeDeleteSentinelKey([]byte("m")),
+						SmallestSeqNum: 8,
+						LargestSeqNum:  8,
+						BackingFileNum: 707,
+					},
+				},
 			},
 		},
 	}
@@ -200,6 +237,31 @@ func TestVersionEditDecode(t *testing.T) {
 	}
 }
 
+func TestVersionEditDecodeRocksDBTags(t *testing.T) {
+	// Tags with the safe-ignore bit set, such as RocksDB's DB ID (tag 8193),
+	// are skipped.
+	var edit VersionEdit
+	require.NoError(t, edit.Decode(strings.NewReader("\x81\x40\x03abc\x04\x05")))
+	require.Equal(t, VersionEdit{LastSeqNum: 5}, edit)
+
+	// Features of RocksDB which pebble does not support are reported.
+	testCases := []struct {
+		encoded string
+		err     string
+	}{
+		{"\xc8\x01\x00", "column families are not supported"},
+		{"\x0a\x05", "two-phase commit is not supported"},
+		{"\xac\x02\x01", "atomic groups are not supported"},
+		{"\x80\x20\x00", errCorruptManifest.Error()},
+	}
+	for _, c := range testCases {
+		t.Run("", func(t *testing.T) {
+			var edit VersionEdit
+			require.EqualError(t, edit.Decode(strings.NewReader(c.encoded)), c.err)
+		})
+	}
+}
+
 func TestVersionEditEncodeLastSeqNum(t *testing.T) {
 	testCases := []struct {
 		edit    VersionEdit
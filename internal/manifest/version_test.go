@@ -473,6 +473,37 @@ func TestCheckOrdering(t *testing.T) {
 		})
 }
 
+func TestAtomicUnit(t *testing.T) {
+	datadriven.RunTest(t, "testdata/atomic_unit",
+		func(d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "atomic-unit":
+				var files []*FileMetadata
+				for _, data := range strings.Split(d.Input, "\n") {
+					parts := strings.Split(data, "-")
+					if len(parts) != 2 {
+						return fmt.Sprintf("malformed table spec: %s", data)
+					}
+					files = append(files, &FileMetadata{
+						FileNum:  base.FileNum(len(files) + 1),
+						Smallest: base.ParseInternalKey(strings.TrimSpace(parts[0])),
+						Largest:  base.ParseInternalKey(strings.TrimSpace(parts[1])),
+					})
+				}
+
+				var buf strings.Builder
+				for i, f := range files {
+					start, end := AtomicUnit(base.DefaultComparer.Compare, files, i)
+					fmt.Fprintf(&buf, "%s: %s-%s\n", f.FileNum, files[start].FileNum, files[end-1].FileNum)
+				}
+				return buf.String()
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		})
+}
+
 func TestCheckConsistency(t *testing.T) {
 	const dir = "./test"
 	mem := vfs.NewMem()
@@ -537,7 +568,7 @@ func TestCheckConsistency(t *testing.T) {
 					}
 				}
 
-				err := v.CheckConsistency(dir, mem)
+				err := v.CheckConsistency(dir, mem, nil)
 				if err != nil {
 					if redact {
 						redacted := errors.Redact(err)
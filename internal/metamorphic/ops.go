@@ -105,7 +105,7 @@ type compactOp struct {
 
 func (o *compactOp) run(t *test, h *history) {
 	err := withRetries(func() error {
-		return t.db.Compact(o.start, o.end)
+		return t.db.Compact(o.start, o.end, false /* parallelize */)
 	})
 	h.Recordf("%s // %v", o, err)
 }
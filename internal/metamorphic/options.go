@@ -165,6 +165,10 @@ func standardOptions() []*testOptions {
 		19: `
 [TestOptions]
   ingest_using_apply=true
+`,
+		20: `
+[Options]
+  deterministic=true
 `,
 	}
 
@@ -187,6 +191,7 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	opts.Experimental.FlushSplitBytes = 1 << rng.Intn(20)       // 1B - 1MB
 	opts.Experimental.L0CompactionConcurrency = 1 + rng.Intn(4) // 1-4
 	opts.Experimental.L0SublevelCompactions = rng.Intn(2) == 0
+	opts.Experimental.StrictMaxOpenFiles = rng.Intn(2) == 0
 	opts.L0CompactionThreshold = 1 + rng.Intn(100) // 1 - 100
 	opts.L0StopWritesThreshold = 1 + rng.Intn(100) // 1 - 100
 	if opts.L0StopWritesThreshold < opts.L0CompactionThreshold {
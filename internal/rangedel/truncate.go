@@ -7,7 +7,8 @@ package rangedel
 import "github.com/cockroachdb/pebble/internal/base"
 
 // Truncate creates a new iterator where every tombstone in the supplied
-// iterator is truncated to be contained within the range [lower, upper).
+// iterator is truncated to be contained within the range [lower, upper). A
+// nil lower or upper bound leaves the corresponding side unbounded.
 func Truncate(cmp base.Compare, iter base.InternalIterator, lower, upper []byte) *Iter {
 	var tombstones []Tombstone
 	for key, value := iter.First(); key != nil; key, value = iter.Next() {
@@ -15,10 +16,10 @@ func Truncate(cmp base.Compare, iter base.InternalIterator, lower, upper []byte)
 			Start: *key,
 			End:   value,
 		}
-		if cmp(t.Start.UserKey, lower) < 0 {
+		if lower != nil && cmp(t.Start.UserKey, lower) < 0 {
 			t.Start.UserKey = lower
 		}
-		if cmp(t.End, upper) > 0 {
+		if upper != nil && cmp(t.End, upper) > 0 {
 			t.End = upper
 		}
 		if cmp(t.Start.UserKey, t.End) < 0 {
@@ -0,0 +1,159 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package rangekey
+
+import "github.com/cockroachdb/pebble/internal/base"
+
+// Iter is an iterator over a set of range keys ordered by start key. The
+// values returned by the iterator are encoded as for a range key stored in a
+// batch, memtable or sstable (see EncodeValue).
+type Iter struct {
+	cmp    base.Compare
+	keys   []Key
+	values [][]byte
+	index  int
+}
+
+// Iter implements the base.InternalIterator interface.
+var _ base.InternalIterator = (*Iter)(nil)
+
+// NewIter returns a new iterator over a set of range keys, which must be
+// ordered by start key.
+func NewIter(cmp base.Compare, keys []Key) *Iter {
+	values := make([][]byte, len(keys))
+	for j := range keys {
+		values[j] = keys[j].EncodedValue()
+	}
+	return &Iter{
+		cmp:    cmp,
+		keys:   keys,
+		values: values,
+		index:  -1,
+	}
+}
+
+func (i *Iter) current() (*base.InternalKey, []byte) {
+	if i.index < 0 || i.index >= len(i.keys) {
+		return nil, nil
+	}
+	return &i.keys[i.index].Start, i.values[i.index]
+}
+
+// SeekGE implements InternalIterator.SeekGE, as documented in the
+// internal/base package.
+func (i *Iter) SeekGE(key []byte) (*base.InternalKey, []byte) {
+	ikey := base.MakeSearchKey(key)
+	i.index = 0
+	upper := len(i.keys)
+	for i.index < upper {
+		h := int(uint(i.index+upper) >> 1) // avoid overflow when computing h
+		if base.InternalCompare(i.cmp, ikey, i.keys[h].Start) >= 0 {
+			i.index = h + 1
+		} else {
+			upper = h
+		}
+	}
+	return i.current()
+}
+
+// SeekPrefixGE implements InternalIterator.SeekPrefixGE, as documented in the
+// internal/base package.
+func (i *Iter) SeekPrefixGE(prefix, key []byte) (*base.InternalKey, []byte) {
+	// This should never be called as prefix iteration is only done for point records.
+	panic("pebble: SeekPrefixGE unimplemented")
+}
+
+// SeekLT implements InternalIterator.SeekLT, as documented in the
+// internal/base package.
+func (i *Iter) SeekLT(key []byte) (*base.InternalKey, []byte) {
+	ikey := base.MakeSearchKey(key)
+	i.index = 0
+	upper := len(i.keys)
+	for i.index < upper {
+		h := int(uint(i.index+upper) >> 1) // avoid overflow when computing h
+		if base.InternalCompare(i.cmp, ikey, i.keys[h].Start) > 0 {
+			i.index = h + 1
+		} else {
+			upper = h
+		}
+	}
+	i.index--
+	return i.current()
+}
+
+// First implements InternalIterator.First, as documented in the internal/base
+// package.
+func (i *Iter) First() (*base.InternalKey, []byte) {
+	i.index = 0
+	return i.current()
+}
+
+// Last implements InternalIterator.Last, as documented in the internal/base
+// package.
+func (i *Iter) Last() (*base.InternalKey, []byte) {
+	i.index = len(i.keys) - 1
+	return i.current()
+}
+
+// Next implements InternalIterator.Next, as documented in the internal/base
+// package.
+func (i *Iter) Next() (*base.InternalKey, []byte) {
+	if i.index == len(i.keys) {
+		return nil, nil
+	}
+	i.index++
+	return i.current()
+}
+
+// Prev implements InternalIterator.Prev, as documented in the internal/base
+// package.
+func (i *Iter) Prev() (*base.InternalKey, []byte) {
+	if i.index < 0 {
+		return nil, nil
+	}
+	i.index--
+	return i.current()
+}
+
+// Key implements InternalIterator.Key, as documented in the internal/base
+// package.
+func (i *Iter) Key() *base.InternalKey {
+	return &i.keys[i.index].Start
+}
+
+// Value implements InternalIterator.Value, as documented in the internal/base
+// package.
+func (i *Iter) Value() []byte {
+	return i.values[i.index]
+}
+
+// Valid implements InternalIterator.Valid, as documented in the internal/base
+// package.
+func (i *Iter) Valid() bool {
+	return i.index >= 0 && i.index < len(i.keys)
+}
+
+// Error implements InternalIterator.Error, as documented in the internal/base
+// package.
+func (i *Iter) Error() error {
+	return nil
+}
+
+// Close implements InternalIterator.Close, as documented in the internal/base
+// package.
+func (i *Iter) Close() error {
+	return nil
+}
+
+// SetBounds implements InternalIterator.SetBounds, as documented in the
+// internal/base package.
+func (i *Iter) SetBounds(lower, upper []byte) {
+	// This should never be called as bounds are only used for point records.
+	panic("pebble: SetBounds unimplemented")
+}
+
+func (i *Iter) String() string {
+	return "range-key"
+}
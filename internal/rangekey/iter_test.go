@@ -0,0 +1,59 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package rangekey
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIter(t *testing.T) {
+	keys := parseKeys(`
+a-c#3,RANGEKEYSET @1=foo
+c-e#5,RANGEKEYUNSET @2
+c-e#4,RANGEKEYSET @2=bar
+g-h#1,RANGEKEYDEL
+`)
+	iter := NewIter(base.DefaultComparer.Compare, keys)
+	defer iter.Close()
+
+	str := func(key *base.InternalKey, value []byte) string {
+		if key == nil {
+			require.False(t, iter.Valid())
+			return "."
+		}
+		require.True(t, iter.Valid())
+		require.Equal(t, key, iter.Key())
+		require.Equal(t, value, iter.Value())
+		k, err := Decode(*key, value)
+		require.NoError(t, err)
+		return k.String()
+	}
+
+	require.Equal(t, "a-c#3,RANGEKEYSET @1=foo", str(iter.First()))
+	require.Equal(t, "c-e#5,RANGEKEYUNSET @2", str(iter.Next()))
+	require.Equal(t, "c-e#4,RANGEKEYSET @2=bar", str(iter.Next()))
+	require.Equal(t, "g-h#1,RANGEKEYDEL", str(iter.Next()))
+	require.Equal(t, ".", str(iter.Next()))
+	require.Equal(t, "g-h#1,RANGEKEYDEL", str(iter.Prev()))
+	require.Equal(t, "g-h#1,RANGEKEYDEL", str(iter.Last()))
+	require.Equal(t, "c-e#4,RANGEKEYSET @2=bar", str(iter.Prev()))
+
+	require.Equal(t, "c-e#5,RANGEKEYUNSET @2", str(iter.SeekGE([]byte("b"))))
+	require.Equal(t, "c-e#5,RANGEKEYUNSET @2", str(iter.SeekGE([]byte("c"))))
+	require.Equal(t, ".", str(iter.SeekGE([]byte("h"))))
+	require.Equal(t, "c-e#4,RANGEKEYSET @2=bar", str(iter.SeekLT([]byte("g"))))
+	require.Equal(t, "a-c#3,RANGEKEYSET @1=foo", str(iter.SeekLT([]byte("c"))))
+	require.Equal(t, ".", str(iter.SeekLT([]byte("a"))))
+	require.Equal(t, "a-c#3,RANGEKEYSET @1=foo", str(iter.Next()))
+
+	empty := NewIter(base.DefaultComparer.Compare, nil)
+	key, _ := empty.First()
+	require.Nil(t, key)
+	key, _ = empty.Last()
+	require.Nil(t, key)
+}
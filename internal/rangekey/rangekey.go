@@ -0,0 +1,185 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package rangekey provides facilities for range keys. A range key associates
+// a suffix and a value with the span of user keys [start,end). A range key is
+// set for a suffix with RANGEKEYSET, removed for a suffix with RANGEKEYUNSET,
+// and removed for all suffixes with RANGEKEYDEL. Unlike range deletion
+// tombstones, range keys do not affect the point keys they overlap.
+//
+// A range key is stored as an internal key/value pair. The internal key is
+// the start key of the range, and the value encodes the end key, the suffix
+// and the value (see EncodeValue).
+package rangekey // import "github.com/cockroachdb/pebble/internal/rangekey"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// Key is a range key.
+type Key struct {
+	// Start is the inclusive start key of the range. Its sequence number and
+	// kind are those of the range key.
+	Start base.InternalKey
+	// End is the exclusive end key of the range.
+	End []byte
+	// Suffix is the suffix of a RANGEKEYSET or RANGEKEYUNSET.
+	Suffix []byte
+	// Value is the value of a RANGEKEYSET.
+	Value []byte
+}
+
+// IsRangeKey returns true if kind is one of the range key kinds.
+func IsRangeKey(kind base.InternalKeyKind) bool {
+	switch kind {
+	case base.InternalKeyKindRangeKeySet,
+		base.InternalKeyKindRangeKeyUnset,
+		base.InternalKeyKindRangeKeyDelete:
+		return true
+	}
+	return false
+}
+
+// Kind returns the kind of the range key.
+func (k Key) Kind() base.InternalKeyKind {
+	return k.Start.Kind()
+}
+
+// SeqNum returns the sequence number of the range key.
+func (k Key) SeqNum() uint64 {
+	return k.Start.SeqNum()
+}
+
+// Clone returns a copy of the range key which does not share any storage
+// with the receiver.
+func (k Key) Clone() Key {
+	buf := make([]byte, 0, len(k.Start.UserKey)+len(k.End)+len(k.Suffix)+len(k.Value))
+	clone := func(b []byte) []byte {
+		if b == nil {
+			return nil
+		}
+		buf = append(buf, b...)
+		return buf[len(buf)-len(b) : len(buf) : len(buf)]
+	}
+	return Key{
+		Start:  base.InternalKey{UserKey: clone(k.Start.UserKey), Trailer: k.Start.Trailer},
+		End:    clone(k.End),
+		Suffix: clone(k.Suffix),
+		Value:  clone(k.Value),
+	}
+}
+
+// EncodedValue returns the value of the internal key/value pair storing the
+// range key. See EncodeValue.
+func (k Key) EncodedValue() []byte {
+	return EncodeValue(k.End, k.Suffix, k.Value)
+}
+
+func (k Key) String() string {
+	switch k.Kind() {
+	case base.InternalKeyKindRangeKeySet:
+		return fmt.Sprintf("%s-%s#%d,%s %s=%s",
+			k.Start.UserKey, k.End, k.SeqNum(), k.Kind(), k.Suffix, k.Value)
+	case base.InternalKeyKindRangeKeyUnset:
+		return fmt.Sprintf("%s-%s#%d,%s %s",
+			k.Start.UserKey, k.End, k.SeqNum(), k.Kind(), k.Suffix)
+	default:
+		return fmt.Sprintf("%s-%s#%d,%s", k.Start.UserKey, k.End, k.SeqNum(), k.Kind())
+	}
+}
+
+// EncodeValue encodes the value of the internal key/value pair storing a
+// range key: the end key and the suffix, each prefixed by their varint
+// encoded length, followed by the value. The suffix and value are empty for
+// a RANGEKEYDEL, and the value is empty for a RANGEKEYUNSET.
+func EncodeValue(end, suffix, value []byte) []byte {
+	buf := make([]byte, 0, 2*binary.MaxVarintLen32+len(end)+len(suffix)+len(value))
+	buf = appendBytes(buf, end)
+	buf = appendBytes(buf, suffix)
+	return append(buf, value...)
+}
+
+func appendBytes(buf, b []byte) []byte {
+	var tmp [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(tmp[:], uint64(len(b)))
+	buf = append(buf, tmp[:n]...)
+	return append(buf, b...)
+}
+
+// Decode decodes the range key stored in the specified internal key/value
+// pair. The returned key shares storage with the arguments.
+func Decode(key base.InternalKey, value []byte) (Key, error) {
+	if !IsRangeKey(key.Kind()) {
+		return Key{}, errors.Errorf("pebble: invalid range key kind: %s", key.Kind())
+	}
+	k := Key{Start: key}
+	var ok bool
+	if k.End, value, ok = decodeBytes(value); !ok {
+		return Key{}, errors.New("pebble: corrupt range key: unable to decode end key")
+	}
+	if k.Suffix, value, ok = decodeBytes(value); !ok {
+		return Key{}, errors.New("pebble: corrupt range key: unable to decode suffix")
+	}
+	if len(value) > 0 {
+		k.Value = value
+	}
+	if len(k.Suffix) == 0 {
+		k.Suffix = nil
+	}
+	return k, nil
+}
+
+func decodeBytes(buf []byte) (b, rest []byte, ok bool) {
+	n, m := binary.Uvarint(buf)
+	if m <= 0 || n > uint64(len(buf)-m) {
+		return nil, nil, false
+	}
+	return buf[m : m+int(n)], buf[m+int(n):], true
+}
+
+// ParseKey parses the string representation of a range key, as produced by
+// Key.String: <start>-<end>#<seqnum>,<kind> followed by <suffix>=<value> for
+// a RANGEKEYSET or <suffix> for a RANGEKEYUNSET. Intended for use in tests.
+func ParseKey(s string) Key {
+	fields := strings.Fields(s)
+	var k Key
+	i := strings.IndexByte(fields[0], '-')
+	j := strings.IndexByte(fields[0], '#')
+	l := strings.IndexByte(fields[0], ',')
+	if i < 0 || j < i || l < j {
+		panic(fmt.Sprintf("invalid range key: %q", s))
+	}
+	seqNum, err := strconv.ParseUint(fields[0][j+1:l], 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid range key: %q: %v", s, err))
+	}
+	var kind base.InternalKeyKind
+	switch fields[0][l+1:] {
+	case "RANGEKEYSET":
+		kind = base.InternalKeyKindRangeKeySet
+	case "RANGEKEYUNSET":
+		kind = base.InternalKeyKindRangeKeyUnset
+	case "RANGEKEYDEL":
+		kind = base.InternalKeyKindRangeKeyDelete
+	default:
+		panic(fmt.Sprintf("invalid range key kind: %q", s))
+	}
+	k.Start = base.MakeInternalKey([]byte(fields[0][:i]), seqNum, kind)
+	k.End = []byte(fields[0][i+1 : j])
+	if len(fields) > 1 {
+		suffix := fields[1]
+		if m := strings.IndexByte(suffix, '='); m >= 0 {
+			k.Value = []byte(suffix[m+1:])
+			suffix = suffix[:m]
+		}
+		k.Suffix = []byte(suffix)
+	}
+	return k
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package rangekey
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	for _, s := range []string{
+		"a-c#5,RANGEKEYSET @3=foo",
+		"a-c#5,RANGEKEYSET @3=",
+		"a-c#5,RANGEKEYUNSET @3",
+		"a-c#5,RANGEKEYDEL",
+	} {
+		k := ParseKey(s)
+		require.Equal(t, s, k.String())
+		decoded, err := Decode(k.Start, k.EncodedValue())
+		require.NoError(t, err)
+		require.Equal(t, s, decoded.String())
+		require.Equal(t, s, decoded.Clone().String())
+	}
+
+	_, err := Decode(base.MakeInternalKey([]byte("a"), 1, base.InternalKeyKindRangeKeySet), []byte{5, 'b'})
+	require.Error(t, err)
+	_, err = Decode(base.MakeInternalKey([]byte("a"), 1, base.InternalKeyKindSet), EncodeValue([]byte("b"), nil, nil))
+	require.Error(t, err)
+}
+
+func parseKeys(input string) []Key {
+	var keys []Key
+	for _, line := range strings.Split(input, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			keys = append(keys, ParseKey(line))
+		}
+	}
+	return keys
+}
+
+func TestResolve(t *testing.T) {
+	cmp := base.DefaultComparer.Compare
+	datadriven.RunTest(t, "testdata/resolve", func(d *datadriven.TestData) string {
+		switch d.Cmd {
+		case "resolve":
+			var lower, upper []byte
+			for _, arg := range d.CmdArgs {
+				switch arg.Key {
+				case "lower":
+					lower = []byte(arg.Vals[0])
+				case "upper":
+					upper = []byte(arg.Vals[0])
+				default:
+					return fmt.Sprintf("unknown arg: %s", arg.Key)
+				}
+			}
+			var buf bytes.Buffer
+			for _, s := range Resolve(cmp, parseKeys(d.Input), lower, upper) {
+				fmt.Fprintln(&buf, s)
+			}
+			return buf.String()
+
+		default:
+			return fmt.Sprintf("unknown command: %s", d.Cmd)
+		}
+	})
+}
+
+func TestCompact(t *testing.T) {
+	cmp := base.DefaultComparer.Compare
+	datadriven.RunTest(t, "testdata/compact", func(d *datadriven.TestData) string {
+		switch d.Cmd {
+		case "compact", "truncate":
+			var snapshots []uint64
+			var elide bool
+			var lower, upper []byte
+			for _, arg := range d.CmdArgs {
+				switch arg.Key {
+				case "snapshots":
+					for _, v := range arg.Vals {
+						s, err := strconv.ParseUint(v, 10, 64)
+						if err != nil {
+							return err.Error()
+						}
+						snapshots = append(snapshots, s)
+					}
+				case "elide":
+					elide = true
+				case "lower":
+					lower = []byte(arg.Vals[0])
+				case "upper":
+					upper = []byte(arg.Vals[0])
+				default:
+					return fmt.Sprintf("unknown arg: %s", arg.Key)
+				}
+			}
+			keys := parseKeys(d.Input)
+			if d.Cmd == "compact" {
+				keys = Compact(cmp, keys, snapshots, func(start, end []byte) bool {
+					return elide
+				})
+			} else {
+				keys = Truncate(cmp, keys, lower, upper)
+			}
+			var buf bytes.Buffer
+			for _, k := range keys {
+				fmt.Fprintln(&buf, k)
+			}
+			return buf.String()
+
+		default:
+			return fmt.Sprintf("unknown command: %s", d.Cmd)
+		}
+	})
+}
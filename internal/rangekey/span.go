@@ -0,0 +1,255 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package rangekey
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// SuffixValue is the suffix and value of a range key which is set over a
+// span.
+type SuffixValue struct {
+	Suffix []byte
+	Value  []byte
+}
+
+// Span is a span of user keys [Start,End) over which the same set of range
+// keys is set.
+type Span struct {
+	Start []byte
+	End   []byte
+	// Keys holds the suffixes and values of the range keys set over the span,
+	// ordered by suffix.
+	Keys []SuffixValue
+}
+
+func (s Span) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s-%s:", s.Start, s.End)
+	for _, k := range s.Keys {
+		fmt.Fprintf(&buf, " %s=%s", k.Suffix, k.Value)
+	}
+	return buf.String()
+}
+
+// fragment splits the range keys at the start and end keys of every other
+// range key, and calls fn for each resulting fragment [start,end) with the
+// range keys overlapping the fragment, ordered from newest to oldest.
+func fragment(cmp base.Compare, keys []Key, fn func(start, end []byte, keys []Key)) {
+	bounds := make([][]byte, 0, 2*len(keys))
+	sorted := make([]Key, 0, len(keys))
+	for _, k := range keys {
+		if cmp(k.Start.UserKey, k.End) >= 0 {
+			continue
+		}
+		bounds = append(bounds, k.Start.UserKey, k.End)
+		sorted = append(sorted, k)
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return cmp(bounds[i], bounds[j]) < 0
+	})
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return cmp(sorted[i].Start.UserKey, sorted[j].Start.UserKey) < 0
+	})
+
+	var active, frag []Key
+	for i, j := 0, 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if cmp(start, end) == 0 {
+			continue
+		}
+		n := 0
+		for _, k := range active {
+			if cmp(k.End, start) > 0 {
+				active[n] = k
+				n++
+			}
+		}
+		active = active[:n]
+		for ; j < len(sorted) && cmp(sorted[j].Start.UserKey, start) <= 0; j++ {
+			active = append(active, sorted[j])
+		}
+		if len(active) == 0 {
+			continue
+		}
+		frag = append(frag[:0], active...)
+		sort.SliceStable(frag, func(i, j int) bool {
+			return frag[i].Start.Trailer > frag[j].Start.Trailer
+		})
+		fn(start, end, frag)
+	}
+}
+
+// Resolve returns the spans over which range keys are set, given the range
+// keys visible to a reader. Within each fragment, a range key is shadowed by
+// a newer range key with the same suffix, and all range keys are shadowed by
+// a newer RANGEKEYDEL. Adjacent spans with identical keys are coalesced. The
+// spans are truncated to [lower,upper); a nil bound is unbounded.
+func Resolve(cmp base.Compare, keys []Key, lower, upper []byte) []Span {
+	var spans []Span
+	fragment(cmp, keys, func(start, end []byte, keys []Key) {
+		if lower != nil && cmp(end, lower) <= 0 {
+			return
+		}
+		if upper != nil && cmp(start, upper) >= 0 {
+			return
+		}
+		if lower != nil && cmp(start, lower) < 0 {
+			start = lower
+		}
+		if upper != nil && cmp(end, upper) > 0 {
+			end = upper
+		}
+
+		var set []SuffixValue
+		var unset [][]byte
+		seen := func(suffix []byte) bool {
+			for i := range set {
+				if bytes.Equal(set[i].Suffix, suffix) {
+					return true
+				}
+			}
+			for i := range unset {
+				if bytes.Equal(unset[i], suffix) {
+					return true
+				}
+			}
+			return false
+		}
+	loop:
+		for _, k := range keys {
+			switch k.Kind() {
+			case base.InternalKeyKindRangeKeyDelete:
+				break loop
+			case base.InternalKeyKindRangeKeyUnset:
+				if !seen(k.Suffix) {
+					unset = append(unset, k.Suffix)
+				}
+			case base.InternalKeyKindRangeKeySet:
+				if !seen(k.Suffix) {
+					set = append(set, SuffixValue{Suffix: k.Suffix, Value: k.Value})
+				}
+			}
+		}
+		if len(set) == 0 {
+			return
+		}
+		sort.Slice(set, func(i, j int) bool {
+			return cmp(set[i].Suffix, set[j].Suffix) < 0
+		})
+
+		if n := len(spans); n > 0 && cmp(spans[n-1].End, start) == 0 &&
+			equalKeys(spans[n-1].Keys, set) {
+			spans[n-1].End = end
+			return
+		}
+		spans = append(spans, Span{Start: start, End: end, Keys: set})
+	})
+	return spans
+}
+
+func equalKeys(a, b []SuffixValue) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Suffix, b[i].Suffix) || !bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compact fragments the range keys and drops those which can no longer be
+// observed by any reader: a range key is dropped if it is shadowed by a newer
+// range key in the same snapshot stripe, either with the same suffix or by a
+// RANGEKEYDEL. The snapshots must be sorted in increasing order. If elide
+// returns true for a fragment, there are no older keys beneath the compaction
+// which overlap it, and RANGEKEYUNSET and RANGEKEYDEL keys in the last
+// snapshot stripe are dropped as well. The returned keys are fragmented and
+// ordered by start key and then from newest to oldest, which is the order in
+// which they must be added to an sstable.
+func Compact(
+	cmp base.Compare, keys []Key, snapshots []uint64, elide func(start, end []byte) bool,
+) []Key {
+	var out []Key
+	fragment(cmp, keys, func(start, end []byte, keys []Key) {
+		elideFragment := elide != nil && elide(start, end)
+		stripe := -1
+		var deleted bool
+		var seen [][]byte
+		for _, k := range keys {
+			if s := snapshotIndex(k.SeqNum(), snapshots); s != stripe {
+				stripe, deleted, seen = s, false, seen[:0]
+			}
+			if deleted {
+				continue
+			}
+			switch k.Kind() {
+			case base.InternalKeyKindRangeKeyDelete:
+				deleted = true
+			case base.InternalKeyKindRangeKeySet, base.InternalKeyKindRangeKeyUnset:
+				var shadowed bool
+				for i := range seen {
+					if bytes.Equal(seen[i], k.Suffix) {
+						shadowed = true
+						break
+					}
+				}
+				if shadowed {
+					continue
+				}
+				seen = append(seen, k.Suffix)
+			}
+			if stripe == 0 && elideFragment && k.Kind() != base.InternalKeyKindRangeKeySet {
+				continue
+			}
+			out = append(out, Key{
+				Start:  base.InternalKey{UserKey: start, Trailer: k.Start.Trailer},
+				End:    end,
+				Suffix: k.Suffix,
+				Value:  k.Value,
+			})
+		}
+	})
+	return out
+}
+
+// snapshotIndex returns the index of the first snapshot sequence number
+// which is greater than seqNum. Keys with the same index are in the same
+// snapshot stripe.
+func snapshotIndex(seqNum uint64, snapshots []uint64) int {
+	return sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i] > seqNum
+	})
+}
+
+// Truncate returns the range keys truncated to [lower,upper). Range keys
+// which do not overlap the bounds are dropped. A nil bound is unbounded. The
+// order of the keys is preserved.
+func Truncate(cmp base.Compare, keys []Key, lower, upper []byte) []Key {
+	var out []Key
+	for _, k := range keys {
+		if lower != nil && cmp(k.End, lower) <= 0 {
+			continue
+		}
+		if upper != nil && cmp(k.Start.UserKey, upper) >= 0 {
+			continue
+		}
+		if lower != nil && cmp(k.Start.UserKey, lower) < 0 {
+			k.Start.UserKey = lower
+		}
+		if upper != nil && cmp(k.End, upper) > 0 {
+			k.End = upper
+		}
+		out = append(out, k)
+	}
+	return out
+}
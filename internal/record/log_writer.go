@@ -16,6 +16,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/internal/histogram"
 )
 
 var walSyncLabels = pprof.Labels("pebble", "wal-sync")
@@ -233,6 +234,20 @@ type syncTimer interface {
 	Stop() bool
 }
 
+// LogWriterMetrics holds metrics about the syncing performed by LogWriters. A
+// single LogWriterMetrics is typically shared by the successive LogWriters
+// created for a DB so that the metrics survive WAL rotation.
+type LogWriterMetrics struct {
+	// SyncLatency records the duration, in nanoseconds, of each sync of the
+	// underlying file.
+	SyncLatency histogram.Histogram
+	// SyncBytes records the number of bytes made durable by each sync. This is
+	// the size of each commit group.
+	SyncBytes histogram.Histogram
+	// SyncQueueLen records the number of sync requests satisfied by each sync.
+	SyncQueueLen histogram.Histogram
+}
+
 // LogWriter writes records to an underlying io.Writer. In order to support WAL
 // file reuse, a LogWriter's records are tagged with the WAL's file
 // number. When reading a log file a record from a previous incarnation of the
@@ -275,8 +290,21 @@ type LogWriter struct {
 		err error
 		// minSyncInterval is the minimum duration between syncs.
 		minSyncInterval durationFunc
-		pending         []*block
-		syncQ           syncQueue
+		// groupCommit holds the group commit configuration. See
+		// LogWriter.SetGroupCommit.
+		groupCommit struct {
+			maxBytes int64
+			maxWait  time.Duration
+			// delayed is true if the current commit group has already been delayed
+			// by maxWait and should be synced once syncing is unblocked.
+			delayed bool
+		}
+		// unsyncedBytes is the number of bytes written to the underlying writer
+		// since the last sync.
+		unsyncedBytes int64
+		metrics       *LogWriterMetrics
+		pending       []*block
+		syncQ         syncQueue
 	}
 
 	// afterFunc is a hook to allow tests to mock out the timer functionality
@@ -324,11 +352,44 @@ func (w *LogWriter) SetMinSyncInterval(minSyncInterval durationFunc) {
 	f.Unlock()
 }
 
+// SetGroupCommit configures group commit. When a sync is requested, the sync
+// is delayed for up to maxWait in order to allow additional sync requests to
+// be satisfied by the same sync. A delayed sync (whether delayed by maxWait or
+// by the min-sync-interval) is performed immediately once maxBytes are
+// awaiting sync. A zero maxWait disables the delay, and a zero maxBytes places
+// no limit on the bytes awaiting sync.
+func (w *LogWriter) SetGroupCommit(maxBytes int64, maxWait time.Duration) {
+	f := &w.flusher
+	f.Lock()
+	f.groupCommit.maxBytes = maxBytes
+	f.groupCommit.maxWait = maxWait
+	f.Unlock()
+}
+
+// SetMetrics sets the metrics the LogWriter records into.
+func (w *LogWriter) SetMetrics(m *LogWriterMetrics) {
+	f := &w.flusher
+	f.Lock()
+	f.metrics = m
+	f.Unlock()
+}
+
 func (w *LogWriter) flushLoop(context.Context) {
 	f := &w.flusher
 	f.Lock()
 
 	var syncTimer syncTimer
+	resetSyncTimer := func(d time.Duration) {
+		f.syncQ.setBlocked()
+		if syncTimer == nil {
+			syncTimer = w.afterFunc(d, func() {
+				f.syncQ.clearBlocked()
+				f.ready.Signal()
+			})
+		} else {
+			syncTimer.Reset(d)
+		}
+	}
 	defer func() {
 		if syncTimer != nil {
 			syncTimer.Stop()
@@ -368,6 +429,11 @@ func (w *LogWriter) flushLoop(context.Context) {
 	//   requested, any previously queued flush work will be synced. This
 	//   motivates reading the syncing work (f.syncQ.load()) before picking up
 	//   the flush work (atomic.LoadInt32(&w.block.written)).
+	//
+	// - Group commit delays a sync for up to groupCommit.maxWait after the sync
+	//   request was first observed, using the same blocked mechanism as
+	//   min-sync-interval. Either delay is cut short once groupCommit.maxBytes
+	//   are awaiting sync.
 
 	// The list of full blocks that need to be written. This is copied from
 	// f.pending on every loop iteration, though the number of elements is small
@@ -415,26 +481,50 @@ func (w *LogWriter) flushLoop(context.Context) {
 		data := w.block.buf[w.block.flushed:written]
 		w.block.flushed = written
 
+		f.unsyncedBytes += int64(len(data))
+		for _, b := range pending {
+			f.unsyncedBytes += int64(blockSize - b.flushed)
+		}
+		groupFull := f.groupCommit.maxBytes > 0 && f.unsyncedBytes >= f.groupCommit.maxBytes
+
+		if head != tail && f.groupCommit.maxWait > 0 && !f.groupCommit.delayed &&
+			!f.close && !groupFull {
+			// Delay the sync in order to allow more sync requests to join the
+			// commit group. The waiters remain queued and are picked up once the
+			// timer fires.
+			f.groupCommit.delayed = true
+			resetSyncTimer(f.groupCommit.maxWait)
+			head, tail = 0, 0
+		}
+		syncBytes := f.unsyncedBytes
+
 		f.Unlock()
 
-		synced, err := w.flushPending(data, pending, head, tail)
+		synced, syncLatency, err := w.flushPending(data, pending, head, tail)
 
 		f.Lock()
 
+		if synced {
+			f.unsyncedBytes = 0
+			f.groupCommit.delayed = false
+			if m := f.metrics; m != nil {
+				m.SyncLatency.RecordDuration(syncLatency)
+				m.SyncBytes.Record(syncBytes)
+				m.SyncQueueLen.Record(int64(head - tail))
+			}
+		}
+
 		if synced && f.minSyncInterval != nil {
 			// A sync was performed. Make sure we've waited for the min sync
 			// interval before syncing again.
 			if min := f.minSyncInterval(); min > 0 {
-				f.syncQ.setBlocked()
-				if syncTimer == nil {
-					syncTimer = w.afterFunc(min, func() {
-						f.syncQ.clearBlocked()
-						f.ready.Signal()
-					})
-				} else {
-					syncTimer.Reset(min)
-				}
+				resetSyncTimer(min)
 			}
+		} else if !synced && f.groupCommit.maxBytes > 0 &&
+			f.unsyncedBytes >= f.groupCommit.maxBytes {
+			// Syncing is blocked, but enough bytes are awaiting sync that we should
+			// sync now rather than waiting for the timer.
+			f.syncQ.clearBlocked()
 		}
 
 		f.err = err
@@ -449,7 +539,7 @@ func (w *LogWriter) flushLoop(context.Context) {
 
 func (w *LogWriter) flushPending(
 	data []byte, pending []*block, head, tail uint32,
-) (synced bool, err error) {
+) (synced bool, syncLatency time.Duration, err error) {
 	defer func() {
 		// Translate panics into errors. The errors will cause flushLoop to shut
 		// down, but allows us to do so in a controlled way and avoid swallowing
@@ -472,15 +562,17 @@ func (w *LogWriter) flushPending(
 	synced = head != tail
 	if synced {
 		if err == nil && w.s != nil {
+			start := time.Now()
 			err = w.s.Sync()
+			syncLatency = time.Since(start)
 		}
 		f := &w.flusher
 		if popErr := f.syncQ.pop(head, tail, err); popErr != nil {
-			return synced, popErr
+			return synced, syncLatency, popErr
 		}
 	}
 
-	return synced, err
+	return synced, syncLatency, err
 }
 
 func (w *LogWriter) flushBlock(b *block) error {
@@ -314,3 +314,68 @@ func TestMinSyncIntervalClose(t *testing.T) {
 	require.NoError(t, w.Close())
 	wg.Wait()
 }
+
+func TestGroupCommit(t *testing.T) {
+	const maxBytes = 64 << 10
+	const maxWait = 100 * time.Millisecond
+
+	f := &syncFile{}
+	w := NewLogWriter(f, 0)
+	w.SetGroupCommit(maxBytes, maxWait)
+	var metrics LogWriterMetrics
+	w.SetMetrics(&metrics)
+
+	var timer fakeTimer
+	timerCreated := make(chan struct{}, 1)
+	w.afterFunc = func(d time.Duration, f func()) syncTimer {
+		if d != maxWait {
+			t.Fatalf("expected maxWait %s, but found %s", maxWait, d)
+		}
+		timer.f = f
+		timer.Reset(d)
+		timerCreated <- struct{}{}
+		return &timer
+	}
+
+	syncRecord := func(n int) *sync.WaitGroup {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		_, err := w.SyncRecord(bytes.Repeat([]byte{'a'}, n), wg, new(error))
+		require.NoError(t, err)
+		return wg
+	}
+
+	// A small sync request is delayed until the group commit timer fires. Note
+	// that the record is written, but not synced.
+	wg := syncRecord(1)
+	<-timerCreated
+	require.NoError(t, try(time.Millisecond, 5*time.Second, func() error {
+		if atomic.LoadInt64(&f.writePos) == 0 {
+			return errors.New("expected record to be written")
+		}
+		return nil
+	}))
+	require.Zero(t, atomic.LoadInt64(&f.syncPos))
+
+	// A second request joins the same commit group.
+	wg2 := syncRecord(1)
+	timer.f()
+	wg.Wait()
+	wg2.Wait()
+	require.Equal(t, atomic.LoadInt64(&f.writePos), atomic.LoadInt64(&f.syncPos))
+
+	// A request which brings the bytes awaiting sync past maxBytes is synced
+	// immediately without waiting for the timer.
+	syncRecord(maxBytes).Wait()
+	require.Equal(t, atomic.LoadInt64(&f.writePos), atomic.LoadInt64(&f.syncPos))
+
+	require.NoError(t, w.Close())
+
+	queueLen := metrics.SyncQueueLen.Snapshot()
+	require.EqualValues(t, 2, queueLen.Count)
+	require.EqualValues(t, 2, queueLen.Max)
+	syncBytes := metrics.SyncBytes.Snapshot()
+	require.EqualValues(t, 2, syncBytes.Count)
+	require.True(t, syncBytes.Max >= maxBytes, "max=%d", syncBytes.Max)
+	require.EqualValues(t, 2, metrics.SyncLatency.Snapshot().Count)
+}
@@ -146,7 +146,7 @@ var (
 	// ErrInvalidChunk is returned if a chunk is encountered with an invalid
 	// header, length, or checksum. This usually occurs when a log is recycled,
 	// but can also occur due to corruption.
-	ErrInvalidChunk = errors.New("pebble/record: invalid chunk")
+	ErrInvalidChunk = base.CorruptionErrorf("pebble/record: invalid chunk")
 )
 
 // IsInvalidRecord returns true if the error matches one of the error types
@@ -170,6 +170,9 @@ type Reader struct {
 	// buf[begin:end] is the unread portion of the current chunk's payload. The
 	// low bound, begin, excludes the chunk header.
 	begin, end int
+	// recordOffset is the offset within the file of the first chunk of the
+	// current record.
+	recordOffset int64
 	// n is the number of bytes of buf that are valid. Once reading has started,
 	// only the final block can have n < blockSize.
 	n int
@@ -264,6 +267,7 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 				if chunkType != fullChunkType && chunkType != firstChunkType {
 					continue
 				}
+				r.recordOffset = r.blockNum*blockSize + int64(r.begin-headerSize)
 			}
 			r.last = chunkType == fullChunkType || chunkType == lastChunkType
 			r.recovering = false
@@ -315,6 +319,13 @@ func (r *Reader) Offset() int64 {
 	return int64(r.blockNum)*blockSize + int64(r.end)
 }
 
+// RecordOffset returns the offset within the file of the record most recently
+// returned by Next. Unlike the Offset prior to calling Next, it accounts for
+// any corrupt chunks skipped by Next after Recover.
+func (r *Reader) RecordOffset() int64 {
+	return r.recordOffset
+}
+
 // recover clears any errors read so far, so that calling Next will start
 // reading from the next good 32KiB block. If there are no such blocks, Next
 // will return io.EOF. recover also marks the current reader, the one most
@@ -332,6 +343,12 @@ func (r *Reader) recover() {
 	r.seq++
 }
 
+// Recover clears any errors read so far, so that calling Next will start
+// reading from the next good 32KiB block. See recover.
+func (r *Reader) Recover() {
+	r.recover()
+}
+
 // seekRecord seeks in the underlying io.Reader such that calling r.Next
 // returns the record whose first chunk header starts at the provided offset.
 // Its behavior is undefined if the argument given is not such an offset, as
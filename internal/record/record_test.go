@@ -546,6 +546,9 @@ func TestRecoverMultipleBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Next: %v", err)
 	}
+	if offset := r.RecordOffset(); offset != recs.offsets[4] {
+		t.Fatalf("expected record offset %d, but found %d", recs.offsets[4], offset)
+	}
 
 	r4Data, _ := ioutil.ReadAll(r4)
 	if !bytes.Equal(r4Data, recs.records[4]) {
@@ -649,6 +652,9 @@ func TestReaderOffset(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Next: %v", err)
 		}
+		if offset != r.RecordOffset() {
+			t.Fatalf("%d: expected record offset %d, but found %d", i, offset, r.RecordOffset())
+		}
 		if _, err = ioutil.ReadAll(rec); err != nil {
 			t.Fatalf("ReadAll: %v", err)
 		}
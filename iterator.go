@@ -7,6 +7,7 @@ package pebble
 import (
 	"bytes"
 	"io"
+	"time"
 
 	"github.com/cockroachdb/errors"
 )
@@ -60,6 +61,24 @@ type Iterator struct {
 	pos         iterPos
 	alloc       *iterAlloc
 	prefix      []byte
+	// getIterAlloc is non-nil if the Iterator was allocated by DB.Get.
+	getIterAlloc *getIterAlloc
+	// rangeKeys is non-nil if the iterator surfaces range keys. See
+	// IterOptions.KeyTypes.
+	rangeKeys *iterRangeKeys
+	// trace is non-nil if the operations on the iterator are recorded (see
+	// Options.TraceRecorder), and traceID identifies the iterator in the trace.
+	trace   *TraceRecorder
+	traceID uint64
+	// span is non-nil if a tracer is attached to the iterator's context (see
+	// ContextWithTracer), and is ended when the iterator is closed.
+	span Span
+	// version holds the prefix of the current entry when reading as of
+	// IterOptions.Timestamp. The older versions of the prefix are hidden.
+	version struct {
+		prefix []byte
+		valid  bool
+	}
 }
 
 func (i *Iterator) findNextEntry() bool {
@@ -83,6 +102,10 @@ func (i *Iterator) findNextEntry() bool {
 				return false
 			}
 		}
+		if i.opts.Timestamp != 0 && i.versionHidden(key.UserKey) {
+			i.nextUserKey()
+			continue
+		}
 
 		switch key.Kind() {
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
@@ -94,6 +117,9 @@ func (i *Iterator) findNextEntry() bool {
 			i.key = i.keyBuf
 			i.value = i.iterValue
 			i.valid = true
+			if i.opts.Timestamp != 0 {
+				i.setVersion()
+			}
 			return true
 
 		case InternalKeyKindMerge:
@@ -105,6 +131,9 @@ func (i *Iterator) findNextEntry() bool {
 			if i.err == nil {
 				i.value, i.valueCloser, i.err = valueMerger.Finish()
 			}
+			if i.err == nil && i.opts.Timestamp != 0 {
+				i.setVersion()
+			}
 			return i.err == nil
 
 		default:
@@ -157,13 +186,13 @@ func (i *Iterator) findPrevEntry() bool {
 		if i.valid {
 			if !i.equal(key.UserKey, i.key) {
 				// We've iterated to the previous user key.
-				i.pos = iterPosPrev
-				if valueMerger != nil {
-					i.value, i.valueCloser, i.err = valueMerger.Finish()
-				}
-				return i.err == nil
+				break
 			}
 		}
+		if i.opts.Timestamp != 0 && i.versionHidden(key.UserKey) {
+			i.iterKey, i.iterValue = i.iter.Prev()
+			continue
+		}
 
 		switch key.Kind() {
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
@@ -224,6 +253,9 @@ func (i *Iterator) findPrevEntry() bool {
 		if valueMerger != nil {
 			i.value, i.valueCloser, i.err = valueMerger.Finish()
 		}
+		if i.err == nil && i.opts.Timestamp != 0 {
+			return i.findNewestVersion()
+		}
 		return i.err == nil
 	}
 
@@ -299,16 +331,28 @@ func (i *Iterator) mergeNext(key InternalKey, valueMerger ValueMerger) {
 
 // SeekGE moves the iterator to the first key/value pair whose key is greater
 // than or equal to the given key. Returns true if the iterator is pointing at
-// a valid entry and false otherwise.
+// a valid entry and false otherwise. If IterOptions.PrefixIteration is set,
+// SeekGE behaves as SeekPrefixGE.
 func (i *Iterator) SeekGE(key []byte) bool {
+	if i.trace != nil {
+		i.trace.iterSeek(traceKindSeekGE, i.traceID, key)
+	}
+	if i.opts.PrefixIteration {
+		return i.seekPrefixGE(key)
+	}
 	i.err = nil // clear cached iteration error
 	i.prefix = nil
+	i.version.valid = false
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
 		key = lowerBound
 	}
 
 	i.iterKey, i.iterValue = i.iter.SeekGE(key)
-	return i.findNextEntry()
+	valid := i.findNextEntry()
+	if i.rangeKeys != nil {
+		return i.seekGERangeKeys(key)
+	}
+	return valid
 }
 
 // SeekPrefixGE moves the iterator to the first key/value pair whose key is
@@ -317,10 +361,11 @@ func (i *Iterator) SeekGE(key []byte) bool {
 // Comparer.Split function. The iterator will not observe keys not matching the
 // "prefix" of the search key. Calling SeekPrefixGE puts the iterator in prefix
 // iteration mode. The iterator remains in prefix iteration until a subsequent
-// call to another absolute positioning method (SeekGE, SeekLT, First,
-// Last). Reverse iteration (Prev) is not supported when an iterator is in
-// prefix iteration mode. Returns true if the iterator is pointing at a valid
-// entry and false otherwise.
+// call to another absolute positioning method (SeekGE, SeekLT, First, Last),
+// or for every SeekGE if IterOptions.PrefixIteration is set. Reverse
+// iteration (Prev) is not supported when an iterator is in prefix iteration
+// mode. Returns true if the iterator is pointing at a valid entry and false
+// otherwise.
 //
 // The semantics of SeekPrefixGE are slightly unusual and designed for
 // iteration to be able to take advantage of bloom filters that have been
@@ -342,7 +387,15 @@ func (i *Iterator) SeekGE(key []byte) bool {
 //   Next()              -> "a@2"
 //   Next()              -> EOF
 func (i *Iterator) SeekPrefixGE(key []byte) bool {
+	if i.trace != nil {
+		i.trace.iterSeek(traceKindSeekPrefixGE, i.traceID, key)
+	}
+	return i.seekPrefixGE(key)
+}
+
+func (i *Iterator) seekPrefixGE(key []byte) bool {
 	i.err = nil // clear cached iteration error
+	i.version.valid = false
 
 	if i.split == nil {
 		panic("pebble: split must be provided for SeekPrefixGE")
@@ -363,55 +416,93 @@ func (i *Iterator) SeekPrefixGE(key []byte) bool {
 	}
 
 	i.iterKey, i.iterValue = i.iter.SeekPrefixGE(i.prefix, key)
-	return i.findNextEntry()
+	valid := i.findNextEntry()
+	if i.rangeKeys != nil {
+		return i.seekGERangeKeys(key)
+	}
+	return valid
 }
 
 // SeekLT moves the iterator to the last key/value pair whose key is less than
 // the given key. Returns true if the iterator is pointing at a valid entry and
 // false otherwise.
 func (i *Iterator) SeekLT(key []byte) bool {
+	if i.trace != nil {
+		i.trace.iterSeek(traceKindSeekLT, i.traceID, key)
+	}
 	i.err = nil // clear cached iteration error
 	i.prefix = nil
+	i.version.valid = false
 	if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) >= 0 {
 		key = upperBound
 	}
 
 	i.iterKey, i.iterValue = i.iter.SeekLT(key)
-	return i.findPrevEntry()
+	valid := i.findPrevEntry()
+	if i.rangeKeys != nil {
+		return i.seekLTRangeKeys(key)
+	}
+	return valid
 }
 
 // First moves the iterator the the first key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) First() bool {
+	if i.trace != nil {
+		i.trace.iterOp(traceKindFirst, i.traceID)
+	}
 	i.err = nil // clear cached iteration error
 	i.prefix = nil
+	i.version.valid = false
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil {
 		i.iterKey, i.iterValue = i.iter.SeekGE(lowerBound)
 	} else {
 		i.iterKey, i.iterValue = i.iter.First()
 	}
-	return i.findNextEntry()
+	valid := i.findNextEntry()
+	if i.rangeKeys != nil {
+		return i.seekGERangeKeys(nil)
+	}
+	return valid
 }
 
 // Last moves the iterator the the last key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) Last() bool {
+	if i.trace != nil {
+		i.trace.iterOp(traceKindLast, i.traceID)
+	}
 	i.err = nil // clear cached iteration error
 	i.prefix = nil
+	i.version.valid = false
 	if upperBound := i.opts.GetUpperBound(); upperBound != nil {
 		i.iterKey, i.iterValue = i.iter.SeekLT(upperBound)
 	} else {
 		i.iterKey, i.iterValue = i.iter.Last()
 	}
-	return i.findPrevEntry()
+	valid := i.findPrevEntry()
+	if i.rangeKeys != nil {
+		return i.seekLTRangeKeys(nil)
+	}
+	return valid
 }
 
 // Next moves the iterator to the next key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) Next() bool {
+	if i.trace != nil {
+		i.trace.iterOp(traceKindNext, i.traceID)
+	}
 	if i.err != nil {
 		return false
 	}
+	if i.rangeKeys != nil {
+		return i.nextWithRangeKeys()
+	}
+	return i.nextPoint()
+}
+
+func (i *Iterator) nextPoint() bool {
 	switch i.pos {
 	case iterPosCur:
 		i.nextUserKey()
@@ -441,6 +532,9 @@ func (i *Iterator) Next() bool {
 // Prev moves the iterator to the previous key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) Prev() bool {
+	if i.trace != nil {
+		i.trace.iterOp(traceKindPrev, i.traceID)
+	}
 	if i.err != nil {
 		return false
 	}
@@ -448,6 +542,13 @@ func (i *Iterator) Prev() bool {
 		i.err = errReversePrefixIteration
 		return false
 	}
+	if i.rangeKeys != nil {
+		return i.prevWithRangeKeys()
+	}
+	return i.prevPoint()
+}
+
+func (i *Iterator) prevPoint() bool {
 	switch i.pos {
 	case iterPosCur:
 		i.prevUserKey()
@@ -475,22 +576,41 @@ func (i *Iterator) Prev() bool {
 }
 
 // Key returns the key of the current key/value pair, or nil if done. The
-// caller should not modify the contents of the returned slice, and its
-// contents may change on the next call to Next.
+// returned slice refers to a buffer owned by the iterator which is reused, so
+// that iteration does not allocate. The caller should not modify the contents
+// of the returned slice, and its contents may change on the next positioning
+// call or Close. A caller which retains the key must copy it. If the iterator
+// surfaces range keys, the current position may not be at a point key (see
+// HasPointAndRange).
 func (i *Iterator) Key() []byte {
+	if r := i.rangeKeys; r != nil {
+		if !r.valid {
+			return nil
+		}
+		return r.key
+	}
 	return i.key
 }
 
-// Value returns the value of the current key/value pair, or nil if done. The
-// caller should not modify the contents of the returned slice, and its
-// contents may change on the next call to Next.
+// Value returns the value of the current key/value pair, or nil if done. As
+// with Key, the returned slice refers to memory owned by the iterator (often
+// the underlying block), so the caller should not modify its contents, and
+// must copy it in order to retain it past the next positioning call or Close.
+// Returns nil if the current position is not at a point key.
 func (i *Iterator) Value() []byte {
+	if r := i.rangeKeys; r != nil && !(r.valid && r.hasPoint) {
+		return nil
+	}
 	return i.value
 }
 
-// Valid returns true if the iterator is positioned at a valid key/value pair
-// and false otherwise.
+// Valid returns true if the iterator is positioned at a valid key/value pair,
+// or within a span of range keys if the iterator surfaces range keys, and
+// false otherwise.
 func (i *Iterator) Valid() bool {
+	if i.rangeKeys != nil {
+		return i.rangeKeys.valid
+	}
 	return i.valid
 }
 
@@ -508,6 +628,9 @@ func (i *Iterator) Error() error {
 // It is not valid to call any method, including Close, after the iterator
 // has been closed.
 func (i *Iterator) Close() error {
+	if i.trace != nil {
+		i.trace.iterOp(traceKindClose, i.traceID)
+	}
 	// Close the child iterator before releasing the readState because when the
 	// readState is released sstables referenced by the readState may be deleted
 	// which will fail on Windows if the sstables are still open by the child
@@ -528,26 +651,76 @@ func (i *Iterator) Close() error {
 		i.valueCloser = nil
 	}
 
+	if i.span != nil {
+		i.span.End(time.Now())
+		i.span = nil
+	}
+
 	if alloc := i.alloc; alloc != nil {
-		// Avoid caching the key buf if it is overly large. The constant is fairly
-		// arbitrary.
-		const maxKeyBufCacheSize = 4 << 10 // 4 KB
-		if cap(i.keyBuf) >= maxKeyBufCacheSize {
-			alloc.keyBuf = nil
-		} else {
-			alloc.keyBuf = i.keyBuf
+		// Clearing alloc makes a second Close a no-op, rather than adding
+		// the allocation to the freelist twice.
+		i.alloc = nil
+		i.reset()
+		alloc.cache.put(alloc)
+	}
+	if alloc := i.getIterAlloc; alloc != nil {
+		keyBuf := i.keyBuf
+		if cap(keyBuf) >= maxBufCacheSize {
+			keyBuf = nil
 		}
-		*i = Iterator{}
-		iterAllocPool.Put(alloc)
+		*alloc = getIterAlloc{keyBuf: keyBuf}
+		getIterAllocPool.Put(alloc)
 	}
 	return err
 }
 
+// maxBufCacheSize is the maximum capacity of a buffer retained for reuse by
+// a closed iterator. The constant is fairly arbitrary.
+const maxBufCacheSize = 4 << 10 // 4 KB
+
+// reset resets a closed Iterator for reuse by newIterInternal. Rather than
+// zeroing the Iterator, only the fields which are not initialized by
+// newIterInternal are reset, and the key and value buffers are retained.
+func (i *Iterator) reset() {
+	// Avoid caching the buffers if they are overly large.
+	if cap(i.keyBuf) >= maxBufCacheSize {
+		i.keyBuf = nil
+	}
+	if cap(i.valueBuf) >= maxBufCacheSize {
+		i.valueBuf = nil
+	}
+	if cap(i.version.prefix) >= maxBufCacheSize {
+		i.version.prefix = nil
+	}
+	i.opts = IterOptions{}
+	i.iter = nil
+	i.err = nil
+	i.key = nil
+	i.value = nil
+	i.valid = false
+	i.iterKey = nil
+	i.iterValue = nil
+	i.pos = iterPosCur
+	i.prefix = nil
+	i.rangeKeys = nil
+	i.trace = nil
+	i.traceID = 0
+	i.version.valid = false
+}
+
 // SetBounds sets the lower and upper bounds for the iterator. Note that the
 // iterator will always be invalidated and must be repositioned with a call to
 // SeekGE, SeekPrefixGE, SeekLT, First, or Last.
 func (i *Iterator) SetBounds(lower, upper []byte) {
+	if i.trace != nil {
+		i.trace.iterSetBounds(i.traceID, lower, upper)
+	}
+	i.setBounds(lower, upper)
+}
+
+func (i *Iterator) setBounds(lower, upper []byte) {
 	i.prefix = nil
+	i.version.valid = false
 	i.iterKey = nil
 	i.iterValue = nil
 	i.pos = iterPosCur
@@ -556,4 +729,45 @@ func (i *Iterator) SetBounds(lower, upper []byte) {
 	i.opts.LowerBound = lower
 	i.opts.UpperBound = upper
 	i.iter.SetBounds(lower, upper)
+	if i.rangeKeys != nil {
+		i.rangeKeys.setBounds(lower, upper)
+	}
+}
+
+// SetOptions replaces the options of the iterator, allowing an iterator to be
+// reused rather than recreated when only its options change. The bounds,
+// PrefixIteration, TableFilter and Timestamp may be changed; KeyTypes may not.
+// The iterator's underlying iterators are retained: sstables which overlap the
+// new bounds remain open along with their loaded blocks, unless TableFilter or
+// Timestamp is set or changed, in which case the tables are reopened with the
+// new filters. Note that the iterator will always be invalidated and must be
+// repositioned with a call to SeekGE, SeekPrefixGE, SeekLT, First, or Last.
+func (i *Iterator) SetOptions(o *IterOptions) {
+	if i.trace != nil {
+		i.trace.iterSetOptions(i.traceID, o)
+	}
+	var opts IterOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.KeyTypes != i.opts.KeyTypes {
+		panic("pebble: SetOptions cannot change IterOptions.KeyTypes")
+	}
+	if opts.Timestamp != 0 && i.split == nil {
+		panic("pebble: split must be provided for IterOptions.Timestamp")
+	}
+
+	filtersChanged := opts.TableFilter != nil || i.opts.TableFilter != nil ||
+		opts.Timestamp != i.opts.Timestamp
+	i.opts.PrefixIteration = opts.PrefixIteration
+	i.opts.TableFilter = opts.TableFilter
+	i.opts.Timestamp = opts.Timestamp
+	if m, ok := i.iter.(*mergingIter); ok && filtersChanged {
+		for j := range m.levels {
+			if l, ok := m.levels[j].iter.(*levelIter); ok {
+				l.setTableFilters(opts.TableFilter, opts.Timestamp)
+			}
+		}
+	}
+	i.setBounds(opts.LowerBound, opts.UpperBound)
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
@@ -458,6 +459,12 @@ func TestIterator(t *testing.T) {
 					opts.LowerBound = []byte(arg.Vals[0])
 				case "upper":
 					opts.UpperBound = []byte(arg.Vals[0])
+				case "prefix":
+					var err error
+					opts.PrefixIteration, err = strconv.ParseBool(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
 				default:
 					return fmt.Sprintf("%s: unknown arg: %s", d.Cmd, arg.Key)
 				}
@@ -571,6 +578,71 @@ func TestIteratorTableFilter(t *testing.T) {
 	})
 }
 
+func TestIteratorSetOptions(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer: TimestampComparer,
+		FS:       vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	key := func(prefix string, ts uint64) []byte {
+		return MakeTimestampKey(nil, []byte(prefix), ts)
+	}
+	for _, prefix := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set(key(prefix, 1), nil, nil))
+		require.NoError(t, d.Set(key(prefix, 2), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+
+	scan := func(iter *Iterator, valid bool) string {
+		var keys []string
+		for ; valid; valid = iter.Next() {
+			keys = append(keys, fmt.Sprint(TimestampComparer.FormatKey(iter.Key())))
+		}
+		require.NoError(t, iter.Error())
+		return strings.Join(keys, " ")
+	}
+
+	iter := d.NewIter(&IterOptions{
+		LowerBound: []byte("b"),
+		UpperBound: []byte("d"),
+	})
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	require.True(t, iter.First())
+	require.Equal(t, int64(1), d.tableCache.iterCount())
+
+	// Narrowing the bounds keeps the table open.
+	iter.SetOptions(&IterOptions{
+		LowerBound: []byte("c"),
+		UpperBound: []byte("d"),
+	})
+	require.False(t, iter.Valid())
+	require.Equal(t, int64(1), d.tableCache.iterCount())
+	require.Equal(t, "c@2 c@1", scan(iter, iter.First()))
+
+	iter.SetOptions(&IterOptions{PrefixIteration: true})
+	require.Equal(t, "b@2 b@1", scan(iter, iter.SeekGE([]byte("b"))))
+
+	// Changing the filters reopens the table.
+	require.True(t, iter.SeekGE([]byte("b")))
+	iter.SetOptions(&IterOptions{Timestamp: 1})
+	require.Equal(t, int64(0), d.tableCache.iterCount())
+	require.Equal(t, "a@1 b@1 c@1 d@1", scan(iter, iter.First()))
+
+	iter.SetOptions(&IterOptions{
+		TableFilter: func(userProps map[string]string) bool { return false },
+	})
+	require.Equal(t, "", scan(iter, iter.First()))
+
+	iter.SetOptions(nil)
+	require.Equal(t, "d@2 d@1", scan(iter, iter.SeekGE([]byte("d"))))
+}
+
 func TestIteratorNextPrev(t *testing.T) {
 	var mem vfs.FS
 	var d *DB
@@ -692,3 +764,123 @@ func BenchmarkIteratorPrev(b *testing.B) {
 		iter.Prev()
 	}
 }
+
+func TestIteratorReverseComparer(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer: ReverseComparer(DefaultComparer),
+		FS:       vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for i, k := range []string{"b", "d", "a", "e", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		if i%2 == 1 {
+			require.NoError(t, d.Flush())
+		}
+	}
+	require.NoError(t, d.DeleteRange([]byte("d"), []byte("b"), nil))
+	require.NoError(t, d.Compact([]byte("e"), []byte("a"), false /* parallelize */))
+
+	iter := d.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.Equal(t, []string{"e", "b", "a"}, keys)
+	require.True(t, iter.SeekGE([]byte("c")))
+	require.Equal(t, "b", string(iter.Key()))
+	require.True(t, iter.SeekLT([]byte("c")))
+	require.Equal(t, "e", string(iter.Key()))
+	require.NoError(t, iter.Close())
+}
+
+func TestIteratorReuse(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+
+	scan := func() {
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+
+	// A closed iterator's allocation is reused by the next iterator, after its
+	// references to the memtables and sstables have been dropped.
+	scan()
+	require.Equal(t, 1, len(d.iterAllocs.free))
+	alloc := d.iterAllocs.free[0]
+	require.Nil(t, alloc.mlevels[0].iter)
+	require.Nil(t, alloc.levels[0].files)
+	require.Nil(t, alloc.merging.levels)
+	iter := d.NewIter(nil)
+	require.True(t, iter.alloc == alloc)
+	require.True(t, iter.SeekGE([]byte("b")))
+	require.Equal(t, "b", string(iter.Key()))
+	require.NoError(t, iter.Close())
+
+	// Closing an iterator twice releases its allocation once, so that it is
+	// not reused by two iterators.
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1, len(d.iterAllocs.free))
+	a, b := d.NewIter(nil), d.NewIter(nil)
+	require.True(t, a != b)
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+
+	if invariants.RaceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	// Opening and closing an iterator over the memtable and an sstable does
+	// not allocate once the table is in the table cache.
+	require.Equal(t, float64(0), testing.AllocsPerRun(100, scan))
+}
+
+func TestIteratorNextPrevAllocs(t *testing.T) {
+	if invariants.RaceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	// Half of the keys are in an sstable, and half in the memtable.
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%05d", i)), []byte("value"), nil))
+		if i == 500 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	iter := d.NewIter(nil)
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	for valid := iter.Last(); valid; valid = iter.Prev() {
+	}
+
+	// Neither Next nor Prev allocate: the key and value are returned from
+	// buffers owned by the Iterator or the underlying blocks.
+	iter.First()
+	require.Equal(t, float64(0), testing.AllocsPerRun(1000, func() {
+		if !iter.Next() {
+			iter.First()
+		}
+	}))
+	iter.Last()
+	require.Equal(t, float64(0), testing.AllocsPerRun(1000, func() {
+		if !iter.Prev() {
+			iter.Last()
+		}
+	}))
+}
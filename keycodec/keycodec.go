@@ -0,0 +1,295 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package keycodec implements order-preserving encodings of values for use in
+// user keys. The bytewise order of encoded values (as used by the default
+// comparer) matches the order of the values themselves, or its reverse for the
+// descending encodings. Every encoding is self-delimiting and prefix-free, so
+// encoded values may be concatenated to form composite keys which order by
+// their first component, then by their second, and so on. Split returns a
+// Comparer.Split function which separates a composite key into a prefix of
+// its leading components and a suffix, such as a version.
+package keycodec // import "github.com/cockroachdb/pebble/keycodec"
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// ErrMalformed is returned when decoding a value which was not produced by the
+// corresponding encoding.
+var ErrMalformed = errors.New("keycodec: malformed encoding")
+
+const (
+	// The bytes encodings escape 0x00 as 0x00 0xff and are terminated by
+	// 0x00 0x01, so that the terminator sorts before any continuation.
+	escape         byte = 0x00
+	escapedEscape  byte = 0xff
+	escapedTerm    byte = 0x01
+	uint64Len           = 8
+	maxUvarintSize      = 1 + uint64Len
+)
+
+// Kind identifies one of the encodings, allowing an encoded value to be
+// skipped without knowing its contents.
+type Kind int
+
+const (
+	// Uint64Ascending is the encoding of EncodeUint64Ascending.
+	Uint64Ascending Kind = iota
+	// Uint64Descending is the encoding of EncodeUint64Descending.
+	Uint64Descending
+	// UvarintAscending is the encoding of EncodeUvarintAscending.
+	UvarintAscending
+	// UvarintDescending is the encoding of EncodeUvarintDescending.
+	UvarintDescending
+	// BytesAscending is the encoding of EncodeBytesAscending and
+	// EncodeStringAscending.
+	BytesAscending
+	// BytesDescending is the encoding of EncodeBytesDescending and
+	// EncodeStringDescending.
+	BytesDescending
+)
+
+// EncodeUint64Ascending appends the 8-byte encoding of v to dst.
+func EncodeUint64Ascending(dst []byte, v uint64) []byte {
+	var buf [uint64Len]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+// EncodeUint64Descending appends the 8-byte encoding of v to dst, such that
+// larger values sort first.
+func EncodeUint64Descending(dst []byte, v uint64) []byte {
+	return EncodeUint64Ascending(dst, ^v)
+}
+
+// DecodeUint64Ascending decodes a value encoded by EncodeUint64Ascending from
+// the start of b, returning the remainder of b and the value.
+func DecodeUint64Ascending(b []byte) ([]byte, uint64, error) {
+	if len(b) < uint64Len {
+		return nil, 0, ErrMalformed
+	}
+	return b[uint64Len:], binary.BigEndian.Uint64(b), nil
+}
+
+// DecodeUint64Descending decodes a value encoded by EncodeUint64Descending
+// from the start of b, returning the remainder of b and the value.
+func DecodeUint64Descending(b []byte) ([]byte, uint64, error) {
+	b, v, err := DecodeUint64Ascending(b)
+	return b, ^v, err
+}
+
+// EncodeUvarintAscending appends the variable length encoding of v to dst. The
+// encoding is a byte holding the number of significant bytes of v, followed by
+// those bytes in big-endian order, so small values have short encodings.
+func EncodeUvarintAscending(dst []byte, v uint64) []byte {
+	n := (bits.Len64(v) + 7) / 8
+	dst = append(dst, byte(n))
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(v>>(8*uint(i))))
+	}
+	return dst
+}
+
+// EncodeUvarintDescending appends the variable length encoding of v to dst,
+// such that larger values sort first. It is the complement of the ascending
+// encoding.
+func EncodeUvarintDescending(dst []byte, v uint64) []byte {
+	n := len(dst)
+	dst = EncodeUvarintAscending(dst, v)
+	complement(dst[n:])
+	return dst
+}
+
+// DecodeUvarintAscending decodes a value encoded by EncodeUvarintAscending
+// from the start of b, returning the remainder of b and the value.
+func DecodeUvarintAscending(b []byte) ([]byte, uint64, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrMalformed
+	}
+	n := int(b[0])
+	if n > uint64Len || len(b) < 1+n || (n > 0 && b[1] == 0) {
+		// Each value has a single encoding of minimal length.
+		return nil, 0, ErrMalformed
+	}
+	var v uint64
+	for _, c := range b[1 : 1+n] {
+		v = v<<8 | uint64(c)
+	}
+	return b[1+n:], v, nil
+}
+
+// DecodeUvarintDescending decodes a value encoded by EncodeUvarintDescending
+// from the start of b, returning the remainder of b and the value.
+func DecodeUvarintDescending(b []byte) ([]byte, uint64, error) {
+	var buf [maxUvarintSize]byte
+	n := copy(buf[:], b)
+	complement(buf[:n])
+	rest, v, err := DecodeUvarintAscending(buf[:n])
+	if err != nil {
+		return nil, 0, err
+	}
+	return b[n-len(rest):], v, nil
+}
+
+// EncodeBytesAscending appends the encoding of the byte string v to dst. Zero
+// bytes within v are escaped and the encoding is terminated, so that a string
+// sorts before any longer string it is a prefix of.
+func EncodeBytesAscending(dst, v []byte) []byte {
+	for _, c := range v {
+		dst = append(dst, c)
+		if c == escape {
+			dst = append(dst, escapedEscape)
+		}
+	}
+	return append(dst, escape, escapedTerm)
+}
+
+// EncodeBytesDescending appends the encoding of the byte string v to dst, such
+// that larger strings sort first. It is the complement of the ascending
+// encoding.
+func EncodeBytesDescending(dst, v []byte) []byte {
+	n := len(dst)
+	dst = EncodeBytesAscending(dst, v)
+	complement(dst[n:])
+	return dst
+}
+
+// EncodeStringAscending is like EncodeBytesAscending, but encodes a string.
+func EncodeStringAscending(dst []byte, v string) []byte {
+	return EncodeBytesAscending(dst, []byte(v))
+}
+
+// EncodeStringDescending is like EncodeBytesDescending, but encodes a string.
+func EncodeStringDescending(dst []byte, v string) []byte {
+	return EncodeBytesDescending(dst, []byte(v))
+}
+
+// DecodeBytesAscending decodes a byte string encoded by EncodeBytesAscending
+// from the start of b, appending it to buf. It returns the remainder of b and
+// the extended buf.
+func DecodeBytesAscending(b, buf []byte) ([]byte, []byte, error) {
+	return decodeBytes(b, buf, 0)
+}
+
+// DecodeBytesDescending decodes a byte string encoded by EncodeBytesDescending
+// from the start of b, appending it to buf. It returns the remainder of b and
+// the extended buf.
+func DecodeBytesDescending(b, buf []byte) ([]byte, []byte, error) {
+	return decodeBytes(b, buf, 0xff)
+}
+
+// DecodeStringAscending is like DecodeBytesAscending, but returns a string.
+func DecodeStringAscending(b []byte) ([]byte, string, error) {
+	b, v, err := DecodeBytesAscending(b, nil)
+	return b, string(v), err
+}
+
+// DecodeStringDescending is like DecodeBytesDescending, but returns a string.
+func DecodeStringDescending(b []byte) ([]byte, string, error) {
+	b, v, err := DecodeBytesDescending(b, nil)
+	return b, string(v), err
+}
+
+// decodeBytes decodes the bytes encoding from the start of b, in which every
+// byte has been XOR'd with mask.
+func decodeBytes(b, buf []byte, mask byte) ([]byte, []byte, error) {
+	for i := 0; i < len(b); i++ {
+		c := b[i] ^ mask
+		if c != escape {
+			buf = append(buf, c)
+			continue
+		}
+		if i+1 == len(b) {
+			break
+		}
+		switch b[i+1] ^ mask {
+		case escapedEscape:
+			buf = append(buf, escape)
+			i++
+		case escapedTerm:
+			return b[i+2:], buf, nil
+		default:
+			return nil, nil, ErrMalformed
+		}
+	}
+	return nil, nil, ErrMalformed
+}
+
+// PeekLength returns the length of the encoded value of the specified kind at
+// the start of b, without decoding it.
+func PeekLength(b []byte, kind Kind) (int, error) {
+	switch kind {
+	case Uint64Ascending, Uint64Descending:
+		if len(b) < uint64Len {
+			return 0, ErrMalformed
+		}
+		return uint64Len, nil
+	case UvarintAscending, UvarintDescending:
+		if len(b) == 0 {
+			return 0, ErrMalformed
+		}
+		n := int(b[0])
+		if kind == UvarintDescending {
+			n = int(^b[0])
+		}
+		if n > uint64Len || len(b) < 1+n {
+			return 0, ErrMalformed
+		}
+		return 1 + n, nil
+	case BytesAscending, BytesDescending:
+		var mask byte
+		if kind == BytesDescending {
+			mask = 0xff
+		}
+		for i := 0; i+1 < len(b); i++ {
+			if b[i]^mask != escape {
+				continue
+			}
+			switch b[i+1] ^ mask {
+			case escapedEscape:
+				i++
+			case escapedTerm:
+				return i + 2, nil
+			default:
+				return 0, ErrMalformed
+			}
+		}
+		return 0, ErrMalformed
+	}
+	return 0, errors.Errorf("keycodec: unknown kind %d", errors.Safe(kind))
+}
+
+// Split returns a function suitable for use as Comparer.Split with the
+// default comparer, for composite keys whose leading components are encoded
+// with the specified kinds. The prefix of a key is its leading components,
+// and the remainder of the key (for example, an encoded version) is its
+// suffix. A key which does not begin with the specified components is
+// entirely prefix.
+//
+// Because the encodings are prefix-free, the resulting Split satisfies the
+// requirements of Comparer.Split under bytewise ordering.
+func Split(kinds ...Kind) base.Split {
+	return func(key []byte) int {
+		n := 0
+		for _, kind := range kinds {
+			l, err := PeekLength(key[n:], kind)
+			if err != nil {
+				return len(key)
+			}
+			n += l
+		}
+		return n
+	}
+}
+
+func complement(b []byte) {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+}
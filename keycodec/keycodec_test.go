@@ -0,0 +1,149 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package keycodec
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestUint64(t *testing.T) {
+	values := []uint64{0, 1, 2, 0xff, 0x100, 0xffff, 1 << 32, math.MaxUint64 - 1, math.MaxUint64}
+	rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+	for i := 0; i < 100; i++ {
+		values = append(values, rng.Uint64()>>uint(rng.Intn(64)))
+	}
+
+	type codec struct {
+		name   string
+		kind   Kind
+		desc   bool
+		encode func([]byte, uint64) []byte
+		decode func([]byte) ([]byte, uint64, error)
+	}
+	for _, c := range []codec{
+		{"uint64-asc", Uint64Ascending, false, EncodeUint64Ascending, DecodeUint64Ascending},
+		{"uint64-desc", Uint64Descending, true, EncodeUint64Descending, DecodeUint64Descending},
+		{"uvarint-asc", UvarintAscending, false, EncodeUvarintAscending, DecodeUvarintAscending},
+		{"uvarint-desc", UvarintDescending, true, EncodeUvarintDescending, DecodeUvarintDescending},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for _, a := range values {
+				enc := c.encode([]byte("prefix"), a)
+				require.Equal(t, "prefix", string(enc[:6]))
+				enc = append(enc[6:], "rest"...)
+				rest, v, err := c.decode(enc)
+				require.NoError(t, err)
+				require.Equal(t, a, v)
+				require.Equal(t, "rest", string(rest))
+				n, err := PeekLength(enc, c.kind)
+				require.NoError(t, err)
+				require.Equal(t, len(enc)-len("rest"), n)
+
+				_, _, err = c.decode(enc[:n-1])
+				require.Equal(t, ErrMalformed, err)
+
+				for _, b := range values {
+					want := 0
+					if a < b {
+						want = -1
+					} else if a > b {
+						want = +1
+					}
+					if c.desc {
+						want = -want
+					}
+					require.Equal(t, want, bytes.Compare(c.encode(nil, a), c.encode(nil, b)), "%d vs %d", a, b)
+				}
+			}
+		})
+	}
+
+	// Non-minimal uvarints are rejected.
+	_, _, err := DecodeUvarintAscending([]byte{2, 0, 1})
+	require.Equal(t, ErrMalformed, err)
+	_, _, err = DecodeUvarintAscending([]byte{9})
+	require.Equal(t, ErrMalformed, err)
+}
+
+func TestBytes(t *testing.T) {
+	values := []string{"", "\x00", "\x00\x00", "\x00\x01", "\x00\xff", "\x01", "a", "a\x00", "a\x00b", "ab", "b", "\xff", "\xff\x00"}
+
+	type codec struct {
+		name   string
+		kind   Kind
+		desc   bool
+		encode func([]byte, []byte) []byte
+		decode func([]byte, []byte) ([]byte, []byte, error)
+	}
+	for _, c := range []codec{
+		{"asc", BytesAscending, false, EncodeBytesAscending, DecodeBytesAscending},
+		{"desc", BytesDescending, true, EncodeBytesDescending, DecodeBytesDescending},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for _, a := range values {
+				enc := append(c.encode(nil, []byte(a)), "rest"...)
+				rest, v, err := c.decode(enc, []byte("buf"))
+				require.NoError(t, err)
+				require.Equal(t, "buf"+a, string(v))
+				require.Equal(t, "rest", string(rest))
+				n, err := PeekLength(enc, c.kind)
+				require.NoError(t, err)
+				require.Equal(t, len(enc)-len("rest"), n)
+
+				_, _, err = c.decode(enc[:n-1], nil)
+				require.Equal(t, ErrMalformed, err)
+
+				for _, b := range values {
+					want := bytes.Compare([]byte(a), []byte(b))
+					if c.desc {
+						want = -want
+					}
+					require.Equal(t, want, bytes.Compare(c.encode(nil, []byte(a)), c.encode(nil, []byte(b))),
+						"%q vs %q", a, b)
+				}
+			}
+		})
+	}
+
+	rest, s, err := DecodeStringDescending(EncodeStringDescending(nil, "a\x00b"))
+	require.NoError(t, err)
+	require.Equal(t, "a\x00b", s)
+	require.Equal(t, 0, len(rest))
+	_, _, err = DecodeStringAscending([]byte("a\x00\x02"))
+	require.Equal(t, ErrMalformed, err)
+}
+
+func TestSplit(t *testing.T) {
+	// Composite keys of a string and a descending uvarint, with a version
+	// suffix.
+	split := Split(BytesAscending, UvarintDescending)
+	var keys [][]byte
+	for _, s := range []string{"", "a", "a\x00", "ab"} {
+		for _, u := range []uint64{0, 1, 1000} {
+			prefix := EncodeUvarintDescending(EncodeStringAscending(nil, s), u)
+			keys = append(keys, prefix)
+			for _, version := range []uint64{1, 2} {
+				key := EncodeUint64Descending(append([]byte(nil), prefix...), version)
+				require.Equal(t, len(prefix), split(key))
+				keys = append(keys, key)
+			}
+		}
+	}
+	// Keys which do not begin with the components are entirely prefix.
+	keys = append(keys, []byte(""), []byte("a"), EncodeStringAscending(nil, "b"))
+	require.Equal(t, 1, split([]byte("a")))
+
+	c := *base.DefaultComparer
+	c.Split = split
+	c.Name = "keycodec"
+	require.NoError(t, base.CheckComparer(&c, keys))
+}
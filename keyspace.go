@@ -0,0 +1,520 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrKeyspaceNotFound is returned when a keyspace does not exist.
+var ErrKeyspaceNotFound = errors.New("pebble: keyspace not found")
+
+const (
+	// keyspacesFilename is the name of the file in the DB directory recording
+	// the keyspaces of the DB.
+	keyspacesFilename = "KEYSPACES"
+	// keyspacesDirname is the name of the directory in the DB directory holding
+	// a subdirectory for each keyspace.
+	keyspacesDirname = "keyspaces"
+)
+
+// A keyspace is a named partition of a DB with its own options. A keyspace is
+// itself a DB, with its own directory, memtables, LSM and compactions, but it
+// shares the WAL and the commit pipeline of the DB which owns it (its parent):
+//
+//   - Batches committed to a keyspace are wrapped in an
+//     InternalKeyKindKeyspaceBatch record tagged with the keyspace's ID, and
+//     written to the parent's WAL while holding the parent's
+//     commitPipeline.mu. The WAL writes of the parent and its keyspaces are
+//     thus serialized, and share WAL syncs.
+//
+//   - Each memtable of a keyspace records the number of the parent's log which
+//     was current when the memtable was created. The batches applied to the
+//     memtable are written to the WAL after it was created, so none of them
+//     are in an earlier log. The minimum unflushed log number in the
+//     keyspace's MANIFEST is thus a log number of the parent, and the parent
+//     retains its logs until they have been flushed by its keyspaces as well
+//     as by itself. A keyspace which retains older logs than the parent is
+//     flushed in the background.
+//
+//   - When a DB is opened, each of its keyspaces replays the logs of the
+//     parent, skipping the batches of the parent and of other keyspaces. The
+//     parent skips the batches of its keyspaces.
+//
+// A keyspace assigns its own sequence numbers, and a batch is committed to a
+// single keyspace: there is no atomicity across keyspaces. A write stall in
+// either the parent or a keyspace stalls the commit pipeline, and thus writes
+// to all of them.
+//
+// The keyspaces of a DB are recorded in the KEYSPACES file of the DB
+// directory, and the files of a keyspace are in a subdirectory of keyspaces/
+// named by its ID. IDs are never reused, so that the batches of a dropped
+// keyspace remaining in the WAL are skipped when the DB is opened.
+type keyspace struct {
+	id     uint32
+	name   string
+	parent *DB
+	db     *DB
+
+	// flushing is set while a flush of the keyspace scheduled by
+	// maybeFlush is in progress, and closing once the keyspace is being
+	// closed, after which no further flushes are scheduled. Accessed
+	// atomically.
+	flushing int32
+	closing  int32
+	flushWG  sync.WaitGroup
+
+	// walBatch is the batch in which the batches committed to the keyspace are
+	// wrapped before they are written to the WAL. Protected by the parent's
+	// commitPipeline.mu.
+	walBatch Batch
+}
+
+func (k *keyspace) dirname() string {
+	fs := k.parent.opts.FS
+	return fs.PathJoin(k.parent.dirname, keyspacesDirname, fmt.Sprintf("%06d", k.id))
+}
+
+// open opens the DB of the keyspace, creating it if necessary. The keyspace
+// uses the FS, WAL and read-only mode of its parent, and the parent's Cache
+// and Logger unless opts specifies its own.
+func (k *keyspace) open(opts *Options) error {
+	p := k.parent
+	opts = opts.Clone()
+	opts.FS = p.opts.FS
+	if opts.Cache == nil {
+		opts.Cache = p.opts.Cache
+	}
+	if opts.Logger == nil {
+		opts.Logger = p.opts.Logger
+	}
+	opts.DisableWAL = p.opts.DisableWAL
+	opts.ErrorIfExists = false
+	opts.ErrorIfNotExists = false
+	opts.Experimental.PlacementPolicy = nil
+	opts.Experimental.SecondaryFS = nil
+	opts.Keyspaces = nil
+	opts.ReadOnly = p.opts.ReadOnly
+	opts.TraceRecorder = nil
+	opts.WALDir = p.walDirname
+	// The keyspace only exists within a DB supporting keyspaces, which
+	// supports the features preceding them as well.
+	if opts.FormatMajorVersion < FormatKeyspaces {
+		opts.FormatMajorVersion = FormatKeyspaces
+	}
+	opts.private.keyspace = k
+	d, err := Open(k.dirname(), opts)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: keyspace %q", k.name)
+	}
+	k.db = d
+	return nil
+}
+
+// close closes the DB of the keyspace, once any flush scheduled by maybeFlush
+// has completed. k.closing must have been set while holding the parent's
+// keyspaces.mu, which must not be held when calling this, as the flush
+// acquires it once complete.
+func (k *keyspace) close() error {
+	k.flushWG.Wait()
+	return k.db.close()
+}
+
+// maybeFlush schedules a flush of the keyspace in the background, unless a
+// previously scheduled flush is still in progress. The parent's
+// keyspaces.mu must be held, so that the keyspace is not concurrently closed.
+func (k *keyspace) maybeFlush() {
+	if atomic.LoadInt32(&k.closing) != 0 || !atomic.CompareAndSwapInt32(&k.flushing, 0, 1) {
+		return
+	}
+	k.flushWG.Add(1)
+	go func() {
+		defer k.flushWG.Done()
+		flushed, err := k.db.AsyncFlush()
+		if err != nil {
+			atomic.StoreInt32(&k.flushing, 0)
+			k.db.opts.Logger.Infof("pebble: keyspace %q: flush failed: %v", k.name, err)
+			return
+		}
+		<-flushed
+		atomic.StoreInt32(&k.flushing, 0)
+		if atomic.LoadInt32(&k.closing) != 0 {
+			return
+		}
+		// The logs retained by the keyspace may now be obsolete. If the DB
+		// switched to a new log while the keyspace was being flushed, this
+		// schedules another flush of the keyspace.
+		p := k.parent
+		p.mu.Lock()
+		jobID := p.mu.nextJobID
+		p.mu.nextJobID++
+		p.deleteObsoleteFiles(jobID)
+		p.mu.Unlock()
+	}()
+}
+
+// commitWrite writes a batch committed to the keyspace to the WAL of its
+// parent, returning the memtable the batch should be applied to. See
+// DB.commitWrite.
+func (k *keyspace) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
+	d, p := k.db, k.parent
+	if b.flushable != nil {
+		b.flushable.setSeqNum(b.SeqNum())
+	}
+
+	// The parent's LogWriter is protected by its commitPipeline.mu, which also
+	// prevents the parent's log from being rotated between the selection of
+	// the memtable and the write of the batch.
+	p.commit.mu.Lock()
+	defer p.commit.mu.Unlock()
+
+	d.mu.Lock()
+	err := d.makeRoomForWrite(b)
+	mem := d.mu.mem.mutable
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.DisableWAL {
+		return mem, nil
+	}
+
+	k.walBatch.Reset()
+	k.walBatch.keyspaceBatch(k.id, b.Repr())
+	size, err := p.mu.log.SyncRecord(k.walBatch.Repr(), syncWG, syncErr)
+	if err != nil {
+		walWriteFailed(b, syncWG, err)
+		return mem, nil
+	}
+	atomic.StoreUint64(&p.mu.log.size, uint64(size))
+	return mem, nil
+}
+
+// currentLogNum returns the file number of the DB's current log.
+func (d *DB) currentLogNum() FileNum {
+	return FileNum(atomic.LoadUint64(&d.mu.log.num))
+}
+
+// minUnflushedLogNumLocked returns the smallest number of a log which may
+// contain batches which have not been flushed, either by the DB or by one of
+// its keyspaces. Keyspaces which retain older logs than the DB are flushed in
+// the background so that they do not retain the logs indefinitely.
+//
+// d.mu must be held when calling this.
+func (d *DB) minUnflushedLogNumLocked() FileNum {
+	minLogNum := d.mu.versions.minUnflushedLogNum
+	d.keyspaces.Lock()
+	defer d.keyspaces.Unlock()
+	for _, k := range d.keyspaces.m {
+		k.db.mu.Lock()
+		logNum := k.db.mu.versions.minUnflushedLogNum
+		k.db.mu.Unlock()
+		if logNum < d.mu.versions.minUnflushedLogNum && !d.opts.ReadOnly {
+			k.maybeFlush()
+		}
+		if minLogNum > logNum {
+			minLogNum = logNum
+		}
+	}
+	return minLogNum
+}
+
+// CreateKeyspace creates a keyspace with the specified name and options,
+// returning the DB through which the keyspace is accessed. A keyspace has its
+// own memtables and LSM, which are configured independently of the DB's by
+// opts (e.g. Comparer, Merger, Levels), but it shares the DB's WAL and commit
+// pipeline. The keyspace uses the FS, WALDir and ReadOnly options of the DB,
+// and the DB's Cache and Logger unless opts specifies its own. A batch is
+// committed to a single keyspace: there is no atomicity across keyspaces.
+//
+// The keyspace is reopened along with the DB, using the options in
+// Options.Keyspaces. It is closed along with the DB, and must not be closed
+// directly. Keyspaces require the format major version of the DB to be at
+// least FormatKeyspaces.
+func (d *DB) CreateKeyspace(name string, opts *Options) (*DB, error) {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if d.keyspace != nil {
+		return nil, errors.New("pebble: keyspaces cannot be nested")
+	}
+	if name == "" {
+		return nil, errors.New("pebble: keyspace name must not be empty")
+	}
+	if err := d.requireFormatMajorVersion(FormatKeyspaces, "keyspaces"); err != nil {
+		return nil, err
+	}
+
+	d.keyspaces.Lock()
+	if _, ok := d.keyspaces.m[name]; ok {
+		d.keyspaces.Unlock()
+		return nil, errors.Errorf("pebble: keyspace %q already exists", name)
+	}
+	k := &keyspace{id: d.keyspaces.nextID, name: name, parent: d}
+	d.keyspaces.nextID++
+
+	// The keyspace's directory is created before the keyspace is recorded in
+	// the KEYSPACES file. If a crash occurs in between, the unrecorded
+	// directory is removed when the DB is next opened.
+	if err := k.open(opts); err != nil {
+		d.keyspaces.Unlock()
+		_ = d.opts.FS.RemoveAll(k.dirname())
+		return nil, err
+	}
+	if d.keyspaces.m == nil {
+		d.keyspaces.m = make(map[string]*keyspace)
+	}
+	d.keyspaces.m[name] = k
+	if err := d.writeKeyspacesLocked(nil /* dropped */); err != nil {
+		delete(d.keyspaces.m, name)
+		atomic.StoreInt32(&k.closing, 1)
+		d.keyspaces.Unlock()
+		_ = k.close()
+		_ = d.opts.FS.RemoveAll(k.dirname())
+		return nil, err
+	}
+	d.keyspaces.Unlock()
+	return k.db, nil
+}
+
+// Keyspace returns the DB through which the named keyspace is accessed, or
+// ErrKeyspaceNotFound if the keyspace does not exist.
+func (d *DB) Keyspace(name string) (*DB, error) {
+	d.keyspaces.Lock()
+	defer d.keyspaces.Unlock()
+	k, ok := d.keyspaces.m[name]
+	if !ok {
+		return nil, ErrKeyspaceNotFound
+	}
+	return k.db, nil
+}
+
+// Keyspaces returns the sorted names of the keyspaces of the DB.
+func (d *DB) Keyspaces() []string {
+	d.keyspaces.Lock()
+	defer d.keyspaces.Unlock()
+	names := make([]string, 0, len(d.keyspaces.m))
+	for name := range d.keyspaces.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropKeyspace drops the named keyspace, closing it and deleting its files
+// without rewriting any of the DB's data. The DB of the keyspace must not be
+// used after DropKeyspace is called, and it is not safe to call DropKeyspace
+// while the keyspace has open iterators.
+func (d *DB) DropKeyspace(name string) error {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	d.keyspaces.Lock()
+	k, ok := d.keyspaces.m[name]
+	if !ok {
+		d.keyspaces.Unlock()
+		return ErrKeyspaceNotFound
+	}
+	// The keyspace retains the logs containing its batches until the drop is
+	// durable.
+	if err := d.writeKeyspacesLocked(k); err != nil {
+		d.keyspaces.Unlock()
+		return err
+	}
+	delete(d.keyspaces.m, name)
+	atomic.StoreInt32(&k.closing, 1)
+	d.keyspaces.Unlock()
+	err := k.close()
+	return firstError(err, d.opts.FS.RemoveAll(k.dirname()))
+}
+
+// closeKeyspaces closes the keyspaces of the DB.
+func (d *DB) closeKeyspaces() error {
+	// The keyspaces are closed without holding keyspaces.mu, but remain in
+	// the map until they are closed so that they continue to retain their
+	// logs.
+	d.keyspaces.Lock()
+	keyspaces := make([]*keyspace, 0, len(d.keyspaces.m))
+	for _, k := range d.keyspaces.m {
+		atomic.StoreInt32(&k.closing, 1)
+		keyspaces = append(keyspaces, k)
+	}
+	d.keyspaces.Unlock()
+
+	var err error
+	for _, k := range keyspaces {
+		err = firstError(err, k.close())
+	}
+	d.keyspaces.Lock()
+	d.keyspaces.m = nil
+	d.keyspaces.Unlock()
+	return err
+}
+
+// openKeyspaces opens the keyspaces recorded in the KEYSPACES file of the DB,
+// using the options in Options.Keyspaces, and removes the directories of
+// keyspaces which are not recorded.
+func (d *DB) openKeyspaces() error {
+	d.keyspaces.Lock()
+	defer d.keyspaces.Unlock()
+
+	fs := d.opts.FS
+	d.keyspaces.nextID = 1
+	f, err := fs.Open(fs.PathJoin(d.dirname, keyspacesFilename))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	keyspaces, nextID, err := parseKeyspaces(data)
+	if err != nil {
+		return err
+	}
+	d.keyspaces.nextID = nextID
+	d.keyspaces.m = make(map[string]*keyspace, len(keyspaces))
+	for _, k := range keyspaces {
+		k.parent = d
+		if err := k.open(d.opts.Keyspaces[k.name]); err != nil {
+			return err
+		}
+		d.keyspaces.m[k.name] = k
+	}
+
+	if d.opts.ReadOnly {
+		return nil
+	}
+	dirnames, err := fs.List(fs.PathJoin(d.dirname, keyspacesDirname))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, dirname := range dirnames {
+		id, err := strconv.ParseUint(dirname, 10, 32)
+		if err != nil || keyspaceRecorded(keyspaces, uint32(id)) {
+			continue
+		}
+		if err := fs.RemoveAll(fs.PathJoin(d.dirname, keyspacesDirname, dirname)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func keyspaceRecorded(keyspaces []*keyspace, id uint32) bool {
+	for _, k := range keyspaces {
+		if k.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// writeKeyspacesLocked durably writes the KEYSPACES file, recording the
+// keyspaces of the DB other than dropped, which may be nil. d.keyspaces.mu
+// must be held.
+func (d *DB) writeKeyspacesLocked(dropped *keyspace) error {
+	var keyspaces []*keyspace
+	for _, k := range d.keyspaces.m {
+		if k != dropped {
+			keyspaces = append(keyspaces, k)
+		}
+	}
+	sort.Slice(keyspaces, func(i, j int) bool {
+		return keyspaces[i].id < keyspaces[j].id
+	})
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "next_id=%d\n", d.keyspaces.nextID)
+	for _, k := range keyspaces {
+		fmt.Fprintf(&buf, "%d %s\n", k.id, strconv.Quote(k.name))
+	}
+
+	// The file is replaced atomically by renaming a temporary file over it.
+	fs := d.opts.FS
+	filename := fs.PathJoin(d.dirname, keyspacesFilename)
+	tmpFilename := filename + ".tmp"
+	f, err := fs.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpFilename, filename); err != nil {
+		return err
+	}
+	return d.dataDir.Sync()
+}
+
+// parseKeyspaces parses the contents of a KEYSPACES file, returning the
+// recorded keyspaces and the next keyspace ID.
+func parseKeyspaces(data []byte) ([]*keyspace, uint32, error) {
+	var keyspaces []*keyspace
+	var nextID uint32
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "next_id=") {
+			id, err := strconv.ParseUint(strings.TrimPrefix(line, "next_id="), 10, 32)
+			if err != nil {
+				return nil, 0, errors.Errorf("pebble: corrupt %s: %q", keyspacesFilename, line)
+			}
+			nextID = uint32(id)
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil, 0, errors.Errorf("pebble: corrupt %s: %q", keyspacesFilename, line)
+		}
+		id, err := strconv.ParseUint(line[:i], 10, 32)
+		if err != nil {
+			return nil, 0, errors.Errorf("pebble: corrupt %s: %q", keyspacesFilename, line)
+		}
+		name, err := strconv.Unquote(line[i+1:])
+		if err != nil {
+			return nil, 0, errors.Errorf("pebble: corrupt %s: %q", keyspacesFilename, line)
+		}
+		keyspaces = append(keyspaces, &keyspace{id: uint32(id), name: name})
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	for _, k := range keyspaces {
+		if k.id >= nextID {
+			return nil, 0, errors.Errorf("pebble: corrupt %s: keyspace ID %d >= next ID %d",
+				keyspacesFilename, errors.Safe(k.id), errors.Safe(nextID))
+		}
+	}
+	return keyspaces, nextID, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// reverseComparer orders non-empty keys in reverse. The empty key is ordered
+// first, as the sstable writer expects.
+var reverseComparer = &Comparer{
+	Compare: func(a, b []byte) int {
+		if len(a) == 0 || len(b) == 0 {
+			return len(a) - len(b)
+		}
+		return bytes.Compare(b, a)
+	},
+	Equal: bytes.Equal,
+	AbbreviatedKey: func(key []byte) uint64 {
+		return 0
+	},
+	Separator: func(dst, a, b []byte) []byte {
+		return append(dst, a...)
+	},
+	Successor: func(dst, a []byte) []byte {
+		return append(dst, a...)
+	},
+	Name: "pebble.test.reverse",
+}
+
+func keyspaceContents(t *testing.T, d *DB) string {
+	iter := d.NewIter(nil)
+	var parts []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	return strings.Join(parts, " ")
+}
+
+func TestKeyspaces(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: FormatNewest,
+		Keyspaces: map[string]*Options{
+			"reverse": {Comparer: reverseComparer},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	fwd, err := d.CreateKeyspace("forward", nil)
+	require.NoError(t, err)
+	rev, err := d.CreateKeyspace("reverse", opts.Keyspaces["reverse"])
+	require.NoError(t, err)
+	_, err = d.CreateKeyspace("forward", nil)
+	require.EqualError(t, err, `pebble: keyspace "forward" already exists`)
+	_, err = fwd.CreateKeyspace("nested", nil)
+	require.Error(t, err)
+	require.EqualError(t, fwd.Close(), "pebble: a keyspace is closed along with its DB")
+	require.Equal(t, []string{"forward", "reverse"}, d.Keyspaces())
+	ks, err := d.Keyspace("reverse")
+	require.NoError(t, err)
+	require.Equal(t, rev, ks)
+	_, err = d.Keyspace("missing")
+	require.Equal(t, ErrKeyspaceNotFound, err)
+
+	// The keyspaces are independent of each other and of the DB, and a
+	// keyspace may be flushed independently.
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte("db"), nil))
+		require.NoError(t, fwd.Set([]byte(k), []byte("fwd"), nil))
+		require.NoError(t, rev.Set([]byte(k), []byte("rev"), Sync))
+	}
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.NoError(t, fwd.Flush())
+	require.NoError(t, fwd.Set([]byte("d"), []byte("fwd"), nil))
+
+	check := func() {
+		require.Equal(t, "a=db c=db", keyspaceContents(t, d))
+		require.Equal(t, "a=fwd b=fwd c=fwd d=fwd", keyspaceContents(t, fwd))
+		require.Equal(t, "c=rev b=rev a=rev", keyspaceContents(t, rev))
+	}
+	check()
+
+	// The unflushed batches of the keyspaces are recovered from the DB's WAL
+	// when the DB is reopened.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	fwd, err = d.Keyspace("forward")
+	require.NoError(t, err)
+	rev, err = d.Keyspace("reverse")
+	require.NoError(t, err)
+	check()
+	require.NoError(t, d.Close())
+
+	// A keyspace must be opened with the comparer it was created with.
+	_, err = Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "comparer name"), err.Error())
+
+	// A read-only DB opens its keyspaces read-only.
+	opts.ReadOnly = true
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	rev, err = d.Keyspace("reverse")
+	require.NoError(t, err)
+	require.Equal(t, "c=rev b=rev a=rev", keyspaceContents(t, rev))
+	require.Equal(t, ErrReadOnly, rev.Set([]byte("e"), nil, nil))
+	require.NoError(t, d.Close())
+}
+
+func TestKeyspaceDrop(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	ks, err := d.CreateKeyspace("ks", nil)
+	require.NoError(t, err)
+	require.NoError(t, ks.Set([]byte("a"), []byte("1"), nil))
+	dirname := ks.dirname
+	_, err = mem.Stat(dirname)
+	require.NoError(t, err)
+
+	// Dropping a keyspace deletes its files, and a keyspace created with the
+	// same name does not observe the dropped keyspace's batches, either before
+	// or after the DB is reopened.
+	require.NoError(t, d.DropKeyspace("ks"))
+	require.Equal(t, ErrKeyspaceNotFound, d.DropKeyspace("ks"))
+	_, err = mem.Stat(dirname)
+	require.Error(t, err)
+	ks, err = d.CreateKeyspace("ks", nil)
+	require.NoError(t, err)
+	require.NotEqual(t, dirname, ks.dirname)
+	require.Equal(t, "", keyspaceContents(t, ks))
+	require.NoError(t, ks.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	ks, err = d.Keyspace("ks")
+	require.NoError(t, err)
+	require.Equal(t, "b=2", keyspaceContents(t, ks))
+
+	// The directory of a keyspace which was not recorded, for example due to
+	// a crash while it was being created, is removed when the DB is opened.
+	orphan := mem.PathJoin(keyspacesDirname, "000099")
+	require.NoError(t, mem.MkdirAll(orphan, 0755))
+	require.NoError(t, d.Close())
+	d, err = Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	_, err = mem.Stat(orphan)
+	require.Error(t, err)
+	require.NoError(t, d.Close())
+}
+
+func TestKeyspaceLogRetention(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	ks, err := d.CreateKeyspace("ks", nil)
+	require.NoError(t, err)
+	require.NoError(t, ks.Set([]byte("a"), []byte("1"), nil))
+
+	// The log containing the keyspace's batch is retained until the keyspace
+	// is flushed, which the DB's flushes trigger in the background.
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Flush())
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		d.mu.Lock()
+		logs := append([]FileNum(nil), d.mu.log.queue...)
+		d.mu.Unlock()
+		if len(logs) == 1 && logs[0] == d.currentLogNum() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("logs %s retained", logs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	require.NoError(t, d.Close())
+	d, err = Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	ks, err = d.Keyspace("ks")
+	require.NoError(t, err)
+	require.Equal(t, "a=1", keyspaceContents(t, ks))
+	require.NoError(t, d.Close())
+}
@@ -136,9 +136,10 @@ func (m *simpleMergingIter) step() bool {
 			// order so the lastLevel must not be lower.
 			if m.lastLevel > item.index {
 				lastLevel := m.levels[m.lastLevel]
-				m.err = errors.Errorf("found InternalKey %s in %s and InternalKey %s in %s",
-					item.key.Pretty(m.formatKey), l.iter, m.lastKey.Pretty(m.formatKey),
-					lastLevel.iter)
+				m.err = newCheckLevelsViolation(CheckLevelsSeqNumInversion,
+					errors.Errorf("found InternalKey %s in %s and InternalKey %s in %s",
+						item.key.Pretty(m.formatKey), l.iter, m.lastKey.Pretty(m.formatKey),
+						lastLevel.iter))
 				return false
 			}
 			m.lastLevel = item.index
@@ -180,9 +181,10 @@ func (m *simpleMergingIter) step() bool {
 			case InternalKeyKindMerge:
 				m.err = m.valueMerger.MergeOlder(item.value)
 			default:
-				m.err = errors.Errorf("pebble: invalid internal key kind %s in %s",
-					item.key.Pretty(m.formatKey),
-					l.iter)
+				m.err = newCheckLevelsViolation(CheckLevelsMergeError,
+					errors.Errorf("pebble: invalid internal key kind %s in %s",
+						item.key.Pretty(m.formatKey),
+						l.iter))
 				return false
 			}
 		} else if item.key.Kind() == InternalKeyKindMerge && m.err == nil {
@@ -190,8 +192,9 @@ func (m *simpleMergingIter) step() bool {
 			m.valueMerger, m.err = m.merge(item.key.UserKey, item.value)
 		}
 		if m.err != nil {
-			m.err = errors.Wrapf(m.err, "merge processing error on key %s in %s",
-				item.key.Pretty(m.formatKey), l.iter)
+			m.err = newCheckLevelsViolation(CheckLevelsMergeError,
+				errors.Wrapf(m.err, "merge processing error on key %s in %s",
+					item.key.Pretty(m.formatKey), l.iter))
 			return false
 		}
 		// Is this point covered by a tombstone at a lower level? Note that all these
@@ -208,9 +211,10 @@ func (m *simpleMergingIter) step() bool {
 			if (lvl.smallestUserKey == nil || m.heap.cmp(lvl.smallestUserKey, item.key.UserKey) <= 0) &&
 				lvl.tombstone.Contains(m.heap.cmp, item.key.UserKey) {
 				if lvl.tombstone.Deletes(item.key.SeqNum()) {
-					m.err = errors.Errorf("tombstone %s in %s deletes key %s in %s",
-						lvl.tombstone.Pretty(m.formatKey), lvl.iter, item.key.Pretty(m.formatKey),
-						l.iter)
+					m.err = newCheckLevelsViolation(CheckLevelsSeqNumInversion,
+						errors.Errorf("tombstone %s in %s deletes key %s in %s",
+							lvl.tombstone.Pretty(m.formatKey), lvl.iter, item.key.Pretty(m.formatKey),
+							l.iter))
 					return false
 				}
 			}
@@ -230,8 +234,9 @@ func (m *simpleMergingIter) step() bool {
 		// next sstable in the level, in which case item.key is previous sstable's
 		// last point key.
 		if base.InternalCompare(m.heap.cmp, item.key, *l.iterKey) >= 0 {
-			m.err = errors.Errorf("out of order keys %s >= %s in %s",
-				item.key.Pretty(m.formatKey), l.iterKey.Pretty(m.formatKey), l.iter)
+			m.err = newCheckLevelsViolation(CheckLevelsKeyOrder,
+				errors.Errorf("out of order keys %s >= %s in %s",
+					item.key.Pretty(m.formatKey), l.iterKey.Pretty(m.formatKey), l.iter))
 			return false
 		}
 		item.key.Trailer = l.iterKey.Trailer
@@ -257,8 +262,9 @@ func (m *simpleMergingIter) step() bool {
 				m.err = closer.Close()
 			}
 			if m.err != nil {
-				m.err = errors.Wrapf(m.err, "merge processing error on key %s in %s",
-					item.key.Pretty(m.formatKey), lastRecordMsg)
+				m.err = newCheckLevelsViolation(CheckLevelsMergeError,
+					errors.Wrapf(m.err, "merge processing error on key %s in %s",
+						item.key.Pretty(m.formatKey), lastRecordMsg))
 			}
 			m.valueMerger = nil
 		}
@@ -328,10 +334,11 @@ func iterateAndCheckTombstones(
 	lastTombstone := tombstoneWithLevel{}
 	for _, t := range tombstones {
 		if cmp(lastTombstone.Start.UserKey, t.Start.UserKey) == 0 && lastTombstone.level > t.level {
-			return errors.Errorf("encountered tombstone %s in %s"+
-				" that has a lower seqnum than the same tombstone in %s",
-				t.Tombstone.Pretty(formatKey), levelOrMemtable(t.lsmLevel, t.fileNum),
-				levelOrMemtable(lastTombstone.lsmLevel, lastTombstone.fileNum))
+			return newCheckLevelsViolation(CheckLevelsSeqNumInversion,
+				errors.Errorf("encountered tombstone %s in %s"+
+					" that has a lower seqnum than the same tombstone in %s",
+					t.Tombstone.Pretty(formatKey), levelOrMemtable(t.lsmLevel, t.fileNum),
+					levelOrMemtable(lastTombstone.lsmLevel, lastTombstone.fileNum)))
 		}
 		lastTombstone = t
 	}
@@ -431,33 +438,9 @@ func checkRangeTombstones(c *checkConfig) error {
 	return iterateAndCheckTombstones(c.cmp, c.formatKey, tombstones)
 }
 
-// TODO(sbhola): mostly copied from compaction.go: refactor and reuse?
 func getAtomicUnitBounds(cmp Compare, files []*fileMetadata, index int) (lower, upper []byte) {
-	lower = files[index].Smallest.UserKey
-	upper = files[index].Largest.UserKey
-	for i := index; i > 0; i-- {
-		cur := files[i]
-		prev := files[i-1]
-		if cmp(prev.Largest.UserKey, cur.Smallest.UserKey) < 0 {
-			break
-		}
-		if prev.Largest.Trailer == InternalKeyRangeDeleteSentinel {
-			break
-		}
-		lower = prev.Smallest.UserKey
-	}
-	for i := index + 1; i < len(files); i++ {
-		cur := files[i-1]
-		next := files[i]
-		if cmp(cur.Largest.UserKey, next.Smallest.UserKey) < 0 {
-			break
-		}
-		if cur.Largest.Trailer == InternalKeyRangeDeleteSentinel {
-			break
-		}
-		upper = next.Largest.UserKey
-	}
-	return
+	start, end := manifest.AtomicUnit(cmp, files, index)
+	return files[start].Smallest.UserKey, files[end-1].Largest.UserKey
 }
 
 func levelOrMemtable(lsmLevel int, fileNum FileNum) string {
@@ -494,8 +477,9 @@ func addTombstonesFromIter(
 		// be ordered and fragmented on disk. But we anyways check for memtables,
 		// rangeDelV1 as well.
 		if prevTombstone.Overlaps(cmp, t) != -1 {
-			return nil, errors.Errorf("unordered or unfragmented range delete tombstones %s, %s in %s",
-				prevTombstone.Pretty(formatKey), t.Pretty(formatKey), levelOrMemtable(lsmLevel, fileNum))
+			return nil, newCheckLevelsViolation(CheckLevelsTombstoneFragmentation,
+				errors.Errorf("unordered or unfragmented range delete tombstones %s, %s in %s",
+					prevTombstone.Pretty(formatKey), t.Pretty(formatKey), levelOrMemtable(lsmLevel, fileNum)))
 		}
 		// No need to copy key.UserKey bytes since blockIter gives key stability for
 		// range delete keys.
@@ -583,12 +567,81 @@ type CheckLevelsStats struct {
 	NumTombstones int
 }
 
+// CheckLevelsViolationKind identifies the invariant violated by a
+// CheckLevelsViolation.
+type CheckLevelsViolationKind int
+
+const (
+	// CheckLevelsKeyOrder indicates that the point keys of an sstable or
+	// memtable, or of successive sstables in a level, are not ordered.
+	CheckLevelsKeyOrder CheckLevelsViolationKind = iota
+	// CheckLevelsFileOverlap indicates that the sstables of a level (or L0
+	// sublevel) are not ordered or overlap.
+	CheckLevelsFileOverlap
+	// CheckLevelsSeqNumInversion indicates that a point or range tombstone has a
+	// higher seqnum than a point or range tombstone for the same user key at a
+	// higher level, including a range tombstone deleting a point at a higher
+	// level.
+	CheckLevelsSeqNumInversion
+	// CheckLevelsTombstoneFragmentation indicates that the range tombstones of
+	// an sstable or memtable are not ordered and fragmented.
+	CheckLevelsTombstoneFragmentation
+	// CheckLevelsMergeError indicates that a series of MERGE records could not
+	// be processed.
+	CheckLevelsMergeError
+)
+
+func (k CheckLevelsViolationKind) String() string {
+	switch k {
+	case CheckLevelsKeyOrder:
+		return "key-order"
+	case CheckLevelsFileOverlap:
+		return "file-overlap"
+	case CheckLevelsSeqNumInversion:
+		return "seqnum-inversion"
+	case CheckLevelsTombstoneFragmentation:
+		return "tombstone-fragmentation"
+	case CheckLevelsMergeError:
+		return "merge-error"
+	default:
+		return fmt.Sprintf("CheckLevelsViolationKind(%d)", int(k))
+	}
+}
+
+// CheckLevelsViolation is the error returned by CheckLevels when an invariant
+// of the LSM is violated. It can be retrieved from the returned error using
+// errors.As. Errors returned by CheckLevels which are not violations, such as
+// IO errors, are returned as is.
+type CheckLevelsViolation struct {
+	// Kind is the invariant that was violated.
+	Kind CheckLevelsViolationKind
+	// Err describes the violation, including the keys and levels involved.
+	Err error
+}
+
+func newCheckLevelsViolation(kind CheckLevelsViolationKind, err error) error {
+	return &CheckLevelsViolation{Kind: kind, Err: err}
+}
+
+// Error implements the error interface.
+func (v *CheckLevelsViolation) Error() string {
+	return v.Err.Error()
+}
+
+// Unwrap returns the error describing the violation.
+func (v *CheckLevelsViolation) Unwrap() error {
+	return v.Err
+}
+
 // CheckLevels checks:
 // - Every entry in the DB is consistent with the level invariant. See the
 //   comment at the top of the file.
+// - The sstables of each level are ordered and do not overlap.
 // - Point keys in sstables are ordered.
 // - Range delete tombstones in sstables are ordered and fragmented.
 // - Successful processing of all MERGE records.
+//
+// The first violation found is returned as a *CheckLevelsViolation.
 func (d *DB) CheckLevels(stats *CheckLevelsStats) error {
 	// Grab and reference the current readState.
 	readState := d.loadReadState()
@@ -644,6 +697,9 @@ func checkLevelsInternal(c *checkConfig) (err error) {
 	}
 
 	current := c.readState.current
+	if err := current.CheckOrdering(c.cmp, c.formatKey); err != nil {
+		return newCheckLevelsViolation(CheckLevelsFileOverlap, err)
+	}
 	// Determine the final size for mlevels so that there are no more
 	// reallocations. levelIter will hold a pointer to elements in mlevels.
 	start := len(mlevels)
@@ -667,7 +723,7 @@ func checkLevelsInternal(c *checkConfig) (err error) {
 		}
 		iterOpts := IterOptions{logger: c.logger}
 		li := &levelIter{}
-		li.init(iterOpts, c.cmp, c.newIters, current.L0Sublevels.Levels[sublevel],
+		li.init(iterOpts, c.cmp, nil, c.newIters, current.L0Sublevels.Levels[sublevel],
 			manifest.L0Sublevel(sublevel), nil)
 		li.initRangeDel(&mlevelAlloc[0].rangeDelIter)
 		li.initSmallestLargestUserKey(&mlevelAlloc[0].smallestUserKey, nil, nil)
@@ -680,7 +736,7 @@ func checkLevelsInternal(c *checkConfig) (err error) {
 		}
 		iterOpts := IterOptions{logger: c.logger}
 		li := &levelIter{}
-		li.init(iterOpts, c.cmp, c.newIters, current.Levels[level], manifest.Level(level), nil)
+		li.init(iterOpts, c.cmp, nil, c.newIters, current.Levels[level], manifest.Level(level), nil)
 		li.initRangeDel(&mlevelAlloc[0].rangeDelIter)
 		li.initSmallestLargestUserKey(&mlevelAlloc[0].smallestUserKey, nil, nil)
 		mlevelAlloc[0].iter = li
@@ -250,6 +250,10 @@ func TestCheckLevelsCornerCases(t *testing.T) {
 				formatKey: formatKey,
 			}
 			if err := checkLevelsInternal(c); err != nil {
+				var v *CheckLevelsViolation
+				if errors.As(err, &v) {
+					return fmt.Sprintf("%s: %s", v.Kind, err)
+				}
 				return err.Error()
 			}
 			return ""
@@ -21,6 +21,10 @@ type tableNewIters func(
 	meta *fileMetadata, opts *IterOptions, bytesIterated *uint64,
 ) (internalIterator, internalIterator, error)
 
+// tableNewRangeKeyIter creates a new iterator over the range keys in the given
+// file. Returns nil if the file does not contain any range keys.
+type tableNewRangeKeyIter func(meta *fileMetadata) (internalIterator, error)
+
 // levelIter provides a merged view of the sstables in a level.
 //
 // levelIter is used during compaction and as part of the Iterator
@@ -46,6 +50,9 @@ type tableNewIters func(
 type levelIter struct {
 	logger Logger
 	cmp    Compare
+	// split is used by SeekPrefixGE to skip tables which cannot contain the
+	// seek prefix. It may be nil.
+	split Split
 	// The lower/upper bounds for iteration as specified at creation or the most
 	// recent call to SetBounds.
 	lower []byte
@@ -142,19 +149,21 @@ var _ base.InternalIterator = (*levelIter)(nil)
 func newLevelIter(
 	opts IterOptions,
 	cmp Compare,
+	split Split,
 	newIters tableNewIters,
 	files []*fileMetadata,
 	level manifest.Level,
 	bytesIterated *uint64,
 ) *levelIter {
 	l := &levelIter{}
-	l.init(opts, cmp, newIters, files, level, bytesIterated)
+	l.init(opts, cmp, split, newIters, files, level, bytesIterated)
 	return l
 }
 
 func (l *levelIter) init(
 	opts IterOptions,
 	cmp Compare,
+	split Split,
 	newIters tableNewIters,
 	files []*fileMetadata,
 	level manifest.Level,
@@ -166,7 +175,11 @@ func (l *levelIter) init(
 	l.lower = opts.LowerBound
 	l.upper = opts.UpperBound
 	l.tableOpts.TableFilter = opts.TableFilter
+	l.tableOpts.Timestamp = opts.Timestamp
+	l.tableOpts.ctx = opts.ctx
+	l.tableOpts.rangeDelBudget = opts.rangeDelBudget
 	l.cmp = cmp
+	l.split = split
 	l.index = -1
 	l.newIters = newIters
 	l.files = files
@@ -363,7 +376,27 @@ func (l *levelIter) SeekPrefixGE(prefix, key []byte) (*InternalKey, []byte) {
 
 	// NB: the top-level Iterator has already adjusted key based on
 	// IterOptions.LowerBound.
-	if !l.loadFile(l.findFileGE(key), 1) {
+	index := l.findFileGE(key)
+	if l.split != nil && index < len(l.files) {
+		// If the prefix of the smallest key in the table is greater than the
+		// seek prefix, then every key with the seek prefix is less than the
+		// smallest key in the table and neither this table nor any later table in
+		// the level can contain such keys. We can avoid loading the table
+		// entirely. Unlike the synthetic boundary key generated below, there is no
+		// need to keep the table's range tombstones open: they are implicitly
+		// truncated to the table's bounds and cannot delete keys with the seek
+		// prefix.
+		smallest := l.files[index].Smallest.UserKey
+		if l.cmp(prefix, smallest[:l.split(smallest)]) < 0 {
+			// Close() will set levelIter.err if an error occurs.
+			_ = l.Close()
+			l.index = index
+			l.smallestBoundary = nil
+			l.largestBoundary = nil
+			return nil, nil
+		}
+	}
+	if !l.loadFile(index, 1) {
 		return nil, nil
 	}
 	if key, val := l.iter.SeekPrefixGE(prefix, key); key != nil {
@@ -632,6 +665,17 @@ func (l *levelIter) SetBounds(lower, upper []byte) {
 	l.iter.SetBounds(l.tableOpts.LowerBound, l.tableOpts.UpperBound)
 }
 
+// setTableFilters replaces the TableFilter and Timestamp used to filter the
+// tables opened by the levelIter. The current table, which was opened with the
+// previous filters, is closed and will be reopened when the levelIter is next
+// positioned.
+func (l *levelIter) setTableFilters(filter func(userProps map[string]string) bool, ts uint64) {
+	l.tableOpts.TableFilter = filter
+	l.tableOpts.Timestamp = ts
+	// Close() will set levelIter.err if an error occurs.
+	_ = l.Close()
+}
+
 func (l *levelIter) String() string {
 	if l.index >= 0 && l.index < len(l.files) {
 		return fmt.Sprintf("%s: fileNum=%s", l.level, l.iter.String())
@@ -80,7 +80,7 @@ func TestLevelIter(t *testing.T) {
 				}
 			}
 
-			iter := newLevelIter(opts, DefaultComparer.Compare,
+			iter := newLevelIter(opts, DefaultComparer.Compare, nil,
 				newIters, files, manifest.Level(level), nil)
 			defer iter.Close()
 			// Fake up the range deletion initialization.
@@ -121,7 +121,7 @@ func TestLevelIter(t *testing.T) {
 				return newIters(meta, opts, nil)
 			}
 
-			iter := newLevelIter(opts, DefaultComparer.Compare,
+			iter := newLevelIter(opts, DefaultComparer.Compare, nil,
 				newIters2, files, manifest.Level(level), nil)
 			iter.SeekGE([]byte(key))
 			lower, upper := tableOpts.GetLowerBound(), tableOpts.GetUpperBound()
@@ -259,7 +259,7 @@ func TestLevelIterBoundaries(t *testing.T) {
 			return lt.runBuild(d)
 
 		case "iter":
-			iter := newLevelIter(IterOptions{}, DefaultComparer.Compare,
+			iter := newLevelIter(IterOptions{}, DefaultComparer.Compare, nil,
 				lt.newIters, lt.files, manifest.Level(level), nil)
 			defer iter.Close()
 			// Fake up the range deletion initialization.
@@ -334,7 +334,7 @@ func TestLevelIterSeek(t *testing.T) {
 
 		case "iter":
 			iter := &levelIterTestIter{
-				levelIter: newLevelIter(IterOptions{}, DefaultComparer.Compare,
+				levelIter: newLevelIter(IterOptions{}, DefaultComparer.Compare, lt.cmp.Split,
 					lt.newIters, lt.files, manifest.Level(level), nil),
 			}
 			defer iter.Close()
@@ -430,7 +430,7 @@ func BenchmarkLevelIterSeekGE(b *testing.B) {
 								iter, err := readers[meta.FileNum].NewIter(nil /* lower */, nil /* upper */)
 								return iter, nil, err
 							}
-							l := newLevelIter(IterOptions{}, DefaultComparer.Compare,
+							l := newLevelIter(IterOptions{}, DefaultComparer.Compare, nil,
 								newIters, files, manifest.Level(level), nil)
 							rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
 
@@ -460,7 +460,7 @@ func BenchmarkLevelIterNext(b *testing.B) {
 								iter, err := readers[meta.FileNum].NewIter(nil /* lower */, nil /* upper */)
 								return iter, nil, err
 							}
-							l := newLevelIter(IterOptions{}, DefaultComparer.Compare,
+							l := newLevelIter(IterOptions{}, DefaultComparer.Compare, nil,
 								newIters, files, manifest.Level(level), nil)
 
 							b.ResetTimer()
@@ -493,7 +493,7 @@ func BenchmarkLevelIterPrev(b *testing.B) {
 								iter, err := readers[meta.FileNum].NewIter(nil /* lower */, nil /* upper */)
 								return iter, nil, err
 							}
-							l := newLevelIter(IterOptions{}, DefaultComparer.Compare,
+							l := newLevelIter(IterOptions{}, DefaultComparer.Compare, nil,
 								newIters, files, manifest.Level(level), nil)
 
 							b.ResetTimer()
@@ -0,0 +1,184 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrDeadlock is returned when a PessimisticTransaction cannot acquire a lock
+// because doing so would deadlock with other transactions. The transaction
+// should be closed, releasing its locks, and retried.
+var ErrDeadlock = errors.New("pebble: deadlock")
+
+// LockMode is the mode in which a PessimisticTransaction holds a lock.
+type LockMode int8
+
+const (
+	// LockShared is compatible with the shared locks of other transactions, and
+	// is acquired for reads.
+	LockShared LockMode = iota
+	// LockExclusive is incompatible with the locks of other transactions, and
+	// is acquired for writes.
+	LockExclusive
+)
+
+func (m LockMode) String() string {
+	switch m {
+	case LockShared:
+		return "shared"
+	case LockExclusive:
+		return "exclusive"
+	}
+	return "unknown"
+}
+
+// lockOwner identifies the holder of a lock.
+type lockOwner = *PessimisticTransaction
+
+// txnLock is a lock on either the single key start, or on the span
+// [start,end) where a nil start or end is unbounded.
+type txnLock struct {
+	owner      lockOwner
+	mode       LockMode
+	start, end []byte
+	point      bool
+}
+
+// overlaps returns true if the keys locked by l and o intersect.
+func (l *txnLock) overlaps(cmp Compare, o *txnLock) bool {
+	switch {
+	case l.point && o.point:
+		return cmp(l.start, o.start) == 0
+	case l.point:
+		return o.contains(cmp, l.start)
+	case o.point:
+		return l.contains(cmp, o.start)
+	}
+	return (l.start == nil || o.end == nil || cmp(l.start, o.end) < 0) &&
+		(o.start == nil || l.end == nil || cmp(o.start, l.end) < 0)
+}
+
+// contains returns true if the key is locked by l.
+func (l *txnLock) contains(cmp Compare, key []byte) bool {
+	if l.point {
+		return cmp(l.start, key) == 0
+	}
+	return (l.start == nil || cmp(l.start, key) <= 0) &&
+		(l.end == nil || cmp(key, l.end) < 0)
+}
+
+// covers returns true if every key locked by o is locked by l, in a mode at
+// least as strong.
+func (l *txnLock) covers(cmp Compare, o *txnLock) bool {
+	if l.mode < o.mode {
+		return false
+	}
+	if o.point {
+		return l.contains(cmp, o.start)
+	}
+	if l.point {
+		return false
+	}
+	return (l.start == nil || (o.start != nil && cmp(l.start, o.start) <= 0)) &&
+		(l.end == nil || (o.end != nil && cmp(o.end, l.end) <= 0))
+}
+
+// lockTable holds the locks of the pessimistic transactions on a DB. A
+// transaction which requests a lock conflicting with the locks held by other
+// transactions waits until they are released. A waits-for graph is maintained
+// between the transactions, and a request which would close a cycle in the
+// graph fails with ErrDeadlock rather than waiting.
+//
+// The locks are kept in a slice which is searched linearly. Transactions are
+// expected to hold relatively few locks at a time.
+type lockTable struct {
+	cmp  Compare
+	mu   sync.Mutex
+	cond sync.Cond
+	// locks holds every lock held by every transaction.
+	locks []*txnLock
+	// waitsFor maps a waiting transaction to the transactions holding the
+	// locks conflicting with its request.
+	waitsFor map[lockOwner][]lockOwner
+}
+
+func (lt *lockTable) init(cmp Compare) {
+	lt.cmp = cmp
+	lt.cond.L = &lt.mu
+	lt.waitsFor = make(map[lockOwner][]lockOwner)
+}
+
+// acquire acquires the lock l for its owner, waiting for any conflicting
+// locks held by other transactions to be released.
+func (lt *lockTable) acquire(l *txnLock) error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	defer delete(lt.waitsFor, l.owner)
+
+	for {
+		var held bool
+		var holders []lockOwner
+		for _, o := range lt.locks {
+			if o.owner == l.owner {
+				held = held || o.covers(lt.cmp, l)
+				continue
+			}
+			if (l.mode == LockExclusive || o.mode == LockExclusive) && o.overlaps(lt.cmp, l) {
+				holders = append(holders, o.owner)
+			}
+		}
+		if len(holders) == 0 {
+			if !held {
+				lt.locks = append(lt.locks, l)
+			}
+			return nil
+		}
+		if lt.reaches(holders, l.owner) {
+			return ErrDeadlock
+		}
+		lt.waitsFor[l.owner] = holders
+		lt.cond.Wait()
+	}
+}
+
+// reaches returns true if target is reachable from any of the owners in the
+// waits-for graph. lt.mu must be held.
+func (lt *lockTable) reaches(owners []lockOwner, target lockOwner) bool {
+	visited := make(map[lockOwner]bool)
+	for len(owners) > 0 {
+		o := owners[len(owners)-1]
+		owners = owners[:len(owners)-1]
+		if o == target {
+			return true
+		}
+		if visited[o] {
+			continue
+		}
+		visited[o] = true
+		owners = append(owners, lt.waitsFor[o]...)
+	}
+	return false
+}
+
+// release releases every lock held by owner, waking any waiting transactions.
+func (lt *lockTable) release(owner lockOwner) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	n := 0
+	for _, l := range lt.locks {
+		if l.owner != owner {
+			lt.locks[n] = l
+			n++
+		}
+	}
+	for i := n; i < len(lt.locks); i++ {
+		lt.locks[i] = nil
+	}
+	lt.locks = lt.locks[:n]
+	lt.cond.Broadcast()
+}
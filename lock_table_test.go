@@ -0,0 +1,108 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnLockOverlaps(t *testing.T) {
+	cmp := DefaultComparer.Compare
+	point := func(k string) *txnLock {
+		return &txnLock{start: []byte(k), point: true}
+	}
+	span := func(start, end string) *txnLock {
+		l := &txnLock{}
+		if start != "" {
+			l.start = []byte(start)
+		}
+		if end != "" {
+			l.end = []byte(end)
+		}
+		return l
+	}
+
+	testCases := []struct {
+		a, b     *txnLock
+		expected bool
+	}{
+		{point("a"), point("a"), true},
+		{point("a"), point("b"), false},
+		{point("b"), span("a", "c"), true},
+		{point("c"), span("a", "c"), false},
+		{point("a"), span("b", "c"), false},
+		{point("z"), span("b", ""), true},
+		{point("a"), span("", "b"), true},
+		{span("a", "c"), span("b", "d"), true},
+		{span("a", "b"), span("b", "c"), false},
+		{span("", ""), span("b", "c"), true},
+		{span("", "b"), span("b", ""), false},
+	}
+	for _, c := range testCases {
+		require.Equal(t, c.expected, c.a.overlaps(cmp, c.b), "%+v %+v", c.a, c.b)
+		require.Equal(t, c.expected, c.b.overlaps(cmp, c.a), "%+v %+v", c.b, c.a)
+	}
+
+	shared := span("a", "d")
+	exclusive := span("", "")
+	exclusive.mode = LockExclusive
+	require.True(t, shared.covers(cmp, point("a")))
+	require.False(t, shared.covers(cmp, point("d")))
+	require.True(t, shared.covers(cmp, span("b", "c")))
+	require.False(t, shared.covers(cmp, span("b", "")))
+	require.False(t, point("a").covers(cmp, span("a", "b")))
+	require.True(t, exclusive.covers(cmp, span("", "")))
+	require.False(t, shared.covers(cmp, exclusive))
+}
+
+func TestLockTable(t *testing.T) {
+	var lt lockTable
+	lt.init(DefaultComparer.Compare)
+	t1, t2 := &PessimisticTransaction{}, &PessimisticTransaction{}
+	lock := func(owner lockOwner, key string, mode LockMode) *txnLock {
+		return &txnLock{owner: owner, mode: mode, start: []byte(key), point: true}
+	}
+
+	// Shared locks are compatible, and locks which are already held are not
+	// added again.
+	require.NoError(t, lt.acquire(lock(t1, "a", LockShared)))
+	require.NoError(t, lt.acquire(lock(t2, "a", LockShared)))
+	require.NoError(t, lt.acquire(lock(t1, "a", LockShared)))
+	require.Equal(t, 2, len(lt.locks))
+
+	// Upgrading t1's lock must wait for t2's shared lock to be released, and
+	// t2 upgrading its own lock would deadlock.
+	acquired := make(chan error)
+	go func() {
+		acquired <- lt.acquire(lock(t1, "a", LockExclusive))
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("lock unexpectedly acquired: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.Equal(t, ErrDeadlock, lt.acquire(lock(t2, "a", LockExclusive)))
+
+	lt.release(t2)
+	require.NoError(t, <-acquired)
+
+	// t2 now waits for t1's exclusive lock.
+	go func() {
+		acquired <- lt.acquire(lock(t2, "a", LockShared))
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("lock unexpectedly acquired: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	lt.release(t1)
+	require.NoError(t, <-acquired)
+	lt.release(t2)
+	require.Equal(t, 0, len(lt.locks))
+	require.Equal(t, 0, len(lt.waitsFor))
+}
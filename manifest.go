@@ -0,0 +1,231 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ManifestDeletedFile describes the removal of an sstable from a level by a
+// version edit.
+type ManifestDeletedFile struct {
+	Level   int
+	FileNum FileNum
+}
+
+// ManifestNewFile describes the addition of an sstable to a level by a version
+// edit. An sstable which is moved between levels is both deleted from its
+// old level and added to its new level.
+type ManifestNewFile struct {
+	Level int
+	TableInfo
+	// CreationTime is the time at which the sstable was created, in seconds
+	// since the Unix epoch, or zero if it is unknown.
+	CreationTime int64 `json:",omitempty"`
+}
+
+// ManifestEdit is a version edit decoded from a MANIFEST. Each version edit
+// records a change to the LSM (the sstables added and removed by a flush,
+// compaction or ingestion) along with other on-disk state. The first version
+// edit in a MANIFEST holds a snapshot of the LSM and state at the time the
+// MANIFEST was created.
+//
+// Fields which are not set by the edit are zero.
+type ManifestEdit struct {
+	// Offset is the offset of the edit's record within the MANIFEST.
+	Offset int64
+	// ComparerName is the name of the DB's comparer. It is only set in the
+	// first edit of a MANIFEST.
+	ComparerName string `json:",omitempty"`
+	// MinUnflushedLogNum is the smallest WAL file number containing
+	// mutations which have not been flushed to an sstable.
+	MinUnflushedLogNum FileNum `json:",omitempty"`
+	// ObsoletePrevLogNum is a historic artifact from LevelDB which is not used
+	// by Pebble.
+	ObsoletePrevLogNum uint64 `json:",omitempty"`
+	// NextFileNum is the next file number to be allocated.
+	NextFileNum FileNum `json:",omitempty"`
+	// LastSeqNum is an upper bound on the sequence numbers assigned in
+	// flushed WALs.
+	LastSeqNum uint64 `json:",omitempty"`
+	// FormatMajorVersion is set in the first edit of a MANIFEST and when the
+	// format major version of the DB is ratcheted.
+	FormatMajorVersion FormatMajorVersion `json:",omitempty"`
+	// DeletedFiles holds the sstables removed from the LSM, sorted by level
+	// and file number.
+	DeletedFiles []ManifestDeletedFile `json:",omitempty"`
+	// NewFiles holds the sstables added to the LSM, in the order in which
+	// they appear in the edit.
+	NewFiles []ManifestNewFile `json:",omitempty"`
+}
+
+func makeManifestEdit(offset int64, ve *versionEdit) *ManifestEdit {
+	e := &ManifestEdit{
+		Offset:             offset,
+		ComparerName:       ve.ComparerName,
+		MinUnflushedLogNum: ve.MinUnflushedLogNum,
+		ObsoletePrevLogNum: ve.ObsoletePrevLogNum,
+		NextFileNum:        ve.NextFileNum,
+		LastSeqNum:         ve.LastSeqNum,
+		FormatMajorVersion: FormatMajorVersion(ve.FormatMajorVersion),
+	}
+	for df := range ve.DeletedFiles {
+		e.DeletedFiles = append(e.DeletedFiles, ManifestDeletedFile{
+			Level:   df.Level,
+			FileNum: df.FileNum,
+		})
+	}
+	sort.Slice(e.DeletedFiles, func(i, j int) bool {
+		a, b := e.DeletedFiles[i], e.DeletedFiles[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		return a.FileNum < b.FileNum
+	})
+	for _, nf := range ve.NewFiles {
+		e.NewFiles = append(e.NewFiles, ManifestNewFile{
+			Level:        nf.Level,
+			TableInfo:    nf.Meta.TableInfo(),
+			CreationTime: nf.Meta.CreationTime,
+		})
+	}
+	return e
+}
+
+// Empty returns true if the edit does not set any fields. RocksDB may write
+// such edits.
+func (e *ManifestEdit) Empty() bool {
+	return e.ComparerName == "" && e.MinUnflushedLogNum == 0 && e.ObsoletePrevLogNum == 0 &&
+		e.NextFileNum == 0 && e.LastSeqNum == 0 && e.FormatMajorVersion == 0 &&
+		len(e.DeletedFiles) == 0 && len(e.NewFiles) == 0
+}
+
+// String implements fmt.Stringer, formatting keys with the default formatter.
+func (e *ManifestEdit) String() string {
+	return e.Format(base.DefaultFormatter)
+}
+
+// Format returns a human-readable rendering of the edit, one field per line,
+// formatting user keys with formatKey.
+func (e *ManifestEdit) Format(formatKey base.FormatKey) string {
+	var buf bytes.Buffer
+	if e.ComparerName != "" {
+		fmt.Fprintf(&buf, "comparer:     %s\n", e.ComparerName)
+	}
+	if e.MinUnflushedLogNum != 0 {
+		fmt.Fprintf(&buf, "log-num:       %d\n", e.MinUnflushedLogNum)
+	}
+	if e.ObsoletePrevLogNum != 0 {
+		fmt.Fprintf(&buf, "prev-log-num:  %d\n", e.ObsoletePrevLogNum)
+	}
+	if e.NextFileNum != 0 {
+		fmt.Fprintf(&buf, "next-file-num: %d\n", e.NextFileNum)
+	}
+	if e.LastSeqNum != 0 {
+		fmt.Fprintf(&buf, "last-seq-num:  %d\n", e.LastSeqNum)
+	}
+	if e.FormatMajorVersion != 0 {
+		fmt.Fprintf(&buf, "format-major-version: %d\n", e.FormatMajorVersion)
+	}
+	for _, df := range e.DeletedFiles {
+		fmt.Fprintf(&buf, "deleted:       L%d %s\n", df.Level, df.FileNum)
+	}
+	for _, nf := range e.NewFiles {
+		fmt.Fprintf(&buf, "added:         L%d %s:%d<#%d-#%d>[%s-%s]",
+			nf.Level, nf.FileNum, nf.Size, nf.SmallestSeqNum, nf.LargestSeqNum,
+			nf.Smallest.Pretty(formatKey), nf.Largest.Pretty(formatKey))
+		if nf.CreationTime != 0 {
+			fmt.Fprintf(&buf, " (%s)", time.Unix(nf.CreationTime, 0).UTC().Format(time.RFC3339))
+		}
+		buf.WriteString("\n")
+	}
+	if e.Empty() {
+		buf.WriteString("<empty>\n")
+	}
+	return buf.String()
+}
+
+// ManifestReader decodes the version edits of a MANIFEST, without requiring
+// the DB to be opened. A MANIFEST may be read while it is being appended to
+// by a DB, in which case the final edit may be incomplete.
+type ManifestReader struct {
+	rr *record.Reader
+}
+
+// NewManifestReader returns a ManifestReader which reads the MANIFEST
+// contained in r.
+func NewManifestReader(r io.Reader) *ManifestReader {
+	return &ManifestReader{rr: record.NewReader(r, 0 /* logNum */)}
+}
+
+// Next returns the next version edit in the MANIFEST. It returns io.EOF once
+// all of the edits have been read. An edit which is incomplete or corrupt
+// returns an error marked as ErrCorruption; an incomplete final edit is
+// expected if the MANIFEST was being written when it was read.
+func (r *ManifestReader) Next() (*ManifestEdit, error) {
+	offset := r.rr.Offset()
+	rec, err := r.rr.Next()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		if record.IsInvalidRecord(err) {
+			err = base.MarkCorruptionError(err)
+		}
+		return nil, errors.Wrapf(err, "pebble: reading MANIFEST record at offset %d", errors.Safe(offset))
+	}
+	var ve versionEdit
+	if err := ve.Decode(rec); err != nil {
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			err = base.MarkCorruptionError(err)
+		}
+		return nil, errors.Wrapf(err, "pebble: decoding MANIFEST record at offset %d", errors.Safe(offset))
+	}
+	return makeManifestEdit(offset, &ve), nil
+}
+
+// CurrentManifest returns the path of the current MANIFEST of the DB in
+// dirname, as named by its CURRENT file. The DB need not be open.
+func CurrentManifest(fs vfs.FS, dirname string) (string, error) {
+	name, _, err := readCurrentFile(fs, dirname)
+	if err != nil {
+		return "", err
+	}
+	return fs.PathJoin(dirname, name), nil
+}
+
+// ReadManifest decodes all of the version edits of the MANIFEST at path,
+// invoking fn for each. Reading stops at the first error returned by fn, which
+// is returned. An incomplete final edit is not considered an error, matching
+// the recovery performed by Open.
+func ReadManifest(fs vfs.FS, path string, fn func(e *ManifestEdit) error) error {
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := NewManifestReader(f)
+	for {
+		e, err := r.Next()
+		if err == io.EOF || errors.Is(err, ErrCorruption) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// ManifestRecoveryMode specifies how corruption of the MANIFEST is handled
+// when it is read during Open. Each version edit in the MANIFEST is
+// protected by the checksums of the record format, so a torn or corrupt edit
+// is detected when it is read.
+type ManifestRecoveryMode int
+
+const (
+	// ManifestRecoveryTolerateTornTail stops reading the MANIFEST at the first
+	// record which cannot be read, treating it as the end of the MANIFEST.
+	// This tolerates a torn write at the tail of the MANIFEST due to a crash.
+	// Open fails if a version edit is readable but cannot be decoded, or if
+	// no version edits can be read. This is the default.
+	ManifestRecoveryTolerateTornTail ManifestRecoveryMode = iota
+	// ManifestRecoveryTruncate also treats a version edit which cannot be
+	// decoded as the end of the MANIFEST. If no version edits can be read from
+	// the current MANIFEST, or it is missing, the DB is instead recovered from
+	// the newest readable previous MANIFEST, which is only retained if
+	// Options.NumPrevManifest is non-zero. The writes reflected by the edits
+	// which could not be read are lost, and Open fails if the sstables named
+	// by the recovered MANIFEST have since been deleted.
+	ManifestRecoveryTruncate
+)
+
+// String implements fmt.Stringer.
+func (m ManifestRecoveryMode) String() string {
+	switch m {
+	case ManifestRecoveryTolerateTornTail:
+		return "tolerate-torn-tail"
+	case ManifestRecoveryTruncate:
+		return "truncate"
+	}
+	return fmt.Sprintf("unknown(%d)", int(m))
+}
+
+func parseManifestRecoveryMode(s string) (ManifestRecoveryMode, error) {
+	for m := ManifestRecoveryTolerateTornTail; m <= ManifestRecoveryTruncate; m++ {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return 0, errors.Errorf("pebble: unknown MANIFEST recovery mode: %q", errors.Safe(s))
+}
+
+// recoverManifest reads the current MANIFEST of the DB in dirname during
+// Open, handling corruption as specified by Options.ManifestRecoveryMode. An
+// EventListener.ManifestRecovered event is emitted if the MANIFEST could not
+// be read in its entirety.
+func (vs *versionSet) recoverManifest(dirname string) (*manifestContents, error) {
+	name, fileNum, err := readCurrentFile(vs.fs, dirname)
+	if err != nil {
+		return nil, err
+	}
+	path := vs.fs.PathJoin(dirname, name)
+	mode := vs.opts.ManifestRecoveryMode
+	m, err := readManifestFile(vs.fs, dirname, name, fileNum, vs.cmpName, mode)
+
+	if err == nil && m.edits == 0 && m.stopErr != nil {
+		err = errors.Wrapf(m.stopErr, "pebble: no version edits can be read from MANIFEST %q",
+			errors.Safe(name))
+	}
+	unusable := err != nil && (errors.Is(err, ErrCorruption) || os.IsNotExist(errors.UnwrapAll(err)))
+	if mode == ManifestRecoveryTruncate && unusable {
+		prev, prevName := vs.readPrevManifest(dirname, fileNum)
+		if prev == nil {
+			return nil, errors.Wrapf(err, "pebble: MANIFEST %q is unusable and no previous MANIFEST is readable",
+				errors.Safe(name))
+		}
+		vs.opts.EventListener.ManifestRecovered(ManifestRecoveryInfo{
+			Path:         path,
+			FileNum:      fileNum,
+			FallbackPath: vs.fs.PathJoin(dirname, prevName),
+			Err:          err,
+		})
+		return prev, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.stopErr != nil {
+		vs.opts.EventListener.ManifestRecovered(ManifestRecoveryInfo{
+			Path:    path,
+			FileNum: fileNum,
+			Offset:  m.stopOffset,
+			Edits:   m.edits,
+			Err:     m.stopErr,
+		})
+	}
+	return m, nil
+}
+
+// readPrevManifest reads the newest MANIFEST of the DB in dirname older than
+// the specified MANIFEST from which at least one version edit can be read,
+// returning nil if there is none.
+func (vs *versionSet) readPrevManifest(
+	dirname string, fileNum FileNum,
+) (*manifestContents, string) {
+	ls, err := vs.fs.List(dirname)
+	if err != nil {
+		return nil, ""
+	}
+	type manifestFile struct {
+		fileNum FileNum
+		name    string
+	}
+	var prev []manifestFile
+	var maxFileNum FileNum
+	for _, filename := range ls {
+		ft, fn, ok := base.ParseFilename(vs.fs, filename)
+		if !ok {
+			continue
+		}
+		if fn > maxFileNum {
+			maxFileNum = fn
+		}
+		if ft == fileTypeManifest && fn < fileNum {
+			prev = append(prev, manifestFile{fn, filename})
+		}
+	}
+	sort.Slice(prev, func(i, j int) bool {
+		return prev[i].fileNum > prev[j].fileNum
+	})
+	for _, f := range prev {
+		m, err := readManifestFile(vs.fs, dirname, f.name, f.fileNum, vs.cmpName, ManifestRecoveryTruncate)
+		if err != nil || m.edits == 0 {
+			continue
+		}
+		// Files may have been created after the previous MANIFEST was last
+		// written, and their file numbers must not be reused.
+		if m.nextFileNum <= maxFileNum {
+			m.nextFileNum = maxFileNum + 1
+		}
+		return m, f.name
+	}
+	return nil, ""
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/corruption"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestReader(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(tables[6]))
+	require.NoError(t, d.Close())
+
+	path, err := CurrentManifest(mem, "")
+	require.NoError(t, err)
+
+	var edits []*ManifestEdit
+	require.NoError(t, ReadManifest(mem, path, func(e *ManifestEdit) error {
+		edits = append(edits, e)
+		return nil
+	}))
+	require.True(t, len(edits) >= 3)
+	require.Equal(t, DefaultComparer.Name, edits[0].ComparerName)
+	require.Equal(t, int64(0), edits[0].Offset)
+
+	// The flush adds a table to L0, which the compaction moves to L6.
+	var added, deleted []FileNum
+	for i, e := range edits {
+		if i > 0 {
+			require.True(t, edits[i-1].Offset < e.Offset)
+		}
+		for _, nf := range e.NewFiles {
+			added = append(added, nf.FileNum)
+			require.Equal(t, "a", string(nf.Smallest.UserKey))
+			require.Equal(t, "b", string(nf.Largest.UserKey))
+		}
+		for _, df := range e.DeletedFiles {
+			require.Equal(t, 0, df.Level)
+			deleted = append(deleted, df.FileNum)
+		}
+	}
+	require.Equal(t, []FileNum{added[0]}, deleted)
+	require.Equal(t, tables[6][0].FileNum, added[len(added)-1])
+
+	last := edits[len(edits)-1]
+	require.True(t, strings.Contains(last.String(), "added:         L6 "), last.String())
+
+	errStop := errors.New("stop")
+	var n int
+	require.Equal(t, errStop, ReadManifest(mem, path, func(e *ManifestEdit) error {
+		n++
+		return errStop
+	}))
+	require.Equal(t, 1, n)
+
+	// A torn final edit is surfaced by ManifestReader as corruption, but is
+	// tolerated by ReadManifest.
+	info, err := mem.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, corruption.TruncateTail(mem, path, info.Size()-last.Offset-1))
+	f, err := mem.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r := NewManifestReader(f)
+	for i := 0; i < len(edits)-1; i++ {
+		e, err := r.Next()
+		require.NoError(t, err)
+		require.Equal(t, edits[i], e)
+	}
+	_, err = r.Next()
+	require.True(t, errors.Is(err, ErrCorruption), "expected corruption, found %v", err)
+
+	n = 0
+	require.NoError(t, ReadManifest(mem, path, func(e *ManifestEdit) error {
+		n++
+		return nil
+	}))
+	require.Equal(t, len(edits)-1, n)
+
+	_, err = NewManifestReader(strings.NewReader("")).Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestManifestRecovery(t *testing.T) {
+	var recovered []ManifestRecoveryInfo
+	opts := func(fs vfs.FS, mode ManifestRecoveryMode) *Options {
+		return &Options{
+			FS:                   fs,
+			MaxManifestFileSize:  1,
+			NumPrevManifest:      1,
+			ManifestRecoveryMode: mode,
+			EventListener: EventListener{
+				ManifestRecovered: func(info ManifestRecoveryInfo) {
+					recovered = append(recovered, info)
+				},
+			},
+		}
+	}
+
+	// Each flush rolls over to a new MANIFEST, retaining the previous one.
+	setup := func(t *testing.T) (fs vfs.FS, cur, prev string) {
+		fs = vfs.NewMem()
+		d, err := Open("", opts(fs, ManifestRecoveryTolerateTornTail))
+		require.NoError(t, err)
+		require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Close())
+		require.Empty(t, recovered)
+
+		cur, err = CurrentManifest(fs, "")
+		require.NoError(t, err)
+		ls, err := fs.List("")
+		require.NoError(t, err)
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(fs, name); ok && ft == fileTypeManifest && name != cur {
+				prev = name
+			}
+		}
+		require.NotEqual(t, "", prev)
+		return fs, cur, prev
+	}
+	get := func(t *testing.T, d *DB, key string) {
+		v, closer, err := d.Get([]byte(key))
+		require.NoError(t, err)
+		require.NotEmpty(t, v)
+		require.NoError(t, closer.Close())
+	}
+
+	t.Run("torn-tail", func(t *testing.T) {
+		recovered = nil
+		fs, cur, _ := setup(t)
+		var offsets []int64
+		require.NoError(t, ReadManifest(fs, cur, func(e *ManifestEdit) error {
+			offsets = append(offsets, e.Offset)
+			return nil
+		}))
+		require.NoError(t, corruption.TruncateTail(fs, cur, 1))
+
+		d, err := Open("", opts(fs, ManifestRecoveryTolerateTornTail))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(recovered))
+		info := recovered[0]
+		require.Equal(t, cur, info.Path)
+		require.Equal(t, len(offsets)-1, info.Edits)
+		require.Equal(t, offsets[len(offsets)-1], info.Offset)
+		require.Equal(t, "", info.FallbackPath)
+		require.True(t, errors.Is(info.Err, ErrCorruption), "expected corruption, found %v", info.Err)
+		get(t, d, "a")
+		require.NoError(t, d.Close())
+	})
+
+	t.Run("unusable", func(t *testing.T) {
+		recovered = nil
+		fs, cur, prev := setup(t)
+		// Corrupt the payload of the first record, following its 7-byte header.
+		require.NoError(t, corruption.FlipBit(fs, cur, 10, 0))
+
+		_, err := Open("", opts(fs, ManifestRecoveryTolerateTornTail))
+		require.True(t, errors.Is(err, ErrCorruption), "expected corruption, found %v", err)
+		require.Empty(t, recovered)
+
+		d, err := Open("", opts(fs, ManifestRecoveryTruncate))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(recovered))
+		info := recovered[0]
+		require.Equal(t, cur, info.Path)
+		require.Equal(t, prev, info.FallbackPath)
+		require.True(t, errors.Is(info.Err, ErrCorruption), "expected corruption, found %v", info.Err)
+		get(t, d, "a")
+		require.NoError(t, d.Close())
+	})
+}
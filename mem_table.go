@@ -22,16 +22,20 @@ func memTableEntrySize(keyBytes, valueBytes int) uint32 {
 	return arenaskl.MaxNodeSize(uint32(keyBytes)+8, uint32(valueBytes))
 }
 
-// memTableEmptySize is the amount of allocated space in the arena when the
-// memtable is empty.
-var memTableEmptySize = func() uint32 {
-	var pointSkl arenaskl.Skiplist
-	var rangeDelSkl arenaskl.Skiplist
-	arena := arenaskl.NewArena(make([]byte, 16<<10 /* 16 KB */))
-	pointSkl.Reset(arena, bytes.Compare)
-	rangeDelSkl.Reset(arena, bytes.Compare)
+// memTableEmptySize returns the amount of allocated space in the arena when a
+// memtable with the specified number of shards is empty. See
+// Options.Experimental.MemTableShards.
+func memTableEmptySize(shards int) uint32 {
+	if shards < 1 {
+		shards = 1
+	}
+	skls := make([]arenaskl.Skiplist, shards+1)
+	arena := arenaskl.NewArena(make([]byte, len(skls)*(16<<10) /* 16 KB per skiplist */))
+	for i := range skls {
+		skls[i].Reset(arena, bytes.Compare)
+	}
 	return arena.Size()
-}()
+}
 
 // A memTable implements an in-memory layer of the LSM. A memTable is mutable,
 // but append-only. Records are added, but never removed. Deletion is supported
@@ -57,13 +61,40 @@ var memTableEmptySize = func() uint32 {
 // commitPipeline serializes batch preparation, and allows batch application to
 // proceed concurrently.
 //
+// A memTable may optionally partition its point keys across multiple
+// skiplists (shards) by a hash of the key prefix. See
+// Options.Experimental.MemTableShards. All of the skiplists share the same
+// arena.
+//
 // It is safe to call get, apply, newIter, and newRangeDelIter concurrently.
 type memTable struct {
-	cmp         Compare
-	equal       Equal
-	arenaBuf    []byte
+	cmp      Compare
+	equal    Equal
+	split    Split
+	arenaBuf []byte
+	arena    *arenaskl.Arena
+	// skl holds the point keys, unless the memtable is sharded in which case
+	// the point keys are partitioned across shards and skl is unused.
 	skl         arenaskl.Skiplist
+	shards      []arenaskl.Skiplist
 	rangeDelSkl arenaskl.Skiplist
+	// rangeKeys holds the range keys. Range keys are rare, so the skiplist is
+	// initialized on first use in order that memtables without range keys do
+	// not pay for its head and tail nodes. The space for them is reserved by
+	// prepare.
+	rangeKeys struct {
+		once sync.Once
+		// init is set to 1, atomically, once skl is initialized.
+		init int32
+		skl  arenaskl.Skiplist
+		seed bool
+	}
+	// bloom, if non-nil, is a filter over the prefixes of the point keys in the
+	// memtable. See Options.Experimental.MemTablePrefixBloomSizeRatio.
+	bloom *memTableBloom
+	// emptySize is the amount of allocated space in the arena when the
+	// memtable is empty.
+	emptySize uint32
 	// reserved tracks the amount of space used by the memtable, both by actual
 	// data stored in the memtable as well as inflight batch commit
 	// operations. This value is incremented pessimistically by prepare() in
@@ -110,6 +141,7 @@ func newMemTable(opts memTableOptions) *memTable {
 	m := &memTable{
 		cmp:        opts.Comparer.Compare,
 		equal:      opts.Comparer.Equal,
+		split:      opts.Comparer.Split,
 		arenaBuf:   opts.arenaBuf,
 		writerRefs: 1,
 		logSeqNum:  opts.logSeqNum,
@@ -119,12 +151,62 @@ func newMemTable(opts memTableOptions) *memTable {
 		m.arenaBuf = make([]byte, opts.size)
 	}
 
-	arena := arenaskl.NewArena(m.arenaBuf)
-	m.skl.Reset(arena, m.cmp)
-	m.rangeDelSkl.Reset(arena, m.cmp)
+	m.arena = arenaskl.NewArena(m.arenaBuf)
+	if n := opts.Experimental.MemTableShards; n > 1 {
+		m.shards = make([]arenaskl.Skiplist, n)
+		for i := range m.shards {
+			m.shards[i].Reset(m.arena, m.cmp)
+		}
+	} else {
+		m.skl.Reset(m.arena, m.cmp)
+	}
+	m.rangeDelSkl.Reset(m.arena, m.cmp)
+	if opts.Experimental.Deterministic {
+		// Seed the skiplists so that the memtable fills up after the same
+		// writes on every run.
+		for i := range m.shards {
+			m.shards[i].Seed(uint64(i))
+		}
+		m.skl.Seed(0)
+		m.rangeDelSkl.Seed(0)
+		m.rangeKeys.seed = true
+	}
+	m.emptySize = m.arena.Size()
+	if r := opts.Experimental.MemTablePrefixBloomSizeRatio; r > 0 {
+		m.bloom = newMemTableBloom(int(r * float64(opts.size)))
+	}
 	return m
 }
 
+// pointSkl returns the skiplist containing the point keys for the specified
+// user key.
+func (m *memTable) pointSkl(key []byte) *arenaskl.Skiplist {
+	if m.shards == nil {
+		return &m.skl
+	}
+	return &m.shards[m.prefixHash(key)%uint32(len(m.shards))]
+}
+
+// prefixHash returns a hash of the prefix of the specified user key.
+func (m *memTable) prefixHash(key []byte) uint32 {
+	if m.split != nil {
+		key = key[:m.split(key)]
+	}
+	// FNV-1a.
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+// mayContain returns false if the memtable definitely does not contain a
+// point key with the same prefix as the specified user key.
+func (m *memTable) mayContain(key []byte) bool {
+	return m.bloom == nil || m.bloom.mayContain(m.prefixHash(key))
+}
+
 func (m *memTable) writerRef() {
 	switch v := atomic.AddInt32(&m.writerRefs, 1); {
 	case v <= 1:
@@ -150,7 +232,7 @@ func (m *memTable) readyForFlush() bool {
 // Get gets the value for the given key. It returns ErrNotFound if the DB does
 // not contain the key.
 func (m *memTable) get(key []byte) (value []byte, err error) {
-	it := m.skl.NewIter(nil, nil)
+	it := m.pointSkl(key).NewIter(nil, nil)
 	ikey, val := it.SeekGE(key)
 	if ikey == nil {
 		return nil, ErrNotFound
@@ -172,10 +254,14 @@ func (m *memTable) get(key []byte) (value []byte, err error) {
 // writerUnref() after the batch has been applied.
 func (m *memTable) prepare(batch *Batch) error {
 	avail := m.availBytes()
-	if batch.memTableSize > avail {
+	size := batch.memTableSize
+	if batch.countRangeKeys > 0 && atomic.LoadInt32(&m.rangeKeys.init) == 0 {
+		size += rangeKeySklSize
+	}
+	if size > avail {
 		return arenaskl.ErrArenaFull
 	}
-	m.reserved += batch.memTableSize
+	m.reserved += size
 
 	m.writerRef()
 	return nil
@@ -201,12 +287,22 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 		case InternalKeyKindRangeDelete:
 			err = m.rangeDelSkl.Add(ikey, value)
 			tombstoneCount++
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			m.rangeKeys.once.Do(m.initRangeKeys)
+			err = m.rangeKeys.skl.Add(ikey, value)
 		case InternalKeyKindLogData:
 			// Don't increment seqNum for LogData, since these are not applied
 			// to the memtable.
 			seqNum--
 		default:
-			err = ins.Add(&m.skl, ikey, value)
+			if m.bloom != nil {
+				m.bloom.add(m.prefixHash(ukey))
+			}
+			if m.shards == nil {
+				err = ins.Add(&m.skl, ikey, value)
+			} else {
+				err = m.pointSkl(ukey).Add(ikey, value)
+			}
 		}
 		if err != nil {
 			return err
@@ -226,11 +322,32 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekLT, First or Last.
 func (m *memTable) newIter(o *IterOptions) internalIterator {
-	return m.skl.NewIter(o.GetLowerBound(), o.GetUpperBound())
+	if m.shards == nil {
+		return m.skl.NewIter(o.GetLowerBound(), o.GetUpperBound())
+	}
+	iters := make([]internalIterator, len(m.shards))
+	for i := range m.shards {
+		iters[i] = m.shards[i].NewIter(o.GetLowerBound(), o.GetUpperBound())
+	}
+	return newMergingIter(o.getLogger(), m.cmp, iters...)
+}
+
+// newPointIter returns an iterator that is only guaranteed to contain the
+// point keys with the same prefix as the specified user key. If the memtable
+// is sharded, only the shard containing the key is iterated over.
+func (m *memTable) newPointIter(key []byte) internalIterator {
+	return m.pointSkl(key).NewIter(nil, nil)
 }
 
 func (m *memTable) newFlushIter(o *IterOptions, bytesFlushed *uint64) internalIterator {
-	return m.skl.NewFlushIter(bytesFlushed)
+	if m.shards == nil {
+		return m.skl.NewFlushIter(bytesFlushed)
+	}
+	iters := make([]internalIterator, len(m.shards))
+	for i := range m.shards {
+		iters[i] = m.shards[i].NewFlushIter(bytesFlushed)
+	}
+	return newMergingIter(o.getLogger(), m.cmp, iters...)
 }
 
 func (m *memTable) newRangeDelIter(*IterOptions) internalIterator {
@@ -241,8 +358,37 @@ func (m *memTable) newRangeDelIter(*IterOptions) internalIterator {
 	return rangedel.NewIter(m.cmp, tombstones)
 }
 
+// newRangeKeyIter returns an iterator over the range keys in the memtable, or
+// nil if the memtable does not contain any range keys. The range keys are not
+// fragmented.
+func (m *memTable) newRangeKeyIter(*IterOptions) internalIterator {
+	if atomic.LoadInt32(&m.rangeKeys.init) == 0 {
+		return nil
+	}
+	it := m.rangeKeys.skl.NewIter(nil, nil)
+	if key, _ := it.First(); key == nil {
+		_ = it.Close()
+		return nil
+	}
+	return it
+}
+
+// rangeKeySklSize is the space needed in the arena for the head and tail nodes
+// of the range key skiplist.
+var rangeKeySklSize = 2 * arenaskl.MaxNodeSize(0, 0)
+
+// initRangeKeys initializes the range key skiplist. It is called once, by the
+// first apply of a batch containing range keys.
+func (m *memTable) initRangeKeys() {
+	m.rangeKeys.skl.Reset(m.arena, m.cmp)
+	if m.rangeKeys.seed {
+		m.rangeKeys.skl.Seed(0)
+	}
+	atomic.StoreInt32(&m.rangeKeys.init, 1)
+}
+
 func (m *memTable) availBytes() uint32 {
-	a := m.skl.Arena()
+	a := m.arena
 	if atomic.LoadInt32(&m.writerRefs) == 1 {
 		// If there are no other concurrent apply operations, we can update the
 		// reserved bytes setting to accurately reflect how many bytes of been
@@ -253,11 +399,11 @@ func (m *memTable) availBytes() uint32 {
 }
 
 func (m *memTable) inuseBytes() uint64 {
-	return uint64(m.skl.Size() - memTableEmptySize)
+	return uint64(m.arena.Size() - m.emptySize)
 }
 
 func (m *memTable) totalBytes() uint64 {
-	return uint64(m.skl.Arena().Capacity())
+	return uint64(m.arena.Capacity())
 }
 
 func (m *memTable) close() error {
@@ -266,7 +412,7 @@ func (m *memTable) close() error {
 
 // empty returns whether the MemTable has no key/value pairs.
 func (m *memTable) empty() bool {
-	return m.skl.Size() == memTableEmptySize
+	return m.arena.Size() == m.emptySize
 }
 
 // A rangeTombstoneFrags holds a set of fragmented range tombstones generated
@@ -347,3 +493,57 @@ func (c *rangeTombstoneCache) get(m *memTable) []rangedel.Tombstone {
 	}
 	return frags.get(m)
 }
+
+// memTableBloomProbes is the number of bits set in a memTableBloom for each
+// key.
+const memTableBloomProbes = 6
+
+// A memTableBloom is a bloom filter over the prefixes of the point keys added
+// to a memtable, allowing point lookups to skip memtables which definitely do
+// not contain a key. Unlike the filters in sstables, a memTableBloom is built
+// incrementally and is safe for concurrent use by add and mayContain.
+type memTableBloom struct {
+	bits []uint32
+}
+
+// newMemTableBloom returns a new memTableBloom using approximately the
+// specified number of bytes.
+func newMemTableBloom(size int) *memTableBloom {
+	const minSize = 64
+	if size < minSize {
+		size = minSize
+	}
+	return &memTableBloom{bits: make([]uint32, (size+3)/4)}
+}
+
+// add adds the key with the specified hash to the filter.
+func (f *memTableBloom) add(h uint32) {
+	nBits := uint32(len(f.bits) * 32)
+	delta := h>>17 | h<<15
+	for j := 0; j < memTableBloomProbes; j++ {
+		bitPos := h % nBits
+		word, mask := &f.bits[bitPos/32], uint32(1)<<(bitPos%32)
+		for {
+			old := atomic.LoadUint32(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint32(word, old, old|mask) {
+				break
+			}
+		}
+		h += delta
+	}
+}
+
+// mayContain returns false if the key with the specified hash was definitely
+// not added to the filter.
+func (f *memTableBloom) mayContain(h uint32) bool {
+	nBits := uint32(len(f.bits) * 32)
+	delta := h>>17 | h<<15
+	for j := 0; j < memTableBloomProbes; j++ {
+		bitPos := h % nBits
+		if atomic.LoadUint32(&f.bits[bitPos/32])&(uint32(1)<<(bitPos%32)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
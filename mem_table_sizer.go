@@ -0,0 +1,84 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "time"
+
+const (
+	// adaptiveMemTableFillInterval is the duration an adaptively sized memtable
+	// is targeted to take to fill at the recent write throughput.
+	adaptiveMemTableFillInterval = 10 * time.Second
+	// adaptiveMemTableBacklog is the number of immutable memtables awaiting
+	// flush at which an adaptively sized memtable is grown regardless of the
+	// write throughput.
+	adaptiveMemTableBacklog = 2
+)
+
+// memTableSizer chooses the size of each new memtable when adaptive memtable
+// sizing is enabled (see Options.Experimental.MemTableMinSize). The size is
+// chosen such that a memtable takes approximately
+// adaptiveMemTableFillInterval to fill at the recent write throughput, and is
+// grown if flushes are falling behind. The size moves by at most a factor of 2
+// per memtable, and is bounded by [minSize, maxSize].
+//
+// Growing the memtable during a burst of writes allows more writes to be
+// buffered and reduces the number of flushes, while shrinking it once writes
+// subside avoids permanently reserving large arenas.
+//
+// A memTableSizer is protected by DB.mu.
+type memTableSizer struct {
+	minSize      int
+	maxSize      int
+	fillInterval time.Duration
+	// rate is an exponentially weighted moving average of the write throughput
+	// in bytes per second, sampled each time a memtable fills.
+	rate float64
+}
+
+func newMemTableSizer(minSize, maxSize int) *memTableSizer {
+	return &memTableSizer{
+		minSize:      minSize,
+		maxSize:      maxSize,
+		fillInterval: adaptiveMemTableFillInterval,
+	}
+}
+
+// next returns the size of the next memtable given the size of the memtable
+// which just filled, the number of bytes written to it, the duration it took
+// to fill and the number of other immutable memtables awaiting flush.
+func (s *memTableSizer) next(size int, written uint64, elapsed time.Duration, backlog int) int {
+	if elapsed > 0 {
+		sample := float64(written) / elapsed.Seconds()
+		if s.rate == 0 {
+			s.rate = sample
+		} else {
+			s.rate = (s.rate + sample) / 2
+		}
+	}
+	target := s.rate * s.fillInterval.Seconds()
+	switch {
+	case backlog >= adaptiveMemTableBacklog || target > float64(size):
+		size *= 2
+	case backlog == 0 && target < float64(size)/2:
+		size /= 2
+	}
+	return s.clamp(size)
+}
+
+// grow returns the size of the next memtable when the current size is too
+// small for a batch.
+func (s *memTableSizer) grow(size int) int {
+	return s.clamp(2 * size)
+}
+
+func (s *memTableSizer) clamp(size int) int {
+	if size < s.minSize {
+		return s.minSize
+	}
+	if size > s.maxSize {
+		return s.maxSize
+	}
+	return size
+}
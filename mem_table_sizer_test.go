@@ -0,0 +1,119 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemTableSizer(t *testing.T) {
+	const minSize, maxSize = 1 << 20, 16 << 20
+	s := newMemTableSizer(minSize, maxSize)
+
+	// A memtable which fills faster than the fill interval grows, up to the
+	// maximum size.
+	size := minSize
+	for _, expected := range []int{2 << 20, 4 << 20, 8 << 20, 16 << 20, 16 << 20} {
+		size = s.next(size, uint64(size), time.Second, 0 /* backlog */)
+		require.Equal(t, expected, size)
+	}
+
+	// Once the writes subside, the memtable shrinks, down to the minimum size.
+	// The moving average of the write rate delays the shrinking.
+	var sizes []int
+	for i := 0; i < 12; i++ {
+		size = s.next(size, uint64(size), time.Minute, 0 /* backlog */)
+		sizes = append(sizes, size>>20)
+	}
+	require.Equal(t, []int{16, 16, 16, 16, 8, 4, 4, 2, 1, 1, 1, 1}, sizes)
+
+	// A flush backlog grows the memtable regardless of the write rate, and
+	// prevents it from shrinking.
+	size = s.next(size, uint64(size), time.Minute, adaptiveMemTableBacklog)
+	require.Equal(t, 2<<20, size)
+	size = s.next(size, uint64(size), time.Minute, 1 /* backlog */)
+	require.Equal(t, 2<<20, size)
+	size = s.next(size, uint64(size), time.Minute, 0 /* backlog */)
+	require.Equal(t, 1<<20, size)
+
+	require.Equal(t, 2<<20, s.grow(1<<20))
+	require.Equal(t, maxSize, s.grow(maxSize))
+}
+
+func TestAdaptiveMemTableSize(t *testing.T) {
+	const minSize, maxSize = 256 << 10, 4 << 20
+	opts := &Options{FS: vfs.NewMem(), MemTableSize: maxSize}
+	opts.Experimental.MemTableMinSize = minSize
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	var now int64
+	d.timeNow = func() time.Time {
+		return time.Unix(0, atomic.LoadInt64(&now))
+	}
+	nextSize := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.mem.nextSize
+	}
+	require.Equal(t, minSize, nextSize())
+
+	// Fill memtables quickly, causing the memtable size to grow to the maximum.
+	value := make([]byte, 16<<10)
+	write := func(n int, delay time.Duration) {
+		for i := 0; i < n; i++ {
+			atomic.AddInt64(&now, int64(delay))
+			require.NoError(t, d.Set([]byte("key"), value, nil))
+		}
+	}
+	write(1000, time.Millisecond)
+	require.Equal(t, maxSize, nextSize())
+
+	// Fill memtables slowly, causing the memtable size to shrink.
+	write(3000, time.Second)
+	require.True(t, nextSize() < maxSize, "next size: %d", nextSize())
+	require.NoError(t, d.Flush())
+}
+
+func TestAdaptiveMemTableLargeBatch(t *testing.T) {
+	const minSize, maxSize = 256 << 10, 4 << 20
+	opts := &Options{FS: vfs.NewMem(), MemTableSize: maxSize}
+	opts.Experimental.MemTableMinSize = minSize
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// A batch which is small relative to Options.MemTableSize, but which does
+	// not fit in the mutable memtable, is committed as a flushable batch rather
+	// than being copied into a larger memtable.
+	value := make([]byte, 192<<10)
+	require.True(t, len(value) < d.largeBatchThreshold)
+	require.NoError(t, d.Set([]byte("a"), value, nil))
+
+	d.mu.Lock()
+	empty := d.mu.mem.mutable.empty()
+	size := d.mu.mem.mutable.totalBytes()
+	d.mu.Unlock()
+	require.True(t, empty)
+	require.EqualValues(t, minSize, size)
+
+	// A batch which fits in the mutable memtable is applied to it.
+	require.NoError(t, d.Set([]byte("b"), value[:1<<10], nil))
+	d.mu.Lock()
+	empty = d.mu.mem.mutable.empty()
+	d.mu.Unlock()
+	require.False(t, empty)
+
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, len(value), len(v))
+	require.NoError(t, closer.Close())
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 )
@@ -32,7 +33,10 @@ func (m *memTable) set(key InternalKey, value []byte) error {
 		m.tombstones.invalidate(1)
 		return nil
 	}
-	return m.skl.Add(key, value)
+	if m.bloom != nil {
+		m.bloom.add(m.prefixHash(key.UserKey))
+	}
+	return m.pointSkl(key.UserKey).Add(key, value)
 }
 
 // count returns the number of entries in a DB.
@@ -230,6 +234,163 @@ func TestMemTable1000Entries(t *testing.T) {
 	}
 }
 
+func TestMemTableShards(t *testing.T) {
+	opts := &Options{}
+	opts.Experimental.MemTableShards = 4
+	m := newMemTable(memTableOptions{Options: opts})
+	require.Equal(t, 4, len(m.shards))
+	require.True(t, m.empty())
+
+	const n = 1000
+	b := newBatch(nil)
+	for i := 0; i < n; i++ {
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprint(i)), nil))
+	}
+	require.NoError(t, m.prepare(b))
+	require.NoError(t, m.apply(b, 1))
+	m.writerUnref()
+	require.False(t, m.empty())
+
+	// The keys are spread across the shards.
+	for i := range m.shards {
+		it := m.shards[i].NewIter(nil, nil)
+		key, _ := it.First()
+		require.NotNil(t, key, "shard %d is empty", i)
+		require.NoError(t, it.Close())
+	}
+
+	// Point lookups and iteration see all of the keys, and iteration merges the
+	// shards in key order.
+	for i := 0; i < n; i++ {
+		v, err := m.get([]byte(fmt.Sprintf("%04d", i)))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(i), string(v))
+	}
+	require.Equal(t, n, m.count())
+	x := newInternalIterAdapter(m.newIter(nil))
+	i := 0
+	for valid := x.First(); valid; valid = x.Next() {
+		require.Equal(t, fmt.Sprintf("%04d", i), string(x.Key().UserKey))
+		i++
+	}
+	require.Equal(t, n, i)
+	i = 500
+	for valid := x.SeekLT([]byte("0500")); valid; valid = x.Prev() {
+		i--
+		require.Equal(t, fmt.Sprintf("%04d", i), string(x.Key().UserKey))
+	}
+	require.Equal(t, 0, i)
+	require.NoError(t, x.Close())
+	require.Equal(t, m.inuseBytes(), m.bytesIterated(t))
+}
+
+func TestMemTableShardsDB(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	opts.Experimental.MemTableShards = 8
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprint(i)), nil))
+	}
+	require.NoError(t, d.Delete([]byte("010"), nil))
+	require.NoError(t, d.DeleteRange([]byte("020"), []byte("030"), nil))
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			v, closer, err := d.Get([]byte(fmt.Sprintf("%03d", i)))
+			if i == 10 || (i >= 20 && i < 30) {
+				require.Equal(t, ErrNotFound, err)
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprint(i), string(v))
+			require.NoError(t, closer.Close())
+		}
+		iter := d.NewIter(nil)
+		count := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			count++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, n-11, count)
+	}
+
+	check()
+	require.NoError(t, d.Flush())
+	check()
+	require.NoError(t, d.Close())
+}
+
+func TestMemTableBloom(t *testing.T) {
+	opts := &Options{}
+	opts.Experimental.MemTablePrefixBloomSizeRatio = 0.1
+	m := newMemTable(memTableOptions{Options: opts, size: 1 << 20})
+	require.NotNil(t, m.bloom)
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		require.NoError(t, m.set(ikey(fmt.Sprintf("key%04d", i)), nil))
+	}
+	for i := 0; i < n; i++ {
+		require.True(t, m.mayContain([]byte(fmt.Sprintf("key%04d", i))))
+	}
+	var falsePositives int
+	for i := n; i < 10*n; i++ {
+		if m.mayContain([]byte(fmt.Sprintf("key%04d", i))) {
+			falsePositives++
+		}
+	}
+	require.True(t, falsePositives < n/10, "false positives: %d", falsePositives)
+
+	// The filter is built over key prefixes.
+	opts.Comparer = &Comparer{
+		Compare: DefaultComparer.Compare,
+		Equal:   DefaultComparer.Equal,
+		Split: func(a []byte) int {
+			if i := bytes.IndexByte(a, '@'); i >= 0 {
+				return i
+			}
+			return len(a)
+		},
+		Name: "prefix",
+	}
+	m = newMemTable(memTableOptions{Options: opts})
+	require.NoError(t, m.set(ikey("a@1"), nil))
+	require.True(t, m.mayContain([]byte("a@2")))
+	require.True(t, m.mayContain([]byte("a")))
+}
+
+func TestMemTableBloomDB(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.MemTablePrefixBloomSizeRatio = 0.1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+
+	// The memtable does not contain the point key "a", but its range tombstone
+	// must still delete the flushed key.
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.False(t, d.mu.mem.mutable.mayContain([]byte("a")))
+
+	_, _, err = d.Get([]byte("a"))
+	require.Equal(t, ErrNotFound, err)
+	for _, kv := range [][2]string{{"b", "2"}, {"c", "3"}} {
+		v, closer, err := d.Get([]byte(kv[0]))
+		require.NoError(t, err)
+		require.Equal(t, kv[1], string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
 func TestMemTableIter(t *testing.T) {
 	var mem *memTable
 	for _, testdata := range []string{
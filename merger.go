@@ -15,5 +15,8 @@ type Merger = base.Merger
 // ValueMerger exports the base.ValueMerger type.
 type ValueMerger = base.ValueMerger
 
+// PartialValueMerger exports the base.PartialValueMerger type.
+type PartialValueMerger = base.PartialValueMerger
+
 // DefaultMerger exports the base.DefaultMerger variable.
 var DefaultMerger = base.DefaultMerger
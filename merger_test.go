@@ -0,0 +1,182 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// sumValueMerger sums integer operands. Partial merges are counted by
+// partialMerges, and full merges by fullMerges.
+type sumValueMerger struct {
+	sum                       int
+	partialMerges, fullMerges *int
+}
+
+func (m *sumValueMerger) add(value []byte) error {
+	v, err := strconv.Atoi(string(value))
+	m.sum += v
+	return err
+}
+
+func (m *sumValueMerger) MergeNewer(value []byte) error {
+	return m.add(value)
+}
+
+func (m *sumValueMerger) MergeOlder(value []byte) error {
+	return m.add(value)
+}
+
+func (m *sumValueMerger) Finish() ([]byte, io.Closer, error) {
+	*m.fullMerges++
+	return []byte(strconv.Itoa(m.sum)), nil, nil
+}
+
+func (m *sumValueMerger) PartialFinish() ([]byte, io.Closer, error) {
+	*m.partialMerges++
+	return []byte(strconv.Itoa(m.sum)), nil, nil
+}
+
+func newSumMerger(partialMerges, fullMerges *int) *Merger {
+	return &Merger{
+		Merge: func(key, value []byte) (ValueMerger, error) {
+			m := &sumValueMerger{partialMerges: partialMerges, fullMerges: fullMerges}
+			return m, m.add(value)
+		},
+		Name: "sum",
+	}
+}
+
+func TestPartialMerge(t *testing.T) {
+	var partialMerges, fullMerges int
+	d, err := Open("", &Options{
+		FS:     vfs.NewMem(),
+		Merger: newSumMerger(&partialMerges, &fullMerges),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	// Flushing operands without an older value is a partial merge.
+	require.NoError(t, d.Merge([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Merge([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 1, partialMerges)
+	require.Equal(t, 0, fullMerges)
+
+	// Reading the key is a full merge.
+	require.Equal(t, "3", get("a"))
+	require.Equal(t, 1, partialMerges)
+	require.Equal(t, 1, fullMerges)
+
+	// Flushing operands with an older value is a full merge.
+	require.NoError(t, d.Set([]byte("b"), []byte("1"), nil))
+	require.NoError(t, d.Merge([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 1, partialMerges)
+	require.Equal(t, 2, fullMerges)
+	require.Equal(t, "3", get("b"))
+}
+
+func TestMaxSuccessiveMerges(t *testing.T) {
+	var partialMerges, fullMerges int
+	d, err := Open("", &Options{
+		FS:                  vfs.NewMem(),
+		Merger:              newSumMerger(&partialMerges, &fullMerges),
+		MaxSuccessiveMerges: 3,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	memTableKinds := func(key string) []InternalKeyKind {
+		d.mu.Lock()
+		mem := d.mu.mem.mutable
+		d.mu.Unlock()
+		iter := mem.newIter(nil)
+		defer iter.Close()
+		var kinds []InternalKeyKind
+		for k, _ := iter.SeekGE([]byte(key)); k != nil && string(k.UserKey) == key; k, _ = iter.Next() {
+			kinds = append(kinds, k.Kind())
+		}
+		return kinds
+	}
+
+	for i := 1; i <= 7; i++ {
+		require.NoError(t, d.Merge([]byte("a"), []byte(strconv.Itoa(i)), nil))
+	}
+	// The 4th merge found 3 successive operands in the memtable and was
+	// committed as a SET. The kinds are listed from newest to oldest.
+	require.Equal(t, []InternalKeyKind{
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+		InternalKeyKindSet,
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+	}, memTableKinds("a"))
+
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "28", string(v))
+	require.NoError(t, closer.Close())
+
+	// A key written earlier in the same batch is not collapsed.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("b"), []byte("1"), nil))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, b.Merge([]byte("b"), []byte("1"), nil))
+	}
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Merge([]byte("b"), []byte("1"), nil))
+	require.Equal(t, []InternalKeyKind{
+		InternalKeyKindSet,
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+		InternalKeyKindMerge,
+		InternalKeyKindSet,
+	}, memTableKinds("b"))
+	v, closer, err = d.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "6", string(v))
+	require.NoError(t, closer.Close())
+
+	// Concurrent merges are not lost when collapsed.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := d.Merge([]byte("c"), []byte("1"), nil); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	v, closer, err = d.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, "800", string(v))
+	require.NoError(t, closer.Close())
+}
@@ -792,6 +792,14 @@ func (m *mergingIter) seekGE(key []byte, level int) {
 			m.logger.Fatalf("mergingIter: lower bound violation: %s < %s\n%s", key, m.lower, debug.Stack())
 		}
 
+		if m.upper != nil && m.heap.cmp(key, m.upper) >= 0 {
+			// Range tombstones have advanced the seek key to or past the upper
+			// bound. The remaining levels cannot contain any keys within the
+			// bounds, so we skip seeking them and treat them as exhausted.
+			m.exhaustLevels(level)
+			break
+		}
+
 		l := &m.levels[level]
 		if m.prefix != nil {
 			l.iterKey, l.iterValue = l.iter.SeekPrefixGE(m.prefix, key)
@@ -841,6 +849,17 @@ func (m *mergingIter) seekGE(key []byte, level int) {
 	m.initMinHeap()
 }
 
+// exhaustLevels marks levels >= level as exhausted without repositioning their
+// iterators. A subsequent change of direction repositions exhausted levels
+// within the bounds (see switchToMinHeap and switchToMaxHeap).
+func (m *mergingIter) exhaustLevels(level int) {
+	for ; level < len(m.levels); level++ {
+		l := &m.levels[level]
+		l.iterKey, l.iterValue = nil, nil
+		l.tombstone = rangedel.Tombstone{}
+	}
+}
+
 func (m *mergingIter) String() string {
 	return "merging"
 }
@@ -875,6 +894,13 @@ func (m *mergingIter) seekLT(key []byte, level int) {
 			m.logger.Fatalf("mergingIter: upper bound violation: %s > %s\n%s", key, m.upper, debug.Stack())
 		}
 
+		if m.lower != nil && m.heap.cmp(key, m.lower) <= 0 {
+			// Range tombstones have retreated the seek key to or before the lower
+			// bound. See the comment in seekGE.
+			m.exhaustLevels(level)
+			break
+		}
+
 		l := &m.levels[level]
 		l.iterKey, l.iterValue = l.iter.SeekLT(key)
 
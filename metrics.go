@@ -7,8 +7,10 @@ package pebble
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/histogram"
 	"github.com/cockroachdb/pebble/internal/humanize"
 	"github.com/cockroachdb/pebble/sstable"
 )
@@ -16,6 +18,10 @@ import (
 // CacheMetrics holds metrics for the block and table cache.
 type CacheMetrics = cache.Metrics
 
+// HistogramSnapshot holds a point-in-time copy of a latency or size
+// distribution.
+type HistogramSnapshot = histogram.Snapshot
+
 // FilterMetrics holds metrics for the filter policy
 type FilterMetrics = sstable.FilterMetrics
 
@@ -123,12 +129,62 @@ func (m *LevelMetrics) format(buf *bytes.Buffer, score string) {
 type Metrics struct {
 	BlockCache CacheMetrics
 
+	Commit struct {
+		// The distribution of end-to-end commit latencies in nanoseconds,
+		// measured from the start of the commit until the batch is visible.
+		Latency HistogramSnapshot
+		// The distribution of the time spent queued waiting for a commit
+		// concurrency slot, in nanoseconds. When fair queuing is enabled (see
+		// Options.Experimental.CommitClassWeights), this includes the time
+		// spent waiting behind commits of other classes.
+		QueueWaitLatency HistogramSnapshot
+		// The distribution of the time spent preparing a commit, which includes
+		// writing the batch to the WAL, in nanoseconds.
+		WALWriteLatency HistogramSnapshot
+		// The distribution of the time spent applying a batch to the memtable,
+		// in nanoseconds.
+		MemTableApplyLatency HistogramSnapshot
+		// The distribution of the time spent waiting for the WAL to be synced,
+		// in nanoseconds. Only commits which request a sync are recorded. The
+		// latency of the fsyncs themselves is reported by WAL.SyncLatency.
+		SyncWaitLatency HistogramSnapshot
+	}
+
 	Compact struct {
-		// The total number of compactions.
-		Count int64
+		// The total number of compactions, and the number of compactions of
+		// each type: default compactions which rewrite their inputs, delete-only
+		// compactions which drop input tables covered by range tombstones, and
+		// move compactions which move a table to the next level without
+		// rewriting it.
+		Count           int64
+		DefaultCount    int64
+		DeleteOnlyCount int64
+		MoveCount       int64
 		// An estimate of the number of bytes that need to be compacted for the LSM
 		// to reach a stable state.
 		EstimatedDebt uint64
+		// An estimate of the time needed to compact EstimatedDebt, assuming
+		// Options.MaxConcurrentCompactions compactions run concurrently at the
+		// recent Throughput. A drain time which grows over successive samples
+		// indicates that compactions are falling behind writes, which will
+		// eventually stall writes. Zero if the throughput is not yet known.
+		EstimatedDrainTime time.Duration
+		// The number of bytes in the input sstables of in-progress compactions.
+		InProgressBytes int64
+		// The number of in-progress compactions. This is bounded by
+		// Options.MaxConcurrentCompactions.
+		NumInProgress int64
+		// The rate, in bytes per second, at which compactions were most recently
+		// paced (see Options.Experimental.CompactionDebtPacing), or 0 if
+		// compactions are not being paced.
+		PacedRate int64
+		// The cumulative time compactions have been delayed by pacing, by
+		// Options.MaxCompactionRate and by Options.RateLimiter.
+		PacingDelay time.Duration
+		// A moving average of the throughput of recent compactions, in bytes
+		// read per second per compaction. Compactions which do not rewrite
+		// data, such as moves of sstables between levels, are excluded.
+		Throughput int64
 	}
 
 	Flush struct {
@@ -159,10 +215,41 @@ type Metrics struct {
 		ZombieSize uint64
 		// The count of zombie tables.
 		ZombieCount int64
+		// The number of bytes present in obsolete tables which are queued for
+		// deletion. Only non-zero if deletions are paced (see
+		// Options.Experimental.TargetByteDeletionRate).
+		PendingDeletionSize uint64
+		// The count of obsolete tables queued for deletion.
+		PendingDeletionCount int64
+		// The total number of entries, and of point and range deletion
+		// tombstones, in the live tables whose stats have been loaded. The
+		// stats of each table are loaded in the background (see
+		// TableStatsLoaded), so the totals exclude PendingStatsCount tables.
+		NumEntries   uint64
+		NumDeletions uint64
+		// The estimated number of bytes which may be reclaimed by compacting the
+		// range deletions in the live tables to the bottom of the LSM.
+		RangeDeletionsBytesEstimate uint64
+		// The count of live tables whose stats have not yet been loaded.
+		PendingStatsCount int64
 	}
 
 	TableCache CacheMetrics
 
+	// The number of sstables evicted from the table cache to make room for
+	// other sstables. When the table cache is shared, an sstable may be evicted
+	// to make room for the sstables of another DB.
+	TableCacheEvictions int64
+	// The number of sstable file descriptors held open by the DB. This includes
+	// sstables evicted from the table cache that are still in use by iterators,
+	// unless Options.Experimental.StrictMaxOpenFiles is set.
+	TableOpenFiles int64
+	// The number of sstables reopened after their file descriptor was closed
+	// to respect Options.MaxOpenFiles. Only non-zero if
+	// Options.Experimental.StrictMaxOpenFiles is set. A high rate of reopens
+	// indicates that MaxOpenFiles is too low for the workload.
+	TableReopens int64
+
 	// Count of the number of open sstable iterators.
 	TableIters int64
 
@@ -178,6 +265,42 @@ type Metrics struct {
 		BytesIn uint64
 		// Number of bytes written to the WAL.
 		BytesWritten uint64
+		// Number of WAL syncs performed. The realized sync rate is the change in
+		// this value over an interval, which is bounded by
+		// Options.WALMinSyncInterval.
+		Syncs uint64
+		// The distribution of WAL sync latencies in nanoseconds.
+		SyncLatency HistogramSnapshot
+		// The distribution of the number of bytes made durable by each WAL
+		// sync. Larger values indicate more effective group commit.
+		SyncBytes HistogramSnapshot
+		// The distribution of the number of sync requests satisfied by each
+		// WAL sync.
+		SyncQueueLen HistogramSnapshot
+	}
+
+	WriteStall struct {
+		// The number of times writes were stopped, in total and by reason: the
+		// L0 read-amplification reaching Options.L0StopWritesThreshold, the
+		// queued memtables reaching Options.MemTableStopWritesThreshold, and
+		// the compaction debt reaching Options.CompactionDebtStopThreshold.
+		Count               int64
+		L0Count             int64
+		MemTableCount       int64
+		CompactionDebtCount int64
+		// The cumulative duration for which writes were stopped, including the
+		// current stall, if any.
+		Duration time.Duration
+		// The number of writes delayed because writes were slowed down (see
+		// Options.WriteSlowdownMaxDelay), and the cumulative delay of those
+		// writes.
+		SlowdownCount int64
+		SlowdownDelay time.Duration
+		// The reason writes are currently stopped or slowed down, and whether
+		// they are slowed down rather than stopped. Reason is empty if writes
+		// are proceeding without delay.
+		Reason   string
+		Slowdown bool
 	}
 }
 
@@ -229,7 +352,8 @@ func (m *Metrics) formatWAL(buf *bytes.Buffer) {
 //         6         1   825 B    0.00   1.6 K     0 B       0     0 B       0   825 B       1   1.6 K     0.5
 //     total         3   2.4 K       -   933 B   825 B       1     0 B       0   4.1 K       4   1.6 K     4.5
 //     flush         3
-//   compact         1   1.6 K          (size == estimated-debt)
+//   compact         1   1.6 K     0 B       0          (size == estimated-debt, score = in-progress-bytes, in = num-in-progress)
+//     ctype         1       0       0  (default, delete, move)
 //    memtbl         1   4.0 M
 //   zmemtbl         0     0 B
 //      ztbl         0     0 B
@@ -272,10 +396,16 @@ func (m *Metrics) String() string {
 	total.format(&buf, "-")
 
 	fmt.Fprintf(&buf, "  flush %9d\n", m.Flush.Count)
-	fmt.Fprintf(&buf, "compact %9d %7s %7s  (size == estimated-debt)\n",
+	fmt.Fprintf(&buf, "compact %9d %7s %7s %7d %7s  (size == estimated-debt, score = in-progress-bytes, in = num-in-progress)\n",
 		m.Compact.Count,
 		humanize.IEC.Uint64(m.Compact.EstimatedDebt),
+		humanize.IEC.Int64(m.Compact.InProgressBytes),
+		m.Compact.NumInProgress,
 		"")
+	fmt.Fprintf(&buf, "  ctype %9d %7d %7d  (default, delete, move)\n",
+		m.Compact.DefaultCount,
+		m.Compact.DeleteOnlyCount,
+		m.Compact.MoveCount)
 	fmt.Fprintf(&buf, " memtbl %9d %7s\n",
 		m.MemTable.Count,
 		humanize.IEC.Uint64(m.MemTable.Size))
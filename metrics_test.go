@@ -7,6 +7,7 @@ package pebble
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
@@ -21,7 +22,12 @@ func TestMetricsFormat(t *testing.T) {
 	m.BlockCache.Hits = 3
 	m.BlockCache.Misses = 4
 	m.Compact.Count = 5
+	m.Compact.DefaultCount = 26
+	m.Compact.DeleteOnlyCount = 27
+	m.Compact.MoveCount = 28
 	m.Compact.EstimatedDebt = 6
+	m.Compact.InProgressBytes = 7
+	m.Compact.NumInProgress = 2
 	m.Flush.Count = 7
 	m.Filter.Hits = 8
 	m.Filter.Misses = 9
@@ -73,7 +79,8 @@ __level_____count____size___score______in__ingest(sz_cnt)____move(sz_cnt)___writ
       6       701   702 B       -   704 B   704 B     712   706 B     713   1.4 K   1.4 K   707 B       7     2.0
   total      2807   2.7 K       -   2.8 K   2.8 K   2.9 K   2.8 K   2.9 K   8.4 K   5.7 K   2.8 K      28     3.0
   flush         7
-compact         5     6 B          (size == estimated-debt)
+compact         5     6 B     7 B       2          (size == estimated-debt, score = in-progress-bytes, in = num-in-progress)
+  ctype        26      27      28  (default, delete, move)
  memtbl        11    10 B
 zmemtbl        13    12 B
    ztbl        15    14 B
@@ -189,3 +196,85 @@ func TestMetrics(t *testing.T) {
 		}
 	})
 }
+
+func TestMetricsWALSyncs(t *testing.T) {
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte("a"), nil, Sync))
+	}
+	require.NoError(t, d.Set([]byte("b"), nil, NoSync))
+
+	m := d.Metrics()
+	require.EqualValues(t, n, m.WAL.Syncs)
+	require.EqualValues(t, n, m.WAL.SyncQueueLen.Sum)
+	require.EqualValues(t, n, m.Commit.SyncWaitLatency.Count)
+	require.EqualValues(t, n+1, m.Commit.Latency.Count)
+	require.EqualValues(t, n+1, m.Commit.QueueWaitLatency.Count)
+	require.EqualValues(t, n+1, m.Commit.WALWriteLatency.Count)
+	require.EqualValues(t, n+1, m.Commit.MemTableApplyLatency.Count)
+
+	d.ResetCommitMetrics()
+	m = d.Metrics()
+	require.EqualValues(t, 0, m.WAL.Syncs)
+	require.EqualValues(t, 0, m.Commit.SyncWaitLatency.Count)
+	require.EqualValues(t, 0, m.Commit.Latency.Count)
+	require.EqualValues(t, 0, m.Commit.QueueWaitLatency.Count)
+
+	require.NoError(t, d.Set([]byte("c"), nil, Sync))
+	m = d.Metrics()
+	require.EqualValues(t, 1, m.WAL.Syncs)
+	require.EqualValues(t, 1, m.Commit.SyncWaitLatency.Count)
+	require.EqualValues(t, 1, m.Commit.Latency.Count)
+}
+
+func TestMetricsCompactionThroughput(t *testing.T) {
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.EqualValues(t, 0, d.Metrics().Compact.Throughput)
+
+	// The first compaction is a move of the flushed sstable, which does not
+	// contribute to the throughput.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	require.EqualValues(t, 0, d.Metrics().Compact.Throughput)
+
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	m := d.Metrics()
+	require.True(t, m.Compact.Throughput > 0)
+	require.EqualValues(t, 0, m.Compact.EstimatedDebt)
+	require.EqualValues(t, 0, m.Compact.EstimatedDrainTime)
+}
+
+func TestEstimatedDrainTime(t *testing.T) {
+	testCases := []struct {
+		debt        uint64
+		throughput  float64
+		concurrency int
+		expected    time.Duration
+	}{
+		{0, 1 << 20, 1, 0},
+		{1 << 30, 0, 1, 0},
+		{1 << 30, 1 << 20, 1, 1024 * time.Second},
+		{1 << 30, 1 << 20, 4, 256 * time.Second},
+		{1 << 30, 1 << 20, 0, 1024 * time.Second},
+	}
+	for _, c := range testCases {
+		require.Equal(t, c.expected, estimatedDrainTime(c.debt, c.throughput, c.concurrency))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// MigrateFromRocksDB performs a one-way migration of the RocksDB database in
+// dirname so that it may be used by pebble.
+//
+// The database is first verified to only use features which pebble
+// supports: a single column family, no two-phase commit or atomic groups,
+// sstables in a supported table format and compression, and a comparer and
+// merger matching those in opts. If verification fails, an error is returned
+// and the directory is left untouched.
+//
+// The database is then opened, which replays and flushes its WALs, writes a
+// new MANIFEST and OPTIONS file in pebble's encoding, and deletes the ones
+// written by RocksDB. The existing sstables are used as-is. Once migrated,
+// the database should only be opened by pebble: the MANIFEST may use
+// features RocksDB does not understand.
+func MigrateFromRocksDB(dirname string, opts *Options) error {
+	opts = opts.Clone()
+	if opts.ReadOnly {
+		return errors.New("pebble: cannot migrate a database in read-only mode")
+	}
+	opts.ErrorIfExists = false
+	opts.ErrorIfNotExists = true
+
+	if err := checkRocksDBCompatibility(dirname, opts); err != nil {
+		return errors.Wrapf(err, "pebble: database %q cannot be migrated", dirname)
+	}
+
+	d, err := Open(dirname, opts)
+	if err != nil {
+		return err
+	}
+	return d.Close()
+}
+
+// checkRocksDBCompatibility verifies that the database in dirname can be
+// opened by pebble without modifying it. Opening the database read-only
+// verifies the MANIFEST, the OPTIONS files and the WALs. Every live sstable
+// is then opened to verify its table format and properties.
+func checkRocksDBCompatibility(dirname string, opts *Options) error {
+	ro := opts.Clone()
+	ro.ReadOnly = true
+	d, err := Open(dirname, ro)
+	if err != nil {
+		return err
+	}
+
+	readState := d.loadReadState()
+	err = func() error {
+		for _, files := range readState.current.Levels {
+			for _, f := range files {
+				if err := d.tableCache.withReader(f, checkRocksDBTable); err != nil {
+					return errors.Wrapf(err, "table %s", f.FileNum)
+				}
+			}
+		}
+		return nil
+	}()
+	readState.unref()
+	return firstError(err, d.Close())
+}
+
+// checkRocksDBTable verifies the properties of an sstable written by RocksDB
+// which the reader does not verify itself.
+func checkRocksDBTable(r *sstable.Reader) error {
+	props := &r.Properties
+	switch props.ColumnFamilyID {
+	case 0, math.MaxInt32:
+	default:
+		return errors.Errorf("column family %d is not supported",
+			errors.Safe(props.ColumnFamilyID))
+	}
+	switch props.CompressionName {
+	case "", sstable.NoCompression.String(), sstable.SnappyCompression.String():
+	default:
+		return errors.Errorf("compression %q is not supported",
+			errors.Safe(props.CompressionName))
+	}
+	return nil
+}
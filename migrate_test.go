@@ -0,0 +1,60 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateFromRocksDB(t *testing.T) {
+	mem := vfs.NewMem()
+	_, err := vfs.Clone(vfs.Default, mem, filepath.Join("testdata", "db-stage-4"), "db")
+	require.NoError(t, err)
+
+	list := func() string {
+		ls, err := mem.List("db")
+		require.NoError(t, err)
+		sort.Strings(ls)
+		return strings.Join(ls, " ")
+	}
+	before := list()
+
+	// An incompatible comparer is detected, and the directory is left
+	// untouched.
+	comparer := *DefaultComparer
+	comparer.Name = "pebble.test.comparer"
+	err = MigrateFromRocksDB("db", &Options{FS: mem, Comparer: &comparer})
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "cannot be migrated"), err.Error())
+	require.Equal(t, before, list())
+
+	// A directory without a database cannot be migrated.
+	require.Error(t, MigrateFromRocksDB("nonexistent", &Options{FS: mem}))
+	require.Error(t, MigrateFromRocksDB("db", &Options{FS: mem, ReadOnly: true}))
+
+	// The RocksDB MANIFEST and OPTIONS files are replaced by ones written by
+	// pebble, and the RocksDB WAL is flushed to 000006.sst.
+	require.NoError(t, MigrateFromRocksDB("db", &Options{FS: mem}))
+	require.Equal(t, "000004.sst 000006.sst 000007.log CURRENT IDENTITY LOCK LOG "+
+		"LOG.old.1530059141872089 MANIFEST-000008 OPTIONS-000009", list())
+
+	d, err := Open("db", &Options{FS: mem, ErrorIfNotExists: true})
+	require.NoError(t, err)
+	iter := d.NewIter(nil)
+	var parts []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, "foo=five quux=six", strings.Join(parts, " "))
+	require.NoError(t, d.Close())
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"runtime"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -53,6 +54,22 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		opts.Cache.Ref()
 	}
 
+	if opts.DiskSlowThreshold > 0 {
+		opts.FS = vfs.WithDiskHealthChecks(opts.FS, opts.DiskSlowThreshold,
+			func(path string, duration time.Duration) {
+				opts.EventListener.DiskSlow(DiskSlowInfo{
+					Path:     path,
+					Duration: duration,
+				})
+			})
+	}
+
+	var tiered *tieredFS
+	if opts.Experimental.SecondaryFS != nil {
+		tiered = newTieredFS(opts.FS, opts.Experimental.SecondaryFS)
+		opts.FS = tiered
+	}
+
 	d := &DB{
 		cacheID:             opts.Cache.NewID(),
 		dirname:             dirname,
@@ -63,10 +80,13 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		merge:               opts.Merger.Merge,
 		split:               opts.Comparer.Split,
 		abbreviatedKey:      opts.Comparer.AbbreviatedKey,
-		largeBatchThreshold: (opts.MemTableSize - int(memTableEmptySize)) / 2,
+		largeBatchThreshold: (opts.MemTableSize - int(memTableEmptySize(opts.Experimental.MemTableShards))) / 2,
 		logRecycler:         logRecycler{limit: opts.MemTableStopWritesThreshold + 1},
 		closedCh:            make(chan struct{}),
+		tieredFS:            tiered,
+		keyspace:            opts.private.keyspace,
 	}
+	d.locks.init(d.cmp)
 
 	defer func() {
 		// If an error or panic occurs during open, attempt to release the manually
@@ -76,8 +96,12 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			// Release our references to the Cache. Note that both the DB, and
 			// tableCache have a reference. The tableCache.Close will release
 			// the tableCache's reference.
+			_ = d.closeKeyspaces()
 			opts.Cache.Unref()
 			_ = d.tableCache.Close()
+			if d.deletionPacer != nil {
+				d.deletionPacer.close()
+			}
 			for _, mem := range d.mu.mem.queue {
 				switch t := mem.flushable.(type) {
 				case *memTable:
@@ -104,19 +128,37 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		logSeqNum:     &d.mu.versions.logSeqNum,
 		visibleSeqNum: &d.mu.versions.visibleSeqNum,
 		apply:         d.commitApply,
-		write:         d.commitWrite,
+		write: func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+			return d.subscriptions.commitWrite(d, b, wg, err)
+		},
 	})
+	if weights := opts.Experimental.CommitClassWeights; len(weights) > 0 {
+		d.commit.enableFairQueuing(weights)
+	}
 	d.compactionLimiter = rate.NewLimiter(rate.Limit(d.opts.MinCompactionRate), d.opts.MinCompactionRate)
+	if d.opts.MaxCompactionRate > 0 {
+		d.compactionRateCap = rate.NewLimiter(rate.Limit(d.opts.MaxCompactionRate), d.opts.MaxCompactionRate)
+	}
 	d.flushLimiter = rate.NewLimiter(rate.Limit(d.opts.MinFlushRate), d.opts.MinFlushRate)
+	if r := d.opts.Experimental.TargetByteDeletionRate; r > 0 {
+		d.deletionPacer = newDeletionPacer(r, func(t obsoleteTable) {
+			d.deleteObsoleteFile(fileTypeTable, t.jobID, t.path, t.fileNum)
+		})
+	}
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
 	if d.mu.mem.nextSize > initialMemTableSize {
 		d.mu.mem.nextSize = initialMemTableSize
 	}
+	if minSize := opts.Experimental.MemTableMinSize; minSize > 0 && minSize < opts.MemTableSize {
+		d.memTableSizer = newMemTableSizer(minSize, opts.MemTableSize)
+		d.mu.mem.nextSize = minSize
+	}
 	d.mu.mem.cond.L = &d.mu.Mutex
 	d.mu.cleaner.cond.L = &d.mu.Mutex
 	d.mu.compact.cond.L = &d.mu.Mutex
 	d.mu.compact.inProgress = make(map[*compaction]struct{})
+	d.mu.compact.disableAutomatic = opts.DisableAutomaticCompactions
 	d.mu.snapshots.init()
 	// logSeqNum is the next sequence number that will be assigned. Start
 	// assigning sequence numbers from 1 to match rocksdb.
@@ -137,11 +179,19 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	// Open the database and WAL directories first in order to check for their
 	// existence.
 	var err error
+	if d.tieredFS != nil {
+		if err := d.tieredFS.scan(dirname); err != nil {
+			return nil, err
+		}
+	}
 	d.dataDir, err = opts.FS.OpenDir(dirname)
 	if err != nil {
 		return nil, err
 	}
-	if d.walDirname == "" {
+	if d.keyspace != nil {
+		// A keyspace shares the WAL directory of its parent.
+		d.walDirname = d.keyspace.parent.walDirname
+	} else if d.walDirname == "" {
 		d.walDirname = d.dirname
 	}
 	if d.walDirname == d.dirname {
@@ -159,14 +209,18 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}
 
-	// Lock the database directory.
-	fileLock, err := opts.FS.Lock(base.MakeFilename(opts.FS, dirname, fileTypeLock, 0))
-	if err != nil {
-		d.dataDir.Close()
-		if d.dataDir != d.walDir {
-			d.walDir.Close()
+	// Lock the database directory, unless it is locked by the primary of a
+	// follower.
+	var fileLock io.Closer
+	if !opts.private.follower {
+		fileLock, err = opts.FS.Lock(base.MakeFilename(opts.FS, dirname, fileTypeLock, 0))
+		if err != nil {
+			d.dataDir.Close()
+			if d.dataDir != d.walDir {
+				d.walDir.Close()
+			}
+			return nil, err
 		}
-		return nil, err
 	}
 	defer func() {
 		if fileLock != nil {
@@ -193,7 +247,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		if err := d.mu.versions.load(dirname, opts, &d.mu.Mutex); err != nil {
 			return nil, err
 		}
-		if err := d.mu.versions.currentVersion().CheckConsistency(dirname, opts.FS); err != nil {
+		if err := d.mu.versions.currentVersion().CheckConsistency(dirname, opts.FS, opts.Experimental.SharedFS); err != nil {
 			return nil, err
 		}
 	}
@@ -211,6 +265,18 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	if err != nil {
 		return nil, err
 	}
+	if d.keyspace != nil {
+		// The WAL directory of a keyspace is that of its parent, in which only
+		// the logs are shared with the keyspace.
+		n := 0
+		for _, filename := range ls {
+			if ft, _, ok := base.ParseFilename(opts.FS, filename); ok && ft == fileTypeLog {
+				ls[n] = filename
+				n++
+			}
+		}
+		ls = ls[:n]
+	}
 	if d.dirname != d.walDirname {
 		ls2, err := opts.FS.List(d.dirname)
 		if err != nil {
@@ -270,7 +336,26 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 	d.mu.versions.visibleSeqNum = d.mu.versions.logSeqNum
 
-	if !d.opts.ReadOnly {
+	// Ratchet the format major version of an existing DB, if a newer version
+	// was requested. The new version is persisted by the version edit below.
+	if !d.opts.ReadOnly && opts.FormatMajorVersion > d.mu.versions.formatMajorVersion {
+		ve.FormatMajorVersion = uint64(opts.FormatMajorVersion)
+	}
+
+	if !d.opts.ReadOnly && d.keyspace != nil {
+		// A keyspace does not have its own log, and its batches are written to
+		// the current log of its parent.
+		newLogNum := d.keyspace.parent.currentLogNum()
+		d.mu.versions.markFileNumUsed(newLogNum)
+		d.mu.mem.queue[len(d.mu.mem.queue)-1].logNum = newLogNum
+		ve.MinUnflushedLogNum = newLogNum
+		d.mu.versions.logLock()
+		if err := d.mu.versions.logAndApply(jobID, &ve, nil, d.dataDir, func() []compactionInfo {
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	} else if !d.opts.ReadOnly {
 		// Create an empty .log file.
 		newLogNum := d.mu.versions.getNextFileNum()
 		newLogName := base.MakeFilename(opts.FS, d.walDirname, fileTypeLog, newLogNum)
@@ -297,6 +382,10 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		})
 		d.mu.log.LogWriter = record.NewLogWriter(logFile, newLogNum)
 		d.mu.log.LogWriter.SetMinSyncInterval(d.opts.WALMinSyncInterval)
+		d.mu.log.LogWriter.SetGroupCommit(
+			int64(d.opts.WALGroupCommitMaxBytes), d.opts.WALGroupCommitMaxWait)
+		d.mu.log.LogWriter.SetMetrics(&d.walMetrics)
+		atomic.StoreUint64(&d.mu.log.num, uint64(newLogNum))
 		d.mu.versions.metrics.WAL.Files++
 
 		// This logic is slightly different than RocksDB's. Specifically, RocksDB
@@ -330,6 +419,14 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}
 
+	if d.keyspace == nil {
+		// The keyspaces retain the logs they have yet to replay, and must be
+		// opened before the obsolete logs are determined.
+		if err := d.openKeyspaces(); err != nil {
+			return nil, err
+		}
+	}
+
 	if !d.opts.ReadOnly {
 		d.scanObsoleteFiles(ls)
 		d.deleteObsoleteFiles(jobID)
@@ -446,6 +543,12 @@ func (d *DB) replayWAL(
 		mem = d.mu.mem.mutable
 		if mem != nil {
 			entry = d.mu.mem.queue[len(d.mu.mem.queue)-1]
+			if entry.flushable != mem {
+				// A large batch or ingested sstables were queued above the mutable
+				// memtable while replaying a previous log. Replay into a new memtable
+				// so that the queue remains ordered by sequence number.
+				mem, entry = nil, nil
+			}
 		}
 	}
 
@@ -467,6 +570,21 @@ func (d *DB) replayWAL(
 		}
 		mem, entry = nil, nil
 	}
+	// Flushes the memtables accumulated in toFlush to L0.
+	flushToL0 := func() error {
+		c := newFlush(d.opts, d.mu.versions.currentVersion(),
+			1 /* base level */, toFlush, &d.bytesFlushed)
+		newVE, _, err := d.runCompaction(jobID, c, nilPacer)
+		if err != nil {
+			return err
+		}
+		ve.NewFiles = append(ve.NewFiles, newVE.NewFiles...)
+		for i := range toFlush {
+			toFlush[i].readerUnref()
+		}
+		toFlush = toFlush[:0]
+		return nil
+	}
 	// Creates a new memtable if there is no current memtable.
 	ensureMem := func(seqNum uint64) {
 		if mem != nil {
@@ -478,36 +596,111 @@ func (d *DB) replayWAL(
 			d.mu.mem.queue = append(d.mu.mem.queue, entry)
 		}
 	}
+	var records int
+	replayError := func(err error) *WALReplayError {
+		return &WALReplayError{
+			FileNum: logNum,
+			Path:    filename,
+			Offset:  offset,
+			Records: records,
+			Err:     err,
+		}
+	}
 	for {
 		offset = rr.Offset()
 		r, err := rr.Next()
 		if err == nil {
 			_, err = io.Copy(&buf, r)
 		}
+		if err == nil && buf.Len() < batchHeaderLen {
+			err = errors.Errorf("pebble: corrupt log file %q (num %s)",
+				filename, errors.Safe(logNum))
+			if d.opts.WALRecoveryMode != WALRecoverySalvage {
+				return 0, replayError(err)
+			}
+		}
 		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if !record.IsInvalidRecord(err) && d.opts.WALRecoveryMode != WALRecoverySalvage {
+				return 0, replayError(err)
+			}
 			// It is common to encounter a zeroed or invalid chunk due to WAL
 			// preallocation and WAL recycling. We need to distinguish these errors
-			// from EOF in order to recognize that the record was truncated, but want
-			// to otherwise treat them like EOF.
-			if err == io.EOF || record.IsInvalidRecord(err) {
+			// from EOF in order to recognize that the record was truncated, but
+			// depending on the recovery mode, treat them like EOF.
+			stop, err := d.walRecoveryStop(rr, replayError(err))
+			if err != nil {
+				return 0, err
+			}
+			if stop {
 				break
 			}
-			return 0, errors.Wrap(err, "pebble: error when replaying WAL")
-		}
-
-		if buf.Len() < batchHeaderLen {
-			return 0, errors.Errorf("pebble: corrupt log file %q (num %s)",
-				filename, errors.Safe(logNum))
+			buf.Reset()
+			continue
 		}
+		records++
 
 		// Specify Batch.db so that Batch.SetRepr will compute Batch.memTableSize
 		// which is used below.
 		b = Batch{db: d}
 		b.SetRepr(buf.Bytes())
+		// The log is shared by a DB and its keyspaces, each of which replays
+		// only its own batches. See keyspace.
+		if id, repr, ok := b.keyspaceBatchRepr(); ok {
+			if d.keyspace == nil || d.keyspace.id != id {
+				buf.Reset()
+				continue
+			}
+			b.SetRepr(repr)
+		} else if d.keyspace != nil {
+			buf.Reset()
+			continue
+		}
 		seqNum := b.SeqNum()
 		maxSeqNum = seqNum + uint64(b.Count())
 
-		if b.memTableSize >= uint32(d.largeBatchThreshold) {
+		if fileNums, ok := b.ingestedSSTs(); ok {
+			// The batch records sstables which were ingested as a flushable (see
+			// DB.ingestAsFlushable). The sstables were linked into the DB
+			// directory before the batch was written.
+			flushMem()
+			meta := make([]*fileMetadata, len(fileNums))
+			for i, fileNum := range fileNums {
+				// The file number may have been allocated after the last MANIFEST
+				// update.
+				d.mu.versions.markFileNumUsed(fileNum)
+				path := base.MakeFilename(d.opts.FS, d.dirname, fileTypeTable, fileNum)
+				meta[i], err = ingestLoad1(d.opts, d.opts.FS, path, d.cacheID, fileNum)
+				if err == nil && meta[i] == nil {
+					err = errors.Errorf("pebble: ingested sstable %s is empty", errors.Safe(fileNum))
+				}
+				if err != nil {
+					return 0, replayError(err)
+				}
+			}
+			if err = ingestUpdateSeqNum(d.opts, d.dirname, seqNum, meta); err != nil {
+				return 0, err
+			}
+			if d.opts.ReadOnly {
+				entry := d.newFlushableEntry(
+					newIngestedFlushable(meta, d.cmp, d.opts.Logger, d.newIters,
+						d.tableCache.newRangeKeyIter), logNum, seqNum)
+				entry.releaseMemAccounting = func() {}
+				d.mu.mem.queue = append(d.mu.mem.queue, entry)
+			} else {
+				// Flush the preceding memtables before adding the ingested sstables
+				// to L0, so that the sstables produced by the flush have smaller
+				// sequence numbers than the ingested sstables.
+				if err = flushToL0(); err != nil {
+					return 0, err
+				}
+				for _, m := range meta {
+					ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: 0, Meta: m})
+				}
+			}
+		} else if b.memTableSize >= uint32(d.largeBatchThreshold) {
 			flushMem()
 			// Make a copy of the data slice since it is currently owned by buf and will
 			// be reused in the next iteration.
@@ -532,6 +725,10 @@ func (d *DB) replayWAL(
 			// largeBatchThreshold).
 			for err == arenaskl.ErrArenaFull {
 				flushMem()
+				if d.memTableSizer != nil {
+					// Adaptively sized memtables do not grow on their own.
+					d.mu.mem.nextSize = d.memTableSizer.grow(d.mu.mem.nextSize)
+				}
 				ensureMem(seqNum)
 				err = mem.prepare(&b)
 				if err != nil && err != arenaskl.ErrArenaFull {
@@ -548,20 +745,51 @@ func (d *DB) replayWAL(
 	flushMem()
 	// mem is nil here.
 	if !d.opts.ReadOnly {
-		c := newFlush(d.opts, d.mu.versions.currentVersion(),
-			1 /* base level */, toFlush, &d.bytesFlushed)
-		newVE, _, err := d.runCompaction(jobID, c, nilPacer)
-		if err != nil {
+		if err = flushToL0(); err != nil {
 			return 0, err
 		}
-		ve.NewFiles = append(ve.NewFiles, newVE.NewFiles...)
-		for i := range toFlush {
-			toFlush[i].readerUnref()
-		}
 	}
 	return maxSeqNum, nil
 }
 
+// walRecoveryStop is called when replay of a WAL encounters an unreadable
+// record, described by replayErr. It returns true if replay should stop,
+// treating the unreadable record as the end of the log, or an error if the
+// unreadable record indicates corruption that the recovery mode does not
+// tolerate. If replay should continue, rr is positioned to read the next
+// readable record.
+func (d *DB) walRecoveryStop(rr *record.Reader, replayErr *WALReplayError) (bool, error) {
+	switch d.opts.WALRecoveryMode {
+	case WALRecoveryStrict:
+		// A torn tail is tolerated, but a readable record anywhere following
+		// the unreadable record indicates corruption in the middle of the log.
+		// Scan the remainder of the log for one.
+		for {
+			rr.Recover()
+			r, err := rr.Next()
+			if err == nil {
+				_, err = io.Copy(ioutil.Discard, r)
+			}
+			if err == nil {
+				replayErr.ReadableOffset = rr.RecordOffset()
+				return true, replayErr
+			}
+			if err == io.EOF {
+				return true, nil
+			}
+			if !record.IsInvalidRecord(err) {
+				return true, err
+			}
+		}
+	case WALRecoverySalvage:
+		d.opts.Logger.Infof("%v: skipping to next readable record", replayErr)
+		rr.Recover()
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
 func checkOptions(opts *Options, path string) error {
 	f, err := opts.FS.Open(path)
 	if err != nil {
@@ -15,7 +15,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/record"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/kr/pretty"
 	"github.com/stretchr/testify/require"
@@ -311,7 +313,7 @@ func TestOpenReadOnly(t *testing.T) {
 		require.NoError(t, err)
 
 		// Verify various write operations fail in read-only mode.
-		require.EqualValues(t, ErrReadOnly, d.Compact(nil, nil))
+		require.EqualValues(t, ErrReadOnly, d.Compact(nil, nil, false))
 		require.EqualValues(t, ErrReadOnly, d.Flush())
 		require.EqualValues(t, ErrReadOnly, func() error { _, err := d.AsyncFlush(); return err }())
 
@@ -548,7 +550,7 @@ func TestOpenWALReplayReadOnlySeqNums(t *testing.T) {
 	// written to the MANIFEST. This produces a MANIFEST where the `logSeqNum`
 	// is greater than the sequence numbers contained in the
 	// `minUnflushedLogNum` log file
-	require.NoError(t, d.Compact([]byte("a"), []byte("a")))
+	require.NoError(t, d.Compact([]byte("a"), []byte("a"), false))
 
 	// While the MANIFEST is still in this state, copy all the files in the
 	// database to a new directory.
@@ -597,6 +599,121 @@ func TestOpenWALReplayMemtableGrowth(t *testing.T) {
 	db.Close()
 }
 
+func TestOpenWALRecoveryMode(t *testing.T) {
+	const numKeys = 20
+	value := []byte(strings.Repeat("a", 10<<10))
+
+	// makeWAL creates a DB whose data is contained entirely in a single WAL,
+	// and then modifies the WAL using the supplied function.
+	makeWAL := func(modify func(data []byte) []byte) vfs.FS {
+		mem := vfs.NewMem()
+		d, err := Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		for i := 0; i < numKeys; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%02d", i)), value, nil))
+		}
+		require.NoError(t, d.Close())
+
+		files, err := mem.List("")
+		require.NoError(t, err)
+		var logName string
+		var logSize int64
+		for _, fname := range files {
+			if !strings.HasSuffix(fname, ".log") {
+				continue
+			}
+			info, err := mem.Stat(fname)
+			require.NoError(t, err)
+			if info.Size() > logSize {
+				logName, logSize = fname, info.Size()
+			}
+		}
+		f, err := mem.Open(logName)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		data = modify(data)
+		f, err = mem.Create(logName)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		return mem
+	}
+	corruptMiddle := func(data []byte) []byte {
+		// Corrupt the third record.
+		data[25<<10] ^= 0xff
+		return data
+	}
+	truncateTail := func(data []byte) []byte {
+		return data[:len(data)-(5<<10)]
+	}
+
+	open := func(fs vfs.FS, mode WALRecoveryMode) (keys []string, _ error) {
+		d, err := Open("", &Options{FS: fs, WALRecoveryMode: mode})
+		if err != nil {
+			return nil, err
+		}
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		require.NoError(t, d.Close())
+		return keys, nil
+	}
+
+	t.Run("tolerate-torn-tail", func(t *testing.T) {
+		keys, err := open(makeWAL(corruptMiddle), WALRecoveryTolerateTornTail)
+		require.NoError(t, err)
+		require.Equal(t, []string{"00", "01"}, keys)
+
+		keys, err = open(makeWAL(truncateTail), WALRecoveryTolerateTornTail)
+		require.NoError(t, err)
+		require.Equal(t, numKeys-1, len(keys))
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := open(makeWAL(corruptMiddle), WALRecoveryStrict)
+		var replayErr *WALReplayError
+		require.True(t, errors.As(err, &replayErr), "%v", err)
+		require.Equal(t, 2, replayErr.Records)
+		require.True(t, replayErr.Offset > 0)
+		require.Equal(t, record.ErrInvalidChunk, replayErr.Err)
+		require.True(t, replayErr.ReadableOffset > replayErr.Offset)
+
+		// The readable record may follow several unreadable ones. The third
+		// record is corrupted in the first block of the log, the fifth and
+		// sixth in the second block, and the seventh, which starts in the
+		// second block, in the third block. The first readable record is in
+		// the fourth block.
+		_, err = open(makeWAL(func(data []byte) []byte {
+			data = corruptMiddle(data)
+			data[44<<10] ^= 0xff
+			data[54<<10] ^= 0xff
+			data[66<<10] ^= 0xff
+			return data
+		}), WALRecoveryStrict)
+		require.True(t, errors.As(err, &replayErr), "%v", err)
+		require.Equal(t, 2, replayErr.Records)
+		require.True(t, replayErr.ReadableOffset > 3*(32<<10), "%d", replayErr.ReadableOffset)
+
+		// A torn tail is tolerated.
+		keys, err := open(makeWAL(truncateTail), WALRecoveryStrict)
+		require.NoError(t, err)
+		require.Equal(t, numKeys-1, len(keys))
+	})
+
+	t.Run("salvage", func(t *testing.T) {
+		keys, err := open(makeWAL(corruptMiddle), WALRecoverySalvage)
+		require.NoError(t, err)
+		require.Equal(t, []string{"00", "01"}, keys[:2])
+		require.Equal(t, fmt.Sprintf("%02d", numKeys-1), keys[len(keys)-1])
+		require.True(t, len(keys) < numKeys)
+	})
+}
+
 func TestGetVersion(t *testing.T) {
 	mem := vfs.NewMem()
 	opts := &Options{
@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -62,6 +63,33 @@ type TablePropertyCollector = sstable.TablePropertyCollector
 // NeedCompacter exports the sstable.NeedCompacter type.
 type NeedCompacter = sstable.NeedCompacter
 
+// IterKeyType configures which types of keys an iterator should surface.
+type IterKeyType int8
+
+const (
+	// IterKeyTypePointsOnly configures an iterator to surface only point keys.
+	IterKeyTypePointsOnly IterKeyType = iota
+	// IterKeyTypeRangesOnly configures an iterator to surface only range keys.
+	IterKeyTypeRangesOnly
+	// IterKeyTypePointsAndRanges configures an iterator to surface both point
+	// keys and range keys.
+	IterKeyTypePointsAndRanges
+)
+
+// String implements fmt.Stringer.
+func (t IterKeyType) String() string {
+	switch t {
+	case IterKeyTypePointsOnly:
+		return "points-only"
+	case IterKeyTypeRangesOnly:
+		return "ranges-only"
+	case IterKeyTypePointsAndRanges:
+		return "points-and-ranges"
+	default:
+		return fmt.Sprintf("unknown(%d)", t)
+	}
+}
+
 // IterOptions hold the optional per-query parameters for NewIter.
 //
 // Like Options, a nil *IterOptions is valid and means to use the default
@@ -81,9 +109,38 @@ type IterOptions struct {
 	// iteration based on the user properties. Return true to scan the table and
 	// false to skip scanning.
 	TableFilter func(userProps map[string]string) bool
+	// PrefixIteration puts the iterator in prefix iteration mode for every
+	// seek: SeekGE behaves as SeekPrefixGE, constraining the iterator to keys
+	// that share the Comparer.Split defined prefix of the seek key. This allows
+	// bloom filters to be used on every seek and stops iteration at the end of
+	// the prefix without the need for an UpperBound. SeekLT, First and Last are
+	// unaffected and leave prefix iteration mode. Requires Comparer.Split.
+	PrefixIteration bool
+	// KeyTypes configures which types of keys the iterator surfaces. By
+	// default, only point keys are surfaced. If range keys are surfaced (see
+	// DB.RangeKeySet), the iterator is also positioned at the start of each
+	// span of user keys over which range keys are set, and the range keys
+	// overlapping the current position are exposed by Iterator.RangeKeys. The
+	// range keys are read when the iterator is created.
+	KeyTypes IterKeyType
+	// Timestamp, if non-zero, reads the versions of keys as of the timestamp.
+	// Requires a Comparer which splits a TimestampLen byte timestamp suffix
+	// from the prefix of a key and orders the versions of a prefix by
+	// descending timestamp, such as TimestampComparer. For every prefix, the
+	// iterator surfaces only the newest version whose timestamp is at or below
+	// Timestamp, and whose newest value is not deleted. Keys without a
+	// timestamp suffix are unaffected. If sstables record their timestamps
+	// (see NewTimestampPropertyCollector), the point keys of sstables which
+	// contain only newer versions are not read. Reverse iteration seeks to the
+	// newest version of each prefix, and is slower than forward iteration.
+	Timestamp uint64
 
 	// Internal options.
-	logger Logger
+	logger         Logger
+	rangeDelBudget *rangeDelBudget
+	// ctx, if non-nil, is the context of the iterator's sstable reads. See
+	// DB.NewIterWithContext.
+	ctx context.Context
 }
 
 // GetLowerBound returns the LowerBound or nil if the receiver is nil.
@@ -102,6 +159,16 @@ func (o *IterOptions) GetUpperBound() []byte {
 	return o.UpperBound
 }
 
+// withContext returns a copy of o which reads from sstables with ctx.
+func withContext(ctx context.Context, o *IterOptions) *IterOptions {
+	var opts IterOptions
+	if o != nil {
+		opts = *o
+	}
+	opts.ctx = ctx
+	return &opts
+}
+
 func (o *IterOptions) getLogger() Logger {
 	if o == nil || o.logger == nil {
 		return DefaultLogger
@@ -128,6 +195,15 @@ type WriteOptions struct {
 	//
 	// The default value is true.
 	Sync bool
+
+	// Class identifies the class of the writer for the purposes of fair
+	// queuing in the commit pipeline. It is an index into
+	// Options.Experimental.CommitClassWeights and is ignored if fair queuing is
+	// not configured. Classes outside of the configured range are treated as
+	// class 0.
+	//
+	// The default value is 0.
+	Class int
 }
 
 // Sync specifies the default write options for writes which synchronize to
@@ -143,6 +219,14 @@ func (o *WriteOptions) GetSync() bool {
 	return o == nil || o.Sync
 }
 
+// GetClass returns the Class value or 0 if the receiver is nil.
+func (o *WriteOptions) GetClass() int {
+	if o == nil {
+		return 0
+	}
+	return o.Class
+}
+
 // LevelOptions holds the optional per-level parameters.
 type LevelOptions struct {
 	// BlockRestartInterval is the number of keys between restart points
@@ -244,11 +328,45 @@ type Options struct {
 	// The default cache size is 8 MB.
 	Cache *cache.Cache
 
+	// TableCache, if set, is a TableCache shared with other DBs, which holds
+	// the open sstables of the DB. The TableCache must have been created with
+	// Cache. When sharing a TableCache, MaxOpenFiles bounds the number of
+	// sstables the DB may hold open in the TableCache.
+	//
+	// The default is a TableCache private to the DB, sized by MaxOpenFiles.
+	TableCache *TableCache
+
 	// Cleaner cleans obsolete files.
 	//
 	// The default cleaner uses the DeleteCleaner.
 	Cleaner Cleaner
 
+	// CompactionFilter, if set, is called at the start of each compaction with
+	// the level the compaction is writing to, and returns the CompactionFilter
+	// to invoke on the key/value pairs written by the compaction. Returning nil
+	// disables filtering for the compaction. See CompactionFilter.
+	CompactionFilter func(outputLevel int) CompactionFilter
+
+	// CompactionDebtSlowdownThreshold is the estimated compaction debt (see
+	// Metrics.Compact.EstimatedDebt), in bytes, at which writes are slowed
+	// down. Each write is delayed by up to WriteSlowdownMaxDelay, in
+	// proportion to how far the debt has progressed from this threshold
+	// towards CompactionDebtStopThreshold.
+	//
+	// The default value is 0, which disables slowing down writes due to
+	// compaction debt.
+	CompactionDebtSlowdownThreshold uint64
+
+	// CompactionDebtStopThreshold is the estimated compaction debt, in bytes,
+	// at which writes are stopped until compactions reduce the debt. The
+	// estimate includes the bytes of every non-empty L0 and LBase, so this
+	// threshold must be comfortably above the debt of the LSM in its steady
+	// state or writes may be stopped indefinitely.
+	//
+	// The default value is 0, which disables stopping writes due to
+	// compaction debt.
+	CompactionDebtStopThreshold uint64
+
 	// Comparer defines a total ordering over the space of []byte keys: a 'less
 	// than' relationship. The same comparison algorithm must be used for reads
 	// and writes over the lifetime of the DB.
@@ -261,6 +379,15 @@ type Options struct {
 	// or tools only, to check invariants over all the data in the database.
 	DebugCheck func(*DB) error
 
+	// DisableAutomaticCompactions disables the scheduling of automatic
+	// compactions. Flushes and manual compactions (see DB.Compact) are still
+	// performed. Note that writes will stall once L0StopWritesThreshold is
+	// reached if L0 is not compacted. Automatic compactions may be re-enabled
+	// at runtime with DB.SetDisableAutomaticCompactions.
+	//
+	// The default value is false.
+	DisableAutomaticCompactions bool
+
 	// Disable the write-ahead log (WAL). Disabling the write-ahead log prohibits
 	// crash recovery, but can improve performance if crash recovery is not
 	// needed (e.g. when only temporary state is being stored in the database).
@@ -268,6 +395,13 @@ type Options struct {
 	// TODO(peter): untested
 	DisableWAL bool
 
+	// DiskSlowThreshold is the duration after which an outstanding write or
+	// sync of a file created by the DB is reported via
+	// EventListener.DiskSlow. Each slow operation is reported once.
+	//
+	// The default value is 0, which disables disk health checking.
+	DiskSlowThreshold time.Duration
+
 	// ErrorIfExists is whether it is an error if the database already exists.
 	//
 	// The default value is false.
@@ -280,6 +414,14 @@ type Options struct {
 	// does not already exist.
 	ErrorIfNotExists bool
 
+	// FormatMajorVersion is the format major version of the DB. A new DB is
+	// created with this version, and an existing DB with an older version is
+	// ratcheted to this version when opened. An existing DB with a newer
+	// version is left unchanged. See FormatMajorVersion.
+	//
+	// The default value is FormatMostCompatible.
+	FormatMajorVersion FormatMajorVersion
+
 	// EventListener provides hooks to listening to significant DB events such as
 	// flushes, compactions, and table deletion.
 	EventListener EventListener
@@ -303,6 +445,29 @@ type Options struct {
 		// TODO(bilal): Experiment with this option to pick a good value.
 		FlushSplitBytes int64
 
+		// FlushSplitHints, if set, is called with the smallest and largest user
+		// keys of each flush and returns additional user keys at which the
+		// flushed L0 sstables are split. As with the L0 flush split keys, a
+		// split occurs after the last key in the flush that is less than or
+		// equal to a hint. The returned keys need not be sorted, and keys
+		// outside of the flush's bounds are ignored. Hints are
+		// combined with the L0 flush split keys and grandparent boundaries
+		// enabled by FlushSplitBytes, but take effect even when FlushSplitBytes
+		// is zero. Aligning L0 sstables with natural boundaries in the key space
+		// (e.g. tables or tenants) allows compactions out of L0 to proceed
+		// independently for each region.
+		FlushSplitHints func(smallest, largest []byte) [][]byte
+
+		// IngestAsFlushable, if true, avoids flushing the memtable when ingested
+		// sstables overlap it. Instead, the ingested sstables are recorded in the
+		// WAL and queued as a flushable above the memtable, and are added to L0
+		// once the memtables before them have been flushed. This eliminates the
+		// latency of waiting for a flush during ingestion, and of stalling the
+		// writes which are sequenced after the ingestion. Has no effect if the
+		// WAL is disabled, or if the format major version of the DB is older
+		// than FormatFlushableIngest.
+		IngestAsFlushable bool
+
 		// The threshold of L0 read-amplification at which compaction concurrency
 		// is enabled. Every multiple of this value enables another concurrent
 		// compaction up to MaxConcurrentCompactions.
@@ -317,11 +482,169 @@ type Options struct {
 		// read amplification as opposed to the count of L0 files.
 		L0SublevelCompactions bool
 
+		// MaxSubcompactions, if greater than 1, allows a single compaction to be
+		// split by key range into up to MaxSubcompactions subcompactions which
+		// run in parallel, each writing its own output sstables. The key ranges
+		// are chosen from the boundaries of the input sstables such that each
+		// subcompaction processes a similar number of bytes, and a compaction is
+		// only split if each subcompaction is expected to write at least one
+		// full-sized output sstable. Subcompactions reduce the duration of large
+		// compactions, such as L0->Lbase compactions following a burst of
+		// ingestions, at the cost of additional concurrency. Flushes and
+		// compactions into L0 are never split. The default value of 0 disables
+		// subcompactions.
+		MaxSubcompactions int
+
+		// CompactionDebtPacing enables pacing of compactions based on the
+		// compaction debt. Rather than proceeding as fast as possible whenever
+		// the compaction debt exceeds a threshold, compactions are rate limited
+		// such that the debt in excess of the threshold is paid down gradually,
+		// at a rate which rises and falls with the rate of incoming writes and
+		// is never lower than MinCompactionRate. This smooths the disk bandwidth
+		// used by compactions, reducing its impact on foreground latency.
+		// Compactions are not paced once incoming writes stop. The current rate
+		// is exposed in Metrics.Compact.PacedRate.
+		CompactionDebtPacing bool
+
+		// CommitClassWeights enables weighted fair queuing of commits, keyed by
+		// WriteOptions.Class. When the commit pipeline is saturated, waiting
+		// commits from class i are admitted in proportion to
+		// CommitClassWeights[i]. For example, weights of [4, 1] with foreground
+		// writers using class 0 and background writers using class 1 ensure that
+		// a burst of background writes cannot starve foreground writes. Weights
+		// less than 1 are treated as 1. If empty, commits are admitted in FIFO
+		// order.
+		CommitClassWeights []int
+
 		// DeleteRangeFlushDelay configures how long the database should wait
 		// before forcing a flush of a memtable that contains a range
 		// deletion. Disk space cannot be reclaimed until the range deletion
 		// is flushed. No automatic flush occurs if zero.
 		DeleteRangeFlushDelay time.Duration
+
+		// FollowerCatchUpInterval is the interval at which a follower (see
+		// OpenFollower) catches up with its primary in the background. Errors
+		// encountered while doing so are reported via
+		// EventListener.BackgroundError. If zero, a follower only catches up
+		// when DB.CatchUp is called.
+		FollowerCatchUpInterval time.Duration
+
+		// IterRangeDelMemoryLimit bounds the memory an Iterator may pin for the
+		// range deletion blocks of the sstables it has open. Once the limit is
+		// reached, the range deletion blocks of additional sstables are not
+		// retained between positioning operations and are instead re-read from
+		// the block cache on demand. This prevents scans across many sstables
+		// containing large numbers of range deletions from using unbounded
+		// memory, at the cost of additional block cache lookups. No limit is
+		// enforced if zero.
+		IterRangeDelMemoryLimit int64
+
+		// MemTableShards, if greater than 1, partitions the point keys in each
+		// memtable across the specified number of skiplists by a hash of the key
+		// prefix (see Comparer.Split). Point lookups only search the shard
+		// containing the key, and concurrent writes to disjoint prefixes are
+		// less likely to contend on the same skiplist. Iteration over the
+		// memtable, including during flushes, merges the shards and is thus
+		// somewhat slower. All of the shards share the memtable's arena, so
+		// MemTableSize continues to bound the memtable's memory usage. The
+		// default value of 0 uses a single skiplist.
+		MemTableShards int
+
+		// MemTableMinSize, if greater than 0 and less than MemTableSize, enables
+		// adaptive memtable sizing. The size of each new memtable is chosen
+		// between MemTableMinSize and MemTableSize based on the sustained write
+		// throughput and the backlog of memtables awaiting flush: the memtable
+		// grows during bursts of writes, reducing the number of flushes and the
+		// likelihood of write stalls, and shrinks once the writes subside so
+		// that large arenas are not permanently reserved. The default value of 0
+		// disables adaptive sizing, in which case memtables start small and
+		// grow to MemTableSize.
+		MemTableMinSize int
+
+		// MemTablePrefixBloomSizeRatio, if greater than 0, enables a bloom
+		// filter over the prefixes (see Comparer.Split) of the point keys in
+		// each memtable, allowing Get to skip memtables which definitely do not
+		// contain the key. The size of each memtable's filter is the specified
+		// fraction of the memtable's size, and is allocated in addition to
+		// MemTableSize. A ratio of 0.1 is typically sufficient for a low false
+		// positive rate with small keys and values. The default value of 0
+		// disables the filter.
+		MemTablePrefixBloomSizeRatio float64
+
+		// PrefetchDataBlocks, if true, causes sstable iterators performing a
+		// forward scan to read the next data block into the block cache in the
+		// background whenever they move to a new data block, so that Next does
+		// not wait on the read of the next block when it crosses the block
+		// boundary. At most one such read is in flight per sstable iterator.
+		// This is most beneficial on storage with high read latency, and has no
+		// effect on reverse iteration.
+		PrefetchDataBlocks bool
+
+		// DecompressionPool, if non-nil, reads and decompresses the data blocks
+		// prefetched by sstable iterators using the pool's workers, so that a
+		// scan is not serialized behind the decompression of its blocks on a
+		// single core. Setting DecompressionPool enables prefetching regardless
+		// of PrefetchDataBlocks. The pool may be shared with other DBs, and must
+		// be closed by the caller once the DBs using it have been closed.
+		DecompressionPool *sstable.DecompressionPool
+
+		// SecondaryFS is an optional second filesystem on which sstables may be
+		// placed, such as a slower but larger "cold" storage device. The DB
+		// directory is created on both filesystems, and sstables found in the
+		// DB directory of either filesystem are used when the DB is opened. WAL,
+		// MANIFEST and other metadata files always reside on FS.
+		SecondaryFS vfs.FS
+
+		// PlacementPolicy determines whether each sstable output by a flush or
+		// compaction is written to FS or to SecondaryFS. Ingested sstables are
+		// always placed on FS. If nil, all sstables are placed on FS. Requires
+		// SecondaryFS to be set. See LevelPlacementPolicy for a policy which
+		// places the lower levels of the LSM on SecondaryFS.
+		PlacementPolicy PlacementPolicy
+
+		// SharedFS is an optional filesystem holding sstables which are shared
+		// with other DBs, such as remote storage used to replicate data between
+		// DBs without copying it. Sstables on SharedFS may be ingested by
+		// reference using DB.IngestExternal, in which case they are read
+		// directly from SharedFS and are never deleted by the DB. Sstables
+		// written by flushes and compactions always reside on FS or
+		// SecondaryFS.
+		SharedFS vfs.FS
+
+		// TargetByteDeletionRate is the rate, in bytes per second, at which
+		// obsolete sstables are deleted. Deleting a large number of sstables at
+		// once, such as after a large compaction, can cause IO latency spikes on
+		// some filesystems. If non-zero, obsolete sstables are queued and deleted
+		// in the background at the target rate (see Metrics.Table for the size
+		// of the queue). The queue is drained without pacing when the DB is
+		// closed. The default value of 0 deletes obsolete sstables as soon as
+		// they are no longer in use. Note that sstables are deleted using
+		// Options.Cleaner, which may instead be used to hand deletions off to
+		// another process entirely.
+		TargetByteDeletionRate int
+
+		// StrictMaxOpenFiles enforces MaxOpenFiles as a hard limit on the number
+		// of sstable file descriptors held open by the table cache. By default,
+		// an sstable evicted from the table cache remains open until the
+		// iterators reading it are closed, allowing the limit to be exceeded. If
+		// set, the file descriptor of the least recently read sstable is closed
+		// instead, even if the sstable is in use, and the sstable is
+		// transparently reopened when next read. The rate of reopens is exposed
+		// by Metrics.TableReopens.
+		StrictMaxOpenFiles bool
+
+		// Deterministic makes the shape of the LSM a deterministic function of
+		// the sequence of operations performed on the DB, which is useful for
+		// tests. Flushes and compactions, as well as the loading of table stats,
+		// are run synchronously on the goroutine which triggered them rather than
+		// in the background, and at most one compaction is run at a time. For
+		// example, a write which fills the memtable returns only once the
+		// memtable has been flushed and any compactions made necessary by the
+		// flush have completed. The memtable skiplists are also seeded so that
+		// memtables fill up after the same writes on every run. Not recommended
+		// for production use, as foreground operations are delayed by background
+		// work.
+		Deterministic bool
 	}
 
 	// Filters is a map from filter policy name to filter policy. It is used for
@@ -333,11 +656,29 @@ type Options struct {
 	// FS provides the interface for persistent file storage.
 	//
 	// The default value uses the underlying operating system's file system.
+	// A DB stored entirely in memory is opened with vfs.NewMem, and may be
+	// persisted with DB.SaveArchive and restored with RestoreArchive.
 	FS vfs.FS
 
+	// Keyspaces holds the options of the keyspaces of the DB, indexed by name,
+	// which are used to open the existing keyspaces when the DB is opened.
+	// Keyspaces without an entry are opened with the default options. See
+	// DB.CreateKeyspace.
+	Keyspaces map[string]*Options
+
 	// The amount of L0 read-amplification necessary to trigger an L0 compaction.
 	L0CompactionThreshold int
 
+	// The amount of L0 read-amplification at which writes are slowed down.
+	// Each write is delayed by up to WriteSlowdownMaxDelay, in proportion to
+	// how far the read-amplification has progressed from this threshold
+	// towards L0StopWritesThreshold. Like L0StopWritesThreshold, this is
+	// measured against the number of L0 sublevels or files.
+	//
+	// The default value is L0StopWritesThreshold. A value greater than or equal
+	// to L0StopWritesThreshold disables slowing down writes.
+	L0SlowdownWritesThreshold int
+
 	// Hard limit on L0 read-amplification. Writes are stopped when this
 	// threshold is reached. If Experimental.L0SublevelCompactions is enabled
 	// this threshold is measured against the number of L0 sublevels. Otherwise
@@ -362,15 +703,35 @@ type Options struct {
 
 	// MaxManifestFileSize is the maximum size the MANIFEST file is allowed to
 	// become. When the MANIFEST exceeds this size it is rolled over and a new
-	// MANIFEST is created.
+	// MANIFEST is created, starting with a snapshot of the current version.
+	// The previous MANIFESTs are deleted once they are obsolete, subject to
+	// NumPrevManifest.
 	MaxManifestFileSize int64
 
+	// ManifestRecoveryMode controls how corruption is handled when reading
+	// the MANIFEST during Open. See ManifestRecoveryMode. The default value is
+	// ManifestRecoveryTolerateTornTail.
+	ManifestRecoveryMode ManifestRecoveryMode
+
 	// MaxOpenFiles is a soft limit on the number of open files that can be
-	// used by the DB.
+	// used by the DB. When the DB shares a TableCache, the sstables of the DB
+	// held open by the TableCache count against this limit.
 	//
 	// The default value is 1000.
 	MaxOpenFiles int
 
+	// MaxSuccessiveMerges bounds the number of successive merge operands of a
+	// key in the mutable memtable. A merge of a key which already has this
+	// many successive operands in the memtable is committed as a SET of the
+	// fully merged value of the key instead, which bounds the number of
+	// operands merged by reads of frequently merged keys, such as counters.
+	// Doing so requires reading the key while the commit pipeline waits for
+	// earlier writes to be applied, which delays concurrent writes. Merges in
+	// large and indexed batches are not collapsed.
+	//
+	// The default value is 0, which places no bound on successive merges.
+	MaxSuccessiveMerges int
+
 	// The size of a MemTable in steady state. The actual MemTable size starts at
 	// min(256KB, MemTableSize) and doubles for each subsequent MemTable up to
 	// MemTableSize. This reduces the memory pressure caused by MemTables for
@@ -401,11 +762,45 @@ type Options struct {
 	// default is 1 MB/s.
 	MinFlushRate int
 
+	// MaxCompactionRate, if greater than 0, is a hard limit on the combined
+	// rate, in bytes per second of input, at which compactions proceed,
+	// regardless of the compaction debt. Flushes are not limited. The default
+	// value of 0 places no limit on the compaction rate.
+	MaxCompactionRate int
+
 	// MaxConcurrentCompactions specifies the maximum number of concurrent
 	// compactions. The default is 1. Concurrent compactions are only performed
 	// when L0 read-amplification passes the L0CompactionConcurrency threshold.
 	MaxConcurrentCompactions int
 
+	// NumPrevManifest is the number of obsolete MANIFESTs to retain after the
+	// MANIFEST is rolled over. Retained MANIFESTs can be useful when debugging
+	// the history of the LSM. The oldest MANIFESTs are deleted first.
+	//
+	// The default value is 0, which deletes MANIFESTs as soon as they are
+	// obsolete.
+	NumPrevManifest int
+
+	// PeriodicCompactionPeriod is the age after which a table is compacted
+	// regardless of the shape of the LSM. Tables above the bottommost level
+	// are compacted into the next level, and tables in the bottommost level
+	// are rewritten in place. This ensures that range and point tombstones are
+	// eventually purged and that data is eventually rewritten with the current
+	// options (compression, filters, table format), even in key ranges which
+	// no longer receive writes. Periodic compactions have the lowest priority
+	// of all automatic compactions. Tables whose creation time is unknown are
+	// never compacted periodically. The default value is 0 which disables
+	// periodic compactions.
+	PeriodicCompactionPeriod time.Duration
+
+	// RateLimiter, if non-nil, limits the combined rate at which flushes and
+	// compactions write sstables, giving priority to flushes. The same
+	// RateLimiter may be shared by several DBs, and its rate may be changed at
+	// any time. See RateLimiter.
+	//
+	// The default value is nil, which places no limit on the rate.
+	RateLimiter *RateLimiter
+
 	// ReadOnly indicates that the DB should be opened in read-only mode. Writes
 	// to the DB will return an error, background compactions are disabled, and
 	// the flush that normally occurs after replaying the WAL at startup is
@@ -423,23 +818,58 @@ type Options struct {
 	// and lives for the lifetime of the table.
 	TablePropertyCollectors []func() TablePropertyCollector
 
+	// TraceRecorder, if set, records the operations performed on the DB to a
+	// trace which can be replayed with ReplayTrace. See TraceRecorder for the
+	// operations recorded.
+	TraceRecorder *TraceRecorder
+
 	// WALDir specifies the directory to store write-ahead logs (WALs) in. If
 	// empty (the default), WALs will be stored in the same directory as sstables
 	// (i.e. the directory passed to pebble.Open).
 	WALDir string
 
+	// WALGroupCommitMaxBytes bounds the number of bytes that may be awaiting a
+	// WAL sync while the sync is being delayed, either by WALGroupCommitMaxWait
+	// or by WALMinSyncInterval. Once this many bytes have been written to the
+	// WAL since the last sync, the sync is performed immediately. The default
+	// value is 0 which places no bound on the bytes awaiting sync.
+	WALGroupCommitMaxBytes int
+
+	// WALGroupCommitMaxWait is the maximum duration a WAL sync request will be
+	// delayed in order to allow concurrent commits to join the same sync (group
+	// commit). Under load, a small delay can substantially reduce the number of
+	// syncs performed. The default value is 0 which disables the delay.
+	WALGroupCommitMaxWait time.Duration
+
 	// WALMinSyncInterval is the minimum duration between syncs of the WAL. If
 	// WAL syncs are requested faster than this interval, they will be
 	// artificially delayed. Introducing a small artificial delay (500us) between
 	// WAL syncs can allow more operations to arrive and reduce IO operations
 	// while having a minimal impact on throughput. This option is supplied as a
 	// closure in order to allow the value to be changed dynamically. The default
-	// value is 0.
+	// value is 0. The realized sync rate can be monitored via Metrics.WAL.Syncs.
 	//
 	// TODO(peter): rather than a closure, should there be another mechanism for
 	// changing options dynamically?
 	WALMinSyncInterval func() time.Duration
 
+	// WALRecoveryMode controls how corruption is handled when replaying the WAL
+	// during Open. See WALRecoveryMode. The default value is
+	// WALRecoveryTolerateTornTail.
+	WALRecoveryMode WALRecoveryMode
+
+	// WriteSlowdownMaxDelay is the delay applied to each write when writes
+	// are slowed down and the L0 read-amplification or compaction debt has
+	// almost reached the threshold at which writes are stopped. The delay
+	// grows linearly from zero at L0SlowdownWritesThreshold (or
+	// CompactionDebtSlowdownThreshold) to WriteSlowdownMaxDelay at
+	// L0StopWritesThreshold (or CompactionDebtStopThreshold), so that writers
+	// are pushed back gradually rather than stopped abruptly. Delayed writes
+	// are reported via Metrics.WriteStall and EventListener.WriteStallBegin.
+	//
+	// The default value is 1ms.
+	WriteSlowdownMaxDelay time.Duration
+
 	// private options are only used by internal tests.
 	private struct {
 		// TODO(peter): A private option to enable flush/compaction pacing. Only used
@@ -450,8 +880,12 @@ type Options struct {
 		// A private option to disable stats collection.
 		disableTableStats bool
 
-		// A private option disable automatic compactions.
-		disableAutomaticCompactions bool
+		// The keyspace opened with these options, if any. Set by keyspace.open.
+		keyspace *keyspace
+
+		// Whether the DB is a follower opened by OpenFollower, in which case the
+		// DB directory is not locked.
+		follower bool
 	}
 }
 
@@ -480,6 +914,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.FS == nil {
 		o.FS = vfs.Default
 	}
+	if o.FormatMajorVersion == FormatDefault {
+		o.FormatMajorVersion = FormatMostCompatible
+	}
 	if o.Experimental.L0CompactionConcurrency <= 0 {
 		o.Experimental.L0CompactionConcurrency = 10
 	}
@@ -489,6 +926,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.L0StopWritesThreshold <= 0 {
 		o.L0StopWritesThreshold = 12
 	}
+	if o.L0SlowdownWritesThreshold <= 0 {
+		o.L0SlowdownWritesThreshold = o.L0StopWritesThreshold
+	}
 	if o.LBaseMaxBytes <= 0 {
 		o.LBaseMaxBytes = 64 << 20 // 64 MB
 	}
@@ -536,6 +976,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.MaxConcurrentCompactions <= 0 {
 		o.MaxConcurrentCompactions = 1
 	}
+	if o.WriteSlowdownMaxDelay <= 0 {
+		o.WriteSlowdownMaxDelay = time.Millisecond
+	}
 
 	o.initMaps()
 	return o
@@ -601,23 +1044,51 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
+	fmt.Fprintf(&buf, "  commit_class_weights=")
+	for i, w := range o.Experimental.CommitClassWeights {
+		if i > 0 {
+			fmt.Fprintf(&buf, ",")
+		}
+		fmt.Fprintf(&buf, "%d", w)
+	}
+	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
+	fmt.Fprintf(&buf, "  compaction_debt_pacing=%t\n", o.Experimental.CompactionDebtPacing)
+	fmt.Fprintf(&buf, "  compaction_debt_slowdown_threshold=%d\n", o.CompactionDebtSlowdownThreshold)
+	fmt.Fprintf(&buf, "  compaction_debt_stop_threshold=%d\n", o.CompactionDebtStopThreshold)
 	fmt.Fprintf(&buf, "  delete_range_flush_delay=%s\n", o.Experimental.DeleteRangeFlushDelay)
+	fmt.Fprintf(&buf, "  deterministic=%t\n", o.Experimental.Deterministic)
+	fmt.Fprintf(&buf, "  disable_automatic_compactions=%t\n", o.DisableAutomaticCompactions)
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
 	fmt.Fprintf(&buf, "  flush_split_bytes=%d\n", o.Experimental.FlushSplitBytes)
+	fmt.Fprintf(&buf, "  format_major_version=%d\n", o.FormatMajorVersion)
+	fmt.Fprintf(&buf, "  ingest_as_flushable=%t\n", o.Experimental.IngestAsFlushable)
+	fmt.Fprintf(&buf, "  iter_range_del_memory_limit=%d\n", o.Experimental.IterRangeDelMemoryLimit)
 	fmt.Fprintf(&buf, "  l0_compaction_concurrency=%d\n", o.Experimental.L0CompactionConcurrency)
 	fmt.Fprintf(&buf, "  l0_compaction_threshold=%d\n", o.L0CompactionThreshold)
+	fmt.Fprintf(&buf, "  l0_slowdown_writes_threshold=%d\n", o.L0SlowdownWritesThreshold)
 	fmt.Fprintf(&buf, "  l0_stop_writes_threshold=%d\n", o.L0StopWritesThreshold)
 	fmt.Fprintf(&buf, "  l0_sublevel_compactions=%t\n", o.Experimental.L0SublevelCompactions)
 	fmt.Fprintf(&buf, "  lbase_max_bytes=%d\n", o.LBaseMaxBytes)
+	fmt.Fprintf(&buf, "  max_compaction_rate=%d\n", o.MaxCompactionRate)
 	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions)
+	fmt.Fprintf(&buf, "  manifest_recovery_mode=%s\n", o.ManifestRecoveryMode)
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	fmt.Fprintf(&buf, "  max_subcompactions=%d\n", o.Experimental.MaxSubcompactions)
+	fmt.Fprintf(&buf, "  max_successive_merges=%d\n", o.MaxSuccessiveMerges)
+	fmt.Fprintf(&buf, "  mem_table_min_size=%d\n", o.Experimental.MemTableMinSize)
+	fmt.Fprintf(&buf, "  mem_table_prefix_bloom_size_ratio=%g\n", o.Experimental.MemTablePrefixBloomSizeRatio)
+	fmt.Fprintf(&buf, "  mem_table_shards=%d\n", o.Experimental.MemTableShards)
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  min_compaction_rate=%d\n", o.MinCompactionRate)
 	fmt.Fprintf(&buf, "  min_flush_rate=%d\n", o.MinFlushRate)
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
+	fmt.Fprintf(&buf, "  num_prev_manifest=%d\n", o.NumPrevManifest)
+	fmt.Fprintf(&buf, "  periodic_compaction_period=%s\n", o.PeriodicCompactionPeriod)
+	fmt.Fprintf(&buf, "  prefetch_data_blocks=%t\n", o.Experimental.PrefetchDataBlocks)
+	fmt.Fprintf(&buf, "  strict_max_open_files=%t\n", o.Experimental.StrictMaxOpenFiles)
 	fmt.Fprintf(&buf, "  table_property_collectors=[")
 	for i := range o.TablePropertyCollectors {
 		if i > 0 {
@@ -628,7 +1099,12 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "%s", o.TablePropertyCollectors[i]().Name())
 	}
 	fmt.Fprintf(&buf, "]\n")
+	fmt.Fprintf(&buf, "  target_byte_deletion_rate=%d\n", o.Experimental.TargetByteDeletionRate)
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
+	fmt.Fprintf(&buf, "  wal_group_commit_max_bytes=%d\n", o.WALGroupCommitMaxBytes)
+	fmt.Fprintf(&buf, "  wal_group_commit_max_wait=%s\n", o.WALGroupCommitMaxWait)
+	fmt.Fprintf(&buf, "  wal_recovery_mode=%s\n", o.WALRecoveryMode)
+	fmt.Fprintf(&buf, "  write_slowdown_max_delay=%s\n", o.WriteSlowdownMaxDelay)
 
 	for i := range o.Levels {
 		l := &o.Levels[i]
@@ -756,28 +1232,74 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Comparer, err = hooks.NewComparer(value)
 					}
 				}
+			case "commit_class_weights":
+				o.Experimental.CommitClassWeights = nil
+				if value != "" {
+					for _, w := range strings.Split(value, ",") {
+						var weight int
+						weight, err = strconv.Atoi(strings.TrimSpace(w))
+						if err != nil {
+							break
+						}
+						o.Experimental.CommitClassWeights = append(o.Experimental.CommitClassWeights, weight)
+					}
+				}
+			case "compaction_debt_pacing":
+				o.Experimental.CompactionDebtPacing, err = strconv.ParseBool(value)
+			case "compaction_debt_slowdown_threshold":
+				o.CompactionDebtSlowdownThreshold, err = strconv.ParseUint(value, 10, 64)
+			case "compaction_debt_stop_threshold":
+				o.CompactionDebtStopThreshold, err = strconv.ParseUint(value, 10, 64)
 			case "delete_range_flush_delay":
 				o.Experimental.DeleteRangeFlushDelay, err = time.ParseDuration(value)
+			case "deterministic":
+				o.Experimental.Deterministic, err = strconv.ParseBool(value)
+			case "disable_automatic_compactions":
+				o.DisableAutomaticCompactions, err = strconv.ParseBool(value)
 			case "disable_wal":
 				o.DisableWAL, err = strconv.ParseBool(value)
 			case "flush_split_bytes":
 				o.Experimental.FlushSplitBytes, err = strconv.ParseInt(value, 10, 64)
+			case "format_major_version":
+				var v uint64
+				v, err = strconv.ParseUint(value, 10, 64)
+				o.FormatMajorVersion = FormatMajorVersion(v)
+			case "ingest_as_flushable":
+				o.Experimental.IngestAsFlushable, err = strconv.ParseBool(value)
+			case "iter_range_del_memory_limit":
+				o.Experimental.IterRangeDelMemoryLimit, err = strconv.ParseInt(value, 10, 64)
 			case "l0_compaction_concurrency":
 				o.Experimental.L0CompactionConcurrency, err = strconv.Atoi(value)
 			case "l0_compaction_threshold":
 				o.L0CompactionThreshold, err = strconv.Atoi(value)
+			case "l0_slowdown_writes_threshold":
+				o.L0SlowdownWritesThreshold, err = strconv.Atoi(value)
 			case "l0_stop_writes_threshold":
 				o.L0StopWritesThreshold, err = strconv.Atoi(value)
 			case "l0_sublevel_compactions":
 				o.Experimental.L0SublevelCompactions, err = strconv.ParseBool(value)
 			case "lbase_max_bytes":
 				o.LBaseMaxBytes, err = strconv.ParseInt(value, 10, 64)
+			case "max_compaction_rate":
+				o.MaxCompactionRate, err = strconv.Atoi(value)
 			case "max_concurrent_compactions":
 				o.MaxConcurrentCompactions, err = strconv.Atoi(value)
+			case "manifest_recovery_mode":
+				o.ManifestRecoveryMode, err = parseManifestRecoveryMode(value)
 			case "max_manifest_file_size":
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "max_subcompactions":
+				o.Experimental.MaxSubcompactions, err = strconv.Atoi(value)
+			case "max_successive_merges":
+				o.MaxSuccessiveMerges, err = strconv.Atoi(value)
+			case "mem_table_min_size":
+				o.Experimental.MemTableMinSize, err = strconv.Atoi(value)
+			case "mem_table_prefix_bloom_size_ratio":
+				o.Experimental.MemTablePrefixBloomSizeRatio, err = strconv.ParseFloat(value, 64)
+			case "mem_table_shards":
+				o.Experimental.MemTableShards, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
@@ -786,6 +1308,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.MinCompactionRate, err = strconv.Atoi(value)
 			case "min_flush_rate":
 				o.MinFlushRate, err = strconv.Atoi(value)
+			case "num_prev_manifest":
+				o.NumPrevManifest, err = strconv.Atoi(value)
 			case "merger":
 				switch value {
 				case "nullptr":
@@ -797,6 +1321,12 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Merger, err = hooks.NewMerger(value)
 					}
 				}
+			case "periodic_compaction_period":
+				o.PeriodicCompactionPeriod, err = time.ParseDuration(value)
+			case "prefetch_data_blocks":
+				o.Experimental.PrefetchDataBlocks, err = strconv.ParseBool(value)
+			case "strict_max_open_files":
+				o.Experimental.StrictMaxOpenFiles, err = strconv.ParseBool(value)
 			case "table_format":
 				switch value {
 				case "leveldb":
@@ -808,8 +1338,18 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "table_property_collectors":
 				// TODO(peter): set o.TablePropertyCollectors
+			case "target_byte_deletion_rate":
+				o.Experimental.TargetByteDeletionRate, err = strconv.Atoi(value)
 			case "wal_dir":
 				o.WALDir = value
+			case "wal_group_commit_max_bytes":
+				o.WALGroupCommitMaxBytes, err = strconv.Atoi(value)
+			case "wal_group_commit_max_wait":
+				o.WALGroupCommitMaxWait, err = time.ParseDuration(value)
+			case "wal_recovery_mode":
+				o.WALRecoveryMode, err = parseWALRecoveryMode(value)
+			case "write_slowdown_max_delay":
+				o.WriteSlowdownMaxDelay, err = time.ParseDuration(value)
 			default:
 				if hooks != nil && hooks.SkipUnknown != nil && hooks.SkipUnknown(section+"."+key) {
 					return nil
@@ -927,10 +1467,25 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "MemTableSize (%s) must be < %s\n",
 			humanize.Uint64(uint64(o.MemTableSize)), humanize.Uint64(maxMemTableSize))
 	}
+	if o.CompactionDebtStopThreshold > 0 &&
+		o.CompactionDebtSlowdownThreshold > o.CompactionDebtStopThreshold {
+		fmt.Fprintf(&buf, "CompactionDebtSlowdownThreshold (%d) must be <= CompactionDebtStopThreshold (%d)\n",
+			o.CompactionDebtSlowdownThreshold, o.CompactionDebtStopThreshold)
+	}
 	if o.MemTableStopWritesThreshold < 2 {
 		fmt.Fprintf(&buf, "MemTableStopWritesThreshold (%d) must be >= 2\n",
 			o.MemTableStopWritesThreshold)
 	}
+	if o.Experimental.PlacementPolicy != nil && o.Experimental.SecondaryFS == nil {
+		fmt.Fprintf(&buf, "PlacementPolicy requires SecondaryFS\n")
+	}
+	if o.FormatMajorVersion > FormatNewest {
+		fmt.Fprintf(&buf, "FormatMajorVersion (%d) must be <= %d\n",
+			o.FormatMajorVersion, FormatNewest)
+	}
+	if o.TableCache != nil && o.TableCache.cache != o.Cache {
+		fmt.Fprintf(&buf, "TableCache must be created with Cache\n")
+	}
 	switch o.TableFormat {
 	case TableFormatLevelDB:
 		fmt.Fprintf(&buf, "TableFormatLevelDB not supported for DB\n")
@@ -949,6 +1504,8 @@ func (o *Options) MakeReaderOptions() sstable.ReaderOptions {
 		readerOpts.Cache = o.Cache
 		readerOpts.Comparer = o.Comparer
 		readerOpts.Filters = o.Filters
+		readerOpts.PrefetchDataBlocks = o.Experimental.PrefetchDataBlocks
+		readerOpts.DecompressionPool = o.Experimental.DecompressionPool
 		if o.Merger != nil {
 			readerOpts.MergerName = o.Merger.Name
 		}
@@ -47,25 +47,51 @@ func TestOptionsString(t *testing.T) {
   bytes_per_sync=524288
   cache_size=8388608
   cleaner=delete
+  commit_class_weights=
   comparer=leveldb.BytewiseComparator
+  compaction_debt_pacing=false
+  compaction_debt_slowdown_threshold=0
+  compaction_debt_stop_threshold=0
   delete_range_flush_delay=0s
+  deterministic=false
+  disable_automatic_compactions=false
   disable_wal=false
   flush_split_bytes=0
+  format_major_version=1
+  ingest_as_flushable=false
+  iter_range_del_memory_limit=0
   l0_compaction_concurrency=10
   l0_compaction_threshold=4
+  l0_slowdown_writes_threshold=12
   l0_stop_writes_threshold=12
   l0_sublevel_compactions=false
   lbase_max_bytes=67108864
+  max_compaction_rate=0
   max_concurrent_compactions=1
+  manifest_recovery_mode=tolerate-torn-tail
   max_manifest_file_size=134217728
   max_open_files=1000
+  max_subcompactions=0
+  max_successive_merges=0
+  mem_table_min_size=0
+  mem_table_prefix_bloom_size_ratio=0
+  mem_table_shards=0
   mem_table_size=4194304
   mem_table_stop_writes_threshold=2
   min_compaction_rate=4194304
   min_flush_rate=1048576
   merger=pebble.concatenate
+  num_prev_manifest=0
+  periodic_compaction_period=0s
+  prefetch_data_blocks=false
+  strict_max_open_files=false
   table_property_collectors=[]
+  target_byte_deletion_rate=0
   wal_dir=
+  wal_group_commit_max_bytes=0
+  wal_group_commit_max_wait=0s
+  wal_recovery_mode=tolerate-torn-tail
+  write_slowdown_max_delay=1ms
 
 [Level "0"]
   block_restart_interval=16
@@ -184,11 +210,15 @@ func TestOptionsParse(t *testing.T) {
 			opts.Comparer = c.comparer
 			opts.Merger = c.merger
 			opts.WALDir = "wal"
+			opts.WALGroupCommitMaxBytes = 1 << 20
+			opts.WALGroupCommitMaxWait = 500 * time.Microsecond
+			opts.WALRecoveryMode = WALRecoverySalvage
 			opts.Levels = make([]LevelOptions, 3)
 			opts.Levels[0].BlockSize = 1024
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
 			opts.Experimental.DeleteRangeFlushDelay = 10 * time.Second
+			opts.Experimental.CommitClassWeights = []int{4, 1}
 			opts.EnsureDefaults()
 			str := opts.String()
 
@@ -201,6 +231,7 @@ func TestOptionsParse(t *testing.T) {
 			}
 			require.Nil(t, parsedOptions.Cache)
 			require.NotEqual(t, newCacheSize, 0)
+			require.Equal(t, []int{4, 1}, parsedOptions.Experimental.CommitClassWeights)
 		})
 	}
 }
@@ -237,6 +268,13 @@ func TestOptionsValidate(t *testing.T) {
 			`MemTableStopWritesThreshold .* must be >= 2`,
 		},
 		{`
+[Options]
+  compaction_debt_slowdown_threshold=2
+  compaction_debt_stop_threshold=1
+`,
+			`CompactionDebtSlowdownThreshold .* must be <= CompactionDebtStopThreshold .*`,
+		},
+		{`
 [Options]
   table_format=leveldb
 `,
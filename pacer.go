@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -13,10 +14,27 @@ import (
 
 var nilPacer = &noopPacer{}
 
+// compactionDebtPacingInterval is the duration over which a compaction pacer
+// using debt pacing (see Options.Experimental.CompactionDebtPacing) aims to
+// pay down the compaction debt in excess of the slowdown threshold.
+const compactionDebtPacingInterval = 30 * time.Second
+
 type limiter interface {
 	DelayN(now time.Time, n int) time.Duration
 	AllowN(now time.Time, n int) bool
 	Burst() int
+	SetLimit(newLimit rate.Limit)
+}
+
+// pacingStats records the decisions made by the compaction pacers, and is
+// exposed in Metrics.Compact. All fields are accessed atomically.
+type pacingStats struct {
+	// rate is the rate, in bytes per second, most recently chosen by a
+	// compaction pacer using debt pacing, or 0 if compactions are unthrottled.
+	rate int64
+	// delay is the cumulative duration, in nanoseconds, that compactions have
+	// been delayed by pacing.
+	delay int64
 }
 
 // pacer is the interface for flush and compaction rate limiters. The rate limiter
@@ -26,10 +44,22 @@ type pacer interface {
 	maybeThrottle(bytesIterated uint64) error
 }
 
+// sleepDeferrer is implemented by the pacers whose sleeps can be deferred.
+// See internalPacer.deferSleeps.
+type sleepDeferrer interface {
+	deferSleeps(until *time.Time)
+}
+
 // internalPacer contains fields and methods common to both compactionPacer and
 // flushPacer.
 type internalPacer struct {
 	limiter limiter
+	// stats, if non-nil, records the time spent delayed by the pacer.
+	stats *pacingStats
+	// until, if non-nil, defers the sleeps of the pacer: rather than
+	// sleeping, the pacer extends *until to the time at which the sleep would
+	// have ended. See deferSleeps.
+	until *time.Time
 
 	iterCount             uint64
 	prevBytesIterated     uint64
@@ -41,31 +71,66 @@ type internalPacer struct {
 // threshold.
 func (p *internalPacer) limit(amount, currentLevel uint64) error {
 	if currentLevel <= p.slowdownThreshold {
-		burst := p.limiter.Burst()
-		for amount > uint64(burst) {
-			d := p.limiter.DelayN(time.Now(), burst)
-			if d == rate.InfDuration {
-				return errors.Errorf("pacing failed")
-			}
-			time.Sleep(d)
-			amount -= uint64(burst)
+		return p.wait(amount)
+	}
+	burst := p.limiter.Burst()
+	for amount > uint64(burst) {
+		p.limiter.AllowN(time.Now(), burst)
+		amount -= uint64(burst)
+	}
+	p.limiter.AllowN(time.Now(), int(amount))
+	return nil
+}
+
+// wait applies rate limiting to amount bytes, sleeping as necessary.
+func (p *internalPacer) wait(amount uint64) error {
+	burst := p.limiter.Burst()
+	for amount > uint64(burst) {
+		if err := p.sleep(p.limiter.DelayN(time.Now(), burst)); err != nil {
+			return err
 		}
-		d := p.limiter.DelayN(time.Now(), int(amount))
-		if d == rate.InfDuration {
-			return errors.Errorf("pacing failed")
+		amount -= uint64(burst)
+	}
+	return p.sleep(p.limiter.DelayN(time.Now(), int(amount)))
+}
+
+func (p *internalPacer) sleep(d time.Duration) error {
+	if d == rate.InfDuration {
+		return errors.Errorf("pacing failed")
+	}
+	if d <= 0 {
+		return nil
+	}
+	if p.until != nil {
+		// The delays returned by the limiter account for the earlier delays
+		// which have not been slept, so only the extension of *until is
+		// recorded as delay.
+		now := time.Now()
+		if p.until.Before(now) {
+			*p.until = now
 		}
-		time.Sleep(d)
-	} else {
-		burst := p.limiter.Burst()
-		for amount > uint64(burst) {
-			p.limiter.AllowN(time.Now(), burst)
-			amount -= uint64(burst)
+		if until := now.Add(d); until.After(*p.until) {
+			d = until.Sub(*p.until)
+			*p.until = until
+		} else {
+			d = 0
 		}
-		p.limiter.AllowN(time.Now(), int(amount))
+	} else {
+		time.Sleep(d)
+	}
+	if p.stats != nil {
+		atomic.AddInt64(&p.stats.delay, int64(d))
 	}
 	return nil
 }
 
+// deferSleeps defers the sleeps of the pacer, which instead extends *until
+// to the time at which they would have ended, allowing the caller to sleep
+// until then after releasing its locks.
+func (p *internalPacer) deferSleeps(until *time.Time) {
+	p.until = until
+}
+
 // compactionPacerInfo contains information necessary for compaction pacing.
 type compactionPacerInfo struct {
 	// slowdownThreshold is the low watermark for compaction debt. If compaction debt is
@@ -80,13 +145,26 @@ type compactionPacerInfo struct {
 	// pacer can monitor changes to this value to determine if user writes have
 	// stopped.
 	totalDirtyBytes uint64
+	// compactions is the number of compactions in progress, among which the
+	// rate chosen by debt pacing is divided.
+	compactions int
 }
 
 // compactionPacerEnv defines the environment in which the compaction rate limiter
 // is applied.
 type compactionPacerEnv struct {
+	// limiter is the rate limiter of the compaction. With debt pacing, the
+	// limit of the limiter is adjusted by the pacer, and the limiter must not
+	// be shared with other compactions.
 	limiter      limiter
 	memTableSize uint64
+	// debtPacing, if true, enables debt pacing (see
+	// Options.Experimental.CompactionDebtPacing), in which case minRate is the
+	// minimum rate at which compactions are paced.
+	debtPacing bool
+	minRate    uint64
+	// stats, if non-nil, records the pacing decisions of the pacer.
+	stats *pacingStats
 
 	getInfo func() compactionPacerInfo
 }
@@ -95,11 +173,20 @@ type compactionPacerEnv struct {
 // limiter is applied at a rate that keeps compaction debt at a steady level. If
 // compaction debt increases at a rate that is faster than the system can handle,
 // no rate limit is applied.
+//
+// With debt pacing, the rate limit is instead adjusted continuously such that
+// the compaction debt in excess of the slowdown threshold is paid down over
+// compactionDebtPacingInterval. As incoming writes add to the debt, the rate
+// rises to match them, avoiding the bursts of unthrottled compaction which
+// occur whenever the debt crosses the slowdown threshold. Each compaction has
+// its own limiter, whose limit is its share of the rate, so that the combined
+// rate of the compactions in progress is the chosen rate.
 type compactionPacer struct {
 	internalPacer
 	env                 compactionPacerEnv
 	totalCompactionDebt uint64
 	totalDirtyBytes     uint64
+	compactions         int
 }
 
 func newCompactionPacer(env compactionPacerEnv) *compactionPacer {
@@ -107,6 +194,7 @@ func newCompactionPacer(env compactionPacerEnv) *compactionPacer {
 		env: env,
 		internalPacer: internalPacer{
 			limiter: env.limiter,
+			stats:   env.stats,
 		},
 	}
 }
@@ -125,10 +213,13 @@ func (p *compactionPacer) maybeThrottle(bytesIterated uint64) error {
 	// Recalculate total compaction debt and the slowdown threshold only once
 	// every 1000 iterations or when the refresh threshold is hit since it
 	// requires grabbing DB.mu which is expensive.
-	if p.iterCount == 0 || bytesIterated > p.refreshBytesThreshold {
+	refresh := p.iterCount == 0 || bytesIterated > p.refreshBytesThreshold
+	writesStopped := false
+	if refresh {
 		pacerInfo := p.env.getInfo()
 		p.slowdownThreshold = pacerInfo.slowdownThreshold
 		p.totalCompactionDebt = pacerInfo.totalCompactionDebt
+		p.compactions = pacerInfo.compactions
 		p.refreshBytesThreshold = bytesIterated + (p.env.memTableSize * 5 / 100)
 		p.iterCount = 1000
 		if p.totalDirtyBytes == pacerInfo.totalDirtyBytes {
@@ -139,6 +230,7 @@ func (p *compactionPacer) maybeThrottle(bytesIterated uint64) error {
 			// nimble in the face of new user writes.
 			p.totalCompactionDebt += p.slowdownThreshold
 			p.iterCount = 100
+			writesStopped = true
 		}
 		p.totalDirtyBytes = pacerInfo.totalDirtyBytes
 	}
@@ -152,6 +244,28 @@ func (p *compactionPacer) maybeThrottle(bytesIterated uint64) error {
 	compactAmount := bytesIterated - p.prevBytesIterated
 	p.prevBytesIterated = bytesIterated
 
+	if p.env.debtPacing {
+		if refresh {
+			r := debtPacingRate(curCompactionDebt, p.slowdownThreshold, p.env.minRate)
+			if writesStopped {
+				r = rate.Inf
+			}
+			if r != rate.Inf && p.compactions > 1 {
+				p.limiter.SetLimit(r / rate.Limit(p.compactions))
+			} else {
+				p.limiter.SetLimit(r)
+			}
+			if p.env.stats != nil {
+				var statsRate int64
+				if r != rate.Inf {
+					statsRate = int64(r)
+				}
+				atomic.StoreInt64(&p.env.stats.rate, statsRate)
+			}
+		}
+		return p.wait(compactAmount)
+	}
+
 	// We slow down compactions when the compaction debt falls below the slowdown
 	// threshold, which is set dynamically based on the number of non-empty levels.
 	// This will only occur if compactions can keep up with the pace of flushes. If
@@ -160,6 +274,18 @@ func (p *compactionPacer) maybeThrottle(bytesIterated uint64) error {
 	return p.limit(compactAmount, curCompactionDebt)
 }
 
+// debtPacingRate returns the rate, in bytes per second, at which compactions
+// are paced by debt pacing. The compaction debt in excess of the slowdown
+// threshold is paid down over compactionDebtPacingInterval, and the rate is
+// never lower than minRate.
+func debtPacingRate(debt, slowdownThreshold, minRate uint64) rate.Limit {
+	r := rate.Limit(minRate)
+	if debt > slowdownThreshold {
+		r += rate.Limit(float64(debt-slowdownThreshold) / compactionDebtPacingInterval.Seconds())
+	}
+	return r
+}
+
 // flushPacerInfo contains information necessary for compaction pacing.
 type flushPacerInfo struct {
 	inuseBytes uint64
@@ -241,6 +367,41 @@ func (p *flushPacer) maybeThrottle(bytesIterated uint64) error {
 	return p.limit(flushAmount, dirtyBytes)
 }
 
+// cappedPacer limits the rate of a flush or compaction to a hard cap, in
+// addition to the pacing applied by the wrapped pacer. The limiter is shared
+// by all compactions, so the cap applies to their combined rate. See
+// Options.MaxCompactionRate.
+type cappedPacer struct {
+	internalPacer
+	pacer pacer
+}
+
+func newCappedPacer(p pacer, limiter limiter, stats *pacingStats) *cappedPacer {
+	return &cappedPacer{
+		pacer: p,
+		internalPacer: internalPacer{
+			limiter: limiter,
+			stats:   stats,
+		},
+	}
+}
+
+func (p *cappedPacer) deferSleeps(until *time.Time) {
+	p.internalPacer.deferSleeps(until)
+	if d, ok := p.pacer.(sleepDeferrer); ok {
+		d.deferSleeps(until)
+	}
+}
+
+func (p *cappedPacer) maybeThrottle(bytesIterated uint64) error {
+	if err := p.pacer.maybeThrottle(bytesIterated); err != nil {
+		return err
+	}
+	amount := bytesIterated - p.prevBytesIterated
+	p.prevBytesIterated = bytesIterated
+	return p.wait(amount)
+}
+
 type noopPacer struct{}
 
 func (p *noopPacer) maybeThrottle(_ uint64) error {
@@ -9,10 +9,13 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/stretchr/testify/require"
 )
 
 type mockCountLimiter struct {
@@ -35,6 +38,8 @@ func (m *mockCountLimiter) Burst() int {
 	return m.burst
 }
 
+func (m *mockCountLimiter) SetLimit(newLimit rate.Limit) {}
+
 type mockPrintLimiter struct {
 	buf   bytes.Buffer
 	burst int
@@ -54,6 +59,14 @@ func (m *mockPrintLimiter) Burst() int {
 	return m.burst
 }
 
+func (m *mockPrintLimiter) SetLimit(newLimit rate.Limit) {
+	if newLimit == rate.Inf {
+		fmt.Fprintf(&m.buf, "limit: inf\n")
+		return
+	}
+	fmt.Fprintf(&m.buf, "limit: %d\n", int64(newLimit))
+}
+
 func TestCompactionPacerMaybeThrottle(t *testing.T) {
 	datadriven.RunTest(t, "testdata/compaction_pacer_maybe_throttle",
 		func(d *datadriven.TestData) string {
@@ -68,6 +81,9 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 				var bytesIterated uint64
 				var currentTotal uint64
 				var slowdownThreshold uint64
+				var debtPacing bool
+				var minRate uint64
+				var compactions int
 				if len(d.Input) > 0 {
 					for _, data := range strings.Split(d.Input, "\n") {
 						parts := strings.Split(data, ":")
@@ -91,6 +107,12 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 							dirtyBytes = varValue
 						case "slowdownThreshold":
 							slowdownThreshold = varValue
+						case "debtPacing":
+							debtPacing = varValue != 0
+						case "minRate":
+							minRate = varValue
+						case "compactions":
+							compactions = int(varValue)
 						default:
 							return fmt.Sprintf("unknown command: %s", varKey)
 						}
@@ -105,11 +127,14 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 							slowdownThreshold:   slowdownThreshold,
 							totalCompactionDebt: currentTotal,
 							totalDirtyBytes:     dirtyBytes,
+							compactions:         compactions,
 						}
 					}
 					compactionPacer := newCompactionPacer(compactionPacerEnv{
 						limiter:      &mockLimiter,
 						memTableSize: 100,
+						debtPacing:   debtPacing,
+						minRate:      minRate,
 						getInfo:      getInfo,
 					})
 
@@ -147,3 +172,77 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 			}
 		})
 }
+
+// mockDelayLimiter delays each call to DelayN by a fixed duration.
+type mockDelayLimiter struct {
+	mockPrintLimiter
+	delay time.Duration
+}
+
+func (m *mockDelayLimiter) DelayN(now time.Time, n int) time.Duration {
+	m.mockPrintLimiter.DelayN(now, n)
+	return m.delay
+}
+
+func TestCappedPacer(t *testing.T) {
+	inner := &mockPrintLimiter{burst: 100}
+	innerPacer := newCompactionPacer(compactionPacerEnv{
+		limiter:      inner,
+		memTableSize: 1 << 20,
+		debtPacing:   true,
+		minRate:      10,
+		getInfo: func() compactionPacerInfo {
+			return compactionPacerInfo{totalDirtyBytes: 1}
+		},
+	})
+	limiter := &mockDelayLimiter{
+		mockPrintLimiter: mockPrintLimiter{burst: 100},
+		delay:            time.Millisecond,
+	}
+	var stats pacingStats
+	p := newCappedPacer(innerPacer, limiter, &stats)
+
+	// The cap is applied to the bytes iterated since the previous call, in
+	// addition to the pacing applied by the wrapped pacer.
+	require.NoError(t, p.maybeThrottle(5))
+	require.NoError(t, p.maybeThrottle(12))
+	require.NoError(t, p.maybeThrottle(250))
+	require.Equal(t, "limit: 10\nwait: 5\nwait: 7\nwait: 100\nwait: 100\nwait: 38\n", inner.buf.String())
+	require.Equal(t, "wait: 5\nwait: 7\nwait: 100\nwait: 100\nwait: 38\n", limiter.buf.String())
+
+	// Only the cap delayed the compaction.
+	require.Equal(t, int64(5*time.Millisecond), stats.delay)
+}
+
+func TestSubcompactionPacerDeferredSleep(t *testing.T) {
+	limiter := &mockDelayLimiter{
+		mockPrintLimiter: mockPrintLimiter{burst: 100},
+		delay:            100 * time.Millisecond,
+	}
+	var stats pacingStats
+	var bytesIterated uint64
+	sp := newSubcompactionPacer(newCappedPacer(nilPacer, limiter, &stats), &bytesIterated, 2)
+
+	// While a subcompaction sleeps, the other is not blocked from reporting
+	// its progress.
+	done := make(chan error, 1)
+	go func() { done <- sp.forSubcompaction(0).maybeThrottle(10) }()
+	for atomic.LoadUint64(&bytesIterated) != 10 {
+		time.Sleep(time.Millisecond)
+	}
+	reported := make(chan struct{})
+	go func() {
+		sp.mu.Lock()
+		sp.bytesIterated[1] = 5
+		sp.mu.Unlock()
+		close(reported)
+	}()
+	select {
+	case <-reported:
+	case <-done:
+		t.Fatal("subcompaction pacer held its mutex while sleeping")
+	}
+	require.NoError(t, <-done)
+	require.Equal(t, "wait: 10\n", limiter.buf.String())
+	require.Equal(t, int64(100*time.Millisecond), stats.delay)
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// RecoveryTarget specifies the point in time to which RecoverToPointInTime
+// recovers a DB. If both fields are set, the earlier of the two points is
+// used.
+type RecoveryTarget struct {
+	// SeqNum, if non-zero, is the largest sequence number of the writes which
+	// are recovered. Batches are recovered atomically: a batch is recovered
+	// only if the sequence numbers of all of its writes are at most SeqNum.
+	SeqNum uint64
+	// Time, if non-zero, is the time after which writes are not recovered.
+	// The WAL does not record when each batch was written, so recovery to a
+	// time has the granularity of a WAL: the writes of the WALs which were
+	// last modified after Time are not recovered, which may exclude writes
+	// that preceded Time.
+	Time time.Time
+}
+
+// RecoverToPointInTime recovers the DB in dirname, which holds a checkpoint
+// of the DB (see DB.Checkpoint), to a point in time after the checkpoint was
+// taken, by replaying the archived WALs of the DB on top of the checkpoint up
+// to the specified target. This allows recovering from an operator error,
+// such as the deletion of data, which occurred after the checkpoint.
+//
+// The WALs are read from archiveDir, which is the archive directory of the
+// DB when configured with ArchiveCleaner. In order to recover the writes
+// which were not yet archived, the WALs of the DB directory may be copied
+// into archiveDir. Ingested sstables which were recorded in the WAL (see
+// DB.Ingest) are also read from archiveDir if they are not present in
+// dirname.
+//
+// The unflushed WALs of the checkpoint are replaced by the archived WALs,
+// truncated after the last batch which precedes the target. The recovered
+// writes are applied when the DB is next opened with Open. The sequence
+// number of the last recovered write is returned. An error is returned if the
+// sstables of the checkpoint contain writes which follow the target, or if
+// the sequence numbers of the recovered batches are not contiguous with those
+// of the checkpoint and with each other, as when an intermediate WAL is
+// missing from archiveDir, or when sstables were ingested after the
+// checkpoint without being recorded in the WAL.
+func RecoverToPointInTime(
+	dirname string, opts *Options, archiveDir string, target RecoveryTarget,
+) (lastSeqNum uint64, err error) {
+	opts = opts.Clone().EnsureDefaults()
+	fs := opts.FS
+	walDirname := dirname
+	if opts.WALDir != "" {
+		walDirname = opts.WALDir
+	}
+
+	m, err := readManifest(fs, dirname, opts.Comparer.Name)
+	if err != nil {
+		return 0, err
+	}
+	v, _, err := m.bve.Apply(nil, opts.Comparer.Compare, opts.Comparer.FormatKey,
+		opts.Experimental.FlushSplitBytes)
+	if err != nil {
+		return 0, err
+	}
+	for l := range v.Levels {
+		for _, f := range v.Levels[l] {
+			if lastSeqNum < f.LargestSeqNum {
+				lastSeqNum = f.LargestSeqNum
+			}
+		}
+	}
+	if target.SeqNum != 0 && target.SeqNum < lastSeqNum {
+		return 0, errors.Errorf(
+			"pebble: recovery target %d precedes the sstables of the checkpoint, which contain sequence number %d",
+			errors.Safe(target.SeqNum), errors.Safe(lastSeqNum))
+	}
+
+	// Find the WALs following the checkpoint's flushed state. The archived
+	// copy of a WAL is preferred, as the copy in the checkpoint may be
+	// incomplete.
+	logs := make(map[FileNum]string)
+	for _, dir := range []string{walDirname, archiveDir} {
+		ls, err := fs.List(dir)
+		if err != nil && !(dir == archiveDir && os.IsNotExist(err)) {
+			return 0, err
+		}
+		for _, filename := range ls {
+			ft, fn, ok := base.ParseFilename(fs, filename)
+			if ok && ft == fileTypeLog && fn >= m.minUnflushedLogNum {
+				logs[fn] = fs.PathJoin(dir, filename)
+			}
+		}
+	}
+	logNums := make([]FileNum, 0, len(logs))
+	for fn := range logs {
+		logNums = append(logNums, fn)
+	}
+	sort.Slice(logNums, func(i, j int) bool {
+		return logNums[i] < logNums[j]
+	})
+
+	// Rewrite the WALs up to the target, and remove the checkpoint's WALs
+	// which follow it. The batches of the first WAL may precede the last
+	// sequence number recorded in the checkpoint's MANIFEST, which can be
+	// written after the WAL was created, but no batch may follow it.
+	reached := false
+	nextSeqNum := m.logSeqNum
+	for _, logNum := range logNums {
+		destPath := base.MakeFilename(fs, walDirname, fileTypeLog, logNum)
+		if !reached && !target.Time.IsZero() {
+			info, err := fs.Stat(logs[logNum])
+			if err != nil {
+				return 0, err
+			}
+			reached = info.ModTime().After(target.Time)
+		}
+		if reached {
+			if err := fs.Remove(destPath); err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+			continue
+		}
+		var last uint64
+		last, reached, err = recoverWAL(opts, dirname, archiveDir, logs[logNum], destPath, logNum,
+			target.SeqNum, m.logSeqNum, &nextSeqNum)
+		if err != nil {
+			return 0, err
+		}
+		if lastSeqNum < last {
+			lastSeqNum = last
+		}
+	}
+
+	dir, err := fs.OpenDir(walDirname)
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return 0, err
+	}
+	return lastSeqNum, nil
+}
+
+// recoverWAL copies the batches of the WAL srcPath whose writes precede
+// targetSeqNum (if non-zero) to destPath, which is replaced. It returns the
+// sequence number of the last write copied, and whether a batch following the
+// target was found.
+//
+// *nextSeqNum is the sequence number expected of the next batch, which is
+// advanced past each batch copied. A batch whose sequence number follows
+// *nextSeqNum is an error, as the batches in between are missing. A batch
+// whose sequence number precedes *nextSeqNum is an error too, unless
+// *nextSeqNum is still checkpointSeqNum, the next sequence number recorded in
+// the checkpoint's MANIFEST. The batches of a keyspace (see keyspace) are not
+// checked, as they are numbered by the keyspace.
+func recoverWAL(
+	opts *Options,
+	dirname, archiveDir, srcPath, destPath string,
+	logNum FileNum,
+	targetSeqNum, checkpointSeqNum uint64,
+	nextSeqNum *uint64,
+) (lastSeqNum uint64, reached bool, err error) {
+	fs := opts.FS
+	src, err := fs.Open(srcPath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer src.Close()
+
+	tmpPath := destPath + ".tmp"
+	tmp, err := fs.Create(tmpPath)
+	if err != nil {
+		return 0, false, err
+	}
+	w := record.NewLogWriter(tmp, logNum)
+	defer func() {
+		if w != nil {
+			w.Close()
+			_ = fs.Remove(tmpPath)
+		}
+	}()
+
+	var buf bytes.Buffer
+	rr := record.NewReader(src, logNum)
+	for {
+		r, err := rr.Next()
+		if err == nil {
+			buf.Reset()
+			_, err = io.Copy(&buf, r)
+		}
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			// The end of the log, or a torn tail, which is treated as the end
+			// of the log as when the WAL is replayed.
+			break
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if buf.Len() < batchHeaderLen {
+			return 0, false, errors.Errorf("pebble: corrupt log file %q (num %s)",
+				srcPath, errors.Safe(logNum))
+		}
+
+		var b Batch
+		b.SetRepr(buf.Bytes())
+		seqNum, count := b.SeqNum(), uint64(b.Count())
+		if targetSeqNum != 0 && seqNum+count > targetSeqNum+1 {
+			reached = true
+			break
+		}
+		if _, _, ok := b.keyspaceBatchRepr(); !ok {
+			if seqNum > *nextSeqNum || (seqNum < *nextSeqNum && *nextSeqNum != checkpointSeqNum) {
+				return 0, false, errors.Errorf(
+					"pebble: batch in log file %q (num %s) has sequence number %d, expected %d",
+					srcPath, errors.Safe(logNum), errors.Safe(seqNum), errors.Safe(*nextSeqNum))
+			}
+			if *nextSeqNum < seqNum+count {
+				*nextSeqNum = seqNum + count
+			}
+		}
+		if fileNums, ok := b.ingestedSSTs(); ok {
+			for _, fileNum := range fileNums {
+				if err := recoverIngestedTable(fs, dirname, archiveDir, fileNum); err != nil {
+					return 0, false, err
+				}
+			}
+		}
+		if _, err := w.WriteRecord(buf.Bytes()); err != nil {
+			return 0, false, err
+		}
+		if count > 0 {
+			lastSeqNum = seqNum + count - 1
+		}
+	}
+
+	err = w.Close()
+	w = nil
+	if err != nil {
+		_ = fs.Remove(tmpPath)
+		return 0, false, err
+	}
+	if err := fs.Rename(tmpPath, destPath); err != nil {
+		return 0, false, err
+	}
+	return lastSeqNum, reached, nil
+}
+
+// recoverIngestedTable copies an sstable which was ingested as recorded in
+// the WAL from archiveDir into dirname, unless it is already present.
+func recoverIngestedTable(fs vfs.FS, dirname, archiveDir string, fileNum FileNum) error {
+	destPath := base.MakeFilename(fs, dirname, fileTypeTable, fileNum)
+	if _, err := fs.Stat(destPath); err == nil {
+		return nil
+	}
+	srcPath := base.MakeFilename(fs, archiveDir, fileTypeTable, fileNum)
+	return vfs.Copy(fs, srcPath, destPath)
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverToPointInTime(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, Cleaner: ArchiveCleaner{}}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+
+	lastSeqNum := func() uint64 {
+		return atomic.LoadUint64(&d.mu.versions.visibleSeqNum) - 1
+	}
+	// copyDir copies the files of src, or only its WALs, to dest.
+	copyDir := func(src, dest string, onlyLogs bool) {
+		require.NoError(t, mem.MkdirAll(dest, 0755))
+		ls, err := mem.List(src)
+		require.NoError(t, err)
+		for _, filename := range ls {
+			path := mem.PathJoin(src, filename)
+			info, err := mem.Stat(path)
+			require.NoError(t, err)
+			if info.IsDir() {
+				continue
+			}
+			if ft, _, ok := base.ParseFilename(mem, filename); onlyLogs && (!ok || ft != fileTypeLog) {
+				continue
+			}
+			require.NoError(t, vfs.Copy(mem, path, mem.PathJoin(dest, filename)))
+		}
+	}
+	// recoverTo recovers a copy of the checkpoint to the target, and returns
+	// the contents of the recovered DB.
+	var recoveries int
+	recoverTo := func(archiveDir string, target RecoveryTarget) (uint64, string) {
+		recoveries++
+		dir := fmt.Sprintf("recovered%d", recoveries)
+		copyDir("checkpoint", dir, false)
+		seqNum, err := RecoverToPointInTime(dir, opts, archiveDir, target)
+		require.NoError(t, err)
+		d, err := Open(dir, opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, d.Close())
+		}()
+		iter := d.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return seqNum, strings.Join(parts, " ")
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("z"), []byte("0"), nil))
+	require.NoError(t, d.Delete([]byte("z"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Checkpoint("checkpoint"))
+
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, d.Flush())
+	beforeDelete := lastSeqNum()
+	time.Sleep(10 * time.Millisecond)
+	beforeDeleteTime := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	// The operator error, which is followed by more writes, some of which are
+	// flushed while others are only in the WAL.
+	b := d.NewBatch()
+	require.NoError(t, b.Delete([]byte("a"), nil))
+	require.NoError(t, b.Delete([]byte("b"), nil))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Set([]byte("d"), []byte("4"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("e"), []byte("5"), nil))
+	require.NoError(t, d.Close())
+
+	// Recovering to the last write before the operator error, either by
+	// sequence number or by time, undoes it.
+	seqNum, contents := recoverTo("db/archive", RecoveryTarget{SeqNum: beforeDelete})
+	require.Equal(t, beforeDelete, seqNum)
+	require.Equal(t, "a=1 b=2 c=3", contents)
+	seqNum, contents = recoverTo("db/archive", RecoveryTarget{Time: beforeDeleteTime})
+	require.Equal(t, beforeDelete, seqNum)
+	require.Equal(t, "a=1 b=2 c=3", contents)
+
+	// Batches are recovered atomically.
+	seqNum, contents = recoverTo("db/archive", RecoveryTarget{SeqNum: beforeDelete + 1})
+	require.Equal(t, beforeDelete, seqNum)
+	require.Equal(t, "a=1 b=2 c=3", contents)
+
+	// Without a target, the archived WALs are recovered, and the WALs of the
+	// DB directory are also recovered if copied into the archive.
+	_, contents = recoverTo("db/archive", RecoveryTarget{})
+	require.Equal(t, "c=3 d=4", contents)
+	copyDir("db/archive", "archive", false)
+	copyDir("db", "archive", true)
+	_, contents = recoverTo("archive", RecoveryTarget{})
+	require.Equal(t, "c=3 d=4 e=5", contents)
+
+	// A missing intermediate WAL is detected, rather than recovering the
+	// writes which follow it.
+	copyDir("archive", "incomplete", false)
+	ls, err := mem.List("incomplete")
+	require.NoError(t, err)
+	var logNums []FileNum
+	for _, filename := range ls {
+		if ft, fn, ok := base.ParseFilename(mem, filename); ok && ft == fileTypeLog {
+			logNums = append(logNums, fn)
+		}
+	}
+	sort.Slice(logNums, func(i, j int) bool { return logNums[i] < logNums[j] })
+	require.True(t, len(logNums) >= 3, "%v", logNums)
+	missing := logNums[len(logNums)-2]
+	require.NoError(t, mem.Remove(base.MakeFilename(mem, "incomplete", fileTypeLog, missing)))
+	copyDir("checkpoint", "recovered-incomplete", false)
+	_, err = RecoverToPointInTime("recovered-incomplete", opts, "incomplete", RecoveryTarget{})
+	require.Error(t, err)
+	require.Regexp(t, "has sequence number .*, expected", err.Error())
+	// Recovering to a target preceding the missing WAL succeeds.
+	seqNum, err = RecoverToPointInTime("recovered-incomplete", opts, "incomplete",
+		RecoveryTarget{SeqNum: beforeDelete})
+	require.NoError(t, err)
+	require.Equal(t, beforeDelete, seqNum)
+
+	// The flushed writes of the checkpoint cannot be undone.
+	_, err = RecoverToPointInTime("checkpoint", opts, "db/archive", RecoveryTarget{SeqNum: 2})
+	require.Error(t, err)
+}
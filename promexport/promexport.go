@@ -0,0 +1,325 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package promexport exports the metrics of a DB (see DB.Metrics) as
+// Prometheus metrics with stable names and labels. Collect converts the
+// metrics into metric families, which WriteText formats in the Prometheus
+// text exposition format, and Handler serves the metrics of a DB, collected
+// on each scrape.
+//
+// The package does not depend on the Prometheus client library. A
+// prometheus.Collector is a thin wrapper around Collect, which describes
+// each Family with a prometheus.Desc and each Sample with
+// prometheus.MustNewConstMetric (or MustNewConstSummary for summaries).
+//
+// All metric names are prefixed with "pebble_". Per-level metrics carry a
+// "level" label. Durations are exported in seconds, and latency
+// distributions as summaries with quantiles 0.5, 0.9, 0.99 and 0.999.
+package promexport // import "github.com/cockroachdb/pebble/promexport"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Type is the type of a metric family.
+type Type int
+
+const (
+	// Counter is a cumulative metric which only increases, such as the number
+	// of flushes.
+	Counter Type = iota
+	// Gauge is a metric which may increase and decrease, such as the number
+	// of files in a level.
+	Gauge
+	// Summary is a distribution, exported as quantiles together with the sum
+	// and count of the observations.
+	Summary
+)
+
+// String implements fmt.Stringer, returning the name of the type in the
+// exposition format.
+func (t Type) String() string {
+	switch t {
+	case Counter:
+		return "counter"
+	case Gauge:
+		return "gauge"
+	case Summary:
+		return "summary"
+	}
+	return "untyped"
+}
+
+// Label is a label of a sample.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single sample of a metric family.
+type Sample struct {
+	// Name is the name of the sample, which is the name of its family, or for
+	// summaries, the name of its family suffixed by "_sum" or "_count".
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// Family is a metric family: a set of samples with the same name and type,
+// distinguished by their labels.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Quantiles are the quantiles exported for summaries.
+var Quantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// collector accumulates the families of Collect.
+type collector struct {
+	families []Family
+}
+
+func (c *collector) add(name, help string, typ Type, value float64, labels ...Label) {
+	name = "pebble_" + name
+	if n := len(c.families); n == 0 || c.families[n-1].Name != name {
+		c.families = append(c.families, Family{Name: name, Help: help, Type: typ})
+	}
+	f := &c.families[len(c.families)-1]
+	f.Samples = append(f.Samples, Sample{Name: name, Labels: labels, Value: value})
+}
+
+func (c *collector) counter(name, help string, value float64, labels ...Label) {
+	c.add(name, help, Counter, value, labels...)
+}
+
+func (c *collector) gauge(name, help string, value float64, labels ...Label) {
+	c.add(name, help, Gauge, value, labels...)
+}
+
+// summary adds a summary of a histogram of values in units of scale. A
+// histogram of nanoseconds is exported in seconds with a scale of 1e-9.
+func (c *collector) summary(name, help string, h *pebble.HistogramSnapshot, scale float64) {
+	name = "pebble_" + name
+	f := Family{Name: name, Help: help, Type: Summary}
+	for _, q := range Quantiles {
+		f.Samples = append(f.Samples, Sample{
+			Name:   name,
+			Labels: []Label{{"quantile", strconv.FormatFloat(q, 'g', -1, 64)}},
+			Value:  float64(h.ValueAtQuantile(q*100)) * scale,
+		})
+	}
+	f.Samples = append(f.Samples,
+		Sample{Name: name + "_sum", Value: float64(h.Sum) * scale},
+		Sample{Name: name + "_count", Value: float64(h.Count)})
+	c.families = append(c.families, f)
+}
+
+func (c *collector) cache(name, desc string, m *pebble.CacheMetrics) {
+	c.gauge(name+"_size_bytes", "The number of bytes in use by the "+desc+".", float64(m.Size))
+	c.gauge(name+"_count", "The number of objects in the "+desc+".", float64(m.Count))
+	c.counter(name+"_hits_total", "The number of "+desc+" hits.", float64(m.Hits))
+	c.counter(name+"_misses_total", "The number of "+desc+" misses.", float64(m.Misses))
+}
+
+// Collect converts the metrics of a DB into metric families. The families,
+// and the samples within each family, are returned in a stable order.
+func Collect(m *pebble.Metrics) []Family {
+	var c collector
+	const nanos = 1e-9
+
+	c.cache("block_cache", "block cache", &m.BlockCache)
+	c.cache("table_cache", "table cache", &m.TableCache)
+	c.counter("table_cache_evictions_total",
+		"The number of sstables evicted from the table cache.", float64(m.TableCacheEvictions))
+	c.gauge("table_open_files",
+		"The number of sstable file descriptors held open.", float64(m.TableOpenFiles))
+	c.counter("table_reopens_total",
+		"The number of sstables reopened after their file descriptor was closed.", float64(m.TableReopens))
+	c.gauge("table_iterators",
+		"The number of open sstable iterators.", float64(m.TableIters))
+
+	c.summary("commit_latency_seconds",
+		"The end-to-end latency of commits.", &m.Commit.Latency, nanos)
+	c.summary("commit_queue_wait_latency_seconds",
+		"The time commits spent queued waiting for a commit slot.", &m.Commit.QueueWaitLatency, nanos)
+	c.summary("commit_wal_write_latency_seconds",
+		"The time commits spent writing to the WAL.", &m.Commit.WALWriteLatency, nanos)
+	c.summary("commit_memtable_apply_latency_seconds",
+		"The time commits spent applying batches to the memtable.", &m.Commit.MemTableApplyLatency, nanos)
+	c.summary("commit_sync_wait_latency_seconds",
+		"The time commits spent waiting for the WAL to be synced.", &m.Commit.SyncWaitLatency, nanos)
+
+	const compactionsHelp = "The number of compactions, by type."
+	c.counter("compactions_total", compactionsHelp, float64(m.Compact.DefaultCount), Label{"type", "default"})
+	c.counter("compactions_total", compactionsHelp, float64(m.Compact.DeleteOnlyCount), Label{"type", "delete-only"})
+	c.counter("compactions_total", compactionsHelp, float64(m.Compact.MoveCount), Label{"type", "move"})
+	c.gauge("compaction_estimated_debt_bytes",
+		"An estimate of the number of bytes that need to be compacted.", float64(m.Compact.EstimatedDebt))
+	c.gauge("compaction_estimated_drain_time_seconds",
+		"An estimate of the time needed to compact the estimated debt.", m.Compact.EstimatedDrainTime.Seconds())
+	c.gauge("compaction_in_progress_bytes",
+		"The number of bytes in the inputs of in-progress compactions.", float64(m.Compact.InProgressBytes))
+	c.gauge("compactions_in_progress",
+		"The number of in-progress compactions.", float64(m.Compact.NumInProgress))
+	c.gauge("compaction_paced_rate_bytes_per_second",
+		"The rate at which compactions were most recently paced.", float64(m.Compact.PacedRate))
+	c.counter("compaction_pacing_delay_seconds_total",
+		"The cumulative time compactions have been delayed by pacing.", m.Compact.PacingDelay.Seconds())
+	c.gauge("compaction_throughput_bytes_per_second",
+		"A moving average of the throughput of recent compactions.", float64(m.Compact.Throughput))
+
+	c.counter("flushes_total", "The number of flushes.", float64(m.Flush.Count))
+	c.counter("filter_hits_total",
+		"The number of data block reads avoided by filters.", float64(m.Filter.Hits))
+	c.counter("filter_misses_total",
+		"The number of filter checks which did not avoid a data block read.", float64(m.Filter.Misses))
+
+	c.gauge("read_amplification", "The read amplification of the DB.", float64(m.ReadAmp()))
+	levels := []struct {
+		name, help string
+		typ        Type
+		value      func(l *pebble.LevelMetrics) float64
+	}{
+		{"level_sublevels", "The number of sublevels within the level.", Gauge,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.Sublevels) }},
+		{"level_files", "The number of files in the level.", Gauge,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.NumFiles) }},
+		{"level_size_bytes", "The size of the files in the level.", Gauge,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.Size) }},
+		{"level_score", "The compaction score of the level.", Gauge,
+			func(l *pebble.LevelMetrics) float64 { return l.Score }},
+		{"level_bytes_in_total", "The number of bytes read into the level by compactions.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.BytesIn) }},
+		{"level_bytes_ingested_total", "The number of bytes ingested into the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.BytesIngested) }},
+		{"level_bytes_moved_total", "The number of bytes moved into the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.BytesMoved) }},
+		{"level_bytes_read_total", "The number of bytes read by compactions at the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.BytesRead) }},
+		{"level_bytes_compacted_total", "The number of bytes written to the level by compactions.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.BytesCompacted) }},
+		{"level_bytes_flushed_total", "The number of bytes written to the level by flushes.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.BytesFlushed) }},
+		{"level_tables_compacted_total", "The number of sstables compacted to the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.TablesCompacted) }},
+		{"level_tables_flushed_total", "The number of sstables flushed to the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.TablesFlushed) }},
+		{"level_tables_ingested_total", "The number of sstables ingested into the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.TablesIngested) }},
+		{"level_tables_moved_total", "The number of sstables moved to the level.", Counter,
+			func(l *pebble.LevelMetrics) float64 { return float64(l.TablesMoved) }},
+	}
+	for _, lm := range levels {
+		for i := range m.Levels {
+			c.add(lm.name, lm.help, lm.typ, lm.value(&m.Levels[i]), Label{"level", strconv.Itoa(i)})
+		}
+	}
+
+	c.gauge("memtable_size_bytes",
+		"The number of bytes allocated by memtables and large batches.", float64(m.MemTable.Size))
+	c.gauge("memtables", "The number of memtables.", float64(m.MemTable.Count))
+	c.gauge("memtable_zombie_size_bytes",
+		"The number of bytes in zombie memtables.", float64(m.MemTable.ZombieSize))
+	c.gauge("memtable_zombies", "The number of zombie memtables.", float64(m.MemTable.ZombieCount))
+
+	c.gauge("table_zombie_size_bytes",
+		"The number of bytes in zombie sstables.", float64(m.Table.ZombieSize))
+	c.gauge("table_zombies", "The number of zombie sstables.", float64(m.Table.ZombieCount))
+	c.gauge("table_pending_deletion_size_bytes",
+		"The number of bytes in obsolete sstables queued for deletion.", float64(m.Table.PendingDeletionSize))
+	c.gauge("table_pending_deletions",
+		"The number of obsolete sstables queued for deletion.", float64(m.Table.PendingDeletionCount))
+
+	c.gauge("wal_files", "The number of live WAL files.", float64(m.WAL.Files))
+	c.gauge("wal_obsolete_files", "The number of obsolete WAL files.", float64(m.WAL.ObsoleteFiles))
+	c.gauge("wal_size_bytes", "The size of the live data in the WAL files.", float64(m.WAL.Size))
+	c.counter("wal_bytes_in_total",
+		"The number of logical bytes written to the WAL.", float64(m.WAL.BytesIn))
+	c.counter("wal_bytes_written_total",
+		"The number of bytes written to the WAL.", float64(m.WAL.BytesWritten))
+	c.counter("wal_syncs_total", "The number of WAL syncs.", float64(m.WAL.Syncs))
+	c.summary("wal_sync_latency_seconds", "The latency of WAL syncs.", &m.WAL.SyncLatency, nanos)
+
+	const stallsHelp = "The number of times writes were stopped, by reason."
+	c.counter("write_stalls_total", stallsHelp, float64(m.WriteStall.L0Count), Label{"reason", "l0"})
+	c.counter("write_stalls_total", stallsHelp, float64(m.WriteStall.MemTableCount), Label{"reason", "memtable"})
+	c.counter("write_stalls_total", stallsHelp,
+		float64(m.WriteStall.CompactionDebtCount), Label{"reason", "compaction-debt"})
+	c.counter("write_stall_duration_seconds_total",
+		"The cumulative duration for which writes were stopped.", m.WriteStall.Duration.Seconds())
+	c.counter("write_slowdowns_total",
+		"The number of writes delayed by write slowdowns.", float64(m.WriteStall.SlowdownCount))
+	c.counter("write_slowdown_delay_seconds_total",
+		"The cumulative delay of writes by write slowdowns.", m.WriteStall.SlowdownDelay.Seconds())
+	return c.families
+}
+
+// WriteText writes the metric families in the Prometheus text exposition
+// format.
+func WriteText(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for i := range families {
+		f := &families[i]
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, helpEscaper.Replace(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(s.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for j, l := range s.Labels {
+					if j > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", l.Name, labelEscaper.Replace(l.Value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler returns an http.Handler which serves the metrics of the DB in the
+// Prometheus text exposition format. The metrics are collected from the DB on
+// each request.
+func Handler(d *pebble.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families := Collect(d.Metrics())
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteText(w, families); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package promexport
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	datadriven.RunTest(t, "testdata/collect", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "collect":
+			// The metrics are set to distinct values so that the output shows
+			// which field each sample is exported from.
+			var m pebble.Metrics
+			m.BlockCache = pebble.CacheMetrics{Size: 1, Count: 2, Hits: 3, Misses: 4}
+			m.TableCache = pebble.CacheMetrics{Size: 5, Count: 6, Hits: 7, Misses: 8}
+			m.TableCacheEvictions = 9
+			m.TableOpenFiles = 10
+			m.TableIters = 11
+			m.Compact.DefaultCount = 12
+			m.Compact.DeleteOnlyCount = 13
+			m.Compact.MoveCount = 14
+			m.Compact.EstimatedDebt = 15
+			m.Compact.PacingDelay = 1500 * time.Millisecond
+			m.Flush.Count = 16
+			m.Filter.Hits = 17
+			m.Levels[0] = pebble.LevelMetrics{Sublevels: 2, NumFiles: 3, Size: 1 << 20, Score: 1.5}
+			m.Levels[6] = pebble.LevelMetrics{Sublevels: 1, NumFiles: 18, BytesCompacted: 19}
+			m.MemTable.Size = 20
+			m.MemTable.Count = 1
+			m.WAL.Files = 1
+			m.WAL.BytesWritten = 21
+			m.WAL.Syncs = 22
+			m.WriteStall.L0Count = 23
+			m.WriteStall.Duration = time.Second
+
+			var buf strings.Builder
+			require.NoError(t, WriteText(&buf, Collect(&m)))
+			return buf.String()
+
+		default:
+			return "unknown command: " + td.Cmd
+		}
+	})
+}
+
+func TestCollectNames(t *testing.T) {
+	// Metric names are unique, and the names of the samples of a family
+	// derive from the name of the family.
+	families := Collect(&pebble.Metrics{})
+	names := make(map[string]bool)
+	for _, f := range families {
+		require.True(t, strings.HasPrefix(f.Name, "pebble_"), f.Name)
+		require.False(t, names[f.Name], "duplicate metric %s", f.Name)
+		names[f.Name] = true
+		for _, s := range f.Samples {
+			switch s.Name {
+			case f.Name:
+			case f.Name + "_sum", f.Name + "_count":
+				require.Equal(t, Summary, f.Type)
+			default:
+				t.Fatalf("sample %s of metric %s", s.Name, f.Name)
+			}
+		}
+		if f.Type == Counter {
+			require.True(t, strings.HasSuffix(f.Name, "_total"), f.Name)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	d, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		Handler(d).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+		body, err := ioutil.ReadAll(w.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// The metrics are collected on each scrape.
+	require.Contains(t, scrape(), "\npebble_flushes_total 0\n")
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	body := scrape()
+	require.Contains(t, body, "\npebble_flushes_total 1\n")
+	require.Contains(t, body, "\npebble_level_files{level=\"0\"} 1\n")
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/pebble/internal/base"
+
+// rangeDelBudget bounds the memory pinned by the sstable range deletion
+// blocks that are open within an Iterator. An open range deletion iterator
+// normally pins its (fragmented) range deletion block in memory. Once the
+// budget is exhausted, additional range deletion iterators are opened in a
+// spilling mode in which the block is only pinned for the duration of each
+// positioning operation and is re-read from the block cache (or from disk if
+// it has been evicted) on demand. See Options.Experimental.IterRangeDelMemoryLimit.
+//
+// A rangeDelBudget is not safe for concurrent use, mirroring Iterator.
+type rangeDelBudget struct {
+	limit int64
+	used  int64
+}
+
+// reserve attempts to reserve n bytes from the budget, returning false if the
+// reservation would exceed the limit.
+func (b *rangeDelBudget) reserve(n int64) bool {
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *rangeDelBudget) release(n int64) {
+	b.used -= n
+}
+
+// budgetedRangeDelIter wraps a range deletion iterator which pins its block,
+// returning the block's reservation to the budget when closed.
+type budgetedRangeDelIter struct {
+	internalIterator
+	budget *rangeDelBudget
+	size   int64
+}
+
+func (i *budgetedRangeDelIter) Close() error {
+	if i.budget != nil {
+		i.budget.release(i.size)
+		i.budget = nil
+	}
+	return i.internalIterator.Close()
+}
+
+type spillPos int8
+
+const (
+	spillUnpositioned spillPos = iota
+	spillValid
+	spillBeforeFirst
+	spillAfterLast
+)
+
+// spillingRangeDelIter is a range deletion iterator that does not retain its
+// range deletion block between positioning operations. Each operation
+// re-opens the underlying iterator, re-positions it at the current tombstone
+// if necessary, and copies the resulting tombstone before closing the
+// underlying iterator again. The retained memory is thus bounded by the size
+// of the tombstones referenced by the caller.
+//
+// Repositioning relies on the fragmented tombstones within a range deletion
+// block having distinct internal keys, which is guaranteed as two tombstones
+// in the same sstable cannot share both a start key and a sequence number.
+type spillingRangeDelIter struct {
+	cmp     Compare
+	newIter func() (internalIterator, error)
+	closeFn func()
+	pos     spillPos
+	key     InternalKey
+	value   []byte
+	err     error
+}
+
+var _ internalIterator = (*spillingRangeDelIter)(nil)
+
+// op opens the underlying iterator, invokes fn on it, and saves the tombstone
+// the iterator is positioned at. If the iterator is exhausted, the position is
+// set to exhausted.
+func (i *spillingRangeDelIter) op(
+	exhausted spillPos, fn func(it internalIterator) (*InternalKey, []byte),
+) (*InternalKey, []byte) {
+	if i.err != nil {
+		return nil, nil
+	}
+	it, err := i.newIter()
+	if err != nil {
+		i.err = err
+		return nil, nil
+	}
+	if it == nil {
+		// The table has no range deletions.
+		i.pos = exhausted
+		return nil, nil
+	}
+	k, v := fn(it)
+	if k == nil {
+		i.pos = exhausted
+	} else {
+		// NB: callers such as rangedel.SeekGE and mergingIter retain the
+		// returned key and value across subsequent positioning operations, as
+		// they would otherwise point into the immutable block. A new buffer is
+		// allocated for every tombstone to preserve that guarantee.
+		buf := make([]byte, len(k.UserKey)+len(v))
+		n := copy(buf, k.UserKey)
+		copy(buf[n:], v)
+		i.pos = spillValid
+		i.key = InternalKey{UserKey: buf[:n:n], Trailer: k.Trailer}
+		i.value = buf[n:]
+	}
+	if err := it.Close(); err != nil {
+		i.err = err
+		return nil, nil
+	}
+	if i.pos != spillValid {
+		return nil, nil
+	}
+	return &i.key, i.value
+}
+
+// reposition positions it at the tombstone the iterator was previously
+// positioned at.
+func (i *spillingRangeDelIter) reposition(it internalIterator) *InternalKey {
+	k, _ := it.SeekGE(i.key.UserKey)
+	for k != nil && base.InternalCompare(i.cmp, *k, i.key) < 0 {
+		k, _ = it.Next()
+	}
+	return k
+}
+
+func (i *spillingRangeDelIter) SeekGE(key []byte) (*InternalKey, []byte) {
+	return i.op(spillAfterLast, func(it internalIterator) (*InternalKey, []byte) {
+		return it.SeekGE(key)
+	})
+}
+
+func (i *spillingRangeDelIter) SeekPrefixGE(prefix, key []byte) (*InternalKey, []byte) {
+	return i.op(spillAfterLast, func(it internalIterator) (*InternalKey, []byte) {
+		return it.SeekPrefixGE(prefix, key)
+	})
+}
+
+func (i *spillingRangeDelIter) SeekLT(key []byte) (*InternalKey, []byte) {
+	return i.op(spillBeforeFirst, func(it internalIterator) (*InternalKey, []byte) {
+		return it.SeekLT(key)
+	})
+}
+
+func (i *spillingRangeDelIter) First() (*InternalKey, []byte) {
+	return i.op(spillAfterLast, func(it internalIterator) (*InternalKey, []byte) {
+		return it.First()
+	})
+}
+
+func (i *spillingRangeDelIter) Last() (*InternalKey, []byte) {
+	return i.op(spillBeforeFirst, func(it internalIterator) (*InternalKey, []byte) {
+		return it.Last()
+	})
+}
+
+func (i *spillingRangeDelIter) Next() (*InternalKey, []byte) {
+	switch i.pos {
+	case spillBeforeFirst:
+		return i.First()
+	case spillValid:
+	default:
+		return nil, nil
+	}
+	return i.op(spillAfterLast, func(it internalIterator) (*InternalKey, []byte) {
+		if i.reposition(it) == nil {
+			return nil, nil
+		}
+		return it.Next()
+	})
+}
+
+func (i *spillingRangeDelIter) Prev() (*InternalKey, []byte) {
+	switch i.pos {
+	case spillAfterLast:
+		return i.Last()
+	case spillValid:
+	default:
+		return nil, nil
+	}
+	return i.op(spillBeforeFirst, func(it internalIterator) (*InternalKey, []byte) {
+		if i.reposition(it) == nil {
+			return nil, nil
+		}
+		return it.Prev()
+	})
+}
+
+func (i *spillingRangeDelIter) Error() error {
+	return i.err
+}
+
+func (i *spillingRangeDelIter) Close() error {
+	if i.closeFn != nil {
+		i.closeFn()
+		i.closeFn = nil
+	}
+	return i.err
+}
+
+func (i *spillingRangeDelIter) SetBounds(lower, upper []byte) {
+	// This should never be called as range deletion iterators are unbounded.
+	panic("pebble: SetBounds unimplemented")
+}
+
+func (i *spillingRangeDelIter) String() string {
+	return "spilling-range-del"
+}
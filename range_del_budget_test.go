@@ -0,0 +1,151 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestSpillingRangeDelIter(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	for i := 0; i < 50; i++ {
+		start := []byte(fmt.Sprintf("%03d", i))
+		end := []byte(fmt.Sprintf("%03d", i+1))
+		// Stack several tombstones with the same bounds in order to exercise
+		// repositioning amongst tombstones with the same start key.
+		for seqNum := uint64(3); seqNum > 0; seqNum-- {
+			key := base.MakeInternalKey(start, seqNum, InternalKeyKindRangeDelete)
+			require.NoError(t, w.Add(key, end))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	r, err := sstable.NewReader(f, sstable.ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	ref, err := r.NewRangeDelIter()
+	require.NoError(t, err)
+	defer ref.Close()
+	var closed bool
+	spill := &spillingRangeDelIter{
+		cmp:     base.DefaultComparer.Compare,
+		newIter: r.NewRangeDelIter,
+		closeFn: func() { closed = true },
+	}
+
+	format := func(k *InternalKey, v []byte) string {
+		if k == nil {
+			return "."
+		}
+		return fmt.Sprintf("%s-%s", k, v)
+	}
+
+	seed := uint64(time.Now().UnixNano())
+	rng := rand.New(rand.NewSource(seed))
+	valid := false
+	for i := 0; i < 2000; i++ {
+		var op string
+		var k1, k2 *InternalKey
+		var v1, v2 []byte
+		key := []byte(fmt.Sprintf("%03d", rng.Intn(52)))
+		switch n := rng.Intn(6); {
+		case n == 0 || (!valid && n >= 4):
+			op = fmt.Sprintf("seek-ge(%s)", key)
+			k1, v1 = ref.SeekGE(key)
+			k2, v2 = spill.SeekGE(key)
+		case n == 1:
+			op = fmt.Sprintf("seek-lt(%s)", key)
+			k1, v1 = ref.SeekLT(key)
+			k2, v2 = spill.SeekLT(key)
+		case n == 2:
+			op = "first"
+			k1, v1 = ref.First()
+			k2, v2 = spill.First()
+		case n == 3:
+			op = "last"
+			k1, v1 = ref.Last()
+			k2, v2 = spill.Last()
+		case n == 4:
+			op = "next"
+			k1, v1 = ref.Next()
+			k2, v2 = spill.Next()
+		default:
+			op = "prev"
+			k1, v1 = ref.Prev()
+			k2, v2 = spill.Prev()
+		}
+		require.Equal(t, format(k1, v1), format(k2, v2), "seed=%d op=%d %s", seed, i, op)
+		valid = k1 != nil
+	}
+	require.NoError(t, spill.Close())
+	require.True(t, closed)
+}
+
+func TestIterRangeDelMemoryLimit(t *testing.T) {
+	mem := vfs.NewMem()
+	scan := func(limit int64) string {
+		opts := &Options{FS: mem}
+		opts.Experimental.IterRangeDelMemoryLimit = limit
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		var buf strings.Builder
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		fmt.Fprintf(&buf, "| ")
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		if limit > 0 {
+			require.True(t, iter.alloc.rangeDelBudget.used <= limit)
+		}
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+
+	// Populate the DB with keys and range tombstones spread across several
+	// sstables in multiple levels.
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 26; j++ {
+			key := []byte(fmt.Sprintf("%c%d", 'a'+j, i))
+			require.NoError(t, d.Set(key, nil, nil))
+		}
+		for j := i; j < 26; j += 4 {
+			start := []byte(fmt.Sprintf("%c", 'a'+j))
+			end := []byte(fmt.Sprintf("%c%d", 'a'+j, i))
+			require.NoError(t, d.DeleteRange(start, end, nil))
+		}
+		require.NoError(t, d.Flush())
+		if i == 1 {
+			require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+		}
+	}
+	require.NoError(t, d.Close())
+
+	expected := scan(0)
+	require.NotEqual(t, "| ", expected)
+	require.Equal(t, expected, scan(1))
+	require.Equal(t, expected, scan(64))
+}
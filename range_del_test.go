@@ -27,7 +27,7 @@ func TestRangeDel(t *testing.T) {
 		}
 	}()
 	opts := &Options{}
-	opts.private.disableAutomaticCompactions = true
+	opts.DisableAutomaticCompactions = true
 
 	datadriven.RunTest(t, "testdata/range_del", func(td *datadriven.TestData) string {
 		switch td.Cmd {
@@ -203,7 +203,7 @@ func TestRangeDelCompactionTruncation(t *testing.T) {
 	require.NoError(t, d.DeleteRange([]byte("a"), []byte("d"), nil))
 
 	// Compact to produce the L1 tables.
-	require.NoError(t, d.Compact([]byte("c"), []byte("c")))
+	require.NoError(t, d.Compact([]byte("c"), []byte("c"), false))
 	expectLSM(`
 1:
   000008:[a#3,RANGEDEL-b#72057594037927935,RANGEDEL]
@@ -211,7 +211,7 @@ func TestRangeDelCompactionTruncation(t *testing.T) {
 `)
 
 	// Compact again to move one of the tables to L2.
-	require.NoError(t, d.Compact([]byte("c"), []byte("c")))
+	require.NoError(t, d.Compact([]byte("c"), []byte("c"), false))
 	expectLSM(`
 1:
   000008:[a#3,RANGEDEL-b#72057594037927935,RANGEDEL]
@@ -260,7 +260,7 @@ func TestRangeDelCompactionTruncation(t *testing.T) {
 	// containing "c" will be compacted again with the L2 table creating two
 	// tables in L2. Lastly, the L2 table containing "c" will be compacted
 	// creating the L3 table.
-	require.NoError(t, d.Compact([]byte("c"), []byte("c")))
+	require.NoError(t, d.Compact([]byte("c"), []byte("c"), false))
 	expectLSM(`
 1:
   000012:[a#3,RANGEDEL-b#72057594037927935,RANGEDEL]
@@ -329,7 +329,7 @@ func TestRangeDelCompactionTruncation2(t *testing.T) {
 	require.NoError(t, d.DeleteRange([]byte("a"), []byte("d"), nil))
 
 	// Compact to produce the L1 tables.
-	require.NoError(t, d.Compact([]byte("b"), []byte("b")))
+	require.NoError(t, d.Compact([]byte("b"), []byte("b"), false))
 	expectLSM(`
 6:
   000008:[a#3,RANGEDEL-b#2,SET]
@@ -337,7 +337,7 @@ func TestRangeDelCompactionTruncation2(t *testing.T) {
 `)
 
 	require.NoError(t, d.Set([]byte("c"), bytes.Repeat([]byte("d"), 100), nil))
-	require.NoError(t, d.Compact([]byte("c"), []byte("c")))
+	require.NoError(t, d.Compact([]byte("c"), []byte("c"), false))
 	expectLSM(`
 6:
   000012:[a#3,RANGEDEL-b#2,SET]
@@ -404,7 +404,7 @@ func TestRangeDelCompactionTruncation3(t *testing.T) {
 
 	// Compact a few times to move the tables down to L3.
 	for i := 0; i < 3; i++ {
-		require.NoError(t, d.Compact([]byte("b"), []byte("b")))
+		require.NoError(t, d.Compact([]byte("b"), []byte("b"), false))
 	}
 	expectLSM(`
 3:
@@ -414,7 +414,7 @@ func TestRangeDelCompactionTruncation3(t *testing.T) {
 
 	require.NoError(t, d.Set([]byte("c"), bytes.Repeat([]byte("d"), 100), nil))
 
-	require.NoError(t, d.Compact([]byte("c"), []byte("c")))
+	require.NoError(t, d.Compact([]byte("c"), []byte("c"), false))
 	expectLSM(`
 4:
   000020:[a#3,RANGEDEL-b#2,SET]
@@ -422,7 +422,7 @@ func TestRangeDelCompactionTruncation3(t *testing.T) {
   000022:[c#4,SET-d#72057594037927935,RANGEDEL]
 `)
 
-	require.NoError(t, d.Compact([]byte("c"), []byte("c")))
+	require.NoError(t, d.Compact([]byte("c"), []byte("c"), false))
 	expectLSM(`
 5:
   000023:[a#3,RANGEDEL-b#2,SET]
@@ -434,7 +434,7 @@ func TestRangeDelCompactionTruncation3(t *testing.T) {
 		t.Fatalf("expected not found, but found %v", err)
 	}
 
-	require.NoError(t, d.Compact([]byte("a"), []byte("a")))
+	require.NoError(t, d.Compact([]byte("a"), []byte("a"), false))
 	expectLSM(`
 5:
   000025:[c#4,SET-d#72057594037927935,RANGEDEL]
@@ -0,0 +1,460 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+)
+
+// RangeKeyData describes a range key set over the span of user keys
+// surrounding an Iterator's current position: the suffix and value of the
+// range key. See Iterator.RangeKeys.
+type RangeKeyData = rangekey.SuffixValue
+
+// collectRangeKeys appends the range keys returned by iter which are visible
+// at the specified snapshot sequence number to keys, and closes iter. The
+// range keys are cloned as the memory backing iter may be released once it is
+// closed.
+func collectRangeKeys(
+	iter internalIterator, snapshot uint64, keys []rangekey.Key,
+) ([]rangekey.Key, error) {
+	if iter == nil {
+		return keys, nil
+	}
+	for key, val := iter.First(); key != nil; key, val = iter.Next() {
+		if !key.Visible(snapshot) {
+			continue
+		}
+		k, err := rangekey.Decode(*key, val)
+		if err != nil {
+			_ = iter.Close()
+			return nil, err
+		}
+		keys = append(keys, k.Clone())
+	}
+	return keys, iter.Close()
+}
+
+// collectTableRangeKeys appends the range keys in the specified files which
+// overlap [lower,upper) and are visible at the specified snapshot sequence
+// number to keys. A nil bound is unbounded.
+func (d *DB) collectTableRangeKeys(
+	files []*fileMetadata, lower, upper []byte, snapshot uint64, keys []rangekey.Key,
+) ([]rangekey.Key, error) {
+	for _, f := range files {
+		if !f.HasRangeKeys {
+			continue
+		}
+		if lower != nil && d.cmp(f.Largest.UserKey, lower) < 0 {
+			continue
+		}
+		if upper != nil && d.cmp(f.Smallest.UserKey, upper) >= 0 {
+			continue
+		}
+		iter, err := d.tableCache.newRangeKeyIter(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pebble: could not open table %s", errors.Safe(f.FileNum))
+		}
+		if keys, err = collectRangeKeys(iter, snapshot, keys); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// compactionRangeKeys returns the range keys to be written by the compaction,
+// fragmented and ordered as they must be added to the output tables. Range
+// keys which are shadowed within a snapshot stripe are dropped, as are
+// RANGEKEYUNSET and RANGEKEYDEL keys which no longer shadow anything beneath
+// the compaction. The range keys are truncated to the bounds of the
+// subcompaction, if any.
+func (d *DB) compactionRangeKeys(c *compaction, snapshots []uint64) ([]rangekey.Key, error) {
+	var keys []rangekey.Key
+	var err error
+	if len(c.flushing) != 0 {
+		for i := range c.flushing {
+			keys, err = collectRangeKeys(c.flushing[i].newRangeKeyIter(nil), InternalKeySeqNumMax, keys)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for i := range c.inputs {
+			keys, err = d.collectTableRangeKeys(c.inputs[i].files, c.lower, c.upper, InternalKeySeqNumMax, keys)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	keys = rangekey.Compact(c.cmp, keys, snapshots, c.elideRangeTombstone)
+	if c.lower != nil || c.upper != nil {
+		keys = rangekey.Truncate(c.cmp, keys, c.lower, c.upper)
+	}
+	return keys, nil
+}
+
+// iterRangeKeys holds the range key state of an Iterator configured to
+// surface range keys (see IterOptions.KeyTypes). The range keys of the batch
+// and memtables visible to the iterator are read when the iterator is
+// created, and those of the tables overlapping the bounds of the iterator
+// are read when the bounds are set. The range keys are resolved into the
+// spans over which they are set for the bounds of the iterator.
+//
+// Range keys are interleaved with point keys. During forward iteration, the
+// start key of a span is surfaced as a position of the iterator, unless the
+// iterator was positioned within the span by a seek in which case the seek
+// key is surfaced instead. During reverse iteration, the start key of a span
+// is surfaced after the point keys within the span. Every position of the
+// iterator within a span, whether at a point key or not, exposes the range
+// keys of the span.
+type iterRangeKeys struct {
+	d       *DB
+	version *version
+	seqNum  uint64
+	// memKeys holds the range keys of the batch and memtables visible to the
+	// iterator. keys holds memKeys followed by the range keys of the tables
+	// overlapping [tablesLower, tablesUpper), from which the spans are
+	// computed for the bounds [lower, upper) of the iterator. The range keys
+	// of the tables are read again only if the bounds of the iterator are
+	// changed to bounds which are not contained within those of the tables.
+	memKeys     []rangekey.Key
+	keys        []rangekey.Key
+	tablesRead  bool
+	tablesLower []byte
+	tablesUpper []byte
+	lower       []byte
+	upper       []byte
+	// stale is set when the bounds of the iterator have changed, until the
+	// spans are computed for them by the next positioning operation.
+	stale bool
+	spans []rangekey.Span
+	// dir is the direction of the last positioning operation: +1 for forward
+	// and -1 for reverse. Zero if the iterator is unpositioned.
+	dir int
+	// next is the index of the next span whose start has not yet been surfaced
+	// in the current direction, and nextKey is the key at which it is surfaced.
+	next    int
+	nextKey []byte
+	seekKey []byte
+	// The current position of the iterator.
+	valid    bool
+	key      []byte
+	hasPoint bool
+	// span is the index of the span containing the current position, or -1 if
+	// there is none.
+	span int
+}
+
+// newIterRangeKeys reads the range keys visible to an iterator at the
+// specified snapshot sequence number.
+func (d *DB) newIterRangeKeys(
+	o *IterOptions, batchRangeKeyIter internalIterator, readState *readState, seqNum uint64,
+) (*iterRangeKeys, error) {
+	var keys []rangekey.Key
+	var err error
+	if keys, err = collectRangeKeys(batchRangeKeyIter, seqNum, keys); err != nil {
+		return nil, err
+	}
+	memtables := readState.memtables
+	for i := len(memtables) - 1; i >= 0; i-- {
+		mem := memtables[i]
+		if mem.logSeqNum >= seqNum {
+			continue
+		}
+		if keys, err = collectRangeKeys(mem.newRangeKeyIter(o), seqNum, keys); err != nil {
+			return nil, err
+		}
+	}
+	r := &iterRangeKeys{
+		d:       d,
+		version: readState.current,
+		seqNum:  seqNum,
+		memKeys: keys,
+	}
+	r.setBounds(o.GetLowerBound(), o.GetUpperBound())
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// setBounds sets the bounds for which the spans are computed by the next
+// positioning operation, and invalidates the current position.
+func (r *iterRangeKeys) setBounds(lower, upper []byte) {
+	r.lower, r.upper = lower, upper
+	r.stale = true
+	r.dir = 0
+	r.valid = false
+	r.span = -1
+}
+
+// load computes the spans for the bounds of the iterator if they have
+// changed, first reading the range keys of the tables overlapping the bounds
+// unless they were already read for bounds which contain them.
+func (r *iterRangeKeys) load() error {
+	if !r.stale {
+		return nil
+	}
+	cmp := r.d.cmp
+	contained := r.tablesRead &&
+		(r.tablesLower == nil || (r.lower != nil && cmp(r.lower, r.tablesLower) >= 0)) &&
+		(r.tablesUpper == nil || (r.upper != nil && cmp(r.upper, r.tablesUpper) <= 0))
+	if !contained {
+		keys := append(r.keys[:0], r.memKeys...)
+		var err error
+		for level := range r.version.Levels {
+			keys, err = r.d.collectTableRangeKeys(r.version.Levels[level], r.lower, r.upper, r.seqNum, keys)
+			if err != nil {
+				r.keys = keys[:0]
+				r.tablesRead = false
+				return err
+			}
+		}
+		r.keys = keys
+		r.tablesRead = true
+		// The bounds are copied as the caller may reuse the memory backing
+		// them once the bounds of the iterator are changed again.
+		r.tablesLower = cloneBound(r.tablesLower[:0], r.lower)
+		r.tablesUpper = cloneBound(r.tablesUpper[:0], r.upper)
+	}
+	r.spans = rangekey.Resolve(cmp, r.keys, r.lower, r.upper)
+	r.stale = false
+	return nil
+}
+
+// cloneBound copies the bound b into buf, preserving a nil bound, which is
+// unbounded.
+func cloneBound(buf, b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(buf, b...)
+}
+
+// loadRangeKeys computes the spans of the range keys for the bounds of the
+// iterator if they have changed. An error reading the range keys is recorded
+// as the error of the iterator, which is left unpositioned.
+func (i *Iterator) loadRangeKeys() bool {
+	if err := i.rangeKeys.load(); err != nil {
+		i.err = err
+		i.rangeKeys.setBounds(i.rangeKeys.lower, i.rangeKeys.upper)
+		return false
+	}
+	return true
+}
+
+// seekGERangeKeys positions the range keys for forward iteration from key,
+// and interleaves them with the point key at which the iterator is
+// positioned.
+func (i *Iterator) seekGERangeKeys(key []byte) bool {
+	if !i.loadRangeKeys() {
+		return false
+	}
+	r := i.rangeKeys
+	r.next = sort.Search(len(r.spans), func(j int) bool {
+		return i.cmp(r.spans[j].End, key) > 0
+	})
+	if r.next < len(r.spans) {
+		r.nextKey = r.spans[r.next].Start
+		if key != nil && i.cmp(r.nextKey, key) < 0 {
+			r.seekKey = append(r.seekKey[:0], key...)
+			r.nextKey = r.seekKey
+		}
+	}
+	return i.interleaveForward()
+}
+
+// seekLTRangeKeys positions the range keys for reverse iteration from key,
+// and interleaves them with the point key at which the iterator is
+// positioned. A nil key positions the range keys after the last span.
+func (i *Iterator) seekLTRangeKeys(key []byte) bool {
+	if !i.loadRangeKeys() {
+		return false
+	}
+	r := i.rangeKeys
+	r.next = len(r.spans) - 1
+	if key != nil {
+		r.next = sort.Search(len(r.spans), func(j int) bool {
+			return i.cmp(r.spans[j].Start, key) >= 0
+		}) - 1
+	}
+	if r.next >= 0 {
+		r.nextKey = r.spans[r.next].Start
+	}
+	return i.interleaveReverse()
+}
+
+// interleaveForward positions the iterator at the smaller of the current
+// point key and the next span start.
+func (i *Iterator) interleaveForward() bool {
+	r := i.rangeKeys
+	r.dir = +1
+	rangeOK := r.next < len(r.spans)
+	if rangeOK && i.prefix != nil {
+		// Only the spans starting within the prefix are surfaced in prefix
+		// iteration mode.
+		if n := i.split(r.nextKey); !bytes.Equal(i.prefix, r.nextKey[:n]) {
+			rangeOK = false
+		}
+	}
+	switch {
+	case i.valid && (!rangeOK || i.cmp(i.key, r.nextKey) <= 0):
+		if rangeOK && i.cmp(i.key, r.nextKey) == 0 {
+			r.advanceForward()
+		}
+		r.key, r.hasPoint = i.key, true
+	case rangeOK:
+		r.key, r.hasPoint = r.nextKey, false
+		r.advanceForward()
+	default:
+		r.valid, r.span = false, -1
+		return false
+	}
+	r.valid = true
+	r.span = r.spanAt(i.cmp, r.key)
+	return true
+}
+
+// interleaveReverse positions the iterator at the larger of the current
+// point key and the next span start.
+func (i *Iterator) interleaveReverse() bool {
+	r := i.rangeKeys
+	r.dir = -1
+	rangeOK := r.next >= 0
+	switch {
+	case i.valid && (!rangeOK || i.cmp(i.key, r.nextKey) >= 0):
+		if rangeOK && i.cmp(i.key, r.nextKey) == 0 {
+			r.advanceReverse()
+		}
+		r.key, r.hasPoint = i.key, true
+	case rangeOK:
+		r.key, r.hasPoint = r.nextKey, false
+		r.advanceReverse()
+	default:
+		r.valid, r.span = false, -1
+		return false
+	}
+	r.valid = true
+	r.span = r.spanAt(i.cmp, r.key)
+	return true
+}
+
+func (r *iterRangeKeys) advanceForward() {
+	r.next++
+	if r.next < len(r.spans) {
+		r.nextKey = r.spans[r.next].Start
+	}
+}
+
+func (r *iterRangeKeys) advanceReverse() {
+	r.next--
+	if r.next >= 0 {
+		r.nextKey = r.spans[r.next].Start
+	}
+}
+
+// spanAt returns the index of the span containing key, or -1 if there is
+// none.
+func (r *iterRangeKeys) spanAt(cmp Compare, key []byte) int {
+	j := sort.Search(len(r.spans), func(j int) bool {
+		return cmp(r.spans[j].End, key) > 0
+	})
+	if j < len(r.spans) && cmp(r.spans[j].Start, key) <= 0 {
+		return j
+	}
+	return -1
+}
+
+// nextWithRangeKeys implements Iterator.Next for an iterator surfacing range
+// keys.
+func (i *Iterator) nextWithRangeKeys() bool {
+	r := i.rangeKeys
+	switch {
+	case r.dir > 0 && !r.valid:
+		return false
+	case r.dir > 0:
+		if r.hasPoint {
+			i.nextPoint()
+		}
+		return i.interleaveForward()
+	case r.dir < 0 && !r.valid:
+		return i.First()
+	case r.dir < 0:
+		// Switching directions. Reposition at the current key, which is
+		// surfaced again by a forward seek, and step past it.
+		key := append([]byte(nil), r.key...)
+		if !i.SeekGE(key) || i.cmp(r.key, key) != 0 {
+			return r.valid
+		}
+		return i.nextWithRangeKeys()
+	default:
+		return false
+	}
+}
+
+// prevWithRangeKeys implements Iterator.Prev for an iterator surfacing range
+// keys.
+func (i *Iterator) prevWithRangeKeys() bool {
+	r := i.rangeKeys
+	switch {
+	case r.dir < 0 && !r.valid:
+		return false
+	case r.dir < 0:
+		if r.hasPoint {
+			i.prevPoint()
+		}
+		return i.interleaveReverse()
+	case r.dir > 0 && !r.valid:
+		return i.Last()
+	case r.dir > 0:
+		// Switching directions. The positions before the current key are
+		// surfaced by a reverse seek.
+		return i.SeekLT(append([]byte(nil), r.key...))
+	default:
+		return false
+	}
+}
+
+// HasPointAndRange indicates whether the iterator is positioned at a point
+// key and whether it is positioned within a span over which range keys are
+// set. If the iterator is not configured to surface range keys (see
+// IterOptions.KeyTypes), hasRange is always false.
+func (i *Iterator) HasPointAndRange() (hasPoint bool, hasRange bool) {
+	if i.rangeKeys == nil {
+		return i.valid, false
+	}
+	r := i.rangeKeys
+	return r.valid && r.hasPoint, r.valid && r.span >= 0
+}
+
+// RangeBounds returns the start (inclusive) and end (exclusive) bounds of the
+// span of user keys containing the current position over which the range keys
+// returned by RangeKeys are set. Returns nil bounds if the current position
+// is not within a span. The caller should not modify the contents of the
+// returned slices.
+func (i *Iterator) RangeBounds() (start, end []byte) {
+	if r := i.rangeKeys; r != nil && r.valid && r.span >= 0 {
+		return r.spans[r.span].Start, r.spans[r.span].End
+	}
+	return nil, nil
+}
+
+// RangeKeys returns the range keys set over the span containing the current
+// position, ordered by suffix. Returns nil if the current position is not
+// within a span. The caller should not modify the returned slice or its
+// contents.
+func (i *Iterator) RangeKeys() []RangeKeyData {
+	if r := i.rangeKeys; r != nil && r.valid && r.span >= 0 {
+		return r.spans[r.span].Keys
+	}
+	return nil
+}
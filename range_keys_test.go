@@ -0,0 +1,318 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// runRangeKeyBatchCmd applies the operations in td.Input to b. Each line is
+// one of:
+//
+//	set <key> <value>
+//	del <key>
+//	range-key-set <start> <end> <suffix> <value>
+//	range-key-unset <start> <end> <suffix>
+//	range-key-del <start> <end>
+func runRangeKeyBatchCmd(td *datadriven.TestData, b *Batch) error {
+	for _, line := range strings.Split(td.Input, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		var err error
+		switch {
+		case parts[0] == "set" && len(parts) == 3:
+			err = b.Set([]byte(parts[1]), []byte(parts[2]), nil)
+		case parts[0] == "del" && len(parts) == 2:
+			err = b.Delete([]byte(parts[1]), nil)
+		case parts[0] == "range-key-set" && len(parts) == 5:
+			err = b.RangeKeySet([]byte(parts[1]), []byte(parts[2]), []byte(parts[3]), []byte(parts[4]), nil)
+		case parts[0] == "range-key-unset" && len(parts) == 4:
+			err = b.RangeKeyUnset([]byte(parts[1]), []byte(parts[2]), []byte(parts[3]), nil)
+		case parts[0] == "range-key-del" && len(parts) == 3:
+			err = b.RangeKeyDelete([]byte(parts[1]), []byte(parts[2]), nil)
+		default:
+			return errors.Errorf("malformed operation: %s", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRangeKeyIterCmd positions iter as directed by td.Input, and prints the
+// point key and range keys at each position.
+func runRangeKeyIterCmd(td *datadriven.TestData, iter *Iterator) string {
+	var b bytes.Buffer
+	for _, line := range strings.Split(td.Input, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		var valid bool
+		switch {
+		case parts[0] == "first":
+			valid = iter.First()
+		case parts[0] == "last":
+			valid = iter.Last()
+		case parts[0] == "next":
+			valid = iter.Next()
+		case parts[0] == "prev":
+			valid = iter.Prev()
+		case parts[0] == "seek-ge" && len(parts) == 2:
+			valid = iter.SeekGE([]byte(parts[1]))
+		case parts[0] == "seek-lt" && len(parts) == 2:
+			valid = iter.SeekLT([]byte(parts[1]))
+		case parts[0] == "seek-prefix-ge" && len(parts) == 2:
+			valid = iter.SeekPrefixGE([]byte(parts[1]))
+		case parts[0] == "set-bounds" && len(parts) == 3:
+			iter.SetBounds([]byte(parts[1]), []byte(parts[2]))
+			b.WriteString(".\n")
+			continue
+		default:
+			return fmt.Sprintf("unknown op: %s", line)
+		}
+		if !valid {
+			if err := iter.Error(); err != nil {
+				fmt.Fprintf(&b, "err=%v\n", err)
+			} else {
+				b.WriteString(".\n")
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "%s:", iter.Key())
+		hasPoint, hasRange := iter.HasPointAndRange()
+		if hasPoint {
+			fmt.Fprintf(&b, " %s", iter.Value())
+		}
+		if hasRange {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&b, " [%s-%s)", start, end)
+			for _, k := range iter.RangeKeys() {
+				fmt.Fprintf(&b, " %s=%s", k.Suffix, k.Value)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestRangeKeys(t *testing.T) {
+	mem := vfs.NewMem()
+	comparer := *DefaultComparer
+	comparer.Split = func(a []byte) int { return len(a) }
+	opts := &Options{FS: mem, Comparer: &comparer, FormatMajorVersion: FormatNewest}
+	opts.DisableAutomaticCompactions = true
+
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	datadriven.RunTest(t, "testdata/range_keys", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "reset":
+			if err := d.Close(); err != nil {
+				return err.Error()
+			}
+			mem = vfs.NewMem()
+			opts.FS = mem
+			if d, err = Open("", opts); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "batch":
+			b := d.NewBatch()
+			if err := runRangeKeyBatchCmd(td, b); err != nil {
+				return err.Error()
+			}
+			if err := b.Commit(nil); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "ingest":
+			f, err := mem.Create("ext")
+			if err != nil {
+				return err.Error()
+			}
+			w := sstable.NewWriter(f, sstable.WriterOptions{})
+			for _, line := range strings.Split(td.Input, "\n") {
+				parts := strings.Fields(line)
+				var err error
+				switch {
+				case len(parts) == 0:
+					continue
+				case parts[0] == "set" && len(parts) == 3:
+					err = w.Set([]byte(parts[1]), []byte(parts[2]))
+				case parts[0] == "range-key-set" && len(parts) == 5:
+					err = w.RangeKeySet([]byte(parts[1]), []byte(parts[2]), []byte(parts[3]), []byte(parts[4]))
+				default:
+					err = errors.Errorf("malformed operation: %s", line)
+				}
+				if err != nil {
+					return err.Error()
+				}
+			}
+			if err := w.Close(); err != nil {
+				return err.Error()
+			}
+			if err := d.Ingest([]string{"ext"}); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "flush":
+			if err := d.Flush(); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "compact":
+			if err := d.Compact([]byte("a"), []byte("z"), false); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "reopen":
+			if err := d.Close(); err != nil {
+				return err.Error()
+			}
+			if d, err = Open("", opts); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "lsm":
+			d.mu.Lock()
+			s := d.mu.versions.currentVersion().String()
+			d.mu.Unlock()
+			return s
+
+		case "iter":
+			iterOpts := &IterOptions{KeyTypes: IterKeyTypePointsAndRanges}
+			for _, arg := range td.CmdArgs {
+				switch arg.Key {
+				case "ranges-only":
+					iterOpts.KeyTypes = IterKeyTypeRangesOnly
+				case "lower":
+					iterOpts.LowerBound = []byte(arg.Vals[0])
+				case "upper":
+					iterOpts.UpperBound = []byte(arg.Vals[0])
+				default:
+					return fmt.Sprintf("%s: unknown arg: %s", td.Cmd, arg.Key)
+				}
+			}
+			iter := d.NewIter(iterOpts)
+			defer iter.Close()
+			return runRangeKeyIterCmd(td, iter)
+
+		default:
+			return fmt.Sprintf("unknown command: %s", td.Cmd)
+		}
+	})
+}
+
+func TestRangeKeysBatchIter(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.RangeKeySet([]byte("a"), []byte("e"), []byte("@1"), []byte("foo"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("y"), nil))
+	b := d.NewIndexedBatch()
+	require.NoError(t, b.RangeKeySet([]byte("c"), []byte("g"), []byte("@2"), []byte("bar"), nil))
+	require.NoError(t, b.Set([]byte("d"), []byte("x"), nil))
+
+	scan := func() string {
+		iter := b.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+		var buf bytes.Buffer
+		for valid := iter.First(); valid; valid = iter.Next() {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&buf, "%s [%s-%s)", iter.Key(), start, end)
+			for _, k := range iter.RangeKeys() {
+				fmt.Fprintf(&buf, " %s=%s", k.Suffix, k.Value)
+			}
+			buf.WriteString("\n")
+		}
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+
+	require.Equal(t, `a [a-c) @1=foo
+b [a-c) @1=foo
+c [c-e) @1=foo @2=bar
+d [c-e) @1=foo @2=bar
+e [e-g) @2=bar
+`, scan())
+
+	// Range deletions and range keys added to the batch after it was first
+	// read are reflected by subsequent iterators.
+	require.NoError(t, b.DeleteRange([]byte("a"), []byte("c"), nil))
+	require.NoError(t, b.RangeKeyUnset([]byte("d"), []byte("f"), []byte("@2"), nil))
+	require.Equal(t, `a [a-c) @1=foo
+c [c-d) @1=foo @2=bar
+d [d-e) @1=foo
+f [f-g) @2=bar
+`, scan())
+	require.NoError(t, b.Close())
+}
+
+func TestRangeKeysIterBounds(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	for _, k := range []string{"a", "m", "x"} {
+		b := d.NewBatch()
+		require.NoError(t, b.RangeKeySet([]byte(k), []byte(k+"z"), []byte("@1"), []byte(k), nil))
+		require.NoError(t, b.Commit(nil))
+		require.NoError(t, d.Flush())
+	}
+	starts := func(iter *Iterator) string {
+		var parts []string
+		for _, k := range iter.rangeKeys.keys {
+			parts = append(parts, string(k.Start.UserKey))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	// Only the range keys of the tables overlapping the bounds are read.
+	iter := d.NewIter(&IterOptions{
+		KeyTypes:   IterKeyTypePointsAndRanges,
+		LowerBound: []byte("b"),
+		UpperBound: []byte("n"),
+	})
+	require.Equal(t, "m", starts(iter))
+	require.True(t, iter.First())
+	require.Equal(t, "m", string(iter.Key()))
+
+	// Bounds contained within those for which the range keys were read do not
+	// read them again, while others do.
+	iter.SetBounds([]byte("c"), []byte("mm"))
+	require.True(t, iter.First())
+	require.Equal(t, "m", starts(iter))
+	iter.SetBounds([]byte("a"), []byte("y"))
+	require.True(t, iter.Last())
+	require.Equal(t, "x", string(iter.Key()))
+	require.Equal(t, "a m x", starts(iter))
+	require.NoError(t, iter.Close())
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// rateLimiterChunkSize is the maximum number of bytes granted to a write at a
+// time. Writes larger than this are rate limited in chunks, which bounds the
+// time a flush waits behind compactions which have already been granted
+// bandwidth.
+const rateLimiterChunkSize = 256 << 10 // 256 KB
+
+// RateLimiter is a token bucket which limits the combined rate at which
+// flushes and compactions write sstables. A RateLimiter may be shared by
+// several DBs (see Options.RateLimiter), such as DBs which share a disk, in
+// which case the limit applies to their combined writes.
+//
+// Flushes take priority over compactions: while a flush is waiting for
+// bandwidth, compactions are not granted any, so that a backlog of
+// compactions does not delay flushes and thereby stall writes.
+//
+// The rate may be changed at any time with SetBytesPerSecond.
+type RateLimiter struct {
+	limiter *rate.Limiter
+
+	mu struct {
+		sync.Mutex
+		// Condition variable signalled when no flushes are waiting.
+		cond sync.Cond
+		// The number of flushes waiting for bandwidth.
+		flushesWaiting int
+	}
+}
+
+// NewRateLimiter returns a RateLimiter which allows bytesPerSec bytes to be
+// written per second. A value of 0 or less places no limit on the rate.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	l := &RateLimiter{
+		limiter: rate.NewLimiter(rateLimiterLimit(bytesPerSec), rateLimiterChunkSize),
+	}
+	l.mu.cond.L = &l.mu.Mutex
+	return l
+}
+
+func rateLimiterLimit(bytesPerSec int64) rate.Limit {
+	if bytesPerSec <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bytesPerSec)
+}
+
+// SetBytesPerSecond changes the rate, in bytes per second, at which writes
+// are allowed. A value of 0 or less removes the limit.
+func (l *RateLimiter) SetBytesPerSecond(bytesPerSec int64) {
+	l.limiter.SetLimit(rateLimiterLimit(bytesPerSec))
+}
+
+// BytesPerSecond returns the rate, in bytes per second, at which writes are
+// allowed, or 0 if the rate is not limited.
+func (l *RateLimiter) BytesPerSecond() int64 {
+	r := l.limiter.Limit()
+	if r == rate.Inf {
+		return 0
+	}
+	return int64(r)
+}
+
+// wait blocks until n bytes may be written, and returns the duration for
+// which the caller was blocked. Compactions (flush == false) are blocked
+// while any flush is waiting.
+func (l *RateLimiter) wait(n int, flush bool) time.Duration {
+	if l.limiter.Limit() == rate.Inf {
+		return 0
+	}
+
+	start := time.Now()
+	blocked := false
+	if flush {
+		l.mu.Lock()
+		l.mu.flushesWaiting++
+		l.mu.Unlock()
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > rateLimiterChunkSize {
+			chunk = rateLimiterChunkSize
+		}
+		if !flush {
+			l.mu.Lock()
+			for l.mu.flushesWaiting > 0 {
+				blocked = true
+				l.mu.cond.Wait()
+			}
+			l.mu.Unlock()
+		}
+		if d := l.limiter.DelayN(time.Now(), chunk); d > 0 {
+			blocked = true
+			time.Sleep(d)
+		}
+		n -= chunk
+	}
+	if flush {
+		l.mu.Lock()
+		l.mu.flushesWaiting--
+		if l.mu.flushesWaiting == 0 {
+			l.mu.cond.Broadcast()
+		}
+		l.mu.Unlock()
+	}
+	if !blocked {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// rateLimitedFile wraps a File, limiting the rate at which it is written
+// using a RateLimiter.
+type rateLimitedFile struct {
+	vfs.File
+	limiter *RateLimiter
+	flush   bool
+	// stats, if non-nil, records the time spent blocked by the limiter.
+	stats *pacingStats
+}
+
+func newRateLimitedFile(
+	file vfs.File, limiter *RateLimiter, flush bool, stats *pacingStats,
+) vfs.File {
+	return &rateLimitedFile{
+		File:    file,
+		limiter: limiter,
+		flush:   flush,
+		stats:   stats,
+	}
+}
+
+// Write implements the io.Writer interface.
+func (f *rateLimitedFile) Write(p []byte) (int, error) {
+	if d := f.limiter.wait(len(p), f.flush); d > 0 && f.stats != nil {
+		atomic.AddInt64(&f.stats.delay, int64(d))
+	}
+	return f.File.Write(p)
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(0)
+	require.Equal(t, int64(0), l.BytesPerSecond())
+	require.Equal(t, time.Duration(0), l.wait(1<<30, false /* flush */))
+
+	l.SetBytesPerSecond(1 << 30)
+	require.Equal(t, int64(1<<30), l.BytesPerSecond())
+
+	// A compaction is blocked while a flush is waiting.
+	l.mu.Lock()
+	l.mu.flushesWaiting++
+	l.mu.Unlock()
+	done := make(chan time.Duration)
+	go func() {
+		done <- l.wait(1, false /* flush */)
+	}()
+	select {
+	case <-done:
+		t.Fatal("compaction was not blocked by a waiting flush")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// A flush is not blocked by other flushes.
+	require.Equal(t, time.Duration(0), l.wait(1, true /* flush */))
+
+	l.mu.Lock()
+	l.mu.flushesWaiting--
+	l.mu.cond.Broadcast()
+	l.mu.Unlock()
+	require.True(t, <-done > 0)
+
+	l.SetBytesPerSecond(0)
+	require.Equal(t, int64(0), l.BytesPerSecond())
+}
+
+func TestRateLimiterFlushAndCompaction(t *testing.T) {
+	l := NewRateLimiter(1 << 30)
+	opts := &Options{
+		FS:          vfs.NewMem(),
+		RateLimiter: l,
+	}
+	// Several DBs may share a limiter.
+	var dbs []*DB
+	for i := 0; i < 2; i++ {
+		d, err := Open(fmt.Sprint(i), opts)
+		require.NoError(t, err)
+		dbs = append(dbs, d)
+	}
+	for _, d := range dbs {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprint(i)), make([]byte, 1<<10), nil))
+			require.NoError(t, d.Flush())
+		}
+		require.NoError(t, d.Compact([]byte("0"), []byte("9"), false))
+	}
+
+	// The rate may be changed while the DBs are open.
+	l.SetBytesPerSecond(0)
+	for _, d := range dbs {
+		require.NoError(t, d.Set([]byte("a"), nil, nil))
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Close())
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// Replicator ships the files of a DB, the primary, to a replica directory,
+// which may reside on another filesystem. See DB.NewReplicator.
+type Replicator struct {
+	d       *DB
+	fs      vfs.FS
+	dirname string
+
+	// mu serializes replication rounds, and Close.
+	mu     sync.Mutex
+	closed bool
+	// optionsFileNum is the file number of the OPTIONS file in the replica.
+	optionsFileNum FileNum
+	// tables are the sstables present in the replica.
+	tables map[FileNum]struct{}
+	// logs are the WALs present in the replica.
+	logs map[FileNum]*replicatedFile
+	// manifest is the MANIFEST named by the replica's CURRENT file.
+	manifest *replicatedFile
+	// obsolete are the paths of the files of the replica which are no longer
+	// referenced, but have not been removed yet.
+	obsolete []string
+}
+
+// replicatedFile is a file of the replica to which the tail of the
+// corresponding file of the primary is appended.
+type replicatedFile struct {
+	fileNum FileNum
+	// f is the open replica file. Nil once the file is complete.
+	f vfs.File
+	// size is the number of bytes shipped to the replica file.
+	size int64
+	// complete is set once the file has been shipped in its entirety.
+	complete bool
+}
+
+// NewReplicator returns a Replicator which maintains a physical replica of the
+// DB in the directory dirname of fs, which must be empty or not exist. The
+// replica is brought up to date with the DB by Replicator.Replicate, which
+// must be called for the replica to be created.
+//
+// The replica is a warm standby of the DB. It may be opened with OpenFollower
+// while replication is ongoing, to serve reads, and catches up with the
+// replicated state through DB.CatchUp. In order to fail over to the replica,
+// replication is stopped, any follower of the replica is closed, and the
+// replica is opened as a DB with Open. The replica then holds the writes
+// which were shipped by the last call to Replicate. The WALs of the replica
+// reside in its directory regardless of Options.WALDir.
+//
+// Replication of a DB with keyspaces, or of a keyspace, is not supported.
+func (d *DB) NewReplicator(fs vfs.FS, dirname string) (*Replicator, error) {
+	if d.keyspace != nil || len(d.Keyspaces()) > 0 {
+		return nil, errors.New("pebble: replication of keyspaces is not supported")
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := fs.MkdirAll(dirname, 0755); err != nil {
+		return nil, err
+	}
+	ls, err := fs.List(dirname)
+	if err != nil {
+		return nil, err
+	}
+	if len(ls) > 0 {
+		return nil, errors.Errorf("pebble: replica directory %q is not empty", errors.Safe(dirname))
+	}
+	return &Replicator{
+		d:       d,
+		fs:      fs,
+		dirname: dirname,
+		tables:  make(map[FileNum]struct{}),
+		logs:    make(map[FileNum]*replicatedFile),
+	}, nil
+}
+
+// Replicate ships the changes to the files of the DB since the previous call
+// to Replicate to the replica: new sstables, the tail of the MANIFEST and the
+// tail of the WALs. Obsolete sstables and WALs are then removed from the
+// replica. The replica reflects a consistent state of the DB after each call,
+// which includes the writes committed before Replicate was called. In order to
+// ensure that those writes have reached the current WAL, Replicate commits an
+// empty synced LogData batch.
+//
+// Files are shipped in an order which keeps the replica consistent while it
+// is being updated: the sstables and WALs referenced by a MANIFEST are
+// shipped before the MANIFEST, and files are only removed once they are no
+// longer referenced by the shipped MANIFEST.
+func (r *Replicator) Replicate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	d := r.d
+	if atomic.LoadInt32(&d.closed) != 0 {
+		return ErrClosed
+	}
+
+	// Disable file deletions, and capture the state of the DB as in
+	// DB.Checkpoint. The log is captured while holding commitPipeline.mu so
+	// that the number and size of the current log are consistent.
+	d.commit.mu.Lock()
+	d.mu.Lock()
+	d.disableFileDeletions()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.enableFileDeletions()
+	}()
+	d.mu.versions.logLock()
+	memQueue := d.mu.mem.queue
+	current := d.mu.versions.currentVersion()
+	manifestFileNum := d.mu.versions.manifestFileNum
+	manifestSize := d.mu.versions.manifest.Size()
+	optionsFileNum := d.optionsFileNum
+	logNum := FileNum(atomic.LoadUint64(&d.mu.log.num))
+	logSize := int64(atomic.LoadUint64(&d.mu.log.size))
+	d.mu.versions.logUnlock()
+	d.mu.Unlock()
+	d.commit.mu.Unlock()
+
+	// The current log is written asynchronously, and its contents up to
+	// logSize may not have reached the file yet. A synced write flushes them.
+	// Logs which are no longer current were flushed when they were closed.
+	if !d.opts.DisableWAL {
+		if err := d.LogData(nil, Sync); err != nil {
+			return err
+		}
+	}
+
+	dir, err := r.fs.OpenDir(r.dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	fs := d.opts.FS
+	if optionsFileNum != r.optionsFileNum {
+		srcPath := base.MakeFilename(fs, d.dirname, fileTypeOptions, optionsFileNum)
+		destPath := base.MakeFilename(r.fs, r.dirname, fileTypeOptions, optionsFileNum)
+		if _, _, err := backupCopy(fs, srcPath, r.fs, destPath); err != nil {
+			return err
+		}
+		if r.optionsFileNum != 0 {
+			r.markObsolete(fileTypeOptions, r.optionsFileNum)
+		}
+		r.optionsFileNum = optionsFileNum
+	}
+
+	// Ship the new sstables. External sstables remain on SharedFS, which the
+	// replica must be opened with.
+	liveTables := make(map[FileNum]struct{})
+	for l := range current.Levels {
+		level := current.Levels[l]
+		for i := range level {
+			if level[i].External != "" {
+				continue
+			}
+			fileNum := level[i].PhysicalFileNum()
+			liveTables[fileNum] = struct{}{}
+			if _, ok := r.tables[fileNum]; ok {
+				continue
+			}
+			srcPath := base.MakeFilename(fs, d.dirname, fileTypeTable, fileNum)
+			destPath := base.MakeFilename(r.fs, r.dirname, fileTypeTable, fileNum)
+			if _, _, err := backupCopy(fs, srcPath, r.fs, destPath); err != nil {
+				return err
+			}
+			r.tables[fileNum] = struct{}{}
+		}
+	}
+
+	// Ship the tails of the unflushed logs. A log which is no longer current
+	// is shipped in its entirety. It may be a recycled log file, in which case
+	// its tail holds records of an earlier log, which are ignored when it is
+	// replayed.
+	liveLogs := make(map[FileNum]struct{})
+	for i := range memQueue {
+		fileNum := memQueue[i].logNum
+		if fileNum == 0 {
+			continue
+		}
+		if _, ok := liveLogs[fileNum]; ok {
+			continue
+		}
+		liveLogs[fileNum] = struct{}{}
+		rf := r.logs[fileNum]
+		if rf == nil {
+			rf = &replicatedFile{fileNum: fileNum}
+			r.logs[fileNum] = rf
+		}
+		if rf.complete {
+			continue
+		}
+		srcPath := base.MakeFilename(fs, d.walDirname, fileTypeLog, fileNum)
+		size := logSize
+		if fileNum != logNum {
+			info, err := fs.Stat(srcPath)
+			if err != nil {
+				return err
+			}
+			size = info.Size()
+		}
+		if err := r.ship(rf, fileTypeLog, srcPath, size); err != nil {
+			return err
+		}
+		if fileNum != logNum {
+			if err := rf.close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Ship the MANIFEST. If the primary has rotated its MANIFEST, the new
+	// MANIFEST is shipped, and installed by writing CURRENT.
+	var obsoleteManifest *replicatedFile
+	if r.manifest != nil && r.manifest.fileNum != manifestFileNum {
+		obsoleteManifest = r.manifest
+		r.manifest = nil
+	}
+	if r.manifest == nil {
+		r.manifest = &replicatedFile{fileNum: manifestFileNum}
+	}
+	installManifest := r.manifest.f == nil
+	srcPath := base.MakeFilename(fs, d.dirname, fileTypeManifest, manifestFileNum)
+	if err := r.ship(r.manifest, fileTypeManifest, srcPath, manifestSize); err != nil {
+		return err
+	}
+	if err := r.manifest.f.Sync(); err != nil {
+		return err
+	}
+	for _, rf := range r.logs {
+		if rf.f != nil {
+			if err := rf.f.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	if installManifest {
+		if err := setCurrentFile(r.dirname, r.fs, manifestFileNum); err != nil {
+			return err
+		}
+	}
+	if obsoleteManifest != nil {
+		if err := obsoleteManifest.close(); err != nil {
+			return err
+		}
+		r.markObsolete(fileTypeManifest, obsoleteManifest.fileNum)
+	}
+
+	// Remove the files which are no longer referenced by the replica.
+	for fileNum := range r.tables {
+		if _, ok := liveTables[fileNum]; !ok {
+			r.markObsolete(fileTypeTable, fileNum)
+			delete(r.tables, fileNum)
+		}
+	}
+	for fileNum, rf := range r.logs {
+		if _, ok := liveLogs[fileNum]; !ok {
+			if err := rf.close(); err != nil {
+				return err
+			}
+			r.markObsolete(fileTypeLog, fileNum)
+			delete(r.logs, fileNum)
+		}
+	}
+	if err := r.removeObsolete(); err != nil {
+		return err
+	}
+	return dir.Sync()
+}
+
+// ship appends the bytes of the file srcPath of the primary up to size to the
+// replica file rf, creating the replica file if it does not exist yet.
+func (r *Replicator) ship(rf *replicatedFile, fileType base.FileType, srcPath string, size int64) error {
+	if rf.f == nil {
+		f, err := r.fs.Create(base.MakeFilename(r.fs, r.dirname, fileType, rf.fileNum))
+		if err != nil {
+			return err
+		}
+		rf.f = f
+	}
+	if size <= rf.size {
+		return nil
+	}
+	src, err := r.d.opts.FS.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	n, err := io.Copy(rf.f, io.NewSectionReader(src, rf.size, size-rf.size))
+	rf.size += n
+	if err == nil && rf.size != size {
+		err = errors.Errorf("pebble: short read of %q: %d of %d bytes",
+			errors.Safe(srcPath), errors.Safe(rf.size), errors.Safe(size))
+	}
+	return err
+}
+
+// close syncs and closes the replica file, which is complete.
+func (rf *replicatedFile) close() error {
+	rf.complete = true
+	if rf.f == nil {
+		return nil
+	}
+	f := rf.f
+	rf.f = nil
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *Replicator) markObsolete(fileType base.FileType, fileNum FileNum) {
+	r.obsolete = append(r.obsolete, base.MakeFilename(r.fs, r.dirname, fileType, fileNum))
+}
+
+// removeObsolete removes the obsolete files from the replica. A file which is
+// open, for example by a follower of the replica, cannot be removed on some
+// filesystems, in which case its removal is retried by the next round.
+func (r *Replicator) removeObsolete() error {
+	var err error
+	remaining := r.obsolete[:0]
+	for _, path := range r.obsolete {
+		if rerr := r.fs.Remove(path); rerr == nil || os.IsNotExist(rerr) {
+			continue
+		} else if rerr != os.ErrInvalid {
+			err = firstError(err, rerr)
+		}
+		remaining = append(remaining, path)
+	}
+	r.obsolete = remaining
+	return err
+}
+
+// Close stops replication, closing the files of the replica. The replica may
+// then be opened with Open in order to fail over to it.
+func (r *Replicator) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	var err error
+	if r.manifest != nil {
+		err = firstError(err, r.manifest.close())
+	}
+	for _, rf := range r.logs {
+		err = firstError(err, rf.close())
+	}
+	return err
+}
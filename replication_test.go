@@ -0,0 +1,123 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicator(t *testing.T) {
+	// The small MANIFEST size causes the primary to rotate its MANIFEST.
+	primary, err := Open("primary", &Options{FS: vfs.NewMem(), MaxManifestFileSize: 1})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, primary.Close())
+	}()
+
+	contents := func(r Reader) string {
+		iter := r.NewIter(nil)
+		var parts []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			parts = append(parts, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(parts, " ")
+	}
+	tables := func(d *DB) []FileNum {
+		var fileNums []FileNum
+		levels, err := d.SSTables()
+		require.NoError(t, err)
+		for _, level := range levels {
+			for _, info := range level {
+				fileNums = append(fileNums, info.FileNum)
+			}
+		}
+		sort.Slice(fileNums, func(i, j int) bool {
+			return fileNums[i] < fileNums[j]
+		})
+		return fileNums
+	}
+
+	// The replica directory must be empty.
+	fs := vfs.NewMem()
+	require.NoError(t, fs.MkdirAll("full", 0755))
+	f, err := fs.Create("full/foo")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = primary.NewReplicator(fs, "full")
+	require.Error(t, err)
+
+	r, err := primary.NewReplicator(fs, "replica")
+	require.NoError(t, err)
+
+	// The replica holds both the flushed and unflushed keys once replicated,
+	// and may be read by a follower.
+	require.NoError(t, primary.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, r.Replicate())
+	follower, err := OpenFollower("replica", &Options{FS: fs})
+	require.NoError(t, err)
+	require.Equal(t, "a=1 b=2", contents(follower))
+
+	// Later rounds ship the tail of the WAL, and the new sstables and version
+	// edits of flushes and compactions.
+	require.NoError(t, primary.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, primary.Delete([]byte("a"), nil))
+	require.NoError(t, r.Replicate())
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "b=2 c=3", contents(follower))
+
+	require.NoError(t, primary.Set([]byte("d"), []byte("4"), nil))
+	require.NoError(t, primary.Compact([]byte("a"), []byte("e"), false))
+	require.NoError(t, primary.Set([]byte("e"), []byte("5"), nil))
+	require.NoError(t, r.Replicate())
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "b=2 c=3 d=4 e=5", contents(follower))
+	require.Equal(t, tables(primary), tables(follower))
+
+	// The obsolete sstables are removed from the replica. MemFS does not allow
+	// the removal of the tables held open by the follower until it has caught
+	// up, so they are removed by the next round.
+	require.NoError(t, r.Replicate())
+	ls, err := fs.List("replica")
+	require.NoError(t, err)
+	primary.mu.Lock()
+	manifestFileNum := primary.mu.versions.manifestFileNum
+	primary.mu.Unlock()
+	var manifests int
+	for _, filename := range ls {
+		ft, fileNum, ok := base.ParseFilename(fs, filename)
+		switch {
+		case ok && ft == fileTypeTable:
+			require.Contains(t, tables(primary), fileNum)
+		case ok && ft == fileTypeManifest:
+			require.Equal(t, manifestFileNum, fileNum)
+			manifests++
+		}
+	}
+	require.Equal(t, 1, manifests)
+	require.NoError(t, follower.Close())
+
+	// Writes after the last round are not replicated. Failing over opens the
+	// replica as a DB which holds the replicated writes, including those
+	// which were only in the WAL, and accepts new writes.
+	require.NoError(t, primary.Set([]byte("f"), []byte("6"), nil))
+	require.NoError(t, r.Close())
+	require.Equal(t, ErrClosed, r.Replicate())
+	d, err := Open("replica", &Options{FS: fs})
+	require.NoError(t, err)
+	require.Equal(t, "b=2 c=3 d=4 e=5", contents(d))
+	require.NoError(t, d.Set([]byte("g"), []byte("7"), nil))
+	require.Equal(t, "b=2 c=3 d=4 e=5 g=7", contents(d))
+	require.NoError(t, d.Close())
+}
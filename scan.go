@@ -0,0 +1,81 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// ScanOptions hold the optional per-query parameters for Scan.
+type ScanOptions struct {
+	// IterOptions configures the iterator used by the scan. The scan only
+	// returns point keys, and does not use prefix iteration:
+	// IterOptions.KeyTypes and IterOptions.PrefixIteration are ignored.
+	IterOptions
+	// ResumeKey, if non-nil, continues a previous scan from the resume key it
+	// returned. The other options should be unchanged from the previous scan.
+	ResumeKey []byte
+	// MaxKeys, if positive, stops the scan after the specified number of keys
+	// have been returned.
+	MaxKeys int
+	// MaxBytes, if positive, stops the scan once the returned keys and values
+	// total at least the specified number of bytes. At least one key is
+	// returned by every scan which does not reach the end of its bounds.
+	MaxBytes int64
+}
+
+// Scan calls fn for the key/value pairs of the Reader in key order, within
+// the bounds of opts.IterOptions, stopping when opts.MaxKeys or opts.MaxBytes
+// is reached. It supports pagination without holding an iterator open across
+// pages: if the scan stops before reaching the end of its bounds, Scan returns
+// an opaque resume key which is passed in ScanOptions.ResumeKey to continue
+// the scan. A nil resume key indicates that the scan is complete. A resume
+// key may be returned when no further keys remain, in which case the resumed
+// scan returns no keys and a nil resume key. A consistent view across pages
+// requires scanning a Snapshot.
+//
+// The key and value passed to fn are only valid for the duration of the call.
+// If fn returns an error, Scan stops and returns the error.
+func Scan(r Reader, opts *ScanOptions, fn func(key, value []byte) error) ([]byte, error) {
+	var o ScanOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.KeyTypes = IterKeyTypePointsOnly
+	o.PrefixIteration = false
+
+	iter := r.NewIter(&o.IterOptions)
+	if err := iter.Error(); err != nil {
+		_ = iter.Close()
+		return nil, err
+	}
+	var valid bool
+	if o.ResumeKey != nil {
+		// The resume key is the last key returned by the previous scan. Seek to
+		// it and step past it if it is still present.
+		valid = iter.SeekGE(o.ResumeKey)
+		if valid && iter.cmp(iter.Key(), o.ResumeKey) == 0 {
+			valid = iter.Next()
+		}
+	} else {
+		valid = iter.First()
+	}
+
+	var keys int
+	var bytes int64
+	var resumeKey []byte
+	for ; valid; valid = iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			_ = iter.Close()
+			return nil, err
+		}
+		keys++
+		bytes += int64(len(iter.Key()) + len(iter.Value()))
+		if (o.MaxKeys > 0 && keys >= o.MaxKeys) || (o.MaxBytes > 0 && bytes >= o.MaxBytes) {
+			resumeKey = append([]byte(nil), iter.Key()...)
+			break
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return resumeKey, nil
+}
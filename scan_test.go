@@ -0,0 +1,119 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	var all []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("%03d", i)
+		require.NoError(t, d.Set([]byte(key), []byte(key), nil))
+		all = append(all, key)
+	}
+
+	// scanPages scans r in pages, returning the keys of each page.
+	scanPages := func(r Reader, opts ScanOptions) [][]string {
+		var pages [][]string
+		for {
+			var page []string
+			resumeKey, err := Scan(r, &opts, func(key, value []byte) error {
+				require.Equal(t, key, value)
+				page = append(page, string(key))
+				return nil
+			})
+			require.NoError(t, err)
+			pages = append(pages, page)
+			if resumeKey == nil {
+				return pages
+			}
+			opts.ResumeKey = resumeKey
+		}
+	}
+	flatten := func(pages [][]string) []string {
+		var keys []string
+		for _, page := range pages {
+			keys = append(keys, page...)
+		}
+		return keys
+	}
+
+	// Without limits, a single page is returned.
+	pages := scanPages(d, ScanOptions{})
+	require.Equal(t, 1, len(pages))
+	require.Equal(t, all, pages[0])
+
+	pages = scanPages(d, ScanOptions{MaxKeys: 7})
+	require.Equal(t, all, flatten(pages))
+	for _, page := range pages[:len(pages)-1] {
+		require.Equal(t, 7, len(page))
+	}
+
+	// Each key and value is 6 bytes.
+	pages = scanPages(d, ScanOptions{MaxBytes: 20})
+	require.Equal(t, all, flatten(pages))
+	require.Equal(t, 4, len(pages[0]))
+
+	pages = scanPages(d, ScanOptions{
+		IterOptions: IterOptions{LowerBound: []byte("010"), UpperBound: []byte("020")},
+		MaxKeys:     4,
+	})
+	require.Equal(t, [][]string{all[10:14], all[14:18], all[18:20]}, pages)
+
+	// The resume key need not be present when the scan is resumed.
+	opts := &ScanOptions{MaxKeys: 2}
+	resumeKey, err := Scan(d, opts, func(key, value []byte) error { return nil })
+	require.NoError(t, err)
+	require.Equal(t, "001", string(resumeKey))
+	require.NoError(t, d.Delete([]byte("001"), nil))
+	opts.ResumeKey = resumeKey
+	var keys []string
+	_, err = Scan(d, opts, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"002", "003"}, keys)
+
+	// A snapshot provides a consistent view across pages.
+	snap := d.NewSnapshot()
+	opts = &ScanOptions{MaxKeys: 50}
+	resumeKey, err = Scan(snap, opts, func(key, value []byte) error { return nil })
+	require.NoError(t, err)
+	require.NoError(t, d.DeleteRange([]byte("000"), []byte("100"), nil))
+	opts.ResumeKey = resumeKey
+	keys = nil
+	resumeKey, err = Scan(snap, opts, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, all[51:], keys)
+	require.Nil(t, resumeKey)
+	require.NoError(t, snap.Close())
+
+	// Errors returned by the callback stop the scan.
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	errTest := errors.New("test")
+	_, err = Scan(d, nil, func(key, value []byte) error { return errTest })
+	require.Equal(t, errTest, err)
+
+	// Non-indexed batches cannot be read.
+	_, err = Scan(d.NewBatch(), nil, func(key, value []byte) error { return nil })
+	require.Equal(t, ErrNotIndexed, err)
+}
@@ -4,7 +4,11 @@
 
 package pebble
 
-import "io"
+import (
+	"context"
+	"io"
+	"sync"
+)
 
 // Snapshot provides a read-only point-in-time view of the DB state.
 type Snapshot struct {
@@ -12,6 +16,16 @@ type Snapshot struct {
 	db     *DB
 	seqNum uint64
 
+	// fileOnly is set for an eventually file-only snapshot (see
+	// DB.NewEventuallyFileOnlySnapshot). Once all of the keys visible to the
+	// snapshot have been flushed, readState holds the version the snapshot
+	// reads from and the snapshot is removed from the snapshot list.
+	fileOnly struct {
+		enabled bool
+		sync.Mutex
+		readState *readState
+	}
+
 	// The list the snapshot is linked into.
 	list *snapshotList
 
@@ -32,7 +46,17 @@ func (s *Snapshot) Get(key []byte) ([]byte, io.Closer, error) {
 	if s.db == nil {
 		panic(ErrClosed)
 	}
-	return s.db.getInternal(key, nil /* batch */, s)
+	return s.db.getInternal(context.Background(), key, nil /* batch */, s)
+}
+
+// GetWithContext is like Get, but once ctx is done, opening sstables and
+// reading sstable blocks which are not in the cache fail with the context's
+// error.
+func (s *Snapshot) GetWithContext(ctx context.Context, key []byte) ([]byte, io.Closer, error) {
+	if s.db == nil {
+		panic(ErrClosed)
+	}
+	return s.db.getInternal(ctx, key, nil /* batch */, s)
 }
 
 // NewIter returns an iterator that is unpositioned (Iterator.Valid() will
@@ -42,7 +66,19 @@ func (s *Snapshot) NewIter(o *IterOptions) *Iterator {
 	if s.db == nil {
 		panic(ErrClosed)
 	}
-	return s.db.newIterInternal(nil /* batchIter */, nil /* batchRangeDelIter */, s, o)
+	return s.db.newIterInternal(nil /* batchIter */, nil, /* batchRangeDelIter */
+		nil /* batchRangeKeyIter */, s, o)
+}
+
+// NewIterWithContext is like NewIter, but once ctx is done, the iterator's
+// opening of sstables and reads of sstable blocks which are not in the cache
+// fail with the context's error.
+func (s *Snapshot) NewIterWithContext(ctx context.Context, o *IterOptions) *Iterator {
+	if s.db == nil {
+		panic(ErrClosed)
+	}
+	return s.db.newIterInternal(nil /* batchIter */, nil, /* batchRangeDelIter */
+		nil /* batchRangeKeyIter */, s, withContext(ctx, o))
 }
 
 // Close closes the snapshot, releasing its resources. Close must be
@@ -54,12 +90,57 @@ func (s *Snapshot) Close() error {
 		panic(ErrClosed)
 	}
 	s.db.mu.Lock()
-	s.db.mu.snapshots.remove(s)
+	// NB: an eventually file-only snapshot is removed from the snapshot list
+	// once it becomes file-only.
+	if s.list != nil {
+		s.db.mu.snapshots.remove(s)
+	}
+	// Releasing the snapshot may allow tables covered by range tombstones to
+	// be deleted.
+	if len(s.db.mu.compact.deletionHints) > 0 {
+		s.db.maybeScheduleCompaction()
+	}
+	s.fileOnly.Lock()
+	readState := s.fileOnly.readState
+	s.fileOnly.readState = nil
+	s.fileOnly.Unlock()
 	s.db.mu.Unlock()
+	if readState != nil {
+		readState.unref()
+	}
 	s.db = nil
 	return nil
 }
 
+// loadReadState returns the readState to read from at the snapshot, which
+// must be unreferenced when the caller is finished with it.
+func (s *Snapshot) loadReadState() *readState {
+	if s.fileOnly.enabled {
+		s.fileOnly.Lock()
+		readState := s.fileOnly.readState
+		if readState != nil {
+			readState.ref()
+		}
+		s.fileOnly.Unlock()
+		if readState != nil {
+			return readState
+		}
+	}
+	return s.db.loadReadState()
+}
+
+// FileOnly returns true if the snapshot is an eventually file-only snapshot
+// which has become file-only, no longer preventing the DB from discarding the
+// keys it shadows (see DB.NewEventuallyFileOnlySnapshot).
+func (s *Snapshot) FileOnly() bool {
+	if s.db == nil {
+		panic(ErrClosed)
+	}
+	s.fileOnly.Lock()
+	defer s.fileOnly.Unlock()
+	return s.fileOnly.readState != nil
+}
+
 type snapshotList struct {
 	root Snapshot
 }
@@ -108,7 +108,7 @@ func TestSnapshot(t *testing.T) {
 					if len(keys) != 2 {
 						return fmt.Sprintf("malformed key range: %s", parts[1])
 					}
-					err = d.Compact([]byte(keys[0]), []byte(keys[1]))
+					err = d.Compact([]byte(keys[0]), []byte(keys[1]), false)
 				default:
 					return fmt.Sprintf("unknown op: %s", parts[0])
 				}
@@ -347,3 +347,58 @@ func TestNewSnapshotRace(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, d.Close())
 }
+
+func TestEventuallyFileOnlySnapshot(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+
+	get := func(r Reader, key string) string {
+		v, closer, err := r.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	count := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.mu.snapshots.toSlice())
+	}
+
+	// The snapshot initially pins its sequence number, as the keys visible to
+	// it reside in the memtable.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	s := d.NewEventuallyFileOnlySnapshot()
+	require.False(t, s.FileOnly())
+	require.Equal(t, 1, count())
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.Equal(t, "1", get(s, "a"))
+
+	// Once the memtable is flushed, the snapshot no longer pins its sequence
+	// number, and the compaction discards the key it shadows.
+	require.NoError(t, d.Flush())
+	require.True(t, s.FileOnly())
+	require.Equal(t, 0, count())
+	require.NoError(t, d.Set([]byte("b"), []byte("3"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+	require.Equal(t, "1", get(s, "a"))
+	require.Equal(t, "<not found>", get(s, "b"))
+	iter := s.NewIter(nil)
+	require.True(t, iter.First())
+	require.Equal(t, "a", string(iter.Key()))
+	require.False(t, iter.Next())
+	require.NoError(t, iter.Close())
+	require.NoError(t, s.Close())
+
+	// A snapshot created when the memtables contain no keys visible to it is
+	// immediately file-only.
+	s = d.NewEventuallyFileOnlySnapshot()
+	require.True(t, s.FileOnly())
+	require.Equal(t, "2", get(s, "a"))
+	require.Equal(t, "3", get(s, "b"))
+	require.NoError(t, s.Close())
+
+	require.NoError(t, d.Close())
+}
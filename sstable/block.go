@@ -815,6 +815,29 @@ func (i *blockIter) Value() []byte {
 	return i.val
 }
 
+// peekNextValue returns the value of the entry following the current entry
+// without repositioning the iterator, or nil if there is no such entry or it
+// cannot be decoded. It is only valid during forward iteration.
+func (i *blockIter) peekNextValue() []byte {
+	if !i.Valid() || i.nextOffset >= i.restarts {
+		return nil
+	}
+	b := i.data[i.nextOffset:i.restarts]
+	var lens [3]uint64
+	for j := range lens {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil
+		}
+		lens[j], b = v, b[n:]
+	}
+	unshared, valueLen := lens[1], lens[2]
+	if unshared+valueLen > uint64(len(b)) {
+		return nil
+	}
+	return b[unshared : unshared+valueLen]
+}
+
 // Valid implements internalIterator.Valid, as documented in the pebble
 // package.
 func (i *blockIter) Valid() bool {
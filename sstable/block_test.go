@@ -15,6 +15,7 @@ import (
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 )
@@ -310,6 +311,39 @@ func TestBlockIterReverseDirections(t *testing.T) {
 	}
 }
 
+func TestBlockIterAllocs(t *testing.T) {
+	if invariants.RaceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	w := &blockWriter{restartInterval: 16}
+	for i := 0; i < 1000; i++ {
+		w.add(InternalKey{UserKey: []byte(fmt.Sprintf("%05d", i))}, []byte("value"))
+	}
+	block := w.finish()
+	i, err := newBlockIter(bytes.Compare, block)
+	require.NoError(t, err)
+
+	// Iterate over the block in each direction once, so that the buffers used
+	// for reverse iteration have grown to their final size.
+	for key, _ := i.Last(); key != nil; key, _ = i.Prev() {
+	}
+	for key, _ := i.First(); key != nil; key, _ = i.Next() {
+	}
+
+	i.First()
+	require.Equal(t, float64(0), testing.AllocsPerRun(1000, func() {
+		if key, _ := i.Next(); key == nil {
+			i.First()
+		}
+	}))
+	i.Last()
+	require.Equal(t, float64(0), testing.AllocsPerRun(1000, func() {
+		if key, _ := i.Prev(); key == nil {
+			i.Last()
+		}
+	}))
+}
+
 func BenchmarkBlockIterSeekGE(b *testing.B) {
 	const blockSize = 32 << 10
 
@@ -0,0 +1,218 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// BulkWriterOptions holds the parameters used by a BulkWriter.
+type BulkWriterOptions struct {
+	// WriterOptions are used to build each of the sstables.
+	WriterOptions
+
+	// TargetFileSize is the target size in bytes of each sstable. An sstable is
+	// finished once the size of the keys and values added to it reaches the
+	// target. The size is measured before compression, so compressed sstables
+	// will be smaller than the target.
+	//
+	// The default value is 64 MB.
+	TargetFileSize int64
+
+	// Concurrency is the maximum number of sstables that are built
+	// concurrently. The keys and values of each sstable being built are
+	// buffered in memory, so a BulkWriter uses approximately
+	// Concurrency*TargetFileSize bytes of memory.
+	//
+	// The default value is 1.
+	Concurrency int
+
+	// Create is called to create the file for the i'th sstable, numbered from
+	// zero in key order. Create is never called concurrently.
+	Create func(i int) (vfs.File, error)
+}
+
+func (o BulkWriterOptions) ensureDefaults() BulkWriterOptions {
+	o.WriterOptions = o.WriterOptions.ensureDefaults()
+	if o.TargetFileSize <= 0 {
+		o.TargetFileSize = 64 << 20
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	return o
+}
+
+// bulkChunk holds the keys and values of a single sstable.
+type bulkChunk struct {
+	index int
+	buf   []byte
+	// offsets holds the end offset within buf of each key and value, in
+	// alternating order.
+	offsets []int
+}
+
+// BulkWriter builds a set of non-overlapping sstables from a stream of keys
+// added in strictly increasing order. The stream is split into sstables of
+// approximately BulkWriterOptions.TargetFileSize bytes, which are built in
+// parallel. All of the keys are written with a zero sequence number, so the
+// resulting sstables can be ingested by DB.Ingest.
+type BulkWriter struct {
+	opts      BulkWriterOptions
+	formatKey base.FormatKey
+	chunk     bulkChunk
+	lastKey   []byte
+	tables    int
+	closed    bool
+	sem       chan struct{}
+	wg        sync.WaitGroup
+	mu        struct {
+		sync.Mutex
+		err  error
+		meta []*WriterMetadata
+	}
+}
+
+// NewBulkWriter returns a new BulkWriter.
+func NewBulkWriter(o BulkWriterOptions) *BulkWriter {
+	o = o.ensureDefaults()
+	w := &BulkWriter{
+		opts:      o,
+		formatKey: o.Comparer.FormatKey,
+		sem:       make(chan struct{}, o.Concurrency),
+	}
+	if w.formatKey == nil {
+		w.formatKey = base.DefaultFormatter
+	}
+	return w
+}
+
+// Set adds a key and value to the sstables being built. The key must be
+// greater than all of the keys previously added. Set does not retain the key
+// or value slices.
+func (w *BulkWriter) Set(key, value []byte) error {
+	if err := w.error(); err != nil {
+		return err
+	}
+	if w.closed {
+		return errors.New("pebble: bulk writer is closed")
+	}
+	if w.lastKey != nil && w.opts.Comparer.Compare(w.lastKey, key) >= 0 {
+		return errors.Errorf("pebble: keys must be added in strictly increasing order: %s, %s",
+			w.formatKey(w.lastKey), w.formatKey(key))
+	}
+	w.lastKey = append(w.lastKey[:0], key...)
+
+	c := &w.chunk
+	c.buf = append(c.buf, key...)
+	c.offsets = append(c.offsets, len(c.buf))
+	c.buf = append(c.buf, value...)
+	c.offsets = append(c.offsets, len(c.buf))
+	if int64(len(c.buf)) >= w.opts.TargetFileSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush starts building an sstable from the current chunk, waiting for a
+// concurrency slot to become available.
+func (w *BulkWriter) flush() error {
+	if len(w.chunk.offsets) == 0 {
+		return nil
+	}
+	w.sem <- struct{}{}
+	f, err := w.opts.Create(w.tables)
+	if err != nil {
+		<-w.sem
+		w.setError(err)
+		return err
+	}
+	chunk := w.chunk
+	chunk.index = w.tables
+	w.chunk = bulkChunk{}
+	w.tables++
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.sem }()
+		meta, err := w.build(f, chunk)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			if w.mu.err == nil {
+				w.mu.err = err
+			}
+			return
+		}
+		for len(w.mu.meta) <= chunk.index {
+			w.mu.meta = append(w.mu.meta, nil)
+		}
+		w.mu.meta[chunk.index] = meta
+	}()
+	return nil
+}
+
+func (w *BulkWriter) build(f vfs.File, c bulkChunk) (*WriterMetadata, error) {
+	tw := NewWriter(f, w.opts.WriterOptions)
+	start := 0
+	for i := 0; i < len(c.offsets); i += 2 {
+		key := c.buf[start:c.offsets[i]]
+		value := c.buf[c.offsets[i]:c.offsets[i+1]]
+		start = c.offsets[i+1]
+		if err := tw.Set(key, value); err != nil {
+			_ = tw.Close()
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return tw.Metadata()
+}
+
+func (w *BulkWriter) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mu.err
+}
+
+func (w *BulkWriter) setError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.mu.err == nil {
+		w.mu.err = err
+	}
+}
+
+// Close finishes building the sstables, waiting for the sstables being built
+// in the background to be written and closed.
+func (w *BulkWriter) Close() error {
+	if w.closed {
+		return w.error()
+	}
+	if err := w.error(); err == nil {
+		_ = w.flush()
+	}
+	w.closed = true
+	w.wg.Wait()
+	return w.error()
+}
+
+// Metadata returns the metadata of the sstables built, in key order. It is
+// only valid to call Metadata after Close has returned successfully.
+func (w *BulkWriter) Metadata() ([]*WriterMetadata, error) {
+	if !w.closed {
+		return nil, errors.New("pebble: bulk writer is not closed")
+	}
+	if err := w.error(); err != nil {
+		return nil, err
+	}
+	return w.mu.meta, nil
+}
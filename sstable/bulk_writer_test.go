@@ -0,0 +1,98 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkWriter(t *testing.T) {
+	const count = 1000
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			mem := vfs.NewMem()
+			w := NewBulkWriter(BulkWriterOptions{
+				TargetFileSize: 1000,
+				Concurrency:    concurrency,
+				Create: func(i int) (vfs.File, error) {
+					return mem.Create(fmt.Sprintf("%06d.sst", i))
+				},
+			})
+			for i := 0; i < count; i++ {
+				key := []byte(fmt.Sprintf("key%04d", i))
+				require.NoError(t, w.Set(key, []byte(fmt.Sprintf("value%d", i))))
+			}
+			_, err := w.Metadata()
+			require.Error(t, err)
+			require.NoError(t, w.Close())
+
+			metas, err := w.Metadata()
+			require.NoError(t, err)
+			// The keys and values total 14890 bytes.
+			require.Equal(t, 15, len(metas))
+
+			i := 0
+			for j, meta := range metas {
+				require.EqualValues(t, 0, meta.LargestSeqNum)
+				if j > 0 {
+					require.True(t, DefaultComparer.Compare(
+						metas[j-1].LargestPoint.UserKey, meta.SmallestPoint.UserKey) < 0)
+				}
+
+				f, err := mem.Open(fmt.Sprintf("%06d.sst", j))
+				require.NoError(t, err)
+				r, err := NewReader(f, ReaderOptions{})
+				require.NoError(t, err)
+				iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+				require.NoError(t, err)
+				for key, value := iter.First(); key != nil; key, value = iter.Next() {
+					require.Equal(t, fmt.Sprintf("key%04d", i), string(key.UserKey))
+					require.Equal(t, fmt.Sprintf("value%d", i), string(value))
+					i++
+				}
+				require.NoError(t, iter.Close())
+				require.NoError(t, r.Close())
+			}
+			require.Equal(t, count, i)
+		})
+	}
+}
+
+func TestBulkWriterErrors(t *testing.T) {
+	mem := vfs.NewMem()
+	create := func(i int) (vfs.File, error) {
+		return mem.Create(fmt.Sprintf("%06d.sst", i))
+	}
+
+	w := NewBulkWriter(BulkWriterOptions{Create: create})
+	require.NoError(t, w.Set([]byte("b"), nil))
+	err := w.Set([]byte("b"), nil)
+	require.EqualError(t, err, `pebble: keys must be added in strictly increasing order: b, b`)
+	require.Error(t, w.Set([]byte("a"), nil))
+	require.NoError(t, w.Set([]byte("c"), nil))
+	require.NoError(t, w.Close())
+	require.EqualError(t, w.Set([]byte("d"), nil), "pebble: bulk writer is closed")
+
+	w = NewBulkWriter(BulkWriterOptions{
+		TargetFileSize: 1,
+		Create: func(i int) (vfs.File, error) {
+			if i == 1 {
+				return nil, errors.New("injected error")
+			}
+			return create(i)
+		},
+	})
+	require.NoError(t, w.Set([]byte("a"), nil))
+	require.EqualError(t, w.Set([]byte("b"), nil), "injected error")
+	require.EqualError(t, w.Set([]byte("c"), nil), "injected error")
+	require.EqualError(t, w.Close(), "injected error")
+	_, err = w.Metadata()
+	require.EqualError(t, err, "injected error")
+}
@@ -4,7 +4,12 @@
 
 package sstable
 
-import "github.com/cockroachdb/pebble/internal/base"
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
 
 // Compare exports the base.Compare type.
 type Compare = base.Compare
@@ -32,3 +37,41 @@ var DefaultComparer = base.DefaultComparer
 
 // Merger exports the base.Merger type.
 type Merger = base.Merger
+
+var registeredComparers struct {
+	sync.RWMutex
+	m map[string]*Comparer
+}
+
+// RegisterComparer makes a comparer available by name to all Readers. A Reader
+// which is not otherwise configured with the comparer named by an sstable's
+// properties uses the registered comparer of that name. This allows tools and
+// libraries to read sstables written with custom comparers, such as comparers
+// backed by a collation library, without threading a Comparers option through
+// every call to NewReader.
+//
+// RegisterComparer panics if the comparer has no name, or if a different
+// comparer is already registered under the same name.
+func RegisterComparer(c *Comparer) {
+	if c.Name == "" {
+		panic("pebble: comparer must have a name to be registered")
+	}
+	registeredComparers.Lock()
+	defer registeredComparers.Unlock()
+	if registeredComparers.m == nil {
+		registeredComparers.m = make(map[string]*Comparer)
+	}
+	if prev, ok := registeredComparers.m[c.Name]; ok && prev != c {
+		panic(fmt.Sprintf("pebble: comparer %q is already registered", c.Name))
+	}
+	registeredComparers.m[c.Name] = c
+}
+
+// LookupComparer returns the comparer registered with the specified name by
+// RegisterComparer.
+func LookupComparer(name string) (*Comparer, bool) {
+	registeredComparers.RLock()
+	defer registeredComparers.RUnlock()
+	c, ok := registeredComparers.m[name]
+	return c, ok
+}
@@ -0,0 +1,472 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package corpus generates a corpus of sstables exercising the combinations of
+// features supported by the sstable package: compression types, filters,
+// two-level (partitioned) indexes, range deletions, global sequence numbers
+// and comparers. The contents of every sstable are deterministic, allowing the
+// corpus to be used to validate readers, as well as tooling outside of Pebble
+// that consumes Pebble-written sstables.
+package corpus // import "github.com/cockroachdb/pebble/sstable/corpus"
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	// numKeys is the number of point entries in each sstable.
+	numKeys = 1000
+	// rangeDelInterval is the number of keys between the start of each range
+	// deletion.
+	rangeDelInterval = 50
+	// blockSize is small so that each sstable contains many data blocks.
+	blockSize = 1024
+	// twoLevelIndexBlockSize is small so that sstables with a two-level index
+	// contain many index partitions.
+	twoLevelIndexBlockSize = 256
+)
+
+func mvccSplit(a []byte) int {
+	if i := bytes.LastIndexByte(a, '@'); i >= 0 {
+		return i
+	}
+	return len(a)
+}
+
+// PrefixComparer orders keys bytewise, and defines the prefix of a key as the
+// portion preceding the last '@', if any. Filters in sstables written with
+// PrefixComparer are built on key prefixes rather than whole keys.
+var PrefixComparer = func() *sstable.Comparer {
+	c := *base.DefaultComparer
+	c.Split = mvccSplit
+	c.Name = "pebble.corpus.PrefixBytewiseComparator"
+	return &c
+}()
+
+// MVCCComparer orders keys by their prefix, defined as the portion preceding
+// the last '@', if any, and then by their suffix in reverse order so that
+// newer versions of a key sort first. A key without a suffix sorts before all
+// keys with the same prefix and a suffix. Filters in sstables written with
+// MVCCComparer are built on key prefixes rather than whole keys.
+var MVCCComparer = &sstable.Comparer{
+	Compare: func(a, b []byte) int {
+		ai, bi := mvccSplit(a), mvccSplit(b)
+		if c := bytes.Compare(a[:ai], b[:bi]); c != 0 {
+			return c
+		}
+		if ai == len(a) || bi == len(b) {
+			return bytes.Compare(a[ai:], b[bi:])
+		}
+		return bytes.Compare(b[bi:], a[ai:])
+	},
+	Equal: bytes.Equal,
+	AbbreviatedKey: func(key []byte) uint64 {
+		return base.DefaultComparer.AbbreviatedKey(key[:mvccSplit(key)])
+	},
+	FormatKey: base.DefaultFormatter,
+	Separator: func(dst, a, b []byte) []byte {
+		return append(dst, a...)
+	},
+	Successor: func(dst, a []byte) []byte {
+		return append(dst, a...)
+	},
+	Split: mvccSplit,
+	Name:  "pebble.corpus.MVCCComparator",
+}
+
+// Comparers lists the comparers used by the corpus.
+var Comparers = []*sstable.Comparer{
+	sstable.DefaultComparer,
+	PrefixComparer,
+	MVCCComparer,
+}
+
+var comparerShortNames = map[string]string{
+	sstable.DefaultComparer.Name: "bytewise",
+	PrefixComparer.Name:          "prefix",
+	MVCCComparer.Name:            "mvcc",
+}
+
+// filterPolicy is the filter policy used by sstables with a filter.
+var filterPolicy = bloom.FilterPolicy(10)
+
+// Spec describes a single sstable in the corpus.
+type Spec struct {
+	// Comparer is the comparer used to order the keys in the sstable. It must
+	// be one of Comparers.
+	Comparer *sstable.Comparer
+	// Compression is the compression applied to the sstable's blocks.
+	Compression sstable.Compression
+	// Filter indicates whether the sstable contains a bloom filter. The filter
+	// is built on key prefixes if the Comparer defines Split, and on whole keys
+	// otherwise.
+	Filter bool
+	// TwoLevelIndex indicates whether the sstable's index is partitioned.
+	TwoLevelIndex bool
+	// RangeDels indicates whether the sstable contains range deletions.
+	RangeDels bool
+	// GlobalSeqNum, if non-zero, is recorded as the global sequence number of
+	// the sstable. Otherwise each entry is assigned a distinct sequence number.
+	GlobalSeqNum uint64
+}
+
+// Specs returns the specs for every sstable in the corpus.
+func Specs() []Spec {
+	var specs []Spec
+	for _, cmp := range Comparers {
+		for _, compression := range []sstable.Compression{
+			sstable.NoCompression, sstable.SnappyCompression,
+		} {
+			for _, filter := range []bool{false, true} {
+				for _, twoLevel := range []bool{false, true} {
+					for _, rangeDels := range []bool{false, true} {
+						for _, globalSeqNum := range []uint64{0, 2 * numKeys} {
+							specs = append(specs, Spec{
+								Comparer:      cmp,
+								Compression:   compression,
+								Filter:        filter,
+								TwoLevelIndex: twoLevel,
+								RangeDels:     rangeDels,
+								GlobalSeqNum:  globalSeqNum,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	return specs
+}
+
+// String returns a description of the spec, which is also used as the base of
+// the spec's filename.
+func (s Spec) String() string {
+	parts := []string{comparerShortNames[s.Comparer.Name]}
+	switch s.Compression {
+	case sstable.NoCompression:
+		parts = append(parts, "no-compression")
+	default:
+		parts = append(parts, strings.ToLower(s.Compression.String()))
+	}
+	if s.Filter {
+		parts = append(parts, "bloom")
+	}
+	if s.TwoLevelIndex {
+		parts = append(parts, "two-level-index")
+	}
+	if s.RangeDels {
+		parts = append(parts, "range-dels")
+	}
+	if s.GlobalSeqNum != 0 {
+		parts = append(parts, fmt.Sprintf("global-seqnum-%d", s.GlobalSeqNum))
+	}
+	return strings.Join(parts, ".")
+}
+
+// Filename returns the name of the file containing the spec's sstable.
+func (s Spec) Filename() string {
+	return s.String() + ".sst"
+}
+
+// WriterOptions returns the options used to write the spec's sstable.
+func (s Spec) WriterOptions() sstable.WriterOptions {
+	opts := sstable.WriterOptions{
+		BlockSize:   blockSize,
+		Comparer:    s.Comparer,
+		Compression: s.Compression,
+		// Prevent the index from being partitioned.
+		IndexBlockSize: math.MaxInt32,
+	}
+	if s.Filter {
+		opts.FilterPolicy = filterPolicy
+		opts.FilterType = sstable.TableFilter
+	}
+	if s.TwoLevelIndex {
+		opts.IndexBlockSize = twoLevelIndexBlockSize
+	}
+	return opts
+}
+
+// ReaderOptions returns the options needed to read the spec's sstable.
+func (s Spec) ReaderOptions() sstable.ReaderOptions {
+	return sstable.ReaderOptions{
+		Comparer: s.Comparer,
+		Filters: map[string]sstable.FilterPolicy{
+			filterPolicy.Name(): filterPolicy,
+		},
+	}
+}
+
+// Entry is a key/value pair within an sstable. For range deletions, the value
+// is the end key of the deletion.
+type Entry struct {
+	Key   sstable.InternalKey
+	Value []byte
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s:%s", e.Key, e.Value)
+}
+
+// seqNum returns the sequence number with which the i'th entry is written.
+func (s Spec) seqNum(i int) uint64 {
+	if s.GlobalSeqNum != 0 {
+		return 0
+	}
+	return uint64(i + 1)
+}
+
+// readSeqNum returns the sequence number with which the i'th entry is read.
+func (s Spec) readSeqNum(i int) uint64 {
+	if s.GlobalSeqNum != 0 {
+		return s.GlobalSeqNum
+	}
+	return uint64(i + 1)
+}
+
+func (s Spec) points(seqNum func(int) uint64) []Entry {
+	entries := make([]Entry, numKeys)
+	for i := range entries {
+		var kind base.InternalKeyKind = base.InternalKeyKindSet
+		var value []byte
+		switch {
+		case i%7 == 3:
+			kind = base.InternalKeyKindDelete
+		case i%11 == 5:
+			kind = base.InternalKeyKindMerge
+			value = []byte(fmt.Sprintf("merge-%05d", i))
+		default:
+			value = bytes.Repeat([]byte(fmt.Sprintf("value-%05d.", i)), 1+i%4)
+		}
+		key := []byte(fmt.Sprintf("key%05d@%d", i/2, i%2))
+		entries[i] = Entry{
+			Key:   base.MakeInternalKey(key, seqNum(i), kind),
+			Value: value,
+		}
+	}
+	s.sort(entries)
+	return entries
+}
+
+func (s Spec) rangeDels(seqNum func(int) uint64) []Entry {
+	if !s.RangeDels {
+		return nil
+	}
+	var entries []Entry
+	for i := 0; i+rangeDelInterval/2 < numKeys/2; i += rangeDelInterval {
+		start := []byte(fmt.Sprintf("key%05d", i+10))
+		end := []byte(fmt.Sprintf("key%05d", i+20))
+		entries = append(entries, Entry{
+			Key:   base.MakeInternalKey(start, seqNum(numKeys+len(entries)), base.InternalKeyKindRangeDelete),
+			Value: end,
+		})
+	}
+	s.sort(entries)
+	return entries
+}
+
+func (s Spec) sort(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return base.InternalCompare(s.Comparer.Compare, entries[i].Key, entries[j].Key) < 0
+	})
+}
+
+func reverse(entries []Entry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
+
+// PointEntries returns the point entries of the spec's sstable, in the order
+// of the spec's comparer, as they are read from the sstable (i.e. with the
+// global sequence number applied).
+func (s Spec) PointEntries() []Entry {
+	return s.points(s.readSeqNum)
+}
+
+// RangeDelEntries returns the range deletions of the spec's sstable, in the
+// order of the spec's comparer, as they are read from the sstable (i.e. with
+// the global sequence number applied).
+func (s Spec) RangeDelEntries() []Entry {
+	return s.rangeDels(s.readSeqNum)
+}
+
+// Write writes the spec's sstable to f, closing f.
+func (s Spec) Write(f vfs.File) error {
+	var extraOpts []sstable.WriterOption
+	if s.GlobalSeqNum != 0 {
+		extraOpts = append(extraOpts, sstable.GlobalSeqNum(s.GlobalSeqNum))
+	}
+	w := sstable.NewWriter(f, s.WriterOptions(), extraOpts...)
+	for _, e := range s.points(s.seqNum) {
+		if err := w.Add(e.Key, e.Value); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	for _, e := range s.rangeDels(s.seqNum) {
+		if err := w.Add(e.Key, e.Value); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Generate writes the sstable for every spec returned by Specs to dir,
+// returning the specs.
+func Generate(fs vfs.FS, dir string) ([]Spec, error) {
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	specs := Specs()
+	for _, s := range specs {
+		f, err := fs.Create(filepath.Join(dir, s.Filename()))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Write(f); err != nil {
+			return nil, errors.Wrapf(err, "pebble: writing %s", errors.Safe(s.Filename()))
+		}
+	}
+	return specs, nil
+}
+
+// Verify checks that the sstable read by r has the properties and contents of
+// the spec's sstable. The reader must have been opened with the spec's
+// ReaderOptions.
+func (s Spec) Verify(r *sstable.Reader) error {
+	if err := s.verifyProperties(&r.Properties); err != nil {
+		return err
+	}
+	if err := s.verifyPoints(r); err != nil {
+		return err
+	}
+	return s.verifyRangeDels(r)
+}
+
+func (s Spec) verifyProperties(props *sstable.Properties) error {
+	check := func(name string, expected, actual interface{}) error {
+		if expected != actual {
+			return errors.Errorf("%s: expected %s %v, but found %v", s, name, expected, actual)
+		}
+		return nil
+	}
+	var filterPolicyName string
+	if s.Filter {
+		filterPolicyName = filterPolicy.Name()
+	}
+	if err := check("comparer", s.Comparer.Name, props.ComparerName); err != nil {
+		return err
+	}
+	if err := check("compression", s.Compression.String(), props.CompressionName); err != nil {
+		return err
+	}
+	if err := check("filter policy", filterPolicyName, props.FilterPolicyName); err != nil {
+		return err
+	}
+	if err := check("two-level index", s.TwoLevelIndex, props.IndexPartitions > 0); err != nil {
+		return err
+	}
+	if err := check("global seqnum", s.GlobalSeqNum, props.GlobalSeqNum); err != nil {
+		return err
+	}
+	numRangeDels := uint64(len(s.RangeDelEntries()))
+	if err := check("entries", numKeys+numRangeDels, props.NumEntries); err != nil {
+		return err
+	}
+	return check("range deletions", numRangeDels, props.NumRangeDeletions)
+}
+
+func (s Spec) verifyPoints(r *sstable.Reader) error {
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return err
+	}
+	expected := s.PointEntries()
+	if err := s.verifyIter(iter, "points", expected); err != nil {
+		_ = iter.Close()
+		return err
+	}
+
+	// Every key must be found by a prefix seek, which consults the filter if
+	// there is one.
+	for _, e := range expected {
+		prefix := e.Key.UserKey
+		if s.Comparer.Split != nil {
+			prefix = prefix[:s.Comparer.Split(prefix)]
+		}
+		key, _ := iter.SeekPrefixGE(prefix, e.Key.UserKey)
+		if key == nil || !s.Comparer.Equal(key.UserKey, e.Key.UserKey) {
+			_ = iter.Close()
+			return errors.Errorf("%s: seek-prefix-ge(%s) did not find key", s, e.Key.UserKey)
+		}
+	}
+	return iter.Close()
+}
+
+func (s Spec) verifyRangeDels(r *sstable.Reader) error {
+	iter, err := r.NewRangeDelIter()
+	if err != nil {
+		return err
+	}
+	expected := s.RangeDelEntries()
+	if iter == nil {
+		if len(expected) > 0 {
+			return errors.Errorf("%s: expected %d range deletions, but found none", s, len(expected))
+		}
+		return nil
+	}
+	if err := s.verifyIter(iter, "range deletions", expected); err != nil {
+		_ = iter.Close()
+		return err
+	}
+	return iter.Close()
+}
+
+// verifyIter checks that iterating forward and backward over iter produces
+// the expected entries.
+func (s Spec) verifyIter(iter base.InternalIterator, name string, expected []Entry) error {
+	var actual []Entry
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		actual = append(actual, Entry{Key: key.Clone(), Value: append([]byte(nil), value...)})
+	}
+	if err := s.compareEntries(name, expected, actual); err != nil {
+		return err
+	}
+	actual = actual[:0]
+	for key, value := iter.Last(); key != nil; key, value = iter.Prev() {
+		actual = append(actual, Entry{Key: key.Clone(), Value: append([]byte(nil), value...)})
+	}
+	reverse(actual)
+	if err := s.compareEntries(name+" (reverse)", expected, actual); err != nil {
+		return err
+	}
+	return iter.Error()
+}
+
+func (s Spec) compareEntries(name string, expected, actual []Entry) error {
+	if len(expected) != len(actual) {
+		return errors.Errorf("%s: expected %d %s, but found %d", s, len(expected), name, len(actual))
+	}
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		if base.InternalCompare(s.Comparer.Compare, e.Key, a.Key) != 0 ||
+			e.Key.Trailer != a.Key.Trailer || !bytes.Equal(e.Value, a.Value) {
+			return errors.Errorf("%s: %s %d: expected %s, but found %s", s, name, i, e, a)
+		}
+	}
+	return nil
+}
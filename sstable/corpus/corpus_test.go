@@ -0,0 +1,76 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package corpus
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, fs vfs.FS, path string) []byte {
+	f, err := fs.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return data
+}
+
+func TestCorpus(t *testing.T) {
+	mem := vfs.NewMem()
+	specs, err := Generate(mem, "corpus")
+	require.NoError(t, err)
+	require.Equal(t, 2*2*2*2*2*len(Comparers), len(specs))
+
+	names := make(map[string]bool)
+	for _, s := range specs {
+		t.Run(s.String(), func(t *testing.T) {
+			require.False(t, names[s.Filename()], "duplicate filename")
+			names[s.Filename()] = true
+
+			f, err := mem.Open(filepath.Join("corpus", s.Filename()))
+			require.NoError(t, err)
+			r, err := sstable.NewReader(f, s.ReaderOptions())
+			require.NoError(t, err)
+			defer func() { require.NoError(t, r.Close()) }()
+			require.NoError(t, s.Verify(r))
+		})
+	}
+}
+
+func TestCorpusDeterministic(t *testing.T) {
+	mem1, mem2 := vfs.NewMem(), vfs.NewMem()
+	specs, err := Generate(mem1, "")
+	require.NoError(t, err)
+	_, err = Generate(mem2, "")
+	require.NoError(t, err)
+	for _, s := range specs {
+		require.Equal(t, readFile(t, mem1, s.Filename()), readFile(t, mem2, s.Filename()), s.String())
+	}
+}
+
+func TestCorpusVerifyMismatch(t *testing.T) {
+	mem := vfs.NewMem()
+	s := Spec{Comparer: sstable.DefaultComparer, Compression: sstable.NoCompression}
+	f, err := mem.Create(s.Filename())
+	require.NoError(t, err)
+	require.NoError(t, s.Write(f))
+
+	// Verifying the sstable against a different spec must fail.
+	other := s
+	other.RangeDels = true
+	f, err = mem.Open(s.Filename())
+	require.NoError(t, err)
+	r, err := sstable.NewReader(f, s.ReaderOptions())
+	require.NoError(t, err)
+	defer r.Close()
+	require.NoError(t, s.Verify(r))
+	require.Error(t, other.Verify(r))
+}
@@ -80,6 +80,21 @@ func runBuildCmd(td *datadriven.TestData, writerOpts WriterOptions) (*WriterMeta
 			if err != nil {
 				return nil, nil, err
 			}
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			// The value of a range key is "<end> [<suffix>[=<value>]]".
+			var end, suffix, val []byte
+			fields := strings.Fields(string(value))
+			end = []byte(fields[0])
+			if len(fields) > 1 {
+				if k := strings.IndexByte(fields[1], '='); k >= 0 {
+					suffix, val = []byte(fields[1][:k]), []byte(fields[1][k+1:])
+				} else {
+					suffix = []byte(fields[1])
+				}
+			}
+			if err := w.AddRangeKey(key, end, suffix, val); err != nil {
+				return nil, nil, err
+			}
 		default:
 			if err := w.Add(key, value); err != nil {
 				return nil, nil, err
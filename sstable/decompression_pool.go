@@ -0,0 +1,77 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import "sync"
+
+// DecompressionPool is a bounded pool of goroutines which read and decompress
+// the data blocks prefetched by iterators (see ReaderOptions.PrefetchDataBlocks)
+// into the block cache, so that the decompression of the blocks of a scan
+// proceeds in parallel with the scan rather than on the scan's goroutine. A
+// DecompressionPool may be shared by multiple Readers, including the Readers
+// of multiple DBs.
+//
+// When all of the workers are busy and the queue of pending blocks is full,
+// further prefetches are skipped rather than waiting, and the blocks are read
+// by the iterators themselves when they reach them.
+type DecompressionPool struct {
+	mu     sync.Mutex
+	closed bool
+	work   chan func()
+	wg     sync.WaitGroup
+}
+
+// NewDecompressionPool returns a DecompressionPool with the specified number
+// of workers, which must be positive. The pool must be closed when it is no
+// longer needed.
+func NewDecompressionPool(workers int) *DecompressionPool {
+	if workers <= 0 {
+		panic("pebble: DecompressionPool requires at least one worker")
+	}
+	p := &DecompressionPool{
+		// Each worker has a couple of blocks queued so that it is not left idle
+		// between blocks.
+		work: make(chan func(), 2*workers),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for fn := range p.work {
+				fn()
+			}
+		}()
+	}
+	return p
+}
+
+// trySubmit queues fn for execution by a worker, returning false without
+// queueing it if the queue is full or the pool has been closed.
+func (p *DecompressionPool) trySubmit(fn func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.work <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the workers of the pool, after they have completed the blocks
+// already queued. Readers using the pool continue to function after it is
+// closed, but no longer prefetch data blocks.
+func (p *DecompressionPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.work)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -113,6 +113,17 @@ type ReaderOptions struct {
 	// written with {Batch,DB}.Merge. The MergerName is checked for consistency
 	// with the value stored in the sstable when it was written.
 	MergerName string
+
+	// PrefetchDataBlocks, if true, causes iterators performing a forward scan
+	// to read the next data block into the block cache in the background
+	// whenever they move to a new data block.
+	PrefetchDataBlocks bool
+
+	// DecompressionPool, if non-nil, reads and decompresses the data blocks
+	// prefetched by iterators using a bounded pool of workers, rather than a
+	// goroutine per prefetched block. Setting DecompressionPool enables
+	// prefetching regardless of PrefetchDataBlocks.
+	DecompressionPool *DecompressionPool
 }
 
 func (o ReaderOptions) ensureDefaults() ReaderOptions {
@@ -6,6 +6,7 @@ package sstable
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/cockroachdb/errors"
@@ -26,7 +28,7 @@ import (
 	"github.com/golang/snappy"
 )
 
-var errCorruptIndexEntry = errors.New("pebble/table: corrupt index entry")
+var errCorruptIndexEntry = base.CorruptionErrorf("pebble/table: corrupt index entry")
 
 const (
 	// Constants for dynamic readahead of data blocks. Note that the size values
@@ -66,6 +68,11 @@ type Iterator interface {
 	base.InternalIterator
 
 	SetCloseHook(fn func(i Iterator) error)
+
+	// SetContext sets the context of the block reads performed by the
+	// iterator. Once the context is done, reads of blocks which are not in the
+	// cache fail with the context's error.
+	SetContext(ctx context.Context)
 }
 
 // singleLevelIterator iterates over an entire table of data. To seek for a given
@@ -87,6 +94,11 @@ type singleLevelIterator struct {
 	dataBH     BlockHandle
 	err        error
 	closeHook  func(i Iterator) error
+	// ctx is the context of the iterator's block reads. See SetContext.
+	ctx context.Context
+	// prefetchDone is non-nil if a read of a data block into the block cache
+	// was started by maybePrefetchNext, and is closed once the read completes.
+	prefetchDone chan struct{}
 }
 
 // singleLevelIterator implements the base.InternalIterator interface.
@@ -152,6 +164,7 @@ func (i *singleLevelIterator) init(r *Reader, lower, upper []byte) error {
 	i.upper = upper
 	i.reader = r
 	i.cmp = r.Compare
+	i.ctx = context.Background()
 	err = i.index.initHandle(i.cmp, indexH, r.Properties.GlobalSeqNum)
 	if err != nil {
 		// blockIter.Close releases indexH and always returns a nil error
@@ -209,7 +222,7 @@ func (i *singleLevelIterator) loadBlock() bool {
 		i.err = errCorruptIndexEntry
 		return false
 	}
-	block, err := i.reader.readBlock(i.dataBH, nil /* transform */, &i.dataRS)
+	block, err := i.reader.readBlock(i.ctx, i.dataBH, nil /* transform */, &i.dataRS)
 	if err != nil {
 		i.err = err
 		return false
@@ -274,7 +287,7 @@ func (i *singleLevelIterator) SeekPrefixGE(prefix, key []byte) (*InternalKey, []
 	// Check prefix bloom filter.
 	if i.reader.tableFilter != nil {
 		var dataH cache.Handle
-		dataH, i.err = i.reader.readFilter()
+		dataH, i.err = i.reader.readFilter(i.ctx)
 		if i.err != nil {
 			i.data.invalidate()
 			return nil, nil
@@ -425,6 +438,7 @@ func (i *singleLevelIterator) skipForward() (*InternalKey, []byte) {
 			}
 			continue
 		}
+		i.maybePrefetchNext()
 		if key, val := i.data.First(); key != nil {
 			if i.blockUpper != nil && i.cmp(key.UserKey, i.blockUpper) >= 0 {
 				return nil, nil
@@ -435,6 +449,68 @@ func (i *singleLevelIterator) skipForward() (*InternalKey, []byte) {
 	return nil, nil
 }
 
+// maybePrefetchNext starts a background read of the data block following the
+// current one into the block cache, if ReaderOptions.PrefetchDataBlocks or
+// ReaderOptions.DecompressionPool is set and no earlier prefetch is still in
+// flight. It is called when a forward scan moves to a new data block, so that
+// the read and decompression of the next block overlap with the iteration
+// over the current one.
+func (i *singleLevelIterator) maybePrefetchNext() {
+	r := i.reader
+	pool := r.opts.DecompressionPool
+	if !r.opts.PrefetchDataBlocks && pool == nil {
+		return
+	}
+	if i.prefetchDone != nil {
+		select {
+		case <-i.prefetchDone:
+			i.prefetchDone = nil
+		default:
+			return
+		}
+	}
+	v := i.index.peekNextValue()
+	if v == nil {
+		return
+	}
+	bh, n := decodeBlockHandle(v)
+	if n == 0 || n != len(v) {
+		// The corruption is surfaced if the iterator loads the block.
+		return
+	}
+	if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
+		h.Release()
+		return
+	}
+	done := make(chan struct{})
+	ctx := i.ctx
+	prefetch := func() {
+		defer close(done)
+		// An error is ignored, as it is encountered again if the iterator
+		// loads the block.
+		if h, err := r.readBlock(ctx, bh, nil /* transform */, nil /* readaheadState */); err == nil {
+			h.Release()
+		}
+	}
+	if pool == nil {
+		go prefetch()
+	} else if !pool.trySubmit(prefetch) {
+		// The pool is saturated or closed.
+		return
+	}
+	i.prefetchDone = done
+}
+
+// waitForPrefetch waits for the completion of a prefetch started by
+// maybePrefetchNext. The iterator must wait before it is closed, as closing
+// the iterator may close the Reader.
+func (i *singleLevelIterator) waitForPrefetch() {
+	if i.prefetchDone != nil {
+		<-i.prefetchDone
+		i.prefetchDone = nil
+	}
+}
+
 func (i *singleLevelIterator) skipBackward() (*InternalKey, []byte) {
 	for {
 		if key, _ := i.index.Prev(); key == nil {
@@ -482,6 +558,11 @@ func (i *singleLevelIterator) SetCloseHook(fn func(i Iterator) error) {
 	i.closeHook = fn
 }
 
+// SetContext sets the context of the block reads performed by the iterator.
+func (i *singleLevelIterator) SetContext(ctx context.Context) {
+	i.ctx = ctx
+}
+
 func firstError(err0, err1 error) error {
 	if err0 != nil {
 		return err0
@@ -492,6 +573,7 @@ func firstError(err0, err1 error) error {
 // Close implements internalIterator.Close, as documented in the pebble
 // package.
 func (i *singleLevelIterator) Close() error {
+	i.waitForPrefetch()
 	var err error
 	if i.closeHook != nil {
 		err = firstError(err, i.closeHook(i))
@@ -613,10 +695,10 @@ func (i *twoLevelIterator) loadIndex() bool {
 	}
 	h, n := decodeBlockHandle(i.topLevelIndex.Value())
 	if n == 0 || n != len(i.topLevelIndex.Value()) {
-		i.err = errors.New("pebble/table: corrupt top level index entry")
+		i.err = base.CorruptionErrorf("pebble/table: corrupt top level index entry")
 		return false
 	}
-	indexBlock, err := i.reader.readBlock(h, nil /* transform */, nil /* readaheadState */)
+	indexBlock, err := i.reader.readBlock(i.ctx, h, nil /* transform */, nil /* readaheadState */)
 	if err != nil {
 		i.err = err
 		return false
@@ -638,6 +720,7 @@ func (i *twoLevelIterator) init(r *Reader, lower, upper []byte) error {
 	i.upper = upper
 	i.reader = r
 	i.cmp = r.Compare
+	i.ctx = context.Background()
 	err = i.topLevelIndex.initHandle(i.cmp, topLevelIndexH, r.Properties.GlobalSeqNum)
 	if err != nil {
 		// blockIter.Close releases topLevelIndexH and always returns a nil error
@@ -844,6 +927,7 @@ func (i *twoLevelIterator) skipBackward() (*InternalKey, []byte) {
 // Close implements internalIterator.Close, as documented in the pebble
 // package.
 func (i *twoLevelIterator) Close() error {
+	i.waitForPrefetch()
 	var err error
 	if i.closeHook != nil {
 		err = firstError(err, i.closeHook(i))
@@ -1097,7 +1181,8 @@ type ReaderOption interface {
 // Comparers is a map from comparer name to comparer. It is used for debugging
 // tools which may be used on multiple databases configured with different
 // comparers. Comparers implements the OpenOption interface and can be passed
-// as a parameter to NewReader.
+// as a parameter to NewReader. Comparers registered with RegisterComparer are
+// used if neither the ReaderOptions nor Comparers match an sstable.
 type Comparers map[string]*Comparer
 
 func (c Comparers) readerApply(r *Reader) {
@@ -1183,6 +1268,7 @@ type Reader struct {
 	filterBH          BlockHandle
 	rangeDelBH        BlockHandle
 	rangeDelTransform blockTransform
+	rangeKeyBH        BlockHandle
 	propertiesBH      BlockHandle
 	metaIndexBH       BlockHandle
 	footerBH          BlockHandle
@@ -1225,7 +1311,7 @@ func (r *Reader) get(key []byte) (value []byte, err error) {
 	}
 
 	if r.tableFilter != nil {
-		dataH, err := r.readFilter()
+		dataH, err := r.readFilter(context.Background())
 		if err != nil {
 			return nil, err
 		}
@@ -1333,25 +1419,58 @@ func (r *Reader) NewRangeDelIter() (base.InternalIterator, error) {
 	return i, nil
 }
 
+// NewRangeKeyIter returns an internal iterator for the contents of the
+// range-key block for the table. Returns nil if the table does not contain any
+// range keys. The values of the returned iterator are decoded using
+// rangekey.Decode.
+func (r *Reader) NewRangeKeyIter() (base.InternalIterator, error) {
+	if r.rangeKeyBH.Length == 0 {
+		return nil, nil
+	}
+	h, err := r.readBlock(context.Background(), r.rangeKeyBH, nil /* transform */, nil /* readaheadState */)
+	if err != nil {
+		return nil, err
+	}
+	i := &blockIter{}
+	if err := i.initHandle(r.Compare, h, r.Properties.GlobalSeqNum); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// RangeDelBlockSize returns the size in bytes of the table's range deletion
+// block, or 0 if the table does not contain range deletions.
+func (r *Reader) RangeDelBlockSize() uint64 {
+	return r.rangeDelBH.Length
+}
+
 func (r *Reader) readIndex() (cache.Handle, error) {
-	return r.readBlock(r.indexBH, nil /* transform */, nil /* readaheadState */)
+	return r.readBlock(context.Background(), r.indexBH, nil /* transform */, nil /* readaheadState */)
 }
 
-func (r *Reader) readFilter() (cache.Handle, error) {
-	return r.readBlock(r.filterBH, nil /* transform */, nil /* readaheadState */)
+func (r *Reader) readFilter(ctx context.Context) (cache.Handle, error) {
+	return r.readBlock(ctx, r.filterBH, nil /* transform */, nil /* readaheadState */)
 }
 
 func (r *Reader) readRangeDel() (cache.Handle, error) {
-	return r.readBlock(r.rangeDelBH, r.rangeDelTransform, nil /* readaheadState */)
+	return r.readBlock(context.Background(), r.rangeDelBH, r.rangeDelTransform, nil /* readaheadState */)
 }
 
 // readBlock reads and decompresses a block from disk into memory.
 func (r *Reader) readBlock(
-	bh BlockHandle, transform blockTransform, raState *readaheadState,
+	ctx context.Context, bh BlockHandle, transform blockTransform, raState *readaheadState,
 ) (cache.Handle, error) {
 	if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
 		return h, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return cache.Handle{}, err
+	}
+	var start time.Time
+	traced := base.Traced(ctx)
+	if traced {
+		start = time.Now()
+	}
 
 	if raState != nil {
 		if readaheadSize := raState.maybeReadahead(int64(bh.Offset), int64(bh.Length+blockTrailerLen)); readaheadSize > 0 {
@@ -1366,13 +1485,9 @@ func (r *Reader) readBlock(
 		return cache.Handle{}, err
 	}
 
-	checksum0 := binary.LittleEndian.Uint32(b[bh.Length+1:])
-	checksum1 := crc.New(b[:bh.Length+1]).Value()
-	if checksum0 != checksum1 {
+	if err := r.checkBlockChecksum(bh, b); err != nil {
 		r.opts.Cache.Free(v)
-		return cache.Handle{}, errors.Newf(
-			"pebble/table: invalid table %s (checksum mismatch at %d/%d)",
-			errors.Safe(r.fileNum), errors.Safe(bh.Offset), errors.Safe(bh.Length))
+		return cache.Handle{}, err
 	}
 
 	typ := b[bh.Length]
@@ -1405,7 +1520,7 @@ func (r *Reader) readBlock(
 		v, b = decoded, decodedBuf
 	default:
 		r.opts.Cache.Free(v)
-		return cache.Handle{}, errors.Errorf("pebble/table: unknown block compression: %d", errors.Safe(typ))
+		return cache.Handle{}, base.CorruptionErrorf("pebble/table: unknown block compression: %d", errors.Safe(typ))
 	}
 
 	if transform != nil {
@@ -1424,9 +1539,72 @@ func (r *Reader) readBlock(
 	}
 
 	h := r.opts.Cache.Set(r.cacheID, r.fileNum, bh.Offset, v)
+	if traced {
+		r.traceBlockRead(ctx, bh, start)
+	}
 	return h, nil
 }
 
+// traceBlockRead records a span for a read of the block bh from the file,
+// which started at start, as a child of the span of ctx.
+func (r *Reader) traceBlockRead(ctx context.Context, bh BlockHandle, start time.Time) {
+	_, span := base.StartSpan(ctx, "pebble.ReadBlock", start)
+	span.SetAttribute("pebble.file_num", int64(r.fileNum))
+	span.SetAttribute("pebble.block_offset", int64(bh.Offset))
+	span.SetAttribute("pebble.block_length", int64(bh.Length))
+	span.End(time.Now())
+}
+
+// checkBlockChecksum validates the checksum of the block bh, whose contents b
+// include the block trailer.
+func (r *Reader) checkBlockChecksum(bh BlockHandle, b []byte) error {
+	checksum0 := binary.LittleEndian.Uint32(b[bh.Length+1:])
+	checksum1 := crc.New(b[:bh.Length+1]).Value()
+	if checksum0 != checksum1 {
+		return base.CorruptionErrorf(
+			"pebble/table: invalid table %s (checksum mismatch at %d/%d)",
+			errors.Safe(r.fileNum), errors.Safe(bh.Offset), errors.Safe(bh.Length))
+	}
+	return nil
+}
+
+// ValidateBlockChecksums reads every block of the table from the file,
+// bypassing the block cache, and validates its checksum.
+func (r *Reader) ValidateBlockChecksums() error {
+	l, err := r.Layout()
+	if err != nil {
+		return err
+	}
+	blocks := make([]BlockHandle, 0, len(l.Data)+len(l.Index)+6)
+	blocks = append(blocks, l.Data...)
+	blocks = append(blocks, l.Index...)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	// Read the blocks in the order they appear in the file.
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Offset < blocks[j].Offset
+	})
+
+	var buf []byte
+	for _, bh := range blocks {
+		if bh == (BlockHandle{}) {
+			// The table does not contain the block.
+			continue
+		}
+		n := int(bh.Length + blockTrailerLen)
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		b := buf[:n]
+		if _, err := r.file.ReadAt(b, int64(bh.Offset)); err != nil {
+			return err
+		}
+		if err := r.checkBlockChecksum(bh, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *Reader) transformRangeDelV1(b []byte) ([]byte, error) {
 	// Convert v1 (RocksDB format) range-del blocks to v2 blocks on the fly. The
 	// v1 format range-del blocks have unfragmented and unsorted range
@@ -1470,7 +1648,7 @@ func (r *Reader) transformRangeDelV1(b []byte) ([]byte, error) {
 }
 
 func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
-	b, err := r.readBlock(metaindexBH, nil /* transform */, nil /* readaheadState */)
+	b, err := r.readBlock(context.Background(), metaindexBH, nil /* transform */, nil /* readaheadState */)
 	if err != nil {
 		return err
 	}
@@ -1500,7 +1678,7 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 	}
 
 	if bh, ok := meta[metaPropertiesName]; ok {
-		b, err = r.readBlock(bh, nil /* transform */, nil /* readaheadState */)
+		b, err = r.readBlock(context.Background(), bh, nil /* transform */, nil /* readaheadState */)
 		if err != nil {
 			return err
 		}
@@ -1521,6 +1699,10 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		}
 	}
 
+	if bh, ok := meta[metaRangeKeyName]; ok {
+		r.rangeKeyBH = bh
+	}
+
 	for name, fp := range r.opts.Filters {
 		types := []struct {
 			ftype  FilterType
@@ -1561,6 +1743,7 @@ func (r *Reader) Layout() (*Layout, error) {
 		Data:       make([]BlockHandle, 0, r.Properties.NumDataBlocks),
 		Filter:     r.filterBH,
 		RangeDel:   r.rangeDelBH,
+		RangeKey:   r.rangeKeyBH,
 		Properties: r.propertiesBH,
 		MetaIndex:  r.metaIndexBH,
 		Footer:     r.footerBH,
@@ -1592,7 +1775,7 @@ func (r *Reader) Layout() (*Layout, error) {
 			}
 			l.Index = append(l.Index, indexBH)
 
-			subIndex, err := r.readBlock(indexBH, nil /* transform */, nil /* readaheadState */)
+			subIndex, err := r.readBlock(context.Background(), indexBH, nil /* transform */, nil /* readaheadState */)
 			if err != nil {
 				return nil, err
 			}
@@ -1658,7 +1841,7 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 		if n == 0 || n != len(val) {
 			return 0, errCorruptIndexEntry
 		}
-		startIdxBlock, err := r.readBlock(startIdxBH, nil /* transform */, nil /* readaheadState */)
+		startIdxBlock, err := r.readBlock(context.Background(), startIdxBH, nil /* transform */, nil /* readaheadState */)
 		if err != nil {
 			return 0, err
 		}
@@ -1678,7 +1861,7 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 			if n == 0 || n != len(val) {
 				return 0, errCorruptIndexEntry
 			}
-			endIdxBlock, err := r.readBlock(endIdxBH, nil /* transform */, nil /* readaheadState */)
+			endIdxBlock, err := r.readBlock(context.Background(), endIdxBH, nil /* transform */, nil /* readaheadState */)
 			if err != nil {
 				return 0, err
 			}
@@ -1784,6 +1967,12 @@ func NewReader(f vfs.File, o ReaderOptions, extraOpts ...ReaderOption) (*Reader,
 		}
 	}
 
+	if r.Compare == nil && r.Properties.ComparerName != "" {
+		if comparer, ok := LookupComparer(r.Properties.ComparerName); ok {
+			r.Compare = comparer.Compare
+			r.Split = comparer.Split
+		}
+	}
 	if r.Compare == nil {
 		r.err = errors.Errorf("pebble/table: %d: unknown comparer %s",
 			errors.Safe(r.fileNum), errors.Safe(r.Properties.ComparerName))
@@ -1807,6 +1996,7 @@ type Layout struct {
 	TopIndex   BlockHandle
 	Filter     BlockHandle
 	RangeDel   BlockHandle
+	RangeKey   BlockHandle
 	Properties BlockHandle
 	MetaIndex  BlockHandle
 	Footer     BlockHandle
@@ -1838,6 +2028,9 @@ func (l *Layout) Describe(
 	if l.RangeDel.Length != 0 {
 		blocks = append(blocks, block{l.RangeDel, "range-del"})
 	}
+	if l.RangeKey.Length != 0 {
+		blocks = append(blocks, block{l.RangeKey, "range-key"})
+	}
 	if l.Properties.Length != 0 {
 		blocks = append(blocks, block{l.Properties, "properties"})
 	}
@@ -1867,7 +2060,7 @@ func (l *Layout) Describe(
 			continue
 		}
 
-		h, err := r.readBlock(b.BlockHandle, nil /* transform */, nil /* readaheadState */)
+		h, err := r.readBlock(context.Background(), b.BlockHandle, nil /* transform */, nil /* readaheadState */)
 		if err != nil {
 			fmt.Fprintf(w, "  [err: %s]\n", err)
 			continue
@@ -1898,7 +2091,7 @@ func (l *Layout) Describe(
 
 		var lastKey InternalKey
 		switch b.name {
-		case "data", "range-del":
+		case "data", "range-del", "range-key":
 			iter, _ := newBlockIter(r.Compare, h.Get())
 			for key, value := iter.First(); key != nil; key, value = iter.Next() {
 				ptr := unsafe.Pointer(uintptr(iter.ptr) + uintptr(iter.offset))
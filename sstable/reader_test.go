@@ -391,6 +391,53 @@ func TestReaderCheckComparerMerger(t *testing.T) {
 		})
 	}
 }
+func TestRegisterComparer(t *testing.T) {
+	const testTable = "test"
+
+	testComparer := &base.Comparer{
+		Name:      "test.registered-comparer",
+		Compare:   base.DefaultComparer.Compare,
+		Equal:     base.DefaultComparer.Equal,
+		Separator: base.DefaultComparer.Separator,
+		Successor: base.DefaultComparer.Successor,
+	}
+	mem := vfs.NewMem()
+	f0, err := mem.Create(testTable)
+	require.NoError(t, err)
+	w := NewWriter(f0, WriterOptions{Comparer: testComparer})
+	require.NoError(t, w.Set([]byte("test"), nil))
+	require.NoError(t, w.Close())
+
+	open := func() error {
+		f1, err := mem.Open(testTable)
+		require.NoError(t, err)
+		r, err := NewReader(f1, ReaderOptions{})
+		if err != nil {
+			return err
+		}
+		return r.Close()
+	}
+
+	_, ok := LookupComparer(testComparer.Name)
+	require.False(t, ok)
+	err = open()
+	require.Error(t, err)
+	require.True(t, strings.HasSuffix(err.Error(), "unknown comparer test.registered-comparer"), err.Error())
+
+	RegisterComparer(testComparer)
+	c, ok := LookupComparer(testComparer.Name)
+	require.True(t, ok)
+	require.Equal(t, testComparer, c)
+	require.NoError(t, open())
+
+	// Registering the same comparer again is allowed, while registering a
+	// different comparer under the same name is not.
+	RegisterComparer(testComparer)
+	other := *testComparer
+	require.Panics(t, func() { RegisterComparer(&other) })
+	require.Panics(t, func() { RegisterComparer(&base.Comparer{Compare: base.DefaultComparer.Compare}) })
+}
+
 func checkValidPrefix(prefix, key []byte) bool {
 	return prefix == nil || bytes.HasPrefix(key, prefix)
 }
@@ -527,6 +574,7 @@ func TestReaderChecksumErrors(t *testing.T) {
 				layout, err = r.Layout()
 				require.NoError(t, err)
 				require.EqualValues(t, len(layout.Data), 3)
+				require.NoError(t, r.ValidateBlockChecksums())
 				require.NoError(t, r.Close())
 			}
 
@@ -570,12 +618,89 @@ func TestReaderChecksumErrors(t *testing.T) {
 				require.Regexp(t, `checksum mismatch`, iter.Error())
 				require.Regexp(t, `checksum mismatch`, iter.Close())
 
+				require.Regexp(t, `checksum mismatch`, r.ValidateBlockChecksums())
 				require.NoError(t, r.Close())
 			}
 		})
 	}
 }
 
+func TestReaderPrefetchDataBlocks(t *testing.T) {
+	pool := NewDecompressionPool(2)
+	defer pool.Close()
+
+	for _, indexBlockSize := range []int{4096, 512} {
+		for _, usePool := range []bool{false, true} {
+			t.Run(fmt.Sprintf("indexBlockSize=%d,pool=%t", indexBlockSize, usePool), func(t *testing.T) {
+				mem := vfs.NewMem()
+				f0, err := mem.Create("test")
+				require.NoError(t, err)
+				w := NewWriter(f0, WriterOptions{
+					BlockSize:      256,
+					IndexBlockSize: indexBlockSize,
+					Compression:    SnappyCompression,
+				})
+				const numEntries = 1000
+				for i := 0; i < numEntries; i++ {
+					require.NoError(t, w.Set([]byte(fmt.Sprintf("%05d", i)), []byte("value")))
+				}
+				require.NoError(t, w.Close())
+
+				f1, err := mem.Open("test")
+				require.NoError(t, err)
+				c := cache.New(128 << 20)
+				defer c.Unref()
+				opts := ReaderOptions{Cache: c, PrefetchDataBlocks: !usePool}
+				if usePool {
+					opts.DecompressionPool = pool
+				}
+				r, err := NewReader(f1, opts)
+				require.NoError(t, err)
+				defer r.Close()
+				l, err := r.Layout()
+				require.NoError(t, err)
+				require.True(t, len(l.Data) > 3)
+
+				iter, err := r.NewIter(nil, nil)
+				require.NoError(t, err)
+				var sli *singleLevelIterator
+				switch i := iter.(type) {
+				case *singleLevelIterator:
+					sli = i
+				case *twoLevelIterator:
+					sli = &i.singleLevelIterator
+				}
+				require.Equal(t, indexBlockSize < 4096, r.Properties.IndexPartitions > 0)
+
+				// Moving to the second data block prefetches the third.
+				inCache := func(bh BlockHandle) bool {
+					h := c.Get(r.cacheID, r.fileNum, bh.Offset)
+					defer h.Release()
+					return h.Get() != nil
+				}
+				n := 0
+				for key, _ := iter.First(); key != nil && sli.dataBH != l.Data[1]; key, _ = iter.Next() {
+					n++
+				}
+				sli.waitForPrefetch()
+				require.True(t, inCache(l.Data[2]))
+				require.False(t, inCache(l.Data[3]))
+
+				// Prefetching does not affect the results of the scan.
+				for key, _ := iter.Next(); key != nil; key, _ = iter.Next() {
+					n++
+				}
+				require.Equal(t, numEntries-1, n)
+				require.NoError(t, iter.Close())
+			})
+		}
+	}
+
+	// Once the pool is closed, scans no longer prefetch.
+	pool.Close()
+	require.False(t, pool.trySubmit(func() {}))
+}
+
 func buildTestTable(
 	t *testing.T, numEntries uint64, blockSize, indexBlockSize int, compression Compression,
 ) *Reader {
@@ -65,6 +65,7 @@ import (
 	"io"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/vfs"
 )
 
@@ -157,6 +158,7 @@ const (
 	metaPropertiesName = "rocksdb.properties"
 	metaRangeDelName   = "rocksdb.range_del"
 	metaRangeDelV2Name = "rocksdb.range_del2"
+	metaRangeKeyName   = "pebble.range_key"
 
 	// Index Types.
 	// A space efficient index block that is optimized for binary-search-based
@@ -201,7 +203,7 @@ func readFooter(f vfs.File) (footer, error) {
 		return footer, errors.Wrap(err, "pebble/table: invalid table (could not stat file)")
 	}
 	if stat.Size() < minFooterLen {
-		return footer, errors.New("pebble/table: invalid table (file size is too small)")
+		return footer, base.CorruptionErrorf("pebble/table: invalid table (file size is too small)")
 	}
 
 	buf := make([]byte, maxFooterLen)
@@ -218,7 +220,7 @@ func readFooter(f vfs.File) (footer, error) {
 	switch string(buf[len(buf)-len(rocksDBMagic):]) {
 	case levelDBMagic:
 		if len(buf) < levelDBFooterLen {
-			return footer, errors.Errorf("pebble/table: invalid table (footer too short): %d", errors.Safe(len(buf)))
+			return footer, base.CorruptionErrorf("pebble/table: invalid table (footer too short): %d", errors.Safe(len(buf)))
 		}
 		footer.footerBH.Offset = uint64(off+int64(len(buf))) - levelDBFooterLen
 		buf = buf[len(buf)-levelDBFooterLen:]
@@ -228,7 +230,7 @@ func readFooter(f vfs.File) (footer, error) {
 
 	case rocksDBMagic:
 		if len(buf) < rocksDBFooterLen {
-			return footer, errors.Errorf("pebble/table: invalid table (footer too short): %d", errors.Safe(len(buf)))
+			return footer, base.CorruptionErrorf("pebble/table: invalid table (footer too short): %d", errors.Safe(len(buf)))
 		}
 		footer.footerBH.Offset = uint64(off+int64(len(buf))) - rocksDBFooterLen
 		buf = buf[len(buf)-rocksDBFooterLen:]
@@ -245,20 +247,20 @@ func readFooter(f vfs.File) (footer, error) {
 		buf = buf[1:]
 
 	default:
-		return footer, errors.New("pebble/table: invalid table (bad magic number)")
+		return footer, base.CorruptionErrorf("pebble/table: invalid table (bad magic number)")
 	}
 
 	{
 		var n int
 		footer.metaindexBH, n = decodeBlockHandle(buf)
 		if n == 0 {
-			return footer, errors.New("pebble/table: invalid table (bad metaindex block handle)")
+			return footer, base.CorruptionErrorf("pebble/table: invalid table (bad metaindex block handle)")
 		}
 		buf = buf[n:]
 
 		footer.indexBH, n = decodeBlockHandle(buf)
 		if n == 0 {
-			return footer, errors.New("pebble/table: invalid table (bad index block handle)")
+			return footer, base.CorruptionErrorf("pebble/table: invalid table (bad index block handle)")
 		}
 	}
 
@@ -7,6 +7,7 @@ package sstable
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -696,7 +697,7 @@ func TestMetaIndexEntriesSorted(t *testing.T) {
 	r, err := NewReader(f, ReaderOptions{})
 	require.NoError(t, err)
 
-	b, err := r.readBlock(r.metaIndexBH, nil /* transform */, nil /* attrs */)
+	b, err := r.readBlock(context.Background(), r.metaIndexBH, nil /* transform */, nil /* attrs */)
 	require.NoError(t, err)
 	defer b.Release()
 
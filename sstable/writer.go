@@ -18,16 +18,22 @@ import (
 	"github.com/cockroachdb/pebble/internal/crc"
 	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/internal/rangedel"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/golang/snappy"
 )
 
 // WriterMetadata holds info about a finished sstable.
 type WriterMetadata struct {
-	Size                uint64
-	SmallestPoint       InternalKey
-	SmallestRange       InternalKey
-	LargestPoint        InternalKey
-	LargestRange        InternalKey
+	Size          uint64
+	SmallestPoint InternalKey
+	SmallestRange InternalKey
+	LargestPoint  InternalKey
+	LargestRange  InternalKey
+	// SmallestRangeKey and LargestRangeKey are the bounds of the range keys.
+	// LargestRangeKey is a range deletion sentinel key for the largest end key,
+	// as the end keys of range keys are exclusive.
+	SmallestRangeKey    InternalKey
+	LargestRangeKey     InternalKey
 	SmallestSeqNum      uint64
 	LargestSeqNum       uint64
 	Properties          Properties
@@ -43,32 +49,34 @@ func (m *WriterMetadata) updateSeqNum(seqNum uint64) {
 	}
 }
 
-// Smallest returns the smaller of SmallestPoint and SmallestRange.
+// Smallest returns the smallest of SmallestPoint, SmallestRange and
+// SmallestRangeKey.
 func (m *WriterMetadata) Smallest(cmp Compare) InternalKey {
-	if m.SmallestPoint.UserKey == nil {
-		return m.SmallestRange
-	}
-	if m.SmallestRange.UserKey == nil {
-		return m.SmallestPoint
-	}
-	if base.InternalCompare(cmp, m.SmallestPoint, m.SmallestRange) < 0 {
-		return m.SmallestPoint
+	smallest := m.SmallestPoint
+	for _, k := range [2]InternalKey{m.SmallestRange, m.SmallestRangeKey} {
+		if k.UserKey == nil {
+			continue
+		}
+		if smallest.UserKey == nil || base.InternalCompare(cmp, k, smallest) < 0 {
+			smallest = k
+		}
 	}
-	return m.SmallestRange
+	return smallest
 }
 
-// Largest returns the larget of LargestPoint and LargestRange.
+// Largest returns the largest of LargestPoint, LargestRange and
+// LargestRangeKey.
 func (m *WriterMetadata) Largest(cmp Compare) InternalKey {
-	if m.LargestPoint.UserKey == nil {
-		return m.LargestRange
-	}
-	if m.LargestRange.UserKey == nil {
-		return m.LargestPoint
-	}
-	if base.InternalCompare(cmp, m.LargestPoint, m.LargestRange) > 0 {
-		return m.LargestPoint
+	largest := m.LargestPoint
+	for _, k := range [2]InternalKey{m.LargestRange, m.LargestRangeKey} {
+		if k.UserKey == nil {
+			continue
+		}
+		if largest.UserKey == nil || base.InternalCompare(cmp, k, largest) > 0 {
+			largest = k
+		}
 	}
-	return m.LargestRange
+	return largest
 }
 
 type flusher interface {
@@ -132,6 +140,7 @@ type Writer struct {
 	block            blockWriter
 	indexBlock       blockWriter
 	rangeDelBlock    blockWriter
+	rangeKeyBlock    blockWriter
 	props            Properties
 	propCollectors   []TablePropertyCollector
 	// compressedBuf is the destination buffer for snappy compression. It is
@@ -187,6 +196,81 @@ func (w *Writer) DeleteRange(start, end []byte) error {
 	return w.addTombstone(base.MakeInternalKey(start, 0, InternalKeyKindRangeDelete), end)
 }
 
+// RangeKeySet sets the range key with the specified suffix to value over the
+// range [start,end). The sequence number is set to 0. Intended for use to
+// externally construct an sstable before ingestion into a DB. Range keys must
+// be added in order of their start keys.
+func (w *Writer) RangeKeySet(start, end, suffix, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.AddRangeKey(base.MakeInternalKey(start, 0, InternalKeyKindRangeKeySet), end, suffix, value)
+}
+
+// RangeKeyUnset removes the range key with the specified suffix over the
+// range [start,end). The sequence number is set to 0. Intended for use to
+// externally construct an sstable before ingestion into a DB. Range keys must
+// be added in order of their start keys.
+func (w *Writer) RangeKeyUnset(start, end, suffix []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.AddRangeKey(base.MakeInternalKey(start, 0, InternalKeyKindRangeKeyUnset), end, suffix, nil)
+}
+
+// RangeKeyDelete removes all range keys over the range [start,end). The
+// sequence number is set to 0. Intended for use to externally construct an
+// sstable before ingestion into a DB. Range keys must be added in order of
+// their start keys.
+func (w *Writer) RangeKeyDelete(start, end []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.AddRangeKey(base.MakeInternalKey(start, 0, InternalKeyKindRangeKeyDelete), end, nil, nil)
+}
+
+// AddRangeKey adds a range key to the table being written. The kind of key
+// must be one of the range key kinds. Range keys are stored in their own
+// block, separately from point keys and range deletions, and must be added
+// in increasing order of their start keys (see rangekey.Key).
+func (w *Writer) AddRangeKey(key InternalKey, end, suffix, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !rangekey.IsRangeKey(key.Kind()) {
+		w.err = errors.Errorf("pebble: invalid range key kind: %s", key.Kind())
+		return w.err
+	}
+	if w.compare(key.UserKey, end) >= 0 {
+		w.err = errors.Errorf("pebble: range key start must be less than end: %s >= %s",
+			w.formatKey(key.UserKey), w.formatKey(end))
+		return w.err
+	}
+	if !w.disableKeyOrderChecks && w.rangeKeyBlock.nEntries > 0 {
+		prevKey := base.DecodeInternalKey(w.rangeKeyBlock.curKey)
+		if base.InternalCompare(w.compare, prevKey, key) > 0 {
+			w.err = errors.Errorf("pebble: keys must be added in order: %s, %s",
+				prevKey.Pretty(w.formatKey), key.Pretty(w.formatKey))
+			return w.err
+		}
+	}
+
+	w.meta.updateSeqNum(key.SeqNum())
+	if w.props.NumRangeKeys == 0 {
+		w.meta.SmallestRangeKey = key.Clone()
+	}
+	if largest := base.MakeRangeDeleteSentinelKey(end); w.meta.LargestRangeKey.UserKey == nil ||
+		base.InternalCompare(w.compare, w.meta.LargestRangeKey, largest) < 0 {
+		w.meta.LargestRangeKey = largest.Clone()
+	}
+	w.props.NumRangeKeys++
+	encoded := rangekey.EncodeValue(end, suffix, value)
+	w.props.RawKeySize += uint64(key.Size())
+	w.props.RawValueSize += uint64(len(encoded))
+	w.rangeKeyBlock.add(key, encoded)
+	return nil
+}
+
 // Merge adds an action to the DB that merges the value at key with the new
 // value. The details of the merge are dependent upon the configured merge
 // operator. The sequence number is set to 0. Intended for use to externally
@@ -581,6 +665,16 @@ func (w *Writer) Close() (err error) {
 		}
 	}
 
+	// Write the range-key block.
+	var rangeKeyBH BlockHandle
+	if w.props.NumRangeKeys > 0 {
+		rangeKeyBH, err = w.writeBlock(w.rangeKeyBlock.finish(), NoCompression)
+		if err != nil {
+			w.err = err
+			return w.err
+		}
+	}
+
 	{
 		for i := range w.propCollectors {
 			if nc, ok := w.propCollectors[i].(NeedCompacter); ok {
@@ -613,6 +707,12 @@ func (w *Writer) Close() (err error) {
 			w.err = err
 			return w.err
 		}
+		// The range key block handle sorts before the properties block handle
+		// in the metaindex block.
+		if w.props.NumRangeKeys > 0 {
+			n := encodeBlockHandle(w.tmp[:], rangeKeyBH)
+			metaindex.add(InternalKey{UserKey: []byte(metaRangeKeyName)}, w.tmp[:n])
+		}
 		n := encodeBlockHandle(w.tmp[:], bh)
 		metaindex.add(InternalKey{UserKey: []byte(metaPropertiesName)}, w.tmp[:n])
 	}
@@ -708,6 +808,18 @@ func (i internalTableOpt) writerApply(w *Writer) {
 	w.props.ExternalFormatVersion = 0
 }
 
+// GlobalSeqNum is a WriterOption that records a global sequence number in the
+// properties of an sstable. The global sequence number is applied by readers
+// to every entry in the sstable, each of which must have been added with a
+// sequence number of 0. Pebble assigns global sequence numbers to ingested
+// sstables in memory, so this option is only needed to construct sstables
+// mimicking those ingested by RocksDB.
+type GlobalSeqNum uint64
+
+func (n GlobalSeqNum) writerApply(w *Writer) {
+	w.props.GlobalSeqNum = uint64(n)
+}
+
 // NewWriter returns a new table writer for the file. Closing the writer will
 // close the file.
 func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *Writer {
@@ -738,6 +850,9 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		rangeDelBlock: blockWriter{
 			restartInterval: 1,
 		},
+		rangeKeyBlock: blockWriter{
+			restartInterval: 1,
+		},
 		topLevelIndexBlock: blockWriter{
 			restartInterval: 1,
 		},
@@ -13,6 +13,7 @@ import (
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -38,10 +39,14 @@ func TestWriter(t *testing.T) {
 			if err != nil {
 				return err.Error()
 			}
-			return fmt.Sprintf("point:   [%s,%s]\nrange:   [%s,%s]\nseqnums: [%d,%d]\n",
+			s := fmt.Sprintf("point:   [%s,%s]\nrange:   [%s,%s]\nseqnums: [%d,%d]\n",
 				meta.SmallestPoint, meta.LargestPoint,
 				meta.SmallestRange, meta.LargestRange,
 				meta.SmallestSeqNum, meta.LargestSeqNum)
+			if meta.SmallestRangeKey.UserKey != nil {
+				s += fmt.Sprintf("rangekey: [%s,%s]\n", meta.SmallestRangeKey, meta.LargestRangeKey)
+			}
+			return s
 
 		case "build-raw":
 			if r != nil {
@@ -89,6 +94,26 @@ func TestWriter(t *testing.T) {
 			}
 			return buf.String()
 
+		case "scan-range-key":
+			iter, err := r.NewRangeKeyIter()
+			if err != nil {
+				return err.Error()
+			}
+			if iter == nil {
+				return ""
+			}
+			defer iter.Close()
+
+			var buf bytes.Buffer
+			for key, val := iter.First(); key != nil; key, val = iter.Next() {
+				k, err := rangekey.Decode(*key, val)
+				if err != nil {
+					return err.Error()
+				}
+				fmt.Fprintf(&buf, "%s\n", k)
+			}
+			return buf.String()
+
 		case "layout":
 			l, err := r.Layout()
 			if err != nil {
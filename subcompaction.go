@@ -0,0 +1,301 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// subcompactionBoundaries returns the user keys at which the compaction is
+// split into subcompactions (see Options.Experimental.MaxSubcompactions), or
+// nil if the compaction should not be split. The boundaries are chosen from
+// the user key bounds of the input sstables such that each subcompaction
+// processes a similar number of bytes. The boundaries depend only on the
+// input sstables, so a given compaction is always split identically.
+func (c *compaction) subcompactionBoundaries(maxSubcompactions int) [][]byte {
+	if maxSubcompactions <= 1 || len(c.flushing) != 0 || c.outputLevel.level == 0 {
+		return nil
+	}
+
+	var files []*fileMetadata
+	for _, cl := range c.inputs {
+		files = append(files, cl.files...)
+	}
+	total := totalSize(files)
+	// Only split the compaction if each subcompaction is expected to write at
+	// least one full-sized output sstable.
+	n := total / c.maxOutputFileSize
+	if n > uint64(maxSubcompactions) {
+		n = uint64(maxSubcompactions)
+	}
+	if n <= 1 {
+		return nil
+	}
+
+	// The candidate boundaries are the smallest and largest user keys of the
+	// input sstables, other than the smallest and largest keys of the
+	// compaction.
+	keys := make([][]byte, 0, 2*len(files))
+	for _, f := range files {
+		for _, k := range [2][]byte{f.Smallest.UserKey, f.Largest.UserKey} {
+			if c.cmp(k, c.smallest.UserKey) > 0 && c.cmp(k, c.largest.UserKey) < 0 {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.cmp(keys[i], keys[j]) < 0
+	})
+	j := 0
+	for i := 1; i < len(keys); i++ {
+		if c.cmp(keys[i], keys[j]) != 0 {
+			j++
+			keys[j] = keys[i]
+		}
+	}
+	keys = keys[:j+1]
+
+	// The candidate boundaries divide the key space into len(keys)+1
+	// intervals, where interval i ends at keys[i]. Estimate the number of bytes
+	// in each interval by distributing the size of each sstable evenly across
+	// the intervals it overlaps.
+	sizes := make([]uint64, len(keys)+1)
+	for _, f := range files {
+		start := sort.Search(len(keys), func(i int) bool {
+			return c.cmp(keys[i], f.Smallest.UserKey) > 0
+		})
+		end := sort.Search(len(keys), func(i int) bool {
+			return c.cmp(keys[i], f.Largest.UserKey) > 0
+		})
+		size := f.Size / uint64(end-start+1)
+		for i := start; i <= end; i++ {
+			sizes[i] += size
+		}
+	}
+
+	target := total / n
+	var bounds [][]byte
+	var size uint64
+	for i := 0; i < len(keys) && uint64(len(bounds)) < n-1; i++ {
+		size += sizes[i]
+		if size >= target {
+			bounds = append(bounds, keys[i])
+			size = 0
+		}
+	}
+	return bounds
+}
+
+// newSubcompaction returns a subcompaction of c which processes the user keys
+// in [lower, upper). The subcompaction's inputs are the input sstables of c
+// which overlap the bounds.
+func (c *compaction) newSubcompaction(lower, upper []byte) *compaction {
+	sc := &compaction{
+		cmp:                          c.cmp,
+		formatKey:                    c.formatKey,
+		logger:                       c.logger,
+		version:                      c.version,
+		score:                        c.score,
+		inputs:                       make([]compactionLevel, len(c.inputs)),
+		maxOutputFileSize:            c.maxOutputFileSize,
+		maxOverlapBytes:              c.maxOverlapBytes,
+		maxExpandedBytes:             c.maxExpandedBytes,
+		disableRangeTombstoneElision: c.disableRangeTombstoneElision,
+		atomicBytesIterated:          new(uint64),
+		atomicCancel:                 c.atomicCancel,
+		lower:                        lower,
+		upper:                        upper,
+		grandparents:                 c.grandparents,
+		inuseKeyRanges:               c.inuseKeyRanges,
+	}
+	var files []*fileMetadata
+	for i, cl := range c.inputs {
+		sc.inputs[i].level = cl.level
+		for _, f := range cl.files {
+			if upper != nil && c.cmp(f.Smallest.UserKey, upper) >= 0 {
+				continue
+			}
+			if lower != nil && c.cmp(f.Largest.UserKey, lower) < 0 {
+				continue
+			}
+			sc.inputs[i].files = append(sc.inputs[i].files, f)
+			files = append(files, f)
+		}
+	}
+	sc.startLevel = &sc.inputs[0]
+	sc.outputLevel = &sc.inputs[len(sc.inputs)-1]
+	sc.smallest, sc.largest = manifest.KeyRange(c.cmp, files, nil)
+	return sc
+}
+
+// runSubcompactions splits the compaction c at the specified user key
+// boundaries into subcompactions which are run in parallel. The outputs of
+// the subcompactions are disjoint and are combined, in key order, into a
+// single version edit. If any subcompaction fails, the outputs of all of the
+// subcompactions are removed. d.mu must not be held when calling this.
+func (d *DB) runSubcompactions(
+	jobID int, c *compaction, bounds [][]byte, pacer pacer, snapshots []uint64,
+) (*versionEdit, []FileNum, error) {
+	subcompactions := make([]*compaction, len(bounds)+1)
+	for i := range subcompactions {
+		var lower, upper []byte
+		if i > 0 {
+			lower = bounds[i-1]
+		}
+		if i < len(bounds) {
+			upper = bounds[i]
+		}
+		subcompactions[i] = c.newSubcompaction(lower, upper)
+	}
+
+	type result struct {
+		ve             *versionEdit
+		pendingOutputs []FileNum
+		err            error
+	}
+	results := make([]result, len(subcompactions))
+	sp := newSubcompactionPacer(pacer, c.atomicBytesIterated, len(subcompactions))
+	var wg sync.WaitGroup
+	for i := range subcompactions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &results[i]
+			r.ve, r.pendingOutputs, r.err = d.writeCompactionOutputs(
+				jobID, subcompactions[i], sp.forSubcompaction(i), snapshots)
+		}(i)
+	}
+	wg.Wait()
+
+	ve := &versionEdit{
+		DeletedFiles: map[deletedFileEntry]bool{},
+	}
+	metrics := &LevelMetrics{
+		BytesIn:   totalSize(c.startLevel.files),
+		BytesRead: totalSize(c.outputLevel.files),
+	}
+	metrics.BytesRead += metrics.BytesIn
+	var pendingOutputs []FileNum
+	var err error
+	for i := range results {
+		r := &results[i]
+		if r.err != nil {
+			err = firstError(err, r.err)
+			continue
+		}
+		ve.NewFiles = append(ve.NewFiles, r.ve.NewFiles...)
+		pendingOutputs = append(pendingOutputs, r.pendingOutputs...)
+		m := subcompactions[i].metrics[c.outputLevel.level]
+		metrics.TablesCompacted += m.TablesCompacted
+		metrics.BytesCompacted += m.BytesCompacted
+	}
+	if err != nil {
+		// The outputs of the failed subcompactions have already been removed.
+		for _, e := range ve.NewFiles {
+			d.opts.FS.Remove(base.MakeFilename(d.opts.FS, d.dirname, fileTypeTable, e.Meta.FileNum))
+		}
+		return nil, nil, err
+	}
+	c.metrics = map[int]*LevelMetrics{
+		c.outputLevel.level: metrics,
+	}
+	return ve, pendingOutputs, nil
+}
+
+// subcompactionInputIter restricts the input iterator of a subcompaction to
+// the user keys in [lower, upper). Range tombstones are truncated to the
+// bounds before being merged with the point keys (see newInputIter), so the
+// keys preceding lower may simply be skipped. Only First and Next are
+// supported, as for the compaction iterators of the underlying sstables.
+type subcompactionInputIter struct {
+	internalIterator
+	cmp          Compare
+	lower, upper []byte
+}
+
+func (i *subcompactionInputIter) First() (*InternalKey, []byte) {
+	key, val := i.internalIterator.First()
+	for key != nil && i.lower != nil && i.cmp(key.UserKey, i.lower) < 0 {
+		key, val = i.internalIterator.Next()
+	}
+	return i.checkUpperBound(key, val)
+}
+
+func (i *subcompactionInputIter) Next() (*InternalKey, []byte) {
+	return i.checkUpperBound(i.internalIterator.Next())
+}
+
+func (i *subcompactionInputIter) checkUpperBound(
+	key *InternalKey, val []byte,
+) (*InternalKey, []byte) {
+	if key != nil && i.upper != nil && i.cmp(key.UserKey, i.upper) >= 0 {
+		return nil, nil
+	}
+	return key, val
+}
+
+// subcompactionPacer shares a pacer between the subcompactions of a
+// compaction. The pacer is throttled on, and the compaction's progress is
+// reported as, the combined number of bytes iterated by the subcompactions.
+// The sleeps of the pacer are deferred (see sleepDeferrer) until mu has been
+// released, so that a subcompaction being throttled does not block the
+// others from reporting their progress.
+type subcompactionPacer struct {
+	mu                  sync.Mutex
+	pacer               pacer
+	atomicBytesIterated *uint64
+	bytesIterated       []uint64
+	// until is the time until which the pacer requires the subcompaction
+	// calling it to sleep. Protected by mu.
+	until time.Time
+}
+
+func newSubcompactionPacer(
+	pacer pacer, atomicBytesIterated *uint64, subcompactions int,
+) *subcompactionPacer {
+	p := &subcompactionPacer{
+		pacer:               pacer,
+		atomicBytesIterated: atomicBytesIterated,
+		bytesIterated:       make([]uint64, subcompactions),
+	}
+	if d, ok := pacer.(sleepDeferrer); ok {
+		d.deferSleeps(&p.until)
+	}
+	return p
+}
+
+func (p *subcompactionPacer) forSubcompaction(i int) pacer {
+	return subcompactionPacerFunc(func(bytesIterated uint64) error {
+		p.mu.Lock()
+		p.bytesIterated[i] = bytesIterated
+		var total uint64
+		for _, b := range p.bytesIterated {
+			total += b
+		}
+		atomic.StoreUint64(p.atomicBytesIterated, total)
+		err := p.pacer.maybeThrottle(total)
+		until := p.until
+		p.mu.Unlock()
+		if d := time.Until(until); d > 0 {
+			time.Sleep(d)
+		}
+		return err
+	})
+}
+
+type subcompactionPacerFunc func(bytesIterated uint64) error
+
+func (f subcompactionPacerFunc) maybeThrottle(bytesIterated uint64) error {
+	return f(bytesIterated)
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/record"
+)
+
+// CommittedBatch is a batch committed to a DB, as delivered to a
+// Subscription.
+type CommittedBatch struct {
+	// SeqNum is the sequence number of the first operation of the batch. The
+	// operations of the batch are assigned consecutive sequence numbers.
+	SeqNum uint64
+	// Count is the number of operations in the batch.
+	Count uint32
+	// Repr is the representation of the batch (see Batch.Repr). It is shared
+	// by all of the subscriptions of the DB, and must not be modified.
+	Repr []byte
+}
+
+// Reader returns a BatchReader over the operations of the batch, which
+// provides the kind, key and value of each operation in sequence number
+// order.
+func (c *CommittedBatch) Reader() BatchReader {
+	return MakeBatchReader(c.Repr)
+}
+
+// Subscription delivers the batches committed to a DB, in commit order, to a
+// consumer such as a change data capture pipeline. See DB.Subscribe.
+type Subscription struct {
+	d  *DB
+	ch chan CommittedBatch
+	// startSeqNum is the sequence number of the first batch delivered to the
+	// subscription.
+	startSeqNum uint64
+	closed      chan struct{}
+	once        sync.Once
+}
+
+// C returns the channel on which the committed batches are delivered. The
+// channel is closed once the subscription or the DB is closed.
+func (s *Subscription) C() <-chan CommittedBatch {
+	return s.ch
+}
+
+// Close unregisters the subscription and closes its channel. Commits blocked
+// waiting for the subscription to accept a batch proceed, and the batches
+// committed after the call to Close are not delivered. Close may be called
+// concurrently with commits, and more than once.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+		s.d.subscriptions.remove(s)
+		close(s.ch)
+	})
+}
+
+// subscriptions holds the subscriptions of a DB.
+type subscriptions struct {
+	// n is the number of subscriptions, allowing commits to avoid acquiring mu
+	// if there are none.
+	n  int32
+	mu sync.RWMutex
+	m  map[*Subscription]struct{}
+	// pending holds the batches written while the DB had subscriptions, in
+	// sequence number order, until they are delivered by the goroutine
+	// started by the first call to Subscribe, which closes stopped when it
+	// exits. Protected by commitPipeline.mu.
+	pending chan *pendingBatch
+	stopped chan struct{}
+}
+
+// pendingBatch is a batch awaiting delivery to the subscriptions.
+type pendingBatch struct {
+	batch CommittedBatch
+	// failed is set if the batch could not be written to the WAL.
+	failed bool
+	// synced is done once the batch has been synced to the WAL, and err is set
+	// if the sync failed.
+	synced sync.WaitGroup
+	err    error
+	// syncWG and syncErr are passed the result of the sync once the batch has
+	// been delivered, which completes the commit.
+	syncWG  *sync.WaitGroup
+	syncErr *error
+}
+
+// Subscribe registers a subscription to the batches committed to the DB after
+// Subscribe returns. Each committed batch is delivered on the subscription's
+// channel with its sequence number and a copy of its contents, in the order in
+// which the batches were committed, which is also sequence number order.
+//
+// A batch is delivered once it has been synced to the WAL, and before Commit
+// returns. While the DB has subscriptions, every batch is synced to the WAL
+// whether or not WriteOptions.Sync is set, and its commit waits for the sync.
+// A batch which could not be written or synced to the WAL is not delivered,
+// and its commit returns the error. Sstables added by Ingest are not
+// delivered, as they are not batches.
+//
+// The channel buffers up to bufferSize batches. Once the buffer is full,
+// commits block until the consumer receives a batch or closes the
+// subscription, so a slow consumer applies backpressure to all writes to the
+// DB. In particular, the consumer must not write to the DB while the buffer is
+// full, or it will deadlock.
+func (d *DB) Subscribe(bufferSize int) *Subscription {
+	if atomic.LoadInt32(&d.closed) != 0 {
+		panic(ErrClosed)
+	}
+	s := &Subscription{
+		d:      d,
+		ch:     make(chan CommittedBatch, bufferSize),
+		closed: make(chan struct{}),
+	}
+	// Holding commitPipeline.mu orders the subscription with respect to the
+	// batches being written.
+	d.commit.mu.Lock()
+	defer d.commit.mu.Unlock()
+	s.startSeqNum = atomic.LoadUint64(&d.mu.versions.logSeqNum)
+	subs := &d.subscriptions
+	if subs.pending == nil {
+		subs.pending = make(chan *pendingBatch, record.SyncConcurrency)
+		subs.stopped = make(chan struct{})
+		go subs.deliver(subs.pending, subs.stopped)
+	}
+	subs.mu.Lock()
+	if subs.m == nil {
+		subs.m = make(map[*Subscription]struct{})
+	}
+	subs.m[s] = struct{}{}
+	atomic.StoreInt32(&subs.n, int32(len(subs.m)))
+	subs.mu.Unlock()
+	return s
+}
+
+func (subs *subscriptions) remove(s *Subscription) {
+	subs.mu.Lock()
+	delete(subs.m, s)
+	atomic.StoreInt32(&subs.n, int32(len(subs.m)))
+	subs.mu.Unlock()
+}
+
+// commitWrite writes a batch to the WAL as DB.commitWrite does, and if the
+// DB has subscriptions, queues the batch to be delivered to them once it has
+// been synced. The commit is completed by the delivery goroutine once the
+// batch has been delivered, which applies backpressure to the commits. It is
+// called with commitPipeline.mu held, which orders the batches by sequence
+// number.
+func (subs *subscriptions) commitWrite(
+	d *DB, b *Batch, syncWG *sync.WaitGroup, syncErr *error,
+) (*memTable, error) {
+	if atomic.LoadInt32(&subs.n) == 0 {
+		return d.commitWrite(b, syncWG, syncErr)
+	}
+	if syncWG == nil {
+		// The batch is synced even though the commit is not synchronous, and
+		// the commit waits for the sync like a synchronous commit, which
+		// bounds the number of syncs in flight by the commit concurrency.
+		b.commit.Add(1)
+		syncWG, syncErr = &b.commit, &b.commitErr
+	}
+	p := &pendingBatch{
+		batch: CommittedBatch{
+			SeqNum: b.SeqNum(),
+			Count:  b.Count(),
+			Repr:   append([]byte(nil), b.Repr()...),
+		},
+		syncWG:  syncWG,
+		syncErr: syncErr,
+	}
+	if !d.opts.DisableWAL {
+		p.synced.Add(1)
+	}
+	mem, err := d.commitWrite(b, &p.synced, &p.err)
+	if err != nil {
+		// The batch was not committed.
+		return nil, err
+	}
+	// A batch which could not be written to the WAL is applied nonetheless
+	// (see walWriteFailed), but not delivered.
+	p.failed = b.commitErr != nil
+	subs.pending <- p
+	return mem, nil
+}
+
+// deliver delivers the pending batches to the subscriptions once they have
+// been synced, until pending is closed.
+func (subs *subscriptions) deliver(pending <-chan *pendingBatch, stopped chan struct{}) {
+	defer close(stopped)
+	for p := range pending {
+		p.synced.Wait()
+		if !p.failed && p.err == nil {
+			subs.publish(p.batch)
+		}
+		if p.err != nil {
+			*p.syncErr = p.err
+		}
+		p.syncWG.Done()
+	}
+}
+
+// publish delivers a batch to the subscriptions which were registered before
+// it was written.
+func (subs *subscriptions) publish(c CommittedBatch) {
+	// A subscription cannot close its channel while mu is read-locked, but
+	// closing the subscription unblocks the send.
+	subs.mu.RLock()
+	defer subs.mu.RUnlock()
+	for s := range subs.m {
+		if c.SeqNum < s.startSeqNum {
+			continue
+		}
+		select {
+		case s.ch <- c:
+		case <-s.closed:
+		}
+	}
+}
+
+// closeAll closes the subscriptions when the DB is closed, and waits for the
+// delivery goroutine to exit.
+func (subs *subscriptions) closeAll() {
+	subs.mu.RLock()
+	all := make([]*Subscription, 0, len(subs.m))
+	for s := range subs.m {
+		all = append(all, s)
+	}
+	subs.mu.RUnlock()
+	for _, s := range all {
+		s.Close()
+	}
+	if subs.pending != nil {
+		close(subs.pending)
+		<-subs.stopped
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func formatCommittedBatch(c CommittedBatch) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "#%d:", c.SeqNum)
+	r := c.Reader()
+	for {
+		kind, ukey, value, ok := r.Next()
+		if !ok {
+			break
+		}
+		fmt.Fprintf(&buf, " %s:%s", kind, ukey)
+		if kind != InternalKeyKindDelete {
+			fmt.Fprintf(&buf, "=%s", value)
+		}
+	}
+	return buf.String()
+}
+
+func TestSubscribe(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+
+	// Batches committed before the subscription are not delivered.
+	require.NoError(t, d.Set([]byte("a"), []byte("0"), nil))
+	s1 := d.Subscribe(10)
+	s2 := d.Subscribe(10)
+
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Merge([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+
+	expected := []string{
+		"#2: SET:a=1 MERGE:b=2",
+		"#4: DEL:a",
+	}
+	for _, s := range []*Subscription{s1, s2} {
+		for _, e := range expected {
+			require.Equal(t, e, formatCommittedBatch(<-s.C()))
+		}
+	}
+
+	// Closing a subscription closes its channel, and stops the delivery of
+	// batches to it.
+	s1.Close()
+	s1.Close()
+	_, ok := <-s1.C()
+	require.False(t, ok)
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.Equal(t, "#5: SET:c=3", formatCommittedBatch(<-s2.C()))
+
+	// Closing the DB closes the remaining subscriptions.
+	require.NoError(t, d.Close())
+	_, ok = <-s2.C()
+	require.False(t, ok)
+}
+
+func TestSubscribeBackpressure(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	s := d.Subscribe(1)
+
+	// The first batch is buffered, and the second blocks until the consumer
+	// receives the first.
+	done := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			done <- d.Set([]byte(fmt.Sprint(i)), nil, nil)
+		}
+	}()
+	require.NoError(t, <-done)
+	select {
+	case <-done:
+		t.Fatal("commit was not blocked by a full subscription")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.Equal(t, "#1: SET:0=", formatCommittedBatch(<-s.C()))
+	require.NoError(t, <-done)
+	require.Equal(t, "#2: SET:1=", formatCommittedBatch(<-s.C()))
+
+	// Closing the subscription unblocks a blocked commit.
+	require.NoError(t, d.Set([]byte("2"), nil, nil))
+	go func() {
+		done <- d.Set([]byte("3"), nil, nil)
+	}()
+	s.Close()
+	require.NoError(t, <-done)
+}
+
+func TestSubscribeWALError(t *testing.T) {
+	fs := &writeErrorFS{FS: vfs.NewMem(), fileType: fileTypeLog}
+	d, begin, end := openBackgroundErrorTestDB(t, fs)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	s := d.Subscribe(10)
+
+	// A batch is delivered only once it has been synced, even if the commit
+	// is not synchronous, and a batch whose sync fails is not delivered.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.Equal(t, "#1: SET:a=1", formatCommittedBatch(<-s.C()))
+	fs.setFailing(true)
+	err := d.Set([]byte("b"), []byte("2"), nil)
+	require.True(t, errors.Is(err, errTestWrite), "%v", err)
+	<-begin
+	fs.setFailing(false)
+	<-end
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.Equal(t, "#3: SET:c=3", formatCommittedBatch(<-s.C()))
+	select {
+	case c := <-s.C():
+		t.Fatalf("unexpected batch: %s", formatCommittedBatch(c))
+	default:
+	}
+}
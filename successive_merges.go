@@ -0,0 +1,151 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// maybeCollapseMerges bounds the number of successive merge operands of a key
+// in the mutable memtable (see Options.MaxSuccessiveMerges). A MERGE in b of
+// a key which already has MaxSuccessiveMerges successive merge operands in
+// the mutable memtable is replaced by a SET of the fully merged value of the
+// key, so that reads of the key need not merge an unbounded number of
+// operands.
+//
+// Replacing a MERGE requires reading the value of the key as of the batch's
+// sequence number, so the batches sequenced before b must have been applied
+// and published. The caller must hold commitPipeline.mu and b must have been
+// assigned its sequence number, but not yet written to the WAL.
+//
+// Collapsing merges is best-effort: large and indexed batches are not
+// rewritten, nor are the operands of keys which are also written earlier in
+// the same batch, and an operand is left unchanged if the key cannot be read
+// or merged.
+func (d *DB) maybeCollapseMerges(b *Batch) {
+	if d.opts.MaxSuccessiveMerges <= 0 || b.flushable != nil || b.index != nil {
+		return
+	}
+
+	d.mu.Lock()
+	mem := d.mu.mem.mutable
+	d.mu.Unlock()
+
+	// Find the merge operands to collapse, identified by their offsets in
+	// b.data.
+	var collapse map[uintptr]bool
+	written := make(map[string]bool)
+	for r := b.Reader(); len(r) > 0; {
+		offset := uintptr(unsafe.Pointer(&r[0])) - uintptr(unsafe.Pointer(&b.data[0]))
+		kind, key, _, ok := r.Next()
+		if !ok {
+			return
+		}
+		if kind == InternalKeyKindMerge && !written[string(key)] &&
+			successiveMerges(mem, d.cmp, key, d.opts.MaxSuccessiveMerges) >= d.opts.MaxSuccessiveMerges {
+			if collapse == nil {
+				collapse = make(map[uintptr]bool)
+			}
+			collapse[offset] = true
+		}
+		written[string(key)] = true
+	}
+	if len(collapse) == 0 {
+		return
+	}
+
+	// Wait for the batches sequenced before b to be published so that reads
+	// observe them. As in commitPipeline.AllocateSeqNum, the spin loop avoids
+	// the need for additional synchronization in the commit pipeline.
+	for atomic.LoadUint64(&d.mu.versions.visibleSeqNum) < b.SeqNum() {
+		runtime.Gosched()
+	}
+
+	data := make([]byte, batchHeaderLen, len(b.data))
+	copy(data, b.data[:batchHeaderLen])
+	for r := b.Reader(); len(r) > 0; {
+		start := r
+		offset := uintptr(unsafe.Pointer(&r[0])) - uintptr(unsafe.Pointer(&b.data[0]))
+		_, key, value, _ := r.Next()
+		if collapse[offset] {
+			if merged, ok := d.fullMerge(key, value); ok {
+				data = appendSetRecord(data, key, merged)
+				continue
+			}
+		}
+		data = append(data, start[:len(start)-len(r)]...)
+	}
+
+	oldData := b.data
+	b.data = data
+	b.refreshMemTableSize()
+	if int(b.memTableSize) >= d.largeBatchThreshold ||
+		(d.memTableSizer != nil && int64(b.memTableSize) >= atomic.LoadInt64(&d.memTableBatchThreshold)) {
+		// The merged values grew the batch such that it would have been
+		// committed as a large batch, which it is too late to do.
+		b.data = oldData
+		b.refreshMemTableSize()
+	}
+}
+
+// fullMerge returns the result of merging the operand with the current value
+// of the key, or false if the key cannot be read or the merge fails.
+func (d *DB) fullMerge(key, operand []byte) ([]byte, bool) {
+	value, closer, err := d.Get(key)
+	var valueMerger ValueMerger
+	switch err {
+	case ErrNotFound:
+		valueMerger, err = d.opts.Merger.Merge(key, operand)
+	case nil:
+		// The ValueMerger may retain the initial value.
+		value = append([]byte(nil), value...)
+		_ = closer.Close()
+		valueMerger, err = d.opts.Merger.Merge(key, value)
+		if err == nil {
+			err = valueMerger.MergeNewer(operand)
+		}
+	}
+	if err != nil {
+		return nil, false
+	}
+	merged, closer, err := valueMerger.Finish()
+	if err != nil {
+		return nil, false
+	}
+	merged = append([]byte(nil), merged...)
+	if closer != nil {
+		_ = closer.Close()
+	}
+	return merged, true
+}
+
+// successiveMerges returns the number of successive merge operands of key at
+// the top of the memtable, counting no more than limit operands.
+func successiveMerges(mem *memTable, cmp Compare, key []byte, limit int) int {
+	iter := mem.newIter(nil)
+	defer iter.Close()
+
+	n := 0
+	for k, _ := iter.SeekGE(key); k != nil && n < limit; k, _ = iter.Next() {
+		if k.Kind() != InternalKeyKindMerge || cmp(k.UserKey, key) != 0 {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// appendSetRecord appends the encoding of a SET batch record to data.
+func appendSetRecord(data, key, value []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	data = append(data, byte(InternalKeyKindSet))
+	data = append(data, buf[:binary.PutUvarint(buf[:], uint64(len(key)))]...)
+	data = append(data, key...)
+	data = append(data, buf[:binary.PutUvarint(buf[:], uint64(len(value)))]...)
+	return append(data, value...)
+}
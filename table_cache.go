@@ -27,58 +27,217 @@ var emptyIter = &errorIter{err: nil}
 
 var tableCacheLabels = pprof.Labels("pebble", "table-cache")
 
-type tableCache struct {
-	cache         *Cache
-	shards        []tableCacheShard
-	filterMetrics FilterMetrics
+// TableCache is a cache of open sstables, bounding the number of sstable file
+// descriptors held open. A TableCache may be shared between DBs by setting
+// Options.TableCache, in which case the DBs compete for the capacity of the
+// TableCache. To prevent a DB accessing many sstables from evicting the
+// sstables of the other DBs, each DB sharing a TableCache is limited to
+// holding open approximately Options.MaxOpenFiles sstables.
+type TableCache struct {
+	refs   int64
+	cache  *Cache
+	shards []tableCacheShard
 }
 
-func (c *tableCache) init(cacheID uint64, dirname string, fs vfs.FS, opts *Options, size int) {
-	c.cache = opts.Cache
+// NewTableCache creates a TableCache holding up to size open sstables, divided
+// between numShards shards. If numShards is not positive, the number of CPUs
+// is used. Every DB using the TableCache must use cache as its
+// Options.Cache. The returned TableCache has a single reference, which the
+// caller must release with Unref.
+func NewTableCache(cache *Cache, numShards int, size int) *TableCache {
+	if numShards <= 0 {
+		numShards = runtime.NumCPU()
+	}
+	if size < 1 {
+		size = 1
+	}
+	if numShards > size {
+		numShards = size
+	}
+	c := &TableCache{
+		refs:   1,
+		cache:  cache,
+		shards: make([]tableCacheShard, numShards),
+	}
 	c.cache.Ref()
-
-	c.shards = make([]tableCacheShard, runtime.NumCPU())
 	for i := range c.shards {
-		c.shards[i].init(cacheID, dirname, fs, opts, size/len(c.shards))
-		c.shards[i].filterMetrics = &c.filterMetrics
+		c.shards[i].init(size / numShards)
+	}
+	return c
+}
+
+// Ref adds a reference to the TableCache. The TableCache remains valid as
+// long as a reference is held to it.
+func (c *TableCache) Ref() {
+	if v := atomic.AddInt64(&c.refs, 1); v <= 1 {
+		panic(fmt.Sprintf("pebble: inconsistent table cache reference count: %d", v))
+	}
+}
+
+// Unref releases a reference to the TableCache. The sstables held open by the
+// TableCache are closed when the last reference is released.
+func (c *TableCache) Unref() {
+	v := atomic.AddInt64(&c.refs, -1)
+	switch {
+	case v < 0:
+		panic(fmt.Sprintf("pebble: inconsistent table cache reference count: %d", v))
+	case v == 0:
+		for i := range c.shards {
+			c.shards[i].Close()
+		}
+		c.cache.Unref()
+	}
+}
+
+func (c *TableCache) getShard(cacheID uint64, fileNum FileNum) *tableCacheShard {
+	const prime64 = 1099511628211
+	return &c.shards[(cacheID*prime64+uint64(fileNum))%uint64(len(c.shards))]
+}
+
+// tableCacheKey identifies an sstable in a TableCache, which may hold the
+// sstables of multiple DBs.
+type tableCacheKey struct {
+	cacheID uint64
+	fileNum FileNum
+}
+
+// tableCacheOpts holds the state of a DB using a TableCache: the options
+// needed to open the sstables of the DB, and the metrics of the DB's use of
+// the TableCache.
+type tableCacheOpts struct {
+	logger        Logger
+	cacheID       uint64
+	dirname       string
+	fs            vfs.FS
+	sharedFS      vfs.FS
+	opts          sstable.ReaderOptions
+	filterMetrics FilterMetrics
+	// quota is the number of sstables the DB may hold open in each shard of the
+	// TableCache before its own sstables are evicted to make room for new ones.
+	// Zero if the TableCache is private to the DB.
+	quota int
+	// files, if set, strictly bounds the number of sstable file descriptors
+	// held open by the DB. See Options.Experimental.StrictMaxOpenFiles.
+	files *openFileLimiter
+
+	// The following fields are updated atomically.
+	hits       int64
+	misses     int64
+	evictions  int64
+	openTables int64
+	iterCount  int32
+
+	// releasing tracks the sstables of the DB queued to be closed.
+	releasing sync.WaitGroup
+
+	mu struct {
+		sync.Mutex
+		// The iters map is only created and populated in race builds.
+		iters map[sstable.Iterator][]byte
+	}
+}
+
+func (o *tableCacheOpts) init(cacheID uint64, dirname string, fs vfs.FS, opts *Options) {
+	o.logger = opts.Logger
+	o.cacheID = cacheID
+	o.dirname = dirname
+	o.fs = fs
+	o.sharedFS = opts.Experimental.SharedFS
+	o.opts = opts.MakeReaderOptions()
+	if invariants.RaceEnabled {
+		o.mu.iters = make(map[sstable.Iterator][]byte)
+	}
+}
+
+// tableCache is a DB's handle on the TableCache holding its sstables, which is
+// either private to the DB or shared through Options.TableCache.
+type tableCache struct {
+	tc     *TableCache
+	dbOpts tableCacheOpts
+}
+
+// init initializes the table cache of a DB holding up to size sstables open.
+func (c *tableCache) init(cacheID uint64, dirname string, fs vfs.FS, opts *Options, size int) {
+	c.dbOpts.init(cacheID, dirname, fs, opts)
+	if opts.Experimental.StrictMaxOpenFiles {
+		c.dbOpts.files = newOpenFileLimiter(size)
+	}
+	if opts.TableCache != nil {
+		c.tc = opts.TableCache
+		c.tc.Ref()
+		c.dbOpts.quota = size / len(c.tc.shards)
+		if c.dbOpts.quota < 1 {
+			c.dbOpts.quota = 1
+		}
+	} else {
+		c.tc = NewTableCache(opts.Cache, runtime.NumCPU(), size)
 	}
 }
 
 func (c *tableCache) getShard(fileNum FileNum) *tableCacheShard {
-	return &c.shards[uint64(fileNum)%uint64(len(c.shards))]
+	return c.tc.getShard(c.dbOpts.cacheID, fileNum)
 }
 
 func (c *tableCache) newIters(
 	meta *fileMetadata, opts *IterOptions, bytesIterated *uint64,
 ) (internalIterator, internalIterator, error) {
-	return c.getShard(meta.FileNum).newIters(meta, opts, bytesIterated)
+	return c.getShard(meta.PhysicalFileNum()).newIters(meta, opts, bytesIterated, &c.dbOpts)
 }
 
 func (c *tableCache) evict(fileNum FileNum) {
-	c.getShard(fileNum).evict(fileNum)
+	c.getShard(fileNum).evict(fileNum, &c.dbOpts)
 }
 
+// metrics returns the metrics of the DB's use of the table cache. The count of
+// the returned CacheMetrics is the number of sstables of the DB held in the
+// table cache, and the hits and misses are those of the DB's lookups.
 func (c *tableCache) metrics() (CacheMetrics, FilterMetrics) {
 	var m CacheMetrics
-	for i := range c.shards {
-		s := &c.shards[i]
+	for i := range c.tc.shards {
+		s := &c.tc.shards[i]
 		s.mu.RLock()
-		m.Count += int64(len(s.mu.nodes))
+		m.Count += int64(s.mu.numOpen[c.dbOpts.cacheID])
 		s.mu.RUnlock()
-		m.Hits += atomic.LoadInt64(&s.hits)
-		m.Misses += atomic.LoadInt64(&s.misses)
 	}
 	m.Size = m.Count * int64(unsafe.Sizeof(sstable.Reader{}))
+	m.Hits = atomic.LoadInt64(&c.dbOpts.hits)
+	m.Misses = atomic.LoadInt64(&c.dbOpts.misses)
 	f := FilterMetrics{
-		Hits:   atomic.LoadInt64(&c.filterMetrics.Hits),
-		Misses: atomic.LoadInt64(&c.filterMetrics.Misses),
+		Hits:   atomic.LoadInt64(&c.dbOpts.filterMetrics.Hits),
+		Misses: atomic.LoadInt64(&c.dbOpts.filterMetrics.Misses),
 	}
 	return m, f
 }
 
+// evictions returns the number of sstables of the DB evicted from the table
+// cache to make room for other sstables.
+func (c *tableCache) evictions() int64 {
+	return atomic.LoadInt64(&c.dbOpts.evictions)
+}
+
+// openTables returns the number of sstables of the DB currently open, which
+// includes sstables evicted from the table cache but still in use by
+// iterators. When MaxOpenFiles is enforced strictly, only the sstables whose
+// file descriptor is open are included.
+func (c *tableCache) openTables() int64 {
+	if c.dbOpts.files != nil {
+		return c.dbOpts.files.openCount()
+	}
+	return atomic.LoadInt64(&c.dbOpts.openTables)
+}
+
+// reopens returns the number of sstables reopened after having been closed to
+// enforce MaxOpenFiles strictly.
+func (c *tableCache) reopens() int64 {
+	if c.dbOpts.files != nil {
+		return atomic.LoadInt64(&c.dbOpts.files.reopens)
+	}
+	return 0
+}
+
 func (c *tableCache) withReader(meta *fileMetadata, fn func(*sstable.Reader) error) error {
-	s := c.getShard(meta.FileNum)
-	v := s.findNode(meta)
+	s := c.getShard(meta.PhysicalFileNum())
+	v := s.findNode(meta, &c.dbOpts)
 	defer s.unrefValue(v)
 	if v.err != nil {
 		return v.err
@@ -86,36 +245,87 @@ func (c *tableCache) withReader(meta *fileMetadata, fn func(*sstable.Reader) err
 	return fn(v.reader)
 }
 
-func (c *tableCache) iterCount() int64 {
-	var n int64
-	for i := range c.shards {
-		n += int64(atomic.LoadInt32(&c.shards[i].iterCount))
+// getTableProperties returns a copy of the properties of the table for the
+// given file metadata.
+func (c *tableCache) getTableProperties(meta *fileMetadata) (*sstable.Properties, error) {
+	var props sstable.Properties
+	err := c.withReader(meta, func(r *sstable.Reader) error {
+		props = r.Properties
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return n
+	return &props, nil
 }
 
+func (c *tableCache) newRangeKeyIter(meta *fileMetadata) (internalIterator, error) {
+	var iter internalIterator
+	err := c.withReader(meta, func(r *sstable.Reader) error {
+		// The iterator holds a reference to the cached range key block, and
+		// remains valid after the reader is released.
+		i, err := r.NewRangeKeyIter()
+		if i == nil || err != nil {
+			return err
+		}
+		if meta.Virtual() {
+			lower, upper := virtualTableBounds(meta)
+			iter, err = truncateVirtualRangeKeys(c.dbOpts.opts.Comparer.Compare, i, lower, upper)
+			return err
+		}
+		iter = i
+		return nil
+	})
+	return iter, err
+}
+
+func (c *tableCache) iterCount() int64 {
+	return int64(atomic.LoadInt32(&c.dbOpts.iterCount))
+}
+
+// Close releases the DB's sstables from the table cache, and its reference to
+// the TableCache.
 func (c *tableCache) Close() error {
+	if c.tc == nil {
+		return nil
+	}
+	// Check for leaked iterators. Note that we'll still perform cleanup below in
+	// the case that there are leaked iterators.
 	var err error
-	for i := range c.shards {
-		err = firstError(err, c.shards[i].Close())
+	if v := atomic.LoadInt32(&c.dbOpts.iterCount); v > 0 {
+		if !invariants.RaceEnabled {
+			err = errors.Errorf("leaked iterators: %d", errors.Safe(v))
+		} else {
+			var buf bytes.Buffer
+			c.dbOpts.mu.Lock()
+			for _, stack := range c.dbOpts.mu.iters {
+				fmt.Fprintf(&buf, "%s\n", stack)
+			}
+			c.dbOpts.mu.Unlock()
+			err = errors.Errorf("leaked iterators: %d\n%s", errors.Safe(v), buf.String())
+		}
 	}
-	c.cache.Unref()
+
+	for i := range c.tc.shards {
+		c.tc.shards[i].removeDB(&c.dbOpts)
+	}
+	// Wait for the DB's sstables to be closed. Note that the shards of a
+	// shared TableCache may concurrently be releasing the sstables of other
+	// DBs.
+	c.dbOpts.releasing.Wait()
+	c.tc.Unref()
 	return err
 }
 
 type tableCacheShard struct {
-	logger  Logger
-	cacheID uint64
-	dirname string
-	fs      vfs.FS
-	opts    sstable.ReaderOptions
-	size    int
+	size int
 
 	mu struct {
 		sync.RWMutex
-		nodes map[FileNum]*tableCacheNode
-		// The iters map is only created and populated in race builds.
-		iters map[sstable.Iterator][]byte
+		nodes map[tableCacheKey]*tableCacheNode
+		// numOpen is the number of nodes holding a value for each DB, keyed by
+		// cache ID.
+		numOpen map[uint64]int
 
 		handHot  *tableCacheNode
 		handCold *tableCacheNode
@@ -127,30 +337,19 @@ type tableCacheShard struct {
 		sizeTest   int
 	}
 
-	hits          int64
-	misses        int64
-	iterCount     int32
-	releasing     sync.WaitGroup
-	releasingCh   chan *tableCacheValue
-	filterMetrics *FilterMetrics
+	iterCount   int32
+	releasing   sync.WaitGroup
+	releasingCh chan *tableCacheValue
 }
 
-func (c *tableCacheShard) init(cacheID uint64, dirname string, fs vfs.FS, opts *Options, size int) {
-	c.logger = opts.Logger
-	c.cacheID = cacheID
-	c.dirname = dirname
-	c.fs = fs
-	c.opts = opts.MakeReaderOptions()
+func (c *tableCacheShard) init(size int) {
 	c.size = size
 
-	c.mu.nodes = make(map[FileNum]*tableCacheNode)
+	c.mu.nodes = make(map[tableCacheKey]*tableCacheNode)
+	c.mu.numOpen = make(map[uint64]int)
 	c.mu.coldTarget = size
 	c.releasingCh = make(chan *tableCacheValue, 100)
 	go c.releaseLoop()
-
-	if invariants.RaceEnabled {
-		c.mu.iters = make(map[sstable.Iterator][]byte)
-	}
 }
 
 func (c *tableCacheShard) releaseLoop() {
@@ -162,33 +361,51 @@ func (c *tableCacheShard) releaseLoop() {
 }
 
 func (c *tableCacheShard) newIters(
-	meta *fileMetadata, opts *IterOptions, bytesIterated *uint64,
+	meta *fileMetadata, opts *IterOptions, bytesIterated *uint64, dbOpts *tableCacheOpts,
 ) (internalIterator, internalIterator, error) {
+	if opts != nil && opts.ctx != nil {
+		if err := opts.ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Calling findNode gives us the responsibility of decrementing v's
 	// refCount. If opening the underlying table resulted in error, then we
 	// decrement this straight away. Otherwise, we pass that responsibility to
 	// the sstable iterator, which decrements when it is closed.
-	v := c.findNode(meta)
+	v := c.findNode(meta, dbOpts)
 	if v.err != nil {
 		c.unrefValue(v)
 		return nil, nil, v.err
 	}
 
 	if opts != nil &&
-		opts.TableFilter != nil &&
-		!opts.TableFilter(v.reader.Properties.UserProperties) {
+		((opts.TableFilter != nil && !opts.TableFilter(v.reader.Properties.UserProperties)) ||
+			timestampTableHidden(&v.reader.Properties, opts.Timestamp)) {
 		// Return the empty iterator. This iterator has no mutable state, so
 		// using a singleton is fine.
 		c.unrefValue(v)
 		return emptyIter, nil, nil
 	}
 
+	// The keys of the backing table of a virtual table which lie outside of
+	// the bounds of the virtual table are hidden.
+	var vLower, vUpper []byte
+	if meta.Virtual() {
+		vLower, vUpper = virtualTableBounds(meta)
+	}
+	cmp := dbOpts.opts.Comparer.Compare
+
 	var iter sstable.Iterator
 	var err error
 	if bytesIterated != nil {
 		iter, err = v.reader.NewCompactionIter(bytesIterated)
 	} else {
-		iter, err = v.reader.NewIter(opts.GetLowerBound(), opts.GetUpperBound())
+		lower, upper := opts.GetLowerBound(), opts.GetUpperBound()
+		if meta.Virtual() {
+			lower, upper = intersectBounds(cmp, lower, upper, vLower, vUpper)
+		}
+		iter, err = v.reader.NewIter(lower, upper)
 	}
 	if err != nil {
 		c.unrefValue(v)
@@ -196,28 +413,83 @@ func (c *tableCacheShard) newIters(
 	}
 	// NB: v.closeHook takes responsibility for calling unrefValue(v) here.
 	iter.SetCloseHook(v.closeHook)
+	if opts != nil && opts.ctx != nil {
+		iter.SetContext(opts.ctx)
+	}
 
 	atomic.AddInt32(&c.iterCount, 1)
+	atomic.AddInt32(&dbOpts.iterCount, 1)
 	if invariants.RaceEnabled {
-		c.mu.Lock()
-		c.mu.iters[iter] = debug.Stack()
-		c.mu.Unlock()
+		dbOpts.mu.Lock()
+		dbOpts.mu.iters[iter] = debug.Stack()
+		dbOpts.mu.Unlock()
 	}
 
 	// NB: range-del iterator does not maintain a reference to the table, nor
-	// does it need to read from it after creation.
+	// does it need to read from it after creation. The exception is a range-del
+	// iterator which exceeds the iterator's range-del memory budget. See
+	// newBudgetedRangeDelIter.
 	rangeDelIter, err := v.reader.NewRangeDelIter()
 	if err != nil {
 		_ = iter.Close()
 		return nil, nil, err
 	}
+	if meta.Virtual() {
+		iter = &virtualTableIter{
+			Iterator:   iter,
+			cmp:        cmp,
+			lower:      vLower,
+			upper:      vUpper,
+			compaction: bytesIterated != nil,
+		}
+		if rangeDelIter == nil {
+			return iter, nil, nil
+		}
+		// The truncated range deletions are copied rather than pinning the
+		// range deletion block, so they are not accounted against the range
+		// deletion budget.
+		rangeDelIter, err = truncateVirtualRangeDels(cmp, rangeDelIter, vLower, vUpper)
+		if err != nil {
+			_ = iter.Close()
+			return nil, nil, err
+		}
+		return iter, rangeDelIter, nil
+	}
 	if rangeDelIter != nil {
+		if opts != nil && opts.rangeDelBudget != nil {
+			return iter, c.newBudgetedRangeDelIter(v, rangeDelIter, opts.rangeDelBudget, dbOpts), nil
+		}
 		return iter, rangeDelIter, nil
 	}
 	// NB: Translate a nil range-del iterator into a nil interface.
 	return iter, nil, nil
 }
 
+// newBudgetedRangeDelIter accounts for the memory pinned by rangeDelIter
+// against budget. If the budget is exhausted, rangeDelIter is closed and a
+// spilling range deletion iterator is returned in its place, which holds a
+// reference to the table in order to re-read the range deletion block on
+// demand.
+func (c *tableCacheShard) newBudgetedRangeDelIter(
+	v *tableCacheValue, rangeDelIter internalIterator, budget *rangeDelBudget, dbOpts *tableCacheOpts,
+) internalIterator {
+	size := int64(v.reader.RangeDelBlockSize())
+	if budget.reserve(size) {
+		return &budgetedRangeDelIter{
+			internalIterator: rangeDelIter,
+			budget:           budget,
+			size:             size,
+		}
+	}
+	_ = rangeDelIter.Close()
+	atomic.AddInt32(&v.refCount, 1)
+	return &spillingRangeDelIter{
+		cmp:     dbOpts.opts.Comparer.Compare,
+		newIter: v.reader.NewRangeDelIter,
+		closeFn: func() { c.unrefValue(v) },
+	}
+}
+
 // releaseNode releases a node from the tableCacheShard.
 //
 // c.mu must be held when calling this.
@@ -231,7 +503,7 @@ func (c *tableCacheShard) releaseNode(n *tableCacheNode) {
 //
 // c.mu must be held when calling this.
 func (c *tableCacheShard) unlinkNode(n *tableCacheNode) {
-	delete(c.mu.nodes, n.meta.FileNum)
+	delete(c.mu.nodes, n.key())
 
 	switch n.ptype {
 	case tableCacheNodeHot:
@@ -264,12 +536,27 @@ func (c *tableCacheShard) unlinkNode(n *tableCacheNode) {
 }
 
 func (c *tableCacheShard) clearNode(n *tableCacheNode) {
-	if v := n.value; v != nil {
-		n.value = nil
+	if v := c.takeValue(n); v != nil {
 		c.unrefValue(v)
 	}
 }
 
+// takeValue clears the value of a node, returning it. The caller takes over
+// the shard's reference to the value.
+//
+// c.mu must be held when calling this.
+func (c *tableCacheShard) takeValue(n *tableCacheNode) *tableCacheValue {
+	v := n.value
+	if v != nil {
+		n.value = nil
+		c.mu.numOpen[n.dbOpts.cacheID]--
+		if c.mu.numOpen[n.dbOpts.cacheID] == 0 {
+			delete(c.mu.numOpen, n.dbOpts.cacheID)
+		}
+	}
+	return v
+}
+
 // unrefValue decrements the reference count for the specified value, releasing
 // it if the reference count fell to 0. Note that the value has a reference if
 // it is present in tableCacheShard.mu.nodes, so a reference count of 0 means
@@ -277,6 +564,7 @@ func (c *tableCacheShard) clearNode(n *tableCacheNode) {
 func (c *tableCacheShard) unrefValue(v *tableCacheValue) {
 	if atomic.AddInt32(&v.refCount, -1) == 0 {
 		c.releasing.Add(1)
+		v.dbOpts.releasing.Add(1)
 		c.releasingCh <- v
 	}
 }
@@ -284,11 +572,13 @@ func (c *tableCacheShard) unrefValue(v *tableCacheValue) {
 // findNode returns the node for the table with the given file number, creating
 // that node if it didn't already exist. The caller is responsible for
 // decrementing the returned node's refCount.
-func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
+func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *tableCacheValue {
+	key := tableCacheKey{cacheID: dbOpts.cacheID, fileNum: meta.PhysicalFileNum()}
+
 	// Fast-path for a hit in the cache. We grab the lock in shared mode, and use
 	// a batching mechanism to perform updates to the LRU list.
 	c.mu.RLock()
-	if n := c.mu.nodes[meta.FileNum]; n != nil && n.value != nil {
+	if n := c.mu.nodes[key]; n != nil && n.value != nil {
 		// Fast-path hit.
 		//
 		// The caller is responsible for decrementing the refCount.
@@ -296,7 +586,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
 		atomic.AddInt32(&v.refCount, 1)
 		c.mu.RUnlock()
 		atomic.StoreInt32(&n.referenced, 1)
-		atomic.AddInt64(&c.hits, 1)
+		atomic.AddInt64(&dbOpts.hits, 1)
 		<-v.loaded
 		return v
 	}
@@ -304,13 +594,14 @@ func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
 
 	c.mu.Lock()
 
-	n := c.mu.nodes[meta.FileNum]
+	n := c.mu.nodes[key]
 	switch {
 	case n == nil:
 		// Slow-path miss of a non-existent node.
 		n = &tableCacheNode{
-			meta:  meta,
-			ptype: tableCacheNodeCold,
+			meta:   meta,
+			dbOpts: dbOpts,
+			ptype:  tableCacheNodeCold,
 		}
 		c.addNode(n)
 		c.mu.sizeCold++
@@ -322,7 +613,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
 		v := n.value
 		atomic.AddInt32(&v.refCount, 1)
 		atomic.StoreInt32(&n.referenced, 1)
-		atomic.AddInt64(&c.hits, 1)
+		atomic.AddInt64(&dbOpts.hits, 1)
 		c.mu.Unlock()
 		<-v.loaded
 		return v
@@ -341,9 +632,19 @@ func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
 		c.mu.sizeHot++
 	}
 
-	atomic.AddInt64(&c.misses, 1)
+	atomic.AddInt64(&dbOpts.misses, 1)
+
+	// If the TableCache is shared and the DB already holds its quota of open
+	// sstables in this shard, make room by evicting one of the DB's own
+	// sstables rather than relying on the clock hands, which would evict the
+	// sstables of other DBs.
+	if dbOpts.quota > 0 {
+		for c.mu.numOpen[dbOpts.cacheID] >= dbOpts.quota && c.evictForQuota(dbOpts) {
+		}
+	}
 
 	v := &tableCacheValue{
+		dbOpts:   dbOpts,
 		loaded:   make(chan struct{}),
 		refCount: 2,
 	}
@@ -351,15 +652,17 @@ func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
 	// allocation on every call to newIters.
 	v.closeHook = func(i sstable.Iterator) error {
 		if invariants.RaceEnabled {
-			c.mu.Lock()
-			delete(c.mu.iters, i)
-			c.mu.Unlock()
+			dbOpts.mu.Lock()
+			delete(dbOpts.mu.iters, i)
+			dbOpts.mu.Unlock()
 		}
 		c.unrefValue(v)
+		atomic.AddInt32(&dbOpts.iterCount, -1)
 		atomic.AddInt32(&c.iterCount, -1)
 		return nil
 	}
 	n.value = v
+	c.mu.numOpen[dbOpts.cacheID]++
 
 	c.mu.Unlock()
 
@@ -371,9 +674,38 @@ func (c *tableCacheShard) findNode(meta *fileMetadata) *tableCacheValue {
 	return v
 }
 
+// evictForQuota evicts an open sstable of the DB, preferring a cold sstable
+// which has not been referenced since the clock hands last swept it. Returns
+// false if the DB has no open sstables in the shard.
+//
+// c.mu must be held when calling this.
+func (c *tableCacheShard) evictForQuota(dbOpts *tableCacheOpts) bool {
+	var victim *tableCacheNode
+	for n := c.mu.handCold; n != nil; {
+		if n.dbOpts == dbOpts && n.value != nil {
+			if victim == nil {
+				victim = n
+			}
+			if n.ptype == tableCacheNodeCold && atomic.LoadInt32(&n.referenced) == 0 {
+				victim = n
+				break
+			}
+		}
+		if n = n.next(); n == c.mu.handCold {
+			break
+		}
+	}
+	if victim == nil {
+		return false
+	}
+	c.releaseNode(victim)
+	atomic.AddInt64(&dbOpts.evictions, 1)
+	return true
+}
+
 func (c *tableCacheShard) addNode(n *tableCacheNode) {
 	c.evictNodes()
-	c.mu.nodes[n.meta.FileNum] = n
+	c.mu.nodes[n.key()] = n
 
 	n.links.next = n
 	n.links.prev = n
@@ -406,6 +738,9 @@ func (c *tableCacheShard) runHandCold() {
 			c.mu.sizeCold--
 			c.mu.sizeHot++
 		} else {
+			if n.value != nil {
+				atomic.AddInt64(&n.dbOpts.evictions, 1)
+			}
 			c.clearNode(n)
 			n.ptype = tableCacheNodeTest
 			c.mu.sizeCold--
@@ -466,10 +801,10 @@ func (c *tableCacheShard) runHandTest() {
 	c.mu.handTest = c.mu.handTest.next()
 }
 
-func (c *tableCacheShard) evict(fileNum FileNum) {
+func (c *tableCacheShard) evict(fileNum FileNum, dbOpts *tableCacheOpts) {
 	c.mu.Lock()
 
-	n := c.mu.nodes[fileNum]
+	n := c.mu.nodes[tableCacheKey{cacheID: dbOpts.cacheID, fileNum: fileNum}]
 	var v *tableCacheValue
 	if n != nil {
 		// NB: This is equivalent to tableCacheShard.releaseNode(), but we perform
@@ -478,12 +813,13 @@ func (c *tableCacheShard) evict(fileNum FileNum) {
 		// tableCacheShard.releasing needs to be incremented while holding
 		// tableCacheShard.mu in order to avoid a race with Close()
 		c.unlinkNode(n)
-		v = n.value
+		v = c.takeValue(n)
 		if v != nil {
 			if t := atomic.AddInt32(&v.refCount, -1); t != 0 {
-				c.logger.Fatalf("sstable %s: refcount is not zero: %d\n%s", fileNum, t, debug.Stack())
+				dbOpts.logger.Fatalf("sstable %s: refcount is not zero: %d\n%s", fileNum, t, debug.Stack())
 			}
 			c.releasing.Add(1)
+			v.dbOpts.releasing.Add(1)
 		}
 	}
 
@@ -493,34 +829,41 @@ func (c *tableCacheShard) evict(fileNum FileNum) {
 		v.release(c)
 	}
 
-	c.opts.Cache.EvictFile(c.cacheID, fileNum)
+	dbOpts.opts.Cache.EvictFile(dbOpts.cacheID, fileNum)
 }
 
-func (c *tableCacheShard) Close() error {
+// removeDB releases the sstables of a DB which is closing from the shard.
+func (c *tableCacheShard) removeDB(dbOpts *tableCacheOpts) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Check for leaked iterators. Note that we'll still perform cleanup below in
-	// the case that there are leaked iterators.
-	var err error
-	if v := atomic.LoadInt32(&c.iterCount); v > 0 {
-		if !invariants.RaceEnabled {
-			err = errors.Errorf("leaked iterators: %d", errors.Safe(v))
-		} else {
-			var buf bytes.Buffer
-			for _, stack := range c.mu.iters {
-				fmt.Fprintf(&buf, "%s\n", stack)
+	for key, n := range c.mu.nodes {
+		if key.cacheID != dbOpts.cacheID {
+			continue
+		}
+		c.unlinkNode(n)
+		if v := c.takeValue(n); v != nil {
+			if atomic.AddInt32(&v.refCount, -1) == 0 {
+				c.releasing.Add(1)
+				v.dbOpts.releasing.Add(1)
+				c.releasingCh <- v
 			}
-			err = errors.Errorf("leaked iterators: %d\n%s", errors.Safe(v), buf.String())
 		}
 	}
+	c.mu.Unlock()
+}
+
+// Close releases the sstables remaining in the shard. It is called when the
+// last reference to the TableCache is released.
+func (c *tableCacheShard) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	for c.mu.handHot != nil {
 		n := c.mu.handHot
-		if n.value != nil {
-			if atomic.AddInt32(&n.value.refCount, -1) == 0 {
+		if v := c.takeValue(n); v != nil {
+			if atomic.AddInt32(&v.refCount, -1) == 0 {
 				c.releasing.Add(1)
-				c.releasingCh <- n.value
+				v.dbOpts.releasing.Add(1)
+				c.releasingCh <- v
 			}
 		}
 		c.unlinkNode(n)
@@ -536,14 +879,14 @@ func (c *tableCacheShard) Close() error {
 	// complete. This behavior is used by iterator leak tests. Leaking the
 	// goroutine for these tests is less bad not closing the iterator which
 	// triggers other warnings about block cache handles not being released.
-	if err == nil {
+	if atomic.LoadInt32(&c.iterCount) == 0 {
 		close(c.releasingCh)
 	}
 	c.releasing.Wait()
-	return err
 }
 
 type tableCacheValue struct {
+	dbOpts    *tableCacheOpts
 	closeHook func(i sstable.Iterator) error
 	reader    *sstable.Reader
 	err       error
@@ -554,15 +897,40 @@ type tableCacheValue struct {
 }
 
 func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard) {
+	o := v.dbOpts
 	// Try opening the fileTypeTable first.
+	// The table cache holds the backing table of a virtual table, which is
+	// shared by the virtual tables created from it.
+	fileNum := meta.PhysicalFileNum()
+	var open func() (vfs.File, error)
+	switch {
+	case meta.External == "":
+		path := base.MakeFilename(o.fs, o.dirname, fileTypeTable, fileNum)
+		open = func() (vfs.File, error) {
+			return o.fs.Open(path, vfs.RandomReadsOption)
+		}
+	case o.sharedFS == nil:
+		v.err = errors.Errorf("pebble: table %s was ingested by reference from %s, but SharedFS is not set",
+			errors.Safe(fileNum), meta.External)
+	default:
+		path := meta.External
+		open = func() (vfs.File, error) {
+			return o.sharedFS.Open(path, vfs.RandomReadsOption)
+		}
+	}
 	var f vfs.File
-	f, v.err = c.fs.Open(base.MakeFilename(c.fs, c.dirname, fileTypeTable, meta.FileNum),
-		vfs.RandomReadsOption)
 	if v.err == nil {
-		cacheOpts := private.SSTableCacheOpts(c.cacheID, meta.FileNum).(sstable.ReaderOption)
-		v.reader, v.err = sstable.NewReader(f, c.opts, cacheOpts, c.filterMetrics)
+		f, v.err = open()
+	}
+	if v.err == nil && o.files != nil {
+		f = o.files.wrap(f, open)
+	}
+	if v.err == nil {
+		cacheOpts := private.SSTableCacheOpts(o.cacheID, fileNum).(sstable.ReaderOption)
+		v.reader, v.err = sstable.NewReader(f, o.opts, cacheOpts, &o.filterMetrics)
 	}
 	if v.err == nil {
+		atomic.AddInt64(&o.openTables, 1)
 		if meta.SmallestSeqNum == meta.LargestSeqNum {
 			v.reader.Properties.GlobalSeqNum = meta.LargestSeqNum
 		}
@@ -572,7 +940,7 @@ func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard) {
 		defer c.mu.Unlock()
 		// Lookup the node in the cache again as it might have already been
 		// removed.
-		n := c.mu.nodes[meta.FileNum]
+		n := c.mu.nodes[tableCacheKey{cacheID: o.cacheID, fileNum: fileNum}]
 		if n != nil && n.value == v {
 			c.releaseNode(n)
 		}
@@ -586,7 +954,9 @@ func (v *tableCacheValue) release(c *tableCacheShard) {
 	// open.
 	if v.reader != nil {
 		_ = v.reader.Close()
+		atomic.AddInt64(&v.dbOpts.openTables, -1)
 	}
+	v.dbOpts.releasing.Done()
 	c.releasing.Done()
 }
 
@@ -611,8 +981,9 @@ func (p tableCacheNodeType) String() string {
 }
 
 type tableCacheNode struct {
-	meta  *fileMetadata
-	value *tableCacheValue
+	meta   *fileMetadata
+	dbOpts *tableCacheOpts
+	value  *tableCacheValue
 
 	links struct {
 		next *tableCacheNode
@@ -624,6 +995,10 @@ type tableCacheNode struct {
 	referenced int32
 }
 
+func (n *tableCacheNode) key() tableCacheKey {
+	return tableCacheKey{cacheID: n.dbOpts.cacheID, fileNum: n.meta.PhysicalFileNum()}
+}
+
 func (n *tableCacheNode) next() *tableCacheNode {
 	if n == nil {
 		return nil
@@ -0,0 +1,238 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// openFileLimiter strictly bounds the number of sstable file descriptors held
+// open by the table cache of a DB (see Options.Experimental.StrictMaxOpenFiles).
+// The table cache alone only bounds the number of sstables it caches: an
+// sstable evicted from the table cache remains open until the iterators using
+// it are closed. With an openFileLimiter, every sstable opened by the table
+// cache is wrapped in a reopenableFile, and opening a file which would exceed
+// the limit first closes the file descriptor of the least recently read
+// sstable, even if that sstable is in use by an iterator. The file is
+// transparently reopened the next time the sstable is read.
+type openFileLimiter struct {
+	limit int
+
+	mu struct {
+		sync.Mutex
+		// lru is the sentinel of a circular list of the open files, ordered from
+		// most to least recently read.
+		lru   reopenableFile
+		count int
+	}
+
+	// The number of sstable files reopened after having been closed to respect
+	// the limit. Updated atomically.
+	reopens int64
+}
+
+func newOpenFileLimiter(limit int) *openFileLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	l := &openFileLimiter{limit: limit}
+	l.mu.lru.next = &l.mu.lru
+	l.mu.lru.prev = &l.mu.lru
+	return l
+}
+
+// wrap returns a reopenableFile for file f, which was opened by open.
+func (l *openFileLimiter) wrap(f vfs.File, open func() (vfs.File, error)) *reopenableFile {
+	rf := &reopenableFile{
+		limiter: l,
+		open:    open,
+		file:    f,
+	}
+	l.add(rf)
+	return rf
+}
+
+// openCount returns the number of sstable file descriptors held open.
+func (l *openFileLimiter) openCount() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.mu.count)
+}
+
+// add adds a newly opened file to the front of the LRU list, closing the
+// least recently read files if the limit is exceeded.
+func (l *openFileLimiter) add(f *reopenableFile) {
+	var victims []*reopenableFile
+	l.mu.Lock()
+	l.pushFrontLocked(f)
+	l.mu.count++
+	for l.mu.count > l.limit && l.mu.lru.prev != f {
+		v := l.mu.lru.prev
+		l.removeLocked(v)
+		victims = append(victims, v)
+	}
+	l.mu.Unlock()
+
+	// Close the victims outside of l.mu, waiting for in-progress reads of the
+	// victims to complete. A victim read in the meantime is not in the LRU list
+	// and is not moved to its front.
+	for _, v := range victims {
+		v.mu.Lock()
+		if v.file != nil {
+			_ = v.file.Close()
+			v.file = nil
+		}
+		v.mu.Unlock()
+	}
+}
+
+// touch moves f to the front of the LRU list.
+func (l *openFileLimiter) touch(f *reopenableFile) {
+	l.mu.Lock()
+	if f.next != nil && l.mu.lru.next != f {
+		f.prev.next = f.next
+		f.next.prev = f.prev
+		l.pushFrontLocked(f)
+	}
+	l.mu.Unlock()
+}
+
+// remove removes f from the LRU list, if present, when it is closed.
+func (l *openFileLimiter) remove(f *reopenableFile) {
+	l.mu.Lock()
+	if f.next != nil {
+		l.removeLocked(f)
+	}
+	l.mu.Unlock()
+}
+
+func (l *openFileLimiter) pushFrontLocked(f *reopenableFile) {
+	f.prev = &l.mu.lru
+	f.next = l.mu.lru.next
+	f.prev.next = f
+	f.next.prev = f
+}
+
+func (l *openFileLimiter) removeLocked(f *reopenableFile) {
+	f.prev.next = f.next
+	f.next.prev = f.prev
+	f.prev = nil
+	f.next = nil
+	l.mu.count--
+}
+
+// reopenableFile is an sstable file whose file descriptor may be closed by an
+// openFileLimiter, and which is reopened when next read. Note that readahead
+// hints are not supported for a reopenableFile, as the file descriptor may be
+// closed at any time.
+type reopenableFile struct {
+	limiter *openFileLimiter
+	open    func() (vfs.File, error)
+
+	// mu protects file, and is held in shared mode while file is in use.
+	mu   sync.RWMutex
+	file vfs.File
+
+	// The links of the LRU list of the limiter, protected by limiter.mu. Nil
+	// when the file descriptor is closed.
+	prev, next *reopenableFile
+}
+
+var _ vfs.File = (*reopenableFile)(nil)
+
+// acquire returns the underlying file, reopening it if it was closed. On
+// success, f.mu is held in shared mode and must be released by the caller.
+func (f *reopenableFile) acquire() (vfs.File, error) {
+	for {
+		f.mu.RLock()
+		if file := f.file; file != nil {
+			f.limiter.touch(f)
+			return file, nil
+		}
+		f.mu.RUnlock()
+
+		f.mu.Lock()
+		reopened := false
+		if f.file == nil {
+			file, err := f.open()
+			if err != nil {
+				f.mu.Unlock()
+				return nil, err
+			}
+			f.file = file
+			reopened = true
+			atomic.AddInt64(&f.limiter.reopens, 1)
+		}
+		f.mu.Unlock()
+		if reopened {
+			// NB: Adding the file may close other files, and must be done
+			// without holding f.mu to avoid deadlocking with a concurrent reopen
+			// of one of those files. The file may itself be closed again before
+			// it is read, in which case it is reopened on the next iteration.
+			f.limiter.add(f)
+		}
+	}
+}
+
+func (f *reopenableFile) Close() error {
+	f.limiter.remove(f)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *reopenableFile) Read(p []byte) (int, error) {
+	file, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.mu.RUnlock()
+	return file.Read(p)
+}
+
+func (f *reopenableFile) ReadAt(p []byte, off int64) (int, error) {
+	file, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.mu.RUnlock()
+	return file.ReadAt(p, off)
+}
+
+func (f *reopenableFile) Write(p []byte) (int, error) {
+	file, err := f.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer f.mu.RUnlock()
+	return file.Write(p)
+}
+
+func (f *reopenableFile) Stat() (os.FileInfo, error) {
+	file, err := f.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer f.mu.RUnlock()
+	return file.Stat()
+}
+
+func (f *reopenableFile) Sync() error {
+	file, err := f.acquire()
+	if err != nil {
+		return err
+	}
+	defer f.mu.RUnlock()
+	return file.Sync()
+}
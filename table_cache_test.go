@@ -380,7 +380,7 @@ func TestTableCacheEvictClose(t *testing.T) {
 	require.NoError(t, db.Set(start, nil, nil))
 	require.NoError(t, db.Flush())
 	require.NoError(t, db.DeleteRange(start, end, nil))
-	require.NoError(t, db.Compact(start, end))
+	require.NoError(t, db.Compact(start, end, false))
 	require.NoError(t, db.Close())
 	close(errs)
 
@@ -411,11 +411,11 @@ func TestTableCacheClockPro(t *testing.T) {
 	opts.EnsureDefaults()
 	defer opts.Cache.Unref()
 
-	cache := &tableCacheShard{
-		filterMetrics: &FilterMetrics{},
-	}
+	cache := &tableCacheShard{}
 	// NB: The table cache size of 200 is required for the expected test values.
-	cache.init(0, "", mem, opts, 200)
+	cache.init(200)
+	dbOpts := &tableCacheOpts{}
+	dbOpts.init(0, "", mem, opts)
 
 	scanner := bufio.NewScanner(f)
 	tables := make(map[int]bool)
@@ -434,11 +434,11 @@ func TestTableCacheClockPro(t *testing.T) {
 			tables[key] = true
 		}
 
-		oldHits := atomic.LoadInt64(&cache.hits)
-		v := cache.findNode(&fileMetadata{FileNum: FileNum(key)})
+		oldHits := atomic.LoadInt64(&dbOpts.hits)
+		v := cache.findNode(&fileMetadata{FileNum: FileNum(key)}, dbOpts)
 		cache.unrefValue(v)
 
-		hit := atomic.LoadInt64(&cache.hits) != oldHits
+		hit := atomic.LoadInt64(&dbOpts.hits) != oldHits
 		wantHit := fields[1][0] == 'h'
 		if hit != wantHit {
 			t.Errorf("%d: cache hit mismatch: got %v, want %v\n", line, hit, wantHit)
@@ -446,3 +446,114 @@ func TestTableCacheClockPro(t *testing.T) {
 		line++
 	}
 }
+
+func TestTableCacheShared(t *testing.T) {
+	cache := NewCache(8 << 20) // 8 MB
+	defer cache.Unref()
+	tc := NewTableCache(cache, 1, 100)
+	defer tc.Unref()
+
+	// The TableCache must be used with the Cache it was created with.
+	other := NewCache(8 << 20) // 8 MB
+	_, err := Open("", &Options{FS: vfs.NewMem(), Cache: other, TableCache: tc})
+	other.Unref()
+	require.Error(t, err)
+
+	// d1 may hold up to minTableCacheSize sstables open in the shared cache.
+	d1, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		Cache:                       cache,
+		TableCache:                  tc,
+		MaxOpenFiles:                minTableCacheSize + numNonTableCacheFiles,
+		L0StopWritesThreshold:       1000,
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	d2, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		Cache:                       cache,
+		TableCache:                  tc,
+		L0StopWritesThreshold:       1000,
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+
+	writeAndRead := func(d *DB, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i)), nil, nil))
+			require.NoError(t, d.Flush())
+		}
+		for i := 0; i < n; i++ {
+			_, closer, err := d.Get([]byte(fmt.Sprintf("k%03d", i)))
+			require.NoError(t, err)
+			require.NoError(t, closer.Close())
+		}
+	}
+
+	writeAndRead(d2, 10)
+	m2 := d2.Metrics()
+	require.Equal(t, int64(10), m2.TableCache.Count)
+	require.Equal(t, int64(10), m2.TableOpenFiles)
+
+	// Accessing more sstables than its quota evicts d1's own sstables, leaving
+	// the sstables of d2 in the cache.
+	writeAndRead(d1, 2*minTableCacheSize)
+	m1 := d1.Metrics()
+	require.Equal(t, int64(minTableCacheSize), m1.TableCache.Count)
+	require.True(t, m1.TableCacheEvictions >= minTableCacheSize, "%d", m1.TableCacheEvictions)
+	m2 = d2.Metrics()
+	require.Equal(t, int64(10), m2.TableCache.Count)
+	require.Equal(t, int64(0), m2.TableCacheEvictions)
+
+	// Closing a DB releases its sstables from the shared cache, making room
+	// for the sstables of d2.
+	require.NoError(t, d1.Close())
+	require.Equal(t, int64(0), atomic.LoadInt64(&d1.tableCache.dbOpts.openTables))
+	writeAndRead(d2, 40)
+	m2 = d2.Metrics()
+	require.Equal(t, int64(50), m2.TableCache.Count)
+	require.Equal(t, int64(0), m2.TableCacheEvictions)
+	require.NoError(t, d2.Close())
+}
+
+func TestTableCacheStrictMaxOpenFiles(t *testing.T) {
+	// Disable block caching so that every read goes to the sstable files.
+	cache := NewCache(0)
+	defer cache.Unref()
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		Cache:                       cache,
+		MaxOpenFiles:                minTableCacheSize + numNonTableCacheFiles,
+		L0StopWritesThreshold:       1000,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.StrictMaxOpenFiles = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	const n = 2 * minTableCacheSize
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprint(i)), nil))
+		require.NoError(t, d.Flush())
+	}
+
+	// The iterator holds all of the sstables open, which exceeds MaxOpenFiles.
+	// The file descriptors of the least recently read sstables are closed, and
+	// reopened as the iterator reads them.
+	iter := d.NewIter(nil)
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < n; i++ {
+			k := fmt.Sprintf("k%03d", i)
+			require.True(t, iter.SeekGE([]byte(k)), k)
+			require.Equal(t, k, string(iter.Key()))
+			require.Equal(t, fmt.Sprint(i), string(iter.Value()))
+			m := d.Metrics()
+			require.True(t, m.TableOpenFiles <= minTableCacheSize, "%d", m.TableOpenFiles)
+		}
+	}
+	require.NoError(t, iter.Close())
+
+	m := d.Metrics()
+	require.True(t, m.TableReopens > 0, "%d", m.TableReopens)
+	require.NoError(t, d.Close())
+}
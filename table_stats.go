@@ -41,6 +41,10 @@ import (
 
 func (d *DB) maybeCollectTableStats() {
 	if d.shouldCollectTableStats() {
+		if d.opts.Experimental.Deterministic {
+			d.collectTableStatsLocked()
+			return
+		}
 		go d.collectTableStats()
 	}
 }
@@ -73,11 +77,19 @@ func (d *DB) shouldCollectTableStats() bool {
 }
 
 func (d *DB) collectTableStats() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.collectTableStatsLocked()
+}
+
+// collectTableStatsLocked runs one table stats collection job.
+//
+// d.mu must be held when calling this, but the mutex is dropped while the
+// stats are loaded.
+func (d *DB) collectTableStatsLocked() {
 	const maxTableStatsPerScan = 50
 
-	d.mu.Lock()
 	if !d.shouldCollectTableStats() {
-		d.mu.Unlock()
 		return
 	}
 
@@ -97,19 +109,19 @@ func (d *DB) collectTableStats() {
 	// Grab a read state to scan for tables.
 	rs := d.loadReadState()
 	var collected []collectedStats
+	var hints []deleteCompactionHint
 	if len(pending) > 0 {
-		collected = d.loadNewFileStats(rs, pending)
+		collected, hints = d.loadNewFileStats(rs, pending)
 	} else {
 		var moreRemain bool
 		var buf [maxTableStatsPerScan]collectedStats
-		collected, moreRemain = d.scanReadStateTableStats(rs, buf[:0])
+		collected, hints, moreRemain = d.scanReadStateTableStats(rs, buf[:0])
 		loadedInitial = !moreRemain
 	}
 	rs.unref()
 
 	// Update the FileMetadata with the loaded stats while holding d.mu.
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	d.mu.tableStats.loading = false
 	if loadedInitial && !d.mu.tableStats.loadedInitial {
 		d.mu.tableStats.loadedInitial = loadedInitial
@@ -118,11 +130,12 @@ func (d *DB) collectTableStats() {
 		})
 	}
 
-	maybeCompact := false
+	maybeCompact := len(hints) > 0
 	for _, c := range collected {
 		c.fileMetadata.Stats = c.TableStats
 		maybeCompact = maybeCompact || c.fileMetadata.Stats.RangeDeletionsBytesEstimate > 0
 	}
+	d.mu.compact.deletionHints = append(d.mu.compact.deletionHints, hints...)
 	d.mu.tableStats.cond.Broadcast()
 	d.maybeCollectTableStats()
 	if maybeCompact {
@@ -135,7 +148,10 @@ type collectedStats struct {
 	manifest.TableStats
 }
 
-func (d *DB) loadNewFileStats(rs *readState, pending []manifest.NewFileEntry) []collectedStats {
+func (d *DB) loadNewFileStats(
+	rs *readState, pending []manifest.NewFileEntry,
+) ([]collectedStats, []deleteCompactionHint) {
+	var hints []deleteCompactionHint
 	collected := make([]collectedStats, 0, len(pending))
 	for _, nf := range pending {
 		// A file's stats might have been populated by an earlier call to
@@ -155,11 +171,12 @@ func (d *DB) loadNewFileStats(rs *readState, pending []manifest.NewFileEntry) []
 			continue
 		}
 
-		stats, err := d.loadTableStats(rs.current, nf.Level, nf.Meta)
+		stats, newHints, err := d.loadTableStats(rs.current, nf.Level, nf.Meta)
 		if err != nil {
 			d.opts.EventListener.BackgroundError(err)
 			continue
 		}
+		hints = append(hints, newHints...)
 		// NB: We don't update the FileMetadata yet, because we aren't
 		// holding DB.mu. We'll copy it to the FileMetadata after we're
 		// finished with IO.
@@ -168,7 +185,7 @@ func (d *DB) loadNewFileStats(rs *readState, pending []manifest.NewFileEntry) []
 			TableStats:   stats,
 		})
 	}
-	return collected
+	return collected, hints
 }
 
 // scanReadStateTableStats is run by an active stat collection job when there
@@ -176,7 +193,8 @@ func (d *DB) loadNewFileStats(rs *readState, pending []manifest.NewFileEntry) []
 // which we haven't loaded table stats.
 func (d *DB) scanReadStateTableStats(
 	rs *readState, fill []collectedStats,
-) ([]collectedStats, bool) {
+) ([]collectedStats, []deleteCompactionHint, bool) {
+	var hints []deleteCompactionHint
 	moreRemain := false
 	for l, ff := range rs.current.Levels {
 		for _, f := range ff {
@@ -196,30 +214,37 @@ func (d *DB) scanReadStateTableStats(
 			// work to do.
 			if len(fill) == cap(fill) {
 				moreRemain = true
-				return fill, moreRemain
+				return fill, hints, moreRemain
 			}
 
-			stats, err := d.loadTableStats(rs.current, l, f)
+			stats, newHints, err := d.loadTableStats(rs.current, l, f)
 			if err != nil {
 				// Set `moreRemain` so we'll try again.
 				moreRemain = true
 				d.opts.EventListener.BackgroundError(err)
 				continue
 			}
+			hints = append(hints, newHints...)
 			fill = append(fill, collectedStats{
 				fileMetadata: f,
 				TableStats:   stats,
 			})
 		}
 	}
-	return fill, moreRemain
+	return fill, hints, moreRemain
 }
 
+// loadTableStats computes the stats for the table meta in the specified
+// level, along with a deletion hint for each of the table's range tombstones
+// which wholly covers a table in a lower level.
 func (d *DB) loadTableStats(
 	v *version, level int, meta *fileMetadata,
-) (manifest.TableStats, error) {
+) (manifest.TableStats, []deleteCompactionHint, error) {
+	var stats manifest.TableStats
 	var totalRangeDeletionEstimate uint64
+	var hints []deleteCompactionHint
 	err := d.tableCache.withReader(meta, func(r *sstable.Reader) (err error) {
+		stats = propertiesTableStats(&r.Properties)
 		if r.Properties.NumRangeDeletions == 0 {
 			return nil
 		}
@@ -233,29 +258,64 @@ func (d *DB) loadTableStats(
 		if err != nil {
 			return err
 		}
+		if meta.Virtual() {
+			// Only the range deletions within the bounds of a virtual table
+			// delete anything.
+			lower, upper := virtualTableBounds(meta)
+			rangeDelIter, err = truncateVirtualRangeDels(d.cmp, rangeDelIter, lower, upper)
+			if err != nil || rangeDelIter == nil {
+				return err
+			}
+		}
 		defer rangeDelIter.Close()
-		err = foreachDefragmentedTombstone(rangeDelIter, d.cmp, func(startUserKey, endUserKey []byte) error {
-			estimate, err := d.estimateSizeBeneath(v, level, meta, startUserKey, endUserKey)
+		err = foreachDefragmentedTombstone(rangeDelIter, d.cmp, func(
+			startUserKey, endUserKey []byte, smallestSeqNum, largestSeqNum uint64,
+		) error {
+			estimate, covers, err := d.estimateSizeBeneath(v, level, meta, startUserKey, endUserKey)
 			if err != nil {
 				return err
 			}
 			totalRangeDeletionEstimate += estimate
+			if covers {
+				hints = append(hints, deleteCompactionHint{
+					tombstoneLevel:          level,
+					tombstoneFile:           meta,
+					tombstoneSmallestSeqNum: smallestSeqNum,
+					tombstoneLargestSeqNum:  largestSeqNum,
+					start:                   append([]byte(nil), startUserKey...),
+					end:                     append([]byte(nil), endUserKey...),
+				})
+			}
 			return nil
 		})
 		return err
 	})
-	var stats manifest.TableStats
 	if err != nil {
-		return stats, err
+		return manifest.TableStats{}, nil, err
 	}
 	stats.Valid = true
 	stats.RangeDeletionsBytesEstimate = totalRangeDeletionEstimate
-	return stats, nil
+	return stats, hints, nil
+}
+
+// propertiesTableStats returns the stats of a table which are derived from
+// its properties. The returned stats are not marked valid, as the estimates
+// which require examining the table's range deletions are not populated. A
+// table without range deletions has no such estimates, so its stats may be
+// marked valid when the table is written, avoiding loading the table later.
+func propertiesTableStats(p *sstable.Properties) manifest.TableStats {
+	return manifest.TableStats{
+		NumEntries:   p.NumEntries,
+		NumDeletions: p.NumDeletions,
+	}
 }
 
+// estimateSizeBeneath estimates the number of bytes in levels below level
+// within [start, end). It also returns whether any table beneath is wholly
+// contained within [start, end).
 func (d *DB) estimateSizeBeneath(
 	v *version, level int, meta *fileMetadata, start, end []byte,
-) (uint64, error) {
+) (estimate uint64, covers bool, _ error) {
 	// Find all files in lower levels that overlap with the deleted range.
 	//
 	// An overlapping file might be completely contained by the range
@@ -264,7 +324,6 @@ func (d *DB) estimateSizeBeneath(
 	//
 	// Otherwise, estimating the range for the file requires
 	// additional I/O to read the file's index blocks.
-	var estimate uint64
 	for l := level + 1; l < numLevels; l++ {
 		overlaps := v.Overlaps(l, d.cmp, start, end)
 
@@ -274,46 +333,66 @@ func (d *DB) estimateSizeBeneath(
 				// partially overlap with the range tombstone, which
 				// means `file` is fully contained within the range.
 				estimate += file.Size
+				covers = true
 			} else if d.cmp(start, file.Smallest.UserKey) <= 0 &&
 				d.cmp(file.Largest.UserKey, end) <= 0 {
 				// The range fully contains the file, so skip looking it up in
 				// table cache/looking at its indexes and add the full file size.
 				estimate += file.Size
+				covers = covers || d.cmp(file.Largest.UserKey, end) < 0
 			} else if d.cmp(file.Smallest.UserKey, end) <= 0 && d.cmp(start, file.Largest.UserKey) <= 0 {
 				var size uint64
 				err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
+					start, end := virtualEstimateBounds(d.cmp, file, start, end)
 					size, err = r.EstimateDiskUsage(start, end)
 					return err
 				})
 				if err != nil {
-					return 0, err
+					return 0, false, err
 				}
 				estimate += size
 			}
 		}
 	}
-	return estimate, nil
+	return estimate, covers, nil
 }
 
+// foreachDefragmentedTombstone calls fn for each span of the range tombstones
+// in rangeDelIter, merging abutting tombstones. fn is also passed the smallest
+// and largest sequence numbers of the tombstones covering each span. Where
+// tombstones at different sequence numbers cover the same fragment, the
+// newest one is used, as it deletes everything the older ones do.
 func foreachDefragmentedTombstone(
-	rangeDelIter base.InternalIterator, cmp base.Compare, fn func([]byte, []byte) error,
+	rangeDelIter base.InternalIterator,
+	cmp base.Compare,
+	fn func(start, end []byte, smallestSeqNum, largestSeqNum uint64) error,
 ) error {
 	var startUserKey, endUserKey []byte
+	var smallestSeqNum, largestSeqNum uint64
 	var initialized bool
 	for start, end := rangeDelIter.First(); start != nil; start, end = rangeDelIter.Next() {
 		// Range tombstones are fragmented such that any two tombstones
 		// that share the same start key also share the same end key.
 		// Multiple tombstones may exist at different sequence numbers.
 		// If this tombstone starts or ends at the same point, it's a fragment
-		// of the previous one.
+		// of the previous one. Fragments are sorted by decreasing sequence
+		// number, so the skipped fragment is older than the previous one.
 		if cmp(startUserKey, start.UserKey) == 0 || cmp(endUserKey, end) == 0 {
 			continue
 		}
 
+		seqNum := start.SeqNum()
+
 		// If this fragmented tombstone begins where the previous
 		// tombstone ended, merge it and continue.
 		if cmp(endUserKey, start.UserKey) == 0 {
 			endUserKey = append(endUserKey[:0], end...)
+			if seqNum < smallestSeqNum {
+				smallestSeqNum = seqNum
+			}
+			if seqNum > largestSeqNum {
+				largestSeqNum = seqNum
+			}
 			continue
 		}
 
@@ -322,18 +401,20 @@ func foreachDefragmentedTombstone(
 		if !initialized {
 			startUserKey = append(startUserKey[:0], start.UserKey...)
 			endUserKey = append(endUserKey[:0], end...)
+			smallestSeqNum, largestSeqNum = seqNum, seqNum
 			initialized = true
 			continue
 		}
 
-		if err := fn(startUserKey, endUserKey); err != nil {
+		if err := fn(startUserKey, endUserKey, smallestSeqNum, largestSeqNum); err != nil {
 			return err
 		}
 		startUserKey = append(startUserKey[:0], start.UserKey...)
 		endUserKey = append(endUserKey[:0], end...)
+		smallestSeqNum, largestSeqNum = seqNum, seqNum
 	}
 	if initialized {
-		if err := fn(startUserKey, endUserKey); err != nil {
+		if err := fn(startUserKey, endUserKey, smallestSeqNum, largestSeqNum); err != nil {
 			return err
 		}
 	}
@@ -27,7 +27,10 @@ func TestTableStats(t *testing.T) {
 			},
 		},
 	}
-	d, err := Open("", &Options{FS: fs})
+	// Automatic compactions are disabled so that the tables covered by range
+	// tombstones aren't removed by delete-only compactions.
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", opts)
 	require.NoError(t, err)
 	defer func() {
 		if d != nil {
@@ -161,7 +164,7 @@ func TestForeachDefragmentedTombstone(t *testing.T) {
 	for _, tc := range testCases {
 		iter := rangedel.NewIter(DefaultComparer.Compare, tc.fragmented)
 		var got [][2]string
-		err := foreachDefragmentedTombstone(iter, DefaultComparer.Compare, func(start, end []byte) error {
+		err := foreachDefragmentedTombstone(iter, DefaultComparer.Compare, func(start, end []byte, _, _ uint64) error {
 			got = append(got, [2]string{string(start), string(end)})
 			return nil
 		})
@@ -169,3 +172,28 @@ func TestForeachDefragmentedTombstone(t *testing.T) {
 		require.Equal(t, tc.want, got)
 	}
 }
+
+func TestTableStatsMetrics(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Delete([]byte("c"), nil))
+	require.NoError(t, d.Flush())
+	// The range deletion only has an estimated size if it covers data in a
+	// lower level.
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.EqualValues(t, 0, m.Table.PendingStatsCount)
+	require.EqualValues(t, 4, m.Table.NumEntries)
+	require.EqualValues(t, 2, m.Table.NumDeletions)
+	require.True(t, m.Table.RangeDeletionsBytesEstimate > 0)
+}
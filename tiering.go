@@ -0,0 +1,331 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// Placement specifies the filesystem an sstable is written to.
+type Placement int
+
+const (
+	// PlacementPrimary places an sstable on Options.FS.
+	PlacementPrimary Placement = iota
+	// PlacementSecondary places an sstable on Options.Experimental.SecondaryFS.
+	PlacementSecondary
+)
+
+// String implements fmt.Stringer.
+func (p Placement) String() string {
+	switch p {
+	case PlacementPrimary:
+		return "primary"
+	case PlacementSecondary:
+		return "secondary"
+	}
+	return "unknown"
+}
+
+// PlacementPolicy determines where the sstables output by flushes and
+// compactions are placed. A PlacementPolicy allows a DB to tier its data
+// across two filesystems, such as local SSD and HDD, or local and remote
+// storage, based on the level and key range of the data.
+type PlacementPolicy interface {
+	// Placement returns the placement of a new sstable which will be output to
+	// the specified level. The user keys smallest and largest (both inclusive)
+	// bound the keys that may be written to the sstable: they are the bounds of
+	// the inputs to the flush or compaction that is creating the sstable.
+	Placement(level int, smallest, largest []byte) Placement
+}
+
+// LevelPlacementPolicy returns a PlacementPolicy which places the sstables
+// output to minLevel and the levels below it on the secondary FS, and all
+// other sstables on the primary FS. For example, LevelPlacementPolicy(6)
+// places only the bottommost level, which typically contains the bulk of the
+// data in the DB, on the secondary FS.
+func LevelPlacementPolicy(minLevel int) PlacementPolicy {
+	return levelPlacementPolicy(minLevel)
+}
+
+type levelPlacementPolicy int
+
+func (p levelPlacementPolicy) Placement(level int, smallest, largest []byte) Placement {
+	if level >= int(p) {
+		return PlacementSecondary
+	}
+	return PlacementPrimary
+}
+
+// outputPlacement returns the placement of the sstables output by c.
+func (d *DB) outputPlacement(c *compaction) Placement {
+	p := d.opts.Experimental.PlacementPolicy
+	if p == nil || d.tieredFS == nil {
+		return PlacementPrimary
+	}
+	return p.Placement(c.outputLevel.level, c.smallest.UserKey, c.largest.UserKey)
+}
+
+// placementChanged returns true if any of the sstables moved by the trivial
+// move compaction c would be placed on a different filesystem than the one it
+// currently resides on.
+func (d *DB) placementChanged(c *compaction) bool {
+	if d.tieredFS == nil {
+		return false
+	}
+	placement := d.outputPlacement(c)
+	for _, meta := range c.startLevel.files {
+		filename := base.MakeFilename(d.opts.FS, d.dirname, fileTypeTable, meta.PhysicalFileNum())
+		if d.tieredFS.placement(filename) != placement {
+			return true
+		}
+	}
+	return false
+}
+
+// tieredFS is a vfs.FS which stores files on either a primary or a secondary
+// FS. Files are placed on the primary FS unless they have been marked for
+// placement on the secondary FS, either because they were found on the
+// secondary FS by scan or because they were marked by placeOnSecondary before
+// being created. Directory operations are applied to both filesystems.
+type tieredFS struct {
+	primary   vfs.FS
+	secondary vfs.FS
+
+	mu struct {
+		sync.Mutex
+		// The set of paths which reside on the secondary FS.
+		onSecondary map[string]struct{}
+	}
+}
+
+var _ vfs.FS = (*tieredFS)(nil)
+
+func newTieredFS(primary, secondary vfs.FS) *tieredFS {
+	fs := &tieredFS{
+		primary:   primary,
+		secondary: secondary,
+	}
+	fs.mu.onSecondary = make(map[string]struct{})
+	return fs
+}
+
+// scan marks all of the files present in the secondary FS directory dir as
+// residing on the secondary FS.
+func (fs *tieredFS) scan(dir string) error {
+	ls, err := fs.secondary.List(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, name := range ls {
+		fs.mu.onSecondary[fs.primary.PathJoin(dir, name)] = struct{}{}
+	}
+	return nil
+}
+
+// placeOnSecondary marks the named file as residing on the secondary FS. It
+// must be called before the file is created.
+func (fs *tieredFS) placeOnSecondary(name string) {
+	fs.mu.Lock()
+	fs.mu.onSecondary[name] = struct{}{}
+	fs.mu.Unlock()
+}
+
+// placement returns the placement of the named file.
+func (fs *tieredFS) placement(name string) Placement {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.mu.onSecondary[name]; ok {
+		return PlacementSecondary
+	}
+	return PlacementPrimary
+}
+
+func (fs *tieredFS) fsFor(name string) vfs.FS {
+	if fs.placement(name) == PlacementSecondary {
+		return fs.secondary
+	}
+	return fs.primary
+}
+
+func (fs *tieredFS) forget(name string) {
+	fs.mu.Lock()
+	delete(fs.mu.onSecondary, name)
+	fs.mu.Unlock()
+}
+
+// Create implements vfs.FS.
+func (fs *tieredFS) Create(name string) (vfs.File, error) {
+	return fs.fsFor(name).Create(name)
+}
+
+// Link implements vfs.FS. A file residing on the secondary FS is linked
+// within the secondary FS.
+func (fs *tieredFS) Link(oldname, newname string) error {
+	if fs.placement(oldname) == PlacementSecondary {
+		if err := fs.secondary.Link(oldname, newname); err != nil {
+			return err
+		}
+		fs.placeOnSecondary(newname)
+		return nil
+	}
+	return fs.primary.Link(oldname, newname)
+}
+
+// Open implements vfs.FS.
+func (fs *tieredFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	return fs.fsFor(name).Open(name, opts...)
+}
+
+// OpenDir implements vfs.FS. If the directory also exists on the secondary FS,
+// syncing the returned directory syncs both copies.
+func (fs *tieredFS) OpenDir(name string) (vfs.File, error) {
+	f, err := fs.primary.OpenDir(name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := fs.secondary.OpenDir(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		_ = f.Close()
+		return nil, err
+	}
+	return &tieredDir{File: f, secondary: s}, nil
+}
+
+// Remove implements vfs.FS.
+func (fs *tieredFS) Remove(name string) error {
+	if err := fs.fsFor(name).Remove(name); err != nil {
+		return err
+	}
+	fs.forget(name)
+	return nil
+}
+
+// RemoveAll implements vfs.FS.
+func (fs *tieredFS) RemoveAll(name string) error {
+	err := fs.primary.RemoveAll(name)
+	if err2 := fs.secondary.RemoveAll(name); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Rename implements vfs.FS. A file residing on the secondary FS is renamed
+// within the secondary FS.
+func (fs *tieredFS) Rename(oldname, newname string) error {
+	if fs.placement(oldname) == PlacementSecondary {
+		if err := fs.secondary.Rename(oldname, newname); err != nil {
+			return err
+		}
+		fs.forget(oldname)
+		fs.placeOnSecondary(newname)
+		return nil
+	}
+	return fs.primary.Rename(oldname, newname)
+}
+
+// ReuseForWrite implements vfs.FS.
+func (fs *tieredFS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	return fs.primary.ReuseForWrite(oldname, newname)
+}
+
+// MkdirAll implements vfs.FS.
+func (fs *tieredFS) MkdirAll(dir string, perm os.FileMode) error {
+	if err := fs.primary.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	return fs.secondary.MkdirAll(dir, perm)
+}
+
+// Lock implements vfs.FS.
+func (fs *tieredFS) Lock(name string) (io.Closer, error) {
+	return fs.primary.Lock(name)
+}
+
+// List implements vfs.FS. The returned listing is the union of the listings of
+// the primary and secondary filesystems.
+func (fs *tieredFS) List(dir string) ([]string, error) {
+	ls, err := fs.primary.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := fs.secondary.List(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ls, nil
+		}
+		return nil, err
+	}
+	if len(secondary) == 0 {
+		return ls, nil
+	}
+	seen := make(map[string]struct{}, len(ls))
+	for _, name := range ls {
+		seen[name] = struct{}{}
+	}
+	for _, name := range secondary {
+		if _, ok := seen[name]; !ok {
+			ls = append(ls, name)
+		}
+	}
+	sort.Strings(ls)
+	return ls, nil
+}
+
+// Stat implements vfs.FS.
+func (fs *tieredFS) Stat(name string) (os.FileInfo, error) {
+	return fs.fsFor(name).Stat(name)
+}
+
+// PathBase implements vfs.FS.
+func (fs *tieredFS) PathBase(path string) string {
+	return fs.primary.PathBase(path)
+}
+
+// PathJoin implements vfs.FS.
+func (fs *tieredFS) PathJoin(elem ...string) string {
+	return fs.primary.PathJoin(elem...)
+}
+
+// PathDir implements vfs.FS.
+func (fs *tieredFS) PathDir(path string) string {
+	return fs.primary.PathDir(path)
+}
+
+// tieredDir is a directory present on both the primary and secondary
+// filesystems.
+type tieredDir struct {
+	vfs.File
+	secondary vfs.File
+}
+
+func (d *tieredDir) Sync() error {
+	if err := d.File.Sync(); err != nil {
+		return err
+	}
+	return d.secondary.Sync()
+}
+
+func (d *tieredDir) Close() error {
+	err := d.File.Close()
+	if err2 := d.secondary.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
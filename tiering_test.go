@@ -0,0 +1,155 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// coldPrefixPolicy places sstables on the secondary FS if they are output to
+// L6 and entirely contain keys with the prefix "cold".
+type coldPrefixPolicy struct{}
+
+func (coldPrefixPolicy) Placement(level int, smallest, largest []byte) Placement {
+	if level == numLevels-1 &&
+		bytes.HasPrefix(smallest, []byte("cold")) && bytes.HasPrefix(largest, []byte("cold")) {
+		return PlacementSecondary
+	}
+	return PlacementPrimary
+}
+
+func TestTieredPlacement(t *testing.T) {
+	primary := vfs.NewMem()
+	secondary := vfs.NewMem()
+	opts := &Options{
+		FS: primary,
+	}
+	opts.Experimental.SecondaryFS = secondary
+	opts.Experimental.PlacementPolicy = coldPrefixPolicy{}
+
+	tables := func(fs vfs.FS) []string {
+		ls, err := fs.List("")
+		require.NoError(t, err)
+		var res []string
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(fs, name); ok && ft == fileTypeTable {
+				res = append(res, name)
+			}
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	for _, prefix := range []string{"cold", "hot"} {
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("%s%02d", prefix, i))
+			require.NoError(t, d.Set(key, key, nil))
+		}
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Compact([]byte(prefix), []byte(prefix+"\xff"), false))
+	}
+
+	// The flushed sstables are placed on the primary FS. Only the L6 "cold"
+	// sstable is placed on the secondary FS.
+	require.Equal(t, 1, len(tables(secondary)))
+	require.Equal(t, 1, len(tables(primary)))
+
+	verify := func() {
+		for _, prefix := range []string{"cold", "hot"} {
+			for i := 0; i < 10; i++ {
+				key := []byte(fmt.Sprintf("%s%02d", prefix, i))
+				v, closer, err := d.Get(key)
+				require.NoError(t, err)
+				require.Equal(t, key, v)
+				require.NoError(t, closer.Close())
+			}
+		}
+	}
+	verify()
+	require.NoError(t, d.Close())
+
+	// Reopening the DB finds the sstables on the secondary FS.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	verify()
+
+	// Overwriting and recompacting the cold keys removes the obsolete sstable
+	// from the secondary FS.
+	before := tables(secondary)
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("cold%02d", i))
+		require.NoError(t, d.Set(key, key, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("cold"), []byte("cold\xff"), false))
+	after := tables(secondary)
+	require.Equal(t, 1, len(after))
+	require.NotEqual(t, before, after)
+	verify()
+	require.NoError(t, d.Close())
+}
+
+func TestLevelPlacementPolicy(t *testing.T) {
+	primary := vfs.NewMem()
+	secondary := vfs.NewMem()
+	opts := &Options{
+		FS: primary,
+	}
+	opts.Experimental.SecondaryFS = secondary
+	opts.Experimental.PlacementPolicy = LevelPlacementPolicy(numLevels - 1)
+
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	placements := func() [numLevels][]Placement {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var res [numLevels][]Placement
+		for level, files := range d.mu.versions.currentVersion().Levels {
+			for _, m := range files {
+				filename := base.MakeFilename(d.opts.FS, d.dirname, fileTypeTable, m.FileNum)
+				res[level] = append(res[level], d.tieredFS.placement(filename))
+			}
+		}
+		return res
+	}
+
+	// The flushed sstable is placed on the primary FS, and moved to the
+	// secondary FS when it is compacted into the bottommost level.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, []Placement{PlacementPrimary}, placements()[0])
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	p := placements()
+	require.Empty(t, p[0])
+	require.Equal(t, []Placement{PlacementSecondary}, p[numLevels-1])
+
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	_, err = secondary.Stat(base.MakeFilename(secondary, "", fileTypeTable, tables[numLevels-1][0].FileNum))
+	require.NoError(t, err)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.Close())
+}
+
+func TestTieredPlacementValidate(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.PlacementPolicy = coldPrefixPolicy{}
+	_, err := Open("", opts)
+	require.Error(t, err)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// The user properties recording the range of timestamps of the point keys of
+// an sstable. See NewTimestampPropertyCollector.
+const (
+	timestampMinProperty = "pebble.timestamp.min"
+	timestampMaxProperty = "pebble.timestamp.max"
+)
+
+// timestampPropertyCollector records the smallest and largest timestamps of
+// the point keys added to an sstable. A key without a timestamp is recorded
+// as timestamp 0 so that the table is never considered to contain only newer
+// versions.
+type timestampPropertyCollector struct {
+	min, max uint64
+	any      bool
+}
+
+// NewTimestampPropertyCollector returns a TablePropertyCollector which
+// records the range of timestamps of the point keys of an sstable whose keys
+// are ordered by TimestampComparer. Iterators reading as of a timestamp (see
+// IterOptions.Timestamp) skip the point keys of sstables which contain only
+// newer versions. To be used, the collector must be configured with
+// Options.TablePropertyCollectors.
+func NewTimestampPropertyCollector() TablePropertyCollector {
+	return &timestampPropertyCollector{}
+}
+
+// Add implements the TablePropertyCollector interface.
+func (c *timestampPropertyCollector) Add(key InternalKey, value []byte) error {
+	if key.Kind() == InternalKeyKindRangeDelete {
+		return nil
+	}
+	_, ts, _ := base.SplitTimestampKey(key.UserKey)
+	if !c.any || ts < c.min {
+		c.min = ts
+	}
+	if !c.any || ts > c.max {
+		c.max = ts
+	}
+	c.any = true
+	return nil
+}
+
+// Finish implements the TablePropertyCollector interface.
+func (c *timestampPropertyCollector) Finish(userProps map[string]string) error {
+	if c.any {
+		userProps[timestampMinProperty] = strconv.FormatUint(c.min, 10)
+		userProps[timestampMaxProperty] = strconv.FormatUint(c.max, 10)
+	}
+	return nil
+}
+
+// Name implements the TablePropertyCollector interface.
+func (c *timestampPropertyCollector) Name() string {
+	return "pebble.TimestampPropertyCollector"
+}
+
+// timestampTableHidden returns true if every point key of the table with the
+// given properties is a version newer than ts, and is therefore hidden from an
+// iterator reading as of ts. A table with range deletions is never hidden as
+// its range deletions may apply to older versions in other tables.
+func timestampTableHidden(props *sstable.Properties, ts uint64) bool {
+	if ts == 0 || props.NumRangeDeletions > 0 {
+		return false
+	}
+	v, ok := props.UserProperties[timestampMinProperty]
+	if !ok {
+		return false
+	}
+	min, err := strconv.ParseUint(v, 10, 64)
+	return err == nil && min > ts
+}
+
+// versionHidden returns true if the user key is a version which the iterator
+// does not surface when reading as of IterOptions.Timestamp: either a version
+// newer than the timestamp, or an older version of the prefix of the current
+// entry. Keys without a timestamp suffix are never hidden.
+func (i *Iterator) versionHidden(userKey []byte) bool {
+	n := i.split(userKey)
+	ts, ok := base.DecodeTimestamp(userKey[n:])
+	if !ok {
+		return false
+	}
+	return ts > i.opts.Timestamp ||
+		(i.version.valid && bytes.Equal(i.version.prefix, userKey[:n]))
+}
+
+// setVersion records the prefix of the current entry, whose older versions
+// are hidden when reading as of IterOptions.Timestamp.
+func (i *Iterator) setVersion() {
+	n := i.split(i.key)
+	if _, ok := base.DecodeTimestamp(i.key[n:]); !ok {
+		i.version.valid = false
+		return
+	}
+	i.version.prefix = append(i.version.prefix[:0], i.key[:n]...)
+	i.version.valid = true
+}
+
+// findNewestVersion positions the iterator at the newest version of the
+// prefix of the current entry which is visible as of IterOptions.Timestamp.
+// During reverse iteration, the versions of a prefix are encountered from
+// oldest to newest, so having found a visible version the iterator seeks to
+// the newest version of the prefix and steps forward to the first visible
+// one. The iterator is left in the forward direction.
+func (i *Iterator) findNewestVersion() bool {
+	n := i.split(i.key)
+	if _, ok := base.DecodeTimestamp(i.key[n:]); !ok {
+		return true
+	}
+	seekKey := base.MakeTimestampKey(nil, i.key[:n], base.MaxTimestamp)
+	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(seekKey, lowerBound) < 0 {
+		seekKey = lowerBound
+	}
+	i.version.valid = false
+	i.iterKey, i.iterValue = i.iter.SeekGE(seekKey)
+	return i.findNextEntry()
+}
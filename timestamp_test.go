@@ -0,0 +1,159 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestIteratorTimestamp(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	t.Logf("seed %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	d, err := Open("", &Options{
+		Comparer:                TimestampComparer,
+		FS:                      vfs.NewMem(),
+		TablePropertyCollectors: []func() TablePropertyCollector{NewTimestampPropertyCollector},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// The model maps each version to its value, or to "" if it is deleted.
+	const prefixes = "abcde"
+	const maxTS = 8
+	model := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		prefix := string(prefixes[rng.Intn(len(prefixes))])
+		key := MakeTimestampKey(nil, []byte(prefix), uint64(1+rng.Intn(maxTS)))
+		if rng.Intn(4) == 0 {
+			require.NoError(t, d.Delete(key, nil))
+			model[string(key)] = ""
+		} else {
+			value := fmt.Sprint(i)
+			require.NoError(t, d.Set(key, []byte(value), nil))
+			model[string(key)] = value
+		}
+		if rng.Intn(50) == 0 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// expected returns the newest version of each prefix visible as of ts.
+	expected := func(ts uint64) []string {
+		var res []string
+		for _, p := range prefixes {
+			for v := uint64(ts); v > 0; v-- {
+				key := MakeTimestampKey(nil, []byte(string(p)), v)
+				if value := model[string(key)]; value != "" {
+					res = append(res, fmt.Sprintf("%s:%s", TimestampComparer.FormatKey(key), value))
+					break
+				}
+			}
+		}
+		return res
+	}
+	format := func(iter *Iterator) string {
+		return fmt.Sprintf("%s:%s", TimestampComparer.FormatKey(iter.Key()), iter.Value())
+	}
+
+	for ts := uint64(1); ts <= maxTS+1; ts++ {
+		iter := d.NewIter(&IterOptions{Timestamp: ts})
+		want := expected(ts)
+
+		var forward []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			forward = append(forward, format(iter))
+		}
+		require.Equal(t, want, forward, "as of %d", ts)
+
+		var reverse []string
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			reverse = append([]string{format(iter)}, reverse...)
+		}
+		require.Equal(t, want, reverse, "as of %d", ts)
+
+		// Switch directions at every entry.
+		for j := range want {
+			require.True(t, iter.SeekGE([]byte(strings.Split(want[j], "@")[0])))
+			require.Equal(t, want[j], format(iter))
+			if j > 0 {
+				require.True(t, iter.Prev())
+				require.Equal(t, want[j-1], format(iter))
+				require.True(t, iter.Next())
+				require.Equal(t, want[j], format(iter))
+			}
+			if j+1 < len(want) {
+				require.True(t, iter.Next())
+				require.Equal(t, want[j+1], format(iter))
+				require.True(t, iter.Prev())
+				require.Equal(t, want[j], format(iter))
+			}
+		}
+		require.NoError(t, iter.Close())
+	}
+}
+
+func TestIteratorTimestampTableSkipping(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:                TimestampComparer,
+		FS:                      vfs.NewMem(),
+		TablePropertyCollectors: []func() TablePropertyCollector{NewTimestampPropertyCollector},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	key := func(prefix string, ts uint64) []byte {
+		return MakeTimestampKey(nil, []byte(prefix), ts)
+	}
+	require.NoError(t, d.Set(key("a", 1), []byte("1"), nil))
+	require.NoError(t, d.Set(key("b", 1), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set(key("a", 5), []byte("5"), nil))
+	require.NoError(t, d.Set(key("b", 6), []byte("6"), nil))
+	require.NoError(t, d.Flush())
+
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[0]
+	d.mu.Unlock()
+	require.Equal(t, 2, len(files))
+	for _, f := range files {
+		iter, _, err := d.newIters(f, &IterOptions{Timestamp: 4}, nil)
+		require.NoError(t, err)
+		// Only the table of newer versions is skipped.
+		require.Equal(t, f.LargestSeqNum > 2, iter == emptyIter)
+		require.NoError(t, iter.Close())
+	}
+
+	it := d.NewIter(&IterOptions{Timestamp: 4})
+	require.True(t, it.First())
+	// The point keys of only one of the tables are read.
+	require.Equal(t, int64(1), d.tableCache.iterCount())
+	var got []string
+	for valid := true; valid; valid = it.Next() {
+		got = append(got, fmt.Sprint(TimestampComparer.FormatKey(it.Key())))
+	}
+	require.NoError(t, it.Close())
+	require.Equal(t, []string{"a@1", "b@1"}, got)
+
+	// A range deletion prevents the table from being skipped.
+	require.NoError(t, d.DeleteRange(key("a", MaxTimestamp), key("c", MaxTimestamp), nil))
+	require.NoError(t, d.Set(key("c", 7), []byte("7"), nil))
+	require.NoError(t, d.Flush())
+	it = d.NewIter(&IterOptions{Timestamp: 4})
+	require.False(t, it.First())
+	require.NoError(t, it.Close())
+}
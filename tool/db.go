@@ -27,10 +27,13 @@ import (
 type dbT struct {
 	Root       *cobra.Command
 	Check      *cobra.Command
+	Get        *cobra.Command
 	LSM        *cobra.Command
 	Properties *cobra.Command
 	Scan       *cobra.Command
+	Set        *cobra.Command
 	Space      *cobra.Command
+	SpaceAmp   *cobra.Command
 
 	// Configuration.
 	opts      *pebble.Options
@@ -45,6 +48,7 @@ type dbT struct {
 	start        key
 	end          key
 	count        int64
+	prefixLen    int
 	verbose      bool
 }
 
@@ -71,6 +75,17 @@ database not be in use by another process.
 		Args: cobra.ExactArgs(1),
 		Run:  d.runCheck,
 	}
+	d.Get = &cobra.Command{
+		Use:   "get <dir> <key>",
+		Short: "get a db record",
+		Long: `
+Print the value of the specified key in the DB, or "not found" if the key does
+not exist. The DB is opened read-only. Requires that the specified database not
+be in use by another process.
+`,
+		Args: cobra.ExactArgs(2),
+		Run:  d.runGet,
+	}
 	d.LSM = &cobra.Command{
 		Use:   "lsm <dir>",
 		Short: "print LSM structure",
@@ -100,6 +115,17 @@ by another process.
 		Args: cobra.ExactArgs(1),
 		Run:  d.runScan,
 	}
+	d.Set = &cobra.Command{
+		Use:   "set <dir> <key> <value>",
+		Short: "set a db record",
+		Long: `
+Set the value of the specified key in the DB. Unlike the other db commands, set
+opens the DB for writing, creating it if it does not exist. Requires that the
+specified database not be in use by another process.
+`,
+		Args: cobra.ExactArgs(3),
+		Run:  d.runSet,
+	}
 	d.Space = &cobra.Command{
 		Use:   "space <dir>",
 		Short: "print filesystem space used",
@@ -111,11 +137,28 @@ use by another process.
 		Args: cobra.ExactArgs(1),
 		Run:  d.runSpace,
 	}
+	d.SpaceAmp = &cobra.Command{
+		Use:   "space-amp <dir>",
+		Short: "print space amplification breakdown",
+		Long: `
+Print the logical bytes stored in each level of the LSM, broken down into live
+records, records shadowed by a newer version of the same key, records deleted
+by a point or range tombstone, and the tombstones themselves. The space-amp row
+is the ratio of logical bytes to live bytes. If --prefix-len is specified, the
+same breakdown is printed for each distinct key prefix of that length.
+
+All of the sstables in the DB are read, so this can be slow on large
+databases. Requires that the specified database not be in use by another
+process.
+`,
+		Args: cobra.ExactArgs(1),
+		Run:  d.runSpaceAmp,
+	}
 
-	d.Root.AddCommand(d.Check, d.LSM, d.Properties, d.Scan, d.Space)
+	d.Root.AddCommand(d.Check, d.Get, d.LSM, d.Properties, d.Scan, d.Set, d.Space, d.SpaceAmp)
 	d.Root.PersistentFlags().BoolVarP(&d.verbose, "verbose", "v", false, "verbose output")
 
-	for _, cmd := range []*cobra.Command{d.Check, d.LSM, d.Properties, d.Scan, d.Space} {
+	for _, cmd := range []*cobra.Command{d.Check, d.Get, d.LSM, d.Properties, d.Scan, d.Set, d.Space, d.SpaceAmp} {
 		cmd.Flags().StringVar(
 			&d.comparerName, "comparer", "", "comparer name (use default if empty)")
 		cmd.Flags().StringVar(
@@ -129,12 +172,18 @@ use by another process.
 			&d.end, "end", "end key for the range")
 	}
 
-	d.Scan.Flags().Var(
-		&d.fmtKey, "key", "key formatter")
-	d.Scan.Flags().Var(
-		&d.fmtValue, "value", "value formatter")
+	for _, cmd := range []*cobra.Command{d.Get, d.Scan} {
+		cmd.Flags().Var(
+			&d.fmtKey, "key", "key formatter")
+		cmd.Flags().Var(
+			&d.fmtValue, "value", "value formatter")
+	}
 	d.Scan.Flags().Int64Var(
 		&d.count, "count", 0, "key count for scan (0 is unlimited)")
+	d.SpaceAmp.Flags().Var(
+		&d.fmtKey, "key", "key formatter")
+	d.SpaceAmp.Flags().IntVar(
+		&d.prefixLen, "prefix-len", 0, "key prefix length for per-bucket output (0 disables)")
 	return d
 }
 
@@ -209,7 +258,15 @@ func (d *dbT) loadOptions(dir string) error {
 	return nil
 }
 
-func (d *dbT) openDB(dir string) (*pebble.DB, error) {
+// openOption configures the options used to open a DB.
+type openOption func(*pebble.Options)
+
+// readWrite opens the DB for writing, rather than read-only.
+func readWrite(opts *pebble.Options) {
+	opts.ReadOnly = false
+}
+
+func (d *dbT) openDB(dir string, openOpts ...openOption) (*pebble.DB, error) {
 	if err := d.loadOptions(dir); err != nil {
 		return nil, err
 	}
@@ -226,6 +283,9 @@ func (d *dbT) openDB(dir string) (*pebble.DB, error) {
 		}
 	}
 	opts := *d.opts
+	for _, o := range openOpts {
+		o(&opts)
+	}
 	opts.Cache = pebble.NewCache(128 << 20 /* 128 MB */)
 	defer opts.Cache.Unref()
 	return pebble.Open(dir, &opts)
@@ -253,6 +313,50 @@ func (d *dbT) runCheck(cmd *cobra.Command, args []string) {
 		stats.NumPoints, makePlural("point", stats.NumPoints), stats.NumTombstones, makePlural("tombstone", int64(stats.NumTombstones)))
 }
 
+func (d *dbT) runGet(cmd *cobra.Command, args []string) {
+	var k key
+	if err := k.Set(args[1]); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+
+	db, err := d.openDB(args[0])
+	if err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+		return
+	}
+	defer d.closeDB(db)
+
+	// Update the internal formatter if this comparator has one specified.
+	if d.opts.Comparer != nil {
+		d.fmtKey.setForComparer(d.opts.Comparer.Name, d.comparers)
+		d.fmtValue.setForComparer(d.opts.Comparer.Name, d.comparers)
+	}
+
+	value, closer, err := db.Get(k)
+	if err == pebble.ErrNotFound {
+		fmt.Fprintf(stdout, "not found\n")
+		return
+	} else if err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+		return
+	}
+	defer closer.Close()
+
+	needDelimiter := false
+	if d.fmtKey.spec != "null" {
+		fmt.Fprintf(stdout, "%s", d.fmtKey.fn(k))
+		needDelimiter = true
+	}
+	if d.fmtValue.spec != "null" {
+		if needDelimiter {
+			stdout.Write([]byte{' '})
+		}
+		fmt.Fprintf(stdout, "%s", d.fmtValue.fn(k, value))
+	}
+	stdout.Write([]byte{'\n'})
+}
+
 func (d *dbT) runLSM(cmd *cobra.Command, args []string) {
 	db, err := d.openDB(args[0])
 	if err != nil {
@@ -318,6 +422,29 @@ func (d *dbT) runScan(cmd *cobra.Command, args []string) {
 		count, makePlural("record", count), elapsed.Seconds())
 }
 
+func (d *dbT) runSet(cmd *cobra.Command, args []string) {
+	var k, v key
+	if err := k.Set(args[1]); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+	if err := v.Set(args[2]); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+
+	db, err := d.openDB(args[0], readWrite)
+	if err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+		return
+	}
+	defer d.closeDB(db)
+
+	if err := db.Set(k, v, pebble.Sync); err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+	}
+}
+
 func (d *dbT) runSpace(cmd *cobra.Command, args []string) {
 	db, err := d.openDB(args[0])
 	if err != nil {
@@ -508,7 +635,7 @@ func (p *props) update(o props) {
 }
 
 func (d *dbT) addProps(dir string, m *manifest.FileMetadata, p *props) error {
-	path := base.MakeFilename(d.opts.FS, dir, base.FileTypeTable, m.FileNum)
+	path := base.MakeFilename(d.opts.FS, dir, base.FileTypeTable, m.PhysicalFileNum())
 	f, err := d.opts.FS.Open(path)
 	if err != nil {
 		return err
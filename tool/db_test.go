@@ -5,9 +5,47 @@
 package tool
 
 import (
+	"bytes"
+	"os"
 	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDB(t *testing.T) {
 	runTests(t, "testdata/db_*")
 }
+
+func TestDBSet(t *testing.T) {
+	fs := vfs.NewMem()
+	var buf bytes.Buffer
+	stdout = &buf
+	stderr = &buf
+	defer func() {
+		stdout = os.Stdout
+		stderr = os.Stderr
+	}()
+
+	run := func(args ...string) string {
+		buf.Reset()
+		tool := New()
+		tool.setFS(fs)
+		c := &cobra.Command{}
+		c.AddCommand(tool.Commands...)
+		c.SetArgs(args)
+		c.SetOutput(&buf)
+		require.NoError(t, c.Execute())
+		return buf.String()
+	}
+
+	// The db commands other than set open the DB read-only, and so fail if the
+	// DB does not exist.
+	require.Equal(t, "open db: file does not exist\n", run("db", "get", "db", "foo"))
+	require.Equal(t, "", run("db", "set", "db", "foo", "bar"))
+	require.Equal(t, "", run("db", "set", "db", "hex:71757578", "raw:hex:baz"))
+	require.Equal(t, "foo [626172]\n", run("db", "get", "db", "foo"))
+	require.Equal(t, "quux hex:baz\n", run("db", "get", "db", "quux", "--value=quoted"))
+	require.Equal(t, "not found\n", run("db", "get", "db", "bar"))
+}
@@ -72,7 +72,8 @@ func newFind(opts *pebble.Options, comparers sstable.Comparers, defaultComparer
 		Long: `
 Find references to the specified key and any range tombstones that contain the
 key. This includes references to the key in WAL files and sstables, and the
-provenance of the sstables (flushed, ingested, compacted).
+provenance of the sstables (flushed, ingested, compacted) along with whether
+they were subsequently moved to another level or deleted from the LSM.
 `,
 		Args: cobra.ExactArgs(2),
 		Run:  f.run,
@@ -578,13 +579,17 @@ func (f *findT) tableProvenance(fileNum base.FileNum) string {
 			// After a table is created, it can be moved to a different level via a
 			// move compaction. This is indicated by a version edit that deletes the
 			// table from one level and adds the same table to a different
-			// level. Loop over the remaining version edits for the table looking for
-			// such moves.
+			// level. A version edit that deletes the table without adding it again
+			// indicates the table was removed from the LSM, usually as the input to
+			// a compaction. Loop over the remaining version edits for the table
+			// looking for such moves and deletions.
 			for len(editRefs) > 0 {
 				ve := f.edits[editRefs[0]]
 				editRefs = editRefs[1:]
+				added := false
 				for _, nf := range ve.NewFiles {
 					if fileNum == nf.Meta.FileNum {
+						added = true
 						for df := range ve.DeletedFiles {
 							if fileNum == df.FileNum {
 								fmt.Fprintf(&buf, ", moved to L%d", nf.Level)
@@ -594,6 +599,14 @@ func (f *findT) tableProvenance(fileNum base.FileNum) string {
 						break
 					}
 				}
+				if !added {
+					for df := range ve.DeletedFiles {
+						if fileNum == df.FileNum {
+							fmt.Fprintf(&buf, ", deleted")
+							break
+						}
+					}
+				}
 			}
 
 			return buf.String()
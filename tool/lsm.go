@@ -63,6 +63,7 @@ type lsmT struct {
 	fmtKey keyFormatter
 	embed  bool
 	pretty bool
+	json   bool
 
 	cmp    *base.Comparer
 	state  lsmState
@@ -81,6 +82,12 @@ func newLSM(opts *pebble.Options, comparers sstable.Comparers) *lsmT {
 		Short: "LSM visualization tool",
 		Long: `
 Visualize the evolution of an LSM from the version edits in a MANIFEST.
+
+By default an HTML page is output. With --json, only the LSM state is output
+as JSON for use by other visualizers: the version edits (including the files
+added and deleted by each flush, ingestion and compaction, and the resulting
+L0 sublevels), the metadata of every file, and the keys referenced by the file
+metadata.
 `,
 		Args: cobra.ExactArgs(1),
 		Run:  l.runLSM,
@@ -89,6 +96,7 @@ Visualize the evolution of an LSM from the version edits in a MANIFEST.
 	l.Root.Flags().Var(&l.fmtKey, "key", "key formatter")
 	l.Root.Flags().BoolVar(&l.embed, "embed", true, "embed javascript in HTML (disable for development)")
 	l.Root.Flags().BoolVar(&l.pretty, "pretty", false, "pretty JSON output")
+	l.Root.Flags().BoolVar(&l.json, "json", false, "output the LSM state as JSON rather than HTML")
 	return l
 }
 
@@ -101,6 +109,11 @@ func (l *lsmT) runLSM(cmd *cobra.Command, args []string) {
 	l.buildEdits(edits)
 	w := l.Root.OutOrStdout()
 
+	if l.json {
+		fmt.Fprintf(w, "%s\n", l.formatJSON(l.state))
+		return
+	}
+
 	fmt.Fprintf(w, `<!DOCTYPE html>
 <html>
 <head>
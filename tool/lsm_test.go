@@ -0,0 +1,13 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package tool
+
+import (
+	"testing"
+)
+
+func TestLSM(t *testing.T) {
+	runTests(t, "testdata/lsm_*")
+}
@@ -5,11 +5,14 @@
 package tool
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/manifest"
@@ -24,11 +27,13 @@ type manifestT struct {
 	Root  *cobra.Command
 	Dump  *cobra.Command
 	Check *cobra.Command
+	Diff  *cobra.Command
 
 	opts      *pebble.Options
 	comparers sstable.Comparers
 	fmtKey    keyFormatter
 	verbose   bool
+	json      bool
 }
 
 func newManifest(opts *pebble.Options, comparers sstable.Comparers) *manifestT {
@@ -59,6 +64,8 @@ Print the contents of the MANIFEST files.
 
 	m.Dump.Flags().Var(
 		&m.fmtKey, "key", "key formatter")
+	m.Dump.Flags().BoolVar(
+		&m.json, "json", false, "print the version edits as JSON, one per line")
 
 	// Add check command
 	m.Check = &cobra.Command{
@@ -74,6 +81,23 @@ Check the contents of the MANIFEST files.
 	m.Check.Flags().Var(
 		&m.fmtKey, "key", "key formatter")
 
+	// Add diff command
+	m.Diff = &cobra.Command{
+		Use:   "diff <manifest-a> <manifest-b>",
+		Short: "diff the state of two manifests",
+		Long: `
+Replay the version edits in the two MANIFEST files and print the differences
+between the resulting LSM states: the comparer, log and file numbers, the last
+sequence number, the format major version, and the files removed from (-) or
+added to (+) each level of the first MANIFEST to produce the second.
+`,
+		Args: cobra.ExactArgs(2),
+		Run:  m.runDiff,
+	}
+	m.Root.AddCommand(m.Diff)
+	m.Diff.Flags().Var(
+		&m.fmtKey, "key", "key formatter")
+
 	return m
 }
 
@@ -103,6 +127,10 @@ func (m *manifestT) printLevels(v *manifest.Version) {
 }
 
 func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
+	if m.json {
+		m.runDumpJSON(args)
+		return
+	}
 	for _, arg := range args {
 		func() {
 			f, err := m.opts.FS.Open(arg)
@@ -161,6 +189,10 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 					empty = false
 					fmt.Fprintf(stdout, "  last-seq-num:  %d\n", ve.LastSeqNum)
 				}
+				if ve.FormatMajorVersion != 0 {
+					empty = false
+					fmt.Fprintf(stdout, "  format-major-version: %d\n", ve.FormatMajorVersion)
+				}
 				entries := make([]manifest.DeletedFileEntry, 0, len(ve.DeletedFiles))
 				for df := range ve.DeletedFiles {
 					empty = false
@@ -207,6 +239,39 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 	}
 }
 
+// runDumpJSON prints the version edits in each of the MANIFEST files as JSON
+// objects, one per line. User keys are encoded in base64.
+func (m *manifestT) runDumpJSON(args []string) {
+	for _, arg := range args {
+		func() {
+			f, err := m.opts.FS.Open(arg)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s\n", err)
+				return
+			}
+			defer f.Close()
+
+			r := pebble.NewManifestReader(f)
+			for {
+				e, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					fmt.Fprintf(stderr, "%s: %s\n", arg, err)
+					break
+				}
+				b, err := json.Marshal(e)
+				if err != nil {
+					fmt.Fprintf(stderr, "%s: %s\n", arg, err)
+					break
+				}
+				fmt.Fprintf(stdout, "%s\n", b)
+			}
+		}()
+	}
+}
+
 func (m *manifestT) runCheck(cmd *cobra.Command, args []string) {
 	ok := true
 	for _, arg := range args {
@@ -292,3 +357,150 @@ func (m *manifestT) runCheck(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(stdout, "OK\n")
 	}
 }
+
+// manifestState is the state of the LSM obtained by replaying all of the
+// version edits in a MANIFEST.
+type manifestState struct {
+	comparerName       string
+	logNum             base.FileNum
+	prevLogNum         uint64
+	nextFileNum        base.FileNum
+	lastSeqNum         uint64
+	formatMajorVersion uint64
+	version            *manifest.Version
+}
+
+func (m *manifestT) replay(arg string) (*manifestState, error) {
+	f, err := m.opts.FS.Open(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var s manifestState
+	var bve manifest.BulkVersionEdit
+	rr := record.NewReader(f, 0 /* logNum */)
+	for {
+		offset := rr.Offset()
+		r, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "%s: offset: %d", arg, offset)
+		}
+		var ve manifest.VersionEdit
+		if err := ve.Decode(r); err != nil {
+			return nil, errors.Wrapf(err, "%s: offset: %d", arg, offset)
+		}
+		bve.Accumulate(&ve)
+		if ve.ComparerName != "" {
+			s.comparerName = ve.ComparerName
+		}
+		if ve.MinUnflushedLogNum != 0 {
+			s.logNum = ve.MinUnflushedLogNum
+		}
+		if ve.ObsoletePrevLogNum != 0 {
+			s.prevLogNum = ve.ObsoletePrevLogNum
+		}
+		if ve.NextFileNum != 0 {
+			s.nextFileNum = ve.NextFileNum
+		}
+		if ve.LastSeqNum != 0 {
+			s.lastSeqNum = ve.LastSeqNum
+		}
+		if ve.FormatMajorVersion != 0 {
+			s.formatMajorVersion = ve.FormatMajorVersion
+		}
+	}
+
+	// A MANIFEST which does not specify a comparer uses the default comparer.
+	cmp := base.DefaultComparer
+	if s.comparerName != "" {
+		cmp = m.comparers[s.comparerName]
+	}
+	if cmp == nil {
+		return nil, errors.Errorf("%s: comparer %q not found", arg, s.comparerName)
+	}
+	s.version, _, err = bve.Apply(nil /* version */, cmp.Compare, m.fmtKey.fn, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", arg)
+	}
+	return &s, nil
+}
+
+func (m *manifestT) runDiff(cmd *cobra.Command, args []string) {
+	a, err := m.replay(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+	b, err := m.replay(args[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+	m.fmtKey.setForComparer(b.comparerName, m.comparers)
+
+	fmt.Fprintf(stdout, "--- %s\n+++ %s\n", args[0], args[1])
+	same := true
+	diffField := func(name string, va, vb interface{}) {
+		if va == vb {
+			return
+		}
+		same = false
+		for _, l := range []struct {
+			prefix byte
+			v      interface{}
+		}{{'-', va}, {'+', vb}} {
+			line := fmt.Sprintf("%c %-21s %v", l.prefix, name+":", l.v)
+			fmt.Fprintf(stdout, "%s\n", strings.TrimRight(line, " "))
+		}
+	}
+	diffField("comparer", a.comparerName, b.comparerName)
+	diffField("log-num", a.logNum, b.logNum)
+	diffField("prev-log-num", a.prevLogNum, b.prevLogNum)
+	diffField("next-file-num", a.nextFileNum, b.nextFileNum)
+	diffField("last-seq-num", a.lastSeqNum, b.lastSeqNum)
+	diffField("format-major-version", a.formatMajorVersion, b.formatMajorVersion)
+
+	printFile := func(prefix byte, f *manifest.FileMetadata) {
+		fmt.Fprintf(stdout, "%c %s:%d", prefix, f.FileNum, f.Size)
+		formatSeqNumRange(stdout, f.SmallestSeqNum, f.LargestSeqNum)
+		formatKeyRange(stdout, m.fmtKey, &f.Smallest, &f.Largest)
+		fmt.Fprintf(stdout, "\n")
+	}
+	for level := range a.version.Levels {
+		inA := make(map[base.FileNum]bool, len(a.version.Levels[level]))
+		for _, f := range a.version.Levels[level] {
+			inA[f.FileNum] = true
+		}
+		inB := make(map[base.FileNum]bool, len(b.version.Levels[level]))
+		for _, f := range b.version.Levels[level] {
+			inB[f.FileNum] = true
+		}
+
+		header := false
+		printHeader := func() {
+			if !header {
+				header = true
+				same = false
+				fmt.Fprintf(stdout, "--- L%d ---\n", level)
+			}
+		}
+		for _, f := range a.version.Levels[level] {
+			if !inB[f.FileNum] {
+				printHeader()
+				printFile('-', f)
+			}
+		}
+		for _, f := range b.version.Levels[level] {
+			if !inA[f.FileNum] {
+				printHeader()
+				printFile('+', f)
+			}
+		}
+	}
+	if same {
+		fmt.Fprintf(stdout, "no differences\n")
+	}
+}
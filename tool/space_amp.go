@@ -0,0 +1,372 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package tool
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/rangedel"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/spf13/cobra"
+)
+
+// spaceUsage accumulates the logical bytes of the records in a level or key
+// range bucket, broken down by whether the records are still live. The size
+// of a record is the size of its internal key plus the size of its value,
+// mirroring the raw-key and raw-value sstable properties.
+type spaceUsage struct {
+	Files    uint64
+	Physical uint64
+	// Live records are visible to a read at the latest sequence number, or are
+	// merge operands that contribute to a visible value.
+	Live uint64
+	// Shadowed records are hidden by a newer SET for the same key.
+	Shadowed uint64
+	// Deleted records are hidden by a newer point or range tombstone.
+	Deleted uint64
+	// Tombstones are the point and range tombstones themselves.
+	Tombstones uint64
+}
+
+func (u *spaceUsage) logical() uint64 {
+	return u.Live + u.Shadowed + u.Deleted + u.Tombstones
+}
+
+func (u *spaceUsage) garbage() uint64 {
+	return u.Shadowed + u.Deleted + u.Tombstones
+}
+
+// amp returns the ratio of logical bytes to live bytes.
+func (u *spaceUsage) amp() string {
+	if u.Live == 0 {
+		if u.logical() == 0 {
+			return "1.00"
+		}
+		return "inf"
+	}
+	return fmt.Sprintf("%.2f", float64(u.logical())/float64(u.Live))
+}
+
+func (u *spaceUsage) update(o spaceUsage) {
+	u.Files += o.Files
+	u.Physical += o.Physical
+	u.Live += o.Live
+	u.Shadowed += o.Shadowed
+	u.Deleted += o.Deleted
+	u.Tombstones += o.Tombstones
+}
+
+// spaceAmpBucket is the space usage of the records whose user keys share a
+// prefix.
+type spaceAmpBucket struct {
+	prefix []byte
+	spaceUsage
+}
+
+// spaceAmpIter is an iterator over the point records of a single sstable
+// which remembers the level the sstable resides in.
+type spaceAmpIter struct {
+	level int
+	iter  sstable.Iterator
+	key   *base.InternalKey
+	value []byte
+}
+
+// spaceAmpHeap merges the point records of multiple sstables in internal key
+// order, so that the records for a user key are visited from newest to
+// oldest.
+type spaceAmpHeap struct {
+	cmp   base.Compare
+	items []*spaceAmpIter
+}
+
+func (h *spaceAmpHeap) Len() int { return len(h.items) }
+func (h *spaceAmpHeap) Less(i, j int) bool {
+	return base.InternalCompare(h.cmp, *h.items[i].key, *h.items[j].key) < 0
+}
+func (h *spaceAmpHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *spaceAmpHeap) Push(x interface{}) { h.items = append(h.items, x.(*spaceAmpIter)) }
+func (h *spaceAmpHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// spaceAmpFragment is a fragment of the range tombstones in the DB, along
+// with the largest sequence number of the tombstones covering it.
+type spaceAmpFragment struct {
+	start, end []byte
+	seqNum     uint64
+}
+
+func (d *dbT) runSpaceAmp(cmd *cobra.Command, args []string) {
+	dirname := args[0]
+	db, err := d.openDB(dirname)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+	defer d.closeDB(db)
+
+	cmp := base.DefaultComparer
+	if d.opts.Comparer != nil {
+		cmp = d.opts.Comparer
+	}
+	d.fmtKey.setForComparer(cmp.Name, d.comparers)
+
+	if err := d.spaceAmp(db, dirname, cmp); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+	}
+}
+
+func (d *dbT) spaceAmp(db *pebble.DB, dirname string, cmp *base.Comparer) error {
+	tables, err := db.SSTables()
+	if err != nil {
+		return err
+	}
+
+	levels := make([]spaceUsage, manifest.NumLevels)
+	var buckets []spaceAmpBucket
+	bucketIndex := make(map[string]int)
+	bucketFor := func(userKey []byte) *spaceUsage {
+		if d.prefixLen <= 0 {
+			return &spaceUsage{}
+		}
+		prefix := userKey
+		if len(prefix) > d.prefixLen {
+			prefix = prefix[:d.prefixLen]
+		}
+		i, ok := bucketIndex[string(prefix)]
+		if !ok {
+			i = len(buckets)
+			bucketIndex[string(prefix)] = i
+			buckets = append(buckets, spaceAmpBucket{prefix: append([]byte(nil), prefix...)})
+		}
+		return &buckets[i].spaceUsage
+	}
+
+	// Open all of the sstables, loading their range tombstones up front and
+	// positioning their point iterators at the first record.
+	h := &spaceAmpHeap{cmp: cmp.Compare}
+	var readers []*sstable.Reader
+	defer func() {
+		for _, it := range h.items {
+			_ = it.iter.Close()
+		}
+		for _, r := range readers {
+			_ = r.Close()
+		}
+	}()
+	var tombstones []rangedel.Tombstone
+	for level := range tables {
+		for _, t := range tables[level] {
+			levels[level].Files++
+			levels[level].Physical += t.Size
+
+			// Only the keys of the backing sstable of a virtual sstable which
+			// lie within the bounds of the virtual sstable are included.
+			fileNum := t.FileNum
+			var lower, upper []byte
+			if t.BackingFileNum != 0 {
+				fileNum = t.BackingFileNum
+				lower = t.Smallest.UserKey
+				if t.Largest.Trailer == base.InternalKeyRangeDeleteSentinel {
+					upper = t.Largest.UserKey
+				}
+			}
+			path := base.MakeFilename(d.opts.FS, dirname, base.FileTypeTable, fileNum)
+			f, err := d.opts.FS.Open(path)
+			if err != nil {
+				return err
+			}
+			r, err := sstable.NewReader(f, sstable.ReaderOptions{Comparer: cmp}, d.mergers, d.comparers)
+			if err != nil {
+				_ = f.Close()
+				return err
+			}
+			readers = append(readers, r)
+
+			rangeDelIter, err := r.NewRangeDelIter()
+			if err != nil {
+				return err
+			}
+			if rangeDelIter != nil {
+				for key, value := rangeDelIter.First(); key != nil; key, value = rangeDelIter.Next() {
+					// The range-del block is prefix compressed, so the keys need to be
+					// copied before the iterator is advanced.
+					start := key.Clone()
+					end := append([]byte(nil), value...)
+					if lower != nil && cmp.Compare(start.UserKey, lower) < 0 {
+						start.UserKey = lower
+					}
+					if upper != nil && cmp.Compare(end, upper) > 0 {
+						end = upper
+					}
+					if cmp.Compare(start.UserKey, end) >= 0 {
+						continue
+					}
+					tombstones = append(tombstones, rangedel.Tombstone{Start: start, End: end})
+					size := uint64(start.Size() + len(end))
+					levels[level].Tombstones += size
+					bucketFor(start.UserKey).Tombstones += size
+				}
+				if err := rangeDelIter.Close(); err != nil {
+					return err
+				}
+			}
+
+			iter, err := r.NewIter(lower, upper)
+			if err != nil {
+				return err
+			}
+			it := &spaceAmpIter{level: level, iter: iter}
+			if lower != nil {
+				it.key, it.value = iter.SeekGE(lower)
+			} else {
+				it.key, it.value = iter.First()
+			}
+			if it.key != nil {
+				h.items = append(h.items, it)
+			} else if err := iter.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	heap.Init(h)
+
+	// Fragment the range tombstones from all of the sstables so that the
+	// tombstones covering a point record can be found by walking the fragments
+	// in parallel with the point records.
+	var fragments []spaceAmpFragment
+	rangedel.Sort(cmp.Compare, tombstones)
+	frag := rangedel.Fragmenter{
+		Cmp: cmp.Compare,
+		Emit: func(fragmented []rangedel.Tombstone) {
+			fragments = append(fragments, spaceAmpFragment{
+				start:  fragmented[0].Start.UserKey,
+				end:    fragmented[0].End,
+				seqNum: fragmented[0].Start.SeqNum(),
+			})
+		},
+	}
+	for _, t := range tombstones {
+		frag.Add(t.Start, t.End)
+	}
+	frag.Finish()
+
+	var prevKey []byte
+	// hidden is set once a record for the current user key hides all of the
+	// older records for the key. hiddenBy is the kind of that record.
+	var hidden bool
+	var hiddenBy base.InternalKeyKind
+	for h.Len() > 0 {
+		it := h.items[0]
+		key := it.key
+		size := uint64(key.Size() + len(it.value))
+
+		if prevKey == nil || cmp.Compare(prevKey, key.UserKey) != 0 {
+			prevKey = append(prevKey[:0], key.UserKey...)
+			hidden = false
+		}
+		for len(fragments) > 0 && cmp.Compare(fragments[0].end, key.UserKey) <= 0 {
+			fragments = fragments[1:]
+		}
+		rangeDeleted := len(fragments) > 0 &&
+			cmp.Compare(fragments[0].start, key.UserKey) <= 0 &&
+			fragments[0].seqNum > key.SeqNum()
+
+		kind := key.Kind()
+		var u spaceUsage
+		switch {
+		case hidden && hiddenBy == base.InternalKeyKindSet:
+			u.Shadowed = size
+		case hidden, rangeDeleted:
+			u.Deleted = size
+		case kind == base.InternalKeyKindDelete, kind == base.InternalKeyKindSingleDelete:
+			u.Tombstones = size
+		default:
+			u.Live = size
+		}
+		if !hidden {
+			switch kind {
+			case base.InternalKeyKindMerge:
+				// A merge operand does not hide older records, which are
+				// combined with it on read.
+			default:
+				hidden, hiddenBy = true, kind
+			}
+			if rangeDeleted {
+				hidden, hiddenBy = true, base.InternalKeyKindRangeDelete
+			}
+		}
+		levels[it.level].update(u)
+		bucketFor(key.UserKey).update(u)
+
+		if it.key, it.value = it.iter.Next(); it.key != nil {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+			if err := it.iter.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	var total spaceUsage
+	for i := range levels {
+		total.update(levels[i])
+	}
+	all := append(levels, total)
+
+	tw := tabwriter.NewWriter(stdout, 2, 1, 4, ' ', 0)
+	fmt.Fprintln(tw, "\tL0\tL1\tL2\tL3\tL4\tL5\tL6\tTOTAL")
+	row := func(name string, fn func(u *spaceUsage) interface{}) {
+		fmt.Fprintf(tw, "%s", name)
+		for i := range all {
+			fmt.Fprintf(tw, "\t%v", fn(&all[i]))
+		}
+		fmt.Fprintln(tw)
+	}
+	row("count", func(u *spaceUsage) interface{} { return u.Files })
+	row("physical", func(u *spaceUsage) interface{} { return humanize.Uint64(u.Physical) })
+	row("logical", func(u *spaceUsage) interface{} { return humanize.Uint64(u.logical()) })
+	row("  live", func(u *spaceUsage) interface{} { return humanize.Uint64(u.Live) })
+	row("  shadowed", func(u *spaceUsage) interface{} { return humanize.Uint64(u.Shadowed) })
+	row("  deleted", func(u *spaceUsage) interface{} { return humanize.Uint64(u.Deleted) })
+	row("  tombstones", func(u *spaceUsage) interface{} { return humanize.Uint64(u.Tombstones) })
+	row("garbage", func(u *spaceUsage) interface{} { return humanize.Uint64(u.garbage()) })
+	row("space-amp", func(u *spaceUsage) interface{} { return u.amp() })
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if d.prefixLen <= 0 {
+		return nil
+	}
+	// Range tombstones are loaded before the point records are visited, so the
+	// buckets are not necessarily created in key order.
+	sort.Slice(buckets, func(i, j int) bool {
+		return cmp.Compare(buckets[i].prefix, buckets[j].prefix) < 0
+	})
+	fmt.Fprintln(stdout)
+	tw = tabwriter.NewWriter(stdout, 2, 1, 4, ' ', 0)
+	fmt.Fprintln(tw, "prefix\tlogical\tlive\tshadowed\tdeleted\ttombstones\tspace-amp")
+	for i := range buckets {
+		b := &buckets[i]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.fmtKey.fn(b.prefix),
+			humanize.Uint64(b.logical()), humanize.Uint64(b.Live),
+			humanize.Uint64(b.Shadowed), humanize.Uint64(b.Deleted),
+			humanize.Uint64(b.Tombstones), b.amp())
+	}
+	return tw.Flush()
+}
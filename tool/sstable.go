@@ -25,6 +25,7 @@ import (
 // and the commands themselves.
 type sstableT struct {
 	Root       *cobra.Command
+	Build      *cobra.Command
 	Check      *cobra.Command
 	Layout     *cobra.Command
 	Properties *cobra.Command
@@ -44,6 +45,14 @@ type sstableT struct {
 	filter   key
 	count    int64
 	verbose  bool
+	build    struct {
+		format         string
+		targetFileSize int64
+		compression    string
+		bloomBits      int
+		concurrency    int
+		hex            bool
+	}
 }
 
 func newSSTable(
@@ -61,6 +70,24 @@ func newSSTable(
 		Use:   "sstable",
 		Short: "sstable introspection tools",
 	}
+	s.Build = &cobra.Command{
+		Use:   "build <input> <dir>",
+		Short: "build sstables from sorted input",
+		Long: `
+Build ingest-ready sstables in the specified directory from a file of sorted
+key/value records. The records must be in strictly increasing key order. The
+input is split into sstables of approximately --target-file-size bytes
+(measured before compression) which are built in parallel. The sstables are
+named 000001.sst, 000002.sst, etc. in key order.
+
+With --format=csv each line of the input holds a key and value separated by a
+comma. With --format=json the input is a sequence of JSON objects, such as
+newline-delimited JSON, of the form {"key": "...", "value": "..."}. If --hex is
+specified the keys and values are hex-decoded.
+`,
+		Args: cobra.ExactArgs(2),
+		Run:  s.runBuild,
+	}
 	s.Check = &cobra.Command{
 		Use:   "check <sstables>",
 		Short: "verify checksums and metadata",
@@ -110,9 +137,23 @@ inclusive-inclusive range specified by --start and --end.
 		Run:  s.runSpace,
 	}
 
-	s.Root.AddCommand(s.Check, s.Layout, s.Properties, s.Scan, s.Space)
+	s.Root.AddCommand(s.Build, s.Check, s.Layout, s.Properties, s.Scan, s.Space)
 	s.Root.PersistentFlags().BoolVarP(&s.verbose, "verbose", "v", false, "verbose output")
 
+	s.Build.Flags().StringVar(
+		&s.build.format, "format", "csv", "input format (csv or json)")
+	s.Build.Flags().Int64Var(
+		&s.build.targetFileSize, "target-file-size", 64<<20, "target sstable size in bytes")
+	s.Build.Flags().StringVar(
+		&s.build.compression, "compression", "snappy", "block compression (none or snappy)")
+	s.Build.Flags().IntVar(
+		&s.build.bloomBits, "bloom-bits", 10, "bloom filter bits per key (0 disables filters)")
+	s.Build.Flags().IntVar(
+		&s.build.concurrency, "concurrency", 1, "number of sstables to build in parallel")
+	s.Build.Flags().BoolVar(
+		&s.build.hex, "hex", false, "hex-decode the input keys and values")
+	s.Build.Flags().Var(
+		&s.fmtKey, "key", "key formatter")
 	s.Check.Flags().Var(
 		&s.fmtKey, "key", "key formatter")
 	s.Layout.Flags().Var(
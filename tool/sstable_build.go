@@ -0,0 +1,192 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package tool
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/spf13/cobra"
+)
+
+// buildRecord is the format of the records in JSON input to sstable build.
+type buildRecord struct {
+	Key   *string `json:"key"`
+	Value string  `json:"value"`
+}
+
+// buildReader reads the records to add to the sstables built by sstable
+// build.
+type buildReader interface {
+	// Next returns the next key and value, or io.EOF if the input is
+	// exhausted.
+	Next() (key, value []byte, err error)
+}
+
+type csvBuildReader struct {
+	r *csv.Reader
+}
+
+func (r *csvBuildReader) Next() (key, value []byte, err error) {
+	rec, err := r.r.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(rec[0]), []byte(rec[1]), nil
+}
+
+type jsonBuildReader struct {
+	d     *json.Decoder
+	count int
+}
+
+func (r *jsonBuildReader) Next() (key, value []byte, err error) {
+	var rec buildRecord
+	if err := r.d.Decode(&rec); err != nil {
+		return nil, nil, err
+	}
+	r.count++
+	if rec.Key == nil {
+		return nil, nil, errors.Errorf("record %d: missing key", r.count)
+	}
+	return []byte(*rec.Key), []byte(rec.Value), nil
+}
+
+func (s *sstableT) newBuildReader(f io.Reader) (buildReader, error) {
+	switch s.build.format {
+	case "csv":
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = 2
+		r.ReuseRecord = true
+		return &csvBuildReader{r: r}, nil
+	case "json":
+		return &jsonBuildReader{d: json.NewDecoder(f)}, nil
+	default:
+		return nil, errors.Errorf("unknown input format %q", errors.Safe(s.build.format))
+	}
+}
+
+func (s *sstableT) buildWriterOptions() (sstable.WriterOptions, error) {
+	o := sstable.WriterOptions{
+		Comparer:    s.opts.Comparer,
+		TableFormat: s.opts.TableFormat,
+	}
+	if s.opts.Merger != nil {
+		o.MergerName = s.opts.Merger.Name
+	}
+	switch s.build.compression {
+	case "none":
+		o.Compression = sstable.NoCompression
+	case "snappy":
+		o.Compression = sstable.SnappyCompression
+	default:
+		return o, errors.Errorf("unknown compression %q", errors.Safe(s.build.compression))
+	}
+	if s.build.bloomBits > 0 {
+		o.FilterPolicy = bloom.FilterPolicy(s.build.bloomBits)
+		o.FilterType = sstable.TableFilter
+	}
+	return o, nil
+}
+
+func (s *sstableT) runBuild(cmd *cobra.Command, args []string) {
+	input, dir := args[0], args[1]
+	if err := s.runBuildInternal(input, dir); err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+	}
+}
+
+func (s *sstableT) runBuildInternal(input, dir string) error {
+	writerOpts, err := s.buildWriterOptions()
+	if err != nil {
+		return err
+	}
+	if s.opts.Comparer != nil {
+		s.fmtKey.setForComparer(s.opts.Comparer.Name, s.comparers)
+	}
+
+	f, err := s.opts.FS.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := s.newBuildReader(f)
+	if err != nil {
+		return err
+	}
+	if err := s.opts.FS.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	filename := func(i int) string {
+		return s.opts.FS.PathJoin(dir, fmt.Sprintf("%06d.sst", i+1))
+	}
+	w := sstable.NewBulkWriter(sstable.BulkWriterOptions{
+		WriterOptions:  writerOpts,
+		TargetFileSize: s.build.targetFileSize,
+		Concurrency:    s.build.concurrency,
+		Create: func(i int) (vfs.File, error) {
+			return s.opts.FS.Create(filename(i))
+		},
+	})
+
+	for {
+		key, value, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && s.build.hex {
+			key, err = hexDecode(key)
+			if err == nil {
+				value, err = hexDecode(value)
+			}
+		}
+		if err == nil {
+			err = w.Set(key, value)
+		}
+		if err != nil {
+			_ = w.Close()
+			return errors.Wrapf(err, "%s", input)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	metas, err := w.Metadata()
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	var records uint64
+	for i, m := range metas {
+		fmt.Fprintf(stdout, "%s: %s, %d %s [%s-%s]\n", filename(i),
+			humanize.Uint64(m.Size), m.Properties.NumEntries,
+			makePlural("record", int64(m.Properties.NumEntries)),
+			s.fmtKey.fn(m.SmallestPoint.UserKey), s.fmtKey.fn(m.LargestPoint.UserKey))
+		total += m.Size
+		records += m.Properties.NumEntries
+	}
+	fmt.Fprintf(stdout, "built %d %s (%s) containing %d %s\n",
+		len(metas), makePlural("sstable", int64(len(metas))), humanize.Uint64(total),
+		records, makePlural("record", int64(records)))
+	return nil
+}
+
+func hexDecode(b []byte) ([]byte, error) {
+	d, err := hex.DecodeString(string(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid hex %q", b)
+	}
+	return d, nil
+}
@@ -25,6 +25,10 @@ type walT struct {
 	opts     *pebble.Options
 	fmtKey   keyFormatter
 	fmtValue valueFormatter
+	start    key
+	end      key
+	minSeq   uint64
+	maxSeq   uint64
 
 	defaultComparer string
 	comparers       sstable.Comparers
@@ -48,7 +52,12 @@ func newWAL(opts *pebble.Options, comparers sstable.Comparers, defaultComparer s
 		Use:   "dump <wal-files>",
 		Short: "print WAL contents",
 		Long: `
-Print the contents of the WAL files.
+Print the contents of the WAL files. Each batch is printed with its offset,
+length, sequence number and count, followed by its operations. The --start and
+--end flags restrict the output to operations on keys within the range
+[start,end), and the --min-seq and --max-seq flags restrict the output to
+operations with sequence numbers within the inclusive range [min-seq,max-seq].
+Batches without any matching operations are omitted.
 `,
 		Args: cobra.MinimumNArgs(1),
 		Run:  w.runDump,
@@ -61,12 +70,24 @@ Print the contents of the WAL files.
 		&w.fmtKey, "key", "key formatter")
 	w.Dump.Flags().Var(
 		&w.fmtValue, "value", "value formatter")
+	w.Dump.Flags().Var(
+		&w.start, "start", "start key for the range")
+	w.Dump.Flags().Var(
+		&w.end, "end", "end key for the range")
+	w.Dump.Flags().Uint64Var(
+		&w.minSeq, "min-seq", 0, "minimum sequence number (inclusive)")
+	w.Dump.Flags().Uint64Var(
+		&w.maxSeq, "max-seq", 0, "maximum sequence number (inclusive, 0 is unlimited)")
 	return w
 }
 
 func (w *walT) runDump(cmd *cobra.Command, args []string) {
 	w.fmtKey.setForComparer(w.defaultComparer, w.comparers)
 	w.fmtValue.setForComparer(w.defaultComparer, w.comparers)
+	cmp := base.DefaultComparer.Compare
+	if c := w.comparers[w.defaultComparer]; c != nil {
+		cmp = c.Compare
+	}
 
 	for _, arg := range args {
 		func() {
@@ -89,7 +110,7 @@ func (w *walT) runDump(cmd *cobra.Command, args []string) {
 			fmt.Fprintf(stdout, "%s\n", arg)
 
 			var b pebble.Batch
-			var buf bytes.Buffer
+			var buf, ops bytes.Buffer
 			rr := record.NewReader(f, fileNum)
 			for {
 				offset := rr.Offset()
@@ -119,31 +140,82 @@ func (w *walT) runDump(cmd *cobra.Command, args []string) {
 					fmt.Fprintf(stdout, "corrupt log file %q: %v", arg, err)
 					return
 				}
-				fmt.Fprintf(stdout, "%d(%d) seq=%d count=%d\n",
-					offset, len(b.Repr()), b.SeqNum(), b.Count())
+				ops.Reset()
+				matched := false
+				seqNum := b.SeqNum()
 				for r := b.Reader(); ; {
 					kind, ukey, value, ok := r.Next()
 					if !ok {
 						break
 					}
-					fmt.Fprintf(stdout, "    %s(", kind)
+					opSeqNum := seqNum
+					if kind != base.InternalKeyKindLogData {
+						// LogData records are not assigned a sequence number.
+						seqNum++
+					}
+					if !w.matches(cmp, kind, opSeqNum, ukey, value) {
+						continue
+					}
+					matched = true
+					fmt.Fprintf(&ops, "    %s(", kind)
 					switch kind {
 					case base.InternalKeyKindDelete:
-						fmt.Fprintf(stdout, "%s", w.fmtKey.fn(ukey))
+						fmt.Fprintf(&ops, "%s", w.fmtKey.fn(ukey))
 					case base.InternalKeyKindSet:
-						fmt.Fprintf(stdout, "%s,%s", w.fmtKey.fn(ukey), w.fmtValue.fn(ukey, value))
+						fmt.Fprintf(&ops, "%s,%s", w.fmtKey.fn(ukey), w.fmtValue.fn(ukey, value))
 					case base.InternalKeyKindMerge:
-						fmt.Fprintf(stdout, "%s,%s", w.fmtKey.fn(ukey), w.fmtValue.fn(ukey, value))
+						fmt.Fprintf(&ops, "%s,%s", w.fmtKey.fn(ukey), w.fmtValue.fn(ukey, value))
 					case base.InternalKeyKindLogData:
-						fmt.Fprintf(stdout, "<%d>", len(value))
+						fmt.Fprintf(&ops, "<%d>", len(value))
 					case base.InternalKeyKindSingleDelete:
-						fmt.Fprintf(stdout, "%s", w.fmtKey.fn(ukey))
+						fmt.Fprintf(&ops, "%s", w.fmtKey.fn(ukey))
 					case base.InternalKeyKindRangeDelete:
-						fmt.Fprintf(stdout, "%s,%s", w.fmtKey.fn(ukey), w.fmtKey.fn(value))
+						fmt.Fprintf(&ops, "%s,%s", w.fmtKey.fn(ukey), w.fmtKey.fn(value))
 					}
-					fmt.Fprintf(stdout, ")\n")
+					fmt.Fprintf(&ops, ")\n")
 				}
+				if !matched && w.filtering() {
+					continue
+				}
+				fmt.Fprintf(stdout, "%d(%d) seq=%d count=%d\n",
+					offset, len(b.Repr()), b.SeqNum(), b.Count())
+				_, _ = stdout.Write(ops.Bytes())
 			}
 		}()
 	}
 }
+
+// filtering returns true if the output of the dump is restricted to a key or
+// sequence number range.
+func (w *walT) filtering() bool {
+	return w.start != nil || w.end != nil || w.minSeq != 0 || w.maxSeq != 0
+}
+
+// matches returns true if the batch operation with the specified kind,
+// sequence number, key and value lies within the key and sequence number
+// ranges specified for the dump. Range deletions match if they overlap the key
+// range. LogData operations have no key and never match a key range.
+func (w *walT) matches(
+	cmp base.Compare, kind base.InternalKeyKind, seqNum uint64, ukey, value []byte,
+) bool {
+	if seqNum < w.minSeq || (w.maxSeq != 0 && seqNum > w.maxSeq) {
+		return false
+	}
+	if w.start == nil && w.end == nil {
+		return true
+	}
+	switch kind {
+	case base.InternalKeyKindLogData:
+		return false
+	case base.InternalKeyKindRangeDelete:
+		// The range tombstone covers [ukey,value).
+		if w.start != nil && cmp(value, w.start) <= 0 {
+			return false
+		}
+	default:
+		if w.start != nil && cmp(ukey, w.start) < 0 {
+			return false
+		}
+	}
+	return w.end == nil || cmp(ukey, w.end) < 0
+}
@@ -0,0 +1,493 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// traceMagic begins every trace, followed by the trace format version.
+const (
+	traceMagic   = "pebble-trace"
+	traceVersion = 1
+)
+
+// traceKind is the kind of a trace record. Each record is encoded as the kind
+// followed by the uvarint-encoded number of nanoseconds elapsed since the
+// previous record, followed by the fields of the record. Byte slice fields are
+// encoded as the uvarint-encoded length of the slice plus one, followed by the
+// contents of the slice, so that nil and empty slices are distinguished.
+type traceKind uint8
+
+const (
+	traceKindInvalid traceKind = iota
+	// traceKindBatch: flags (traceBatchSync), batch repr.
+	traceKindBatch
+	// traceKindGet: key.
+	traceKindGet
+	// traceKindNewIter: iterator ID, iterator options.
+	traceKindNewIter
+	// traceKindSetOptions: iterator ID, iterator options.
+	traceKindSetOptions
+	// traceKindSetBounds: iterator ID, lower bound, upper bound.
+	traceKindSetBounds
+	// traceKindSeekGE, traceKindSeekPrefixGE, traceKindSeekLT: iterator ID,
+	// key.
+	traceKindSeekGE
+	traceKindSeekPrefixGE
+	traceKindSeekLT
+	// traceKindFirst, traceKindLast, traceKindNext, traceKindPrev,
+	// traceKindClose: iterator ID.
+	traceKindFirst
+	traceKindLast
+	traceKindNext
+	traceKindPrev
+	traceKindClose
+)
+
+const traceBatchSync = 1
+
+// Flags of the encoded iterator options.
+const (
+	traceIterPrefixIteration = 1 << iota
+)
+
+// A TraceRecorder records the operations performed on a DB to a compact trace
+// which can be replayed against another DB with ReplayTrace (see
+// Options.TraceRecorder). The trace records committed batches, point lookups,
+// and the creation, positioning and closing of iterators, along with the time
+// at which each operation was performed. Operations on snapshots, indexed
+// batches and other keyspaces of the DB are not recorded, and neither are the
+// values read.
+//
+// The trace is buffered; Flush must be called to write out any buffered
+// records once the operations of interest have been performed.
+type TraceRecorder struct {
+	mu struct {
+		sync.Mutex
+		w *bufio.Writer
+		// buf holds the encoding of the record being written.
+		buf []byte
+		// last is the time of the most recent record.
+		last       time.Time
+		nextIterID uint64
+		// err is the first error encountered writing the trace. Once set, no
+		// further records are written.
+		err error
+	}
+}
+
+// NewTraceRecorder creates a TraceRecorder which writes a trace to w.
+func NewTraceRecorder(w io.Writer) *TraceRecorder {
+	r := &TraceRecorder{}
+	r.mu.w = bufio.NewWriter(w)
+	r.mu.last = time.Now()
+	r.mu.buf = append(r.mu.buf, traceMagic...)
+	r.mu.buf = append(r.mu.buf, traceVersion)
+	_, r.mu.err = r.mu.w.Write(r.mu.buf)
+	return r
+}
+
+// Flush writes any buffered records to the underlying writer, returning the
+// first error encountered writing the trace.
+func (r *TraceRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mu.err == nil {
+		r.mu.err = r.mu.w.Flush()
+	}
+	return r.mu.err
+}
+
+// begin starts a record of the specified kind, returning false if the trace
+// has encountered an error. On success, r.mu is held until end is called.
+func (r *TraceRecorder) begin(kind traceKind) bool {
+	r.mu.Lock()
+	if r.mu.err != nil {
+		r.mu.Unlock()
+		return false
+	}
+	now := time.Now()
+	elapsed := now.Sub(r.mu.last)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	r.mu.last = now
+	r.mu.buf = append(r.mu.buf[:0], byte(kind))
+	r.mu.buf = appendTraceUvarint(r.mu.buf, uint64(elapsed))
+	return true
+}
+
+// end writes the record started by begin, and releases r.mu.
+func (r *TraceRecorder) end() {
+	_, r.mu.err = r.mu.w.Write(r.mu.buf)
+	r.mu.Unlock()
+}
+
+func (r *TraceRecorder) batch(b *Batch, sync bool) {
+	if !r.begin(traceKindBatch) {
+		return
+	}
+	var flags uint64
+	if sync {
+		flags |= traceBatchSync
+	}
+	r.mu.buf = appendTraceUvarint(r.mu.buf, flags)
+	r.mu.buf = appendTraceBytes(r.mu.buf, b.Repr())
+	r.end()
+}
+
+func (r *TraceRecorder) get(key []byte) {
+	if !r.begin(traceKindGet) {
+		return
+	}
+	r.mu.buf = appendTraceBytes(r.mu.buf, key)
+	r.end()
+}
+
+// newIter records the creation of an iterator, returning the ID identifying
+// the iterator in subsequent records.
+func (r *TraceRecorder) newIter(o *IterOptions) uint64 {
+	if !r.begin(traceKindNewIter) {
+		return 0
+	}
+	r.mu.nextIterID++
+	id := r.mu.nextIterID
+	r.mu.buf = appendTraceUvarint(r.mu.buf, id)
+	r.mu.buf = appendTraceIterOptions(r.mu.buf, o)
+	r.end()
+	return id
+}
+
+func (r *TraceRecorder) iterSetOptions(id uint64, o *IterOptions) {
+	if !r.begin(traceKindSetOptions) {
+		return
+	}
+	r.mu.buf = appendTraceUvarint(r.mu.buf, id)
+	r.mu.buf = appendTraceIterOptions(r.mu.buf, o)
+	r.end()
+}
+
+func (r *TraceRecorder) iterSetBounds(id uint64, lower, upper []byte) {
+	if !r.begin(traceKindSetBounds) {
+		return
+	}
+	r.mu.buf = appendTraceUvarint(r.mu.buf, id)
+	r.mu.buf = appendTraceBytes(r.mu.buf, lower)
+	r.mu.buf = appendTraceBytes(r.mu.buf, upper)
+	r.end()
+}
+
+// iterSeek records a seek of the specified kind.
+func (r *TraceRecorder) iterSeek(kind traceKind, id uint64, key []byte) {
+	if !r.begin(kind) {
+		return
+	}
+	r.mu.buf = appendTraceUvarint(r.mu.buf, id)
+	r.mu.buf = appendTraceBytes(r.mu.buf, key)
+	r.end()
+}
+
+// iterOp records an iterator operation of the specified kind which takes no
+// arguments.
+func (r *TraceRecorder) iterOp(kind traceKind, id uint64) {
+	if !r.begin(kind) {
+		return
+	}
+	r.mu.buf = appendTraceUvarint(r.mu.buf, id)
+	r.end()
+}
+
+func appendTraceUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendTraceBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return appendTraceUvarint(buf, 0)
+	}
+	buf = appendTraceUvarint(buf, uint64(len(b))+1)
+	return append(buf, b...)
+}
+
+// appendTraceIterOptions encodes the options of an iterator which affect the
+// keys it returns. The TableFilter and context are not recorded.
+func appendTraceIterOptions(buf []byte, o *IterOptions) []byte {
+	var flags uint64
+	var keyTypes IterKeyType
+	var timestamp uint64
+	if o != nil {
+		if o.PrefixIteration {
+			flags |= traceIterPrefixIteration
+		}
+		keyTypes = o.KeyTypes
+		timestamp = o.Timestamp
+	}
+	buf = appendTraceUvarint(buf, flags)
+	buf = appendTraceUvarint(buf, uint64(keyTypes))
+	buf = appendTraceUvarint(buf, timestamp)
+	buf = appendTraceBytes(buf, o.GetLowerBound())
+	return appendTraceBytes(buf, o.GetUpperBound())
+}
+
+// traceReader decodes the records of a trace.
+type traceReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (t *traceReader) uvarint() uint64 {
+	if t.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		t.err = errors.Wrap(noEOF(err), "pebble: corrupt trace")
+	}
+	return v
+}
+
+func (t *traceReader) bytes() []byte {
+	n := t.uvarint()
+	if t.err != nil || n == 0 {
+		return nil
+	}
+	b := make([]byte, n-1)
+	if _, err := io.ReadFull(t.r, b); err != nil {
+		t.err = errors.Wrap(noEOF(err), "pebble: corrupt trace")
+		return nil
+	}
+	return b
+}
+
+func (t *traceReader) iterOptions() *IterOptions {
+	flags := t.uvarint()
+	o := &IterOptions{
+		PrefixIteration: flags&traceIterPrefixIteration != 0,
+		KeyTypes:        IterKeyType(t.uvarint()),
+		Timestamp:       t.uvarint(),
+	}
+	o.LowerBound = t.bytes()
+	o.UpperBound = t.bytes()
+	return o
+}
+
+// noEOF converts io.EOF, which is only expected between records, to
+// io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// TraceReplayOptions configures ReplayTrace.
+type TraceReplayOptions struct {
+	// Speed is the rate at which the trace is replayed relative to the rate at
+	// which it was recorded: 1 reproduces the original timing of the
+	// operations, 2 performs them twice as fast, and so on. A Speed of 0
+	// performs the operations as fast as possible.
+	Speed float64
+}
+
+// TraceReplayStats summarizes the operations performed by ReplayTrace.
+type TraceReplayStats struct {
+	// The number of batches applied.
+	Batches int64
+	// The number of point lookups performed.
+	Gets int64
+	// The number of iterators created, and the number of operations performed
+	// on them, including their creation.
+	Iterators   int64
+	IteratorOps int64
+	// The duration of the replay.
+	Duration time.Duration
+}
+
+// ReplayTrace replays a trace recorded by a TraceRecorder against d, which is
+// typically a fresh DB. The operations in the trace are performed
+// sequentially, in the order in which they were recorded, waiting as
+// necessary to reproduce the timing of the trace at the rate specified by
+// opts.Speed. Iterators left open by the trace are closed once the replay
+// completes.
+func ReplayTrace(r io.Reader, d *DB, opts TraceReplayOptions) (TraceReplayStats, error) {
+	var stats TraceReplayStats
+	t := &traceReader{r: bufio.NewReader(r)}
+
+	var header [len(traceMagic) + 1]byte
+	if _, err := io.ReadFull(t.r, header[:]); err != nil {
+		return stats, errors.Wrap(noEOF(err), "pebble: corrupt trace")
+	}
+	if !bytes.Equal(header[:len(traceMagic)], []byte(traceMagic)) {
+		return stats, errors.New("pebble: not a trace")
+	}
+	if v := header[len(traceMagic)]; v != traceVersion {
+		return stats, errors.Errorf("pebble: unsupported trace version %d", errors.Safe(v))
+	}
+
+	iters := make(map[uint64]*Iterator)
+	defer func() {
+		for _, iter := range iters {
+			_ = iter.Close()
+		}
+	}()
+	getIter := func(id uint64) (*Iterator, error) {
+		iter := iters[id]
+		if iter == nil {
+			return nil, errors.Errorf("pebble: corrupt trace: unknown iterator %d", errors.Safe(id))
+		}
+		stats.IteratorOps++
+		return iter, nil
+	}
+
+	start := time.Now()
+	var offset time.Duration
+	for {
+		kind, err := t.r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, err
+		}
+		offset += time.Duration(t.uvarint())
+		if t.err != nil {
+			return stats, t.err
+		}
+		if opts.Speed > 0 {
+			if wait := time.Duration(float64(offset)/opts.Speed) - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		switch traceKind(kind) {
+		case traceKindBatch:
+			flags := t.uvarint()
+			repr := t.bytes()
+			if t.err != nil {
+				return stats, t.err
+			}
+			b := d.NewBatch()
+			if err := b.SetRepr(repr); err != nil {
+				return stats, err
+			}
+			wo := NoSync
+			if flags&traceBatchSync != 0 {
+				wo = Sync
+			}
+			if err := d.Apply(b, wo); err != nil {
+				return stats, err
+			}
+			_ = b.Close()
+			stats.Batches++
+
+		case traceKindGet:
+			key := t.bytes()
+			if t.err != nil {
+				return stats, t.err
+			}
+			_, closer, err := d.Get(key)
+			if err == nil {
+				err = closer.Close()
+			}
+			if err != nil && err != ErrNotFound {
+				return stats, err
+			}
+			stats.Gets++
+
+		case traceKindNewIter:
+			id := t.uvarint()
+			o := t.iterOptions()
+			if t.err != nil {
+				return stats, t.err
+			}
+			iters[id] = d.NewIter(o)
+			stats.Iterators++
+			stats.IteratorOps++
+
+		case traceKindSetOptions:
+			id := t.uvarint()
+			o := t.iterOptions()
+			if t.err != nil {
+				return stats, t.err
+			}
+			iter, err := getIter(id)
+			if err != nil {
+				return stats, err
+			}
+			iter.SetOptions(o)
+
+		case traceKindSetBounds:
+			id := t.uvarint()
+			lower, upper := t.bytes(), t.bytes()
+			if t.err != nil {
+				return stats, t.err
+			}
+			iter, err := getIter(id)
+			if err != nil {
+				return stats, err
+			}
+			iter.SetBounds(lower, upper)
+
+		case traceKindSeekGE, traceKindSeekPrefixGE, traceKindSeekLT:
+			id := t.uvarint()
+			key := t.bytes()
+			if t.err != nil {
+				return stats, t.err
+			}
+			iter, err := getIter(id)
+			if err != nil {
+				return stats, err
+			}
+			switch traceKind(kind) {
+			case traceKindSeekGE:
+				iter.SeekGE(key)
+			case traceKindSeekPrefixGE:
+				iter.SeekPrefixGE(key)
+			case traceKindSeekLT:
+				iter.SeekLT(key)
+			}
+
+		case traceKindFirst, traceKindLast, traceKindNext, traceKindPrev, traceKindClose:
+			id := t.uvarint()
+			if t.err != nil {
+				return stats, t.err
+			}
+			iter, err := getIter(id)
+			if err != nil {
+				return stats, err
+			}
+			switch traceKind(kind) {
+			case traceKindFirst:
+				iter.First()
+			case traceKindLast:
+				iter.Last()
+			case traceKindNext:
+				iter.Next()
+			case traceKindPrev:
+				iter.Prev()
+			case traceKindClose:
+				delete(iters, id)
+				if err := iter.Close(); err != nil {
+					return stats, err
+				}
+			}
+
+		default:
+			return stats, errors.Errorf("pebble: corrupt trace: unknown record kind %d", errors.Safe(kind))
+		}
+	}
+
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
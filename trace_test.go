@@ -0,0 +1,138 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func dumpDB(t *testing.T, d *DB) string {
+	var buf bytes.Buffer
+	iter := d.NewIter(nil)
+	for valid := iter.First(); valid; valid = iter.Next() {
+		fmt.Fprintf(&buf, "%s:%s\n", iter.Key(), iter.Value())
+	}
+	require.NoError(t, iter.Close())
+	return buf.String()
+}
+
+func TestTraceRecordReplay(t *testing.T) {
+	var trace bytes.Buffer
+	rec := NewTraceRecorder(&trace)
+	src, err := Open("", &Options{FS: vfs.NewMem(), TraceRecorder: rec})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprint(i)), nil))
+	}
+	b := src.NewBatch()
+	require.NoError(t, b.Delete([]byte("k03"), nil))
+	require.NoError(t, b.Merge([]byte("k04"), []byte("x"), nil))
+	require.NoError(t, b.DeleteRange([]byte("k07"), []byte("k09"), nil))
+	require.NoError(t, b.Commit(Sync))
+	require.NoError(t, b.Close())
+	// Empty batches are not recorded.
+	require.NoError(t, src.Apply(src.NewBatch(), nil))
+
+	_, closer, err := src.Get([]byte("k01"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	_, _, err = src.Get([]byte("missing"))
+	require.Equal(t, ErrNotFound, err)
+
+	iter := src.NewIter(&IterOptions{LowerBound: []byte("k02")})
+	require.True(t, iter.SeekGE([]byte("k05")))
+	require.True(t, iter.Next())
+	require.True(t, iter.Prev())
+	iter.SetBounds(nil, []byte("k05"))
+	require.True(t, iter.Last())
+	require.True(t, iter.First())
+	iter.SetOptions(&IterOptions{UpperBound: []byte("k02")})
+	require.True(t, iter.SeekLT([]byte("k02")))
+	require.NoError(t, iter.Close())
+
+	// Operations on snapshots are not recorded.
+	snap := src.NewSnapshot()
+	_, _, err = snap.Get([]byte("missing"))
+	require.Equal(t, ErrNotFound, err)
+	iter = snap.NewIter(nil)
+	iter.First()
+	require.NoError(t, iter.Close())
+	require.NoError(t, snap.Close())
+
+	require.NoError(t, rec.Flush())
+	expected := dumpDB(t, src)
+	require.NoError(t, src.Close())
+
+	dst, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	stats, err := ReplayTrace(bytes.NewReader(trace.Bytes()), dst, TraceReplayOptions{})
+	require.NoError(t, err)
+	require.EqualValues(t, 11, stats.Batches)
+	require.EqualValues(t, 2, stats.Gets)
+	require.EqualValues(t, 1, stats.Iterators)
+	require.EqualValues(t, 10, stats.IteratorOps)
+	require.Equal(t, expected, dumpDB(t, dst))
+	require.NoError(t, dst.Close())
+}
+
+func TestTraceReplaySpeed(t *testing.T) {
+	var trace bytes.Buffer
+	rec := NewTraceRecorder(&trace)
+	src, err := Open("", &Options{FS: vfs.NewMem(), TraceRecorder: rec})
+	require.NoError(t, err)
+	require.NoError(t, src.Set([]byte("a"), nil, nil))
+	const pause = 50 * time.Millisecond
+	time.Sleep(pause)
+	require.NoError(t, src.Set([]byte("b"), nil, nil))
+	require.NoError(t, rec.Flush())
+	require.NoError(t, src.Close())
+
+	for _, speed := range []float64{1, 2} {
+		dst, err := Open("", &Options{FS: vfs.NewMem()})
+		require.NoError(t, err)
+		stats, err := ReplayTrace(bytes.NewReader(trace.Bytes()), dst, TraceReplayOptions{Speed: speed})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, stats.Batches)
+		require.True(t, stats.Duration >= time.Duration(float64(pause)/speed),
+			"replay at speed %.0f took %s", speed, stats.Duration)
+		require.NoError(t, dst.Close())
+	}
+}
+
+func TestTraceReplayCorrupt(t *testing.T) {
+	var trace bytes.Buffer
+	rec := NewTraceRecorder(&trace)
+	src, err := Open("", &Options{FS: vfs.NewMem(), TraceRecorder: rec})
+	require.NoError(t, err)
+	require.NoError(t, src.Set([]byte("a"), []byte("value"), nil))
+	require.NoError(t, rec.Flush())
+	require.NoError(t, src.Close())
+
+	testCases := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("not-a-trace!\x01"), "not a trace"},
+		{[]byte(traceMagic + "\x02"), "unsupported trace version 2"},
+		{trace.Bytes()[:len(trace.Bytes())-1], "corrupt trace: unexpected EOF"},
+		{append([]byte(traceMagic+"\x01"), byte(traceKindNext), 0, 1), "unknown iterator 1"},
+		{append([]byte(traceMagic+"\x01"), 0xff, 0), "unknown record kind 255"},
+	}
+	for _, c := range testCases {
+		d, err := Open("", &Options{FS: vfs.NewMem()})
+		require.NoError(t, err)
+		_, err = ReplayTrace(bytes.NewReader(c.data), d, TraceReplayOptions{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), c.expected)
+		require.NoError(t, d.Close())
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// Tracer exports the base.Tracer type.
+type Tracer = base.Tracer
+
+// Span exports the base.Span type.
+type Span = base.Span
+
+// ContextWithTracer returns a copy of ctx to which the tracer is attached.
+// The operations performed with the context record spans using the tracer,
+// as children of the span of ctx, if any:
+//
+//   - DB.GetWithContext records a "pebble.Get" span.
+//   - DB.NewIterWithContext records a "pebble.Iterator" span, spanning the
+//     lifetime of the iterator until it is closed.
+//   - DB.ApplyWithContext and Batch.CommitWithContext record a
+//     "pebble.Commit" span, with child spans for the stages of the commit:
+//     "pebble.CommitQueueWait", "pebble.WALWrite", "pebble.MemTableApply" and,
+//     if the commit is synced, "pebble.WALSync".
+//
+// Reads of sstable blocks which are not in the block cache performed by gets
+// and iterators record "pebble.ReadBlock" child spans. Operations performed
+// without a tracer do not incur the cost of tracing.
+func ContextWithTracer(ctx context.Context, t Tracer) context.Context {
+	return base.ContextWithTracer(ctx, t)
+}
+
+// traceCommit records the spans of a commit, whose stages completed at the
+// specified times.
+func traceCommit(
+	ctx context.Context, b *Batch, syncWAL bool, start, acquired, written, applied, end time.Time,
+) {
+	ctx, span := base.StartSpan(ctx, "pebble.Commit", start)
+	span.SetAttribute("pebble.batch_count", int64(b.Count()))
+	span.SetAttribute("pebble.batch_bytes", int64(len(b.Repr())))
+	span.SetAttribute("pebble.seq_num", int64(b.SeqNum()))
+	child := func(name string, start, end time.Time) {
+		_, s := base.StartSpan(ctx, name, start)
+		s.End(end)
+	}
+	child("pebble.CommitQueueWait", start, acquired)
+	child("pebble.WALWrite", acquired, written)
+	child("pebble.MemTableApply", written, applied)
+	if syncWAL {
+		child("pebble.WALSync", applied, end)
+	}
+	span.End(end)
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type testSpan struct {
+	t          *testTracer
+	name       string
+	attrs      map[string]int64
+	start, end time.Time
+	children   []*testSpan
+}
+
+func (s *testSpan) SetAttribute(key string, value int64) {
+	s.attrs[key] = value
+}
+
+func (s *testSpan) End(end time.Time) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	if !s.end.IsZero() {
+		panic("span ended twice")
+	}
+	s.end = end
+}
+
+// format formats the span and its descendants, omitting the ReadBlock spans,
+// whose number depends on the layout of the sstables, but counting them.
+func (s *testSpan) format(buf *strings.Builder, depth int) {
+	fmt.Fprintf(buf, "%s%s", strings.Repeat("  ", depth), s.name)
+	if s.end.IsZero() {
+		buf.WriteString(" (open)")
+	} else if s.end.Before(s.start) {
+		buf.WriteString(" (ends before it starts)")
+	}
+	var keys []string
+	for k := range s.attrs {
+		if k != "pebble.seq_num" && k != "pebble.batch_bytes" && !strings.HasPrefix(k, "pebble.block_") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buf, " %s=%d", k, s.attrs[k])
+	}
+	buf.WriteString("\n")
+	var reads int
+	for _, c := range s.children {
+		if c.name == "pebble.ReadBlock" {
+			reads++
+			continue
+		}
+		c.format(buf, depth+1)
+	}
+	if reads > 0 {
+		fmt.Fprintf(buf, "%s(%d block reads)\n", strings.Repeat("  ", depth+1), reads)
+	}
+}
+
+func (s *testSpan) String() string {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	var buf strings.Builder
+	s.format(&buf, 0)
+	return buf.String()
+}
+
+type testSpanKey struct{}
+
+type testTracer struct {
+	mu    sync.Mutex
+	roots []*testSpan
+}
+
+func (t *testTracer) StartSpan(
+	ctx context.Context, name string, start time.Time,
+) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{t: t, name: name, attrs: make(map[string]int64), start: start}
+	if parent, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		parent.children = append(parent.children, s)
+	} else {
+		t.roots = append(t.roots, s)
+	}
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+// String formats and clears the recorded spans.
+func (t *testTracer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf strings.Builder
+	for _, s := range t.roots {
+		s.format(&buf, 0)
+	}
+	t.roots = nil
+	return buf.String()
+}
+
+func TestTracing(t *testing.T) {
+	// Without a block cache, every read of an sstable block reads the file.
+	cache := NewCache(0)
+	defer cache.Unref()
+	d, err := Open("", &Options{FS: vfs.NewMem(), Cache: cache})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	tracer := &testTracer{}
+	ctx := ContextWithTracer(context.Background(), tracer)
+
+	// Operations without a tracer do not record spans.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), Sync))
+	require.NoError(t, d.Flush())
+	v, closer, err := d.GetWithContext(context.Background(), []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	require.Equal(t, "", tracer.String())
+
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, b.CommitWithContext(ctx, Sync))
+	require.Equal(t, `pebble.Commit pebble.batch_count=2
+  pebble.CommitQueueWait
+  pebble.WALWrite
+  pebble.MemTableApply
+  pebble.WALSync
+`, tracer.String())
+
+	b = d.NewBatch()
+	require.NoError(t, b.Set([]byte("d"), []byte("4"), nil))
+	require.NoError(t, d.ApplyWithContext(ctx, b, NoSync))
+	require.Equal(t, `pebble.Commit pebble.batch_count=1
+  pebble.CommitQueueWait
+  pebble.WALWrite
+  pebble.MemTableApply
+`, tracer.String())
+	require.NoError(t, d.Flush())
+
+	// The reads of sstable blocks are recorded as children of the read's span.
+	v, closer, err = d.GetWithContext(ctx, []byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, "3", string(v))
+	require.NoError(t, closer.Close())
+	require.Regexp(t, `^pebble.Get pebble.value_bytes=1
+  \(\d+ block reads\)
+$`, tracer.String())
+
+	iter := d.NewIterWithContext(ctx, nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.Equal(t, []string{"a", "b", "c", "d"}, keys)
+	require.Regexp(t, `^pebble.Iterator \(open\)
+  \(\d+ block reads\)
+$`, tracer.roots[0].String())
+	require.NoError(t, iter.Close())
+	require.Regexp(t, `^pebble.Iterator
+  \(\d+ block reads\)
+$`, tracer.String())
+}
@@ -0,0 +1,483 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+)
+
+// ErrTransactionConflict is returned by Transaction.Commit if a key read by
+// the transaction was written by another writer after the transaction began.
+var ErrTransactionConflict = errors.New("pebble: transaction conflict")
+
+// Transaction is an optimistic transaction. A transaction reads from a
+// consistent snapshot of the DB taken when it began, overlaid with its own
+// writes, and buffers its writes in an indexed batch. The keys read by the
+// transaction are tracked, and when the transaction commits they are checked
+// for writes with a sequence number at or above the transaction's snapshot. If
+// there are any, the transaction fails with ErrTransactionConflict and none of
+// its writes are applied.
+//
+// Commits of transactions are serialized with respect to each other, so
+// conflicts between transactions are always detected. A write made outside of
+// a transaction conflicts with a transaction only if it is applied before the
+// transaction begins to commit.
+//
+// The span of keys read by an iterator is taken from the LowerBound and
+// UpperBound of its IterOptions, and an iterator which has no bounds is
+// considered to have read the entire key space. The bounds of an iterator
+// created by a transaction must not be widened with Iterator.SetBounds.
+//
+// A Transaction is not safe for concurrent use.
+type Transaction struct {
+	db       *DB
+	batch    *Batch
+	snapshot *Snapshot
+	reads    []txnRead
+}
+
+// txnRead records a read made by a transaction: either of the single key
+// start, or of the span [start,end) where a nil bound is unbounded.
+type txnRead struct {
+	start, end []byte
+	point      bool
+}
+
+// overlaps returns true if the read overlaps the span [start,end]. The end
+// key is inclusive if endInclusive is true. A nil start or end is unbounded.
+func (r *txnRead) overlaps(cmp Compare, start, end []byte, endInclusive bool) bool {
+	lower, upper := r.start, r.end
+	if end != nil && lower != nil {
+		if c := cmp(end, lower); c < 0 || (c == 0 && !endInclusive) {
+			return false
+		}
+	}
+	if r.point {
+		return start == nil || cmp(start, lower) <= 0
+	}
+	return start == nil || upper == nil || cmp(start, upper) < 0
+}
+
+// NewTransaction returns a new optimistic transaction which reads from the
+// current state of the DB.
+func (d *DB) NewTransaction() *Transaction {
+	return &Transaction{
+		db:       d,
+		batch:    d.NewIndexedBatch(),
+		snapshot: d.NewSnapshot(),
+	}
+}
+
+// Get gets the value for the given key, reflecting the writes made by the
+// transaction. It returns ErrNotFound if the key is not found. The key is
+// added to the read set of the transaction.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (t *Transaction) Get(key []byte) ([]byte, io.Closer, error) {
+	t.reads = append(t.reads, txnRead{start: append([]byte(nil), key...), point: true})
+	return t.db.getInternal(context.Background(), key, t.batch, t.snapshot)
+}
+
+// NewIter returns an iterator that is unpositioned (Iterator.Valid() will
+// return false), reflecting the writes made by the transaction. The span
+// [LowerBound,UpperBound) of the IterOptions is added to the read set of the
+// transaction.
+func (t *Transaction) NewIter(o *IterOptions) *Iterator {
+	r := txnRead{}
+	if lower := o.GetLowerBound(); lower != nil {
+		r.start = append([]byte(nil), lower...)
+	}
+	if upper := o.GetUpperBound(); upper != nil {
+		r.end = append([]byte(nil), upper...)
+	}
+	t.reads = append(t.reads, r)
+
+	b := t.batch
+	var rangeKeyIter internalIterator
+	if o != nil && o.KeyTypes != IterKeyTypePointsOnly {
+		rangeKeyIter = b.newRangeKeyIter(o)
+	}
+	return t.db.newIterInternal(b.newInternalIter(o),
+		b.newRangeDelIter(o), rangeKeyIter, t.snapshot, o)
+}
+
+// Set sets the value for the given key within the transaction.
+//
+// It is safe to modify the contents of the arguments after Set returns.
+func (t *Transaction) Set(key, value []byte, opts *WriteOptions) error {
+	return t.batch.Set(key, value, opts)
+}
+
+// Delete deletes the value for the given key within the transaction.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (t *Transaction) Delete(key []byte, opts *WriteOptions) error {
+	return t.batch.Delete(key, opts)
+}
+
+// DeleteRange deletes all of the keys (and values) in the range [start,end)
+// (inclusive on start, exclusive on end) within the transaction.
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns.
+func (t *Transaction) DeleteRange(start, end []byte, opts *WriteOptions) error {
+	return t.batch.DeleteRange(start, end, opts)
+}
+
+// Merge adds an action to the transaction that merges the value at key with
+// the new value.
+//
+// It is safe to modify the contents of the arguments after Merge returns.
+func (t *Transaction) Merge(key, value []byte, opts *WriteOptions) error {
+	return t.batch.Merge(key, value, opts)
+}
+
+// Commit validates the read set of the transaction and, if none of the keys
+// it read have been written since it began, applies its writes to the DB
+// atomically. ErrTransactionConflict is returned if a conflicting write is
+// found. The transaction is closed by Commit, whether or not it succeeds.
+func (t *Transaction) Commit(opts *WriteOptions) error {
+	defer t.Close()
+
+	// A transaction without writes read a consistent snapshot, and can always
+	// be serialized at the point at which it began.
+	if t.batch.Empty() {
+		return nil
+	}
+
+	d := t.db
+	d.txnCommitMu.Lock()
+	defer d.txnCommitMu.Unlock()
+
+	conflict, err := d.txnConflicts(t.reads, t.snapshot.seqNum)
+	if err != nil {
+		return err
+	}
+	if conflict {
+		return ErrTransactionConflict
+	}
+	return d.Apply(t.batch, opts)
+}
+
+// Close discards the transaction, releasing its resources. It is valid to call
+// Close multiple times.
+func (t *Transaction) Close() error {
+	if t.batch == nil {
+		return nil
+	}
+	err := firstError(t.batch.Close(), t.snapshot.Close())
+	t.batch, t.snapshot, t.reads = nil, nil, nil
+	return err
+}
+
+// txnConflicts returns true if any of the reads overlaps a write with a
+// sequence number greater than or equal to seqNum. Writes newer than seqNum
+// are never elided or have their sequence numbers zeroed by compactions while
+// the transaction's snapshot is open, so the newest write to any key read by
+// the transaction is always found by searching the memtables and the sstables
+// containing writes at or above seqNum.
+func (d *DB) txnConflicts(reads []txnRead, seqNum uint64) (bool, error) {
+	if len(reads) == 0 {
+		return false, nil
+	}
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	c := txnConflictChecker{cmp: d.cmp, reads: reads, seqNum: seqNum}
+	for i := range readState.memtables {
+		f := readState.memtables[i]
+		if c.points(f.newIter(nil)) || c.tombstones(f.newRangeDelIter(nil)) ||
+			c.rangeKeys(f.newRangeKeyIter(nil)) {
+			return true, c.err
+		}
+		if c.err != nil {
+			return false, c.err
+		}
+	}
+
+	for level := range readState.current.Levels {
+		for _, f := range readState.current.Levels[level] {
+			if f.LargestSeqNum < seqNum || !c.overlaps(f.Smallest.UserKey, f.Largest.UserKey, true) {
+				continue
+			}
+			iter, rangeDelIter, err := d.newIters(f, nil /* iter options */, nil /* bytes iterated */)
+			if err != nil {
+				return false, err
+			}
+			if c.points(iter) || c.tombstones(rangeDelIter) {
+				return true, c.err
+			}
+			if c.err == nil && f.HasRangeKeys {
+				rangeKeyIter, err := d.tableCache.newRangeKeyIter(f)
+				if err != nil {
+					return false, err
+				}
+				if c.rangeKeys(rangeKeyIter) {
+					return true, c.err
+				}
+			}
+			if c.err != nil {
+				return false, c.err
+			}
+		}
+	}
+	return false, nil
+}
+
+// txnConflictChecker searches internal iterators for writes which conflict
+// with the reads of a transaction. Each method closes the iterator it is
+// passed, which may be nil, and returns true if a conflict was found. The
+// first error encountered is recorded in err.
+type txnConflictChecker struct {
+	cmp    Compare
+	reads  []txnRead
+	seqNum uint64
+	err    error
+}
+
+func (c *txnConflictChecker) overlaps(start, end []byte, endInclusive bool) bool {
+	for i := range c.reads {
+		if c.reads[i].overlaps(c.cmp, start, end, endInclusive) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *txnConflictChecker) close(iter internalIterator) {
+	if err := iter.Close(); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// points searches for point keys written at or above seqNum within the reads.
+func (c *txnConflictChecker) points(iter internalIterator) bool {
+	if iter == nil {
+		return false
+	}
+	defer c.close(iter)
+	for i := range c.reads {
+		r := &c.reads[i]
+		var key *InternalKey
+		if r.start == nil {
+			key, _ = iter.First()
+		} else {
+			key, _ = iter.SeekGE(r.start)
+		}
+		for ; key != nil; key, _ = iter.Next() {
+			if r.point {
+				if c.cmp(key.UserKey, r.start) != 0 {
+					break
+				}
+			} else if r.end != nil && c.cmp(key.UserKey, r.end) >= 0 {
+				break
+			}
+			if key.SeqNum() >= c.seqNum {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tombstones searches for range deletions written at or above seqNum which
+// overlap the reads.
+func (c *txnConflictChecker) tombstones(iter internalIterator) bool {
+	if iter == nil {
+		return false
+	}
+	defer c.close(iter)
+	for key, end := iter.First(); key != nil; key, end = iter.Next() {
+		if key.SeqNum() >= c.seqNum && c.overlaps(key.UserKey, end, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeKeys searches for range keys written at or above seqNum which overlap
+// the reads.
+func (c *txnConflictChecker) rangeKeys(iter internalIterator) bool {
+	if iter == nil {
+		return false
+	}
+	defer c.close(iter)
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		if key.SeqNum() < c.seqNum {
+			continue
+		}
+		k, err := rangekey.Decode(*key, value)
+		if err != nil {
+			if c.err == nil {
+				c.err = err
+			}
+			return false
+		}
+		if c.overlaps(k.Start.UserKey, k.End, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// PessimisticTransaction is a transaction which locks the keys it reads and
+// writes, for workloads with enough contention that optimistic transactions
+// would repeatedly conflict. Reads acquire shared locks and writes acquire
+// exclusive locks, which are held until the transaction commits or is closed.
+// A transaction requesting a lock which conflicts with a lock held by another
+// transaction waits for it to be released, unless waiting would deadlock in
+// which case ErrDeadlock is returned. The transaction should then be closed
+// and retried.
+//
+// Reads reflect the writes made by the transaction over the latest state of
+// the DB. The span of keys read by an iterator is locked using the
+// LowerBound and UpperBound of its IterOptions, and an iterator which has no
+// bounds locks the entire key space. The bounds of an iterator created by a
+// transaction must not be widened with Iterator.SetBounds.
+//
+// Locks only exclude other pessimistic transactions: writes made outside of
+// a pessimistic transaction do not acquire locks.
+//
+// A PessimisticTransaction is not safe for concurrent use.
+type PessimisticTransaction struct {
+	db    *DB
+	batch *Batch
+}
+
+// NewPessimisticTransaction returns a new pessimistic transaction.
+func (d *DB) NewPessimisticTransaction() *PessimisticTransaction {
+	return &PessimisticTransaction{
+		db:    d,
+		batch: d.NewIndexedBatch(),
+	}
+}
+
+// LockKey locks the key in the specified mode, waiting for conflicting locks
+// held by other transactions to be released. Locking a key exclusively before
+// reading it avoids the deadlock which occurs when two transactions read a key
+// and then both attempt to write it.
+func (t *PessimisticTransaction) LockKey(key []byte, mode LockMode) error {
+	return t.db.locks.acquire(&txnLock{
+		owner: t,
+		mode:  mode,
+		start: append([]byte(nil), key...),
+		point: true,
+	})
+}
+
+// LockRange locks the keys in the range [start,end) in the specified mode,
+// waiting for conflicting locks held by other transactions to be released. A
+// nil start or end is unbounded.
+func (t *PessimisticTransaction) LockRange(start, end []byte, mode LockMode) error {
+	l := &txnLock{owner: t, mode: mode}
+	if start != nil {
+		l.start = append([]byte(nil), start...)
+	}
+	if end != nil {
+		l.end = append([]byte(nil), end...)
+	}
+	return t.db.locks.acquire(l)
+}
+
+// Get locks the key in shared mode and gets its value, reflecting the writes
+// made by the transaction. It returns ErrNotFound if the key is not found.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (t *PessimisticTransaction) Get(key []byte) ([]byte, io.Closer, error) {
+	if err := t.LockKey(key, LockShared); err != nil {
+		return nil, nil, err
+	}
+	return t.batch.Get(key)
+}
+
+// NewIter locks the span [LowerBound,UpperBound) of the IterOptions in shared
+// mode and returns an iterator that is unpositioned (Iterator.Valid() will
+// return false), reflecting the writes made by the transaction. If the lock
+// cannot be acquired, the error is returned by the iterator.
+func (t *PessimisticTransaction) NewIter(o *IterOptions) *Iterator {
+	if err := t.LockRange(o.GetLowerBound(), o.GetUpperBound(), LockShared); err != nil {
+		return &Iterator{err: err}
+	}
+	return t.batch.NewIter(o)
+}
+
+// Set locks the key exclusively and sets its value within the transaction.
+//
+// It is safe to modify the contents of the arguments after Set returns.
+func (t *PessimisticTransaction) Set(key, value []byte, opts *WriteOptions) error {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.Set(key, value, opts)
+}
+
+// Delete locks the key exclusively and deletes its value within the
+// transaction.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (t *PessimisticTransaction) Delete(key []byte, opts *WriteOptions) error {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.Delete(key, opts)
+}
+
+// DeleteRange locks the range [start,end) exclusively and deletes all of the
+// keys (and values) in it within the transaction.
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns.
+func (t *PessimisticTransaction) DeleteRange(start, end []byte, opts *WriteOptions) error {
+	if err := t.LockRange(start, end, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.DeleteRange(start, end, opts)
+}
+
+// Merge locks the key exclusively and adds an action to the transaction that
+// merges the value at key with the new value.
+//
+// It is safe to modify the contents of the arguments after Merge returns.
+func (t *PessimisticTransaction) Merge(key, value []byte, opts *WriteOptions) error {
+	if err := t.LockKey(key, LockExclusive); err != nil {
+		return err
+	}
+	return t.batch.Merge(key, value, opts)
+}
+
+// Commit applies the writes of the transaction to the DB atomically and
+// releases its locks. The locks are released only once the writes have been
+// published, so another transaction which acquires one of the locks observes
+// the writes. The transaction is closed by Commit, whether or not it
+// succeeds.
+func (t *PessimisticTransaction) Commit(opts *WriteOptions) error {
+	defer t.Close()
+	if t.batch.Empty() {
+		return nil
+	}
+	return t.db.Apply(t.batch, opts)
+}
+
+// Close discards the transaction, releasing its locks. It is valid to call
+// Close multiple times.
+func (t *PessimisticTransaction) Close() error {
+	if t.batch == nil {
+		return nil
+	}
+	t.db.locks.release(t)
+	err := t.batch.Close()
+	t.batch = nil
+	return err
+}
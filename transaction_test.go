@@ -0,0 +1,310 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransaction(t *testing.T) {
+	var d *DB
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+	txns := map[string]*Transaction{}
+
+	datadriven.RunTest(t, "testdata/transaction", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "reset":
+			for name, txn := range txns {
+				require.NoError(t, txn.Close())
+				delete(txns, name)
+			}
+			if d != nil {
+				require.NoError(t, d.Close())
+			}
+			var err error
+			d, err = Open("", &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true})
+			if err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "batch":
+			b := d.NewBatch()
+			if err := runBatchDefineCmd(td, b); err != nil {
+				return err.Error()
+			}
+			if err := b.Commit(nil); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "flush":
+			if err := d.Flush(); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "compact":
+			if err := d.Compact([]byte("a"), []byte("z"), false); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "begin":
+			txns[td.CmdArgs[0].String()] = d.NewTransaction()
+			return ""
+
+		case "txn":
+			// txn <name>, followed by operations on the transaction, one per line:
+			// set, del, del-range, get and scan.
+			txn := txns[td.CmdArgs[0].String()]
+			var buf bytes.Buffer
+			for _, line := range strings.Split(td.Input, "\n") {
+				parts := strings.Fields(line)
+				if len(parts) == 0 {
+					continue
+				}
+				var err error
+				switch parts[0] {
+				case "set":
+					err = txn.Set([]byte(parts[1]), []byte(parts[2]), nil)
+				case "del":
+					err = txn.Delete([]byte(parts[1]), nil)
+				case "del-range":
+					err = txn.DeleteRange([]byte(parts[1]), []byte(parts[2]), nil)
+				case "get":
+					v, closer, err := txn.Get([]byte(parts[1]))
+					if err != nil {
+						fmt.Fprintf(&buf, "%s: %v\n", parts[1], err)
+						continue
+					}
+					fmt.Fprintf(&buf, "%s: %s\n", parts[1], v)
+					require.NoError(t, closer.Close())
+				case "scan":
+					o := &IterOptions{}
+					if len(parts) == 3 {
+						o.LowerBound, o.UpperBound = []byte(parts[1]), []byte(parts[2])
+					}
+					iter := txn.NewIter(o)
+					fmt.Fprintf(&buf, "scan:")
+					for valid := iter.First(); valid; valid = iter.Next() {
+						fmt.Fprintf(&buf, " %s=%s", iter.Key(), iter.Value())
+					}
+					buf.WriteString("\n")
+					require.NoError(t, iter.Close())
+				default:
+					return fmt.Sprintf("unknown op: %s", parts[0])
+				}
+				if err != nil {
+					return err.Error()
+				}
+			}
+			return buf.String()
+
+		case "commit":
+			name := td.CmdArgs[0].String()
+			txn := txns[name]
+			delete(txns, name)
+			if err := txn.Commit(nil); err != nil {
+				return err.Error()
+			}
+			return "ok"
+
+		case "get":
+			var buf bytes.Buffer
+			for _, key := range strings.Fields(td.Input) {
+				v, closer, err := d.Get([]byte(key))
+				if err != nil {
+					fmt.Fprintf(&buf, "%s: %v\n", key, err)
+					continue
+				}
+				fmt.Fprintf(&buf, "%s: %s\n", key, v)
+				require.NoError(t, closer.Close())
+			}
+			return buf.String()
+
+		default:
+			return fmt.Sprintf("unknown command: %s", td.Cmd)
+		}
+	})
+}
+
+func TestTransactionConcurrentIncrements(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	const workers, increments = 4, 50
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for n := 0; n < increments; {
+				txn := d.NewTransaction()
+				var count int
+				if v, closer, err := txn.Get(key); err == nil {
+					count, err = strconv.Atoi(string(v))
+					if err != nil {
+						panic(err)
+					}
+					_ = closer.Close()
+				} else if err != ErrNotFound {
+					panic(err)
+				}
+				if err := txn.Set(key, []byte(strconv.Itoa(count+1)), nil); err != nil {
+					panic(err)
+				}
+				switch err := txn.Commit(nil); err {
+				case nil:
+					n++
+				case ErrTransactionConflict:
+				default:
+					panic(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, closer, err := d.Get(key)
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(workers*increments), string(v))
+	require.NoError(t, closer.Close())
+}
+
+func TestPessimisticTransaction(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+
+	// A transaction reads its own writes over the latest state of the DB.
+	t1 := d.NewPessimisticTransaction()
+	require.NoError(t, t1.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	iter := t1.NewIter(&IterOptions{LowerBound: []byte("a"), UpperBound: []byte("z")})
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a", "b", "c"}, keys)
+
+	// A second transaction writing a key read by t1 waits for t1 to commit,
+	// and then observes t1's writes.
+	t2 := d.NewPessimisticTransaction()
+	done := make(chan error)
+	go func() {
+		if err := t2.Set([]byte("c"), []byte("30"), nil); err != nil {
+			done <- err
+			return
+		}
+		v, closer, err := t2.Get([]byte("b"))
+		if err != nil {
+			done <- err
+			return
+		}
+		if string(v) != "2" {
+			done <- errors.Errorf("unexpected value: %s", v)
+			return
+		}
+		_ = closer.Close()
+		done <- t2.Commit(nil)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("transaction unexpectedly completed: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, t1.Commit(nil))
+	require.NoError(t, <-done)
+
+	v, closer, err := d.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, "30", string(v))
+	require.NoError(t, closer.Close())
+}
+
+func TestPessimisticTransactionConcurrentIncrements(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Half of the workers lock the counter exclusively before reading it, and
+	// the other half read it with a shared lock before writing it, which
+	// deadlocks when two of them read it concurrently.
+	const workers, increments = 4, 50
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		lockFirst := i%2 == 0
+		go func() {
+			defer wg.Done()
+			increment := func() error {
+				txn := d.NewPessimisticTransaction()
+				defer txn.Close()
+				if lockFirst {
+					if err := txn.LockKey(key, LockExclusive); err != nil {
+						return err
+					}
+				}
+				var count int
+				if v, closer, err := txn.Get(key); err == nil {
+					count, err = strconv.Atoi(string(v))
+					if err != nil {
+						panic(err)
+					}
+					_ = closer.Close()
+				} else if err != ErrNotFound {
+					return err
+				}
+				if err := txn.Set(key, []byte(strconv.Itoa(count+1)), nil); err != nil {
+					return err
+				}
+				return txn.Commit(nil)
+			}
+			for n := 0; n < increments; {
+				switch err := increment(); err {
+				case nil:
+					n++
+				case ErrDeadlock:
+					// Retry.
+				default:
+					panic(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, closer, err := d.Get(key)
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(workers*increments), string(v))
+	require.NoError(t, closer.Close())
+}
@@ -63,6 +63,17 @@ type versionSet struct {
 	// still referenced by an inuse iterator.
 	zombieTables map[FileNum]uint64 // filenum -> size
 
+	// External tables which have been ingested by reference. External tables
+	// reside on Options.Experimental.SharedFS and are never deleted.
+	externalTables map[FileNum]struct{}
+
+	// Virtual tables which have not become obsolete, mapped to the file number
+	// of their backing table (see FileMetadata.BackingFileNum).
+	virtualTables map[FileNum]FileNum
+	// The backing tables of virtual tables, which become obsolete along with
+	// the last table referencing them.
+	backingTables map[FileNum]*backingTable
+
 	// minUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	minUnflushedLogNum FileNum
@@ -78,6 +89,9 @@ type versionSet struct {
 	logSeqNum     uint64 // next seqNum to use for WAL writes
 	visibleSeqNum uint64 // visible seqNum (<= logSeqNum)
 
+	// The format major version of the DB.
+	formatMajorVersion FormatMajorVersion
+
 	// The current manifest file number.
 	manifestFileNum FileNum
 
@@ -100,6 +114,9 @@ func (vs *versionSet) init(dirname string, opts *Options, mu *sync.Mutex) {
 	vs.versions.Init(mu)
 	vs.obsoleteFn = vs.addObsoleteLocked
 	vs.zombieTables = make(map[FileNum]uint64)
+	vs.externalTables = make(map[FileNum]struct{})
+	vs.virtualTables = make(map[FileNum]FileNum)
+	vs.backingTables = make(map[FileNum]*backingTable)
 	vs.nextFileNum = 1
 }
 
@@ -108,6 +125,7 @@ func (vs *versionSet) create(
 	jobID int, dirname string, dir vfs.File, opts *Options, mu *sync.Mutex,
 ) error {
 	vs.init(dirname, opts, mu)
+	vs.formatMajorVersion = opts.FormatMajorVersion
 	newVersion := &version{}
 	vs.append(newVersion)
 	vs.picker = newCompactionPicker(newVersion, vs.opts, nil)
@@ -153,79 +171,196 @@ func (vs *versionSet) create(
 func (vs *versionSet) load(dirname string, opts *Options, mu *sync.Mutex) error {
 	vs.init(dirname, opts, mu)
 
-	// Read the CURRENT file to find the current manifest file.
-	current, err := vs.fs.Open(base.MakeFilename(vs.fs, dirname, fileTypeCurrent, 0))
+	m, err := vs.recoverManifest(dirname)
+	if err != nil {
+		return err
+	}
+	vs.manifestFileNum = m.fileNum
+	vs.formatMajorVersion = FormatMostCompatible
+	if m.formatMajorVersion != 0 {
+		vs.formatMajorVersion = m.formatMajorVersion
+	}
+	if vs.formatMajorVersion > FormatNewest {
+		return errors.Errorf("pebble: database %q has format major version %d, "+
+			"which is newer than the newest supported version %d",
+			dirname, errors.Safe(uint64(vs.formatMajorVersion)), errors.Safe(uint64(FormatNewest)))
+	}
+	if m.minUnflushedLogNum != 0 {
+		vs.minUnflushedLogNum = m.minUnflushedLogNum
+	}
+	if m.nextFileNum != 0 {
+		vs.nextFileNum = m.nextFileNum
+	}
+	if m.logSeqNum != 0 {
+		vs.logSeqNum = m.logSeqNum
+	}
+	// We have already set vs.nextFileNum = 2 at the beginning of the
+	// function and could have only updated it to some other non-zero value,
+	// so it cannot be 0 here.
+	if vs.minUnflushedLogNum == 0 {
+		if vs.nextFileNum >= 2 {
+			// We either have a freshly created DB, or a DB created by RocksDB
+			// that has not had a single flushed SSTable yet. This is because
+			// RocksDB bumps up nextFileNum in this case without bumping up
+			// minUnflushedLogNum, even if WALs with non-zero file numbers are
+			// present in the directory.
+		} else {
+			return errors.Errorf("pebble: malformed manifest file %q for DB %q",
+				errors.Safe(base.MakeFilename(vs.fs, "", fileTypeManifest, m.fileNum)), dirname)
+		}
+	}
+	vs.markFileNumUsed(vs.minUnflushedLogNum)
+
+	newVersion, _, err := m.bve.Apply(nil, vs.cmp, opts.Comparer.FormatKey, opts.Experimental.FlushSplitBytes)
+	if err != nil {
+		return err
+	}
+	newVersion.L0Sublevels.InitCompactingFileInfo()
+	vs.append(newVersion)
+	for _, files := range newVersion.Levels {
+		for _, f := range files {
+			if f.External != "" {
+				vs.externalTables[f.FileNum] = struct{}{}
+			}
+			if f.Virtual() {
+				vs.addVirtualTable(f, nil)
+				// The size of a backing table is not recorded in the manifest,
+				// so it is estimated from the sizes of its virtual tables.
+				vs.backingTables[f.BackingFileNum].size += f.Size
+			}
+		}
+	}
+
+	vs.picker = newCompactionPicker(newVersion, vs.opts, nil)
+
+	for i := range vs.metrics.Levels {
+		l := &vs.metrics.Levels[i]
+		l.NumFiles = int64(len(newVersion.Levels[i]))
+		l.Size = uint64(totalSize(newVersion.Levels[i]))
+	}
+	return nil
+}
+
+// manifestContents holds the state accumulated from the version edits in a
+// MANIFEST. Fields which were not set by any version edit are zero.
+type manifestContents struct {
+	fileNum            FileNum
+	bve                bulkVersionEdit
+	minUnflushedLogNum FileNum
+	nextFileNum        FileNum
+	logSeqNum          uint64
+	formatMajorVersion FormatMajorVersion
+	// edits is the number of version edits read.
+	edits int
+	// stopErr is the error, if any, which stopped reading before the end of
+	// the manifest, and stopOffset is the offset of the record at which
+	// reading stopped.
+	stopOffset int64
+	stopErr    error
+}
+
+// readCurrentFile reads the CURRENT file of the DB in dirname, returning the
+// name and file number of the current manifest file.
+func readCurrentFile(fs vfs.FS, dirname string) (string, FileNum, error) {
+	current, err := fs.Open(base.MakeFilename(fs, dirname, fileTypeCurrent, 0))
 	if err != nil {
-		return errors.Wrapf(err, "pebble: could not open CURRENT file for DB %q", dirname)
+		return "", 0, errors.Wrapf(err, "pebble: could not open CURRENT file for DB %q", dirname)
 	}
 	defer current.Close()
 	stat, err := current.Stat()
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	n := stat.Size()
 	if n == 0 {
-		return errors.Errorf("pebble: CURRENT file for DB %q is empty", dirname)
+		return "", 0, errors.Errorf("pebble: CURRENT file for DB %q is empty", dirname)
 	}
 	if n > 4096 {
-		return errors.Errorf("pebble: CURRENT file for DB %q is too large", dirname)
+		return "", 0, errors.Errorf("pebble: CURRENT file for DB %q is too large", dirname)
 	}
 	b := make([]byte, n)
 	_, err = current.ReadAt(b, 0)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	if b[n-1] != '\n' {
-		return errors.Errorf("pebble: CURRENT file for DB %q is malformed", dirname)
+		return "", 0, errors.Errorf("pebble: CURRENT file for DB %q is malformed", dirname)
 	}
 	b = bytes.TrimSpace(b)
 
-	var ok bool
-	if _, vs.manifestFileNum, ok = base.ParseFilename(vs.fs, string(b)); !ok {
-		return errors.Errorf("pebble: MANIFEST name %q is malformed", errors.Safe(b))
+	_, fileNum, ok := base.ParseFilename(fs, string(b))
+	if !ok {
+		return "", 0, errors.Errorf("pebble: MANIFEST name %q is malformed", errors.Safe(b))
 	}
+	return string(b), fileNum, nil
+}
 
-	// Read the versionEdits in the manifest file.
-	var bve bulkVersionEdit
-	manifest, err := vs.fs.Open(vs.fs.PathJoin(dirname, string(b)))
+// readManifest reads the version edits in the current manifest file of the DB
+// in dirname, as named by the CURRENT file.
+func readManifest(fs vfs.FS, dirname string, cmpName string) (*manifestContents, error) {
+	name, fileNum, err := readCurrentFile(fs, dirname)
 	if err != nil {
-		return errors.Wrapf(err, "pebble: could not open manifest file %q for DB %q",
-			errors.Safe(b), dirname)
+		return nil, err
+	}
+	return readManifestFile(fs, dirname, name, fileNum, cmpName, ManifestRecoveryTolerateTornTail)
+}
+
+// readManifestFile reads the version edits in the named manifest file of the
+// DB in dirname. Reading stops at the first record which cannot be read, or
+// the first version edit which cannot be decoded if permitted by the recovery
+// mode, which is recorded in the returned manifestContents.
+func readManifestFile(
+	fs vfs.FS, dirname, name string, fileNum FileNum, cmpName string, mode ManifestRecoveryMode,
+) (*manifestContents, error) {
+	m := &manifestContents{fileNum: fileNum}
+	manifest, err := fs.Open(fs.PathJoin(dirname, name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "pebble: could not open manifest file %q for DB %q",
+			errors.Safe(name), dirname)
 	}
 	defer manifest.Close()
 	rr := record.NewReader(manifest, 0 /* logNum */)
 	for {
+		offset := rr.Offset()
 		r, err := rr.Next()
-		if err == io.EOF || record.IsInvalidRecord(err) {
+		if err == io.EOF {
+			break
+		}
+		if record.IsInvalidRecord(err) {
+			m.stopOffset, m.stopErr = offset, base.MarkCorruptionError(err)
 			break
 		}
 		if err != nil {
-			return errors.Wrapf(err, "pebble: error when loading manifest file %q",
-				errors.Safe(b))
+			return nil, errors.Wrapf(err, "pebble: error when loading manifest file %q",
+				errors.Safe(name))
 		}
 		var ve versionEdit
 		err = ve.Decode(r)
 		if err != nil {
 			// Break instead of returning an error if the record is corrupted
 			// or invalid.
-			if err == io.EOF || record.IsInvalidRecord(err) {
+			if err == io.EOF || record.IsInvalidRecord(err) || mode == ManifestRecoveryTruncate {
+				m.stopOffset, m.stopErr = offset, base.MarkCorruptionError(err)
 				break
 			}
-			return err
+			return nil, err
 		}
 		if ve.ComparerName != "" {
-			if ve.ComparerName != vs.cmpName {
-				return errors.Errorf("pebble: manifest file %q for DB %q: "+
+			if ve.ComparerName != cmpName {
+				return nil, errors.Errorf("pebble: manifest file %q for DB %q: "+
 					"comparer name from file %q != comparer name from Options %q",
-					errors.Safe(b), dirname, errors.Safe(ve.ComparerName), errors.Safe(vs.cmpName))
+					errors.Safe(name), dirname, errors.Safe(ve.ComparerName), errors.Safe(cmpName))
 			}
 		}
-		bve.Accumulate(&ve)
+		m.bve.Accumulate(&ve)
 		if ve.MinUnflushedLogNum != 0 {
-			vs.minUnflushedLogNum = ve.MinUnflushedLogNum
+			m.minUnflushedLogNum = ve.MinUnflushedLogNum
 		}
 		if ve.NextFileNum != 0 {
-			vs.nextFileNum = ve.NextFileNum
+			m.nextFileNum = ve.NextFileNum
+		}
+		if ve.FormatMajorVersion != 0 {
+			m.formatMajorVersion = FormatMajorVersion(ve.FormatMajorVersion)
 		}
 		if ve.LastSeqNum != 0 {
 			// logSeqNum is the _next_ sequence number that will be assigned,
@@ -235,41 +370,11 @@ func (vs *versionSet) load(dirname string, opts *Options, mu *sync.Mutex) error
 			// (assuming no WALs contain higher sequence numbers than the
 			// manifest's LastSeqNum). Increment LastSeqNum by 1 to get the
 			// next sequence number that will be assigned.
-			vs.logSeqNum = ve.LastSeqNum + 1
-		}
-	}
-	// We have already set vs.nextFileNum = 2 at the beginning of the
-	// function and could have only updated it to some other non-zero value,
-	// so it cannot be 0 here.
-	if vs.minUnflushedLogNum == 0 {
-		if vs.nextFileNum >= 2 {
-			// We either have a freshly created DB, or a DB created by RocksDB
-			// that has not had a single flushed SSTable yet. This is because
-			// RocksDB bumps up nextFileNum in this case without bumping up
-			// minUnflushedLogNum, even if WALs with non-zero file numbers are
-			// present in the directory.
-		} else {
-			return errors.Errorf("pebble: malformed manifest file %q for DB %q",
-				errors.Safe(b), dirname)
+			m.logSeqNum = ve.LastSeqNum + 1
 		}
+		m.edits++
 	}
-	vs.markFileNumUsed(vs.minUnflushedLogNum)
-
-	newVersion, _, err := bve.Apply(nil, vs.cmp, opts.Comparer.FormatKey, opts.Experimental.FlushSplitBytes)
-	if err != nil {
-		return err
-	}
-	newVersion.L0Sublevels.InitCompactingFileInfo()
-	vs.append(newVersion)
-
-	vs.picker = newCompactionPicker(newVersion, vs.opts, nil)
-
-	for i := range vs.metrics.Levels {
-		l := &vs.metrics.Levels[i]
-		l.NumFiles = int64(len(newVersion.Levels[i]))
-		l.Size = uint64(totalSize(newVersion.Levels[i]))
-	}
-	return nil
+	return m, nil
 }
 
 func (vs *versionSet) close() error {
@@ -454,12 +559,23 @@ func (vs *versionSet) logAndApply(
 	for fileNum, size := range zombies {
 		vs.zombieTables[fileNum] = size
 	}
+	for _, nf := range ve.NewFiles {
+		if nf.Meta.External != "" {
+			vs.externalTables[nf.Meta.FileNum] = struct{}{}
+		}
+		if nf.Meta.Virtual() {
+			vs.addVirtualTable(nf.Meta, zombies)
+		}
+	}
 
 	// Install the new version.
 	vs.append(newVersion)
 	if ve.MinUnflushedLogNum != 0 {
 		vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 	}
+	if ve.FormatMajorVersion != 0 {
+		vs.formatMajorVersion = FormatMajorVersion(ve.FormatMajorVersion)
+	}
 	if newManifestFileNum != 0 {
 		if vs.manifestFileNum != 0 {
 			vs.obsoleteManifests = append(vs.obsoleteManifests, vs.manifestFileNum)
@@ -487,8 +603,16 @@ func (vs *versionSet) logAndApply(
 	return nil
 }
 
-func (vs *versionSet) incrementCompactions() {
+func (vs *versionSet) incrementCompactions(c *compaction) {
 	vs.metrics.Compact.Count++
+	switch {
+	case c.deleteOnly:
+		vs.metrics.Compact.DeleteOnlyCount++
+	case c.moved:
+		vs.metrics.Compact.MoveCount++
+	default:
+		vs.metrics.Compact.DefaultCount++
+	}
 }
 
 func (vs *versionSet) incrementFlushes() {
@@ -541,6 +665,11 @@ func (vs *versionSet) createManifest(
 	// VersionEdit that had those fields).
 	snapshot.MinUnflushedLogNum = minUnflushedLogNum
 	snapshot.NextFileNum = nextFileNum
+	// The original format is not recorded, allowing the MANIFEST to be read by
+	// versions of Pebble predating format major versions.
+	if vs.formatMajorVersion > FormatMostCompatible {
+		snapshot.FormatMajorVersion = uint64(vs.formatMajorVersion)
+	}
 
 	w, err1 := manifest.Next()
 	if err1 != nil {
@@ -595,6 +724,9 @@ func (vs *versionSet) currentVersion() *version {
 }
 
 func (vs *versionSet) addLiveFileNums(m map[FileNum]struct{}) {
+	for fileNum := range vs.backingTables {
+		m[fileNum] = struct{}{}
+	}
 	current := vs.currentVersion()
 	for v := vs.versions.Front(); true; v = v.Next() {
 		for _, ff := range v.Levels {
@@ -616,6 +748,64 @@ func (vs *versionSet) addObsoleteLocked(obsolete []FileNum) {
 		if _, ok := vs.zombieTables[fileNum]; !ok {
 			vs.opts.Logger.Fatalf("MANIFEST obsolete table %s not marked as zombie", fileNum)
 		}
+		// A virtual table has no file of its own, so it is obsolete once it is
+		// no longer referenced, but its backing table is obsolete only once
+		// the last table referencing it is.
+		if backingFileNum, ok := vs.virtualTables[fileNum]; ok {
+			delete(vs.virtualTables, fileNum)
+			delete(vs.zombieTables, fileNum)
+			delete(vs.externalTables, fileNum)
+			vs.unrefBackingTableLocked(backingFileNum)
+			continue
+		}
+		if _, ok := vs.backingTables[fileNum]; ok {
+			vs.unrefBackingTableLocked(fileNum)
+			continue
+		}
+		vs.obsoleteTables = append(vs.obsoleteTables, fileNum)
+	}
+}
+
+// backingTable describes the backing table of virtual tables.
+type backingTable struct {
+	// refs counts the virtual tables referencing the backing table which have
+	// not become obsolete, along with the physical table itself until it
+	// becomes obsolete.
+	refs int
+	// size is the size of the backing table.
+	size uint64
+}
+
+// addVirtualTable records the virtual table f, which was added by a version
+// edit. zombies holds the tables removed by the edit, which include the
+// physical table whose data backs f if it was removed by the edit that
+// created f.
+func (vs *versionSet) addVirtualTable(f *fileMetadata, zombies map[FileNum]uint64) {
+	b := vs.backingTables[f.BackingFileNum]
+	if b == nil {
+		b = &backingTable{}
+		if size, ok := zombies[f.BackingFileNum]; ok {
+			b.refs, b.size = 1, size
+		}
+		vs.backingTables[f.BackingFileNum] = b
+	}
+	b.refs++
+	vs.virtualTables[f.FileNum] = f.BackingFileNum
+	if f.External != "" {
+		vs.externalTables[f.BackingFileNum] = struct{}{}
+	}
+}
+
+// unrefBackingTableLocked removes a reference to the backing table with the
+// specified file number, which becomes obsolete once unreferenced.
+func (vs *versionSet) unrefBackingTableLocked(fileNum FileNum) {
+	b := vs.backingTables[fileNum]
+	if b.refs--; b.refs > 0 {
+		return
+	}
+	delete(vs.backingTables, fileNum)
+	if _, ok := vs.zombieTables[fileNum]; !ok {
+		vs.zombieTables[fileNum] = b.size
 	}
-	vs.obsoleteTables = append(vs.obsoleteTables, obsolete...)
+	vs.obsoleteTables = append(vs.obsoleteTables, fileNum)
 }
@@ -5,7 +5,9 @@
 package pebble
 
 import (
+	"fmt"
 	"io"
+	"sort"
 	"testing"
 
 	"github.com/cockroachdb/pebble/internal/base"
@@ -105,3 +107,56 @@ func TestVersionSetSeqNums(t *testing.T) {
 	// logSeqNum is always one greater than the last assigned sequence number.
 	require.Equal(t, d.mu.versions.logSeqNum, lastSeqNum+1)
 }
+
+func TestVersionSetManifestRetention(t *testing.T) {
+	listManifests := func(fs vfs.FS) []FileNum {
+		filenames, err := fs.List("")
+		require.NoError(t, err)
+		var manifests []FileNum
+		for _, filename := range filenames {
+			fileType, fileNum, ok := base.ParseFilename(fs, filename)
+			if ok && fileType == fileTypeManifest {
+				manifests = append(manifests, fileNum)
+			}
+		}
+		sort.Slice(manifests, func(i, j int) bool { return manifests[i] < manifests[j] })
+		return manifests
+	}
+
+	for _, numPrev := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("num-prev-manifest=%d", numPrev), func(t *testing.T) {
+			mem := vfs.NewMem()
+			require.NoError(t, mem.MkdirAll("ext", 0755))
+			opts := &Options{
+				FS:                  mem,
+				MaxManifestFileSize: 1,
+				NumPrevManifest:     numPrev,
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+
+			// Each ingestion rolls the MANIFEST over.
+			for i := 0; i < 5; i++ {
+				key := fmt.Sprintf("%c", 'a'+i)
+				writeAndIngest(t, mem, d, base.MakeInternalKey([]byte(key), 0, InternalKeyKindSet), []byte(key), key)
+			}
+			manifests := listManifests(mem)
+			require.Equal(t, numPrev+1, len(manifests))
+			d.mu.Lock()
+			require.Equal(t, d.mu.versions.manifestFileNum, manifests[len(manifests)-1])
+			d.mu.Unlock()
+			require.NoError(t, d.Close())
+
+			// The retained MANIFESTs are still retained after reopening the DB,
+			// which rolls the MANIFEST over again.
+			d, err = Open("", opts)
+			require.NoError(t, err)
+			require.Equal(t, numPrev+1, len(listManifests(mem)))
+			v, closer, err := d.Get([]byte("e"))
+			require.NoError(t, err)
+			require.Equal(t, "e", string(v))
+			require.NoError(t, closer.Close())
+			require.NoError(t, d.Close())
+		})
+	}
+}
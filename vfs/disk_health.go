@@ -0,0 +1,185 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultTickInterval is the maximum interval between checks of whether an
+// operation on a diskHealthCheckingFile has exceeded its threshold.
+const defaultTickInterval = 2 * time.Second
+
+// diskHealthCheckingFS wraps a FS with one that wraps newly created (and
+// reused) files with a diskHealthCheckingFile.
+type diskHealthCheckingFS struct {
+	FS
+	diskSlowThreshold time.Duration
+	onSlowDisk        func(path string, duration time.Duration)
+}
+
+// WithDiskHealthChecks wraps an FS and ensures that all write-oriented
+// operations on files created through it are timed. If any write or sync
+// takes longer than diskSlowThreshold, onSlowDisk is invoked with the path of
+// the file and the duration the operation has been outstanding for. The
+// callback is invoked at most once per operation, from a background
+// goroutine, while the operation is still in progress.
+func WithDiskHealthChecks(
+	fs FS, diskSlowThreshold time.Duration, onSlowDisk func(path string, duration time.Duration),
+) FS {
+	return diskHealthCheckingFS{
+		FS:                fs,
+		diskSlowThreshold: diskSlowThreshold,
+		onSlowDisk:        onSlowDisk,
+	}
+}
+
+// Unwrap returns the FS wrapped by fs.
+func (fs diskHealthCheckingFS) Unwrap() FS {
+	return fs.FS
+}
+
+// Create implements FS.Create.
+func (fs diskHealthCheckingFS) Create(name string) (File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrap(f, name), nil
+}
+
+// ReuseForWrite implements FS.ReuseForWrite.
+func (fs diskHealthCheckingFS) ReuseForWrite(oldname, newname string) (File, error) {
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrap(f, newname), nil
+}
+
+func (fs diskHealthCheckingFS) wrap(f File, name string) File {
+	checkingFile := newDiskHealthCheckingFile(f, fs.diskSlowThreshold, func(duration time.Duration) {
+		fs.onSlowDisk(name, duration)
+	})
+	checkingFile.startTicker()
+	return checkingFile
+}
+
+// diskHealthCheckingFile wraps a File and times the duration of every Write
+// and Sync. A background goroutine periodically checks whether the
+// outstanding operation, if any, has exceeded diskSlowThreshold.
+type diskHealthCheckingFile struct {
+	File
+
+	onSlowDisk        func(time.Duration)
+	diskSlowThreshold time.Duration
+	tickInterval      time.Duration
+	stopper           chan struct{}
+	stopOnce          sync.Once
+
+	atomic struct {
+		// The start time, in nanoseconds since the Unix epoch, of the
+		// outstanding operation, or zero if no operation is in progress.
+		startNanos int64
+	}
+}
+
+func newDiskHealthCheckingFile(
+	file File, diskSlowThreshold time.Duration, onSlowDisk func(time.Duration),
+) *diskHealthCheckingFile {
+	tickInterval := diskSlowThreshold / 2
+	if tickInterval > defaultTickInterval {
+		tickInterval = defaultTickInterval
+	}
+	return &diskHealthCheckingFile{
+		File:              file,
+		onSlowDisk:        onSlowDisk,
+		diskSlowThreshold: diskSlowThreshold,
+		tickInterval:      tickInterval,
+		stopper:           make(chan struct{}),
+	}
+}
+
+// startTicker starts the background goroutine which checks for slow
+// operations. It is stopped by Close.
+func (d *diskHealthCheckingFile) startTicker() {
+	if d.diskSlowThreshold == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(d.tickInterval)
+		defer ticker.Stop()
+
+		// The start time of the last operation reported as slow, used to
+		// report each slow operation only once.
+		var reportedNanos int64
+		for {
+			select {
+			case <-d.stopper:
+				return
+
+			case <-ticker.C:
+				startNanos := atomic.LoadInt64(&d.atomic.startNanos)
+				if startNanos == 0 || startNanos == reportedNanos {
+					continue
+				}
+				duration := time.Duration(time.Now().UnixNano() - startNanos)
+				if duration > d.diskSlowThreshold {
+					reportedNanos = startNanos
+					d.onSlowDisk(duration)
+				}
+			}
+		}
+	}()
+}
+
+// Fd returns the file descriptor of the wrapped file, or zero if the wrapped
+// file does not expose one. Exposing the descriptor allows a
+// diskHealthCheckingFile to be wrapped by NewSyncingFile without losing the
+// ability to use sync_file_range.
+func (d *diskHealthCheckingFile) Fd() uintptr {
+	type fd interface {
+		Fd() uintptr
+	}
+	if f, ok := d.File.(fd); ok {
+		return f.Fd()
+	}
+	return 0
+}
+
+// Write implements the io.Writer interface.
+func (d *diskHealthCheckingFile) Write(p []byte) (n int, err error) {
+	d.timeDiskOp(func() {
+		n, err = d.File.Write(p)
+	})
+	return n, err
+}
+
+// Sync implements the File interface.
+func (d *diskHealthCheckingFile) Sync() (err error) {
+	d.timeDiskOp(func() {
+		err = d.File.Sync()
+	})
+	return err
+}
+
+// Close implements the io.Closer interface.
+func (d *diskHealthCheckingFile) Close() error {
+	d.stopOnce.Do(func() {
+		close(d.stopper)
+	})
+	return d.File.Close()
+}
+
+// timeDiskOp records the start time of op so that the background goroutine
+// can detect it being slow.
+func (d *diskHealthCheckingFile) timeDiskOp(op func()) {
+	atomic.StoreInt64(&d.atomic.startNanos, time.Now().UnixNano())
+	defer atomic.StoreInt64(&d.atomic.startNanos, 0)
+	op()
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowFS wraps a FS with one whose created files block in Write and Sync
+// until released.
+type slowFS struct {
+	FS
+	release chan struct{}
+}
+
+func (fs slowFS) Create(name string) (File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return slowFile{File: f, release: fs.release}, nil
+}
+
+type slowFile struct {
+	File
+	release chan struct{}
+}
+
+func (f slowFile) Write(p []byte) (int, error) {
+	<-f.release
+	return f.File.Write(p)
+}
+
+func (f slowFile) Sync() error {
+	<-f.release
+	return f.File.Sync()
+}
+
+func TestDiskHealthChecks(t *testing.T) {
+	type slowOp struct {
+		path     string
+		duration time.Duration
+	}
+	const threshold = 10 * time.Millisecond
+	slowOps := make(chan slowOp, 10)
+	release := make(chan struct{})
+	fs := WithDiskHealthChecks(slowFS{FS: NewMem(), release: release}, threshold,
+		func(path string, duration time.Duration) {
+			slowOps <- slowOp{path, duration}
+		})
+
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+
+	for _, op := range []func() error{
+		func() error {
+			_, err := f.Write([]byte("bar"))
+			return err
+		},
+		f.Sync,
+	} {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- op()
+		}()
+
+		// The operation is reported as slow while it is still in progress.
+		s := <-slowOps
+		require.Equal(t, "foo", s.path)
+		require.True(t, s.duration > threshold, s.duration.String())
+
+		// The operation is reported only once.
+		time.Sleep(5 * threshold)
+		require.Equal(t, 0, len(slowOps))
+
+		release <- struct{}{}
+		require.NoError(t, <-errCh)
+	}
+
+	// Operations which aren't slow aren't reported.
+	close(release)
+	_, err = f.Write([]byte("baz"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, 0, len(slowOps))
+}
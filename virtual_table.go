@@ -0,0 +1,210 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rangedel"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// virtualTableBounds returns the user key bounds [lower, upper) of the keys
+// of the backing table exposed by the virtual table f. A nil upper bound is
+// unbounded, as the largest key of f is then the largest key of its backing
+// table.
+func virtualTableBounds(f *fileMetadata) (lower, upper []byte) {
+	// The smallest key of a virtual table is either the smallest key of its
+	// backing table or a search key, neither of which sorts after any key of
+	// the backing table with the same user key.
+	lower = f.Smallest.UserKey
+	// A range deletion sentinel sorts before all of the point keys with the
+	// same user key, so the user key of a sentinel is an exclusive bound.
+	if f.Largest.Trailer == InternalKeyRangeDeleteSentinel {
+		upper = f.Largest.UserKey
+	}
+	return lower, upper
+}
+
+// intersectBounds returns the intersection of the user key bounds [lower,
+// upper) with the bounds [vLower, vUpper) of a virtual table. A nil bound is
+// unbounded.
+func intersectBounds(cmp Compare, lower, upper, vLower, vUpper []byte) ([]byte, []byte) {
+	if lower == nil || (vLower != nil && cmp(vLower, lower) > 0) {
+		lower = vLower
+	}
+	if upper == nil || (vUpper != nil && cmp(vUpper, upper) < 0) {
+		upper = vUpper
+	}
+	return lower, upper
+}
+
+// virtualEstimateBounds returns the inclusive user key range [start, end]
+// whose size within the table f is estimated, narrowed to the bounds of f if
+// it is a virtual table, so that the keys of its backing table outside of its
+// bounds are not included in the estimate.
+func virtualEstimateBounds(cmp Compare, f *fileMetadata, start, end []byte) ([]byte, []byte) {
+	if !f.Virtual() {
+		return start, end
+	}
+	lower, upper := virtualTableBounds(f)
+	if cmp(lower, start) > 0 {
+		start = lower
+	}
+	if upper != nil && cmp(upper, end) < 0 {
+		end = upper
+	}
+	return start, end
+}
+
+// virtualTableIter wraps an iterator over the point keys of the backing table
+// of a virtual table, hiding the keys which lie outside the bounds of the
+// virtual table. The bounds of the wrapped iterator are kept within those of
+// the virtual table, including when they are changed by SetBounds, but the
+// bounds are also enforced by virtualTableIter, as a compaction iterator
+// ignores its bounds.
+type virtualTableIter struct {
+	sstable.Iterator
+	cmp Compare
+	// lower and upper are the bounds of the virtual table. See
+	// virtualTableBounds.
+	lower, upper []byte
+	// compaction is true if the wrapped iterator is a compaction iterator,
+	// which only supports First and Next.
+	compaction bool
+}
+
+// virtualTableIter implements the base.InternalIterator interface.
+var _ base.InternalIterator = (*virtualTableIter)(nil)
+
+func (i *virtualTableIter) checkLower(key *InternalKey, val []byte) (*InternalKey, []byte) {
+	if key != nil && i.cmp(key.UserKey, i.lower) < 0 {
+		return nil, nil
+	}
+	return key, val
+}
+
+func (i *virtualTableIter) checkUpper(key *InternalKey, val []byte) (*InternalKey, []byte) {
+	if key != nil && i.upper != nil && i.cmp(key.UserKey, i.upper) >= 0 {
+		return nil, nil
+	}
+	return key, val
+}
+
+func (i *virtualTableIter) SeekGE(key []byte) (*InternalKey, []byte) {
+	if i.cmp(key, i.lower) < 0 {
+		key = i.lower
+	}
+	return i.checkUpper(i.Iterator.SeekGE(key))
+}
+
+func (i *virtualTableIter) SeekPrefixGE(prefix, key []byte) (*InternalKey, []byte) {
+	if i.cmp(key, i.lower) < 0 {
+		key = i.lower
+	}
+	return i.checkUpper(i.Iterator.SeekPrefixGE(prefix, key))
+}
+
+func (i *virtualTableIter) SeekLT(key []byte) (*InternalKey, []byte) {
+	if i.upper != nil && i.cmp(key, i.upper) > 0 {
+		key = i.upper
+	}
+	return i.checkLower(i.Iterator.SeekLT(key))
+}
+
+func (i *virtualTableIter) First() (*InternalKey, []byte) {
+	if !i.compaction {
+		return i.SeekGE(i.lower)
+	}
+	key, val := i.Iterator.First()
+	for key != nil && i.cmp(key.UserKey, i.lower) < 0 {
+		key, val = i.Iterator.Next()
+	}
+	return i.checkUpper(key, val)
+}
+
+func (i *virtualTableIter) Last() (*InternalKey, []byte) {
+	if i.upper != nil {
+		return i.checkLower(i.Iterator.SeekLT(i.upper))
+	}
+	return i.checkLower(i.Iterator.Last())
+}
+
+func (i *virtualTableIter) Next() (*InternalKey, []byte) {
+	return i.checkUpper(i.Iterator.Next())
+}
+
+func (i *virtualTableIter) Prev() (*InternalKey, []byte) {
+	return i.checkLower(i.Iterator.Prev())
+}
+
+func (i *virtualTableIter) SetBounds(lower, upper []byte) {
+	i.Iterator.SetBounds(intersectBounds(i.cmp, lower, upper, i.lower, i.upper))
+}
+
+// truncateVirtualRangeDels returns an iterator over the range deletions of
+// iter truncated to the bounds of a virtual table (see virtualTableBounds),
+// and closes iter. The returned iterator does not share any storage with
+// iter.
+func truncateVirtualRangeDels(
+	cmp Compare, iter internalIterator, lower, upper []byte,
+) (internalIterator, error) {
+	var tombstones []rangedel.Tombstone
+	for key, val := iter.First(); key != nil; key, val = iter.Next() {
+		start, end := key.UserKey, val
+		if cmp(start, lower) < 0 {
+			start = lower
+		}
+		if upper != nil && cmp(end, upper) > 0 {
+			end = upper
+		}
+		if cmp(start, end) >= 0 {
+			continue
+		}
+		buf := make([]byte, 0, len(start)+len(end))
+		buf = append(append(buf, start...), end...)
+		tombstones = append(tombstones, rangedel.Tombstone{
+			Start: base.InternalKey{UserKey: buf[:len(start):len(start)], Trailer: key.Trailer},
+			End:   buf[len(start):],
+		})
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		return nil, err
+	}
+	if len(tombstones) == 0 {
+		return nil, nil
+	}
+	return rangedel.NewIter(cmp, tombstones), nil
+}
+
+// truncateVirtualRangeKeys returns an iterator over the range keys of iter
+// truncated to the bounds of a virtual table (see virtualTableBounds), and
+// closes iter. The returned iterator does not share any storage with iter.
+func truncateVirtualRangeKeys(
+	cmp Compare, iter internalIterator, lower, upper []byte,
+) (internalIterator, error) {
+	var keys []rangekey.Key
+	for key, val := iter.First(); key != nil; key, val = iter.Next() {
+		k, err := rangekey.Decode(*key, val)
+		if err != nil {
+			_ = iter.Close()
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	// The keys are cloned before iter is closed, as they share the storage of
+	// its block.
+	keys = rangekey.Truncate(cmp, keys, lower, upper)
+	for j := range keys {
+		keys[j] = keys[j].Clone()
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return rangekey.NewIter(cmp, keys), nil
+}
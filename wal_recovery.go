@@ -0,0 +1,98 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+)
+
+// WALRecoveryMode specifies how strictly the WAL is validated when it is
+// replayed during Open.
+type WALRecoveryMode int
+
+const (
+	// WALRecoveryTolerateTornTail stops replaying a WAL at the first record
+	// which cannot be read, treating it as the end of the log. This tolerates
+	// a torn write at the tail of the log due to a crash, but silently ignores
+	// any records following corruption in the middle of the log. This is the
+	// default.
+	WALRecoveryTolerateTornTail WALRecoveryMode = iota
+	// WALRecoveryStrict tolerates a torn tail, but fails Open with a
+	// *WALReplayError if a record which cannot be read is followed anywhere in
+	// the log by a record which can be read, indicating corruption in the
+	// middle of the log.
+	WALRecoveryStrict
+	// WALRecoverySalvage replays every readable record, skipping over any
+	// unreadable regions of the log. Each skipped region is logged. Note that
+	// salvaging may result in a DB which does not reflect any consistent
+	// point in time as the writes contained in the skipped records are lost.
+	WALRecoverySalvage
+)
+
+// String implements fmt.Stringer.
+func (m WALRecoveryMode) String() string {
+	switch m {
+	case WALRecoveryTolerateTornTail:
+		return "tolerate-torn-tail"
+	case WALRecoveryStrict:
+		return "strict"
+	case WALRecoverySalvage:
+		return "salvage"
+	}
+	return fmt.Sprintf("unknown(%d)", int(m))
+}
+
+func parseWALRecoveryMode(s string) (WALRecoveryMode, error) {
+	for m := WALRecoveryTolerateTornTail; m <= WALRecoverySalvage; m++ {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return 0, errors.Errorf("pebble: unknown WAL recovery mode: %q", errors.Safe(s))
+}
+
+// WALReplayError is returned by Open when a WAL cannot be replayed. It
+// describes where in the log replay stopped.
+type WALReplayError struct {
+	// FileNum is the file number of the WAL.
+	FileNum FileNum
+	// Path is the path of the WAL.
+	Path string
+	// Offset is the byte offset within the WAL of the record at which replay
+	// stopped.
+	Offset int64
+	// Records is the number of records which were successfully replayed
+	// before replay stopped.
+	Records int
+	// ReadableOffset is the byte offset within the WAL of the first readable
+	// record following the record at which replay stopped, if the error
+	// reports corruption in the middle of the log (see WALRecoveryStrict).
+	// Otherwise, it is zero.
+	ReadableOffset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *WALReplayError) Error() string {
+	if e.ReadableOffset != 0 {
+		return fmt.Sprintf("pebble: error replaying WAL %s (num %s) at offset %d after %d records, "+
+			"followed by a readable record at offset %d: %v",
+			e.Path, e.FileNum, e.Offset, e.Records, e.ReadableOffset, e.Err)
+	}
+	return fmt.Sprintf("pebble: error replaying WAL %s (num %s) at offset %d after %d records: %v",
+		e.Path, e.FileNum, e.Offset, e.Records, e.Err)
+}
+
+// Cause implements the causer interface used by github.com/cockroachdb/errors.
+func (e *WALReplayError) Cause() error {
+	return e.Err
+}
+
+// Unwrap implements the Go 1.13 error wrapping interface.
+func (e *WALReplayError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "time"
+
+// The reasons for which writes are stopped or slowed down, reported by
+// WriteStallBeginInfo.Reason and Metrics.WriteStall.Reason.
+const (
+	writeStallMemTableLimit       = "memtable count limit reached"
+	writeStallL0Limit             = "L0 file count limit exceeded"
+	writeStallCompactionDebtLimit = "compaction debt limit exceeded"
+	writeSlowdownL0               = "L0 file count slowdown threshold exceeded"
+	writeSlowdownCompactionDebt   = "compaction debt slowdown threshold exceeded"
+)
+
+// l0ReadAmpLocked returns the L0 read-amplification which is compared against
+// the L0 write stall thresholds. d.mu must be held.
+func (d *DB) l0ReadAmpLocked() int {
+	current := d.mu.versions.currentVersion()
+	if d.opts.Experimental.L0SublevelCompactions {
+		return current.L0Sublevels.ReadAmplification()
+	}
+	return len(current.Levels[0])
+}
+
+// compactionDebtLocked returns the estimated compaction debt of the current
+// version, or 0 if no compaction debt thresholds are configured. d.mu must be
+// held.
+func (d *DB) compactionDebtLocked() uint64 {
+	if d.opts.CompactionDebtSlowdownThreshold == 0 && d.opts.CompactionDebtStopThreshold == 0 {
+		return 0
+	}
+	if current := d.mu.versions.currentVersion(); d.mu.writeStall.debtVersion != current {
+		d.mu.writeStall.debtVersion = current
+		d.mu.writeStall.debt = d.mu.versions.picker.estimatedCompactionDebt(0)
+	}
+	return d.mu.writeStall.debt
+}
+
+// writeStallInfoLocked returns the info for a write stall for the specified
+// reason. d.mu must be held.
+func (d *DB) writeStallInfoLocked(reason string, slowdown bool) WriteStallBeginInfo {
+	return WriteStallBeginInfo{
+		Reason:         reason,
+		Slowdown:       slowdown,
+		L0ReadAmp:      d.l0ReadAmpLocked(),
+		MemTableCount:  len(d.mu.mem.queue),
+		CompactionDebt: d.compactionDebtLocked(),
+	}
+}
+
+// beginWriteStallLocked records that writes are stopped or slowed down for
+// the specified reason, ending the current stall if it has a different
+// reason. d.mu must be held.
+func (d *DB) beginWriteStallLocked(reason string, slowdown bool) {
+	s := &d.mu.writeStall
+	if s.active {
+		if s.info.Reason == reason && s.info.Slowdown == slowdown {
+			return
+		}
+		d.endWriteStallLocked()
+	}
+	s.active = true
+	s.info = d.writeStallInfoLocked(reason, slowdown)
+	s.start = d.timeNow()
+
+	m := &d.mu.versions.metrics.WriteStall
+	if !slowdown {
+		m.Count++
+		switch reason {
+		case writeStallL0Limit:
+			m.L0Count++
+		case writeStallMemTableLimit:
+			m.MemTableCount++
+		case writeStallCompactionDebtLimit:
+			m.CompactionDebtCount++
+		}
+	}
+	d.opts.EventListener.WriteStallBegin(s.info)
+}
+
+// endWriteStallLocked records that writes are no longer stopped or slowed
+// down. d.mu must be held.
+func (d *DB) endWriteStallLocked() {
+	s := &d.mu.writeStall
+	if !s.active {
+		return
+	}
+	s.active = false
+	if !s.info.Slowdown {
+		d.mu.versions.metrics.WriteStall.Duration += d.timeNow().Sub(s.start)
+	}
+	d.opts.EventListener.WriteStallEnd()
+}
+
+// endWriteStopLocked ends the current stall if writes are stopped rather than
+// slowed down. A slowdown persists across writes until a write finds that
+// writes no longer need to be slowed down. d.mu must be held.
+func (d *DB) endWriteStopLocked() {
+	if d.mu.writeStall.active && !d.mu.writeStall.info.Slowdown {
+		d.endWriteStallLocked()
+	}
+}
+
+// writeStallMetricsLocked populates the current write stall state of m. d.mu
+// must be held.
+func (d *DB) writeStallMetricsLocked(m *Metrics) {
+	s := &d.mu.writeStall
+	if !s.active {
+		return
+	}
+	m.WriteStall.Reason = s.info.Reason
+	m.WriteStall.Slowdown = s.info.Slowdown
+	if !s.info.Slowdown {
+		m.WriteStall.Duration += d.timeNow().Sub(s.start)
+	}
+}
+
+// writeDelayLocked returns the delay to apply to a write given the current
+// L0 read-amplification and compaction debt, along with the reason for the
+// delay. The delay grows linearly from zero at a slowdown threshold to
+// Options.WriteSlowdownMaxDelay at the corresponding stop threshold. d.mu
+// must be held.
+func (d *DB) writeDelayLocked() (time.Duration, string) {
+	var severity float64
+	var reason string
+
+	o := d.opts
+	if o.L0SlowdownWritesThreshold < o.L0StopWritesThreshold {
+		if readAmp := d.l0ReadAmpLocked(); readAmp >= o.L0SlowdownWritesThreshold {
+			// The severity is 1/n at the slowdown threshold, where n is the
+			// number of steps between the slowdown and stop thresholds, and is
+			// capped at 1 until the stop threshold is checked when the memtable
+			// is rotated.
+			steps := o.L0StopWritesThreshold - o.L0SlowdownWritesThreshold + 1
+			severity = float64(readAmp-o.L0SlowdownWritesThreshold+1) / float64(steps)
+			reason = writeSlowdownL0
+		}
+	}
+	if o.CompactionDebtSlowdownThreshold > 0 {
+		if debt := d.compactionDebtLocked(); debt >= o.CompactionDebtSlowdownThreshold {
+			s := 1.0
+			if o.CompactionDebtStopThreshold > o.CompactionDebtSlowdownThreshold {
+				s = float64(debt-o.CompactionDebtSlowdownThreshold) /
+					float64(o.CompactionDebtStopThreshold-o.CompactionDebtSlowdownThreshold)
+			}
+			if s > severity {
+				severity = s
+				reason = writeSlowdownCompactionDebt
+			}
+		}
+	}
+	if reason == "" {
+		return 0, ""
+	}
+	if severity > 1 {
+		severity = 1
+	}
+	return time.Duration(severity * float64(o.WriteSlowdownMaxDelay)), reason
+}
+
+// maybeDelayWriteLocked delays the caller if writes are being slowed down,
+// beginning or ending a slowdown as necessary. DB.mu is released while the
+// caller is delayed, while commitPipeline.mu remains held so that concurrent
+// writes are delayed as well. d.mu must be held.
+func (d *DB) maybeDelayWriteLocked() {
+	delay, reason := d.writeDelayLocked()
+	if reason == "" {
+		d.endWriteStallLocked()
+		return
+	}
+	d.beginWriteStallLocked(reason, true /* slowdown */)
+	if delay <= 0 {
+		return
+	}
+	d.mu.Unlock()
+	time.Sleep(delay)
+	d.mu.Lock()
+
+	m := &d.mu.versions.metrics.WriteStall
+	m.SlowdownCount++
+	m.SlowdownDelay += delay
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSlowdown(t *testing.T) {
+	var buf syncedBuffer
+	d, err := Open("", &Options{
+		EventListener: EventListener{
+			WriteStallBegin: func(info WriteStallBeginInfo) {
+				fmt.Fprintln(&buf, info.String())
+			},
+			WriteStallEnd: func() {
+				fmt.Fprintln(&buf, "write stall ending")
+			},
+		},
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		L0CompactionThreshold:       1,
+		L0SlowdownWritesThreshold:   1,
+		L0StopWritesThreshold:       4,
+		WriteSlowdownMaxDelay:       4 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Writes are not delayed while L0 is empty.
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, "", buf.String())
+
+	// Once L0 reaches the slowdown threshold, each write is delayed by a
+	// fraction of the maximum delay.
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Set([]byte("c"), nil, nil))
+	require.Equal(t, "write slowdown beginning: L0 file count slowdown threshold exceeded "+
+		"(L0 read-amp 1, 1 memtables, compaction debt 0 B)\n", buf.String())
+	m := d.Metrics()
+	require.Equal(t, int64(2), m.WriteStall.SlowdownCount)
+	require.Equal(t, 2*time.Millisecond, m.WriteStall.SlowdownDelay)
+	require.Equal(t, writeSlowdownL0, m.WriteStall.Reason)
+	require.True(t, m.WriteStall.Slowdown)
+	require.Equal(t, int64(0), m.WriteStall.Count)
+
+	// The slowdown ends with the first write after L0 has been compacted.
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+	require.NoError(t, d.Set([]byte("d"), nil, nil))
+	require.Contains(t, buf.String(), "write stall ending")
+	m = d.Metrics()
+	require.Equal(t, int64(2), m.WriteStall.SlowdownCount)
+	require.Equal(t, "", m.WriteStall.Reason)
+	require.False(t, m.WriteStall.Slowdown)
+}